@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"time"
+
 	"github.com/hyperjumptech/hansip/internal/server"
 )
 
@@ -15,5 +18,26 @@ func main() {
 |  |  ||  |  ||  |  |\    | |  | |  |   
 |__|__||__|__||__|__| \___||____||__|   
 Access Authentication & Authorization (AAA) server.`)
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		if err := server.ResolveConfigSecrets(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if !server.RunPreflight(os.Stdout, server.PreflightChecks()) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		if err := server.ResolveConfigSecrets(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := server.RotateKey(os.Stdout, time.Now()); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	server.Start()
 }
@@ -1,22 +1,49 @@
 module github.com/hyperjumptech/hansip
 
-go 1.16
+go 1.21
 
 require (
 	github.com/SermoDigital/jose v0.0.0-20180104203859-803625baeddc
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/go-webauthn/webauthn v0.9.4
 	github.com/gorilla/mux v1.8.0
 	github.com/hyperjumptech/jiffy v1.0.0
-	github.com/mattn/go-sqlite3 v1.14.8 // indirect
+	github.com/mattn/go-sqlite3 v1.14.8
 	github.com/rs/cors v1.7.0
-	github.com/sendgrid/rest v2.6.1+incompatible // indirect
+	github.com/sendgrid/rest v2.6.1+incompatible
 	github.com/sendgrid/sendgrid-go v3.6.4+incompatible
 	github.com/sirupsen/logrus v1.7.0
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/viper v1.7.1
-	github.com/stretchr/testify v1.3.0
-	golang.org/x/crypto v0.0.0-20200930160638-afb6bcd081ae
-	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f // indirect
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/crypto v0.16.0
+)
+
+require (
+	github.com/antlr/antlr4 v0.0.0-20200124162019-2d7f727a00b7 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/fxamacker/cbor/v2 v2.5.0 // indirect
+	github.com/go-webauthn/x v0.1.5 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+	github.com/google/go-tpm v0.9.0 // indirect
+	github.com/google/uuid v1.4.0 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/magiconair/properties v1.8.1 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.3.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/subosito/gotenv v1.2.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	gopkg.in/ini.v1 v1.51.0 // indirect
+	gopkg.in/yaml.v2 v2.2.4 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 exclude github.com/SermoDigital/jose v0.9.1
@@ -20,14 +20,42 @@ func initialize() {
 	defCfg = make(map[string]string)
 
 	defCfg["api.path.prefix"] = "/api/v1"
+	defCfg["api.query.maxpagesize"] = "1000"
+	defCfg["api.query.maxfilters"] = "10"
+	defCfg["api.query.maxsortfields"] = "1"
+	defCfg["api.query.maxoffset"] = "100000"
+	defCfg["api.query.cursor.enabled"] = "true"
+	defCfg["api.query.cursor.key"] = ""
+	defCfg["api.bulk.maxusers"] = "1000"
+	defCfg["api.delete.confirm.enable"] = "true"
+	defCfg["api.delete.confirm.window"] = "2 minutes"
+
+	defCfg["audit.retention"] = ""
+	defCfg["audit.retention.interval"] = "1 hour"
+	defCfg["audit.retention.archive"] = ""
+	defCfg["audit.authorization.denied"] = "false"
 
 	defCfg["server.host"] = "localhost"
 	defCfg["server.port"] = "3000"
+	defCfg["server.tls.cert"] = ""
+	defCfg["server.tls.key"] = ""
+	defCfg["server.tls.clientauth"] = "none" // valid values are none, optional, require
+	defCfg["server.tls.clientca"] = ""
+	defCfg["server.tls.clientidentities"] = ""
+	defCfg["server.tls.minversion"] = "1.2" // valid values are 1.0, 1.1, 1.2, 1.3
+	defCfg["server.tls.ciphers"] = ""
 	defCfg["server.log.level"] = "warn" // valid values are trace, debug, info, warn, error, fatal
 	defCfg["server.timeout.write"] = "15 seconds"
 	defCfg["server.timeout.read"] = "15 seconds"
 	defCfg["server.timeout.idle"] = "60 seconds"
 	defCfg["server.timeout.graceshut"] = "15 seconds"
+	defCfg["server.timeout.shutdownhook"] = "15 seconds"
+	defCfg["server.shutdown.draindelay"] = "0 seconds"
+	defCfg["server.timeout.routes"] = ""
+	defCfg["server.health.checkmailer"] = "false"
+	defCfg["server.metrics.enable"] = "false"
+	defCfg["server.preflight.enable"] = "false"
+	defCfg["server.banner.enable"] = "true"
 	defCfg["server.http.cors.enable"] = "true"
 	defCfg["server.http.cors.allow.origins"] = "*"
 	defCfg["server.http.cors.allow.credential"] = "true"
@@ -36,13 +64,61 @@ func initialize() {
 	defCfg["server.http.cors.exposed.headers"] = "*"
 	defCfg["server.http.cors.optionpassthrough"] = "true"
 	defCfg["server.http.cors.maxage"] = "300"
+	defCfg["server.http.cors.maxage.groups"] = ""
+	defCfg["server.http.gzip.exclude"] = ""
+	defCfg["server.http.securityheaders"] = "false"
+	defCfg["server.http.securityheaders.contenttypeoptions"] = "nosniff"
+	defCfg["server.http.securityheaders.frameoptions"] = "DENY"
+	defCfg["server.http.securityheaders.referrerpolicy"] = "strict-origin-when-cross-origin"
+	defCfg["server.http.securityheaders.hsts"] = "max-age=31536000; includeSubDomains"
+	defCfg["server.http.securityheaders.csp"] = "default-src 'self'; frame-ancestors 'none'"
+	defCfg["server.http.hsts.maxage"] = ""
+	defCfg["server.http.hsts.includesubdomains"] = "true"
+	defCfg["server.http.hsts.preload"] = "false"
+	defCfg["server.http.idempotency.enable"] = "true"
+	defCfg["server.http.idempotency.ttl"] = "24 hours"
+	defCfg["server.http.ratelimit.routes"] = ""
+	defCfg["server.http.ratelimit.user.routes"] = ""
+	defCfg["server.http.ratelimit.headers"] = "true"
+	defCfg["server.http.maxheaderbytes"] = "1048576"
+	defCfg["server.http.maxinflight"] = "0"
+	defCfg["server.http.maxinflight.retryafter"] = "1 second"
+	defCfg["server.http.maxinflight.exempt"] = "/health,/ready,/metrics"
+	defCfg["server.http.requestid.header"] = "X-Request-ID"
+	defCfg["server.http.requestid.inherit"] = "true"
+	defCfg["server.http.accesslog.level"] = "trace"
+	defCfg["server.http.accesslog.quiet"] = "/health,/ready,/metrics"
+	defCfg["server.http.accesslog.quiet.level"] = "trace"
+	defCfg["server.http.xml.enable"] = "false"
 
 	defCfg["token.issuer"] = "aaa.domain.com"
+	defCfg["token.issuer.accept"] = ""
+	defCfg["token.format"] = "JWT"
+	defCfg["token.opaque.purge.interval"] = "1 hour"
+	defCfg["token.minimize"] = "none"
+	defCfg["token.encrypt"] = "false"
+	defCfg["token.encrypt.key"] = ""
 	defCfg["token.access.duration"] = "5 minutes"
 	defCfg["token.refresh.duration"] = "1 year"
+	defCfg["token.refresh.remember.duration"] = "1 year"
+	defCfg["token.refresh.session.duration"] = "1 day"
+	defCfg["token.sliding.enable"] = "false"
+	defCfg["token.sliding.window"] = "1 minute"
+	defCfg["token.notbefore.offset"] = "0 seconds"
+	defCfg["token.notbefore.maxdelay"] = "1 day"
+	defCfg["token.clockskew.leeway"] = "0 seconds"
+	defCfg["token.impersonate.duration"] = "15 minutes"
+	defCfg["token.impersonate.restricted"] = "true"
+	defCfg["token.onetime.replay.check"] = "true"
+	defCfg["token.permissions"] = ""
 
 	defCfg["token.crypt.key"] = "th15mustb3CH@ngedINprodUCT10N"
 	defCfg["token.crypt.method"] = "HS512"
+	defCfg["token.crypt.keyset"] = ""
+	defCfg["token.crypt.keyset.reload"] = "1 minute"
+	defCfg["token.crypt.rotation.overlap"] = ""
+
+	defCfg["export.include.passphrase"] = "false"
 
 	defCfg["db.type"] = "MYSQL" // MYSQL, SQLITE
 	defCfg["db.mysql.host"] = "localhost"
@@ -50,29 +126,126 @@ func initialize() {
 	defCfg["db.mysql.user"] = "devuser"
 	defCfg["db.mysql.password"] = "devpassword"
 	defCfg["db.mysql.database"] = "devdb"
+	defCfg["db.mysql.params"] = "parseTime=true"
+	defCfg["db.mysql.driver"] = "mysql"
+	defCfg["db.mysql.dsn.format"] = "MYSQL" // MYSQL, URL
+	defCfg["db.mysql.dsn"] = ""
+	defCfg["db.sqlite.dsn"] = "file::memory:?cache=shared"
 
 	defCfg["db.pool.maxidle"] = "3"
 	defCfg["db.pool.maxopen"] = "10"
+	defCfg["db.connect.retries"] = "5"
+	defCfg["db.connect.retry.interval"] = "1 second"
+	defCfg["db.retry.deadlock.max"] = "3"
+	defCfg["db.slowquery.threshold"] = "0 seconds"
+	defCfg["db.encrypt.fields"] = ""
+	defCfg["db.encrypt.key"] = ""
+	defCfg["db.id.strategy"] = "RANDOM" // RANDOM, UUID
+	defCfg["db.tls.mode"] = "disable"   // disable, require, verify-ca, verify-full
+	defCfg["db.tls.ca"] = ""
+	defCfg["db.tls.cert"] = ""
+	defCfg["db.tls.key"] = ""
+	defCfg["db.tls.skipverify"] = "false"
+
+	defCfg["cache.enable"] = "false"
+	defCfg["cache.capacity"] = "10000"
+	defCfg["cache.role.ttl"] = "1 minute"
+	defCfg["cache.tenant.ttl"] = "5 minutes"
+	defCfg["revocation.cache.enable"] = "false"
+	defCfg["revocation.cache.capacity"] = "10000"
+	defCfg["revocation.cache.ttl"] = "5 seconds"
+
+	defCfg["secret.vault.address"] = ""
+	defCfg["secret.vault.token"] = ""
+	defCfg["secret.vault.kv.version"] = "2"
+	defCfg["secret.vault.timeout"] = "10 seconds"
+	defCfg["secret.refresh.interval"] = ""
 
 	defCfg["hansip.domain"] = "hansip"
 	defCfg["hansip.admin"] = "admin"
+	defCfg["seed.roles"] = ""
+	defCfg["seed.roles.file"] = ""
+	defCfg["tenant.region.allowed"] = ""
+	defCfg["tenant.provision.roles"] = ""
+	defCfg["tenant.suspended.message"] = "Your tenant is suspended. Please contact your administrator"
 
 	defCfg["security.passphrase.minchars"] = "8"
 	defCfg["security.passphrase.minwords"] = "3"
 	defCfg["security.passphrase.mincharsinword"] = "3"
 
-	defCfg["mailer.type"] = "SENDGRID" // DUMMY, SENDMAIL, SENDGRID
+	defCfg["auth.password.history"] = "0"
+	defCfg["auth.password.minage"] = ""
+	defCfg["auth.password.breachcheck"] = "false"
+	defCfg["auth.password.breachcheck.url"] = "https://api.pwnedpasswords.com/range"
+	defCfg["auth.password.breachcheck.timeout"] = "5 seconds"
+	defCfg["auth.password.check.enable"] = "false"
+	defCfg["auth.password.check.ratelimit"] = "10/1 minute"
+	defCfg["auth.email.change.ttl"] = "1 day"
+	defCfg["auth.email.mxcheck"] = "false"
+	defCfg["auth.email.mxcheck.timeout"] = "2 seconds"
+	defCfg["auth.email.denylist"] = ""
+	defCfg["auth.username.pattern"] = ""
+	defCfg["auth.email.allowpattern"] = ""
+	defCfg["auth.email.caseinsensitive"] = "false"
+	defCfg["auth.email.stripplus"] = "false"
+	defCfg["auth.throttle.base"] = "0 seconds"
+	defCfg["auth.throttle.max"] = "30 seconds"
+	defCfg["auth.2fa.requiredroles"] = ""
+	defCfg["auth.2fa.enrollment.duration"] = "15 minutes"
+	defCfg["auth.tenantadmin.scoped"] = "true"
+	defCfg["auth.permissions.cache.ttl"] = "30 seconds"
+	defCfg["auth.permissions.cache.capacity"] = "10000"
+	defCfg["auth.webauthn.enable"] = "false"
+	defCfg["auth.webauthn.rpid"] = "localhost"
+	defCfg["auth.webauthn.rpname"] = "Hansip"
+	defCfg["auth.webauthn.origins"] = "http://localhost:3000"
+	defCfg["auth.webauthn.timeout"] = "5 minutes"
+
+	defCfg["mailer.type"] = "SENDGRID"  // DUMMY, SENDMAIL, SENDGRID
+	defCfg["mailer.failmode"] = "fatal" // fatal, degrade
 	defCfg["mailer.from"] = "hansip@aaa.com"
 	defCfg["mailer.from.name"] = "hansip@aaa.com"
+	defCfg["mailer.replyto"] = ""
+	defCfg["mailer.subject.prefix"] = ""
+	defCfg["mailer.locales"] = ""
 	defCfg["mailer.sendmail.host"] = "localhost"
 	defCfg["mailer.sendmail.port"] = "25"
 	defCfg["mailer.sendmail.user"] = "sendmail"
 	defCfg["mailer.sendmail.password"] = "password"
-	defCfg["mailer.templates.emailveri.subject"] = "Please verify your new Hansip account's email"
-	defCfg["mailer.templates.emailveri.body"] = "<html><body>Dear New Hansip User<br><br>Your new account is ready!<br>please click this <a href=\"http://172.31.219.130:3001/activate?email={{.Email}}&code={{.ActivationCode}}\">link to activate</a> your account.<br><br>Cordially,<br>HANSIP team</body></html>"
+	defCfg["mailer.sendmail.keepalive"] = "false"
+	defCfg["mailer.templates.emailveri.subject"] = "Please verify your new {{.Branding.ProductName}} account's email"
+	defCfg["mailer.templates.emailveri.body"] = "<html><body>Dear New {{.Branding.ProductName}} User<br><br>Your new account is ready!<br>please click this <a href=\"http://172.31.219.130:3001/activate?email={{.Email}}&code={{.ActivationCode}}\">link to activate</a> your account.<br><br>Cordially,<br>{{.Branding.ProductName}} team</body></html>"
 	defCfg["mailer.templates.passrecover.subject"] = "Passphrase recovery instruction"
-	defCfg["mailer.templates.passrecover.body"] = "<html><body>Dear Hansip User<br><br>To recover your passphrase<br>please click this <a href=\"http://172.31.219.130:3001/recover?email={{.Email}}&code={{.RecoveryCode}}\">link to change your passphrase</a>.<br><br>Cordially,<br>HANSIP team</body></html>"
+	defCfg["mailer.templates.passrecover.body"] = "<html><body>Dear {{.Branding.ProductName}} User<br><br>To recover your passphrase<br>please click this <a href=\"http://172.31.219.130:3001/recover?email={{.Email}}&code={{.RecoveryCode}}\">link to change your passphrase</a>.<br><br>Cordially,<br>{{.Branding.ProductName}} team</body></html>"
+	defCfg["mailer.welcome.enable"] = "false"
+	defCfg["mailer.verification.resend.enable"] = "true"
+	defCfg["mailer.ratelimit.perhour"] = "0"
+	defCfg["mailer.workers"] = "1"
+	defCfg["mailer.queue.size"] = "100"
+	defCfg["mailer.templates.welcome.subject"] = "Welcome to {{.Branding.ProductName}}"
+	defCfg["mailer.templates.welcome.body"] = "<html><body>Dear {{.Email}}<br><br>Your {{.Branding.ProductName}} account is now active. Welcome aboard!<br><br>Cordially,<br>{{.Branding.ProductName}} team</body></html>"
+	defCfg["mailer.templates.emailchange.subject"] = "Please confirm your new {{.Branding.ProductName}} account's email"
+	defCfg["mailer.templates.emailchange.body"] = "<html><body>Dear {{.Branding.ProductName}} User<br><br>You asked to change your account's email to {{.NewEmail}}<br>please click this <a href=\"http://172.31.219.130:3001/confirm-email?token={{.Token}}\">link to confirm</a> your new email.<br><br>Cordially,<br>{{.Branding.ProductName}} team</body></html>"
+	defCfg["mailer.templates.emailchangenotice.subject"] = "Your {{.Branding.ProductName}} account's email is being changed"
+	defCfg["mailer.templates.emailchangenotice.body"] = "<html><body>Dear {{.Email}}<br><br>A change of your account's email to {{.NewEmail}} was requested. The change takes effect once the new email is confirmed.<br>If you did not ask for this, please contact us immediately.<br><br>Cordially,<br>{{.Branding.ProductName}} team</body></html>"
+	defCfg["mailer.templates.announcement.subject"] = "{{.Data.subject}}"
+	defCfg["mailer.templates.announcement.body"] = "<html><body>Dear {{.Email}}<br><br>{{.Data.message}}<br><br>Cordially,<br>{{.Branding.ProductName}} team</body></html>"
+	defCfg["mailer.group.templates"] = "ANNOUNCEMENT"
+	defCfg["mailer.group.job.ttl"] = "24 hours"
 	defCfg["mailer.sendgrid.token"] = "SENDGRIDTOKEN"
+	defCfg["mailer.http.timeout"] = "30 seconds"
+	defCfg["http.proxy.url"] = ""
+	defCfg["http.proxy.cabundle"] = ""
+
+	defCfg["webhook.security.url"] = ""
+	defCfg["webhook.security.secret"] = ""
+	defCfg["webhook.security.events"] = "LOGIN_FAILED,ACCOUNT_LOCKED,PASSPHRASE_RESET,2FA_FAILED"
+	defCfg["webhook.security.timeout"] = "5 seconds"
+
+	defCfg["branding.product.name"] = "Hansip"
+	defCfg["branding.logo.url"] = ""
+	defCfg["branding.support.email"] = ""
+	defCfg["branding.primary.color"] = ""
 
 	for k := range defCfg {
 		err := viper.BindEnv(k)
@@ -94,6 +267,14 @@ func Get(key string) string {
 	if !initialized {
 		initialize()
 	}
+	if secret, ok := getSecret(key); ok {
+		return secret
+	}
+	return getRaw(key)
+}
+
+// getRaw fetch configuration as string value without resolving secret reference
+func getRaw(key string) string {
 	ret := viper.GetString(key)
 	if len(ret) == 0 {
 		if ret, ok := defCfg[key]; ok {
@@ -140,7 +321,21 @@ func GetFloat(key string) float64 {
 	return f
 }
 
+// GetList fetch configuration as a comma separated list, the items are trimmed and the empty ones left out
+func GetList(key string) []string {
+	ret := make([]string, 0)
+	for _, item := range strings.Split(Get(key), ",") {
+		if trimmed := strings.TrimSpace(item); len(trimmed) > 0 {
+			ret = append(ret, trimmed)
+		}
+	}
+	return ret
+}
+
 // Set configuration key value
 func Set(key, value string) {
+	if !initialized {
+		initialize()
+	}
 	defCfg[key] = value
 }
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetList(t *testing.T) {
+	defer Set("tenant.region.allowed", "")
+	testData := map[string]string{
+		"":                        "",
+		"eu-west":                 "eu-west",
+		" eu-west , ,us-east, ":   "eu-west|us-east",
+		"eu-west,us-east,ap-east": "eu-west|us-east|ap-east",
+	}
+	for value, expect := range testData {
+		Set("tenant.region.allowed", value)
+		if list := strings.Join(GetList("tenant.region.allowed"), "|"); list != expect {
+			t.Errorf("%q expect %q. got %q", value, expect, list)
+		}
+	}
+}
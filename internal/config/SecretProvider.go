@@ -0,0 +1,237 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	secretLog = log.WithField("go", "SecretProvider")
+
+	secretProviders = make(map[string]SecretProvider)
+	secretValues    = make(map[string]string)
+	secretListeners = make([]func(keys []string), 0)
+	secretMutex     sync.RWMutex
+)
+
+// SecretProvider fetch secret values from a secret store, such as HashiCorp Vault.
+type SecretProvider interface {
+	// GetSecret returns the value of a key within the secret at the specified path.
+	GetSecret(path, key string) (string, error)
+}
+
+// SecretReference is a configuration value that refers to a secret, eg. "vault://secret/hansip#token-key"
+type SecretReference struct {
+	Scheme string
+	Path   string
+	Key    string
+}
+
+// ParseSecretReference parse a configuration value in the form of "scheme://path#key".
+// It returns false if the value is not a secret reference.
+func ParseSecretReference(value string) (*SecretReference, bool) {
+	idx := strings.Index(value, "://")
+	if idx <= 0 {
+		return nil, false
+	}
+	hash := strings.LastIndex(value, "#")
+	if hash < idx+3 {
+		return nil, false
+	}
+	ref := &SecretReference{
+		Scheme: strings.ToLower(value[:idx]),
+		Path:   strings.Trim(value[idx+3:hash], "/"),
+		Key:    value[hash+1:],
+	}
+	if len(ref.Path) == 0 || len(ref.Key) == 0 {
+		return nil, false
+	}
+	return ref, true
+}
+
+// RegisterSecretProvider register the provider to resolve the secret reference of the scheme.
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	secretProviders[strings.ToLower(scheme)] = provider
+}
+
+// OnSecretChange register the listener called with the configuration keys whose resolved secret value changed,
+// so the consumer that read the value once, such as the database pool, can pick up the rotated secret.
+func OnSecretChange(listener func(keys []string)) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	secretListeners = append(secretListeners, listener)
+}
+
+// configuredKeys returns the keys that have a default, are set explicitly, or are set by an "AAA_" environment variable.
+func configuredKeys() []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0, len(defCfg))
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for key := range defCfg {
+		add(key)
+	}
+	for _, key := range viper.AllKeys() {
+		add(key)
+	}
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, "AAA_") && len(name) > len("AAA_") {
+			add(strings.ToLower(strings.ReplaceAll(name[len("AAA_"):], "_", ".")))
+		}
+	}
+	return keys
+}
+
+// ResolveSecrets fetch all configuration value that refers to a secret of a registered provider.
+// Once resolved, Get returns the secret value instead of the reference.
+// Value with scheme that has no registered provider, such as a template file URI, is left untouched.
+// The listeners registered with OnSecretChange are told about the keys whose value changed.
+func ResolveSecrets() error {
+	if !initialized {
+		initialize()
+	}
+	resolved := make(map[string]string)
+	for _, key := range configuredKeys() {
+		ref, ok := ParseSecretReference(getRaw(key))
+		if !ok {
+			continue
+		}
+		secretMutex.RLock()
+		provider, ok := secretProviders[ref.Scheme]
+		secretMutex.RUnlock()
+		if !ok {
+			continue
+		}
+		value, err := provider.GetSecret(ref.Path, ref.Key)
+		if err != nil {
+			return fmt.Errorf("can not resolve secret of configuration %s. got %s", key, err.Error())
+		}
+		secretLog.WithField("func", "ResolveSecrets").Infof("Configuration %s is resolved from %s secret", key, ref.Scheme)
+		resolved[key] = value
+	}
+	secretMutex.Lock()
+	changed := make([]string, 0)
+	for key, value := range resolved {
+		if previous, ok := secretValues[key]; !ok || previous != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range secretValues {
+		if _, ok := resolved[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	secretValues = resolved
+	listeners := secretListeners
+	secretMutex.Unlock()
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		for _, listener := range listeners {
+			listener(changed)
+		}
+	}
+	return nil
+}
+
+// StartSecretRefresh periodically resolve the secrets so rotated secret such as database credential
+// is picked up by the next Get. Failing refresh keeps the previously resolved values.
+func StartSecretRefresh(interval time.Duration, stop <-chan bool) {
+	fLog := secretLog.WithField("func", "StartSecretRefresh")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ResolveSecrets(); err != nil {
+				fLog.Errorf("ResolveSecrets got %s", err.Error())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func getSecret(key string) (string, bool) {
+	secretMutex.RLock()
+	defer secretMutex.RUnlock()
+	value, ok := secretValues[key]
+	return value, ok
+}
+
+// VaultSecretProvider fetch secrets from HashiCorp Vault KV secret engine using a Vault token.
+type VaultSecretProvider struct {
+	Address   string
+	Token     string
+	KVVersion int
+	Client    *http.Client
+	// Timeout cuts off the call to Vault, 10 seconds if zero
+	Timeout time.Duration
+}
+
+// GetSecret read the secret at the path, eg. "secret/hansip", and returns the value of the key.
+// For KV version 2, the "data" segment is added after the mount, "secret/hansip" is read from "secret/data/hansip".
+func (vault *VaultSecretProvider) GetSecret(path, key string) (string, error) {
+	apiPath := path
+	if vault.KVVersion == 2 {
+		segments := strings.SplitN(path, "/", 2)
+		if len(segments) == 2 {
+			apiPath = segments[0] + "/data/" + segments[1]
+		}
+	}
+	timeout := vault.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(vault.Address, "/"), apiPath), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", vault.Token)
+	client := vault.Client
+	if client == nil {
+		client = &http.Client{Timeout: timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", hansiperrors.OutboundError("vault", timeout, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded %d for secret %s", resp.StatusCode, path)
+	}
+	secret := &struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	if vault.KVVersion == 2 {
+		data, _ = secret.Data["data"].(map[string]interface{})
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	return value, nil
+}
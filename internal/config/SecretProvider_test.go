@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type mockSecretProvider struct {
+	secrets map[string]string
+}
+
+func (provider *mockSecretProvider) GetSecret(path, key string) (string, error) {
+	if value, ok := provider.secrets[path+"#"+key]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("secret %s#%s not found", path, key)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	provider := &mockSecretProvider{secrets: map[string]string{"secret/hansip#token-key": "s3cr3t"}}
+	RegisterSecretProvider("mock", provider)
+	defer func() {
+		secretValues = make(map[string]string)
+		delete(secretProviders, "mock")
+	}()
+	tokenKey := Get("token.crypt.key")
+	defer Set("token.crypt.key", tokenKey)
+	Set("token.crypt.key", "mock://secret/hansip#token-key")
+
+	if err := ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if Get("token.crypt.key") != "s3cr3t" {
+		t.Errorf("expect secret value but %s", Get("token.crypt.key"))
+	}
+
+	// rotated secret is picked up on the next resolve.
+	provider.secrets["secret/hansip#token-key"] = "r0t4t3d"
+	if err := ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if Get("token.crypt.key") != "r0t4t3d" {
+		t.Errorf("expect rotated secret value but %s", Get("token.crypt.key"))
+	}
+
+	Set("token.crypt.key", "mock://secret/hansip#missing")
+	if err := ResolveSecrets(); err == nil {
+		t.Errorf("expect error for missing secret")
+	}
+
+	if _, ok := ParseSecretReference("file:///etc/hansip/template.html"); ok {
+		t.Errorf("uri without key should not be a secret reference")
+	}
+}
+
+func TestResolveSecretsWithoutDefault(t *testing.T) {
+	provider := &mockSecretProvider{secrets: map[string]string{"secret/hansip#custom": "cust0m"}}
+	RegisterSecretProvider("mock", provider)
+	defer func() {
+		secretValues = make(map[string]string)
+		secretListeners = make([]func(keys []string), 0)
+		delete(secretProviders, "mock")
+	}()
+	os.Setenv("AAA_CUSTOM_SECRET_VALUE", "mock://secret/hansip#custom")
+	defer os.Unsetenv("AAA_CUSTOM_SECRET_VALUE")
+
+	changes := make([][]string, 0)
+	OnSecretChange(func(keys []string) {
+		changes = append(changes, keys)
+	})
+
+	if err := ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if Get("custom.secret.value") != "cust0m" {
+		t.Errorf("expect the key without default to be resolved but %s", Get("custom.secret.value"))
+	}
+
+	// unchanged secret does not notify the listener, the rotated one does.
+	if err := ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	provider.secrets["secret/hansip#custom"] = "r0t4t3d"
+	if err := ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]string{{"custom.secret.value"}, {"custom.secret.value"}}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Errorf("expect changes %v but %v", expect, changes)
+	}
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.URL.Path != "/v1/secret/data/hansip" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"token-key":"from-vault"},"metadata":{"version":1}}}`))
+	}))
+	defer server.Close()
+
+	vault := &VaultSecretProvider{Address: server.URL, Token: "vault-token", KVVersion: 2}
+	value, err := vault.GetSecret("secret/hansip", "token-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "from-vault" {
+		t.Errorf("expect from-vault but %s", value)
+	}
+	if _, err := vault.GetSecret("secret/hansip", "other-key"); err == nil {
+		t.Errorf("expect error for missing key")
+	}
+}
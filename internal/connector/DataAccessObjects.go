@@ -34,6 +34,38 @@ type TenantRepository interface {
 
 	// ListTenant from database with pagination
 	ListTenant(ctx context.Context, request *helper.PageRequest) ([]*Tenant, *helper.Page, error)
+
+	// GetTenantRegion returns the region of a tenant, empty if the tenant has no region
+	GetTenantRegion(ctx context.Context, tenant *Tenant) (string, error)
+
+	// SetTenantRegion sets the region of a tenant. An empty region removes the tenant's region
+	SetTenantRegion(ctx context.Context, tenant *Tenant, region string) error
+
+	// SetTenantActive suspends or reactivates a tenant. The users of a suspended tenant can not access Hansip.
+	SetTenantActive(ctx context.Context, tenant *Tenant, active bool) error
+
+	// IsTenantActive check whether the tenant is not suspended
+	IsTenantActive(ctx context.Context, tenant *Tenant) (bool, error)
+
+	// GetTenantBranding returns the branding of a tenant, nil if the tenant has no branding
+	GetTenantBranding(ctx context.Context, tenant *Tenant) (*TenantBranding, error)
+
+	// SetTenantBranding sets the branding of a tenant. A nil branding removes the tenant's branding
+	SetTenantBranding(ctx context.Context, tenant *Tenant, branding *TenantBranding) error
+
+	// GetTenantEmailDomains returns the email domains allowed for the users of a tenant, sorted. Empty means any domain is allowed
+	GetTenantEmailDomains(ctx context.Context, tenant *Tenant) ([]string, error)
+
+	// SetTenantEmailDomains replaces the email domains allowed for the users of a tenant. No domain removes the restriction
+	SetTenantEmailDomains(ctx context.Context, tenant *Tenant, domains []string) error
+
+	// ReplaceTenantDirectory deletes all roles and groups of a tenant together with their relations and stores the
+	// directory in their place, in a single transaction. Returns the number of roles and groups deleted.
+	ReplaceTenantDirectory(ctx context.Context, tenant *Tenant, directory *TenantDirectory) (int, int, error)
+
+	// ProvisionTenant stores a new tenant with its region, roles, groups, first admin and their relations in a single
+	// transaction. Nothing is stored on error.
+	ProvisionTenant(ctx context.Context, provision *TenantProvision) error
 }
 
 // UserRepository manage User table
@@ -76,6 +108,18 @@ type UserRepository interface {
 
 	// MarkTOTPRecoveryCodeUsed will mark the specific recovery code as used and thus can not be used anymore.
 	MarkTOTPRecoveryCodeUsed(ctx context.Context, user *User, code string) error
+
+	// SetUserActive deactivate or reactivate a user account. Deactivated user is kept but can not authenticate.
+	SetUserActive(ctx context.Context, user *User, active bool) error
+
+	// IsUserActive check whether the user account is not deactivated
+	IsUserActive(ctx context.Context, user *User) (bool, error)
+
+	// SetEmailVerified marks whether the user's current email address has been verified
+	SetEmailVerified(ctx context.Context, user *User, verified bool) error
+
+	// IsEmailVerified check whether the user's current email address has been verified
+	IsEmailVerified(ctx context.Context, user *User) (bool, error)
 }
 
 // GroupRepository manage Group table
@@ -97,6 +141,15 @@ type GroupRepository interface {
 
 	// CreateUserGroup into Group table
 	UpdateGroup(ctx context.Context, group *Group) error
+
+	// GetParentGroup return the parent of a group, nil if the group has no parent
+	GetParentGroup(ctx context.Context, group *Group) (*Group, error)
+
+	// SetParentGroup set the parent of a group, nil parent will make the group a root group
+	SetParentGroup(ctx context.Context, group, parent *Group) error
+
+	// ListChildGroups list the direct sub-groups of a group
+	ListChildGroups(ctx context.Context, group *Group) ([]*Group, error)
 }
 
 // UserGroupRepository manage UserGroup table
@@ -107,6 +160,9 @@ type UserGroupRepository interface {
 	// CreateUserGroup into UserGroup table
 	CreateUserGroup(ctx context.Context, user *User, group *Group) (*UserGroup, error)
 
+	// CreateUserGroups adds all the users into the group in a single transaction, returns the rec ids of the users newly added
+	CreateUserGroups(ctx context.Context, group *Group, users []*User) ([]string, error)
+
 	// ListUserGroupByEmail from the UserGroup table
 	ListUserGroupByUser(ctx context.Context, user *User, request *helper.PageRequest) ([]*Group, *helper.Page, error)
 
@@ -131,6 +187,9 @@ type UserRoleRepository interface {
 	// CreateUserRole into UserRole table
 	CreateUserRole(ctx context.Context, user *User, role *Role) (*UserRole, error)
 
+	// CreateUserRoles assigns the role to all the users in a single transaction, returns the rec ids of the users newly assigned
+	CreateUserRoles(ctx context.Context, role *Role, users []*User) ([]string, error)
+
 	// ListUserRoleByEmail from UserRole table
 	ListUserRoleByUser(ctx context.Context, user *User, request *helper.PageRequest) ([]*Role, *helper.Page, error)
 
@@ -205,6 +264,67 @@ type RevocationRepository interface {
 	IsRevoked(ctx context.Context, subject string) (bool, error)
 }
 
+// AuditRepository manage audit trail table
+type AuditRepository interface {
+	// CreateAudit records an audit event
+	CreateAudit(ctx context.Context, eventType, actor, target, detail string) (*Audit, error)
+
+	// EachAuditBefore calls each with every audit event that occurred before the time, oldest first. An error from each stops the iteration
+	EachAuditBefore(ctx context.Context, before time.Time, each func(audit *Audit) error) error
+
+	// PurgeAuditsBefore deletes the audit events that occurred before the time and returns how many were deleted
+	PurgeAuditsBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PassphraseHistoryRepository manage the user's previously used passphrases
+type PassphraseHistoryRepository interface {
+	// AddPassphraseHistory records a hashed passphrase previously used by the user, only the latest keep entries are retained
+	AddPassphraseHistory(ctx context.Context, user *User, hashedPassphrase string, keep int) error
+
+	// ListPassphraseHistory returns at most limit of the user's previous hashed passphrases, the latest first
+	ListPassphraseHistory(ctx context.Context, user *User, limit int) ([]string, error)
+
+	// GetPassphraseChangedAt returns the time the user's passphrase was last changed, nil if it was never recorded
+	GetPassphraseChangedAt(ctx context.Context, user *User) (*time.Time, error)
+
+	// SetPassphraseChangedAt records the time the user's passphrase was changed
+	SetPassphraseChangedAt(ctx context.Context, user *User, changedAt time.Time) error
+}
+
+// IdempotencyRepository manage the responses recorded for idempotency keys
+type IdempotencyRepository interface {
+	// GetIdempotentResponse returns the response recorded for the key, nil if the key is not recorded or has expired
+	GetIdempotentResponse(ctx context.Context, key string) (*IdempotentResponse, error)
+
+	// SaveIdempotentResponse records the response for its key until it expires. Expired keys are purged along the way.
+	SaveIdempotentResponse(ctx context.Context, response *IdempotentResponse) error
+}
+
+// ConsumedTokenRepository records the IDs of the one-time tokens already used, so they can not be replayed
+type ConsumedTokenRepository interface {
+	// ConsumeTokenID marks the token ID used until it expires, it returns false if the ID is already used.
+	// Expired IDs are purged along the way.
+	ConsumeTokenID(ctx context.Context, tokenID string, expire time.Time) (bool, error)
+}
+
+// WebAuthnCredentialRepository manage the WebAuthn credentials (passkeys) registered by the users
+type WebAuthnCredentialRepository interface {
+	// CreateWebAuthnCredential stores a credential registered by the user
+	CreateWebAuthnCredential(ctx context.Context, user *User, name, credentialID, credential string) (*WebAuthnCredential, error)
+
+	// GetWebAuthnCredentialByRecID returns a credential, nil if it not exist
+	GetWebAuthnCredentialByRecID(ctx context.Context, recID string) (*WebAuthnCredential, error)
+
+	// ListWebAuthnCredentials returns all credentials of the user, oldest first
+	ListWebAuthnCredentials(ctx context.Context, user *User) ([]*WebAuthnCredential, error)
+
+	// UpdateWebAuthnCredential saves the name, the credential and the last use time of a credential
+	UpdateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+
+	// DeleteWebAuthnCredential removes a credential
+	DeleteWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+}
+
 // Revocation record entity
 type Revocation struct {
 	// TenantName is the tenant name
@@ -214,6 +334,75 @@ type Revocation struct {
 	RevocationTime time.Time `json:"revocation_time"`
 }
 
+// Audit record entity
+type Audit struct {
+	// RecID. Primary key
+	RecID string `json:"rec_id"`
+
+	// EventTime is the time the event occurred
+	EventTime time.Time `json:"event_time"`
+
+	// EventType is the type of the event, eg. IMPERSONATE
+	EventType string `json:"event_type"`
+
+	// Actor is the subject who did the action
+	Actor string `json:"actor"`
+
+	// Target is the subject or entity affected by the action
+	Target string `json:"target"`
+
+	// Detail of the event
+	Detail string `json:"detail"`
+
+	// RequestID of the request that trigger the event
+	RequestID string `json:"request_id"`
+}
+
+// IdempotentResponse record entity, the response of a request made with an idempotency key
+type IdempotentResponse struct {
+	// Key is the idempotency key, scoped to the subject that made the request. Primary key
+	Key string `json:"key"`
+
+	// RequestHash is the hash of the request the key was first used with
+	RequestHash string `json:"request_hash"`
+
+	// StatusCode of the response
+	StatusCode int `json:"status_code"`
+
+	// ContentType of the response
+	ContentType string `json:"content_type"`
+
+	// Body of the response
+	Body string `json:"body"`
+
+	// Expire is the time the key can no longer be replayed
+	Expire time.Time `json:"expire"`
+}
+
+// WebAuthnCredential record entity, a passkey the user registered to authenticate with
+type WebAuthnCredential struct {
+	// RecID. Primary key
+	RecID string `json:"rec_id"`
+
+	// UserRecID is the record id of the user owning the credential
+	UserRecID string `json:"user_rec_id"`
+
+	// Name given by the user to tell the passkeys apart
+	Name string `json:"name"`
+
+	// CredentialID is the base64 url encoded credential id given by the authenticator
+	CredentialID string `json:"credential_id"`
+
+	// Credential is the JSON of the verified credential, its public key, flags and sign count
+	Credential string `json:"-"`
+
+	// CreatedAt is the time the credential was registered
+	CreatedAt time.Time `json:"created_at"`
+
+	// LastUsedAt is the time the credential was last used to authenticate
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
 // Tenant record entity
 type Tenant struct {
 	// RecID. Primary key
@@ -227,6 +416,27 @@ type Tenant struct {
 
 	// TenantAdminRole role needed to manage users under this tenant
 	Domain string `json:"domain"`
+
+	// Region where the tenant's data resides, used to route the tenant's users to the regional deployment
+	Region string `json:"region"`
+
+	// Suspended tenant's users can not access Hansip
+	Suspended bool `json:"suspended"`
+}
+
+// TenantBranding is how a tenant presents itself to its users, e.g. in the emails sent to them
+type TenantBranding struct {
+	// ProductName is the name of the product the users sign in to
+	ProductName string `json:"product_name"`
+
+	// LogoURL is the URL of the product logo
+	LogoURL string `json:"logo_url"`
+
+	// SupportEmail is the address users can ask for help
+	SupportEmail string `json:"support_email"`
+
+	// PrimaryColor is the main color of the product, e.g. #0055AA
+	PrimaryColor string `json:"primary_color"`
 }
 
 // User record entity
@@ -337,6 +547,31 @@ type GroupRole struct {
 	RoleRecID string `json:"role_rec_id"`
 }
 
+// TenantDirectory is the complete set of roles, groups and relations of a tenant, as stored by ReplaceTenantDirectory.
+// The roles and groups carry their new rec id, the relations refer to them and to existing users by rec id.
+type TenantDirectory struct {
+	Roles  []*Role
+	Groups []*Group
+
+	// Parents maps the rec id of a group to the rec id of its parent group
+	Parents    map[string]string
+	GroupRoles []*GroupRole
+	UserRoles  []*UserRole
+	UserGroups []*UserGroup
+}
+
+// TenantProvision is a new tenant ready to use, as stored by ProvisionTenant. Every entity carries its new rec id,
+// the admin is made by NewUserRecord and the relations refer to them by rec id.
+type TenantProvision struct {
+	Tenant     *Tenant
+	Roles      []*Role
+	Groups     []*Group
+	GroupRoles []*GroupRole
+	Admin      *User
+	UserRoles  []*UserRole
+	UserGroups []*UserGroup
+}
+
 // Role record entity
 type Role struct {
 	// RecID. Primary key
@@ -0,0 +1,148 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+)
+
+// inTransaction calls fn with a new transaction, committed when fn succeeds and rolled back when it fails.
+func inTransaction(ctx context.Context, instance *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := instance.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// execAllInTransaction executes the statement once for each of the rows of arguments in a single transaction.
+// Either all rows are executed or, on the first error, none. Returns for each row whether it affected any record.
+func execAllInTransaction(ctx context.Context, instance *sql.DB, query string, rows [][]interface{}) ([]bool, error) {
+	affected := make([]bool, len(rows))
+	err := inTransaction(ctx, instance, func(tx *sql.Tx) error {
+		for i, args := range rows {
+			result, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			count, err := result.RowsAffected()
+			affected[i] = err == nil && count > 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return affected, nil
+}
+
+// addedUserRecIDs list the rec ids of the users whose row affected a record
+func addedUserRecIDs(users []*User, affected []bool) []string {
+	added := make([]string, 0, len(users))
+	for i, user := range users {
+		if affected[i] {
+			added = append(added, user.RecID)
+		}
+	}
+	return added
+}
+
+// CreateUserRoles assigns the role to all the users in a single transaction, retried as a whole on a deadlock.
+// Users already having the role are left as is. Returns the rec ids of the users the role is newly assigned to.
+func (db *MySQLDB) CreateUserRoles(ctx context.Context, role *Role, users []*User) ([]string, error) {
+	fLog := mysqlLog.WithField("func", "CreateUserRoles").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "INSERT IGNORE INTO HANSIP_USER_ROLE(USER_REC_ID, ROLE_REC_ID) VALUES (?,?)"
+	rows := make([][]interface{}, len(users))
+	for i, user := range users {
+		rows[i] = []interface{}{user.RecID, role.RecID}
+	}
+	var affected []bool
+	err := RetryOnDeadlock(ctx, config.GetInt("db.retry.deadlock.max"), deadlockRetryBackoff, func(ctx context.Context) error {
+		var err error
+		affected, err = execAllInTransaction(ctx, db.instance, q, rows)
+		return err
+	})
+	if err != nil {
+		fLog.Errorf("execAllInTransaction got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateUserRoles",
+			SQL:     q,
+		}
+	}
+	return addedUserRecIDs(users, affected), nil
+}
+
+// CreateUserGroups adds all the users into the group in a single transaction, retried as a whole on a deadlock.
+// Users already in the group are left as is. Returns the rec ids of the users newly added into the group.
+func (db *MySQLDB) CreateUserGroups(ctx context.Context, group *Group, users []*User) ([]string, error) {
+	fLog := mysqlLog.WithField("func", "CreateUserGroups").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "INSERT IGNORE INTO HANSIP_USER_GROUP(USER_REC_ID, GROUP_REC_ID) VALUES (?,?)"
+	rows := make([][]interface{}, len(users))
+	for i, user := range users {
+		rows[i] = []interface{}{user.RecID, group.RecID}
+	}
+	var affected []bool
+	err := RetryOnDeadlock(ctx, config.GetInt("db.retry.deadlock.max"), deadlockRetryBackoff, func(ctx context.Context) error {
+		var err error
+		affected, err = execAllInTransaction(ctx, db.instance, q, rows)
+		return err
+	})
+	if err != nil {
+		fLog.Errorf("execAllInTransaction got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateUserGroups",
+			SQL:     q,
+		}
+	}
+	return addedUserRecIDs(users, affected), nil
+}
+
+// CreateUserRoles assigns the role to all the users in a single transaction.
+// Users already having the role are left as is. Returns the rec ids of the users the role is newly assigned to.
+func (db *SqliteDB) CreateUserRoles(ctx context.Context, role *Role, users []*User) ([]string, error) {
+	fLog := sqliteLog.WithField("func", "CreateUserRoles").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "INSERT OR IGNORE INTO HANSIP_USER_ROLE(USER_REC_ID, ROLE_REC_ID) VALUES (?,?)"
+	rows := make([][]interface{}, len(users))
+	for i, user := range users {
+		rows[i] = []interface{}{user.RecID, role.RecID}
+	}
+	affected, err := execAllInTransaction(ctx, db.instance, q, rows)
+	if err != nil {
+		fLog.Errorf("execAllInTransaction got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateUserRoles",
+			SQL:     q,
+		}
+	}
+	return addedUserRecIDs(users, affected), nil
+}
+
+// CreateUserGroups adds all the users into the group in a single transaction.
+// Users already in the group are left as is. Returns the rec ids of the users newly added into the group.
+func (db *SqliteDB) CreateUserGroups(ctx context.Context, group *Group, users []*User) ([]string, error) {
+	fLog := sqliteLog.WithField("func", "CreateUserGroups").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "INSERT OR IGNORE INTO HANSIP_USER_GROUP(USER_REC_ID, GROUP_REC_ID) VALUES (?,?)"
+	rows := make([][]interface{}, len(users))
+	for i, user := range users {
+		rows[i] = []interface{}{user.RecID, group.RecID}
+	}
+	affected, err := execAllInTransaction(ctx, db.instance, q, rows)
+	if err != nil {
+		fLog.Errorf("execAllInTransaction got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateUserGroups",
+			SQL:     q,
+		}
+	}
+	return addedUserRecIDs(users, affected), nil
+}
@@ -0,0 +1,52 @@
+package connector
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+var (
+	retryLog = log.WithField("go", "DbConnectRetry")
+)
+
+const (
+	// maxConnectRetryInterval is the upper limit of wait between connection attempt.
+	maxConnectRetryInterval = time.Minute
+)
+
+// ConnectWithRetry calls the connect function until it succeed or the retries are exhausted.
+// The first retry waits for the specified interval, and the wait is doubled on each subsequent retry.
+// It returns the last error returned by connect if all attempts failed.
+func ConnectWithRetry(ctx context.Context, retries int, interval time.Duration, connect func(ctx context.Context) error) error {
+	fLog := retryLog.WithField("func", "ConnectWithRetry")
+	if retries < 0 {
+		retries = 0
+	}
+	wait := interval
+	var err error
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		err = connect(ctx)
+		if err == nil {
+			if attempt > 1 {
+				fLog.Infof("Database connection succeeded on attempt %d", attempt)
+			}
+			return nil
+		}
+		if attempt > retries {
+			break
+		}
+		fLog.Warnf("Database connection attempt %d of %d failed. got %s. retrying in %s", attempt, retries+1, err.Error(), wait.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = wait * 2
+		if wait > maxConnectRetryInterval {
+			wait = maxConnectRetryInterval
+		}
+	}
+	fLog.Errorf("Database connection failed after %d attempt. got %s", retries+1, err.Error())
+	return err
+}
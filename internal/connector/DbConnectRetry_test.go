@@ -0,0 +1,37 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConnectWithRetry(t *testing.T) {
+	attempt := 0
+	err := ConnectWithRetry(context.Background(), 3, time.Millisecond, func(ctx context.Context) error {
+		attempt++
+		if attempt < 2 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expect connection to succeed. got %s", err.Error())
+	}
+	if attempt != 2 {
+		t.Errorf("expect 2 attempt. got %d", attempt)
+	}
+
+	attempt = 0
+	err = ConnectWithRetry(context.Background(), 2, time.Millisecond, func(ctx context.Context) error {
+		attempt++
+		return fmt.Errorf("connection refused")
+	})
+	if err == nil {
+		t.Errorf("expect error after retries exhausted")
+	}
+	if attempt != 3 {
+		t.Errorf("expect 3 attempt. got %d", attempt)
+	}
+}
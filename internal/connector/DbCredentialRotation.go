@@ -0,0 +1,101 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	rotationLog = log.WithField("go", "DbCredentialRotation")
+)
+
+// openRotatingDB opens the database like openDB, but the data source name is obtained again for every new connection,
+// so the connections opened after a credential rotation, eg. a refreshed "vault://" secret, use the new credential.
+func openRotatingDB(driverName string, dataSourceName func() (string, error), label string) (*sql.DB, error) {
+	observer, err := newQueryObserver(label)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := dataSourceName()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	_ = db.Close()
+	var conn driver.Connector = &rotatingConnector{driver: drv, dataSourceName: dataSourceName}
+	if observer.metrics || observer.slowThreshold > 0 {
+		conn = &instrumentedConnector{Connector: conn, observer: observer}
+	}
+	return sql.OpenDB(conn), nil
+}
+
+// rotatingConnector connects using the current data source name. The connector of the driver is rebuilt
+// only when the data source name changed.
+type rotatingConnector struct {
+	driver         driver.Driver
+	dataSourceName func() (string, error)
+
+	mutex     sync.Mutex
+	dsn       string
+	connector driver.Connector
+}
+
+func (c *rotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Connect(ctx)
+}
+
+func (c *rotatingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *rotatingConnector) current() (driver.Connector, error) {
+	dsn, err := c.dataSourceName()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.connector != nil && c.dsn == dsn {
+		return c.connector, nil
+	}
+	var conn driver.Connector = &dsnConnector{dsn: dsn, driver: c.driver}
+	if driverCtx, ok := c.driver.(driver.DriverContext); ok {
+		conn, err = driverCtx.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.dsn, c.connector = dsn, conn
+	return conn, nil
+}
+
+// refreshPoolOnSecretChange returns the config.OnSecretChange listener that drops the idle connections of the pool
+// once a secret of the configuration with one of the prefixes changed, so the next statements open
+// new connections with the rotated credential. Connections in use at that time keep their already authenticated session.
+func refreshPoolOnSecretChange(db *sql.DB, maxIdle int, prefixes ...string) func(keys []string) {
+	return func(keys []string) {
+		for _, key := range keys {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(key, prefix) {
+					rotationLog.WithField("func", "refreshPoolOnSecretChange").Infof("Secret of %s changed, reconnecting the database pool", key)
+					db.SetMaxIdleConns(0)
+					db.SetMaxIdleConns(maxIdle)
+					return
+				}
+			}
+		}
+	}
+}
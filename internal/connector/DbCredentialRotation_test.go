@@ -0,0 +1,40 @@
+package connector
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRotatingDBReconnectOnSecretChange(t *testing.T) {
+	dsn := "file:rotation-before?mode=memory&cache=shared"
+	db, err := openRotatingDB("sqlite3", func() (string, error) { return dsn, nil }, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxIdleConns(1)
+	ctx := context.Background()
+	tableCount := func() int {
+		var count int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'rotation'").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	if _, err := db.ExecContext(ctx, "CREATE TABLE rotation (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rotated data source name is not used while the idle connection is reused.
+	dsn = "file:rotation-after?mode=memory&cache=shared"
+	listener := refreshPoolOnSecretChange(db, 1, "db.mysql.")
+	listener([]string{"token.crypt.key"})
+	if tableCount() != 1 {
+		t.Fatalf("unrelated secret change should keep the pool")
+	}
+
+	listener([]string{"db.mysql.password"})
+	if tableCount() != 0 {
+		t.Errorf("database secret change should reconnect with the rotated data source name")
+	}
+}
@@ -0,0 +1,64 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+const (
+	// mysqlErrDeadlock is returned by MySQL when the statement is chosen as a deadlock victim.
+	mysqlErrDeadlock = 1213
+	// mysqlErrLockWaitTimeout is returned by MySQL when the statement gave up waiting for a row lock.
+	mysqlErrLockWaitTimeout = 1205
+	// deadlockRetryBackoff is the wait before the first retry, it grows linearly on each subsequent retry.
+	deadlockRetryBackoff = 20 * time.Millisecond
+)
+
+// IsTransientLockError check whether the error is a MySQL deadlock or lock wait timeout, which are safe to retry.
+func IsTransientLockError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	return false
+}
+
+// RetryOnDeadlock calls the exec function up to maxAttempts times for as long as it fails with a transient lock error.
+// Any other error, or the last transient lock error once the attempts are exhausted, is returned as is.
+func RetryOnDeadlock(ctx context.Context, maxAttempts int, backoff time.Duration, exec func(ctx context.Context) error) error {
+	fLog := retryLog.WithField("func", "RetryOnDeadlock")
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = exec(ctx)
+		if err == nil || !IsTransientLockError(err) || attempt == maxAttempts {
+			return err
+		}
+		fLog.Warnf("Statement attempt %d of %d hit a lock conflict. got %s. retrying", attempt, maxAttempts, err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff * time.Duration(attempt)):
+		}
+	}
+	return err
+}
+
+// execContext executes a single statement, retrying it when MySQL reports a deadlock or lock wait timeout.
+// MySQL rolls back the statement that failed that way, so executing it again is safe.
+func (db *MySQLDB) execContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := RetryOnDeadlock(ctx, config.GetInt("db.retry.deadlock.max"), deadlockRetryBackoff, func(ctx context.Context) error {
+		var err error
+		result, err = db.instance.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
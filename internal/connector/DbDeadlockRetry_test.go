@@ -0,0 +1,48 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestRetryOnDeadlock(t *testing.T) {
+	attempt := 0
+	err := RetryOnDeadlock(context.Background(), 3, time.Millisecond, func(ctx context.Context) error {
+		attempt++
+		if attempt == 1 {
+			return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expect statement to succeed on retry. got %s", err.Error())
+	}
+	if attempt != 2 {
+		t.Errorf("expect 2 attempt. got %d", attempt)
+	}
+
+	attempt = 0
+	err = RetryOnDeadlock(context.Background(), 3, time.Millisecond, func(ctx context.Context) error {
+		attempt++
+		return &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	})
+	if !IsTransientLockError(err) {
+		t.Errorf("expect the lock error after attempts exhausted")
+	}
+	if attempt != 3 {
+		t.Errorf("expect 3 attempt. got %d", attempt)
+	}
+
+	attempt = 0
+	err = RetryOnDeadlock(context.Background(), 3, time.Millisecond, func(ctx context.Context) error {
+		attempt++
+		return fmt.Errorf("duplicate entry")
+	})
+	if err == nil || attempt != 1 {
+		t.Errorf("non transient error should not be retried. got %d attempt", attempt)
+	}
+}
@@ -90,3 +90,12 @@ type ErrDBNoResult struct {
 func (err *ErrDBNoResult) Error() string {
 	return err.Message
 }
+
+type ErrGroupCycle struct {
+	GroupRecID  string
+	ParentRecID string
+}
+
+func (err *ErrGroupCycle) Error() string {
+	return fmt.Sprintf("Can not set group %s as parent of group %s, it will create a cycle", err.ParentRecID, err.GroupRecID)
+}
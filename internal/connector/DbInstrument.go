@@ -0,0 +1,284 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/metrics"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	instrumentLog = log.WithField("go", "DbInstrument")
+
+	dbQueries       = metrics.DefaultRegistry.NewCounterVec("hansip_db_queries_total", "Number of executed database statements.", "db", "operation")
+	dbQueryErrors   = metrics.DefaultRegistry.NewCounterVec("hansip_db_query_errors_total", "Number of database statements that failed.", "db", "operation")
+	dbQueryDuration = metrics.DefaultRegistry.NewHistogramVec("hansip_db_query_duration_seconds", "Latency of the database statements.", metrics.DefaultBuckets, "db", "operation")
+)
+
+// queryObserver records the metrics of each executed statement and logs those slower than the slow query threshold.
+type queryObserver struct {
+	db            string
+	metrics       bool
+	slowThreshold time.Duration
+}
+
+// observe is called once the statement is done, with the time it started.
+func (o *queryObserver) observe(query string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	if o.metrics {
+		operation := queryOperation(query)
+		dbQueries.Inc(o.db, operation)
+		dbQueryDuration.Observe(elapsed.Seconds(), o.db, operation)
+		if err != nil {
+			dbQueryErrors.Inc(o.db, operation)
+		}
+	}
+	if o.slowThreshold > 0 && elapsed >= o.slowThreshold {
+		instrumentLog.WithField("func", "observe").WithField("db", o.db).WithField("duration", elapsed.String()).Warnf("Slow query took %s : %s", elapsed.String(), strings.Join(strings.Fields(query), " "))
+	}
+}
+
+// newQueryObserver creates the observer of the label database from "server.metrics.enable" and "db.slowquery.threshold".
+func newQueryObserver(label string) (*queryObserver, error) {
+	threshold, err := jiffy.DurationOf(config.Get("db.slowquery.threshold"))
+	if err != nil {
+		return nil, err
+	}
+	return &queryObserver{db: label, metrics: config.GetBoolean("server.metrics.enable"), slowThreshold: threshold}, nil
+}
+
+// queryOperation tells whether the statement reads or writes the database.
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "write"
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "SHOW", "DESCRIBE", "EXPLAIN", "WITH", "PRAGMA":
+		return "read"
+	}
+	return "write"
+}
+
+// openDB opens the database like sql.Open. When "server.metrics.enable" is on or "db.slowquery.threshold" is set,
+// the driver is wrapped to observe every statement, otherwise the driver is used as is without any overhead.
+func openDB(driverName, dsn, label string) (*sql.DB, error) {
+	observer, err := newQueryObserver(label)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil || (!observer.metrics && observer.slowThreshold <= 0) {
+		return db, err
+	}
+	drv := db.Driver()
+	_ = db.Close()
+	var conn driver.Connector = &dsnConnector{dsn: dsn, driver: drv}
+	if driverCtx, ok := drv.(driver.DriverContext); ok {
+		conn, err = driverCtx.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(&instrumentedConnector{Connector: conn, observer: observer}), nil
+}
+
+// dsnConnector is a driver.Connector of drivers that do not implement driver.DriverContext.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type instrumentedConnector struct {
+	driver.Connector
+	observer *queryObserver
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, observer: c.observer}, nil
+}
+
+// instrumentedConn observes the statements executed directly on the connection and the prepared ones.
+// The optional driver interfaces are passed through to the wrapped connection.
+type instrumentedConn struct {
+	driver.Conn
+	observer *queryObserver
+}
+
+func (c *instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	preparer, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := preparer.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.observer.observe(query, start, err)
+	}
+	return result, err
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		if err != driver.ErrSkip {
+			c.observer.observe(query, start, err)
+		}
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, observer: c.observer, query: query, start: start}, nil
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// instrumentedStmt observes the executions of a prepared statement.
+type instrumentedStmt struct {
+	driver.Stmt
+	conn  *instrumentedConn
+	query string
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(namedValuesToValues(args))
+	}
+	s.conn.observer.observe(s.query, start, err)
+	return result, err
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedValuesToValues(args))
+	}
+	if err != nil {
+		s.conn.observer.observe(s.query, start, err)
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, observer: s.conn.observer, query: s.query, start: start}, nil
+}
+
+// CheckNamedValue uses the statement's checker, or the connection's one as database/sql would without the wrapper.
+func (s *instrumentedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// instrumentedRows observes the query once its rows are closed, as drivers may only run the query while the rows are read.
+type instrumentedRows struct {
+	driver.Rows
+	observer *queryObserver
+	query    string
+	start    time.Time
+	err      error
+}
+
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return err
+}
+
+func (r *instrumentedRows) Close() error {
+	err := r.Rows.Close()
+	r.observer.observe(r.query, r.start, r.err)
+	return err
+}
+
+func namedValuesToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
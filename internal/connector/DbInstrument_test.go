@@ -0,0 +1,59 @@
+package connector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	config.Set("db.slowquery.threshold", "50 milliseconds")
+	config.Set("server.metrics.enable", "true")
+	defer config.Set("db.slowquery.threshold", "0 seconds")
+	defer config.Set("server.metrics.enable", "false")
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	db, err := openDB("sqlite3", "file:slowquery?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	queries := dbQueries.Value("sqlite", "read")
+
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range hook.AllEntries() {
+		if strings.Contains(entry.Message, "Slow query") {
+			t.Errorf("fast query should not be logged. got %s", entry.Message)
+		}
+	}
+
+	// a recursive count to a million takes well over the threshold.
+	var count int
+	if err := db.QueryRowContext(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x < 1000000) SELECT COUNT(*) FROM c").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	var slow *log.Entry
+	for _, entry := range hook.AllEntries() {
+		if strings.Contains(entry.Message, "Slow query") {
+			slow = entry
+		}
+	}
+	if slow == nil {
+		t.Fatalf("slow query should be logged")
+	}
+	if slow.Level != log.WarnLevel || !strings.Contains(slow.Message, "WITH RECURSIVE c(x)") || slow.Data["duration"] == nil {
+		t.Errorf("slow query should be logged at WARN with the statement and duration. got %s %s %v", slow.Level, slow.Message, slow.Data)
+	}
+	if got := dbQueries.Value("sqlite", "read") - queries; got != 2 {
+		t.Errorf("expect 2 read queries counted. got %v", got)
+	}
+}
@@ -0,0 +1,138 @@
+package connector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+const (
+	// DbTLSDisable connects to the database in plaintext
+	DbTLSDisable = "disable"
+	// DbTLSRequire encrypts the connection without verifying the database certificate
+	DbTLSRequire = "require"
+	// DbTLSVerifyCA encrypts the connection and verifies the database certificate is signed by db.tls.ca
+	DbTLSVerifyCA = "verify-ca"
+	// DbTLSVerifyFull encrypts the connection and verifies the database certificate is signed by db.tls.ca and issued to the database host
+	DbTLSVerifyFull = "verify-full"
+
+	// dbTLSConfigName is the name the tls.Config is registered to the mysql driver with
+	dbTLSConfigName = "hansip"
+)
+
+// ValidateDbTLS checks the "db.tls" configuration can build a tls.Config.
+func ValidateDbTLS() error {
+	_, err := DbTLSConfig(config.Get("db.mysql.host"))
+	return err
+}
+
+// DbTLSConfig builds the tls.Config of the database connection to the host from "db.tls.mode", "db.tls.ca",
+// "db.tls.cert", "db.tls.key" and "db.tls.skipverify". It returns nil if the mode is disable.
+// db.tls.skipverify is only allowed in the require mode, which does not verify the certificate anyway.
+// Without db.tls.ca the database certificate is verified against the system CA.
+func DbTLSConfig(host string) (*tls.Config, error) {
+	mode := config.Get("db.tls.mode")
+	caFile, certFile, keyFile := config.Get("db.tls.ca"), config.Get("db.tls.cert"), config.Get("db.tls.key")
+	switch mode {
+	case DbTLSDisable:
+		if len(caFile) > 0 || len(certFile) > 0 || len(keyFile) > 0 || config.GetBoolean("db.tls.skipverify") {
+			return nil, fmt.Errorf("db.tls settings are set but db.tls.mode is %s", DbTLSDisable)
+		}
+		return nil, nil
+	case DbTLSRequire:
+	case DbTLSVerifyCA, DbTLSVerifyFull:
+		if config.GetBoolean("db.tls.skipverify") {
+			return nil, fmt.Errorf("db.tls.skipverify can not be set when db.tls.mode is %s", mode)
+		}
+	default:
+		return nil, fmt.Errorf("db.tls.mode %q is not one of %s, %s, %s or %s", mode, DbTLSDisable, DbTLSRequire, DbTLSVerifyCA, DbTLSVerifyFull)
+	}
+
+	tlsConfig := &tls.Config{ServerName: host}
+	if len(caFile) > 0 {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read db.tls.ca %s. got %s", caFile, err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("db.tls.ca %s has no PEM certificate", caFile)
+		}
+	}
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			return nil, fmt.Errorf("db.tls.cert and db.tls.key must both be set")
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not load db.tls.cert and db.tls.key. got %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	switch mode {
+	case DbTLSRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case DbTLSVerifyCA:
+		// the chain is verified here instead of by crypto/tls, which would also verify the host name
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyCertificateChain(tlsConfig.RootCAs)
+	}
+	return tlsConfig, nil
+}
+
+// verifyCertificateChain verifies the peer certificate is signed by the roots, whatever host it is issued to.
+func verifyCertificateChain(roots *x509.CertPool) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("database presented no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("can not parse database certificate. got %s", err.Error())
+			}
+			certs[i] = cert
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
+
+// dbTLSParams returns the data source params enabling TLS in the format, empty if the mode is disable.
+// The MYSQL format refers to the tls.Config registered to the mysql driver, the URL format carries
+// the sslmode, sslrootcert, sslcert and sslkey params for the MySQL compatible drivers taking them.
+func dbTLSParams(format, host string) (string, error) {
+	tlsConfig, err := DbTLSConfig(host)
+	if err != nil || tlsConfig == nil {
+		return "", err
+	}
+	switch format {
+	case DSNFormatMySQL:
+		if err := mysql.RegisterTLSConfig(dbTLSConfigName, tlsConfig); err != nil {
+			return "", err
+		}
+		return "tls=" + dbTLSConfigName, nil
+	case DSNFormatURL:
+		params := url.Values{}
+		params.Set("sslmode", config.Get("db.tls.mode"))
+		for param, key := range map[string]string{"sslrootcert": "db.tls.ca", "sslcert": "db.tls.cert", "sslkey": "db.tls.key"} {
+			if len(config.Get(key)) > 0 {
+				params.Set(param, config.Get(key))
+			}
+		}
+		return params.Encode(), nil
+	default:
+		return "", fmt.Errorf("data source name format %q is not one of %s or %s", format, DSNFormatMySQL, DSNFormatURL)
+	}
+}
@@ -0,0 +1,173 @@
+package connector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+// testDbCertificate creates a certificate for the host signed by the parent, a self signed CA if parent is nil.
+func testDbCertificate(t *testing.T, host string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// handshake connects with the client configuration to a TLS server presenting the certificate.
+func handshake(client *tls.Config, certificate tls.Certificate) error {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{certificate}}).Handshake()
+	}()
+	return tls.Client(clientConn, client).Handshake()
+}
+
+func setDbTLS(t *testing.T, values map[string]string) {
+	defaults := map[string]string{"db.tls.mode": DbTLSDisable, "db.tls.ca": "", "db.tls.cert": "", "db.tls.key": "", "db.tls.skipverify": "false", "db.mysql.host": "localhost"}
+	t.Cleanup(func() {
+		for key, value := range defaults {
+			config.Set(key, value)
+		}
+	})
+	for key, value := range defaults {
+		config.Set(key, value)
+	}
+	for key, value := range values {
+		config.Set(key, value)
+	}
+}
+
+func TestDbTLS(t *testing.T) {
+	ca := testDbCertificate(t, "Test CA", nil)
+	otherCA := testDbCertificate(t, "Other CA", nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dbCert := testDbCertificate(t, "db.local", &ca)
+	wrongHostCert := testDbCertificate(t, "other.local", &ca)
+	untrustedCert := testDbCertificate(t, "db.local", &otherCA)
+
+	setDbTLS(t, map[string]string{"db.tls.mode": DbTLSVerifyFull, "db.tls.ca": caFile, "db.mysql.host": "db.local"})
+	dsn, err := mySQLDataSourceName()
+	if err != nil || dsn != "devuser:devpassword@tcp(db.local:3306)/devdb?parseTime=true&tls=hansip" {
+		t.Fatalf("verify-full should add the registered tls config. got %s %v", dsn, err)
+	}
+	if dsCfg, err := mysql.ParseDSN(dsn); err != nil || dsCfg.TLSConfig != "hansip" {
+		t.Fatalf("the driver should know the registered tls config. got %+v %v", dsCfg, err)
+	}
+	verifyFull, err := DbTLSConfig("db.local")
+	if err != nil || verifyFull.ServerName != "db.local" || verifyFull.InsecureSkipVerify {
+		t.Fatalf("verify-full should verify the certificate of db.local. got %+v %v", verifyFull, err)
+	}
+	if err := handshake(verifyFull, dbCert); err != nil {
+		t.Errorf("verify-full should accept the certificate of db.local. got %s", err.Error())
+	}
+	if err := handshake(verifyFull, wrongHostCert); err == nil {
+		t.Errorf("verify-full should refuse a certificate of another host")
+	}
+	if err := handshake(verifyFull, untrustedCert); err == nil {
+		t.Errorf("verify-full should refuse a certificate of another CA")
+	}
+
+	config.Set("db.tls.mode", DbTLSVerifyCA)
+	verifyCA, err := DbTLSConfig("db.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(verifyCA, wrongHostCert); err != nil {
+		t.Errorf("verify-ca should accept a certificate of another host. got %s", err.Error())
+	}
+	if err := handshake(verifyCA, untrustedCert); err == nil {
+		t.Errorf("verify-ca should refuse a certificate of another CA")
+	}
+
+	config.Set("db.tls.mode", DbTLSRequire)
+	require, err := DbTLSConfig("db.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(require, untrustedCert); err != nil {
+		t.Errorf("require should accept any certificate. got %s", err.Error())
+	}
+
+	config.Set("db.tls.mode", DbTLSVerifyFull)
+	config.Set("db.mysql.dsn.format", DSNFormatURL)
+	defer config.Set("db.mysql.dsn.format", DSNFormatMySQL)
+	dsn, err = mySQLDataSourceName()
+	if err != nil || !strings.HasSuffix(dsn, "?parseTime=true&sslmode=verify-full&sslrootcert="+strings.ReplaceAll(caFile, "/", "%2F")) {
+		t.Errorf("URL format should add the sslmode and sslrootcert params. got %s %v", dsn, err)
+	}
+}
+
+func TestValidateDbTLS(t *testing.T) {
+	setDbTLS(t, nil)
+	if err := ValidateDbTLS(); err != nil {
+		t.Errorf("plaintext should be the valid default. got %s", err.Error())
+	}
+	if dsn, _ := mySQLDataSourceName(); strings.Contains(dsn, "tls=") {
+		t.Errorf("plaintext should not add tls. got %s", dsn)
+	}
+	for _, invalid := range []map[string]string{
+		{"db.tls.mode": "prefer"},
+		{"db.tls.ca": "/etc/ssl/db-ca.pem"},
+		{"db.tls.mode": DbTLSVerifyFull, "db.tls.ca": filepath.Join(t.TempDir(), "missing.pem")},
+		{"db.tls.mode": DbTLSRequire, "db.tls.cert": "client.pem"},
+		{"db.tls.skipverify": "true"},
+		{"db.tls.mode": DbTLSVerifyCA, "db.tls.skipverify": "true"},
+		{"db.tls.mode": DbTLSVerifyFull, "db.tls.skipverify": "true"},
+	} {
+		setDbTLS(t, invalid)
+		if err := ValidateDbTLS(); err == nil {
+			t.Errorf("expect %v to be invalid", invalid)
+		}
+		if _, err := mySQLDataSourceName(); err == nil {
+			t.Errorf("expect no data source name with %v", invalid)
+		}
+	}
+	setDbTLS(t, map[string]string{"db.tls.mode": DbTLSRequire, "db.tls.skipverify": "true"})
+	if err := ValidateDbTLS(); err != nil {
+		t.Errorf("skipverify should be allowed with require. got %s", err.Error())
+	}
+}
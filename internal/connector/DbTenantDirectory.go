@@ -0,0 +1,133 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+)
+
+// replaceTenantDirectory deletes the roles and groups of the tenant and inserts the directory within the transaction.
+// The relations of the deleted roles and groups are deleted explicitly as SQLite does not enforce the foreign keys.
+// Returns the number of roles and groups deleted and, on error, the statement that failed.
+func replaceTenantDirectory(ctx context.Context, tx *sql.Tx, tenant *Tenant, directory *TenantDirectory) (int, int, string, error) {
+	roles := "SELECT REC_ID FROM HANSIP_ROLE WHERE ROLE_DOMAIN=?"
+	groups := "SELECT REC_ID FROM HANSIP_GROUP WHERE GROUP_DOMAIN=?"
+	relations := []string{
+		"DELETE FROM HANSIP_USER_ROLE WHERE ROLE_REC_ID IN (" + roles + ")",
+		"DELETE FROM HANSIP_GROUP_ROLE WHERE ROLE_REC_ID IN (" + roles + ")",
+		"DELETE FROM HANSIP_GROUP_ROLE WHERE GROUP_REC_ID IN (" + groups + ")",
+		"DELETE FROM HANSIP_USER_GROUP WHERE GROUP_REC_ID IN (" + groups + ")",
+		"DELETE FROM HANSIP_GROUP_PARENT WHERE GROUP_REC_ID IN (" + groups + ")",
+		"DELETE FROM HANSIP_GROUP_PARENT WHERE PARENT_REC_ID IN (" + groups + ")",
+	}
+	for _, q := range relations {
+		if _, err := tx.ExecContext(ctx, q, tenant.Domain); err != nil {
+			return 0, 0, q, err
+		}
+	}
+	deleted := make([]int, 0, 2)
+	for _, q := range []string{"DELETE FROM HANSIP_ROLE WHERE ROLE_DOMAIN=?", "DELETE FROM HANSIP_GROUP WHERE GROUP_DOMAIN=?"} {
+		result, err := tx.ExecContext(ctx, q, tenant.Domain)
+		if err != nil {
+			return 0, 0, q, err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return 0, 0, q, err
+		}
+		deleted = append(deleted, int(count))
+	}
+
+	type insert struct {
+		query string
+		rows  [][]interface{}
+	}
+	roleRows := make([][]interface{}, 0, len(directory.Roles))
+	for _, role := range directory.Roles {
+		roleRows = append(roleRows, []interface{}{role.RecID, role.RoleName, role.RoleDomain, role.Description})
+	}
+	groupRows := make([][]interface{}, 0, len(directory.Groups))
+	for _, group := range directory.Groups {
+		groupRows = append(groupRows, []interface{}{group.RecID, group.GroupName, group.GroupDomain, group.Description})
+	}
+	parentRows := make([][]interface{}, 0, len(directory.Parents))
+	for groupRecID, parentRecID := range directory.Parents {
+		parentRows = append(parentRows, []interface{}{groupRecID, parentRecID})
+	}
+	groupRoleRows := make([][]interface{}, 0, len(directory.GroupRoles))
+	for _, groupRole := range directory.GroupRoles {
+		groupRoleRows = append(groupRoleRows, []interface{}{groupRole.GroupRecID, groupRole.RoleRecID})
+	}
+	userRoleRows := make([][]interface{}, 0, len(directory.UserRoles))
+	for _, userRole := range directory.UserRoles {
+		userRoleRows = append(userRoleRows, []interface{}{userRole.UserRecID, userRole.RoleRecID})
+	}
+	userGroupRows := make([][]interface{}, 0, len(directory.UserGroups))
+	for _, userGroup := range directory.UserGroups {
+		userGroupRows = append(userGroupRows, []interface{}{userGroup.UserRecID, userGroup.GroupRecID})
+	}
+	inserts := []insert{
+		{"INSERT INTO HANSIP_ROLE(REC_ID, ROLE_NAME, ROLE_DOMAIN, DESCRIPTION) VALUES (?,?,?,?)", roleRows},
+		{"INSERT INTO HANSIP_GROUP(REC_ID, GROUP_NAME, GROUP_DOMAIN, DESCRIPTION) VALUES (?,?,?,?)", groupRows},
+		{"INSERT INTO HANSIP_GROUP_PARENT(GROUP_REC_ID, PARENT_REC_ID) VALUES (?,?)", parentRows},
+		{"INSERT INTO HANSIP_GROUP_ROLE(GROUP_REC_ID, ROLE_REC_ID) VALUES (?,?)", groupRoleRows},
+		{"INSERT INTO HANSIP_USER_ROLE(USER_REC_ID, ROLE_REC_ID) VALUES (?,?)", userRoleRows},
+		{"INSERT INTO HANSIP_USER_GROUP(USER_REC_ID, GROUP_REC_ID) VALUES (?,?)", userGroupRows},
+	}
+	for _, statement := range inserts {
+		for _, args := range statement.rows {
+			if _, err := tx.ExecContext(ctx, statement.query, args...); err != nil {
+				return 0, 0, statement.query, err
+			}
+		}
+	}
+	return deleted[0], deleted[1], "", nil
+}
+
+// ReplaceTenantDirectory deletes all roles and groups of the tenant together with their relations and stores the
+// directory in their place, in a single transaction retried as a whole on a deadlock. Nothing changes on error.
+func (db *MySQLDB) ReplaceTenantDirectory(ctx context.Context, tenant *Tenant, directory *TenantDirectory) (int, int, error) {
+	fLog := mysqlLog.WithField("func", "ReplaceTenantDirectory").WithField("RequestID", ctx.Value(constants.RequestID))
+	var deletedRoles, deletedGroups int
+	var q string
+	err := RetryOnDeadlock(ctx, config.GetInt("db.retry.deadlock.max"), deadlockRetryBackoff, func(ctx context.Context) error {
+		return inTransaction(ctx, db.instance, func(tx *sql.Tx) error {
+			var err error
+			deletedRoles, deletedGroups, q, err = replaceTenantDirectory(ctx, tx, tenant, directory)
+			return err
+		})
+	})
+	if err != nil {
+		fLog.Errorf("replaceTenantDirectory got %s. SQL = %s", err.Error(), q)
+		return 0, 0, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ReplaceTenantDirectory",
+			SQL:     q,
+		}
+	}
+	return deletedRoles, deletedGroups, nil
+}
+
+// ReplaceTenantDirectory deletes all roles and groups of the tenant together with their relations and stores the
+// directory in their place, in a single transaction. Nothing changes on error.
+func (db *SqliteDB) ReplaceTenantDirectory(ctx context.Context, tenant *Tenant, directory *TenantDirectory) (int, int, error) {
+	fLog := sqliteLog.WithField("func", "ReplaceTenantDirectory").WithField("RequestID", ctx.Value(constants.RequestID))
+	var deletedRoles, deletedGroups int
+	var q string
+	err := inTransaction(ctx, db.instance, func(tx *sql.Tx) error {
+		var err error
+		deletedRoles, deletedGroups, q, err = replaceTenantDirectory(ctx, tx, tenant, directory)
+		return err
+	})
+	if err != nil {
+		fLog.Errorf("replaceTenantDirectory got %s. SQL = %s", err.Error(), q)
+		return 0, 0, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ReplaceTenantDirectory",
+			SQL:     q,
+		}
+	}
+	return deletedRoles, deletedGroups, nil
+}
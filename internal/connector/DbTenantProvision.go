@@ -0,0 +1,136 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/hansip/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// NewUserRecord returns a new disabled user with the hashed passphrase and fresh activation, 2FA and recovery codes,
+// ready to be stored.
+func NewUserRecord(email, passphrase string) (*User, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(passphrase), 14)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		RecID:             NewRecID(),
+		Email:             email,
+		HashedPassphrase:  string(bytes),
+		Enabled:           false,
+		Suspended:         false,
+		LastSeen:          time.Now(),
+		LastLogin:         time.Now(),
+		FailCount:         0,
+		ActivationCode:    helper.MakeRandomString(6, true, false, false, false),
+		ActivationDate:    time.Now(),
+		Enable2FactorAuth: false,
+		UserTotpSecretKey: totp.MakeSecret().Base32(),
+		Token2FA:          helper.MakeRandomString(6, true, false, false, false),
+		RecoveryCode:      helper.MakeRandomString(6, true, false, false, false),
+	}, nil
+}
+
+// provisionTenant inserts the tenant, its region, roles, groups, admin and their relations within the transaction.
+// Returns the statement that failed on error.
+func provisionTenant(ctx context.Context, tx *sql.Tx, provision *TenantProvision) (string, error) {
+	type insert struct {
+		query string
+		rows  [][]interface{}
+	}
+	tenant, admin := provision.Tenant, provision.Admin
+	regionRows := make([][]interface{}, 0, 1)
+	if len(tenant.Region) > 0 {
+		regionRows = append(regionRows, []interface{}{tenant.RecID, tenant.Region})
+	}
+	roleRows := make([][]interface{}, 0, len(provision.Roles))
+	for _, role := range provision.Roles {
+		roleRows = append(roleRows, []interface{}{role.RecID, role.RoleName, role.RoleDomain, role.Description})
+	}
+	groupRows := make([][]interface{}, 0, len(provision.Groups))
+	for _, group := range provision.Groups {
+		groupRows = append(groupRows, []interface{}{group.RecID, group.GroupName, group.GroupDomain, group.Description})
+	}
+	groupRoleRows := make([][]interface{}, 0, len(provision.GroupRoles))
+	for _, groupRole := range provision.GroupRoles {
+		groupRoleRows = append(groupRoleRows, []interface{}{groupRole.GroupRecID, groupRole.RoleRecID})
+	}
+	userRoleRows := make([][]interface{}, 0, len(provision.UserRoles))
+	for _, userRole := range provision.UserRoles {
+		userRoleRows = append(userRoleRows, []interface{}{userRole.UserRecID, userRole.RoleRecID})
+	}
+	userGroupRows := make([][]interface{}, 0, len(provision.UserGroups))
+	for _, userGroup := range provision.UserGroups {
+		userGroupRows = append(userGroupRows, []interface{}{userGroup.UserRecID, userGroup.GroupRecID})
+	}
+	inserts := []insert{
+		{"INSERT INTO HANSIP_TENANT(REC_ID,TENANT_NAME, TENANT_DOMAIN, DESCRIPTION) VALUES(?,?,?,?)", [][]interface{}{{tenant.RecID, tenant.Name, tenant.Domain, tenant.Description}}},
+		{"INSERT INTO HANSIP_TENANT_REGION(TENANT_REC_ID, REGION) VALUES (?,?)", regionRows},
+		{"INSERT INTO HANSIP_ROLE(REC_ID, ROLE_NAME, ROLE_DOMAIN, DESCRIPTION) VALUES (?,?,?,?)", roleRows},
+		{"INSERT INTO HANSIP_GROUP(REC_ID, GROUP_NAME, GROUP_DOMAIN, DESCRIPTION) VALUES (?,?,?,?)", groupRows},
+		{"INSERT INTO HANSIP_GROUP_ROLE(GROUP_REC_ID, ROLE_REC_ID) VALUES (?,?)", groupRoleRows},
+		{"INSERT INTO HANSIP_USER(REC_ID,EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)", [][]interface{}{{
+			admin.RecID, admin.Email, admin.HashedPassphrase, 0, 0, admin.LastSeen, admin.LastLogin, admin.FailCount, admin.ActivationCode,
+			admin.ActivationDate, sealedField{FieldTOTPKey, admin.UserTotpSecretKey}, admin.Enable2FactorAuth, admin.Token2FA, admin.RecoveryCode}}},
+		{"INSERT INTO HANSIP_USER_ROLE(USER_REC_ID, ROLE_REC_ID) VALUES (?,?)", userRoleRows},
+		{"INSERT INTO HANSIP_USER_GROUP(USER_REC_ID, GROUP_REC_ID) VALUES (?,?)", userGroupRows},
+	}
+	for _, statement := range inserts {
+		for _, args := range statement.rows {
+			if _, err := tx.ExecContext(ctx, statement.query, args...); err != nil {
+				return statement.query, err
+			}
+		}
+	}
+	return "", nil
+}
+
+// ProvisionTenant stores the tenant with its region, roles, groups, admin and their relations in a single transaction,
+// retried as a whole on a deadlock. Nothing is stored on error.
+func (db *MySQLDB) ProvisionTenant(ctx context.Context, provision *TenantProvision) error {
+	fLog := mysqlLog.WithField("func", "ProvisionTenant").WithField("RequestID", ctx.Value(constants.RequestID))
+	var q string
+	err := RetryOnDeadlock(ctx, config.GetInt("db.retry.deadlock.max"), deadlockRetryBackoff, func(ctx context.Context) error {
+		return inTransaction(ctx, db.instance, func(tx *sql.Tx) error {
+			var err error
+			q, err = provisionTenant(ctx, tx, provision)
+			return err
+		})
+	})
+	if err != nil {
+		fLog.Errorf("provisionTenant got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ProvisionTenant",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// ProvisionTenant stores the tenant with its region, roles, groups, admin and their relations in a single transaction.
+// Nothing is stored on error.
+func (db *SqliteDB) ProvisionTenant(ctx context.Context, provision *TenantProvision) error {
+	fLog := sqliteLog.WithField("func", "ProvisionTenant").WithField("RequestID", ctx.Value(constants.RequestID))
+	var q string
+	err := inTransaction(ctx, db.instance, func(tx *sql.Tx) error {
+		var err error
+		q, err = provisionTenant(ctx, tx, provision)
+		return err
+	})
+	if err != nil {
+		fLog.Errorf("provisionTenant got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ProvisionTenant",
+			SQL:     q,
+		}
+	}
+	return nil
+}
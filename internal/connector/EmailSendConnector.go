@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 	"github.com/sirupsen/logrus"
+	"net/http"
 	"net/smtp"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -47,12 +52,15 @@ func (r *Recipients) Recipients() []string {
 
 // DummyMailSender a dummy email sender. It does not send any email.
 type DummyMailSender struct {
+	// ReplyTo is the Reply-To address of the emails, none if empty
+	ReplyTo      string
 	LastSentMail *DummyMail
 }
 
 // DummyMail dummy email data structure
 type DummyMail struct {
 	From    string
+	ReplyTo string
 	To      string
 	Cc      string
 	Bcc     string
@@ -64,6 +72,7 @@ type DummyMail struct {
 func (sender *DummyMailSender) SendEmail(ctx context.Context, to, cc, bcc []string, from, fromName, subject, body string) error {
 	sender.LastSentMail = &DummyMail{
 		From:    from,
+		ReplyTo: sender.ReplyTo,
 		Subject: subject,
 		Body:    body,
 	}
@@ -85,6 +94,14 @@ type SendMailSender struct {
 	Port     int
 	User     string
 	Password string
+	// ReplyTo is the Reply-To address of the emails, none if empty
+	ReplyTo string
+	// KeepAlive reuses one SMTP connection for all emails instead of connecting for each email.
+	// The connection is probed before each email and reconnected if it went stale.
+	KeepAlive bool
+
+	mutex  sync.Mutex
+	client *smtp.Client
 }
 
 // SendEmail implementation to send email using sendmail
@@ -95,6 +112,11 @@ func (sender *SendMailSender) SendEmail(ctx context.Context, to, cc, bcc []strin
 		To: make(map[string]bool),
 	}
 	var bodyBuffer bytes.Buffer
+	if len(sender.ReplyTo) > 0 {
+		bodyBuffer.WriteString("Reply-To: ")
+		bodyBuffer.WriteString(sender.ReplyTo)
+		bodyBuffer.WriteString("\r\n")
+	}
 	if to != nil && len(to) > 0 {
 		rec.AddAll(to)
 		bodyBuffer.WriteString("To: ")
@@ -121,7 +143,12 @@ func (sender *SendMailSender) SendEmail(ctx context.Context, to, cc, bcc []strin
 
 	sendmailLog := mailerLog.WithField("mailer", "sendmail").WithField("mailto", strings.Join(to, ","))
 
-	err := smtp.SendMail(fmt.Sprintf("%s:%d", sender.Host, sender.Port), auth, from, rec.Recipients(), bodyBuffer.Bytes())
+	var err error
+	if sender.KeepAlive {
+		err = sender.sendKeptAlive(auth, from, rec.Recipients(), bodyBuffer.Bytes())
+	} else {
+		err = smtp.SendMail(fmt.Sprintf("%s:%d", sender.Host, sender.Port), auth, from, rec.Recipients(), bodyBuffer.Bytes())
+	}
 	if err != nil {
 		sendmailLog.Error(err)
 		return err
@@ -133,6 +160,14 @@ func (sender *SendMailSender) SendEmail(ctx context.Context, to, cc, bcc []strin
 // SendGridSender implementation using sendgrid. contains sendgrid token.
 type SendGridSender struct {
 	Token string
+	// Client is the http client calling the SendGrid API, the default client if nil
+	Client *http.Client
+	// Host is the SendGrid API host, https://api.sendgrid.com if empty
+	Host string
+	// Timeout cuts off the call to the SendGrid API, no timeout if zero
+	Timeout time.Duration
+	// ReplyTo is the Reply-To address of the emails, none if empty
+	ReplyTo string
 }
 
 // getMailBoxName get the mailbox portion of an email. abc@domain.com will return "abc"
@@ -170,18 +205,45 @@ func (sender *SendGridSender) SendEmail(ctx context.Context, to, cc, bcc []strin
 	sendGridMail.AddContent(content)
 
 	sendGridMail.SetFrom(mail.NewEmail(fromName, from))
+	if len(sender.ReplyTo) > 0 {
+		sendGridMail.SetReplyTo(mail.NewEmail(getMailBoxName(sender.ReplyTo), sender.ReplyTo))
+	}
 
 	if len(sender.Token) == 0 {
 		panic("sendgrid mailer with no token configured")
 	}
 
-	sendGridClient := sendgrid.NewSendClient(sender.Token)
-	resp, err := sendGridClient.Send(sendGridMail)
+	request := sendgrid.GetRequest(sender.Token, "/v3/mail/send", sender.Host)
+	request.Method = http.MethodPost
+	request.Body = mail.GetRequestBody(sendGridMail)
+	restClient := rest.DefaultClient
+	if sender.Client != nil {
+		restClient = &rest.Client{HTTPClient: sender.Client}
+	}
+	if sender.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sender.Timeout)
+		defer cancel()
+	}
+	resp, err := sendGridRequest(ctx, restClient, request)
 	sendgridLog := mailerLog.WithField("mailer", "sendgrid").WithField("mailto", strings.Join(to, ","))
 	if err != nil {
 		sendgridLog.Errorf("error while sending email. got %s", err.Error())
-		return err
+		return hansiperrors.OutboundError("sendgrid", sender.Timeout, err)
 	}
 	sendgridLog.Debugf("response status %d, body %s", resp.StatusCode, resp.Body)
 	return nil
 }
+
+// sendGridRequest sends the SendGrid API request within the context, so its deadline cuts off the call.
+func sendGridRequest(ctx context.Context, client *rest.Client, request rest.Request) (*rest.Response, error) {
+	req, err := rest.BuildRequestObject(request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.MakeRequest(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return rest.BuildResponse(resp)
+}
@@ -0,0 +1,36 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+)
+
+func TestSendGridTimeout(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sender := &SendGridSender{
+		Token:   "token",
+		Host:    server.URL,
+		Timeout: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	err := sender.SendEmail(context.Background(), []string{"user@test.com"}, nil, nil, "hansip@test.com", "Hansip", "subject", "body")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hanging SendGrid call should be cut off at the timeout. took %s", elapsed)
+	}
+	timeoutErr := &hansiperrors.ErrOutboundTimeout{}
+	if !errors.As(err, &timeoutErr) || timeoutErr.Connector != "sendgrid" {
+		t.Errorf("expect a sendgrid timeout error. got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
+)
+
+// EntityCache caches the hot role and tenant lookups in process, each entity kind with its own time to live.
+// The cache keys start with the tenant domain, so the entries of a tenant never serve another tenant and
+// are invalidated together when the tenant changes.
+// Only found entities are cached, a copy is stored and returned so callers can not alter the cached entity.
+type EntityCache struct {
+	roles   cache.ObjectCache
+	tenants cache.ObjectCache
+}
+
+// NewEntityCache create new instance of EntityCache holding up to capacity roles and capacity tenant entries.
+func NewEntityCache(capacity int, roleTTL, tenantTTL time.Duration) *EntityCache {
+	return &EntityCache{
+		roles:   cache.NewInMemoryCache(capacity, ttlSeconds(roleTTL), false),
+		tenants: cache.NewInMemoryCache(capacity, ttlSeconds(tenantTTL), false),
+	}
+}
+
+func ttlSeconds(ttl time.Duration) int {
+	if ttl < time.Second {
+		return 1
+	}
+	return int(ttl / time.Second)
+}
+
+func tenantKey(domain, kind, id string) string {
+	return fmt.Sprintf("%s|%s|%s", domain, kind, id)
+}
+
+// recIDKey is the key of the tenant domain an entity record id belongs to,
+// it lets a lookup by record id find the tenant scoped entry.
+func recIDKey(kind, recID string) string {
+	return fmt.Sprintf("recid|%s|%s", kind, recID)
+}
+
+func store(objectCache cache.ObjectCache, key string, object interface{}) {
+	// a stored key is deleted first, so the replaced entry is moved to the top of the cache.
+	objectCache.Delete(key)
+	objectCache.Store(key, object)
+}
+
+func deleteByPrefix(objectCache cache.ObjectCache, prefix string) {
+	for keys := objectCache.KeysByPrefix(prefix); len(keys) > 0; keys = objectCache.KeysByPrefix(prefix) {
+		for _, key := range keys {
+			objectCache.Delete(key)
+		}
+	}
+}
+
+// InvalidateTenant removes all cached entries of the tenant domain.
+func (c *EntityCache) InvalidateTenant(domain string) {
+	deleteByPrefix(c.roles, domain+"|")
+	deleteByPrefix(c.tenants, domain+"|")
+}
+
+func (c *EntityCache) domainOf(objectCache cache.ObjectCache, kind, recID string) (string, bool) {
+	if ok, domain := objectCache.Fetch(recIDKey(kind, recID)); ok {
+		return domain.(string), true
+	}
+	return "", false
+}
+
+// CachedRoleRepository is a RoleRepository that caches the roles looked up by record id or by name.
+// Updating or deleting a role invalidates the cached roles of its domain.
+type CachedRoleRepository struct {
+	RoleRepository
+	Cache *EntityCache
+}
+
+// GetRoleByRecID return an existing role, from the cache if it is there.
+func (repo *CachedRoleRepository) GetRoleByRecID(ctx context.Context, recID string) (*Role, error) {
+	if domain, ok := repo.Cache.domainOf(repo.Cache.roles, "role", recID); ok {
+		if ok, role := repo.Cache.roles.Fetch(tenantKey(domain, "role.id", recID)); ok {
+			cached := role.(Role)
+			return &cached, nil
+		}
+	}
+	role, err := repo.RoleRepository.GetRoleByRecID(ctx, recID)
+	if err == nil && role != nil {
+		repo.cacheRole(role)
+	}
+	return role, err
+}
+
+// GetRoleByName return a role record, from the cache if it is there.
+func (repo *CachedRoleRepository) GetRoleByName(ctx context.Context, roleName, roleDomain string) (*Role, error) {
+	if ok, role := repo.Cache.roles.Fetch(tenantKey(roleDomain, "role.name", roleName)); ok {
+		cached := role.(Role)
+		return &cached, nil
+	}
+	role, err := repo.RoleRepository.GetRoleByName(ctx, roleName, roleDomain)
+	if err == nil && role != nil {
+		repo.cacheRole(role)
+	}
+	return role, err
+}
+
+func (repo *CachedRoleRepository) cacheRole(role *Role) {
+	store(repo.Cache.roles, recIDKey("role", role.RecID), role.RoleDomain)
+	store(repo.Cache.roles, tenantKey(role.RoleDomain, "role.id", role.RecID), *role)
+	store(repo.Cache.roles, tenantKey(role.RoleDomain, "role.name", role.RoleName), *role)
+}
+
+// invalidateRole removes the cached roles of the role's domain and, as an update may move the role
+// to another domain, of the domain it is stored under before the write.
+func (repo *CachedRoleRepository) invalidateRole(ctx context.Context, role *Role) func() {
+	domains := []string{role.RoleDomain}
+	if stored, err := repo.RoleRepository.GetRoleByRecID(ctx, role.RecID); err == nil && stored != nil {
+		domains = append(domains, stored.RoleDomain)
+	}
+	return func() {
+		repo.Cache.roles.Delete(recIDKey("role", role.RecID))
+		for _, domain := range domains {
+			deleteByPrefix(repo.Cache.roles, domain+"|role.")
+		}
+	}
+}
+
+// DeleteRole from Role table and the cache
+func (repo *CachedRoleRepository) DeleteRole(ctx context.Context, role *Role) error {
+	defer repo.invalidateRole(ctx, role)()
+	return repo.RoleRepository.DeleteRole(ctx, role)
+}
+
+// UpdateRole into Role table and invalidates the cache
+func (repo *CachedRoleRepository) UpdateRole(ctx context.Context, role *Role) error {
+	defer repo.invalidateRole(ctx, role)()
+	return repo.RoleRepository.UpdateRole(ctx, role)
+}
+
+// CachedTenantRepository is a TenantRepository that caches the tenants, their region and their branding.
+// Any change to a tenant invalidates all cached entries of its domain, including its roles.
+type CachedTenantRepository struct {
+	TenantRepository
+	Cache *EntityCache
+}
+
+// GetTenantByDomain return a tenant record, from the cache if it is there.
+func (repo *CachedTenantRepository) GetTenantByDomain(ctx context.Context, tenantDomain string) (*Tenant, error) {
+	if ok, tenant := repo.Cache.tenants.Fetch(tenantKey(tenantDomain, "tenant", "")); ok {
+		cached := tenant.(Tenant)
+		return &cached, nil
+	}
+	tenant, err := repo.TenantRepository.GetTenantByDomain(ctx, tenantDomain)
+	if err == nil && tenant != nil {
+		repo.cacheTenant(tenant)
+	}
+	return tenant, err
+}
+
+// GetTenantByRecID return a tenant record, from the cache if it is there.
+func (repo *CachedTenantRepository) GetTenantByRecID(ctx context.Context, recID string) (*Tenant, error) {
+	if domain, ok := repo.Cache.domainOf(repo.Cache.tenants, "tenant", recID); ok {
+		if ok, tenant := repo.Cache.tenants.Fetch(tenantKey(domain, "tenant", "")); ok {
+			cached := tenant.(Tenant)
+			return &cached, nil
+		}
+	}
+	tenant, err := repo.TenantRepository.GetTenantByRecID(ctx, recID)
+	if err == nil && tenant != nil {
+		repo.cacheTenant(tenant)
+	}
+	return tenant, err
+}
+
+func (repo *CachedTenantRepository) cacheTenant(tenant *Tenant) {
+	store(repo.Cache.tenants, recIDKey("tenant", tenant.RecID), tenant.Domain)
+	store(repo.Cache.tenants, tenantKey(tenant.Domain, "tenant", ""), *tenant)
+}
+
+// GetTenantRegion returns the region of a tenant, from the cache if it is there.
+func (repo *CachedTenantRepository) GetTenantRegion(ctx context.Context, tenant *Tenant) (string, error) {
+	key := tenantKey(tenant.Domain, "region", tenant.RecID)
+	if ok, region := repo.Cache.tenants.Fetch(key); ok {
+		return region.(string), nil
+	}
+	region, err := repo.TenantRepository.GetTenantRegion(ctx, tenant)
+	if err == nil {
+		store(repo.Cache.tenants, key, region)
+	}
+	return region, err
+}
+
+// IsTenantActive check whether the tenant is not suspended, from the cache if it is there.
+func (repo *CachedTenantRepository) IsTenantActive(ctx context.Context, tenant *Tenant) (bool, error) {
+	key := tenantKey(tenant.Domain, "active", tenant.RecID)
+	if ok, active := repo.Cache.tenants.Fetch(key); ok {
+		return active.(bool), nil
+	}
+	active, err := repo.TenantRepository.IsTenantActive(ctx, tenant)
+	if err == nil {
+		store(repo.Cache.tenants, key, active)
+	}
+	return active, err
+}
+
+// GetTenantBranding returns the branding of a tenant, from the cache if it is there.
+func (repo *CachedTenantRepository) GetTenantBranding(ctx context.Context, tenant *Tenant) (*TenantBranding, error) {
+	key := tenantKey(tenant.Domain, "branding", tenant.RecID)
+	if ok, branding := repo.Cache.tenants.Fetch(key); ok {
+		cached := branding.(TenantBranding)
+		return &cached, nil
+	}
+	branding, err := repo.TenantRepository.GetTenantBranding(ctx, tenant)
+	if err == nil && branding != nil {
+		store(repo.Cache.tenants, key, *branding)
+	}
+	return branding, err
+}
+
+// invalidateTenant removes the cached entries of the tenant's domain and, as an update may change
+// the domain, of the domain it is stored under before the write.
+func (repo *CachedTenantRepository) invalidateTenant(ctx context.Context, tenant *Tenant) func() {
+	domains := []string{tenant.Domain}
+	if stored, err := repo.TenantRepository.GetTenantByRecID(ctx, tenant.RecID); err == nil && stored != nil {
+		domains = append(domains, stored.Domain)
+	}
+	return func() {
+		repo.Cache.tenants.Delete(recIDKey("tenant", tenant.RecID))
+		for _, domain := range domains {
+			repo.Cache.InvalidateTenant(domain)
+		}
+	}
+}
+
+// DeleteTenant removes a tenant entity from table and the cache
+func (repo *CachedTenantRepository) DeleteTenant(ctx context.Context, tenant *Tenant) error {
+	defer repo.invalidateTenant(ctx, tenant)()
+	return repo.TenantRepository.DeleteTenant(ctx, tenant)
+}
+
+// UpdateTenant into table tenant and invalidates the cache
+func (repo *CachedTenantRepository) UpdateTenant(ctx context.Context, tenant *Tenant) error {
+	defer repo.invalidateTenant(ctx, tenant)()
+	return repo.TenantRepository.UpdateTenant(ctx, tenant)
+}
+
+// SetTenantRegion sets the region of a tenant and removes its cached region
+func (repo *CachedTenantRepository) SetTenantRegion(ctx context.Context, tenant *Tenant, region string) error {
+	defer repo.Cache.tenants.Delete(tenantKey(tenant.Domain, "region", tenant.RecID))
+	return repo.TenantRepository.SetTenantRegion(ctx, tenant, region)
+}
+
+// SetTenantBranding sets the branding of a tenant and removes its cached branding
+func (repo *CachedTenantRepository) SetTenantBranding(ctx context.Context, tenant *Tenant, branding *TenantBranding) error {
+	defer repo.Cache.tenants.Delete(tenantKey(tenant.Domain, "branding", tenant.RecID))
+	return repo.TenantRepository.SetTenantBranding(ctx, tenant, branding)
+}
+
+// SetTenantActive suspends or reactivates a tenant and removes its cached status
+func (repo *CachedTenantRepository) SetTenantActive(ctx context.Context, tenant *Tenant, active bool) error {
+	defer repo.Cache.tenants.Delete(tenantKey(tenant.Domain, "active", tenant.RecID))
+	return repo.TenantRepository.SetTenantActive(ctx, tenant, active)
+}
+
+// ReplaceTenantDirectory replaces the roles and groups of a tenant and removes the cached entries of its domain
+func (repo *CachedTenantRepository) ReplaceTenantDirectory(ctx context.Context, tenant *Tenant, directory *TenantDirectory) (int, int, error) {
+	defer repo.Cache.InvalidateTenant(tenant.Domain)
+	return repo.TenantRepository.ReplaceTenantDirectory(ctx, tenant, directory)
+}
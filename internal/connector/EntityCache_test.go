@@ -0,0 +1,171 @@
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type countingRoleRepo struct {
+	RoleRepository
+	roles map[string]*Role
+	reads int
+}
+
+func (repo *countingRoleRepo) GetRoleByRecID(ctx context.Context, recID string) (*Role, error) {
+	repo.reads++
+	if role, ok := repo.roles[recID]; ok {
+		copied := *role
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (repo *countingRoleRepo) GetRoleByName(ctx context.Context, roleName, roleDomain string) (*Role, error) {
+	repo.reads++
+	for _, role := range repo.roles {
+		if role.RoleName == roleName && role.RoleDomain == roleDomain {
+			copied := *role
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *countingRoleRepo) UpdateRole(ctx context.Context, role *Role) error {
+	copied := *role
+	repo.roles[role.RecID] = &copied
+	return nil
+}
+
+type countingTenantRepo struct {
+	TenantRepository
+	tenants map[string]*Tenant
+	regions map[string]string
+	reads   int
+}
+
+func (repo *countingTenantRepo) GetTenantByDomain(ctx context.Context, tenantDomain string) (*Tenant, error) {
+	repo.reads++
+	for _, tenant := range repo.tenants {
+		if tenant.Domain == tenantDomain {
+			copied := *tenant
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *countingTenantRepo) GetTenantByRecID(ctx context.Context, recID string) (*Tenant, error) {
+	repo.reads++
+	if tenant, ok := repo.tenants[recID]; ok {
+		copied := *tenant
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (repo *countingTenantRepo) UpdateTenant(ctx context.Context, tenant *Tenant) error {
+	copied := *tenant
+	repo.tenants[tenant.RecID] = &copied
+	return nil
+}
+
+func (repo *countingTenantRepo) GetTenantRegion(ctx context.Context, tenant *Tenant) (string, error) {
+	repo.reads++
+	return repo.regions[tenant.RecID], nil
+}
+
+func (repo *countingTenantRepo) SetTenantRegion(ctx context.Context, tenant *Tenant, region string) error {
+	repo.regions[tenant.RecID] = region
+	return nil
+}
+
+func TestCachedRoleRepository(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingRoleRepo{roles: map[string]*Role{
+		"r1": {RecID: "r1", RoleName: "admin", RoleDomain: "acme", Description: "before"},
+		"r2": {RecID: "r2", RoleName: "admin", RoleDomain: "globex", Description: "globex admin"},
+	}}
+	repo := &CachedRoleRepository{RoleRepository: backend, Cache: NewEntityCache(100, time.Minute, time.Minute)}
+
+	role, err := repo.GetRoleByName(ctx, "admin", "acme")
+	if err != nil || role.RecID != "r1" {
+		t.Fatalf("expect role r1. got %v %v", role, err)
+	}
+	role.Description = "changed by the caller"
+	if role, _ = repo.GetRoleByRecID(ctx, "r1"); role.Description != "before" {
+		t.Errorf("cached role should not be changed by the caller. got %s", role.Description)
+	}
+	if backend.reads != 1 {
+		t.Errorf("expect the second read served from the cache. got %d reads", backend.reads)
+	}
+	if role, _ = repo.GetRoleByName(ctx, "admin", "globex"); role.RecID != "r2" || backend.reads != 2 {
+		t.Errorf("cache key should be tenant scoped. got %s after %d reads", role.RecID, backend.reads)
+	}
+
+	// the update looks up the stored role to invalidate the domain it is stored under.
+	if err := repo.UpdateRole(ctx, &Role{RecID: "r1", RoleName: "admin", RoleDomain: "acme", Description: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ = repo.GetRoleByName(ctx, "admin", "acme"); role.Description != "after" || backend.reads != 4 {
+		t.Errorf("update should invalidate the cached role. got %s after %d reads", role.Description, backend.reads)
+	}
+	if role, _ = repo.GetRoleByName(ctx, "admin", "globex"); backend.reads != 4 {
+		t.Errorf("update should not invalidate the roles of other tenants. got %d reads", backend.reads)
+	}
+
+	repo.GetRoleByRecID(ctx, "missing")
+	if role, _ = repo.GetRoleByRecID(ctx, "missing"); role != nil || backend.reads != 6 {
+		t.Errorf("not found role should not be cached. got %v after %d reads", role, backend.reads)
+	}
+}
+
+func TestCachedTenantRepository(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingTenantRepo{
+		tenants: map[string]*Tenant{"t1": {RecID: "t1", Name: "Acme", Domain: "acme"}},
+		regions: map[string]string{"t1": "eu"},
+	}
+	cache := NewEntityCache(100, time.Minute, time.Minute)
+	repo := &CachedTenantRepository{TenantRepository: backend, Cache: cache}
+	roles := &countingRoleRepo{roles: map[string]*Role{"r1": {RecID: "r1", RoleName: "admin", RoleDomain: "acme"}}}
+	roleRepo := &CachedRoleRepository{RoleRepository: roles, Cache: cache}
+
+	tenant, err := repo.GetTenantByDomain(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant, _ = repo.GetTenantByRecID(ctx, "t1"); tenant.Name != "Acme" || backend.reads != 1 {
+		t.Errorf("expect the second read served from the cache. got %s after %d reads", tenant.Name, backend.reads)
+	}
+	region, _ := repo.GetTenantRegion(ctx, tenant)
+	region, _ = repo.GetTenantRegion(ctx, tenant)
+	if region != "eu" || backend.reads != 2 {
+		t.Errorf("expect the cached region. got %s after %d reads", region, backend.reads)
+	}
+
+	if err := repo.SetTenantRegion(ctx, tenant, "us"); err != nil {
+		t.Fatal(err)
+	}
+	if region, _ = repo.GetTenantRegion(ctx, tenant); region != "us" || backend.reads != 3 {
+		t.Errorf("setting the region should invalidate the cache. got %s after %d reads", region, backend.reads)
+	}
+
+	roleRepo.GetRoleByName(ctx, "admin", "acme")
+	tenant.Name = "Acme Corp"
+	tenant.Domain = "acme.corp"
+	// the update looks up the stored tenant to invalidate its old domain.
+	if err := repo.UpdateTenant(ctx, tenant); err != nil {
+		t.Fatal(err)
+	}
+	if tenant, _ = repo.GetTenantByRecID(ctx, "t1"); tenant.Name != "Acme Corp" || backend.reads != 5 {
+		t.Errorf("update should invalidate the cached tenant. got %s after %d reads", tenant.Name, backend.reads)
+	}
+	if old, _ := repo.GetTenantByDomain(ctx, "acme"); old != nil {
+		t.Errorf("old domain should not be served from the cache. got %s", old.Name)
+	}
+	if roleRepo.GetRoleByName(ctx, "admin", "acme"); roles.reads != 2 {
+		t.Errorf("tenant update should invalidate the roles of the tenant. got %d reads", roles.reads)
+	}
+}
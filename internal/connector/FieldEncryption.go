@@ -0,0 +1,152 @@
+package connector
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+const (
+	// FieldTOTPKey is the user's TOTP secret key, the HANSIP_USER.TOTP_KEY column
+	FieldTOTPKey = "totp"
+
+	// encryptedFieldPrefix marks a column value encrypted at rest. Values without it are plain text, eg. written before
+	// the field is encrypted, and are read as is. A sealed 16 character TOTP key is exactly 64 characters, the column width.
+	encryptedFieldPrefix = "enc1:"
+)
+
+// encryptableFields are the fields that can be listed in "db.encrypt.fields"
+var encryptableFields = []string{FieldTOTPKey}
+
+// ValidateEncryptedFields checks that every field listed in "db.encrypt.fields" can be encrypted.
+func ValidateEncryptedFields() error {
+	for _, field := range encryptedFields() {
+		known := false
+		for _, encryptable := range encryptableFields {
+			known = known || field == encryptable
+		}
+		if !known {
+			return fmt.Errorf("db.encrypt.fields %q is not one of %s", field, strings.Join(encryptableFields, ","))
+		}
+	}
+	return nil
+}
+
+// encryptedFields lists the fields in "db.encrypt.fields"
+func encryptedFields() []string {
+	return config.GetList("db.encrypt.fields")
+}
+
+// isFieldEncrypted check whether the field is listed in "db.encrypt.fields"
+func isFieldEncrypted(field string) bool {
+	for _, encrypted := range encryptedFields() {
+		if encrypted == field {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldCipher creates the AES-256-GCM cipher keyed from "db.encrypt.key", or "token.crypt.key" when it is not set.
+func fieldCipher() (cipher.AEAD, error) {
+	secret := config.Get("db.encrypt.key")
+	if len(secret) == 0 {
+		secret = config.Get("token.crypt.key")
+	}
+	// the key is derived so the token signing key is never used as is to encrypt data
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("hansip field encryption"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealField encrypts the value of the field if it is listed in "db.encrypt.fields", an empty value is kept empty.
+func sealField(field, value string) (string, error) {
+	if len(value) == 0 || !isFieldEncrypted(field) {
+		return value, nil
+	}
+	aead, err := fieldCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	// the field name is authenticated so a sealed value can not be moved into another field
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return encryptedFieldPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openField decrypts the value of the field if it was sealed, whether or not the field is still listed in "db.encrypt.fields".
+func openField(field, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedFieldPrefix) {
+		return value, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(value[len(encryptedFieldPrefix):])
+	if err != nil {
+		return "", fmt.Errorf("encrypted %s is not valid base64. got %s", field, err.Error())
+	}
+	aead, err := fieldCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted %s is too short", field)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("can not decrypt %s, db.encrypt.key may have changed. got %s", field, err.Error())
+	}
+	return string(plain), nil
+}
+
+// sealedField is a statement argument written encrypted at rest
+type sealedField struct {
+	field string
+	value string
+}
+
+// Value implements driver.Valuer
+func (f sealedField) Value() (driver.Value, error) {
+	return sealField(f.field, f.value)
+}
+
+// openedField is a scan destination decrypting the column into the string
+type openedField struct {
+	field string
+	dest  *string
+}
+
+// Scan implements sql.Scanner, a NULL column is scanned as an empty string
+func (f openedField) Scan(src interface{}) error {
+	var value string
+	switch v := src.(type) {
+	case nil:
+		value = ""
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("can not scan %T into %s", src, f.field)
+	}
+	plain, err := openField(f.field, value)
+	if err != nil {
+		return err
+	}
+	*f.dest = plain
+	return nil
+}
@@ -0,0 +1,117 @@
+package connector
+
+import (
+	"context"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// ListAncestorGroups list all the ancestors of a group, starting from its direct parent up to the root group.
+func ListAncestorGroups(ctx context.Context, repo GroupRepository, group *Group) ([]*Group, error) {
+	ret := make([]*Group, 0)
+	visited := map[string]bool{group.RecID: true}
+	current := group
+	for {
+		parent, err := repo.GetParentGroup(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil || visited[parent.RecID] {
+			return ret, nil
+		}
+		visited[parent.RecID] = true
+		ret = append(ret, parent)
+		current = parent
+	}
+}
+
+// ListDescendantGroups list all the sub-groups of a group, including the sub-groups of its sub-groups.
+func ListDescendantGroups(ctx context.Context, repo GroupRepository, group *Group) ([]*Group, error) {
+	ret := make([]*Group, 0)
+	visited := map[string]bool{group.RecID: true}
+	queue := []*Group{group}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		children, err := repo.ListChildGroups(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if !visited[child.RecID] {
+				visited[child.RecID] = true
+				ret = append(ret, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// ValidateParentGroup makes sure that setting parent as the parent of group will not create a cycle in the group tree.
+func ValidateParentGroup(ctx context.Context, repo GroupRepository, group, parent *Group) error {
+	if group.RecID == parent.RecID {
+		return &ErrGroupCycle{GroupRecID: group.RecID, ParentRecID: parent.RecID}
+	}
+	ancestors, err := ListAncestorGroups(ctx, repo, parent)
+	if err != nil {
+		return err
+	}
+	for _, ancestor := range ancestors {
+		if ancestor.RecID == group.RecID {
+			return &ErrGroupCycle{GroupRecID: group.RecID, ParentRecID: parent.RecID}
+		}
+	}
+	return nil
+}
+
+// ResolveEffectiveGroups returns the specified groups together with all of their ancestors, without duplicate.
+// A member of a group is also an effective member of all of the group's ancestors.
+func ResolveEffectiveGroups(ctx context.Context, repo GroupRepository, groups []*Group) ([]*Group, error) {
+	ret := make([]*Group, 0)
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		if !seen[group.RecID] {
+			seen[group.RecID] = true
+			ret = append(ret, group)
+		}
+		ancestors, err := ListAncestorGroups(ctx, repo, group)
+		if err != nil {
+			return nil, err
+		}
+		for _, ancestor := range ancestors {
+			if !seen[ancestor.RecID] {
+				seen[ancestor.RecID] = true
+				ret = append(ret, ancestor)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// ResolveEffectiveGroupRoles returns all roles of the specified groups and their ancestors, without duplicate.
+func ResolveEffectiveGroupRoles(ctx context.Context, groupRepo GroupRepository, groupRoleRepo GroupRoleRepository, groups []*Group) ([]*Role, error) {
+	effectiveGroups, err := ResolveEffectiveGroups(ctx, groupRepo, groups)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Role, 0)
+	seen := make(map[string]bool)
+	for _, group := range effectiveGroups {
+		roles, _, err := groupRoleRepo.ListGroupRoleByGroup(ctx, group, &helper.PageRequest{
+			No:       1,
+			PageSize: 1000,
+			OrderBy:  "ROLE_NAME",
+			Sort:     "ASC",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if !seen[role.RecID] {
+				seen[role.RecID] = true
+				ret = append(ret, role)
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,111 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type treeGroupRepo struct {
+	GroupRepository
+	parents map[string]*Group
+}
+
+func (repo *treeGroupRepo) GetParentGroup(ctx context.Context, group *Group) (*Group, error) {
+	return repo.parents[group.RecID], nil
+}
+
+func (repo *treeGroupRepo) SetParentGroup(ctx context.Context, group, parent *Group) error {
+	if err := ValidateParentGroup(ctx, repo, group, parent); err != nil {
+		return err
+	}
+	repo.parents[group.RecID] = parent
+	return nil
+}
+
+func (repo *treeGroupRepo) ListChildGroups(ctx context.Context, group *Group) ([]*Group, error) {
+	ret := make([]*Group, 0)
+	for childID, parent := range repo.parents {
+		if parent.RecID == group.RecID {
+			ret = append(ret, &Group{RecID: childID})
+		}
+	}
+	return ret, nil
+}
+
+type treeGroupRoleRepo struct {
+	GroupRoleRepository
+	roles map[string][]*Role
+}
+
+func (repo *treeGroupRoleRepo) ListGroupRoleByGroup(ctx context.Context, group *Group, request *helper.PageRequest) ([]*Role, *helper.Page, error) {
+	return repo.roles[group.RecID], nil, nil
+}
+
+func hasRole(roles []*Role, name string) bool {
+	for _, r := range roles {
+		if r.RoleName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGroupTreeRoleInheritance(t *testing.T) {
+	ctx := context.Background()
+	company := &Group{RecID: "company"}
+	department := &Group{RecID: "department"}
+	team := &Group{RecID: "team"}
+
+	groupRepo := &treeGroupRepo{parents: make(map[string]*Group)}
+	if err := groupRepo.SetParentGroup(ctx, department, company); err != nil {
+		t.Fatal(err)
+	}
+	if err := groupRepo.SetParentGroup(ctx, team, department); err != nil {
+		t.Fatal(err)
+	}
+
+	roleRepo := &treeGroupRoleRepo{roles: map[string][]*Role{
+		"company":    {{RecID: "r1", RoleName: "employee"}},
+		"department": {{RecID: "r2", RoleName: "engineer"}},
+		"team":       {{RecID: "r3", RoleName: "deployer"}},
+	}}
+
+	// member of team inherits roles of department and company.
+	roles, err := ResolveEffectiveGroupRoles(ctx, groupRepo, roleRepo, []*Group{team})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 3 || !hasRole(roles, "employee") || !hasRole(roles, "engineer") || !hasRole(roles, "deployer") {
+		t.Errorf("team member should have all 3 roles. got %d", len(roles))
+	}
+
+	// member of department does not get roles of its sub-group.
+	roles, err = ResolveEffectiveGroupRoles(ctx, groupRepo, roleRepo, []*Group{department})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 || hasRole(roles, "deployer") {
+		t.Errorf("department member should only have employee and engineer role. got %d", len(roles))
+	}
+
+	descendants, err := ListDescendantGroups(ctx, groupRepo, company)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descendants) != 2 {
+		t.Errorf("company should have 2 descendants. got %d", len(descendants))
+	}
+
+	// making company a child of team should be rejected.
+	err = groupRepo.SetParentGroup(ctx, company, team)
+	cycleErr := &ErrGroupCycle{}
+	if !errors.As(err, &cycleErr) {
+		t.Errorf("expect cycle error")
+	}
+	if err := groupRepo.SetParentGroup(ctx, team, team); err == nil {
+		t.Errorf("expect error when group is its own parent")
+	}
+}
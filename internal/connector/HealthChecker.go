@@ -0,0 +1,68 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+const (
+	// healthCheckDialTimeout is the maximum time to wait for a connection when checking a remote service.
+	healthCheckDialTimeout = 5 * time.Second
+)
+
+// HealthChecker is implemented by connector that can verify its connectivity.
+type HealthChecker interface {
+	// CheckHealth returns an error if the connector can not reach the service it connects to.
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckHealth ping the database
+func (db *MySQLDB) CheckHealth(ctx context.Context) error {
+	return db.instance.PingContext(ctx)
+}
+
+// CheckHealth ping the database
+func (db *SqliteDB) CheckHealth(ctx context.Context) error {
+	return db.instance.PingContext(ctx)
+}
+
+// CheckHealth of dummy mail sender is always healthy
+func (sender *DummyMailSender) CheckHealth(ctx context.Context) error {
+	return nil
+}
+
+// CheckHealth connects to the SMTP server and issue a NOOP command.
+func (sender *SendMailSender) CheckHealth(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: healthCheckDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", sender.Host, sender.Port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(healthCheckDialTimeout))
+	}
+	client, err := smtp.NewClient(conn, sender.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if err := client.Noop(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// CheckHealth of sendgrid mail sender only verify that the token is configured,
+// so readiness probes do not consume the sendgrid API quota.
+func (sender *SendGridSender) CheckHealth(ctx context.Context) error {
+	if len(sender.Token) == 0 {
+		return fmt.Errorf("sendgrid mailer with no token configured")
+	}
+	return nil
+}
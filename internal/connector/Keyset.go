@@ -0,0 +1,9 @@
+package connector
+
+// keysetOperator returns the comparison selecting the rows after the cursor key in the sort order, ASC or DESC.
+func keysetOperator(sort string) string {
+	if sort == "DESC" {
+		return "<"
+	}
+	return ">"
+}
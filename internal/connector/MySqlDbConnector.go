@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -17,14 +18,13 @@ import (
 	"github.com/hyperjumptech/hansip/internal/config"
 	"github.com/hyperjumptech/hansip/internal/constants"
 	"github.com/hyperjumptech/hansip/pkg/helper"
-	"github.com/hyperjumptech/hansip/pkg/totp"
+	"github.com/hyperjumptech/jiffy"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	// DropAllMySQL contains SQL to drop all existing table for hansip
-	DropAllMySQL = `DROP TABLE IF EXISTS HANSIP_REVOCATION, HANSIP_TOTP_RECOVERY_CODES, HANSIP_USER_GROUP, HANSIP_USER_ROLE, HANSIP_GROUP_ROLE, HANSIP_USER, HANSIP_GROUP, HANSIP_ROLE, HANSIP_TENANT;`
+	DropAllMySQL = `DROP TABLE IF EXISTS HANSIP_TENANT_EMAIL_DOMAIN, HANSIP_CONSUMED_TOKEN, HANSIP_USER_EMAIL_VERIFICATION, HANSIP_TENANT_SUSPENSION, HANSIP_WEBAUTHN_CREDENTIAL, HANSIP_IDEMPOTENCY_KEY, HANSIP_TENANT_BRANDING, HANSIP_TENANT_REGION, HANSIP_OPAQUE_TOKEN, HANSIP_USER_DEACTIVATION, HANSIP_PASSPHRASE_CHANGE, HANSIP_PASSPHRASE_HISTORY, HANSIP_AUDIT, HANSIP_GROUP_PARENT, HANSIP_REVOCATION, HANSIP_TOTP_RECOVERY_CODES, HANSIP_USER_GROUP, HANSIP_USER_ROLE, HANSIP_GROUP_ROLE, HANSIP_USER, HANSIP_GROUP, HANSIP_ROLE, HANSIP_TENANT;`
 
 	// CreateTenantMySQL contains SQL to create HANSIP_ROLE table
 	CreateTenantMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT (
@@ -113,6 +113,130 @@ const (
     SUBJECT VARCHAR(128) NOT NULL UNIQUE,
     ACTIVATION_DATE DATETIME,
     PRIMARY KEY (SUBJECT)
+) ENGINE=INNODB;`
+	// CreateGroupParentMySQL contains SQL to create HANSIP_GROUP_PARENT table
+	CreateGroupParentMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_GROUP_PARENT (
+    GROUP_REC_ID VARCHAR(32) NOT NULL,
+    PARENT_REC_ID VARCHAR(32) NOT NULL,
+    PRIMARY KEY (GROUP_REC_ID),
+    INDEX (PARENT_REC_ID),
+    FOREIGN KEY (GROUP_REC_ID) REFERENCES HANSIP_GROUP(REC_ID) ON DELETE CASCADE,
+    FOREIGN KEY (PARENT_REC_ID) REFERENCES HANSIP_GROUP(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateAuditMySQL contains SQL to create HANSIP_AUDIT table
+	CreateAuditMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_AUDIT (
+    REC_ID VARCHAR(32) NOT NULL UNIQUE,
+    EVENT_TIME DATETIME NOT NULL,
+    EVENT_TYPE VARCHAR(64) NOT NULL,
+    ACTOR VARCHAR(128),
+    TARGET VARCHAR(128),
+    DETAIL VARCHAR(1024),
+    REQUEST_ID VARCHAR(64),
+    INDEX (EVENT_TIME),
+    PRIMARY KEY (REC_ID)
+) ENGINE=INNODB;`
+	// CreatePassphraseHistoryMySQL contains SQL to create HANSIP_PASSPHRASE_HISTORY table
+	CreatePassphraseHistoryMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_PASSPHRASE_HISTORY (
+    REC_ID VARCHAR(32) NOT NULL UNIQUE,
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    HASHED_PASSPHRASE VARCHAR(128) NOT NULL,
+    CREATED_AT DATETIME NOT NULL,
+    PRIMARY KEY (REC_ID),
+    INDEX (USER_REC_ID, CREATED_AT),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreatePassphraseChangeMySQL contains SQL to create HANSIP_PASSPHRASE_CHANGE table
+	CreatePassphraseChangeMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_PASSPHRASE_CHANGE (
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    PASSPHRASE_CHANGED_AT DATETIME NOT NULL,
+    PRIMARY KEY (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateUserDeactivationMySQL contains SQL to create HANSIP_USER_DEACTIVATION table
+	CreateUserDeactivationMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_USER_DEACTIVATION (
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    DEACTIVATED_AT DATETIME NOT NULL,
+    PRIMARY KEY (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateUserEmailVerificationMySQL contains SQL to create HANSIP_USER_EMAIL_VERIFICATION table
+	CreateUserEmailVerificationMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_USER_EMAIL_VERIFICATION (
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    VERIFIED_AT DATETIME NOT NULL,
+    PRIMARY KEY (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateOpaqueTokenMySQL contains SQL to create HANSIP_OPAQUE_TOKEN table
+	CreateOpaqueTokenMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_OPAQUE_TOKEN (
+    TOKEN VARCHAR(64) NOT NULL UNIQUE,
+    SUBJECT VARCHAR(128) NOT NULL,
+    CLAIMS TEXT NOT NULL,
+    EXPIRE DATETIME NOT NULL,
+    INDEX (SUBJECT),
+    INDEX (EXPIRE),
+    PRIMARY KEY (TOKEN)
+) ENGINE=INNODB;`
+	// CreateTenantRegionMySQL contains SQL to create HANSIP_TENANT_REGION table
+	CreateTenantRegionMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_REGION (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    REGION VARCHAR(64) NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateTenantSuspensionMySQL contains SQL to create HANSIP_TENANT_SUSPENSION table
+	CreateTenantSuspensionMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_SUSPENSION (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    SUSPENDED_AT DATETIME NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateTenantBrandingMySQL contains SQL to create HANSIP_TENANT_BRANDING table
+	CreateTenantBrandingMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_BRANDING (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    PRODUCT_NAME VARCHAR(128) NOT NULL,
+    LOGO_URL VARCHAR(255) NOT NULL,
+    SUPPORT_EMAIL VARCHAR(128) NOT NULL,
+    PRIMARY_COLOR VARCHAR(32) NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateIdempotencyKeyMySQL contains SQL to create HANSIP_IDEMPOTENCY_KEY table
+	CreateIdempotencyKeyMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_IDEMPOTENCY_KEY (
+    IDEMPOTENCY_KEY VARCHAR(64) NOT NULL,
+    REQUEST_HASH VARCHAR(64) NOT NULL,
+    STATUS_CODE INT NOT NULL,
+    CONTENT_TYPE VARCHAR(128) NOT NULL,
+    BODY MEDIUMTEXT NOT NULL,
+    EXPIRE DATETIME NOT NULL,
+    INDEX (EXPIRE),
+    PRIMARY KEY (IDEMPOTENCY_KEY)
+) ENGINE=INNODB;`
+	// CreateWebAuthnCredentialMySQL contains SQL to create HANSIP_WEBAUTHN_CREDENTIAL table
+	CreateWebAuthnCredentialMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_WEBAUTHN_CREDENTIAL (
+    REC_ID VARCHAR(32) NOT NULL UNIQUE,
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    CREDENTIAL_NAME VARCHAR(128) NOT NULL,
+    CREDENTIAL_ID VARCHAR(1400) NOT NULL,
+    CREDENTIAL TEXT NOT NULL,
+    CREATED_AT DATETIME NOT NULL,
+    LAST_USED_AT DATETIME NOT NULL,
+    PRIMARY KEY (REC_ID),
+    INDEX (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+) ENGINE=INNODB;`
+	// CreateConsumedTokenMySQL contains SQL to create HANSIP_CONSUMED_TOKEN table
+	CreateConsumedTokenMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_CONSUMED_TOKEN (
+    TOKEN_ID VARCHAR(64) NOT NULL,
+    EXPIRE DATETIME NOT NULL,
+    INDEX (EXPIRE),
+    PRIMARY KEY (TOKEN_ID)
+) ENGINE=INNODB;`
+	// CreateTenantEmailDomainMySQL contains SQL to create HANSIP_TENANT_EMAIL_DOMAIN table
+	CreateTenantEmailDomainMySQL = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_EMAIL_DOMAIN (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    EMAIL_DOMAIN VARCHAR(255) NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID,EMAIL_DOMAIN),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
 ) ENGINE=INNODB;`
 )
 
@@ -126,30 +250,86 @@ var (
 // GetMySQLDBInstance will obtain the singleton instance to MySQLDB
 func GetMySQLDBInstance() *MySQLDB {
 	if mySQLDBInstance == nil {
-		host := config.Get("db.mysql.host")
-		port := config.GetInt("db.mysql.port")
-		user := config.Get("db.mysql.user")
-		password := config.Get("db.mysql.password")
-		database := config.Get("db.mysql.database")
-		db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", user, password, host, port, database))
+		db, err := openRotatingDB(config.Get("db.mysql.driver"), mySQLDataSourceName, "mysql")
 		if err != nil {
-			mysqlLog.WithField("func", "GetMySQLDBInstance").Fatalf("sql.Open got %s", err.Error())
+			mysqlLog.WithField("func", "GetMySQLDBInstance").Fatalf("openRotatingDB got %s", err.Error())
 		}
 
 		db.SetMaxOpenConns(config.GetInt("db.pool.maxopen"))
 		db.SetMaxIdleConns(config.GetInt("db.pool.maxidle"))
+		config.OnSecretChange(refreshPoolOnSecretChange(db, config.GetInt("db.pool.maxidle"), "db.mysql.", "db.tls."))
 
-		mySQLDBInstance = &MySQLDB{
+		retryInterval, err := jiffy.DurationOf(config.Get("db.connect.retry.interval"))
+		if err != nil {
+			mysqlLog.WithField("func", "GetMySQLDBInstance").Fatalf("invalid db.connect.retry.interval %s. got %s", config.Get("db.connect.retry.interval"), err.Error())
+		}
+
+		instance := &MySQLDB{
 			instance: db,
 		}
-		err = mySQLDBInstance.InitDB(context.Background())
+		err = ConnectWithRetry(context.Background(), config.GetInt("db.connect.retries"), retryInterval, func(ctx context.Context) error {
+			if err := db.PingContext(ctx); err != nil {
+				return err
+			}
+			return instance.InitDB(ctx)
+		})
 		if err != nil {
 			mysqlLog.WithField("func", "GetMySQLDBInstance").Fatalf("mySQLDBInstance.InitDB got %s", err.Error())
 		}
+		mySQLDBInstance = instance
 	}
 	return mySQLDBInstance
 }
 
+// mySQLDataSourceName returns db.mysql.dsn as is if it is set, otherwise builds the data source name
+// from the discrete db.mysql fields and the db.tls settings. Either way, the data source name must be valid in the db.mysql.dsn.format expected by the db.mysql.driver.
+func mySQLDataSourceName() (string, error) {
+	format := config.Get("db.mysql.dsn.format")
+	dsn := config.Get("db.mysql.dsn")
+	if len(dsn) == 0 {
+		params := config.Get("db.mysql.params")
+		tlsParams, err := dbTLSParams(format, config.Get("db.mysql.host"))
+		if err != nil {
+			return "", err
+		}
+		if len(tlsParams) > 0 && len(params) > 0 {
+			params = params + "&" + tlsParams
+		} else if len(tlsParams) > 0 {
+			params = tlsParams
+		}
+		built, err := DataSourceName(format, "mysql", &DataSourceConfig{
+			Host:     config.Get("db.mysql.host"),
+			Port:     config.GetInt("db.mysql.port"),
+			User:     config.Get("db.mysql.user"),
+			Password: config.Get("db.mysql.password"),
+			Database: config.Get("db.mysql.database"),
+			Params:   params,
+		})
+		if err != nil {
+			return "", err
+		}
+		dsn = built
+	}
+	if err := ValidateDataSourceName(format, dsn); err != nil {
+		return "", err
+	}
+	return dsn, nil
+}
+
+// CheckMySQLConnectivity opens a new connection to the configured MySQL database and ping it, without initializing the tables.
+func CheckMySQLConnectivity(ctx context.Context) error {
+	dsn, err := mySQLDataSourceName()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open(config.Get("db.mysql.driver"), dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.PingContext(ctx)
+}
+
 // MySQLDB is a struct to hold sql.DB pointer
 type MySQLDB struct {
 	instance *sql.DB
@@ -169,7 +349,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_TENANT")
-		_, err := db.instance.ExecContext(ctx, CreateTenantMySQL)
+		_, err := db.execContext(ctx, CreateTenantMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT Got %s. SQL = %s", err.Error(), CreateTenantMySQL)
 		}
@@ -182,7 +362,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_USER")
-		_, err := db.instance.ExecContext(ctx, CreateUserMySQL)
+		_, err := db.execContext(ctx, CreateUserMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_USER Got %s. SQL = %s", err.Error(), CreateUserMySQL)
 		}
@@ -195,7 +375,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_GROUP")
-		_, err := db.instance.ExecContext(ctx, CreateGroupMySQL)
+		_, err := db.execContext(ctx, CreateGroupMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_GROUP Got %s. SQL = %s", err.Error(), CreateGroupMySQL)
 		}
@@ -208,7 +388,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_ROLE")
-		_, err := db.instance.ExecContext(ctx, CreateRoleMySQL)
+		_, err := db.execContext(ctx, CreateRoleMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_ROLE Got %s. SQL = %s", err.Error(), CreateRoleMySQL)
 		}
@@ -221,7 +401,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_USER_ROLE")
-		_, err := db.instance.ExecContext(ctx, CreateUserRoleMySQL)
+		_, err := db.execContext(ctx, CreateUserRoleMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_USER_ROLE Got %s. SQL = %s", err.Error(), CreateUserRoleMySQL)
 		}
@@ -234,7 +414,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_USER_GROUP")
-		_, err := db.instance.ExecContext(ctx, CreateUserGroupMySQL)
+		_, err := db.execContext(ctx, CreateUserGroupMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_USER_GROUP Got %s. SQL = %s", err.Error(), CreateUserGroupMySQL)
 		}
@@ -247,7 +427,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_GROUP_ROLE")
-		_, err := db.instance.ExecContext(ctx, CreateGroupRoleMySQL)
+		_, err := db.execContext(ctx, CreateGroupRoleMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_GROUP_ROLE Got %s. SQL = %s", err.Error(), CreateGroupRoleMySQL)
 		}
@@ -260,7 +440,7 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_TOTP_RECOVERY_CODES")
-		_, err := db.instance.ExecContext(ctx, CreateTOTPRecoveryCodeMySQL)
+		_, err := db.execContext(ctx, CreateTOTPRecoveryCodeMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_TOTP_RECOVERY_CODES Got %s. SQL = %s", err.Error(), CreateTOTPRecoveryCodeMySQL)
 		}
@@ -273,12 +453,194 @@ func (db *MySQLDB) InitDB(ctx context.Context) error {
 	}
 	if !exist {
 		fLog.Infof("Create table HANSIP_REVOCATION")
-		_, err := db.instance.ExecContext(ctx, CreateRevocationMySQL)
+		_, err := db.execContext(ctx, CreateRevocationMySQL)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext HANSIP_REVOCATION Got %s. SQL = %s", err.Error(), CreateRevocationMySQL)
 		}
 	}
 
+	fLog.Infof("Checking table HANSIP_GROUP_PARENT")
+	exist, err = db.isTableExist(ctx, "HANSIP_GROUP_PARENT")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_GROUP_PARENT")
+		_, err := db.execContext(ctx, CreateGroupParentMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_GROUP_PARENT Got %s. SQL = %s", err.Error(), CreateGroupParentMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_AUDIT")
+	exist, err = db.isTableExist(ctx, "HANSIP_AUDIT")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_AUDIT")
+		_, err := db.execContext(ctx, CreateAuditMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_AUDIT Got %s. SQL = %s", err.Error(), CreateAuditMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_PASSPHRASE_HISTORY")
+	exist, err = db.isTableExist(ctx, "HANSIP_PASSPHRASE_HISTORY")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_PASSPHRASE_HISTORY")
+		_, err := db.execContext(ctx, CreatePassphraseHistoryMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_HISTORY Got %s. SQL = %s", err.Error(), CreatePassphraseHistoryMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_PASSPHRASE_CHANGE")
+	exist, err = db.isTableExist(ctx, "HANSIP_PASSPHRASE_CHANGE")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_PASSPHRASE_CHANGE")
+		_, err := db.execContext(ctx, CreatePassphraseChangeMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_CHANGE Got %s. SQL = %s", err.Error(), CreatePassphraseChangeMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_USER_DEACTIVATION")
+	exist, err = db.isTableExist(ctx, "HANSIP_USER_DEACTIVATION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_USER_DEACTIVATION")
+		_, err := db.execContext(ctx, CreateUserDeactivationMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_USER_DEACTIVATION Got %s. SQL = %s", err.Error(), CreateUserDeactivationMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_USER_EMAIL_VERIFICATION")
+	exist, err = db.isTableExist(ctx, "HANSIP_USER_EMAIL_VERIFICATION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_USER_EMAIL_VERIFICATION")
+		_, err := db.execContext(ctx, CreateUserEmailVerificationMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_USER_EMAIL_VERIFICATION Got %s. SQL = %s", err.Error(), CreateUserEmailVerificationMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_OPAQUE_TOKEN")
+	exist, err = db.isTableExist(ctx, "HANSIP_OPAQUE_TOKEN")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_OPAQUE_TOKEN")
+		_, err := db.execContext(ctx, CreateOpaqueTokenMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_OPAQUE_TOKEN Got %s. SQL = %s", err.Error(), CreateOpaqueTokenMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_REGION")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_REGION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_REGION")
+		_, err := db.instance.ExecContext(ctx, CreateTenantRegionMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_REGION Got %s. SQL = %s", err.Error(), CreateTenantRegionMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_SUSPENSION")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_SUSPENSION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_SUSPENSION")
+		_, err := db.instance.ExecContext(ctx, CreateTenantSuspensionMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_SUSPENSION Got %s. SQL = %s", err.Error(), CreateTenantSuspensionMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_BRANDING")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_BRANDING")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_BRANDING")
+		_, err := db.instance.ExecContext(ctx, CreateTenantBrandingMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_BRANDING Got %s. SQL = %s", err.Error(), CreateTenantBrandingMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_IDEMPOTENCY_KEY")
+	exist, err = db.isTableExist(ctx, "HANSIP_IDEMPOTENCY_KEY")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_IDEMPOTENCY_KEY")
+		_, err := db.instance.ExecContext(ctx, CreateIdempotencyKeyMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_IDEMPOTENCY_KEY Got %s. SQL = %s", err.Error(), CreateIdempotencyKeyMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_WEBAUTHN_CREDENTIAL")
+	exist, err = db.isTableExist(ctx, "HANSIP_WEBAUTHN_CREDENTIAL")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_WEBAUTHN_CREDENTIAL")
+		_, err := db.instance.ExecContext(ctx, CreateWebAuthnCredentialMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_WEBAUTHN_CREDENTIAL Got %s. SQL = %s", err.Error(), CreateWebAuthnCredentialMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_CONSUMED_TOKEN")
+	exist, err = db.isTableExist(ctx, "HANSIP_CONSUMED_TOKEN")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_CONSUMED_TOKEN")
+		_, err := db.instance.ExecContext(ctx, CreateConsumedTokenMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_CONSUMED_TOKEN Got %s. SQL = %s", err.Error(), CreateConsumedTokenMySQL)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_EMAIL_DOMAIN")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_EMAIL_DOMAIN")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_EMAIL_DOMAIN")
+		_, err := db.instance.ExecContext(ctx, CreateTenantEmailDomainMySQL)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_EMAIL_DOMAIN Got %s. SQL = %s", err.Error(), CreateTenantEmailDomainMySQL)
+		}
+	}
+
 	hansipDomain := config.Get("hansip.domain")
 	handipAdmin := config.Get("hansip.admin")
 
@@ -390,7 +752,7 @@ func (db *MySQLDB) isTableExist(ctx context.Context, tableName string) (bool, er
 
 // DropAllTables will drop all tables used by Hansip
 func (db *MySQLDB) DropAllTables(ctx context.Context) error {
-	_, err := db.instance.ExecContext(ctx, DropAllMySQL)
+	_, err := db.execContext(ctx, DropAllMySQL)
 	if err != nil {
 		mysqlLog.WithField("func", "DropAllTables").WithField("RequestID", ctx.Value(constants.RequestID)).Errorf("got %s, SQL = %s", err.Error(), DropAllMySQL)
 		return &ErrDBExecuteError{
@@ -409,7 +771,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 	hansipDomain := config.Get("hansip.domain")
 	hansipAdmin := config.Get("hansip.admin")
 
-	_, err := db.instance.ExecContext(ctx, CreateTenantMySQL)
+	_, err := db.execContext(ctx, CreateTenantMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT Got %s. SQL = %s", err.Error(), CreateTenantMySQL)
 		return &ErrDBExecuteError{
@@ -423,7 +785,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 		fLog.Errorf("db.CreateTenantRecord Got %s", err.Error())
 		return err
 	}
-	_, err = db.instance.ExecContext(ctx, CreateUserMySQL)
+	_, err = db.execContext(ctx, CreateUserMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_USER Got %s. SQL = %s", err.Error(), CreateUserMySQL)
 		return &ErrDBExecuteError{
@@ -432,7 +794,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateUserMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateGroupMySQL)
+	_, err = db.execContext(ctx, CreateGroupMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_GROUP Got %s. SQL = %s", err.Error(), CreateGroupMySQL)
 		return &ErrDBExecuteError{
@@ -441,7 +803,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateGroupMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateRoleMySQL)
+	_, err = db.execContext(ctx, CreateRoleMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_ROLE Got %s. SQL = %s", err.Error(), CreateRoleMySQL)
 		return &ErrDBExecuteError{
@@ -450,7 +812,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateRoleMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateUserRoleMySQL)
+	_, err = db.execContext(ctx, CreateUserRoleMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_USER_ROLE Got %s. SQL = %s", err.Error(), CreateUserRoleMySQL)
 		return &ErrDBExecuteError{
@@ -459,7 +821,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateUserRoleMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateUserGroupMySQL)
+	_, err = db.execContext(ctx, CreateUserGroupMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_USER_GROUP Got %s. SQL = %s", err.Error(), CreateUserGroupMySQL)
 		return &ErrDBExecuteError{
@@ -468,7 +830,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateUserGroupMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateGroupRoleMySQL)
+	_, err = db.execContext(ctx, CreateGroupRoleMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_GROUP_ROLE Got %s. SQL = %s", err.Error(), CreateGroupRoleMySQL)
 		return &ErrDBExecuteError{
@@ -477,7 +839,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateGroupRoleMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateTOTPRecoveryCodeMySQL)
+	_, err = db.execContext(ctx, CreateTOTPRecoveryCodeMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_TOTP_RECOVERY_CODES Got %s. SQL = %s", err.Error(), CreateTOTPRecoveryCodeMySQL)
 		return &ErrDBExecuteError{
@@ -486,7 +848,7 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateTOTPRecoveryCodeMySQL,
 		}
 	}
-	_, err = db.instance.ExecContext(ctx, CreateRevocationMySQL)
+	_, err = db.execContext(ctx, CreateRevocationMySQL)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext HANSIP_REVOCATION Got %s. SQL = %s", err.Error(), CreateRevocationMySQL)
 		return &ErrDBExecuteError{
@@ -495,6 +857,132 @@ func (db *MySQLDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateRevocationMySQL,
 		}
 	}
+	_, err = db.execContext(ctx, CreateGroupParentMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_GROUP_PARENT Got %s. SQL = %s", err.Error(), CreateGroupParentMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_GROUP_PARENT",
+			SQL:     CreateGroupParentMySQL,
+		}
+	}
+	_, err = db.execContext(ctx, CreateAuditMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_AUDIT Got %s. SQL = %s", err.Error(), CreateAuditMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_AUDIT",
+			SQL:     CreateAuditMySQL,
+		}
+	}
+	_, err = db.execContext(ctx, CreatePassphraseHistoryMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_HISTORY Got %s. SQL = %s", err.Error(), CreatePassphraseHistoryMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_PASSPHRASE_HISTORY",
+			SQL:     CreatePassphraseHistoryMySQL,
+		}
+	}
+	_, err = db.execContext(ctx, CreatePassphraseChangeMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_CHANGE Got %s. SQL = %s", err.Error(), CreatePassphraseChangeMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_PASSPHRASE_CHANGE",
+			SQL:     CreatePassphraseChangeMySQL,
+		}
+	}
+	_, err = db.execContext(ctx, CreateUserDeactivationMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_USER_DEACTIVATION Got %s. SQL = %s", err.Error(), CreateUserDeactivationMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_USER_DEACTIVATION",
+			SQL:     CreateUserDeactivationMySQL,
+		}
+	}
+	_, err = db.execContext(ctx, CreateUserEmailVerificationMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_USER_EMAIL_VERIFICATION Got %s. SQL = %s", err.Error(), CreateUserEmailVerificationMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_USER_EMAIL_VERIFICATION",
+			SQL:     CreateUserEmailVerificationMySQL,
+		}
+	}
+	_, err = db.execContext(ctx, CreateOpaqueTokenMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_OPAQUE_TOKEN Got %s. SQL = %s", err.Error(), CreateOpaqueTokenMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_OPAQUE_TOKEN",
+			SQL:     CreateOpaqueTokenMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantRegionMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_REGION Got %s. SQL = %s", err.Error(), CreateTenantRegionMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_REGION",
+			SQL:     CreateTenantRegionMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantSuspensionMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_SUSPENSION Got %s. SQL = %s", err.Error(), CreateTenantSuspensionMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_SUSPENSION",
+			SQL:     CreateTenantSuspensionMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantBrandingMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_BRANDING Got %s. SQL = %s", err.Error(), CreateTenantBrandingMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_BRANDING",
+			SQL:     CreateTenantBrandingMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateIdempotencyKeyMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_IDEMPOTENCY_KEY Got %s. SQL = %s", err.Error(), CreateIdempotencyKeyMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_IDEMPOTENCY_KEY",
+			SQL:     CreateIdempotencyKeyMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateWebAuthnCredentialMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_WEBAUTHN_CREDENTIAL Got %s. SQL = %s", err.Error(), CreateWebAuthnCredentialMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_WEBAUTHN_CREDENTIAL",
+			SQL:     CreateWebAuthnCredentialMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateConsumedTokenMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_CONSUMED_TOKEN Got %s. SQL = %s", err.Error(), CreateConsumedTokenMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_CONSUMED_TOKEN",
+			SQL:     CreateConsumedTokenMySQL,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantEmailDomainMySQL)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_EMAIL_DOMAIN Got %s. SQL = %s", err.Error(), CreateTenantEmailDomainMySQL)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_EMAIL_DOMAIN",
+			SQL:     CreateTenantEmailDomainMySQL,
+		}
+	}
 	_, err = db.CreateRole(ctx, hansipAdmin, hansipDomain, "Administrator role")
 	if err != nil {
 		fLog.Errorf("db.CreateRole Got %s", err.Error())
@@ -549,7 +1037,7 @@ func (db *MySQLDB) GetTenantByRecID(ctx context.Context, recID string) (*Tenant,
 func (db *MySQLDB) CreateTenantRecord(ctx context.Context, tenantName, tenantDomain, description string) (*Tenant, error) {
 	fLog := mysqlLog.WithField("func", "CreateTenantRecord").WithField("RequestID", ctx.Value(constants.RequestID))
 	tenant := &Tenant{
-		RecID:       helper.MakeRandomString(10, true, true, true, false),
+		RecID:       NewRecID(),
 		Name:        tenantName,
 		Domain:      tenantDomain,
 		Description: description,
@@ -557,7 +1045,7 @@ func (db *MySQLDB) CreateTenantRecord(ctx context.Context, tenantName, tenantDom
 
 	q := "INSERT INTO HANSIP_TENANT(REC_ID,TENANT_NAME, TENANT_DOMAIN, DESCRIPTION) VALUES(?,?,?,?)"
 
-	_, err := db.instance.ExecContext(ctx, q,
+	_, err := db.execContext(ctx, q,
 		tenant.RecID, tenant.Name, tenant.Domain, tenant.Description)
 
 	if err != nil {
@@ -576,7 +1064,7 @@ func (db *MySQLDB) CreateTenantRecord(ctx context.Context, tenantName, tenantDom
 func (db *MySQLDB) DeleteTenant(ctx context.Context, tenant *Tenant) error {
 	fLog := mysqlLog.WithField("func", "DeleteTenant").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_TENANT WHERE REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, tenant.RecID)
+	_, err := db.execContext(ctx, q, tenant.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -590,7 +1078,7 @@ func (db *MySQLDB) DeleteTenant(ctx context.Context, tenant *Tenant) error {
 
 	// delete all user-roles ...
 	q = "DELETE FROM HANSIP_USER_ROLE WHERE HANSIP_USER_ROLE.ROLE_REC_ID = HANSIP_ROLE.REC_ID AND HANSIP_ROLE.ROLE_DOMAIN = ?"
-	_, err = db.instance.ExecContext(ctx, q, domainToDelete)
+	_, err = db.execContext(ctx, q, domainToDelete)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -602,7 +1090,7 @@ func (db *MySQLDB) DeleteTenant(ctx context.Context, tenant *Tenant) error {
 
 	// delete all group-roles ...
 	q = "DELETE FROM HANSIP_GROUP_ROLE WHERE HANSIP_GROUP_ROLE.GROUP_REC_ID = HANSIP_GROUP.REC_ID AND HANSIP_GROUP.GROUP_DOMAIN = ?"
-	_, err = db.instance.ExecContext(ctx, q, domainToDelete)
+	_, err = db.execContext(ctx, q, domainToDelete)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -614,7 +1102,7 @@ func (db *MySQLDB) DeleteTenant(ctx context.Context, tenant *Tenant) error {
 
 	// delete all user-groups ...
 	q = "DELETE FROM HANSIP_USER_GROUP WHERE HANSIP_USER_GROUP.GROUP_REC_ID = HANSIP_GROUP.REC_ID AND HANSIP_GROUP.GROUP_DOMAIN = ?"
-	_, err = db.instance.ExecContext(ctx, q, domainToDelete)
+	_, err = db.execContext(ctx, q, domainToDelete)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -626,7 +1114,7 @@ func (db *MySQLDB) DeleteTenant(ctx context.Context, tenant *Tenant) error {
 
 	// delete all groups ...
 	q = "DELETE FROM HANSIP_GROUP WHERE HANSIP_GROUP.GROUP_DOMAIN = ?"
-	_, err = db.instance.ExecContext(ctx, q, domainToDelete)
+	_, err = db.execContext(ctx, q, domainToDelete)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -638,7 +1126,7 @@ func (db *MySQLDB) DeleteTenant(ctx context.Context, tenant *Tenant) error {
 
 	// delete all roles ...
 	q = "DELETE FROM HANSIP_ROLE WHERE HANSIP_ROLE.ROLE_DOMAIN = ?"
-	_, err = db.instance.ExecContext(ctx, q, domainToDelete)
+	_, err = db.execContext(ctx, q, domainToDelete)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -670,7 +1158,7 @@ func (db *MySQLDB) UpdateTenant(ctx context.Context, tenant *Tenant) error {
 	domainChanged := origin.Domain != tenant.Domain
 
 	q := "UPDATE HANSIP_TENANT SET TENANT_NAME=?, TENANT_DOMAIN=?, DESCRIPTION=? WHERE REC_ID=?"
-	_, err = db.instance.ExecContext(ctx, q,
+	_, err = db.execContext(ctx, q,
 		tenant.Name, tenant.Domain, tenant.Description, tenant.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
@@ -683,7 +1171,7 @@ func (db *MySQLDB) UpdateTenant(ctx context.Context, tenant *Tenant) error {
 
 	if domainChanged {
 		q = "UPDATE HANSIP_ROLE SET ROLE_DOMAIN=? WHERE ROLE_DOMAIN=?"
-		_, err = db.instance.ExecContext(ctx, q,
+		_, err = db.execContext(ctx, q,
 			tenant.Domain, origin.Domain)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
@@ -695,7 +1183,7 @@ func (db *MySQLDB) UpdateTenant(ctx context.Context, tenant *Tenant) error {
 		}
 
 		q = "UPDATE HANSIP_GROUP SET GROUP_DOMAIN=? WHERE GROUP_DOMAIN=?"
-		_, err = db.instance.ExecContext(ctx, q,
+		_, err = db.execContext(ctx, q,
 			tenant.Domain, origin.Domain)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
@@ -809,7 +1297,7 @@ func (db *MySQLDB) GetUserByRecID(ctx context.Context, recID string) (*User, err
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE REC_ID = ?"
 	row := db.instance.QueryRowContext(ctx, q, recID)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-		&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -836,37 +1324,21 @@ func (db *MySQLDB) GetUserByRecID(ctx context.Context, recID string) (*User, err
 // CreateUserRecord create a new user
 func (db *MySQLDB) CreateUserRecord(ctx context.Context, email, passphrase string) (*User, error) {
 	fLog := mysqlLog.WithField("func", "CreateUserRecord").WithField("RequestID", ctx.Value(constants.RequestID))
-	bytes, err := bcrypt.GenerateFromPassword([]byte(passphrase), 14)
+	user, err := NewUserRecord(email, passphrase)
 	if err != nil {
-		fLog.Errorf("bcrypt.GenerateFromPassword got %s", err.Error())
+		fLog.Errorf("NewUserRecord got %s", err.Error())
 		return nil, &ErrLibraryCallError{
 			Wrapped:     err,
 			Message:     "Error CreateUserRecord",
 			LibraryName: "bcrypt",
 		}
 	}
-	user := &User{
-		RecID:             helper.MakeRandomString(10, true, true, true, false),
-		Email:             email,
-		HashedPassphrase:  string(bytes),
-		Enabled:           false,
-		Suspended:         false,
-		LastSeen:          time.Now(),
-		LastLogin:         time.Now(),
-		FailCount:         0,
-		ActivationCode:    helper.MakeRandomString(6, true, false, false, false),
-		ActivationDate:    time.Now(),
-		Enable2FactorAuth: false,
-		UserTotpSecretKey: totp.MakeSecret().Base32(),
-		Token2FA:          helper.MakeRandomString(6, true, false, false, false),
-		RecoveryCode:      helper.MakeRandomString(6, true, false, false, false),
-	}
 
 	q := "INSERT INTO HANSIP_USER(REC_ID,EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 
-	_, err = db.instance.ExecContext(ctx, q,
+	_, err = db.execContext(ctx, q,
 		user.RecID, user.Email, user.HashedPassphrase, 0, 0, user.LastSeen, user.LastLogin, user.FailCount, user.ActivationCode,
-		user.ActivationDate, user.UserTotpSecretKey, user.Enable2FactorAuth, user.Token2FA, user.RecoveryCode)
+		user.ActivationDate, sealedField{FieldTOTPKey, user.UserTotpSecretKey}, user.Enable2FactorAuth, user.Token2FA, user.RecoveryCode)
 
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
@@ -919,7 +1391,7 @@ func (db *MySQLDB) RecreateTOTPRecoveryCodes(ctx context.Context, user *User) ([
 
 	// first we clear out all existing codes.
 	q := "DELETE FROM HANSIP_TOTP_RECOVERY_CODES WHERE USER_REC_ID = ?"
-	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	_, err := db.execContext(ctx, q, user.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return nil, &ErrDBExecuteError{
@@ -932,10 +1404,10 @@ func (db *MySQLDB) RecreateTOTPRecoveryCodes(ctx context.Context, user *User) ([
 	// Now lets recreate all new records.
 	ret := make([]string, 0)
 	for i := 0; i < 16; i++ {
-		recID := helper.MakeRandomString(10, true, true, true, false)
+		recID := NewRecID()
 		code := helper.MakeRandomString(8, true, false, true, false)
 		q = "INSERT INTO HANSIP_TOTP_RECOVERY_CODES(REC_ID, RECOVERY_CODE, USED_FLAG, USER_REC_ID) VALUES (?,?,?,?)"
-		_, err := db.instance.ExecContext(ctx, q, recID, code, 0, user.RecID)
+		_, err := db.execContext(ctx, q, recID, code, 0, user.RecID)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 			return nil, &ErrDBExecuteError{
@@ -957,7 +1429,7 @@ func (db *MySQLDB) MarkTOTPRecoveryCodeUsed(ctx context.Context, user *User, cod
 	rexp := regexp.MustCompile(`^[A-Z0-9]{8}$`)
 	if rexp.Match([]byte(code)) {
 		q := "UPDATE HANSIP_TOTP_RECOVERY_CODES SET USED_FLAG = ? WHERE USER_REC_ID = ? AND RECOVERY_CODE=?"
-		_, err := db.instance.ExecContext(ctx, q, 1, user.RecID, code)
+		_, err := db.execContext(ctx, q, 1, user.RecID, code)
 		if err != nil {
 			fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 			return &ErrDBExecuteError{
@@ -980,7 +1452,7 @@ func (db *MySQLDB) GetUserByEmail(ctx context.Context, email string) (*User, err
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE EMAIL = ?"
 	row := db.instance.QueryRowContext(ctx, q, email)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-		&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1012,7 +1484,7 @@ func (db *MySQLDB) GetUserBy2FAToken(ctx context.Context, token string) (*User,
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE TOKEN_2FE = ?"
 	row := db.instance.QueryRowContext(ctx, q, token)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-		&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1044,7 +1516,7 @@ func (db *MySQLDB) GetUserByRecoveryToken(ctx context.Context, token string) (*U
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE RECOVERY_CODE = ?"
 	row := db.instance.QueryRowContext(ctx, q, token)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-		&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1072,7 +1544,7 @@ func (db *MySQLDB) GetUserByRecoveryToken(ctx context.Context, token string) (*U
 func (db *MySQLDB) DeleteUser(ctx context.Context, user *User) error {
 	fLog := mysqlLog.WithField("func", "DeleteUser").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER WHERE REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	_, err := db.execContext(ctx, q, user.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1143,9 +1615,9 @@ func (db *MySQLDB) UpdateUser(ctx context.Context, user *User) error {
 	q := "UPDATE HANSIP_USER SET EMAIL=?,HASHED_PASSPHRASE=?,ENABLED=?, SUSPENDED=?,LAST_SEEN=?,LAST_LOGIN=?,FAIL_COUNT=?,ACTIVATION_CODE=?,ACTIVATION_DATE=?,TOTP_KEY=?,ENABLE_2FE=?,TOKEN_2FE=?,RECOVERY_CODE=? WHERE REC_ID=?"
 
 	fLog.Infof("Updating user %s", user.Email)
-	_, err = db.instance.ExecContext(ctx, q,
+	_, err = db.execContext(ctx, q,
 		user.Email, user.HashedPassphrase, enabled, suspended, user.LastSeen, user.LastLogin, user.FailCount, user.ActivationCode,
-		user.ActivationDate, user.UserTotpSecretKey, enable2fa, user.Token2FA, user.RecoveryCode, user.RecID)
+		user.ActivationDate, sealedField{FieldTOTPKey, user.UserTotpSecretKey}, enable2fa, user.Token2FA, user.RecoveryCode, user.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1185,7 +1657,13 @@ func (db *MySQLDB) ListUser(ctx context.Context, request *helper.PageRequest) ([
 	}
 
 	q := fmt.Sprintf("SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER ORDER BY %s %s LIMIT %d, %d", OrderBy, request.Sort, page.OffsetStart, page.OffsetEnd-page.OffsetStart)
-	rows, err := db.instance.QueryContext(ctx, q)
+	args := make([]interface{}, 0)
+	if request.Cursor != nil {
+		// keyset pagination on the unique EMAIL, one more user is listed to know whether there is a next page
+		q = fmt.Sprintf("SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE EMAIL %s ? ORDER BY EMAIL %s LIMIT %d", keysetOperator(request.Sort), request.Sort, request.PageSize+1)
+		args = append(args, request.Cursor.Key)
+	}
+	rows, err := db.instance.QueryContext(ctx, q, args...)
 	if err != nil {
 		fLog.Errorf("db.instance.QueryContext got %s. SQL = %s", err.Error(), q)
 		return nil, nil, &ErrDBQueryError{
@@ -1199,7 +1677,7 @@ func (db *MySQLDB) ListUser(ctx context.Context, request *helper.PageRequest) ([
 		user := &User{}
 		var enabled, suspended, enable2fa int
 		err := rows.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-			&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+			&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 		if err != nil {
 			fLog.Warnf("rows.Scan got %s", err.Error())
 			return nil, nil, &ErrDBScanError{
@@ -1220,6 +1698,13 @@ func (db *MySQLDB) ListUser(ctx context.Context, request *helper.PageRequest) ([
 			userList = append(userList, user)
 		}
 	}
+	if request.Cursor != nil {
+		hasNext := uint(len(userList)) > request.PageSize
+		if hasNext {
+			userList = userList[:request.PageSize]
+		}
+		page = helper.NewCursorPage(request, uint(count), uint(len(userList)), hasNext)
+	}
 	return userList, page, nil
 }
 
@@ -1270,30 +1755,25 @@ func (db *MySQLDB) ListAllUserRoles(ctx context.Context, user *User, request *he
 		}
 	}
 	rows.Close()
-	q = "SELECT DISTINCT R.REC_ID, R.ROLE_NAME, R.ROLE_DOMAIN, R.DESCRIPTION FROM HANSIP_ROLE R, HANSIP_GROUP_ROLE GR, HANSIP_USER_GROUP UG WHERE R.REC_ID = GR.ROLE_REC_ID AND GR.GROUP_REC_ID = UG.GROUP_REC_ID AND UG.USER_REC_ID = ?"
-	rows, err = db.instance.QueryContext(ctx, q, user.RecID)
+
+	// Add roles from the user's groups and all of the groups' ancestors.
+	groups, _, err := db.ListUserGroupByUser(ctx, user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "GROUP_NAME",
+		Sort:     "ASC",
+	})
 	if err != nil {
-		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
-		return nil, nil, &ErrDBQueryError{
-			Wrapped: err,
-			Message: "Error ListAllUserRoles",
-			SQL:     q,
-		}
+		fLog.Errorf("db.ListUserGroupByUser got  %s", err.Error())
+		return nil, nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		r := &Role{}
-		err = rows.Scan(&r.RecID, &r.RoleName, &r.RoleDomain, &r.Description)
-		if err != nil {
-			fLog.Warnf("rows.Scan got  %s", err.Error())
-			return nil, nil, &ErrDBScanError{
-				Wrapped: err,
-				Message: "Error ListAllUserRoles",
-				SQL:     q,
-			}
-		} else {
-			roleMap[r.RecID] = r
-		}
+	groupRoles, err := ResolveEffectiveGroupRoles(ctx, db, db, groups)
+	if err != nil {
+		fLog.Errorf("ResolveEffectiveGroupRoles got  %s", err.Error())
+		return nil, nil, err
+	}
+	for _, r := range groupRoles {
+		roleMap[r.RecID] = r
 	}
 
 	page := helper.NewPage(request, uint(len(roleMap)))
@@ -1366,7 +1846,7 @@ func (db *MySQLDB) GetUserRole(ctx context.Context, user *User, role *Role) (*Us
 func (db *MySQLDB) CreateUserRole(ctx context.Context, user *User, role *Role) (*UserRole, error) {
 	fLog := mysqlLog.WithField("func", "CreateUserRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "INSERT INTO HANSIP_USER_ROLE(USER_REC_ID, ROLE_REC_ID) VALUES (?,?)"
-	_, err := db.instance.ExecContext(ctx, q, user.RecID, role.RecID)
+	_, err := db.execContext(ctx, q, user.RecID, role.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return nil, &ErrDBExecuteError{
@@ -1464,7 +1944,7 @@ func (db *MySQLDB) ListUserRoleByRole(ctx context.Context, role *Role, request *
 		user := &User{}
 		var enabled, suspended, enable2fa int
 		err := rows.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-			&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+			&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 		if err != nil {
 			fLog.Warnf("rows.Scan got  %s", err.Error())
 			return nil, nil, &ErrDBScanError{
@@ -1492,7 +1972,7 @@ func (db *MySQLDB) ListUserRoleByRole(ctx context.Context, role *Role, request *
 func (db *MySQLDB) DeleteUserRole(ctx context.Context, userRole *UserRole) error {
 	fLog := mysqlLog.WithField("func", "DeleteUserRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER_ROLE WHERE USER_REC_ID=? AND ROLE_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, userRole.UserRecID, userRole.RoleRecID)
+	_, err := db.execContext(ctx, q, userRole.UserRecID, userRole.RoleRecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1508,7 +1988,7 @@ func (db *MySQLDB) DeleteUserRole(ctx context.Context, userRole *UserRole) error
 func (db *MySQLDB) DeleteUserRoleByUser(ctx context.Context, user *User) error {
 	fLog := mysqlLog.WithField("func", "DeleteUserRoleByUser").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER_ROLE WHERE USER_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	_, err := db.execContext(ctx, q, user.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1524,7 +2004,7 @@ func (db *MySQLDB) DeleteUserRoleByUser(ctx context.Context, user *User) error {
 func (db *MySQLDB) DeleteUserRoleByRole(ctx context.Context, role *Role) error {
 	fLog := mysqlLog.WithField("func", "DeleteUserRoleByRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER_ROLE WHERE ROLE_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, role.RecID)
+	_, err := db.execContext(ctx, q, role.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1582,13 +2062,13 @@ func (db *MySQLDB) GetRoleByName(ctx context.Context, roleName, roleDomain strin
 func (db *MySQLDB) CreateRole(ctx context.Context, roleName, roleDomain, description string) (*Role, error) {
 	fLog := mysqlLog.WithField("func", "CreateRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	r := &Role{
-		RecID:       helper.MakeRandomString(10, true, true, true, false),
+		RecID:       NewRecID(),
 		RoleName:    roleName,
 		RoleDomain:  roleDomain,
 		Description: description,
 	}
 	q := "INSERT INTO HANSIP_ROLE(REC_ID, ROLE_NAME,ROLE_DOMAIN, DESCRIPTION) VALUES (?,?,?,?)"
-	_, err := db.instance.ExecContext(ctx, q, r.RecID, roleName, roleDomain, description)
+	_, err := db.execContext(ctx, q, r.RecID, roleName, roleDomain, description)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return nil, &ErrDBExecuteError{
@@ -1655,7 +2135,7 @@ func (db *MySQLDB) ListRoles(ctx context.Context, tenant *Tenant, request *helpe
 func (db *MySQLDB) DeleteRole(ctx context.Context, role *Role) error {
 	fLog := mysqlLog.WithField("func", "DeleteRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_ROLE WHERE REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, role.RecID)
+	_, err := db.execContext(ctx, q, role.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1708,7 +2188,7 @@ func (db *MySQLDB) UpdateRole(ctx context.Context, role *Role) error {
 		return ErrNotFound
 	}
 	q := "UPDATE HANSIP_ROLE SET ROLE_NAME=?, ROLE_DOMAIN=?, DESCRIPTION=? WHERE REC_ID=?"
-	_, err = db.instance.ExecContext(ctx, q,
+	_, err = db.execContext(ctx, q,
 		role.RoleName, role.RoleDomain, role.Description, role.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
@@ -1766,13 +2246,13 @@ func (db *MySQLDB) GetGroupByName(ctx context.Context, groupName, groupDomain st
 func (db *MySQLDB) CreateGroup(ctx context.Context, groupName, groupDomain, description string) (*Group, error) {
 	fLog := mysqlLog.WithField("func", "CreateGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	r := &Group{
-		RecID:       helper.MakeRandomString(10, true, true, true, false),
+		RecID:       NewRecID(),
 		GroupName:   groupName,
 		GroupDomain: groupDomain,
 		Description: description,
 	}
 	q := "INSERT INTO HANSIP_GROUP(REC_ID, GROUP_NAME, GROUP_DOMAIN, DESCRIPTION) VALUES (?,?,?,?)"
-	_, err := db.instance.ExecContext(ctx, q, r.RecID, groupName, groupDomain, description)
+	_, err := db.execContext(ctx, q, r.RecID, groupName, groupDomain, description)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return nil, &ErrDBExecuteError{
@@ -1836,7 +2316,7 @@ func (db *MySQLDB) ListGroups(ctx context.Context, tenant *Tenant, request *help
 func (db *MySQLDB) DeleteGroup(ctx context.Context, group *Group) error {
 	fLog := mysqlLog.WithField("func", "DeleteGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_GROUP WHERE REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, group.RecID)
+	_, err := db.execContext(ctx, q, group.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1889,7 +2369,7 @@ func (db *MySQLDB) UpdateGroup(ctx context.Context, group *Group) error {
 		return ErrNotFound
 	}
 	q := "UPDATE HANSIP_GROUP SET GROUP_NAME=?, GROUP_DOMAIN=?, DESCRIPTION=? WHERE REC_ID=?"
-	_, err = db.instance.ExecContext(ctx, q,
+	_, err = db.execContext(ctx, q,
 		group.GroupName, group.GroupDomain, group.Description, group.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
@@ -1902,6 +2382,93 @@ func (db *MySQLDB) UpdateGroup(ctx context.Context, group *Group) error {
 	return nil
 }
 
+// GetParentGroup return the parent of a group, nil if the group has no parent
+func (db *MySQLDB) GetParentGroup(ctx context.Context, group *Group) (*Group, error) {
+	fLog := mysqlLog.WithField("func", "GetParentGroup").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT G.REC_ID, G.GROUP_NAME, G.GROUP_DOMAIN, G.DESCRIPTION FROM HANSIP_GROUP G, HANSIP_GROUP_PARENT GP WHERE G.REC_ID = GP.PARENT_REC_ID AND GP.GROUP_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, group.RecID)
+	r := &Group{}
+	err := row.Scan(&r.RecID, &r.GroupName, &r.GroupDomain, &r.Description)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("db.instance.QueryRowContext got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetParentGroup",
+			SQL:     q,
+		}
+	}
+	return r, nil
+}
+
+// SetParentGroup set the parent of a group, nil parent will make the group a root group
+func (db *MySQLDB) SetParentGroup(ctx context.Context, group, parent *Group) error {
+	fLog := mysqlLog.WithField("func", "SetParentGroup").WithField("RequestID", ctx.Value(constants.RequestID))
+	if parent != nil {
+		err := ValidateParentGroup(ctx, db, group, parent)
+		if err != nil {
+			return err
+		}
+	}
+	q := "DELETE FROM HANSIP_GROUP_PARENT WHERE GROUP_REC_ID=?"
+	_, err := db.execContext(ctx, q, group.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetParentGroup",
+			SQL:     q,
+		}
+	}
+	if parent == nil {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_GROUP_PARENT(GROUP_REC_ID, PARENT_REC_ID) VALUES (?,?)"
+	_, err = db.execContext(ctx, q, group.RecID, parent.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetParentGroup",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// ListChildGroups list the direct sub-groups of a group
+func (db *MySQLDB) ListChildGroups(ctx context.Context, group *Group) ([]*Group, error) {
+	fLog := mysqlLog.WithField("func", "ListChildGroups").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT G.REC_ID, G.GROUP_NAME, G.GROUP_DOMAIN, G.DESCRIPTION FROM HANSIP_GROUP G, HANSIP_GROUP_PARENT GP WHERE G.REC_ID = GP.GROUP_REC_ID AND GP.PARENT_REC_ID=? ORDER BY G.GROUP_NAME ASC"
+	rows, err := db.instance.QueryContext(ctx, q, group.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error ListChildGroups",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	ret := make([]*Group, 0)
+	for rows.Next() {
+		r := &Group{}
+		err := rows.Scan(&r.RecID, &r.GroupName, &r.GroupDomain, &r.Description)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error ListChildGroups",
+				SQL:     q,
+			}
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
 // GetGroupRole get GroupRole relation
 func (db *MySQLDB) GetGroupRole(ctx context.Context, group *Group, role *Role) (*GroupRole, error) {
 	fLog := mysqlLog.WithField("func", "GetGroupRole").WithField("RequestID", ctx.Value(constants.RequestID))
@@ -1947,7 +2514,7 @@ func (db *MySQLDB) CreateGroupRole(ctx context.Context, group *Group, role *Role
 	q := "INSERT INTO HANSIP_GROUP_ROLE(GROUP_REC_ID, ROLE_REC_ID) VALUES (?,?)"
 	stmt, err := db.instance.Prepare(q)
 	_, err = stmt.ExecContext(ctx, group.RecID, role.RecID)
-	//_, err := db.instance.ExecContext(ctx, q, group.RecID, role.RecID)
+	//_, err := db.execContext(ctx, q, group.RecID, role.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return nil, &ErrDBExecuteError{
@@ -2062,7 +2629,7 @@ func (db *MySQLDB) ListGroupRoleByRole(ctx context.Context, role *Role, request
 func (db *MySQLDB) DeleteGroupRole(ctx context.Context, groupRole *GroupRole) error {
 	fLog := mysqlLog.WithField("func", "DeleteGroupRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_GROUP_ROLE WHERE GROUP_REC_ID=? AND ROLE_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, groupRole.GroupRecID, groupRole.RoleRecID)
+	_, err := db.execContext(ctx, q, groupRole.GroupRecID, groupRole.RoleRecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2078,7 +2645,7 @@ func (db *MySQLDB) DeleteGroupRole(ctx context.Context, groupRole *GroupRole) er
 func (db *MySQLDB) DeleteGroupRoleByGroup(ctx context.Context, group *Group) error {
 	fLog := mysqlLog.WithField("func", "DeleteGroupRoleByGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_GROUP_ROLE WHERE GROUP_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, group.RecID)
+	_, err := db.execContext(ctx, q, group.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2094,7 +2661,7 @@ func (db *MySQLDB) DeleteGroupRoleByGroup(ctx context.Context, group *Group) err
 func (db *MySQLDB) DeleteGroupRoleByRole(ctx context.Context, role *Role) error {
 	fLog := mysqlLog.WithField("func", "DeleteGroupRoleByRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_GROUP_ROLE WHERE ROLE_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, role.RecID)
+	_, err := db.execContext(ctx, q, role.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got  %s", err.Error())
 		return &ErrDBExecuteError{
@@ -2140,7 +2707,7 @@ func (db *MySQLDB) GetUserGroup(ctx context.Context, user *User, group *Group) (
 func (db *MySQLDB) CreateUserGroup(ctx context.Context, user *User, group *Group) (*UserGroup, error) {
 	fLog := mysqlLog.WithField("func", "CreateUserGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "INSERT INTO HANSIP_USER_GROUP(USER_REC_ID, GROUP_REC_ID) VALUES (?,?)"
-	_, err := db.instance.ExecContext(ctx, q, user.RecID, group.RecID)
+	_, err := db.execContext(ctx, q, user.RecID, group.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return nil, &ErrDBExecuteError{
@@ -2238,7 +2805,7 @@ func (db *MySQLDB) ListUserGroupByGroup(ctx context.Context, group *Group, reque
 		user := &User{}
 		var enabled, suspended, enable2fa int
 		err := rows.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &user.LastSeen, &user.LastLogin, &user.FailCount, &user.ActivationCode,
-			&user.ActivationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+			&user.ActivationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 		if err != nil {
 			fLog.Warnf("rows.Scan got  %s", err.Error())
 			return nil, nil, &ErrDBScanError{
@@ -2266,7 +2833,7 @@ func (db *MySQLDB) ListUserGroupByGroup(ctx context.Context, group *Group, reque
 func (db *MySQLDB) DeleteUserGroup(ctx context.Context, userGroup *UserGroup) error {
 	fLog := mysqlLog.WithField("func", "DeleteUserGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER_GROUP WHERE GROUP_REC_ID=? AND USER_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, userGroup.GroupRecID, userGroup.UserRecID)
+	_, err := db.execContext(ctx, q, userGroup.GroupRecID, userGroup.UserRecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2282,7 +2849,7 @@ func (db *MySQLDB) DeleteUserGroup(ctx context.Context, userGroup *UserGroup) er
 func (db *MySQLDB) DeleteUserGroupByUser(ctx context.Context, user *User) error {
 	fLog := mysqlLog.WithField("func", "DeleteUserGroupByUser").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER_GROUP WHERE USER_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	_, err := db.execContext(ctx, q, user.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2298,7 +2865,7 @@ func (db *MySQLDB) DeleteUserGroupByUser(ctx context.Context, user *User) error
 func (db *MySQLDB) DeleteUserGroupByGroup(ctx context.Context, group *Group) error {
 	fLog := mysqlLog.WithField("func", "DeleteUserGroupByGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	q := "DELETE FROM HANSIP_USER_GROUP WHERE GROUP_REC_ID=?"
-	_, err := db.instance.ExecContext(ctx, q, group.RecID)
+	_, err := db.execContext(ctx, q, group.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2321,7 +2888,7 @@ func (db *MySQLDB) Revoke(ctx context.Context, subject string) error {
 		return nil
 	}
 	q := "INSERT INTO HANSIP_REVOCATION(SUBJECT, ACTIVATION_DATE) VALUES (?,?)"
-	_, err = db.instance.ExecContext(ctx, q, subject, time.Now())
+	_, err = db.execContext(ctx, q, subject, time.Now())
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2344,7 +2911,7 @@ func (db *MySQLDB) UnRevoke(ctx context.Context, subject string) error {
 		return nil
 	}
 	q := "DELETE FROM HANSIP_REVOCATION WHERE SUBJECT=?"
-	_, err = db.instance.ExecContext(ctx, q, subject)
+	_, err = db.execContext(ctx, q, subject)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -2386,3 +2953,803 @@ func (db *MySQLDB) IsRevoked(ctx context.Context, subject string) (bool, error)
 	}
 	return false, nil
 }
+
+// CreateAudit records an audit event
+func (db *MySQLDB) CreateAudit(ctx context.Context, eventType, actor, target, detail string) (*Audit, error) {
+	fLog := mysqlLog.WithField("func", "CreateAudit").WithField("RequestID", ctx.Value(constants.RequestID))
+	audit := &Audit{
+		RecID:     NewRecID(),
+		EventTime: time.Now(),
+		EventType: eventType,
+		Actor:     actor,
+		Target:    target,
+		Detail:    detail,
+	}
+	if requestID, ok := ctx.Value(constants.RequestID).(string); ok {
+		audit.RequestID = requestID
+	}
+	q := "INSERT INTO HANSIP_AUDIT(REC_ID, EVENT_TIME, EVENT_TYPE, ACTOR, TARGET, DETAIL, REQUEST_ID) VALUES (?,?,?,?,?,?,?)"
+	_, err := db.execContext(ctx, q, audit.RecID, audit.EventTime, audit.EventType, audit.Actor, audit.Target, audit.Detail, audit.RequestID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateAudit",
+			SQL:     q,
+		}
+	}
+	return audit, nil
+}
+
+// EachAuditBefore calls each with every audit event that occurred before the time, oldest first. An error from each stops the iteration
+func (db *MySQLDB) EachAuditBefore(ctx context.Context, before time.Time, each func(audit *Audit) error) error {
+	fLog := mysqlLog.WithField("func", "EachAuditBefore").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REC_ID, EVENT_TIME, EVENT_TYPE, ACTOR, TARGET, DETAIL, REQUEST_ID FROM HANSIP_AUDIT WHERE EVENT_TIME < ? ORDER BY EVENT_TIME ASC"
+	rows, err := db.instance.QueryContext(ctx, q, before)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error EachAuditBefore",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		audit := &Audit{}
+		var actor, target, detail, requestID sql.NullString
+		err := rows.Scan(&audit.RecID, &audit.EventTime, &audit.EventType, &actor, &target, &detail, &requestID)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error EachAuditBefore",
+				SQL:     q,
+			}
+		}
+		audit.Actor, audit.Target, audit.Detail, audit.RequestID = actor.String, target.String, detail.String, requestID.String
+		if err := each(audit); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PurgeAuditsBefore deletes the audit events that occurred before the time and returns how many were deleted
+func (db *MySQLDB) PurgeAuditsBefore(ctx context.Context, before time.Time) (int64, error) {
+	fLog := mysqlLog.WithField("func", "PurgeAuditsBefore").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_AUDIT WHERE EVENT_TIME < ?"
+	result, err := db.execContext(ctx, q, before)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return 0, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error PurgeAuditsBefore",
+			SQL:     q,
+		}
+	}
+	return result.RowsAffected()
+}
+
+// AddPassphraseHistory records a hashed passphrase previously used by the user, only the latest keep entries are retained
+func (db *MySQLDB) AddPassphraseHistory(ctx context.Context, user *User, hashedPassphrase string, keep int) error {
+	fLog := mysqlLog.WithField("func", "AddPassphraseHistory").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "INSERT INTO HANSIP_PASSPHRASE_HISTORY(REC_ID, USER_REC_ID, HASHED_PASSPHRASE, CREATED_AT) VALUES (?,?,?,?)"
+	_, err := db.execContext(ctx, q, NewRecID(), user.RecID, hashedPassphrase, time.Now())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error AddPassphraseHistory",
+			SQL:     q,
+		}
+	}
+	q = "SELECT REC_ID FROM HANSIP_PASSPHRASE_HISTORY WHERE USER_REC_ID=? ORDER BY CREATED_AT DESC"
+	rows, err := db.instance.QueryContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error AddPassphraseHistory",
+			SQL:     q,
+		}
+	}
+	prune := make([]string, 0)
+	count := 0
+	for rows.Next() {
+		recID := ""
+		err := rows.Scan(&recID)
+		if err != nil {
+			rows.Close()
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error AddPassphraseHistory",
+				SQL:     q,
+			}
+		}
+		count++
+		if count > keep {
+			prune = append(prune, recID)
+		}
+	}
+	rows.Close()
+	q = "DELETE FROM HANSIP_PASSPHRASE_HISTORY WHERE REC_ID=?"
+	for _, recID := range prune {
+		_, err := db.execContext(ctx, q, recID)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+			return &ErrDBExecuteError{
+				Wrapped: err,
+				Message: "Error AddPassphraseHistory",
+				SQL:     q,
+			}
+		}
+	}
+	return nil
+}
+
+// ListPassphraseHistory returns at most limit of the user's previous hashed passphrases, the latest first
+func (db *MySQLDB) ListPassphraseHistory(ctx context.Context, user *User, limit int) ([]string, error) {
+	fLog := mysqlLog.WithField("func", "ListPassphraseHistory").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT HASHED_PASSPHRASE FROM HANSIP_PASSPHRASE_HISTORY WHERE USER_REC_ID=? ORDER BY CREATED_AT DESC LIMIT ?"
+	rows, err := db.instance.QueryContext(ctx, q, user.RecID, limit)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error ListPassphraseHistory",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	ret := make([]string, 0)
+	for rows.Next() {
+		hashed := ""
+		err := rows.Scan(&hashed)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error ListPassphraseHistory",
+				SQL:     q,
+			}
+		}
+		ret = append(ret, hashed)
+	}
+	return ret, nil
+}
+
+// GetPassphraseChangedAt returns the time the user's passphrase was last changed, nil if it was never recorded
+func (db *MySQLDB) GetPassphraseChangedAt(ctx context.Context, user *User) (*time.Time, error) {
+	fLog := mysqlLog.WithField("func", "GetPassphraseChangedAt").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT PASSPHRASE_CHANGED_AT FROM HANSIP_PASSPHRASE_CHANGE WHERE USER_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, user.RecID)
+	changedAt := time.Time{}
+	err := row.Scan(&changedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetPassphraseChangedAt",
+			SQL:     q,
+		}
+	}
+	return &changedAt, nil
+}
+
+// SetPassphraseChangedAt records the time the user's passphrase was changed
+func (db *MySQLDB) SetPassphraseChangedAt(ctx context.Context, user *User, changedAt time.Time) error {
+	fLog := mysqlLog.WithField("func", "SetPassphraseChangedAt").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_PASSPHRASE_CHANGE WHERE USER_REC_ID=?"
+	_, err := db.execContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetPassphraseChangedAt",
+			SQL:     q,
+		}
+	}
+	q = "INSERT INTO HANSIP_PASSPHRASE_CHANGE(USER_REC_ID, PASSPHRASE_CHANGED_AT) VALUES (?,?)"
+	_, err = db.execContext(ctx, q, user.RecID, changedAt)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetPassphraseChangedAt",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// SetUserActive deactivate or reactivate a user account. Deactivated user is kept but can not authenticate.
+func (db *MySQLDB) SetUserActive(ctx context.Context, user *User, active bool) error {
+	fLog := mysqlLog.WithField("func", "SetUserActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_USER_DEACTIVATION WHERE USER_REC_ID=?"
+	_, err := db.execContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetUserActive",
+			SQL:     q,
+		}
+	}
+	if active {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_USER_DEACTIVATION(USER_REC_ID, DEACTIVATED_AT) VALUES (?,?)"
+	_, err = db.execContext(ctx, q, user.RecID, time.Now())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetUserActive",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// IsUserActive check whether the user account is not deactivated
+func (db *MySQLDB) IsUserActive(ctx context.Context, user *User) (bool, error) {
+	fLog := mysqlLog.WithField("func", "IsUserActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) AS CNT FROM HANSIP_USER_DEACTIVATION WHERE USER_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, user.RecID)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error IsUserActive",
+			SQL:     q,
+		}
+	}
+	return count == 0, nil
+}
+
+// SetEmailVerified marks whether the user's current email address has been verified
+func (db *MySQLDB) SetEmailVerified(ctx context.Context, user *User, verified bool) error {
+	fLog := mysqlLog.WithField("func", "SetEmailVerified").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_USER_EMAIL_VERIFICATION WHERE USER_REC_ID=?"
+	_, err := db.execContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetEmailVerified",
+			SQL:     q,
+		}
+	}
+	if !verified {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_USER_EMAIL_VERIFICATION(USER_REC_ID, VERIFIED_AT) VALUES (?,?)"
+	_, err = db.execContext(ctx, q, user.RecID, time.Now())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetEmailVerified",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// IsEmailVerified check whether the user's current email address has been verified
+func (db *MySQLDB) IsEmailVerified(ctx context.Context, user *User) (bool, error) {
+	fLog := mysqlLog.WithField("func", "IsEmailVerified").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) AS CNT FROM HANSIP_USER_EMAIL_VERIFICATION WHERE USER_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, user.RecID)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error IsEmailVerified",
+			SQL:     q,
+		}
+	}
+	return count > 0, nil
+}
+
+// SaveOpaqueToken stores the claims of an opaque token under its key
+func (db *MySQLDB) SaveOpaqueToken(ctx context.Context, key string, token *helper.HansipToken) error {
+	fLog := mysqlLog.WithField("func", "SaveOpaqueToken").WithField("RequestID", ctx.Value(constants.RequestID))
+	claims, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	q := "INSERT INTO HANSIP_OPAQUE_TOKEN(TOKEN, SUBJECT, CLAIMS, EXPIRE) VALUES (?,?,?,?)"
+	_, err = db.execContext(ctx, q, key, token.Subject, string(claims), token.Expire)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SaveOpaqueToken",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// GetOpaqueToken returns the claims of the opaque token with the key, nil if the token is not found
+func (db *MySQLDB) GetOpaqueToken(ctx context.Context, key string) (*helper.HansipToken, error) {
+	fLog := mysqlLog.WithField("func", "GetOpaqueToken").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT CLAIMS FROM HANSIP_OPAQUE_TOKEN WHERE TOKEN=?"
+	row := db.instance.QueryRowContext(ctx, q, key)
+	var claims string
+	err := row.Scan(&claims)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetOpaqueToken",
+			SQL:     q,
+		}
+	}
+	ret := &helper.HansipToken{}
+	err = json.Unmarshal([]byte(claims), ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DeleteOpaqueToken removes the opaque token with the key
+func (db *MySQLDB) DeleteOpaqueToken(ctx context.Context, key string) error {
+	fLog := mysqlLog.WithField("func", "DeleteOpaqueToken").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_OPAQUE_TOKEN WHERE TOKEN=?"
+	_, err := db.execContext(ctx, q, key)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error DeleteOpaqueToken",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// DeleteOpaqueTokensBySubject removes all opaque tokens of a subject
+func (db *MySQLDB) DeleteOpaqueTokensBySubject(ctx context.Context, subject string) error {
+	fLog := mysqlLog.WithField("func", "DeleteOpaqueTokensBySubject").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_OPAQUE_TOKEN WHERE SUBJECT=?"
+	_, err := db.execContext(ctx, q, subject)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error DeleteOpaqueTokensBySubject",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// PurgeOpaqueTokensBefore removes the opaque tokens expired before the time. Returns the number of tokens removed
+func (db *MySQLDB) PurgeOpaqueTokensBefore(ctx context.Context, before time.Time) (int64, error) {
+	fLog := mysqlLog.WithField("func", "PurgeOpaqueTokensBefore").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_OPAQUE_TOKEN WHERE EXPIRE < ?"
+	result, err := db.execContext(ctx, q, before)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return 0, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error PurgeOpaqueTokensBefore",
+			SQL:     q,
+		}
+	}
+	return result.RowsAffected()
+}
+
+// GetTenantRegion returns the region of a tenant, empty if the tenant has no region
+func (db *MySQLDB) GetTenantRegion(ctx context.Context, tenant *Tenant) (string, error) {
+	fLog := mysqlLog.WithField("func", "GetTenantRegion").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REGION FROM HANSIP_TENANT_REGION WHERE TENANT_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, tenant.RecID)
+	var region string
+	err := row.Scan(&region)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return "", &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetTenantRegion",
+			SQL:     q,
+		}
+	}
+	return region, nil
+}
+
+// SetTenantRegion sets the region of a tenant. An empty region removes the tenant's region
+func (db *MySQLDB) SetTenantRegion(ctx context.Context, tenant *Tenant, region string) error {
+	fLog := mysqlLog.WithField("func", "SetTenantRegion").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_REGION WHERE TENANT_REC_ID=?"
+	_, err := db.execContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantRegion",
+			SQL:     q,
+		}
+	}
+	if len(region) == 0 {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_TENANT_REGION(TENANT_REC_ID, REGION) VALUES (?,?)"
+	_, err = db.execContext(ctx, q, tenant.RecID, region)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantRegion",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// SetTenantActive suspends or reactivates a tenant. The users of a suspended tenant can not access Hansip.
+func (db *MySQLDB) SetTenantActive(ctx context.Context, tenant *Tenant, active bool) error {
+	fLog := mysqlLog.WithField("func", "SetTenantActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_SUSPENSION WHERE TENANT_REC_ID=?"
+	_, err := db.execContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantActive",
+			SQL:     q,
+		}
+	}
+	if active {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_TENANT_SUSPENSION(TENANT_REC_ID, SUSPENDED_AT) VALUES (?,?)"
+	_, err = db.execContext(ctx, q, tenant.RecID, time.Now())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantActive",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// IsTenantActive check whether the tenant is not suspended
+func (db *MySQLDB) IsTenantActive(ctx context.Context, tenant *Tenant) (bool, error) {
+	fLog := mysqlLog.WithField("func", "IsTenantActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) AS CNT FROM HANSIP_TENANT_SUSPENSION WHERE TENANT_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, tenant.RecID)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error IsTenantActive",
+			SQL:     q,
+		}
+	}
+	return count == 0, nil
+}
+
+// GetTenantBranding returns the branding of a tenant, nil if the tenant has no branding
+func (db *MySQLDB) GetTenantBranding(ctx context.Context, tenant *Tenant) (*TenantBranding, error) {
+	fLog := mysqlLog.WithField("func", "GetTenantBranding").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT PRODUCT_NAME, LOGO_URL, SUPPORT_EMAIL, PRIMARY_COLOR FROM HANSIP_TENANT_BRANDING WHERE TENANT_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, tenant.RecID)
+	branding := &TenantBranding{}
+	err := row.Scan(&branding.ProductName, &branding.LogoURL, &branding.SupportEmail, &branding.PrimaryColor)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetTenantBranding",
+			SQL:     q,
+		}
+	}
+	return branding, nil
+}
+
+// SetTenantBranding sets the branding of a tenant. A nil branding removes the tenant's branding
+func (db *MySQLDB) SetTenantBranding(ctx context.Context, tenant *Tenant, branding *TenantBranding) error {
+	fLog := mysqlLog.WithField("func", "SetTenantBranding").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_BRANDING WHERE TENANT_REC_ID=?"
+	_, err := db.execContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantBranding",
+			SQL:     q,
+		}
+	}
+	if branding == nil {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_TENANT_BRANDING(TENANT_REC_ID, PRODUCT_NAME, LOGO_URL, SUPPORT_EMAIL, PRIMARY_COLOR) VALUES (?,?,?,?,?)"
+	_, err = db.execContext(ctx, q, tenant.RecID, branding.ProductName, branding.LogoURL, branding.SupportEmail, branding.PrimaryColor)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantBranding",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// GetTenantEmailDomains returns the email domains allowed for the users of a tenant, sorted. Empty means any domain is allowed
+func (db *MySQLDB) GetTenantEmailDomains(ctx context.Context, tenant *Tenant) ([]string, error) {
+	fLog := mysqlLog.WithField("func", "GetTenantEmailDomains").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT EMAIL_DOMAIN FROM HANSIP_TENANT_EMAIL_DOMAIN WHERE TENANT_REC_ID=? ORDER BY EMAIL_DOMAIN ASC"
+	rows, err := db.instance.QueryContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error GetTenantEmailDomains",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	domains := make([]string, 0)
+	for rows.Next() {
+		var domain string
+		err := rows.Scan(&domain)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error GetTenantEmailDomains",
+				SQL:     q,
+			}
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// SetTenantEmailDomains replaces the email domains allowed for the users of a tenant. No domain removes the restriction
+func (db *MySQLDB) SetTenantEmailDomains(ctx context.Context, tenant *Tenant, domains []string) error {
+	fLog := mysqlLog.WithField("func", "SetTenantEmailDomains").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_EMAIL_DOMAIN WHERE TENANT_REC_ID=?"
+	_, err := db.execContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantEmailDomains",
+			SQL:     q,
+		}
+	}
+	q = "INSERT INTO HANSIP_TENANT_EMAIL_DOMAIN(TENANT_REC_ID, EMAIL_DOMAIN) VALUES (?,?)"
+	for _, domain := range domains {
+		_, err = db.execContext(ctx, q, tenant.RecID, domain)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+			return &ErrDBExecuteError{
+				Wrapped: err,
+				Message: "Error SetTenantEmailDomains",
+				SQL:     q,
+			}
+		}
+	}
+	return nil
+}
+
+// GetIdempotentResponse returns the response recorded for the key, nil if the key is not recorded or has expired
+func (db *MySQLDB) GetIdempotentResponse(ctx context.Context, key string) (*IdempotentResponse, error) {
+	fLog := mysqlLog.WithField("func", "GetIdempotentResponse").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT IDEMPOTENCY_KEY, REQUEST_HASH, STATUS_CODE, CONTENT_TYPE, BODY, EXPIRE FROM HANSIP_IDEMPOTENCY_KEY WHERE IDEMPOTENCY_KEY=? AND EXPIRE > ?"
+	row := db.instance.QueryRowContext(ctx, q, key, time.Now())
+	response := &IdempotentResponse{}
+	err := row.Scan(&response.Key, &response.RequestHash, &response.StatusCode, &response.ContentType, &response.Body, &response.Expire)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetIdempotentResponse",
+			SQL:     q,
+		}
+	}
+	return response, nil
+}
+
+// SaveIdempotentResponse records the response for its key until it expires. Expired keys are purged along the way.
+func (db *MySQLDB) SaveIdempotentResponse(ctx context.Context, response *IdempotentResponse) error {
+	fLog := mysqlLog.WithField("func", "SaveIdempotentResponse").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_IDEMPOTENCY_KEY WHERE EXPIRE < ? OR IDEMPOTENCY_KEY=?"
+	_, err := db.execContext(ctx, q, time.Now(), response.Key)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SaveIdempotentResponse",
+			SQL:     q,
+		}
+	}
+	q = "INSERT INTO HANSIP_IDEMPOTENCY_KEY(IDEMPOTENCY_KEY, REQUEST_HASH, STATUS_CODE, CONTENT_TYPE, BODY, EXPIRE) VALUES (?,?,?,?,?,?)"
+	_, err = db.execContext(ctx, q, response.Key, response.RequestHash, response.StatusCode, response.ContentType, response.Body, response.Expire)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SaveIdempotentResponse",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// ConsumeTokenID marks the token ID used until it expires, it returns false if the ID is already used.
+// Expired IDs are purged along the way.
+func (db *MySQLDB) ConsumeTokenID(ctx context.Context, tokenID string, expire time.Time) (bool, error) {
+	fLog := mysqlLog.WithField("func", "ConsumeTokenID").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_CONSUMED_TOKEN WHERE EXPIRE < ?"
+	_, err := db.execContext(ctx, q, time.Now())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return false, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ConsumeTokenID",
+			SQL:     q,
+		}
+	}
+	q = "INSERT IGNORE INTO HANSIP_CONSUMED_TOKEN(TOKEN_ID, EXPIRE) VALUES (?,?)"
+	result, err := db.execContext(ctx, q, tokenID, expire)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return false, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ConsumeTokenID",
+			SQL:     q,
+		}
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return false, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ConsumeTokenID",
+			SQL:     q,
+		}
+	}
+	return inserted == 1, nil
+}
+
+// CreateWebAuthnCredential stores a credential registered by the user
+func (db *MySQLDB) CreateWebAuthnCredential(ctx context.Context, user *User, name, credentialID, credential string) (*WebAuthnCredential, error) {
+	fLog := mysqlLog.WithField("func", "CreateWebAuthnCredential").WithField("RequestID", ctx.Value(constants.RequestID))
+	now := time.Unix(time.Now().Unix(), 0)
+	webAuthnCredential := &WebAuthnCredential{
+		RecID:        NewRecID(),
+		UserRecID:    user.RecID,
+		Name:         name,
+		CredentialID: credentialID,
+		Credential:   credential,
+		CreatedAt:    now,
+		LastUsedAt:   now,
+	}
+	q := "INSERT INTO HANSIP_WEBAUTHN_CREDENTIAL(REC_ID, USER_REC_ID, CREDENTIAL_NAME, CREDENTIAL_ID, CREDENTIAL, CREATED_AT, LAST_USED_AT) VALUES (?,?,?,?,?,?,?)"
+	_, err := db.execContext(ctx, q, webAuthnCredential.RecID, webAuthnCredential.UserRecID, webAuthnCredential.Name, webAuthnCredential.CredentialID, webAuthnCredential.Credential, webAuthnCredential.CreatedAt, webAuthnCredential.LastUsedAt)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateWebAuthnCredential",
+			SQL:     q,
+		}
+	}
+	return webAuthnCredential, nil
+}
+
+// GetWebAuthnCredentialByRecID returns a credential, nil if it not exist
+func (db *MySQLDB) GetWebAuthnCredentialByRecID(ctx context.Context, recID string) (*WebAuthnCredential, error) {
+	fLog := mysqlLog.WithField("func", "GetWebAuthnCredentialByRecID").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REC_ID, USER_REC_ID, CREDENTIAL_NAME, CREDENTIAL_ID, CREDENTIAL, CREATED_AT, LAST_USED_AT FROM HANSIP_WEBAUTHN_CREDENTIAL WHERE REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, recID)
+	credential := &WebAuthnCredential{}
+	err := row.Scan(&credential.RecID, &credential.UserRecID, &credential.Name, &credential.CredentialID, &credential.Credential, &credential.CreatedAt, &credential.LastUsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetWebAuthnCredentialByRecID",
+			SQL:     q,
+		}
+	}
+	return credential, nil
+}
+
+// ListWebAuthnCredentials returns all credentials of the user, oldest first
+func (db *MySQLDB) ListWebAuthnCredentials(ctx context.Context, user *User) ([]*WebAuthnCredential, error) {
+	fLog := mysqlLog.WithField("func", "ListWebAuthnCredentials").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REC_ID, USER_REC_ID, CREDENTIAL_NAME, CREDENTIAL_ID, CREDENTIAL, CREATED_AT, LAST_USED_AT FROM HANSIP_WEBAUTHN_CREDENTIAL WHERE USER_REC_ID=? ORDER BY CREATED_AT ASC"
+	rows, err := db.instance.QueryContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error ListWebAuthnCredentials",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	ret := make([]*WebAuthnCredential, 0)
+	for rows.Next() {
+		credential := &WebAuthnCredential{}
+		err := rows.Scan(&credential.RecID, &credential.UserRecID, &credential.Name, &credential.CredentialID, &credential.Credential, &credential.CreatedAt, &credential.LastUsedAt)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error ListWebAuthnCredentials",
+				SQL:     q,
+			}
+		}
+		ret = append(ret, credential)
+	}
+	return ret, nil
+}
+
+// UpdateWebAuthnCredential saves the name, the credential and the last use time of a credential
+func (db *MySQLDB) UpdateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	fLog := mysqlLog.WithField("func", "UpdateWebAuthnCredential").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "UPDATE HANSIP_WEBAUTHN_CREDENTIAL SET CREDENTIAL_NAME=?, CREDENTIAL=?, LAST_USED_AT=? WHERE REC_ID=?"
+	_, err := db.execContext(ctx, q, credential.Name, credential.Credential, credential.LastUsedAt, credential.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error UpdateWebAuthnCredential",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential removes a credential
+func (db *MySQLDB) DeleteWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	fLog := mysqlLog.WithField("func", "DeleteWebAuthnCredential").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_WEBAUTHN_CREDENTIAL WHERE REC_ID=?"
+	_, err := db.execContext(ctx, q, credential.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error DeleteWebAuthnCredential",
+			SQL:     q,
+		}
+	}
+	return nil
+}
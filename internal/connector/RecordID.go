@@ -0,0 +1,42 @@
+package connector
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+const (
+	// RecIDStrategyRandom creates 10 character alphanumeric record ids
+	RecIDStrategyRandom = "RANDOM"
+	// RecIDStrategyUUID creates random (version 4) UUID record ids, as 32 hex digits without dashes to fit the REC_ID columns
+	RecIDStrategyUUID = "UUID"
+)
+
+// ValidateRecIDStrategy checks "db.id.strategy" is a known strategy
+func ValidateRecIDStrategy() error {
+	switch config.Get("db.id.strategy") {
+	case RecIDStrategyRandom, RecIDStrategyUUID:
+		return nil
+	default:
+		return fmt.Errorf("db.id.strategy %q is not one of %s or %s", config.Get("db.id.strategy"), RecIDStrategyRandom, RecIDStrategyUUID)
+	}
+}
+
+// NewRecID creates the record id of a new record according to "db.id.strategy".
+// Records created before the strategy is changed keep their record id.
+func NewRecID() string {
+	if config.Get("db.id.strategy") != RecIDStrategyUUID {
+		return helper.MakeRandomString(10, true, true, true, false)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("can not read random bytes for a record id. got %s", err.Error()))
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return hex.EncodeToString(id)
+}
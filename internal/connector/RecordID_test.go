@@ -0,0 +1,29 @@
+package connector
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+func TestNewRecID(t *testing.T) {
+	defer config.Set("db.id.strategy", RecIDStrategyRandom)
+	if id := NewRecID(); !regexp.MustCompile(`^[A-Za-z0-9]{10}$`).MatchString(id) {
+		t.Errorf("expect a 10 character random id. got %q", id)
+	}
+	config.Set("db.id.strategy", RecIDStrategyUUID)
+	uuid := regexp.MustCompile(`^[0-9a-f]{12}4[0-9a-f]{3}[89ab][0-9a-f]{15}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := NewRecID()
+		if !uuid.MatchString(id) || seen[id] {
+			t.Fatalf("expect a unique version 4 UUID of 32 hex digits. got %q", id)
+		}
+		seen[id] = true
+	}
+	config.Set("db.id.strategy", "AUTOINCREMENT")
+	if err := ValidateRecIDStrategy(); err == nil {
+		t.Errorf("unknown strategy should be invalid")
+	}
+}
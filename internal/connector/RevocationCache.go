@@ -0,0 +1,115 @@
+package connector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
+)
+
+// CachedRevocationRepository is a RevocationRepository that caches whether a subject is revoked, so the revocation
+// check of every authenticated request does not hit the store. Both revoked and not revoked subjects are cached,
+// a failed check is not. Revoking or un-revoking a subject through this instance updates its cached entry at once,
+// a change made through another instance is seen once the entry expires.
+// Concurrent checks of a subject missing from the cache are coalesced into a single store lookup.
+type CachedRevocationRepository struct {
+	RevocationRepository
+	Cache cache.ObjectCache
+
+	mutex   sync.Mutex
+	lookups map[string]*revocationLookup
+}
+
+// revocationLookup is a store lookup in progress, shared by the checks of the same subject arriving meanwhile.
+type revocationLookup struct {
+	done    chan struct{}
+	revoked bool
+	err     error
+	// stale is set when the subject is revoked or un-revoked during the lookup, its result must not be cached
+	stale bool
+}
+
+// NewCachedRevocationRepository create new instance of CachedRevocationRepository caching up to capacity subjects for the ttl.
+func NewCachedRevocationRepository(repo RevocationRepository, capacity int, ttl time.Duration) *CachedRevocationRepository {
+	return &CachedRevocationRepository{
+		RevocationRepository: repo,
+		Cache:                cache.NewInMemoryCache(capacity, ttlSeconds(ttl), false),
+		lookups:              make(map[string]*revocationLookup),
+	}
+}
+
+// Revoke a subject in the store and caches it as revoked.
+func (repo *CachedRevocationRepository) Revoke(ctx context.Context, subject string) error {
+	err := repo.RevocationRepository.Revoke(ctx, subject)
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	if lookup, ok := repo.lookups[subject]; ok {
+		lookup.stale = true
+	}
+	if err != nil {
+		repo.Cache.Delete(subject)
+		return err
+	}
+	store(repo.Cache, subject, true)
+	return nil
+}
+
+// UnRevoke a subject in the store and caches it as not revoked.
+func (repo *CachedRevocationRepository) UnRevoke(ctx context.Context, subject string) error {
+	err := repo.RevocationRepository.UnRevoke(ctx, subject)
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	if lookup, ok := repo.lookups[subject]; ok {
+		lookup.stale = true
+	}
+	if err != nil {
+		repo.Cache.Delete(subject)
+		return err
+	}
+	store(repo.Cache, subject, false)
+	return nil
+}
+
+// IsRevoked check whether the subject is revoked, from the cache if it is there. Otherwise the first check looks
+// the subject up in the store and the checks arriving meanwhile wait for its result, including its error.
+func (repo *CachedRevocationRepository) IsRevoked(ctx context.Context, subject string) (bool, error) {
+	if ok, revoked := repo.Cache.Fetch(subject); ok {
+		return revoked.(bool), nil
+	}
+	repo.mutex.Lock()
+	// the lookup may have completed since the cache was read
+	if ok, revoked := repo.Cache.Fetch(subject); ok {
+		repo.mutex.Unlock()
+		return revoked.(bool), nil
+	}
+	if lookup, ok := repo.lookups[subject]; ok {
+		repo.mutex.Unlock()
+		select {
+		case <-lookup.done:
+			return lookup.revoked, lookup.err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	if repo.lookups == nil {
+		repo.lookups = make(map[string]*revocationLookup)
+	}
+	lookup := &revocationLookup{done: make(chan struct{})}
+	repo.lookups[subject] = lookup
+	repo.mutex.Unlock()
+
+	lookup.revoked, lookup.err = repo.RevocationRepository.IsRevoked(ctx, subject)
+
+	repo.mutex.Lock()
+	delete(repo.lookups, subject)
+	if lookup.err == nil && !lookup.stale {
+		store(repo.Cache, subject, lookup.revoked)
+	}
+	repo.mutex.Unlock()
+	close(lookup.done)
+	if lookup.err != nil {
+		return false, lookup.err
+	}
+	return lookup.revoked, nil
+}
@@ -0,0 +1,179 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingRevocationRepo struct {
+	revoked map[string]bool
+	fail    bool
+	reads   int
+}
+
+func (repo *countingRevocationRepo) Revoke(ctx context.Context, subject string) error {
+	repo.revoked[subject] = true
+	return nil
+}
+
+func (repo *countingRevocationRepo) UnRevoke(ctx context.Context, subject string) error {
+	delete(repo.revoked, subject)
+	return nil
+}
+
+func (repo *countingRevocationRepo) IsRevoked(ctx context.Context, subject string) (bool, error) {
+	repo.reads++
+	if repo.fail {
+		return false, fmt.Errorf("store is down")
+	}
+	return repo.revoked[subject], nil
+}
+
+func TestCachedRevocationRepository(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingRevocationRepo{revoked: map[string]bool{"revoked@hansip": true}}
+	repo := NewCachedRevocationRepository(backend, 10, time.Minute)
+
+	// cache miss reads the store, the following checks are served from the cache
+	for i := 0; i < 3; i++ {
+		revoked, err := repo.IsRevoked(ctx, "revoked@hansip")
+		if err != nil || !revoked {
+			t.Fatalf("expect revoked@hansip revoked. got %v, %v", revoked, err)
+		}
+		revoked, err = repo.IsRevoked(ctx, "user@hansip")
+		if err != nil || revoked {
+			t.Fatalf("expect user@hansip not revoked. got %v, %v", revoked, err)
+		}
+	}
+	if backend.reads != 2 {
+		t.Fatalf("expect 2 store reads. got %d", backend.reads)
+	}
+
+	// revoking through the cache is seen at once
+	if err := repo.Revoke(ctx, "user@hansip"); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := repo.IsRevoked(ctx, "user@hansip"); !revoked {
+		t.Fatalf("expect user@hansip revoked right after Revoke")
+	}
+	if err := repo.UnRevoke(ctx, "revoked@hansip"); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := repo.IsRevoked(ctx, "revoked@hansip"); revoked {
+		t.Fatalf("expect revoked@hansip not revoked right after UnRevoke")
+	}
+	if backend.reads != 2 {
+		t.Fatalf("expect no store read after revoke. got %d", backend.reads)
+	}
+
+	// a failed check is not cached
+	backend.fail = true
+	if _, err := repo.IsRevoked(ctx, "other@hansip"); err == nil {
+		t.Fatalf("expect error when the store fails")
+	}
+	backend.fail = false
+	if revoked, err := repo.IsRevoked(ctx, "other@hansip"); err != nil || revoked {
+		t.Fatalf("expect other@hansip not revoked. got %v, %v", revoked, err)
+	}
+	if backend.reads != 4 {
+		t.Fatalf("expect 4 store reads. got %d", backend.reads)
+	}
+}
+
+func TestCachedRevocationRepositoryExpiry(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingRevocationRepo{revoked: map[string]bool{}}
+	repo := NewCachedRevocationRepository(backend, 10, time.Second)
+
+	if revoked, _ := repo.IsRevoked(ctx, "user@hansip"); revoked {
+		t.Fatalf("expect user@hansip not revoked")
+	}
+	// revoked through another instance
+	backend.revoked["user@hansip"] = true
+	if revoked, _ := repo.IsRevoked(ctx, "user@hansip"); revoked {
+		t.Fatalf("expect the cached entry before it expires")
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if revoked, _ := repo.IsRevoked(ctx, "user@hansip"); !revoked {
+		t.Fatalf("expect user@hansip revoked from the store after the entry expires")
+	}
+}
+
+// blockingRevocationRepo holds every lookup until release is closed.
+type blockingRevocationRepo struct {
+	countingRevocationRepo
+	lookups int32
+	release chan struct{}
+}
+
+func (repo *blockingRevocationRepo) IsRevoked(ctx context.Context, subject string) (bool, error) {
+	atomic.AddInt32(&repo.lookups, 1)
+	<-repo.release
+	return repo.revoked[subject], nil
+}
+
+func TestCachedRevocationRepositoryCoalescing(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingRevocationRepo{countingRevocationRepo: countingRevocationRepo{revoked: map[string]bool{"revoked@hansip": true}}, release: make(chan struct{})}
+	repo := NewCachedRevocationRepository(backend, 10, time.Minute)
+
+	var checks sync.WaitGroup
+	results := make(chan bool, 100)
+	for i := 0; i < 100; i++ {
+		checks.Add(1)
+		go func() {
+			defer checks.Done()
+			revoked, err := repo.IsRevoked(ctx, "revoked@hansip")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- revoked
+		}()
+	}
+	for atomic.LoadInt32(&backend.lookups) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// give the other checks time to join the lookup in progress
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	checks.Wait()
+	close(results)
+
+	for revoked := range results {
+		if !revoked {
+			t.Fatalf("expect every check to see revoked@hansip revoked")
+		}
+	}
+	if lookups := atomic.LoadInt32(&backend.lookups); lookups != 1 {
+		t.Errorf("expect the concurrent checks coalesced into 1 store lookup. got %d", lookups)
+	}
+}
+
+func TestCachedRevocationRepositoryRevokeDuringLookup(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingRevocationRepo{countingRevocationRepo: countingRevocationRepo{revoked: map[string]bool{}}, release: make(chan struct{})}
+	repo := NewCachedRevocationRepository(backend, 10, time.Minute)
+
+	checked := make(chan bool)
+	go func() {
+		revoked, _ := repo.IsRevoked(ctx, "user@hansip")
+		checked <- revoked
+	}()
+	for atomic.LoadInt32(&backend.lookups) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := repo.Revoke(ctx, "user@hansip"); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.release)
+	<-checked
+
+	// the lookup started before the revocation must not overwrite it in the cache
+	if revoked, _ := repo.IsRevoked(ctx, "user@hansip"); !revoked {
+		t.Errorf("expect user@hansip revoked after a revocation during the lookup")
+	}
+}
@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+const (
+	// sendMailTimeout cuts off connecting to the SMTP server, and sending an email over the kept alive connection
+	sendMailTimeout = 30 * time.Second
+)
+
+// sendKeptAlive sends the message over the kept alive connection. A stale connection, eg. closed by the SMTP server
+// while idle, is detected by a NOOP before sending and replaced by a new connection, so the message is not failed.
+func (sender *SendMailSender) sendKeptAlive(auth smtp.Auth, from string, recipients []string, msg []byte) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if sender.client != nil && sender.probe() != nil {
+		mailerLog.WithField("mailer", "sendmail").Info("smtp connection went stale, reconnecting")
+		sender.closeConnection()
+	}
+	if sender.client == nil {
+		if err := sender.connect(auth); err != nil {
+			return err
+		}
+	}
+	err := sendOver(sender.client, from, recipients, msg)
+	if err != nil {
+		// the connection state is unknown after a failure, the next email uses a new connection
+		sender.closeConnection()
+	}
+	return err
+}
+
+// probe checks the kept alive connection is still usable.
+func (sender *SendMailSender) probe() error {
+	return sender.client.Noop()
+}
+
+// connect opens the kept alive connection, greeting, upgrading to TLS and authenticating the same way smtp.SendMail does.
+func (sender *SendMailSender) connect(auth smtp.Auth) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", sender.Host, sender.Port), sendMailTimeout)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(&deadlineConn{Conn: conn}, sender.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := client.Hello("localhost"); err != nil {
+		client.Close()
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: sender.Host}); err != nil {
+			client.Close()
+			return err
+		}
+	}
+	if ok, _ := client.Extension("AUTH"); ok && auth != nil {
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return err
+		}
+	}
+	sender.client = client
+	return nil
+}
+
+// closeConnection closes the kept alive connection, politely if the server still listens.
+func (sender *SendMailSender) closeConnection() {
+	if sender.client == nil {
+		return
+	}
+	if err := sender.client.Quit(); err != nil {
+		sender.client.Close()
+	}
+	sender.client = nil
+}
+
+// sendOver sends a message over an SMTP client, leaving the connection open for the next message.
+func sendOver(client *smtp.Client, from string, recipients []string, msg []byte) error {
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(msg); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// deadlineConn extends the deadline of the kept alive connection on each read and write,
+// so a hanging SMTP server cuts the send off while an idle connection never times out on its own.
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(sendMailTimeout))
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(sendMailTimeout))
+	return c.Conn.Write(b)
+}
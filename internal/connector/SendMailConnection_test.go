@@ -0,0 +1,145 @@
+package connector
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTPServer accepts SMTP connections, records the delivered messages and drops
+// each connection after dropAfter messages, like a server closing idle connections.
+type fakeSMTPServer struct {
+	listener    net.Listener
+	dropAfter   int
+	mutex       sync.Mutex
+	connections int
+	messages    []string
+}
+
+func newFakeSMTPServer(t *testing.T, dropAfter int) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener, dropAfter: dropAfter}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.connections++
+			server.mutex.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake ESMTP")
+	delivered := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250 fake")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			var body strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				body.WriteString(dataLine)
+			}
+			server.mutex.Lock()
+			server.messages = append(server.messages, body.String())
+			server.mutex.Unlock()
+			reply("250 queued")
+			delivered++
+			if delivered >= server.dropAfter {
+				// dropped without a word, the client only notices on its next command
+				return
+			}
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (server *fakeSMTPServer) counts() (int, int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.connections, len(server.messages)
+}
+
+func TestSendMailKeepAliveReconnect(t *testing.T) {
+	server := newFakeSMTPServer(t, 1)
+	defer server.listener.Close()
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	sender := &SendMailSender{Host: "127.0.0.1", Port: portNumber, KeepAlive: true}
+
+	for i := 0; i < 3; i++ {
+		if err := sender.SendEmail(context.Background(), []string{"user@hansip.io"}, nil, nil, "hansip@hansip.io", "Hansip", "Hello", "body"); err != nil {
+			t.Fatalf("email #%d expect to be sent over a new connection. got %s", i+1, err.Error())
+		}
+	}
+	if connections, messages := server.counts(); connections != 3 || messages != 3 {
+		t.Errorf("expect 3 messages over 3 connections. got %d messages over %d connections", messages, connections)
+	}
+}
+
+func TestSendMailKeepAliveReuse(t *testing.T) {
+	server := newFakeSMTPServer(t, 100)
+	defer server.listener.Close()
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	sender := &SendMailSender{Host: "127.0.0.1", Port: portNumber, KeepAlive: true}
+
+	for i := 0; i < 3; i++ {
+		if err := sender.SendEmail(context.Background(), []string{"user@hansip.io"}, nil, nil, "hansip@hansip.io", "Hansip", "Hello", "body"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if connections, messages := server.counts(); connections != 1 || messages != 3 {
+		t.Errorf("expect 3 messages over 1 connection. got %d messages over %d connections", messages, connections)
+	}
+}
+
+func TestSendMailReplyTo(t *testing.T) {
+	server := newFakeSMTPServer(t, 100)
+	defer server.listener.Close()
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	sender := &SendMailSender{Host: "127.0.0.1", Port: portNumber, KeepAlive: true, ReplyTo: "support@acme.com"}
+
+	if err := sender.SendEmail(context.Background(), []string{"user@hansip.io"}, nil, nil, "hansip@hansip.io", "Hansip", "Hello", "body"); err != nil {
+		t.Fatal(err)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.messages) != 1 || !strings.HasPrefix(server.messages[0], "Reply-To: support@acme.com\r\n") {
+		t.Errorf("expect the Reply-To header. got %q", server.messages)
+	}
+}
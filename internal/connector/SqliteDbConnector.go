@@ -3,22 +3,23 @@ package connector
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/hyperjumptech/hansip/internal/config"
 	"github.com/hyperjumptech/hansip/internal/constants"
 	"github.com/hyperjumptech/hansip/pkg/helper"
-	"github.com/hyperjumptech/hansip/pkg/totp"
 	_ "github.com/mattn/go-sqlite3"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
+	"net/url"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 )
 
 const (
 	// DropAllSqlite contains SQL to drop all existing table for hansip
-	DropAllSqlite = `DROP TABLE IF EXISTS HANSIP_REVOCATION, HANSIP_TOTP_RECOVERY_CODES, HANSIP_USER_GROUP, HANSIP_USER_ROLE, HANSIP_GROUP_ROLE, HANSIP_USER, HANSIP_GROUP, HANSIP_ROLE, HANSIP_TENANT;`
+	DropAllSqlite = `DROP TABLE IF EXISTS HANSIP_TENANT_EMAIL_DOMAIN, HANSIP_CONSUMED_TOKEN, HANSIP_USER_EMAIL_VERIFICATION, HANSIP_TENANT_SUSPENSION, HANSIP_WEBAUTHN_CREDENTIAL, HANSIP_IDEMPOTENCY_KEY, HANSIP_TENANT_BRANDING, HANSIP_TENANT_REGION, HANSIP_OPAQUE_TOKEN, HANSIP_USER_DEACTIVATION, HANSIP_PASSPHRASE_CHANGE, HANSIP_PASSPHRASE_HISTORY, HANSIP_AUDIT, HANSIP_GROUP_PARENT, HANSIP_REVOCATION, HANSIP_TOTP_RECOVERY_CODES, HANSIP_USER_GROUP, HANSIP_USER_ROLE, HANSIP_GROUP_ROLE, HANSIP_USER, HANSIP_GROUP, HANSIP_ROLE, HANSIP_TENANT;`
 
 	// CreateTenantSqlite contains SQL to create HANSIP_ROLE table
 	CreateTenantSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT (
@@ -103,6 +104,122 @@ const (
     SUBJECT VARCHAR(128) NOT NULL UNIQUE,
     ACTIVATION_DATE DATETIME,
     PRIMARY KEY (SUBJECT)
+)`
+	// CreateGroupParentSqlite contains SQL to create HANSIP_GROUP_PARENT table
+	CreateGroupParentSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_GROUP_PARENT (
+    GROUP_REC_ID VARCHAR(32) NOT NULL,
+    PARENT_REC_ID VARCHAR(32) NOT NULL,
+    PRIMARY KEY (GROUP_REC_ID),
+    FOREIGN KEY (GROUP_REC_ID) REFERENCES HANSIP_GROUP(REC_ID) ON DELETE CASCADE,
+    FOREIGN KEY (PARENT_REC_ID) REFERENCES HANSIP_GROUP(REC_ID) ON DELETE CASCADE
+)`
+	// CreateAuditSqlite contains SQL to create HANSIP_AUDIT table
+	CreateAuditSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_AUDIT (
+    REC_ID VARCHAR(32) NOT NULL UNIQUE,
+    EVENT_TIME FLOAT NOT NULL,
+    EVENT_TYPE VARCHAR(64) NOT NULL,
+    ACTOR VARCHAR(128),
+    TARGET VARCHAR(128),
+    DETAIL VARCHAR(1024),
+    REQUEST_ID VARCHAR(64),
+    PRIMARY KEY (REC_ID)
+)`
+	// CreatePassphraseHistorySqlite contains SQL to create HANSIP_PASSPHRASE_HISTORY table
+	CreatePassphraseHistorySqlite = `CREATE TABLE IF NOT EXISTS HANSIP_PASSPHRASE_HISTORY (
+    REC_ID VARCHAR(32) NOT NULL UNIQUE,
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    HASHED_PASSPHRASE VARCHAR(128) NOT NULL,
+    CREATED_AT FLOAT NOT NULL,
+    PRIMARY KEY (REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+)`
+	// CreatePassphraseChangeSqlite contains SQL to create HANSIP_PASSPHRASE_CHANGE table
+	CreatePassphraseChangeSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_PASSPHRASE_CHANGE (
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    PASSPHRASE_CHANGED_AT FLOAT NOT NULL,
+    PRIMARY KEY (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+)`
+	// CreateUserDeactivationSqlite contains SQL to create HANSIP_USER_DEACTIVATION table
+	CreateUserDeactivationSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_USER_DEACTIVATION (
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    DEACTIVATED_AT FLOAT NOT NULL,
+    PRIMARY KEY (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+)`
+	// CreateUserEmailVerificationSqlite contains SQL to create HANSIP_USER_EMAIL_VERIFICATION table
+	CreateUserEmailVerificationSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_USER_EMAIL_VERIFICATION (
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    VERIFIED_AT FLOAT NOT NULL,
+    PRIMARY KEY (USER_REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+)`
+	// CreateOpaqueTokenSqlite contains SQL to create HANSIP_OPAQUE_TOKEN table
+	CreateOpaqueTokenSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_OPAQUE_TOKEN (
+    TOKEN VARCHAR(64) NOT NULL UNIQUE,
+    SUBJECT VARCHAR(128) NOT NULL,
+    CLAIMS TEXT NOT NULL,
+    EXPIRE FLOAT NOT NULL,
+    PRIMARY KEY (TOKEN)
+)`
+	// CreateTenantRegionSqlite contains SQL to create HANSIP_TENANT_REGION table
+	CreateTenantRegionSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_REGION (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    REGION VARCHAR(64) NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
+)`
+	// CreateTenantSuspensionSqlite contains SQL to create HANSIP_TENANT_SUSPENSION table
+	CreateTenantSuspensionSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_SUSPENSION (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    SUSPENDED_AT FLOAT NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
+)`
+	// CreateTenantBrandingSqlite contains SQL to create HANSIP_TENANT_BRANDING table
+	CreateTenantBrandingSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_BRANDING (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    PRODUCT_NAME VARCHAR(128) NOT NULL,
+    LOGO_URL VARCHAR(255) NOT NULL,
+    SUPPORT_EMAIL VARCHAR(128) NOT NULL,
+    PRIMARY_COLOR VARCHAR(32) NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
+)`
+	// CreateIdempotencyKeySqlite contains SQL to create HANSIP_IDEMPOTENCY_KEY table
+	CreateIdempotencyKeySqlite = `CREATE TABLE IF NOT EXISTS HANSIP_IDEMPOTENCY_KEY (
+    IDEMPOTENCY_KEY VARCHAR(64) NOT NULL,
+    REQUEST_HASH VARCHAR(64) NOT NULL,
+    STATUS_CODE INT NOT NULL,
+    CONTENT_TYPE VARCHAR(128) NOT NULL,
+    BODY TEXT NOT NULL,
+    EXPIRE FLOAT NOT NULL,
+    PRIMARY KEY (IDEMPOTENCY_KEY)
+)`
+	// CreateWebAuthnCredentialSqlite contains SQL to create HANSIP_WEBAUTHN_CREDENTIAL table
+	CreateWebAuthnCredentialSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_WEBAUTHN_CREDENTIAL (
+    REC_ID VARCHAR(32) NOT NULL UNIQUE,
+    USER_REC_ID VARCHAR(32) NOT NULL,
+    CREDENTIAL_NAME VARCHAR(128) NOT NULL,
+    CREDENTIAL_ID VARCHAR(1400) NOT NULL,
+    CREDENTIAL TEXT NOT NULL,
+    CREATED_AT FLOAT NOT NULL,
+    LAST_USED_AT FLOAT NOT NULL,
+    PRIMARY KEY (REC_ID),
+    FOREIGN KEY (USER_REC_ID) REFERENCES HANSIP_USER(REC_ID) ON DELETE CASCADE
+)`
+	// CreateConsumedTokenSqlite contains SQL to create HANSIP_CONSUMED_TOKEN table
+	CreateConsumedTokenSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_CONSUMED_TOKEN (
+    TOKEN_ID VARCHAR(64) NOT NULL,
+    EXPIRE FLOAT NOT NULL,
+    PRIMARY KEY (TOKEN_ID)
+)`
+	// CreateTenantEmailDomainSqlite contains SQL to create HANSIP_TENANT_EMAIL_DOMAIN table
+	CreateTenantEmailDomainSqlite = `CREATE TABLE IF NOT EXISTS HANSIP_TENANT_EMAIL_DOMAIN (
+    TENANT_REC_ID VARCHAR(32) NOT NULL,
+    EMAIL_DOMAIN VARCHAR(255) NOT NULL,
+    PRIMARY KEY (TENANT_REC_ID,EMAIL_DOMAIN),
+    FOREIGN KEY (TENANT_REC_ID) REFERENCES HANSIP_TENANT(REC_ID) ON DELETE CASCADE
 )`
 )
 
@@ -111,12 +228,30 @@ var (
 	sqliteDBInstance *SqliteDB
 )
 
+// sqliteDataSourceName returns db.sqlite.dsn, the file name or the file URI of the database.
+func sqliteDataSourceName() (string, error) {
+	dsn := config.Get("db.sqlite.dsn")
+	if len(dsn) == 0 {
+		return "", fmt.Errorf("db.sqlite.dsn is empty")
+	}
+	if strings.HasPrefix(dsn, "file:") {
+		if _, err := url.Parse(dsn); err != nil {
+			return "", fmt.Errorf("invalid db.sqlite.dsn. got %s", err.Error())
+		}
+	}
+	return dsn, nil
+}
+
 // GetSqliteDBInstance will obtain the singleton instance to SqliteDB
 func GetSqliteDBInstance() *SqliteDB {
 	if sqliteDBInstance == nil {
-		db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+		dsn, err := sqliteDataSourceName()
+		if err != nil {
+			sqliteLog.WithField("func", "GetSqliteDBInstance").Fatalf("sqliteDataSourceName got %s", err.Error())
+		}
+		db, err := openDB("sqlite3", dsn, "sqlite")
 		if err != nil {
-			sqliteLog.WithField("func", "GetSqliteDBInstance").Fatalf("sql.Open got %s", err.Error())
+			sqliteLog.WithField("func", "GetSqliteDBInstance").Fatalf("openDB got %s", err.Error())
 		}
 
 		//		db.SetMaxOpenConns(config.GetInt("db.pool.maxopen"))
@@ -267,6 +402,188 @@ func (db *SqliteDB) InitDB(ctx context.Context) error {
 		}
 	}
 
+	fLog.Infof("Checking table HANSIP_GROUP_PARENT")
+	exist, err = db.isTableExist(ctx, "HANSIP_GROUP_PARENT")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_GROUP_PARENT")
+		_, err := db.instance.ExecContext(ctx, CreateGroupParentSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_GROUP_PARENT Got %s. SQL = %s", err.Error(), CreateGroupParentSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_AUDIT")
+	exist, err = db.isTableExist(ctx, "HANSIP_AUDIT")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_AUDIT")
+		_, err := db.instance.ExecContext(ctx, CreateAuditSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_AUDIT Got %s. SQL = %s", err.Error(), CreateAuditSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_PASSPHRASE_HISTORY")
+	exist, err = db.isTableExist(ctx, "HANSIP_PASSPHRASE_HISTORY")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_PASSPHRASE_HISTORY")
+		_, err := db.instance.ExecContext(ctx, CreatePassphraseHistorySqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_HISTORY Got %s. SQL = %s", err.Error(), CreatePassphraseHistorySqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_PASSPHRASE_CHANGE")
+	exist, err = db.isTableExist(ctx, "HANSIP_PASSPHRASE_CHANGE")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_PASSPHRASE_CHANGE")
+		_, err := db.instance.ExecContext(ctx, CreatePassphraseChangeSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_CHANGE Got %s. SQL = %s", err.Error(), CreatePassphraseChangeSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_USER_DEACTIVATION")
+	exist, err = db.isTableExist(ctx, "HANSIP_USER_DEACTIVATION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_USER_DEACTIVATION")
+		_, err := db.instance.ExecContext(ctx, CreateUserDeactivationSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_USER_DEACTIVATION Got %s. SQL = %s", err.Error(), CreateUserDeactivationSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_USER_EMAIL_VERIFICATION")
+	exist, err = db.isTableExist(ctx, "HANSIP_USER_EMAIL_VERIFICATION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_USER_EMAIL_VERIFICATION")
+		_, err := db.instance.ExecContext(ctx, CreateUserEmailVerificationSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_USER_EMAIL_VERIFICATION Got %s. SQL = %s", err.Error(), CreateUserEmailVerificationSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_OPAQUE_TOKEN")
+	exist, err = db.isTableExist(ctx, "HANSIP_OPAQUE_TOKEN")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_OPAQUE_TOKEN")
+		_, err := db.instance.ExecContext(ctx, CreateOpaqueTokenSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_OPAQUE_TOKEN Got %s. SQL = %s", err.Error(), CreateOpaqueTokenSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_REGION")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_REGION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_REGION")
+		_, err := db.instance.ExecContext(ctx, CreateTenantRegionSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_REGION Got %s. SQL = %s", err.Error(), CreateTenantRegionSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_SUSPENSION")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_SUSPENSION")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_SUSPENSION")
+		_, err := db.instance.ExecContext(ctx, CreateTenantSuspensionSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_SUSPENSION Got %s. SQL = %s", err.Error(), CreateTenantSuspensionSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_BRANDING")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_BRANDING")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_BRANDING")
+		_, err := db.instance.ExecContext(ctx, CreateTenantBrandingSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_BRANDING Got %s. SQL = %s", err.Error(), CreateTenantBrandingSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_IDEMPOTENCY_KEY")
+	exist, err = db.isTableExist(ctx, "HANSIP_IDEMPOTENCY_KEY")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_IDEMPOTENCY_KEY")
+		_, err := db.instance.ExecContext(ctx, CreateIdempotencyKeySqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_IDEMPOTENCY_KEY Got %s. SQL = %s", err.Error(), CreateIdempotencyKeySqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_WEBAUTHN_CREDENTIAL")
+	exist, err = db.isTableExist(ctx, "HANSIP_WEBAUTHN_CREDENTIAL")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_WEBAUTHN_CREDENTIAL")
+		_, err := db.instance.ExecContext(ctx, CreateWebAuthnCredentialSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_WEBAUTHN_CREDENTIAL Got %s. SQL = %s", err.Error(), CreateWebAuthnCredentialSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_CONSUMED_TOKEN")
+	exist, err = db.isTableExist(ctx, "HANSIP_CONSUMED_TOKEN")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_CONSUMED_TOKEN")
+		_, err := db.instance.ExecContext(ctx, CreateConsumedTokenSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_CONSUMED_TOKEN Got %s. SQL = %s", err.Error(), CreateConsumedTokenSqlite)
+		}
+	}
+
+	fLog.Infof("Checking table HANSIP_TENANT_EMAIL_DOMAIN")
+	exist, err = db.isTableExist(ctx, "HANSIP_TENANT_EMAIL_DOMAIN")
+	if err != nil {
+		return err
+	}
+	if !exist {
+		fLog.Infof("Create table HANSIP_TENANT_EMAIL_DOMAIN")
+		_, err := db.instance.ExecContext(ctx, CreateTenantEmailDomainSqlite)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_EMAIL_DOMAIN Got %s. SQL = %s", err.Error(), CreateTenantEmailDomainSqlite)
+		}
+	}
+
 	hansipDomain := config.Get("hansip.domain")
 	handipAdmin := config.Get("hansip.admin")
 
@@ -483,6 +800,132 @@ func (db *SqliteDB) CreateAllTable(ctx context.Context) error {
 			SQL:     CreateRevocationSqlite,
 		}
 	}
+	_, err = db.instance.ExecContext(ctx, CreateGroupParentSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_GROUP_PARENT Got %s. SQL = %s", err.Error(), CreateGroupParentSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_GROUP_PARENT",
+			SQL:     CreateGroupParentSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateAuditSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_AUDIT Got %s. SQL = %s", err.Error(), CreateAuditSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_AUDIT",
+			SQL:     CreateAuditSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreatePassphraseHistorySqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_HISTORY Got %s. SQL = %s", err.Error(), CreatePassphraseHistorySqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_PASSPHRASE_HISTORY",
+			SQL:     CreatePassphraseHistorySqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreatePassphraseChangeSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_PASSPHRASE_CHANGE Got %s. SQL = %s", err.Error(), CreatePassphraseChangeSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_PASSPHRASE_CHANGE",
+			SQL:     CreatePassphraseChangeSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateUserDeactivationSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_USER_DEACTIVATION Got %s. SQL = %s", err.Error(), CreateUserDeactivationSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_USER_DEACTIVATION",
+			SQL:     CreateUserDeactivationSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateUserEmailVerificationSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_USER_EMAIL_VERIFICATION Got %s. SQL = %s", err.Error(), CreateUserEmailVerificationSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_USER_EMAIL_VERIFICATION",
+			SQL:     CreateUserEmailVerificationSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateOpaqueTokenSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_OPAQUE_TOKEN Got %s. SQL = %s", err.Error(), CreateOpaqueTokenSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_OPAQUE_TOKEN",
+			SQL:     CreateOpaqueTokenSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantRegionSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_REGION Got %s. SQL = %s", err.Error(), CreateTenantRegionSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_REGION",
+			SQL:     CreateTenantRegionSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantSuspensionSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_SUSPENSION Got %s. SQL = %s", err.Error(), CreateTenantSuspensionSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_SUSPENSION",
+			SQL:     CreateTenantSuspensionSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantBrandingSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_BRANDING Got %s. SQL = %s", err.Error(), CreateTenantBrandingSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_BRANDING",
+			SQL:     CreateTenantBrandingSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateIdempotencyKeySqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_IDEMPOTENCY_KEY Got %s. SQL = %s", err.Error(), CreateIdempotencyKeySqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_IDEMPOTENCY_KEY",
+			SQL:     CreateIdempotencyKeySqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateWebAuthnCredentialSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_WEBAUTHN_CREDENTIAL Got %s. SQL = %s", err.Error(), CreateWebAuthnCredentialSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_WEBAUTHN_CREDENTIAL",
+			SQL:     CreateWebAuthnCredentialSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateConsumedTokenSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_CONSUMED_TOKEN Got %s. SQL = %s", err.Error(), CreateConsumedTokenSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_CONSUMED_TOKEN",
+			SQL:     CreateConsumedTokenSqlite,
+		}
+	}
+	_, err = db.instance.ExecContext(ctx, CreateTenantEmailDomainSqlite)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext HANSIP_TENANT_EMAIL_DOMAIN Got %s. SQL = %s", err.Error(), CreateTenantEmailDomainSqlite)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error while trying to create table HANSIP_TENANT_EMAIL_DOMAIN",
+			SQL:     CreateTenantEmailDomainSqlite,
+		}
+	}
 	_, err = db.CreateRole(ctx, hansipAdmin, hansipDomain, "Administrator role")
 	if err != nil {
 		fLog.Errorf("db.CreateRole Got %s", err.Error())
@@ -537,7 +980,7 @@ func (db *SqliteDB) GetTenantByRecID(ctx context.Context, recID string) (*Tenant
 func (db *SqliteDB) CreateTenantRecord(ctx context.Context, tenantName, tenantDomain, description string) (*Tenant, error) {
 	fLog := sqliteLog.WithField("func", "CreateTenantRecord").WithField("RequestID", ctx.Value(constants.RequestID))
 	tenant := &Tenant{
-		RecID:       helper.MakeRandomString(10, true, true, true, false),
+		RecID:       NewRecID(),
 		Name:        tenantName,
 		Domain:      tenantDomain,
 		Description: description,
@@ -791,7 +1234,7 @@ func (db *SqliteDB) GetUserByRecID(ctx context.Context, recID string) (*User, er
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE REC_ID = ?"
 	row := db.instance.QueryRowContext(ctx, q, recID)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-		&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -821,37 +1264,21 @@ func (db *SqliteDB) GetUserByRecID(ctx context.Context, recID string) (*User, er
 // CreateUserRecord create a new user
 func (db *SqliteDB) CreateUserRecord(ctx context.Context, email, passphrase string) (*User, error) {
 	fLog := sqliteLog.WithField("func", "CreateUserRecord").WithField("RequestID", ctx.Value(constants.RequestID))
-	bytes, err := bcrypt.GenerateFromPassword([]byte(passphrase), 14)
+	user, err := NewUserRecord(email, passphrase)
 	if err != nil {
-		fLog.Errorf("bcrypt.GenerateFromPassword got %s", err.Error())
+		fLog.Errorf("NewUserRecord got %s", err.Error())
 		return nil, &ErrLibraryCallError{
 			Wrapped:     err,
 			Message:     "Error CreateUserRecord",
 			LibraryName: "bcrypt",
 		}
 	}
-	user := &User{
-		RecID:             helper.MakeRandomString(10, true, true, true, false),
-		Email:             email,
-		HashedPassphrase:  string(bytes),
-		Enabled:           false,
-		Suspended:         false,
-		LastSeen:          time.Now(),
-		LastLogin:         time.Now(),
-		FailCount:         0,
-		ActivationCode:    helper.MakeRandomString(6, true, false, false, false),
-		ActivationDate:    time.Now(),
-		Enable2FactorAuth: false,
-		UserTotpSecretKey: totp.MakeSecret().Base32(),
-		Token2FA:          helper.MakeRandomString(6, true, false, false, false),
-		RecoveryCode:      helper.MakeRandomString(6, true, false, false, false),
-	}
 
 	q := "INSERT INTO HANSIP_USER(REC_ID,EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 
 	_, err = db.instance.ExecContext(ctx, q,
 		user.RecID, user.Email, user.HashedPassphrase, 0, 0, user.LastSeen, user.LastLogin, user.FailCount, user.ActivationCode,
-		user.ActivationDate, user.UserTotpSecretKey, user.Enable2FactorAuth, user.Token2FA, user.RecoveryCode)
+		user.ActivationDate, sealedField{FieldTOTPKey, user.UserTotpSecretKey}, user.Enable2FactorAuth, user.Token2FA, user.RecoveryCode)
 
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
@@ -917,7 +1344,7 @@ func (db *SqliteDB) RecreateTOTPRecoveryCodes(ctx context.Context, user *User) (
 	// Now lets recreate all new records.
 	ret := make([]string, 0)
 	for i := 0; i < 16; i++ {
-		recID := helper.MakeRandomString(10, true, true, true, false)
+		recID := NewRecID()
 		code := helper.MakeRandomString(8, true, false, true, false)
 		q = "INSERT INTO HANSIP_TOTP_RECOVERY_CODES(REC_ID, RECOVERY_CODE, USED_FLAG, USER_REC_ID) VALUES (?,?,?,?)"
 		_, err := db.instance.ExecContext(ctx, q, recID, code, 0, user.RecID)
@@ -966,7 +1393,7 @@ func (db *SqliteDB) GetUserByEmail(ctx context.Context, email string) (*User, er
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE EMAIL = ?"
 	row := db.instance.QueryRowContext(ctx, q, email)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-		&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1002,7 +1429,7 @@ func (db *SqliteDB) GetUserBy2FAToken(ctx context.Context, token string) (*User,
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE TOKEN_2FE = ?"
 	row := db.instance.QueryRowContext(ctx, q, token)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-		&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1038,7 +1465,7 @@ func (db *SqliteDB) GetUserByRecoveryToken(ctx context.Context, token string) (*
 	q := "SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE RECOVERY_CODE = ?"
 	row := db.instance.QueryRowContext(ctx, q, token)
 	err := row.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-		&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+		&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -1142,7 +1569,7 @@ func (db *SqliteDB) UpdateUser(ctx context.Context, user *User) error {
 	fLog.Infof("Updating user %s", user.Email)
 	_, err = db.instance.ExecContext(ctx, q,
 		user.Email, user.HashedPassphrase, enabled, suspended, user.LastSeen, user.LastLogin, user.FailCount, user.ActivationCode,
-		user.ActivationDate, user.UserTotpSecretKey, enable2fa, user.Token2FA, user.RecoveryCode, user.RecID)
+		user.ActivationDate, sealedField{FieldTOTPKey, user.UserTotpSecretKey}, enable2fa, user.Token2FA, user.RecoveryCode, user.RecID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
 		return &ErrDBExecuteError{
@@ -1165,7 +1592,13 @@ func (db *SqliteDB) ListUser(ctx context.Context, request *helper.PageRequest) (
 	page := helper.NewPage(request, uint(count))
 	userList := make([]*User, 0)
 	q := fmt.Sprintf("SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER ORDER BY EMAIL %s LIMIT %d, %d", request.Sort, page.OffsetStart, page.OffsetEnd-page.OffsetStart)
-	rows, err := db.instance.QueryContext(ctx, q)
+	args := make([]interface{}, 0)
+	if request.Cursor != nil {
+		// keyset pagination on the unique EMAIL, one more user is listed to know whether there is a next page
+		q = fmt.Sprintf("SELECT REC_ID, EMAIL,HASHED_PASSPHRASE,ENABLED, SUSPENDED,LAST_SEEN,LAST_LOGIN,FAIL_COUNT,ACTIVATION_CODE,ACTIVATION_DATE,TOTP_KEY,ENABLE_2FE,TOKEN_2FE,RECOVERY_CODE FROM HANSIP_USER WHERE EMAIL %s ? ORDER BY EMAIL %s LIMIT %d", keysetOperator(request.Sort), request.Sort, request.PageSize+1)
+		args = append(args, request.Cursor.Key)
+	}
+	rows, err := db.instance.QueryContext(ctx, q, args...)
 	if err != nil {
 		fLog.Errorf("db.instance.QueryContext got %s. SQL = %s", err.Error(), q)
 		return nil, nil, &ErrDBQueryError{
@@ -1180,7 +1613,7 @@ func (db *SqliteDB) ListUser(ctx context.Context, request *helper.PageRequest) (
 		var enabled, suspended, enable2fa int
 		var lastSeen, lastLogin, activationDate float64
 		err := rows.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-			&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+			&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 		if err != nil {
 			fLog.Warnf("rows.Scan got %s", err.Error())
 			return nil, nil, &ErrDBScanError{
@@ -1204,6 +1637,13 @@ func (db *SqliteDB) ListUser(ctx context.Context, request *helper.PageRequest) (
 			userList = append(userList, user)
 		}
 	}
+	if request.Cursor != nil {
+		hasNext := uint(len(userList)) > request.PageSize
+		if hasNext {
+			userList = userList[:request.PageSize]
+		}
+		page = helper.NewCursorPage(request, uint(count), uint(len(userList)), hasNext)
+	}
 	return userList, page, nil
 }
 
@@ -1254,30 +1694,25 @@ func (db *SqliteDB) ListAllUserRoles(ctx context.Context, user *User, request *h
 		}
 	}
 	rows.Close()
-	q = "SELECT DISTINCT R.REC_ID, R.ROLE_NAME, R.ROLE_DOMAIN, R.DESCRIPTION FROM HANSIP_ROLE R, HANSIP_GROUP_ROLE GR, HANSIP_USER_GROUP UG WHERE R.REC_ID = GR.ROLE_REC_ID AND GR.GROUP_REC_ID = UG.GROUP_REC_ID AND UG.USER_REC_ID = ?"
-	rows, err = db.instance.QueryContext(ctx, q, user.RecID)
+
+	// Add roles from the user's groups and all of the groups' ancestors.
+	groups, _, err := db.ListUserGroupByUser(ctx, user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "GROUP_NAME",
+		Sort:     "ASC",
+	})
 	if err != nil {
-		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
-		return nil, nil, &ErrDBQueryError{
-			Wrapped: err,
-			Message: "Error ListAllUserRoles",
-			SQL:     q,
-		}
+		fLog.Errorf("db.ListUserGroupByUser got  %s", err.Error())
+		return nil, nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		r := &Role{}
-		err = rows.Scan(&r.RecID, &r.RoleName, &r.RoleDomain, &r.Description)
-		if err != nil {
-			fLog.Warnf("rows.Scan got  %s", err.Error())
-			return nil, nil, &ErrDBScanError{
-				Wrapped: err,
-				Message: "Error ListAllUserRoles",
-				SQL:     q,
-			}
-		} else {
-			roleMap[r.RecID] = r
-		}
+	groupRoles, err := ResolveEffectiveGroupRoles(ctx, db, db, groups)
+	if err != nil {
+		fLog.Errorf("ResolveEffectiveGroupRoles got  %s", err.Error())
+		return nil, nil, err
+	}
+	for _, r := range groupRoles {
+		roleMap[r.RecID] = r
 	}
 
 	page := helper.NewPage(request, uint(len(roleMap)))
@@ -1432,7 +1867,7 @@ func (db *SqliteDB) ListUserRoleByRole(ctx context.Context, role *Role, request
 		var enabled, suspended, enable2fa int
 		var lastSeen, lastLogin, activationDate float64
 		err := rows.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-			&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+			&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 		if err != nil {
 			fLog.Warnf("rows.Scan got  %s", err.Error())
 			return nil, nil, &ErrDBScanError{
@@ -1553,7 +1988,7 @@ func (db *SqliteDB) GetRoleByName(ctx context.Context, roleName, roleDomain stri
 func (db *SqliteDB) CreateRole(ctx context.Context, roleName, roleDomain, description string) (*Role, error) {
 	fLog := sqliteLog.WithField("func", "CreateRole").WithField("RequestID", ctx.Value(constants.RequestID))
 	r := &Role{
-		RecID:       helper.MakeRandomString(10, true, true, true, false),
+		RecID:       NewRecID(),
 		RoleName:    roleName,
 		RoleDomain:  roleDomain,
 		Description: description,
@@ -1734,7 +2169,7 @@ func (db *SqliteDB) GetGroupByName(ctx context.Context, groupName, groupDomain s
 func (db *SqliteDB) CreateGroup(ctx context.Context, groupName, groupDomain, description string) (*Group, error) {
 	fLog := sqliteLog.WithField("func", "CreateGroup").WithField("RequestID", ctx.Value(constants.RequestID))
 	r := &Group{
-		RecID:       helper.MakeRandomString(10, true, true, true, false),
+		RecID:       NewRecID(),
 		GroupName:   groupName,
 		GroupDomain: groupDomain,
 		Description: description,
@@ -1871,11 +2306,98 @@ func (db *SqliteDB) UpdateGroup(ctx context.Context, group *Group) error {
 	return nil
 }
 
-// GetGroupRole get GroupRole relation
-func (db *SqliteDB) GetGroupRole(ctx context.Context, group *Group, role *Role) (*GroupRole, error) {
-	fLog := sqliteLog.WithField("func", "GetGroupRole").WithField("RequestID", ctx.Value(constants.RequestID))
-	q := "SELECT COUNT(*) CNT FROM HANSIP_GROUP_ROLE WHERE GROUP_REC_ID=? AND ROLE_REC_ID=?"
-	row := db.instance.QueryRowContext(ctx, q, group.RecID, role.RecID)
+// GetParentGroup return the parent of a group, nil if the group has no parent
+func (db *SqliteDB) GetParentGroup(ctx context.Context, group *Group) (*Group, error) {
+	fLog := sqliteLog.WithField("func", "GetParentGroup").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT G.REC_ID, G.GROUP_NAME, G.GROUP_DOMAIN, G.DESCRIPTION FROM HANSIP_GROUP G, HANSIP_GROUP_PARENT GP WHERE G.REC_ID = GP.PARENT_REC_ID AND GP.GROUP_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, group.RecID)
+	r := &Group{}
+	err := row.Scan(&r.RecID, &r.GroupName, &r.GroupDomain, &r.Description)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("db.instance.QueryRowContext got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetParentGroup",
+			SQL:     q,
+		}
+	}
+	return r, nil
+}
+
+// SetParentGroup set the parent of a group, nil parent will make the group a root group
+func (db *SqliteDB) SetParentGroup(ctx context.Context, group, parent *Group) error {
+	fLog := sqliteLog.WithField("func", "SetParentGroup").WithField("RequestID", ctx.Value(constants.RequestID))
+	if parent != nil {
+		err := ValidateParentGroup(ctx, db, group, parent)
+		if err != nil {
+			return err
+		}
+	}
+	q := "DELETE FROM HANSIP_GROUP_PARENT WHERE GROUP_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, group.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetParentGroup",
+			SQL:     q,
+		}
+	}
+	if parent == nil {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_GROUP_PARENT(GROUP_REC_ID, PARENT_REC_ID) VALUES (?,?)"
+	_, err = db.instance.ExecContext(ctx, q, group.RecID, parent.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetParentGroup",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// ListChildGroups list the direct sub-groups of a group
+func (db *SqliteDB) ListChildGroups(ctx context.Context, group *Group) ([]*Group, error) {
+	fLog := sqliteLog.WithField("func", "ListChildGroups").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT G.REC_ID, G.GROUP_NAME, G.GROUP_DOMAIN, G.DESCRIPTION FROM HANSIP_GROUP G, HANSIP_GROUP_PARENT GP WHERE G.REC_ID = GP.GROUP_REC_ID AND GP.PARENT_REC_ID=? ORDER BY G.GROUP_NAME ASC"
+	rows, err := db.instance.QueryContext(ctx, q, group.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error ListChildGroups",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	ret := make([]*Group, 0)
+	for rows.Next() {
+		r := &Group{}
+		err := rows.Scan(&r.RecID, &r.GroupName, &r.GroupDomain, &r.Description)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error ListChildGroups",
+				SQL:     q,
+			}
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// GetGroupRole get GroupRole relation
+func (db *SqliteDB) GetGroupRole(ctx context.Context, group *Group, role *Role) (*GroupRole, error) {
+	fLog := sqliteLog.WithField("func", "GetGroupRole").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) CNT FROM HANSIP_GROUP_ROLE WHERE GROUP_REC_ID=? AND ROLE_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, group.RecID, role.RecID)
 	count := 0
 	err := row.Scan(&count)
 	if err != nil {
@@ -2208,7 +2730,7 @@ func (db *SqliteDB) ListUserGroupByGroup(ctx context.Context, group *Group, requ
 		var enabled, suspended, enable2fa int
 		var lastSeen, lastLogin, activationDate float64
 		err := rows.Scan(&user.RecID, &user.Email, &user.HashedPassphrase, &enabled, &suspended, &lastSeen, &lastLogin, &user.FailCount, &user.ActivationCode,
-			&activationDate, &user.UserTotpSecretKey, &enable2fa, &user.Token2FA, &user.RecoveryCode)
+			&activationDate, openedField{FieldTOTPKey, &user.UserTotpSecretKey}, &enable2fa, &user.Token2FA, &user.RecoveryCode)
 		if err != nil {
 			fLog.Warnf("rows.Scan got  %s", err.Error())
 			return nil, nil, &ErrDBScanError{
@@ -2359,3 +2881,815 @@ func (db *SqliteDB) IsRevoked(ctx context.Context, subject string) (bool, error)
 	}
 	return false, nil
 }
+
+// CreateAudit records an audit event
+func (db *SqliteDB) CreateAudit(ctx context.Context, eventType, actor, target, detail string) (*Audit, error) {
+	fLog := sqliteLog.WithField("func", "CreateAudit").WithField("RequestID", ctx.Value(constants.RequestID))
+	audit := &Audit{
+		RecID:     NewRecID(),
+		EventTime: time.Now(),
+		EventType: eventType,
+		Actor:     actor,
+		Target:    target,
+		Detail:    detail,
+	}
+	if requestID, ok := ctx.Value(constants.RequestID).(string); ok {
+		audit.RequestID = requestID
+	}
+	q := "INSERT INTO HANSIP_AUDIT(REC_ID, EVENT_TIME, EVENT_TYPE, ACTOR, TARGET, DETAIL, REQUEST_ID) VALUES (?,?,?,?,?,?,?)"
+	_, err := db.instance.ExecContext(ctx, q, audit.RecID, audit.EventTime.Sub(coreEpoch).Seconds(), audit.EventType, audit.Actor, audit.Target, audit.Detail, audit.RequestID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateAudit",
+			SQL:     q,
+		}
+	}
+	return audit, nil
+}
+
+// EachAuditBefore calls each with every audit event that occurred before the time, oldest first. An error from each stops the iteration
+func (db *SqliteDB) EachAuditBefore(ctx context.Context, before time.Time, each func(audit *Audit) error) error {
+	fLog := sqliteLog.WithField("func", "EachAuditBefore").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REC_ID, EVENT_TIME, EVENT_TYPE, ACTOR, TARGET, DETAIL, REQUEST_ID FROM HANSIP_AUDIT WHERE EVENT_TIME < ? ORDER BY EVENT_TIME ASC"
+	rows, err := db.instance.QueryContext(ctx, q, before.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error EachAuditBefore",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		audit := &Audit{}
+		var eventTime float64
+		var actor, target, detail, requestID sql.NullString
+		err := rows.Scan(&audit.RecID, &eventTime, &audit.EventType, &actor, &target, &detail, &requestID)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error EachAuditBefore",
+				SQL:     q,
+			}
+		}
+		audit.EventTime = coreEpoch.Add(time.Duration(eventTime * float64(time.Second)))
+		audit.Actor, audit.Target, audit.Detail, audit.RequestID = actor.String, target.String, detail.String, requestID.String
+		if err := each(audit); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PurgeAuditsBefore deletes the audit events that occurred before the time and returns how many were deleted
+func (db *SqliteDB) PurgeAuditsBefore(ctx context.Context, before time.Time) (int64, error) {
+	fLog := sqliteLog.WithField("func", "PurgeAuditsBefore").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_AUDIT WHERE EVENT_TIME < ?"
+	result, err := db.instance.ExecContext(ctx, q, before.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return 0, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error PurgeAuditsBefore",
+			SQL:     q,
+		}
+	}
+	return result.RowsAffected()
+}
+
+// AddPassphraseHistory records a hashed passphrase previously used by the user, only the latest keep entries are retained
+func (db *SqliteDB) AddPassphraseHistory(ctx context.Context, user *User, hashedPassphrase string, keep int) error {
+	fLog := sqliteLog.WithField("func", "AddPassphraseHistory").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "INSERT INTO HANSIP_PASSPHRASE_HISTORY(REC_ID, USER_REC_ID, HASHED_PASSPHRASE, CREATED_AT) VALUES (?,?,?,?)"
+	_, err := db.instance.ExecContext(ctx, q, NewRecID(), user.RecID, hashedPassphrase, time.Now().Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error AddPassphraseHistory",
+			SQL:     q,
+		}
+	}
+	q = "SELECT REC_ID FROM HANSIP_PASSPHRASE_HISTORY WHERE USER_REC_ID=? ORDER BY CREATED_AT DESC"
+	rows, err := db.instance.QueryContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error AddPassphraseHistory",
+			SQL:     q,
+		}
+	}
+	prune := make([]string, 0)
+	count := 0
+	for rows.Next() {
+		recID := ""
+		err := rows.Scan(&recID)
+		if err != nil {
+			rows.Close()
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error AddPassphraseHistory",
+				SQL:     q,
+			}
+		}
+		count++
+		if count > keep {
+			prune = append(prune, recID)
+		}
+	}
+	rows.Close()
+	q = "DELETE FROM HANSIP_PASSPHRASE_HISTORY WHERE REC_ID=?"
+	for _, recID := range prune {
+		_, err := db.instance.ExecContext(ctx, q, recID)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+			return &ErrDBExecuteError{
+				Wrapped: err,
+				Message: "Error AddPassphraseHistory",
+				SQL:     q,
+			}
+		}
+	}
+	return nil
+}
+
+// ListPassphraseHistory returns at most limit of the user's previous hashed passphrases, the latest first
+func (db *SqliteDB) ListPassphraseHistory(ctx context.Context, user *User, limit int) ([]string, error) {
+	fLog := sqliteLog.WithField("func", "ListPassphraseHistory").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT HASHED_PASSPHRASE FROM HANSIP_PASSPHRASE_HISTORY WHERE USER_REC_ID=? ORDER BY CREATED_AT DESC LIMIT ?"
+	rows, err := db.instance.QueryContext(ctx, q, user.RecID, limit)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error ListPassphraseHistory",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	ret := make([]string, 0)
+	for rows.Next() {
+		hashed := ""
+		err := rows.Scan(&hashed)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error ListPassphraseHistory",
+				SQL:     q,
+			}
+		}
+		ret = append(ret, hashed)
+	}
+	return ret, nil
+}
+
+// GetPassphraseChangedAt returns the time the user's passphrase was last changed, nil if it was never recorded
+func (db *SqliteDB) GetPassphraseChangedAt(ctx context.Context, user *User) (*time.Time, error) {
+	fLog := sqliteLog.WithField("func", "GetPassphraseChangedAt").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT PASSPHRASE_CHANGED_AT FROM HANSIP_PASSPHRASE_CHANGE WHERE USER_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, user.RecID)
+	var changedAt float64
+	err := row.Scan(&changedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetPassphraseChangedAt",
+			SQL:     q,
+		}
+	}
+	ret := coreEpoch.Add(time.Duration(changedAt * float64(time.Second)))
+	return &ret, nil
+}
+
+// SetPassphraseChangedAt records the time the user's passphrase was changed
+func (db *SqliteDB) SetPassphraseChangedAt(ctx context.Context, user *User, changedAt time.Time) error {
+	fLog := sqliteLog.WithField("func", "SetPassphraseChangedAt").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_PASSPHRASE_CHANGE WHERE USER_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetPassphraseChangedAt",
+			SQL:     q,
+		}
+	}
+	q = "INSERT INTO HANSIP_PASSPHRASE_CHANGE(USER_REC_ID, PASSPHRASE_CHANGED_AT) VALUES (?,?)"
+	_, err = db.instance.ExecContext(ctx, q, user.RecID, changedAt.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetPassphraseChangedAt",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// SetUserActive deactivate or reactivate a user account. Deactivated user is kept but can not authenticate.
+func (db *SqliteDB) SetUserActive(ctx context.Context, user *User, active bool) error {
+	fLog := sqliteLog.WithField("func", "SetUserActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_USER_DEACTIVATION WHERE USER_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetUserActive",
+			SQL:     q,
+		}
+	}
+	if active {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_USER_DEACTIVATION(USER_REC_ID, DEACTIVATED_AT) VALUES (?,?)"
+	_, err = db.instance.ExecContext(ctx, q, user.RecID, time.Now().Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetUserActive",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// IsUserActive check whether the user account is not deactivated
+func (db *SqliteDB) IsUserActive(ctx context.Context, user *User) (bool, error) {
+	fLog := sqliteLog.WithField("func", "IsUserActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) AS CNT FROM HANSIP_USER_DEACTIVATION WHERE USER_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, user.RecID)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error IsUserActive",
+			SQL:     q,
+		}
+	}
+	return count == 0, nil
+}
+
+// SetEmailVerified marks whether the user's current email address has been verified
+func (db *SqliteDB) SetEmailVerified(ctx context.Context, user *User, verified bool) error {
+	fLog := sqliteLog.WithField("func", "SetEmailVerified").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_USER_EMAIL_VERIFICATION WHERE USER_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetEmailVerified",
+			SQL:     q,
+		}
+	}
+	if !verified {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_USER_EMAIL_VERIFICATION(USER_REC_ID, VERIFIED_AT) VALUES (?,?)"
+	_, err = db.instance.ExecContext(ctx, q, user.RecID, time.Now().Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetEmailVerified",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// IsEmailVerified check whether the user's current email address has been verified
+func (db *SqliteDB) IsEmailVerified(ctx context.Context, user *User) (bool, error) {
+	fLog := sqliteLog.WithField("func", "IsEmailVerified").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) AS CNT FROM HANSIP_USER_EMAIL_VERIFICATION WHERE USER_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, user.RecID)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error IsEmailVerified",
+			SQL:     q,
+		}
+	}
+	return count > 0, nil
+}
+
+// SaveOpaqueToken stores the claims of an opaque token under its key
+func (db *SqliteDB) SaveOpaqueToken(ctx context.Context, key string, token *helper.HansipToken) error {
+	fLog := sqliteLog.WithField("func", "SaveOpaqueToken").WithField("RequestID", ctx.Value(constants.RequestID))
+	claims, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	q := "INSERT INTO HANSIP_OPAQUE_TOKEN(TOKEN, SUBJECT, CLAIMS, EXPIRE) VALUES (?,?,?,?)"
+	_, err = db.instance.ExecContext(ctx, q, key, token.Subject, string(claims), token.Expire.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SaveOpaqueToken",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// GetOpaqueToken returns the claims of the opaque token with the key, nil if the token is not found
+func (db *SqliteDB) GetOpaqueToken(ctx context.Context, key string) (*helper.HansipToken, error) {
+	fLog := sqliteLog.WithField("func", "GetOpaqueToken").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT CLAIMS FROM HANSIP_OPAQUE_TOKEN WHERE TOKEN=?"
+	row := db.instance.QueryRowContext(ctx, q, key)
+	var claims string
+	err := row.Scan(&claims)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetOpaqueToken",
+			SQL:     q,
+		}
+	}
+	ret := &helper.HansipToken{}
+	err = json.Unmarshal([]byte(claims), ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DeleteOpaqueToken removes the opaque token with the key
+func (db *SqliteDB) DeleteOpaqueToken(ctx context.Context, key string) error {
+	fLog := sqliteLog.WithField("func", "DeleteOpaqueToken").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_OPAQUE_TOKEN WHERE TOKEN=?"
+	_, err := db.instance.ExecContext(ctx, q, key)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error DeleteOpaqueToken",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// DeleteOpaqueTokensBySubject removes all opaque tokens of a subject
+func (db *SqliteDB) DeleteOpaqueTokensBySubject(ctx context.Context, subject string) error {
+	fLog := sqliteLog.WithField("func", "DeleteOpaqueTokensBySubject").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_OPAQUE_TOKEN WHERE SUBJECT=?"
+	_, err := db.instance.ExecContext(ctx, q, subject)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error DeleteOpaqueTokensBySubject",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// PurgeOpaqueTokensBefore removes the opaque tokens expired before the time. Returns the number of tokens removed
+func (db *SqliteDB) PurgeOpaqueTokensBefore(ctx context.Context, before time.Time) (int64, error) {
+	fLog := sqliteLog.WithField("func", "PurgeOpaqueTokensBefore").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_OPAQUE_TOKEN WHERE EXPIRE < ?"
+	result, err := db.instance.ExecContext(ctx, q, before.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return 0, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error PurgeOpaqueTokensBefore",
+			SQL:     q,
+		}
+	}
+	return result.RowsAffected()
+}
+
+// GetTenantRegion returns the region of a tenant, empty if the tenant has no region
+func (db *SqliteDB) GetTenantRegion(ctx context.Context, tenant *Tenant) (string, error) {
+	fLog := sqliteLog.WithField("func", "GetTenantRegion").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REGION FROM HANSIP_TENANT_REGION WHERE TENANT_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, tenant.RecID)
+	var region string
+	err := row.Scan(&region)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return "", &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetTenantRegion",
+			SQL:     q,
+		}
+	}
+	return region, nil
+}
+
+// SetTenantRegion sets the region of a tenant. An empty region removes the tenant's region
+func (db *SqliteDB) SetTenantRegion(ctx context.Context, tenant *Tenant, region string) error {
+	fLog := sqliteLog.WithField("func", "SetTenantRegion").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_REGION WHERE TENANT_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantRegion",
+			SQL:     q,
+		}
+	}
+	if len(region) == 0 {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_TENANT_REGION(TENANT_REC_ID, REGION) VALUES (?,?)"
+	_, err = db.instance.ExecContext(ctx, q, tenant.RecID, region)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantRegion",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// SetTenantActive suspends or reactivates a tenant. The users of a suspended tenant can not access Hansip.
+func (db *SqliteDB) SetTenantActive(ctx context.Context, tenant *Tenant, active bool) error {
+	fLog := sqliteLog.WithField("func", "SetTenantActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_SUSPENSION WHERE TENANT_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantActive",
+			SQL:     q,
+		}
+	}
+	if active {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_TENANT_SUSPENSION(TENANT_REC_ID, SUSPENDED_AT) VALUES (?,?)"
+	_, err = db.instance.ExecContext(ctx, q, tenant.RecID, time.Now().Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantActive",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// IsTenantActive check whether the tenant is not suspended
+func (db *SqliteDB) IsTenantActive(ctx context.Context, tenant *Tenant) (bool, error) {
+	fLog := sqliteLog.WithField("func", "IsTenantActive").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT COUNT(*) AS CNT FROM HANSIP_TENANT_SUSPENSION WHERE TENANT_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, tenant.RecID)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error IsTenantActive",
+			SQL:     q,
+		}
+	}
+	return count == 0, nil
+}
+
+// GetTenantBranding returns the branding of a tenant, nil if the tenant has no branding
+func (db *SqliteDB) GetTenantBranding(ctx context.Context, tenant *Tenant) (*TenantBranding, error) {
+	fLog := sqliteLog.WithField("func", "GetTenantBranding").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT PRODUCT_NAME, LOGO_URL, SUPPORT_EMAIL, PRIMARY_COLOR FROM HANSIP_TENANT_BRANDING WHERE TENANT_REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, tenant.RecID)
+	branding := &TenantBranding{}
+	err := row.Scan(&branding.ProductName, &branding.LogoURL, &branding.SupportEmail, &branding.PrimaryColor)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetTenantBranding",
+			SQL:     q,
+		}
+	}
+	return branding, nil
+}
+
+// SetTenantBranding sets the branding of a tenant. A nil branding removes the tenant's branding
+func (db *SqliteDB) SetTenantBranding(ctx context.Context, tenant *Tenant, branding *TenantBranding) error {
+	fLog := sqliteLog.WithField("func", "SetTenantBranding").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_BRANDING WHERE TENANT_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantBranding",
+			SQL:     q,
+		}
+	}
+	if branding == nil {
+		return nil
+	}
+	q = "INSERT INTO HANSIP_TENANT_BRANDING(TENANT_REC_ID, PRODUCT_NAME, LOGO_URL, SUPPORT_EMAIL, PRIMARY_COLOR) VALUES (?,?,?,?,?)"
+	_, err = db.instance.ExecContext(ctx, q, tenant.RecID, branding.ProductName, branding.LogoURL, branding.SupportEmail, branding.PrimaryColor)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantBranding",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// GetTenantEmailDomains returns the email domains allowed for the users of a tenant, sorted. Empty means any domain is allowed
+func (db *SqliteDB) GetTenantEmailDomains(ctx context.Context, tenant *Tenant) ([]string, error) {
+	fLog := sqliteLog.WithField("func", "GetTenantEmailDomains").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT EMAIL_DOMAIN FROM HANSIP_TENANT_EMAIL_DOMAIN WHERE TENANT_REC_ID=? ORDER BY EMAIL_DOMAIN ASC"
+	rows, err := db.instance.QueryContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error GetTenantEmailDomains",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	domains := make([]string, 0)
+	for rows.Next() {
+		var domain string
+		err := rows.Scan(&domain)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error GetTenantEmailDomains",
+				SQL:     q,
+			}
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// SetTenantEmailDomains replaces the email domains allowed for the users of a tenant. No domain removes the restriction
+func (db *SqliteDB) SetTenantEmailDomains(ctx context.Context, tenant *Tenant, domains []string) error {
+	fLog := sqliteLog.WithField("func", "SetTenantEmailDomains").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_TENANT_EMAIL_DOMAIN WHERE TENANT_REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, tenant.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SetTenantEmailDomains",
+			SQL:     q,
+		}
+	}
+	q = "INSERT INTO HANSIP_TENANT_EMAIL_DOMAIN(TENANT_REC_ID, EMAIL_DOMAIN) VALUES (?,?)"
+	for _, domain := range domains {
+		_, err = db.instance.ExecContext(ctx, q, tenant.RecID, domain)
+		if err != nil {
+			fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+			return &ErrDBExecuteError{
+				Wrapped: err,
+				Message: "Error SetTenantEmailDomains",
+				SQL:     q,
+			}
+		}
+	}
+	return nil
+}
+
+// GetIdempotentResponse returns the response recorded for the key, nil if the key is not recorded or has expired
+func (db *SqliteDB) GetIdempotentResponse(ctx context.Context, key string) (*IdempotentResponse, error) {
+	fLog := sqliteLog.WithField("func", "GetIdempotentResponse").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT IDEMPOTENCY_KEY, REQUEST_HASH, STATUS_CODE, CONTENT_TYPE, BODY, EXPIRE FROM HANSIP_IDEMPOTENCY_KEY WHERE IDEMPOTENCY_KEY=? AND EXPIRE > ?"
+	row := db.instance.QueryRowContext(ctx, q, key, time.Now().Sub(coreEpoch).Seconds())
+	response := &IdempotentResponse{}
+	var expire float64
+	err := row.Scan(&response.Key, &response.RequestHash, &response.StatusCode, &response.ContentType, &response.Body, &expire)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetIdempotentResponse",
+			SQL:     q,
+		}
+	}
+	response.Expire = coreEpoch.Add(time.Duration(expire * float64(time.Second)))
+	return response, nil
+}
+
+// SaveIdempotentResponse records the response for its key until it expires. Expired keys are purged along the way.
+func (db *SqliteDB) SaveIdempotentResponse(ctx context.Context, response *IdempotentResponse) error {
+	fLog := sqliteLog.WithField("func", "SaveIdempotentResponse").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_IDEMPOTENCY_KEY WHERE EXPIRE < ? OR IDEMPOTENCY_KEY=?"
+	_, err := db.instance.ExecContext(ctx, q, time.Now().Sub(coreEpoch).Seconds(), response.Key)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SaveIdempotentResponse",
+			SQL:     q,
+		}
+	}
+	q = "INSERT INTO HANSIP_IDEMPOTENCY_KEY(IDEMPOTENCY_KEY, REQUEST_HASH, STATUS_CODE, CONTENT_TYPE, BODY, EXPIRE) VALUES (?,?,?,?,?,?)"
+	_, err = db.instance.ExecContext(ctx, q, response.Key, response.RequestHash, response.StatusCode, response.ContentType, response.Body, response.Expire.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error SaveIdempotentResponse",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// ConsumeTokenID marks the token ID used until it expires, it returns false if the ID is already used.
+// Expired IDs are purged along the way.
+func (db *SqliteDB) ConsumeTokenID(ctx context.Context, tokenID string, expire time.Time) (bool, error) {
+	fLog := sqliteLog.WithField("func", "ConsumeTokenID").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_CONSUMED_TOKEN WHERE EXPIRE < ?"
+	_, err := db.instance.ExecContext(ctx, q, time.Now().Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return false, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ConsumeTokenID",
+			SQL:     q,
+		}
+	}
+	q = "INSERT OR IGNORE INTO HANSIP_CONSUMED_TOKEN(TOKEN_ID, EXPIRE) VALUES (?,?)"
+	result, err := db.instance.ExecContext(ctx, q, tokenID, expire.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return false, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ConsumeTokenID",
+			SQL:     q,
+		}
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return false, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error ConsumeTokenID",
+			SQL:     q,
+		}
+	}
+	return inserted == 1, nil
+}
+
+// CreateWebAuthnCredential stores a credential registered by the user
+func (db *SqliteDB) CreateWebAuthnCredential(ctx context.Context, user *User, name, credentialID, credential string) (*WebAuthnCredential, error) {
+	fLog := sqliteLog.WithField("func", "CreateWebAuthnCredential").WithField("RequestID", ctx.Value(constants.RequestID))
+	now := time.Now()
+	webAuthnCredential := &WebAuthnCredential{
+		RecID:        NewRecID(),
+		UserRecID:    user.RecID,
+		Name:         name,
+		CredentialID: credentialID,
+		Credential:   credential,
+		CreatedAt:    now,
+		LastUsedAt:   now,
+	}
+	q := "INSERT INTO HANSIP_WEBAUTHN_CREDENTIAL(REC_ID, USER_REC_ID, CREDENTIAL_NAME, CREDENTIAL_ID, CREDENTIAL, CREATED_AT, LAST_USED_AT) VALUES (?,?,?,?,?,?,?)"
+	_, err := db.instance.ExecContext(ctx, q, webAuthnCredential.RecID, webAuthnCredential.UserRecID, webAuthnCredential.Name, webAuthnCredential.CredentialID, webAuthnCredential.Credential,
+		now.Sub(coreEpoch).Seconds(), now.Sub(coreEpoch).Seconds())
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error CreateWebAuthnCredential",
+			SQL:     q,
+		}
+	}
+	return webAuthnCredential, nil
+}
+
+// GetWebAuthnCredentialByRecID returns a credential, nil if it not exist
+func (db *SqliteDB) GetWebAuthnCredentialByRecID(ctx context.Context, recID string) (*WebAuthnCredential, error) {
+	fLog := sqliteLog.WithField("func", "GetWebAuthnCredentialByRecID").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REC_ID, USER_REC_ID, CREDENTIAL_NAME, CREDENTIAL_ID, CREDENTIAL, CREATED_AT, LAST_USED_AT FROM HANSIP_WEBAUTHN_CREDENTIAL WHERE REC_ID=?"
+	row := db.instance.QueryRowContext(ctx, q, recID)
+	credential := &WebAuthnCredential{}
+	var createdAt, lastUsedAt float64
+	err := row.Scan(&credential.RecID, &credential.UserRecID, &credential.Name, &credential.CredentialID, &credential.Credential, &createdAt, &lastUsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, &ErrDBScanError{
+			Wrapped: err,
+			Message: "Error GetWebAuthnCredentialByRecID",
+			SQL:     q,
+		}
+	}
+	credential.CreatedAt = coreEpoch.Add(time.Duration(createdAt * float64(time.Second)))
+	credential.LastUsedAt = coreEpoch.Add(time.Duration(lastUsedAt * float64(time.Second)))
+	return credential, nil
+}
+
+// ListWebAuthnCredentials returns all credentials of the user, oldest first
+func (db *SqliteDB) ListWebAuthnCredentials(ctx context.Context, user *User) ([]*WebAuthnCredential, error) {
+	fLog := sqliteLog.WithField("func", "ListWebAuthnCredentials").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "SELECT REC_ID, USER_REC_ID, CREDENTIAL_NAME, CREDENTIAL_ID, CREDENTIAL, CREATED_AT, LAST_USED_AT FROM HANSIP_WEBAUTHN_CREDENTIAL WHERE USER_REC_ID=? ORDER BY CREATED_AT ASC"
+	rows, err := db.instance.QueryContext(ctx, q, user.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got  %s. SQL = %s", err.Error(), q)
+		return nil, &ErrDBQueryError{
+			Wrapped: err,
+			Message: "Error ListWebAuthnCredentials",
+			SQL:     q,
+		}
+	}
+	defer rows.Close()
+	ret := make([]*WebAuthnCredential, 0)
+	for rows.Next() {
+		credential := &WebAuthnCredential{}
+		var createdAt, lastUsedAt float64
+		err := rows.Scan(&credential.RecID, &credential.UserRecID, &credential.Name, &credential.CredentialID, &credential.Credential, &createdAt, &lastUsedAt)
+		if err != nil {
+			fLog.Warnf("row.Scan got  %s", err.Error())
+			return nil, &ErrDBScanError{
+				Wrapped: err,
+				Message: "Error ListWebAuthnCredentials",
+				SQL:     q,
+			}
+		}
+		credential.CreatedAt = coreEpoch.Add(time.Duration(createdAt * float64(time.Second)))
+		credential.LastUsedAt = coreEpoch.Add(time.Duration(lastUsedAt * float64(time.Second)))
+		ret = append(ret, credential)
+	}
+	return ret, nil
+}
+
+// UpdateWebAuthnCredential saves the name, the credential and the last use time of a credential
+func (db *SqliteDB) UpdateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	fLog := sqliteLog.WithField("func", "UpdateWebAuthnCredential").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "UPDATE HANSIP_WEBAUTHN_CREDENTIAL SET CREDENTIAL_NAME=?, CREDENTIAL=?, LAST_USED_AT=? WHERE REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, credential.Name, credential.Credential, credential.LastUsedAt.Sub(coreEpoch).Seconds(), credential.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error UpdateWebAuthnCredential",
+			SQL:     q,
+		}
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential removes a credential
+func (db *SqliteDB) DeleteWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	fLog := sqliteLog.WithField("func", "DeleteWebAuthnCredential").WithField("RequestID", ctx.Value(constants.RequestID))
+	q := "DELETE FROM HANSIP_WEBAUTHN_CREDENTIAL WHERE REC_ID=?"
+	_, err := db.instance.ExecContext(ctx, q, credential.RecID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s. SQL = %s", err.Error(), q)
+		return &ErrDBExecuteError{
+			Wrapped: err,
+			Message: "Error DeleteWebAuthnCredential",
+			SQL:     q,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package connector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func TestSqliteAuditRetention(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:auditretention?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	if _, err := instance.ExecContext(ctx, CreateAuditSqlite); err != nil {
+		t.Fatal(err)
+	}
+	db := &SqliteDB{instance: instance}
+
+	old, err := db.CreateAudit(ctx, "IMPERSONATE", "admin@acme.com", "user@acme.com", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance.ExecContext(ctx, "UPDATE HANSIP_AUDIT SET EVENT_TIME=? WHERE REC_ID=?", time.Now().Add(-48*time.Hour).Sub(coreEpoch).Seconds(), old.RecID); err != nil {
+		t.Fatal(err)
+	}
+	recent, err := db.CreateAudit(ctx, "EMAIL_CHANGED", "user@acme.com", "user@acme.com", "recent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(-24 * time.Hour)
+
+	listed := make([]*Audit, 0)
+	err = db.EachAuditBefore(ctx, cutoff, func(audit *Audit) error {
+		listed = append(listed, audit)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].RecID != old.RecID || listed[0].Detail != "old" || !listed[0].EventTime.Before(cutoff) {
+		t.Errorf("expect only the old audit before the cutoff. got %v", listed)
+	}
+
+	purged, err := db.PurgeAuditsBefore(ctx, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("expect 1 audit purged. got %d", purged)
+	}
+	remaining := make([]string, 0)
+	db.EachAuditBefore(ctx, time.Now().Add(time.Hour), func(audit *Audit) error {
+		remaining = append(remaining, audit.RecID)
+		return nil
+	})
+	if len(remaining) != 1 || remaining[0] != recent.RecID {
+		t.Errorf("expect only the recent audit retained. got %v", remaining)
+	}
+}
+
+func TestSqliteFieldEncryption(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:fieldencryption?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	if _, err := instance.ExecContext(ctx, CreateUserSqlite); err != nil {
+		t.Fatal(err)
+	}
+	db := &SqliteDB{instance: instance}
+	config.Set("db.encrypt.fields", FieldTOTPKey)
+	defer config.Set("db.encrypt.fields", "")
+	stored := func(recID string) string {
+		var value string
+		if err := instance.QueryRowContext(ctx, "SELECT TOTP_KEY FROM HANSIP_USER WHERE REC_ID=?", recID).Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	read := func(recID string) (string, error) {
+		var value string
+		err := instance.QueryRowContext(ctx, "SELECT TOTP_KEY FROM HANSIP_USER WHERE REC_ID=?", recID).Scan(openedField{FieldTOTPKey, &value})
+		return value, err
+	}
+
+	user, err := db.CreateUserRecord(ctx, "user@acme.com", "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := stored(user.RecID); !strings.HasPrefix(value, encryptedFieldPrefix) || strings.Contains(value, user.UserTotpSecretKey) || len(value) > 64 {
+		t.Errorf("expect the TOTP key encrypted within the column width. got %q", value)
+	}
+	if value, err := read(user.RecID); err != nil || value != user.UserTotpSecretKey {
+		t.Errorf("expect the TOTP key decrypted on read. got %q %v want %q", value, err, user.UserTotpSecretKey)
+	}
+
+	// encrypted values are still read once the field is not encrypted anymore, plain text values are read as is
+	config.Set("db.encrypt.fields", "")
+	if value, err := read(user.RecID); err != nil || value != user.UserTotpSecretKey {
+		t.Errorf("expect the encrypted TOTP key still decrypted. got %q %v", value, err)
+	}
+	user.UserTotpSecretKey = "PLAINTEXTSECRET2"
+	if err := db.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := read(user.RecID); err != nil || value != "PLAINTEXTSECRET2" || stored(user.RecID) != "PLAINTEXTSECRET2" {
+		t.Errorf("expect the plain text TOTP key read as is. got %q %v", value, err)
+	}
+
+	// updating encrypts again, a different key can not decrypt
+	config.Set("db.encrypt.fields", FieldTOTPKey)
+	if err := db.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := read(user.RecID); err != nil || value != "PLAINTEXTSECRET2" || stored(user.RecID) == "PLAINTEXTSECRET2" {
+		t.Errorf("expect the updated TOTP key encrypted. got %q %v", value, err)
+	}
+	config.Set("db.encrypt.key", "another key")
+	defer config.Set("db.encrypt.key", "")
+	if _, err := read(user.RecID); err == nil {
+		t.Errorf("expect decrypting with another key to fail")
+	}
+}
+
+func TestSqliteUUIDRecID(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:uuidrecid?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	for _, create := range []string{CreateRoleSqlite, CreateGroupSqlite} {
+		if _, err := instance.ExecContext(ctx, create); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &SqliteDB{instance: instance}
+	config.Set("db.id.strategy", RecIDStrategyUUID)
+	defer config.Set("db.id.strategy", RecIDStrategyRandom)
+
+	role, err := db.CreateRole(ctx, "admin", "acme.com", "administrator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := db.CreateGroup(ctx, "staff", "acme.com", "all staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, recID := range []string{role.RecID, group.RecID} {
+		if len(recID) != 32 || strings.Trim(recID, "0123456789abcdef") != "" {
+			t.Errorf("expect a UUID record id. got %q", recID)
+		}
+	}
+	if found, err := db.GetRoleByRecID(ctx, role.RecID); err != nil || found == nil || found.RoleName != "admin" {
+		t.Errorf("expect the role addressable by its UUID. got %v %v", found, err)
+	}
+	if found, err := db.GetGroupByRecID(ctx, group.RecID); err != nil || found == nil || found.GroupName != "staff" {
+		t.Errorf("expect the group addressable by its UUID. got %v %v", found, err)
+	}
+}
+
+func TestSqliteConsumeTokenID(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:consumedtoken?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	if _, err := instance.ExecContext(ctx, CreateConsumedTokenSqlite); err != nil {
+		t.Fatal(err)
+	}
+	db := &SqliteDB{instance: instance}
+
+	if unused, err := db.ConsumeTokenID(ctx, "token1", time.Now().Add(time.Hour)); err != nil || !unused {
+		t.Fatalf("expect the first use accepted. got %v %v", unused, err)
+	}
+	if unused, err := db.ConsumeTokenID(ctx, "token1", time.Now().Add(time.Hour)); err != nil || unused {
+		t.Errorf("expect the second use refused. got %v %v", unused, err)
+	}
+	if unused, err := db.ConsumeTokenID(ctx, "token2", time.Now().Add(-time.Minute)); err != nil || !unused {
+		t.Fatalf("expect another token accepted. got %v %v", unused, err)
+	}
+	if _, err := db.ConsumeTokenID(ctx, "token3", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := instance.QueryRowContext(ctx, "SELECT COUNT(*) FROM HANSIP_CONSUMED_TOKEN WHERE TOKEN_ID=?", "token2").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expect the expired token ID purged. got %d", count)
+	}
+}
+
+func TestSqliteTenantEmailDomains(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:tenantemaildomain?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	for _, create := range []string{CreateTenantSqlite, CreateTenantEmailDomainSqlite} {
+		if _, err := instance.ExecContext(ctx, create); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &SqliteDB{instance: instance}
+	acme, globex := &Tenant{RecID: "t1", Domain: "acme"}, &Tenant{RecID: "t2", Domain: "globex"}
+
+	if domains, err := db.GetTenantEmailDomains(ctx, acme); err != nil || len(domains) != 0 {
+		t.Fatalf("expect no domains yet. got %v %v", domains, err)
+	}
+	if err := db.SetTenantEmailDomains(ctx, acme, []string{"acme.com", "acme.co.id"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTenantEmailDomains(ctx, globex, []string{"globex.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if domains, err := db.GetTenantEmailDomains(ctx, acme); err != nil || strings.Join(domains, ",") != "acme.co.id,acme.com" {
+		t.Errorf("expect the acme domains. got %v %v", domains, err)
+	}
+	if err := db.SetTenantEmailDomains(ctx, acme, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	if domains, err := db.GetTenantEmailDomains(ctx, acme); err != nil || len(domains) != 0 {
+		t.Errorf("expect the acme domains removed. got %v %v", domains, err)
+	}
+	if domains, err := db.GetTenantEmailDomains(ctx, globex); err != nil || strings.Join(domains, ",") != "globex.com" {
+		t.Errorf("expect the globex domains kept. got %v %v", domains, err)
+	}
+}
+
+func TestSqliteReplaceTenantDirectory(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:tenantdirectory?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	for _, create := range []string{CreateUserSqlite, CreateRoleSqlite, CreateGroupSqlite, CreateUserRoleSqlite, CreateUserGroupSqlite, CreateGroupRoleSqlite, CreateGroupParentSqlite} {
+		if _, err := instance.ExecContext(ctx, create); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &SqliteDB{instance: instance}
+	acme := &Tenant{RecID: "t1", Domain: "acme"}
+	user := &User{RecID: "u1"}
+	oldRole, _ := db.CreateRole(ctx, "old", "acme", "")
+	oldGroup, _ := db.CreateGroup(ctx, "old", "acme", "")
+	otherRole, _ := db.CreateRole(ctx, "other", "globex", "")
+	db.CreateUserRole(ctx, user, oldRole)
+	db.CreateUserRole(ctx, user, otherRole)
+	db.CreateUserGroup(ctx, user, oldGroup)
+	db.CreateGroupRole(ctx, oldGroup, oldRole)
+
+	count := func(q string) int {
+		n := 0
+		if err := instance.QueryRowContext(ctx, q).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	// the second role duplicates the first, failing the insert and rolling back the whole replacement
+	failing := &TenantDirectory{Roles: []*Role{{RecID: "r1", RoleName: "admin", RoleDomain: "acme"}, {RecID: "r2", RoleName: "admin", RoleDomain: "acme"}}}
+	if _, _, err := db.ReplaceTenantDirectory(ctx, acme, failing); err == nil {
+		t.Fatal("expect the duplicated role to fail")
+	}
+	if count("SELECT COUNT(*) FROM HANSIP_ROLE WHERE ROLE_DOMAIN='acme'") != 1 || count("SELECT COUNT(*) FROM HANSIP_USER_ROLE") != 2 || count("SELECT COUNT(*) FROM HANSIP_GROUP_ROLE") != 1 {
+		t.Fatal("expect the tenant untouched after a failed replacement")
+	}
+
+	directory := &TenantDirectory{
+		Roles:      []*Role{{RecID: "r1", RoleName: "admin", RoleDomain: "acme"}},
+		Groups:     []*Group{{RecID: "g1", GroupName: "staff", GroupDomain: "acme"}, {RecID: "g2", GroupName: "ops", GroupDomain: "acme"}},
+		Parents:    map[string]string{"g2": "g1"},
+		GroupRoles: []*GroupRole{{GroupRecID: "g1", RoleRecID: "r1"}},
+		UserRoles:  []*UserRole{{UserRecID: "u1", RoleRecID: "r1"}},
+		UserGroups: []*UserGroup{{UserRecID: "u1", GroupRecID: "g2"}},
+	}
+	roles, groups, err := db.ReplaceTenantDirectory(ctx, acme, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles != 1 || groups != 1 {
+		t.Errorf("expect 1 role and 1 group deleted. got %d and %d", roles, groups)
+	}
+	for q, expect := range map[string]int{
+		"SELECT COUNT(*) FROM HANSIP_ROLE WHERE ROLE_DOMAIN='acme'":                                   1,
+		"SELECT COUNT(*) FROM HANSIP_ROLE WHERE ROLE_DOMAIN='globex'":                                 1,
+		"SELECT COUNT(*) FROM HANSIP_GROUP":                                                           2,
+		"SELECT COUNT(*) FROM HANSIP_GROUP_PARENT WHERE GROUP_REC_ID='g2'":                            1,
+		"SELECT COUNT(*) FROM HANSIP_GROUP_ROLE":                                                      1,
+		"SELECT COUNT(*) FROM HANSIP_USER_ROLE WHERE ROLE_REC_ID IN ('r1','" + otherRole.RecID + "')": 2,
+		"SELECT COUNT(*) FROM HANSIP_USER_ROLE":                                                       2,
+		"SELECT COUNT(*) FROM HANSIP_USER_GROUP WHERE GROUP_REC_ID='g2'":                              1,
+		"SELECT COUNT(*) FROM HANSIP_USER_GROUP":                                                      1,
+	} {
+		if n := count(q); n != expect {
+			t.Errorf("expect %d for %s. got %d", expect, q, n)
+		}
+	}
+}
+
+func TestSqliteProvisionTenant(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:tenantprovision?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	for _, create := range []string{CreateTenantSqlite, CreateTenantRegionSqlite, CreateUserSqlite, CreateRoleSqlite, CreateGroupSqlite, CreateUserRoleSqlite, CreateUserGroupSqlite, CreateGroupRoleSqlite} {
+		if _, err := instance.ExecContext(ctx, create); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &SqliteDB{instance: instance}
+	count := func(q string) int {
+		n := 0
+		if err := instance.QueryRowContext(ctx, q).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	provision := func(roles ...*Role) *TenantProvision {
+		return &TenantProvision{
+			Tenant:     &Tenant{RecID: "t1", Name: "Initech", Domain: "initech", Region: "eu-west"},
+			Roles:      roles,
+			Groups:     []*Group{{RecID: "g1", GroupName: "admins", GroupDomain: "initech"}},
+			GroupRoles: []*GroupRole{{GroupRecID: "g1", RoleRecID: "r1"}},
+			Admin:      &User{RecID: "u1", Email: "peter@initech.com", HashedPassphrase: "hashed"},
+			UserRoles:  []*UserRole{{UserRecID: "u1", RoleRecID: "r1"}},
+			UserGroups: []*UserGroup{{UserRecID: "u1", GroupRecID: "g1"}},
+		}
+	}
+	tables := []string{"HANSIP_TENANT", "HANSIP_TENANT_REGION", "HANSIP_USER", "HANSIP_ROLE", "HANSIP_GROUP", "HANSIP_USER_ROLE", "HANSIP_USER_GROUP", "HANSIP_GROUP_ROLE"}
+
+	// the second role duplicates the first, failing the insert and rolling back the whole provisioning
+	if err := db.ProvisionTenant(ctx, provision(&Role{RecID: "r1", RoleName: "admin", RoleDomain: "initech"}, &Role{RecID: "r2", RoleName: "admin", RoleDomain: "initech"})); err == nil {
+		t.Fatal("expect the duplicated role to fail")
+	}
+	for _, table := range tables {
+		if n := count("SELECT COUNT(*) FROM " + table); n != 0 {
+			t.Errorf("expect nothing left in %s after a failed provisioning. got %d", table, n)
+		}
+	}
+
+	if err := db.ProvisionTenant(ctx, provision(&Role{RecID: "r1", RoleName: "admin", RoleDomain: "initech"})); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if n := count("SELECT COUNT(*) FROM " + table); n != 1 {
+			t.Errorf("expect the provisioned row in %s. got %d", table, n)
+		}
+	}
+}
+
+func TestSqliteOpaqueTokenPurge(t *testing.T) {
+	instance, err := openDB("sqlite3", "file:opaquetokenpurge?mode=memory", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+	instance.SetMaxOpenConns(1)
+	ctx := context.Background()
+	if _, err := instance.ExecContext(ctx, CreateOpaqueTokenSqlite); err != nil {
+		t.Fatal(err)
+	}
+	db := &SqliteDB{instance: instance}
+	now := time.Now()
+	db.SaveOpaqueToken(ctx, "expired", &helper.HansipToken{Subject: "user@test.com", Expire: now.Add(-time.Minute)})
+	db.SaveOpaqueToken(ctx, "valid", &helper.HansipToken{Subject: "user@test.com", Expire: now.Add(time.Minute)})
+
+	purged, err := db.PurgeOpaqueTokensBefore(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("expect 1 token purged. got %d", purged)
+	}
+	if token, err := db.GetOpaqueToken(ctx, "expired"); err != nil || token != nil {
+		t.Errorf("expect the expired token purged. got %v %v", token, err)
+	}
+	if token, err := db.GetOpaqueToken(ctx, "valid"); err != nil || token == nil {
+		t.Errorf("expect the valid token kept. got %v %v", token, err)
+	}
+}
@@ -0,0 +1,75 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+)
+
+const (
+	// WebhookEventHeader carries the event type of the posted payload
+	WebhookEventHeader = "X-Hansip-Event"
+	// WebhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of the payload keyed by the webhook secret
+	WebhookSignatureHeader = "X-Hansip-Signature"
+)
+
+// Webhook posts event payloads to a subscriber
+type Webhook interface {
+	Post(ctx context.Context, eventType string, payload []byte) error
+}
+
+// WebhookPoster posts the JSON payloads to the URL
+type WebhookPoster struct {
+	URL string
+	// Secret signs the payloads, they are not signed if empty
+	Secret string
+	// Client is the http client calling the URL, the default client if nil
+	Client *http.Client
+	// Timeout cuts off the call to the URL, no timeout if zero
+	Timeout time.Duration
+}
+
+// WebhookSignature computes the value of the WebhookSignatureHeader of the payload
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post posts the payload of the event type, a response other than 2xx is an error
+func (poster *WebhookPoster) Post(ctx context.Context, eventType string, payload []byte) error {
+	if poster.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, poster.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, poster.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, eventType)
+	if len(poster.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(poster.Secret, payload))
+	}
+	client := http.DefaultClient
+	if poster.Client != nil {
+		client = poster.Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return hansiperrors.OutboundError("webhook", poster.Timeout, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %d", poster.URL, resp.StatusCode)
+	}
+	return nil
+}
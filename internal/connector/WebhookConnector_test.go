@@ -0,0 +1,41 @@
+package connector
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookPoster(t *testing.T) {
+	var eventType, signature, body string
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+		eventType, signature, body = r.Header.Get(WebhookEventHeader), r.Header.Get(WebhookSignatureHeader), string(payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	poster := &WebhookPoster{URL: server.URL, Secret: "webhook secret"}
+	payload := []byte(`{"event_type":"LOGIN_FAILED"}`)
+	if err := poster.Post(context.Background(), "LOGIN_FAILED", payload); err != nil {
+		t.Fatal(err)
+	}
+	if eventType != "LOGIN_FAILED" || body != string(payload) {
+		t.Errorf("expect the payload posted with its event type. got %s %s", eventType, body)
+	}
+	if signature != WebhookSignature("webhook secret", payload) || signature == WebhookSignature("another secret", payload) {
+		t.Errorf("expect the payload signed with the secret. got %s", signature)
+	}
+
+	poster.Secret = ""
+	if err := poster.Post(context.Background(), "LOGIN_FAILED", payload); err != nil || len(signature) > 0 {
+		t.Errorf("expect the payload not signed without secret. got %q %v", signature, err)
+	}
+	status = http.StatusInternalServerError
+	if err := poster.Post(context.Background(), "LOGIN_FAILED", payload); err == nil {
+		t.Errorf("expect an error when the webhook does not respond 2xx")
+	}
+}
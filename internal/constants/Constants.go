@@ -10,6 +10,12 @@ const (
 	// HansipAuthentication is context key for hansip authentication information
 	HansipAuthentication ContextKey = 2
 
+	// ResponseMediaType is context key for the media type negotiated for the response body
+	ResponseMediaType ContextKey = 3
+
+	// RequestMediaType is context key for the media type of the request body
+	RequestMediaType ContextKey = 4
+
 	// RequestIDHeader is context key for tracking request
 	RequestIDHeader = "X-Request-ID"
 )
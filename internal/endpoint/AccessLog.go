@@ -0,0 +1,87 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// AccessLogNone suppresses the access log of the quiet routes
+	AccessLogNone = "none"
+)
+
+// accessLogLevel returns the log level configured in the key, false if it is "none".
+func accessLogLevel(key string) (log.Level, bool, error) {
+	value := strings.TrimSpace(config.Get(key))
+	if strings.EqualFold(value, AccessLogNone) {
+		return log.TraceLevel, false, nil
+	}
+	level, err := log.ParseLevel(value)
+	if err != nil {
+		return log.TraceLevel, false, fmt.Errorf("%s %q is not a log level. allowed values are trace, debug, info, warn or %s", key, value, AccessLogNone)
+	}
+	return level, true, nil
+}
+
+// ValidateAccessLog checks the "server.http.accesslog.level" and "server.http.accesslog.quiet.level" are valid
+func ValidateAccessLog() error {
+	if _, logged, err := accessLogLevel("server.http.accesslog.level"); err != nil {
+		return err
+	} else if !logged {
+		return fmt.Errorf("server.http.accesslog.level can not be %s, only the quiet routes are suppressed", AccessLogNone)
+	}
+	_, _, err := accessLogLevel("server.http.accesslog.quiet.level")
+	return err
+}
+
+// isQuietRoute check whether the request is a read of a route in the "server.http.accesslog.quiet" path prefixes.
+// Mutating requests are never quiet.
+func isQuietRoute(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		return false
+	}
+	for _, prefix := range config.GetList("server.http.accesslog.quiet") {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessLogFor returns the level the access log of the request is written at, false if it is not written.
+// An invalid configuration falls back to TRACE, it is reported by ValidateAccessLog at startup.
+func accessLogFor(r *http.Request) (log.Level, bool) {
+	key := "server.http.accesslog.level"
+	if isQuietRoute(r) {
+		key = "server.http.accesslog.quiet.level"
+	}
+	level, logged, err := accessLogLevel(key)
+	if err != nil {
+		return log.TraceLevel, true
+	}
+	return level, logged
+}
+
+// statusRecorder remembers the status code of the response for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,89 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestAccessLogQuietRoutes(t *testing.T) {
+	for _, key := range []string{"server.http.accesslog.level", "server.http.accesslog.quiet", "server.http.accesslog.quiet.level"} {
+		defer config.Set(key, config.Get(key))
+	}
+	config.Set("server.http.accesslog.level", "info")
+	config.Set("server.http.accesslog.quiet", "/health,/ready,/metrics")
+	config.Set("server.http.accesslog.quiet.level", "none")
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.TraceLevel)
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	handler := TransactionIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	accessLogs := func(method, path string) []*log.Entry {
+		hook.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+		entries := make([]*log.Entry, 0)
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["func"] == "TransactionIDMiddleware" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	for _, path := range []string{"/health", "/ready", "/metrics"} {
+		if entries := accessLogs(http.MethodGet, path); len(entries) != 0 {
+			t.Errorf("expect no access log of GET %s. got %d entries", path, len(entries))
+		}
+	}
+	for _, request := range [][]string{{http.MethodGet, "/api/v1/management/users"}, {http.MethodPost, "/health"}} {
+		entries := accessLogs(request[0], request[1])
+		var end *log.Entry
+		for _, entry := range entries {
+			if entry.Message == "request end" {
+				end = entry
+			}
+		}
+		if end == nil || end.Level != log.InfoLevel || end.Data["status"] != http.StatusAccepted {
+			t.Errorf("expect an info access log of %s %s with its status. got %v", request[0], request[1], end)
+		}
+	}
+
+	config.Set("server.http.accesslog.quiet.level", "trace")
+	for _, entry := range accessLogs(http.MethodGet, "/health") {
+		if entry.Level != log.TraceLevel {
+			t.Errorf("expect the access log of GET /health at trace. got %s", entry.Level)
+		}
+	}
+}
+
+func TestValidateAccessLog(t *testing.T) {
+	for _, key := range []string{"server.http.accesslog.level", "server.http.accesslog.quiet.level"} {
+		defer config.Set(key, config.Get(key))
+	}
+	testData := []struct {
+		level      string
+		quietLevel string
+		valid      bool
+	}{
+		{"trace", "trace", true},
+		{"info", "none", true},
+		{"INFO", "debug", true},
+		{"none", "none", false},
+		{"loud", "none", false},
+		{"info", "silent", false},
+	}
+	for _, td := range testData {
+		config.Set("server.http.accesslog.level", td.level)
+		config.Set("server.http.accesslog.quiet.level", td.quietLevel)
+		if err := ValidateAccessLog(); (err == nil) != td.valid {
+			t.Errorf("%s %s expect valid %v. got %v", td.level, td.quietLevel, td.valid, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package endpoint
+
+import (
+	"context"
+
+	"github.com/hyperjumptech/hansip/internal/constants"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// AuditImpersonate is the audit event type when an admin starts impersonating a user
+	AuditImpersonate = "IMPERSONATE"
+	// AuditImpersonatedRequest is the audit event type for every request made using impersonation token
+	AuditImpersonatedRequest = "IMPERSONATED_REQUEST"
+	// AuditEmailChanged is the audit event type when a user confirmed the change of the email
+	AuditEmailChanged = "EMAIL_CHANGED"
+)
+
+var (
+	auditLog = log.WithField("go", "Audit")
+)
+
+// writeAudit records an audit event into the audit repository, failure to record is logged.
+func writeAudit(ctx context.Context, eventType, actor, target, detail string) error {
+	fLog := auditLog.WithField("func", "writeAudit").WithField("RequestID", ctx.Value(constants.RequestID))
+	if AuditRepo == nil {
+		fLog.Warnf("No audit repository. Audit %s by %s to %s : %s", eventType, actor, target, detail)
+		return nil
+	}
+	_, err := AuditRepo.CreateAudit(ctx, eventType, actor, target, detail)
+	if err != nil {
+		fLog.Errorf("AuditRepo.CreateAudit got %s", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// WriteAuditNDJSON writes the audit events that occurred before the time into w as newline delimited JSON, oldest first.
+// It returns the number of events written.
+func WriteAuditNDJSON(ctx context.Context, w io.Writer, before time.Time) (int, error) {
+	if AuditRepo == nil {
+		return 0, fmt.Errorf("no audit repository")
+	}
+	encoder := json.NewEncoder(w)
+	count := 0
+	err := AuditRepo.EachAuditBefore(ctx, before, func(audit *connector.Audit) error {
+		if err := encoder.Encode(audit); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// ExportAudit serving request to download the audit events as newline delimited JSON, eg. to archive them into cold storage.
+// The optional "before" query parameter is an RFC 3339 time, only the events that occurred before it are exported.
+func ExportAudit(w http.ResponseWriter, r *http.Request) {
+	fLog := auditLog.WithField("func", "ExportAudit").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	before := time.Now()
+	if param := r.URL.Query().Get("before"); len(param) > 0 {
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("before %q is not an RFC 3339 time", param), nil, nil)
+			return
+		}
+		before = parsed
+	}
+	if AuditRepo == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "no audit repository", nil, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"hansip-audit-%s.ndjson\"", before.UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+	count, err := WriteAuditNDJSON(r.Context(), w, before)
+	if err != nil {
+		// the status is already sent, the last line tells the client the export is incomplete.
+		fLog.Errorf("WriteAuditNDJSON got %s after %d events", err.Error(), count)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	fLog.Infof("exported %d audit events before %s", count, before.Format(time.RFC3339))
+}
@@ -3,8 +3,12 @@ package endpoint
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
 	"github.com/hyperjumptech/hansip/pkg/helper"
 	"github.com/hyperjumptech/hansip/pkg/totp"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +17,7 @@ import (
 )
 
 var (
+	authenticationLogger = log.WithField("go", "Authentication")
 
 	// TokenFactory instance used for generating and validating token
 	TokenFactory helper.TokenFactory
@@ -24,6 +29,12 @@ var (
 type Request struct {
 	Email      string `json:"email"`
 	Passphrase string `json:"passphrase"`
+	// NotBeforeDelay optionally delays the validity of the issued tokens, eg. "30 minutes"
+	NotBeforeDelay string `json:"not_before_delay"`
+	// Scope optionally narrows the permissions of the issued tokens, space separated, eg. "users:read audit:read"
+	Scope string `json:"scope"`
+	// RememberMe optionally issues a long lived refresh token if true, or a short lived one for shared devices if false
+	RememberMe *bool `json:"remember_me"`
 }
 
 // RequestWith2FA a model for authentication using 2fa secret key
@@ -31,6 +42,12 @@ type RequestWith2FA struct {
 	Email      string `json:"email"`
 	Passphrase string `json:"passphrase"`
 	SecretKey  string `json:"2FA_recovery_code"`
+	// NotBeforeDelay optionally delays the validity of the issued tokens, eg. "30 minutes"
+	NotBeforeDelay string `json:"not_before_delay"`
+	// Scope optionally narrows the permissions of the issued tokens, space separated, eg. "users:read audit:read"
+	Scope string `json:"scope"`
+	// RememberMe optionally issues a long lived refresh token if true, or a short lived one for shared devices if false
+	RememberMe *bool `json:"remember_me"`
 }
 
 // Response a model for responding successful authentication
@@ -48,6 +65,12 @@ type RefreshResponse struct {
 type TwoFARequest struct {
 	Token string `json:"2FA_token"`
 	Otp   string `json:"2FA_otp"`
+	// NotBeforeDelay optionally delays the validity of the issued tokens, eg. "30 minutes"
+	NotBeforeDelay string `json:"not_before_delay"`
+	// Scope optionally narrows the permissions of the issued tokens, space separated, eg. "users:read audit:read"
+	Scope string `json:"scope"`
+	// RememberMe optionally issues a long lived refresh token if true, or a short lived one for shared devices if false
+	RememberMe *bool `json:"remember_me"`
 }
 
 // TwoFATestRequest model for sending 2FA authentication
@@ -69,7 +92,7 @@ func TwoFATest(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
-	user, err := UserRepo.GetUserByEmail(r.Context(), authReq.Email)
+	user, err := getUserByEmail(r.Context(), authReq.Email)
 	if err != nil {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, err.Error(), nil, nil)
 		return
@@ -102,12 +125,30 @@ func TwoFA(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
+	delay, err := parseNotBeforeDelay(authReq.NotBeforeDelay)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
 	user, err := UserRepo.GetUserBy2FAToken(r.Context(), authReq.Token)
 	if err != nil || user == nil {
+		emitSecurityEvent(r, SecurityEvent2FAFailed, "", nil, "unknown 2FA token")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, err.Error(), nil, nil)
 		return
 	}
 
+	// Make sure the user is not deactivated
+	active, err := UserRepo.IsUserActive(r.Context(), user)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		emitSecurityEvent(r, SecurityEventLoginFailed, user.Email, user, "account deactivated")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account deactivated", nil, nil)
+		return
+	}
+
 	secret := totp.SecretFromBase32(user.UserTotpSecretKey)
 	valid, err := totp.Authenticate(secret, authReq.Otp, true)
 	if err != nil {
@@ -118,16 +159,14 @@ func TwoFA(w http.ResponseWriter, r *http.Request) {
 	defer UserRepo.UpdateUser(r.Context(), user)
 
 	if !valid {
-		user.FailCount = user.FailCount + 1
-		if user.FailCount > 3 {
-			user.Suspended = true
-		}
+		countFailedAttempt(r, user, SecurityEvent2FAFailed, user.Email, "invalid OTP")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "OTP not valid", nil, nil)
 		return
 	}
 
 	// If the password is valid, reset the user's FailCount
 	user.FailCount = 0
+	throttleSucceededLogin(r, user.Email)
 
 	var roles []string
 
@@ -144,21 +183,38 @@ func TwoFA(w http.ResponseWriter, r *http.Request) {
 	}
 	// Add user's role into Token audiences info.
 	roles = make([]string, len(userRoles))
+	roleDomains := make([]string, 0, len(userRoles))
+	scopedRoles := make([]string, len(userRoles))
 	for k, v := range userRoles {
 		r, err := RoleRepo.GetRoleByRecID(r.Context(), v.RecID)
 		if err == nil {
 			roles[k] = r.RoleName
+			roleDomains = append(roleDomains, r.RoleDomain)
+			scopedRoles[k] = fmt.Sprintf("%s@%s", r.RoleName, r.RoleDomain)
 		}
 	}
 
 	// Set the account email into Token subject.
 	subject := user.Email
 
-	// Set the audience
-	audience := roles
+	claims, err := scopeClaims(regionClaims(r.Context(), roleDomains), scopedRoles, authReq.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 
-	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, nil)
+	// Set the audience
+	audience, err := scopeAudience(roles, scopedRoles, authReq.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 
+	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, claims, helper.TokenOptions{Delay: delay, RefreshTokenAge: rememberMeRefreshAge(audience, authReq.RememberMe)})
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 	resp := &Response{
 		AccessToken:  access,
 		RefreshToken: refresh,
@@ -190,10 +246,17 @@ func Authentication2FA(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
+	delay, err := parseNotBeforeDelay(authReq.NotBeforeDelay)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
 
 	// Get user by said email
-	user, err := UserRepo.GetUserByEmail(r.Context(), authReq.Email)
+	user, err := getUserByEmail(r.Context(), authReq.Email)
 	if err != nil || user == nil {
+		emitSecurityEvent(r, SecurityEventLoginFailed, authReq.Email, nil, "unknown user")
+		throttleFailedLogin(r, authReq.Email)
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), nil, nil)
 		return
 	}
@@ -204,23 +267,34 @@ func Authentication2FA(w http.ResponseWriter, r *http.Request) {
 
 	// Make sure the user is enabled
 	if !user.Enabled {
+		emitSecurityEvent(r, SecurityEventLoginFailed, authReq.Email, user, "account disabled")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account disabled", nil, nil)
 		return
 	}
 
 	// Make sure the user is not suspended
 	if user.Suspended {
+		emitSecurityEvent(r, SecurityEventLoginFailed, authReq.Email, user, "account suspended")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account suspended", nil, nil)
 		return
 	}
 
+	// Make sure the user is not deactivated
+	active, err := UserRepo.IsUserActive(r.Context(), user)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		emitSecurityEvent(r, SecurityEventLoginFailed, user.Email, user, "account deactivated")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account deactivated", nil, nil)
+		return
+	}
+
 	// Validate the user's password
 	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassphrase), []byte(authReq.Passphrase))
 	if err != nil {
-		user.FailCount++
-		if user.FailCount > 3 {
-			user.Suspended = true
-		}
+		countFailedAttempt(r, user, SecurityEventLoginFailed, authReq.Email, "passphrase not match")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "email or passphrase not match", nil, nil)
 		return
 	}
@@ -238,10 +312,7 @@ func Authentication2FA(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if !codeCorrect {
-		user.FailCount++
-		if user.FailCount > 3 {
-			user.Suspended = true
-		}
+		countFailedAttempt(r, user, SecurityEvent2FAFailed, authReq.Email, "invalid TOTP recovery code")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "invalid secret key", nil, nil)
 		return
 	}
@@ -250,6 +321,7 @@ func Authentication2FA(w http.ResponseWriter, r *http.Request) {
 
 	// If the password is valid, reset the user's FailCount
 	user.FailCount = 0
+	throttleSucceededLogin(r, authReq.Email)
 
 	var roles []string
 
@@ -266,21 +338,36 @@ func Authentication2FA(w http.ResponseWriter, r *http.Request) {
 	}
 	// Add user's role into Token audiences info.
 	roles = make([]string, len(userRoles))
+	roleDomains := make([]string, 0, len(userRoles))
 	for k, v := range userRoles {
 		r, err := RoleRepo.GetRoleByRecID(r.Context(), v.RecID)
 		if err == nil {
 			roles[k] = fmt.Sprintf("%s@%s", r.RoleName, r.RoleDomain)
+			roleDomains = append(roleDomains, r.RoleDomain)
 		}
 	}
 
 	// Set the account email into Token subject.
 	subject := user.Email
 
-	// Set the audience
-	audience := roles
+	claims, err := scopeClaims(regionClaims(r.Context(), roleDomains), roles, authReq.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 
-	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, nil)
+	// Set the audience
+	audience, err := scopeAudience(roles, roles, authReq.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 
+	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, claims, helper.TokenOptions{Delay: delay, RefreshTokenAge: rememberMeRefreshAge(audience, authReq.RememberMe)})
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 	resp := &Response{
 		AccessToken:  access,
 		RefreshToken: refresh,
@@ -312,10 +399,17 @@ func Authentication(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
+	delay, err := parseNotBeforeDelay(authReq.NotBeforeDelay)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
 
 	// Get user by said email
-	user, err := UserRepo.GetUserByEmail(r.Context(), authReq.Email)
+	user, err := getUserByEmail(r.Context(), authReq.Email)
 	if err != nil || user == nil {
+		emitSecurityEvent(r, SecurityEventLoginFailed, authReq.Email, nil, "unknown user")
+		throttleFailedLogin(r, authReq.Email)
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), nil, nil)
 		return
 	}
@@ -332,6 +426,7 @@ func Authentication(w http.ResponseWriter, r *http.Request) {
 
 	// Make sure the user is enabled
 	if !user.Enabled {
+		emitSecurityEvent(r, SecurityEventLoginFailed, authReq.Email, user, "account disabled")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account disabled", nil, nil)
 		err = UserRepo.UpdateUser(r.Context(), user)
 		if err != nil {
@@ -342,6 +437,7 @@ func Authentication(w http.ResponseWriter, r *http.Request) {
 
 	// Make sure the user is not suspended
 	if user.Suspended {
+		emitSecurityEvent(r, SecurityEventLoginFailed, authReq.Email, user, "account suspended")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account suspended", nil, nil)
 		err = UserRepo.UpdateUser(r.Context(), user)
 		if err != nil {
@@ -350,13 +446,22 @@ func Authentication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Make sure the user is not deactivated
+	active, err := UserRepo.IsUserActive(r.Context(), user)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		emitSecurityEvent(r, SecurityEventLoginFailed, user.Email, user, "account deactivated")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account deactivated", nil, nil)
+		return
+	}
+
 	// Validate the user's password
 	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassphrase), []byte(authReq.Passphrase))
 	if err != nil {
-		user.FailCount++
-		if user.FailCount > 3 {
-			user.Suspended = true
-		}
+		countFailedAttempt(r, user, SecurityEventLoginFailed, authReq.Email, "passphrase not match")
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "email or passphrase not match", nil, nil)
 		err = UserRepo.UpdateUser(r.Context(), user)
 		if err != nil {
@@ -379,6 +484,7 @@ func Authentication(w http.ResponseWriter, r *http.Request) {
 
 	// If the password is valid, reset the user's FailCount
 	user.FailCount = 0
+	throttleSucceededLogin(r, authReq.Email)
 
 	var roles []string
 
@@ -400,23 +506,56 @@ func Authentication(w http.ResponseWriter, r *http.Request) {
 
 	// Add user's role into Token audiences info.
 	roles = make([]string, len(userRoles))
+	roleDomains := make([]string, 0, len(userRoles))
 	for k, v := range userRoles {
 		r, err := RoleRepo.GetRoleByRecID(r.Context(), v.RecID)
 		if err == nil {
 			roles[k] = fmt.Sprintf("%s@%s", r.RoleName, r.RoleDomain)
+			roleDomains = append(roleDomains, r.RoleDomain)
 		}
 	}
 
 	// Set the account email into Token subject.
 	subject := user.Email
 
+	// Users of the roles requiring 2FA only get a token to enroll 2FA
+	if isTwoFAEnrollmentPending(user, roles) {
+		enrollment, err := createTwoFAEnrollmentToken(subject)
+		if err != nil {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		err = UserRepo.UpdateUser(r.Context(), user)
+		if err != nil {
+			fLog := authenticationLogger.WithField("func", "Authentication").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+			fLog.Errorf("UserRepo.UpdateUser got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "2FA enrollment required", nil, &TwoFAEnrollmentResponse{EnrollmentToken: enrollment})
+		return
+	}
+
 	RevocationRepo.UnRevoke(r.Context(), subject)
 
-	// Set the audience
-	audience := roles
+	claims, err := scopeClaims(regionClaims(r.Context(), roleDomains), roles, authReq.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 
-	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, nil)
+	// Set the audience
+	audience, err := scopeAudience(roles, roles, authReq.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 
+	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, claims, helper.TokenOptions{Delay: delay, RefreshTokenAge: rememberMeRefreshAge(audience, authReq.RememberMe)})
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 	resp := &Response{
 		AccessToken:  access,
 		RefreshToken: refresh,
@@ -446,13 +585,33 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 	// Token
 	token := strings.TrimSpace(auth[7:])
 
+	// an opaque token of a revoked subject is deleted from the store, it reads as no token at all.
 	ht, err := TokenFactory.ReadToken(token)
+	if err != nil || ht == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "invalid or expired refresh token, please authenticate again", nil, nil)
+		return
+	}
 	revoked, err := RevocationRepo.IsRevoked(r.Context(), ht.Subject)
 	if err != nil || revoked {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "your access been revoked, please authenticate again", nil, nil)
 		return
 	}
 
+	// a refresh token issued before its roles required 2FA must not outlive the enrollment
+	if isTwoFARequired(ht.Audiences) {
+		user, err := getUserByEmail(r.Context(), ht.Subject)
+		if err != nil {
+			fLog := authenticationLogger.WithField("func", "Refresh").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+			fLog.Errorf("getUserByEmail got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if user == nil || isTwoFAEnrollmentPending(user, ht.Audiences) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "2FA enrollment required, please authenticate again", nil, nil)
+			return
+		}
+	}
+
 	access, err := TokenFactory.RefreshToken(token)
 	if err != nil {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, err.Error(), nil, nil)
@@ -463,3 +622,26 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 
 	helper.WriteHTTPResponse(r.Context(), w, 200, "access Token refreshed", nil, resp)
 }
+
+// parseNotBeforeDelay parses the optional not before delay of an authentication request,
+// refusing a delay longer than the "token.notbefore.maxdelay".
+func parseNotBeforeDelay(delay string) (time.Duration, error) {
+	if len(delay) == 0 {
+		return 0, nil
+	}
+	ret, err := jiffy.DurationOf(delay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid not_before_delay %s", delay)
+	}
+	if ret < 0 {
+		return 0, fmt.Errorf("not_before_delay can not be negative")
+	}
+	maxDelay, err := jiffy.DurationOf(config.Get("token.notbefore.maxdelay"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid token.notbefore.maxdelay %s", config.Get("token.notbefore.maxdelay"))
+	}
+	if ret > maxDelay {
+		return 0, fmt.Errorf("not_before_delay can not be longer than %s", config.Get("token.notbefore.maxdelay"))
+	}
+	return ret, nil
+}
@@ -0,0 +1,141 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// AuditAuthorizationDenied is the audit event type when a request is denied for lacking the required roles or permissions
+	AuditAuthorizationDenied = "AUTHORIZATION_DENIED"
+)
+
+var (
+	authorizationLog = log.WithField("go", "AuthorizationMiddleware")
+
+	// RouteMiddlewares are additional middlewares for individual route, keyed by the endpoint's PathPattern.
+	// They are applied by InitializeRouter, after the JwtMiddleware populated the authentication context.
+	RouteMiddlewares = make(map[string][]func(next http.Handler) http.Handler)
+)
+
+// AttachRouteMiddleware attach middlewares to the endpoint with the specified path pattern.
+// It must be called before InitializeRouter.
+func AttachRouteMiddleware(pathPattern string, middlewares ...func(next http.Handler) http.Handler) {
+	RouteMiddlewares[pathPattern] = append(RouteMiddlewares[pathPattern], middlewares...)
+}
+
+// AuthorizationDenial describes a request denied for lacking the required roles or permissions.
+// It holds the claims only, never the token nor the query string of the request.
+type AuthorizationDenial struct {
+	Subject  string   `json:"subject"`
+	Method   string   `json:"method"`
+	Route    string   `json:"route"`
+	Claim    string   `json:"claim"`
+	Required []string `json:"required"`
+	Held     []string `json:"held"`
+}
+
+// routeOf returns the path pattern of the endpoint serving the path, eg. /api/v1/management/user/{userRecId},
+// so denials of the same route are grouped together. The path itself is returned if no endpoint matches.
+func routeOf(path string) string {
+	for _, ep := range Endpoints {
+		if ep.isPathCanAccess(path) {
+			return ep.PathPattern
+		}
+	}
+	return path
+}
+
+// recordAuthorizationDenial logs the denial with its fields, and records it as an audit event if "audit.authorization.denied" is true.
+func recordAuthorizationDenial(ctx context.Context, fLog *log.Entry, denial *AuthorizationDenial) {
+	fLog.WithField("subject", denial.Subject).WithField("route", denial.Route).WithField("method", denial.Method).
+		WithField("claim", denial.Claim).WithField("required", denial.Required).WithField("held", denial.Held).
+		Warn("authorization denied")
+	if !config.GetBoolean("audit.authorization.denied") {
+		return
+	}
+	detail, err := json.Marshal(denial)
+	if err != nil {
+		fLog.Errorf("json.Marshal got %s", err.Error())
+		return
+	}
+	writeAudit(ctx, AuditAuthorizationDenied, denial.Subject, fmt.Sprintf("%s %s", denial.Method, denial.Route), string(detail))
+}
+
+// authorizeAdminOfDomain check whether the authenticated user is the admin of all the domains, recording an
+// authorization denial if not. The caller responds 403 when it returns false.
+func authorizeAdminOfDomain(r *http.Request, authCtx *hansipcontext.AuthenticationContext, domains ...string) bool {
+	required := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if !authCtx.IsAdminOfDomain(domain) {
+			required = append(required, fmt.Sprintf("%s@%s", config.Get("hansip.admin"), domain))
+		}
+	}
+	if len(required) == 0 {
+		return true
+	}
+	recordAdminDenial(r, authCtx, required)
+	return false
+}
+
+// recordAdminDenial records the denial of a request lacking the admin roles required by the handler.
+func recordAdminDenial(r *http.Request, authCtx *hansipcontext.AuthenticationContext, required []string) {
+	fLog := authorizationLog.WithField("func", "recordAdminDenial").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	recordAuthorizationDenial(r.Context(), fLog, &AuthorizationDenial{
+		Subject:  authCtx.Subject,
+		Method:   r.Method,
+		Route:    routeOf(r.URL.Path),
+		Claim:    "role",
+		Required: required,
+		Held:     authCtx.Audience,
+	})
+}
+
+// RequireRole creates a middleware that only allow caller having any of the roles in its token audience.
+// Role is in the form of "role@domain", wildcard "*" is supported the same way as the endpoint's WhiteListAudiences.
+func RequireRole(roles ...string) func(next http.Handler) http.Handler {
+	return requireClaim("RequireRole", "role", roles, func(authCtx *hansipcontext.AuthenticationContext) []string {
+		return authCtx.Audience
+	})
+}
+
+// RequirePermission creates a middleware that only allow caller having any of the permissions in its token "permissions" claim.
+func RequirePermission(perms ...string) func(next http.Handler) http.Handler {
+	return requireClaim("RequirePermission", "permission", perms, func(authCtx *hansipcontext.AuthenticationContext) []string {
+		return authCtx.Permissions
+	})
+}
+
+func requireClaim(name, claim string, required []string, claims func(authCtx *hansipcontext.AuthenticationContext) []string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fLog := authorizationLog.WithField("func", name).WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+			authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+			if !ok || authCtx == nil {
+				helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+				return
+			}
+			if held := claims(authCtx); !isRoleMatch(required, held) {
+				recordAuthorizationDenial(r.Context(), fLog, &AuthorizationDenial{
+					Subject:  authCtx.Subject,
+					Method:   r.Method,
+					Route:    routeOf(r.URL.Path),
+					Claim:    claim,
+					Required: required,
+					Held:     held,
+				})
+				helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, fmt.Sprintf("You are not allowed to access this end point %s", r.URL.Path), nil, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
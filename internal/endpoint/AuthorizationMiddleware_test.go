@@ -0,0 +1,191 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func authorizedRequest(authCtx *hansipcontext.AuthenticationContext) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/reports", nil)
+	if authCtx == nil {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, authCtx))
+}
+
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := RequireRole("auditor@*", "admin@hansip")(ok)
+
+	testData := []struct {
+		authCtx *hansipcontext.AuthenticationContext
+		expect  int
+	}{
+		{&hansipcontext.AuthenticationContext{Subject: "a@test.com", Audience: []string{"auditor@finance"}}, http.StatusOK},
+		{&hansipcontext.AuthenticationContext{Subject: "b@test.com", Audience: []string{"user@finance"}}, http.StatusForbidden},
+		{nil, http.StatusUnauthorized},
+	}
+	for i, td := range testData {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, authorizedRequest(td.authCtx))
+		if recorder.Code != td.expect {
+			t.Errorf("#%d expect %d but %d", i, td.expect, recorder.Code)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := RequirePermission("report:read")(ok)
+
+	testData := []struct {
+		authCtx *hansipcontext.AuthenticationContext
+		expect  int
+	}{
+		{&hansipcontext.AuthenticationContext{Subject: "a@test.com", Permissions: []string{"report:write", "report:read"}}, http.StatusOK},
+		{&hansipcontext.AuthenticationContext{Subject: "b@test.com", Audience: []string{"admin@hansip"}}, http.StatusForbidden},
+		{nil, http.StatusUnauthorized},
+	}
+	for i, td := range testData {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, authorizedRequest(td.authCtx))
+		if recorder.Code != td.expect {
+			t.Errorf("#%d expect %d but %d", i, td.expect, recorder.Code)
+		}
+	}
+}
+
+func TestAuthorizationDenialRecord(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	auditRepo := &fakeAuditRepo{}
+	AuditRepo = auditRepo
+	defer func() {
+		AuditRepo = nil
+	}()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := RequireRole("auditor@*")(ok)
+	deny := func() {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/management/user/u1?token=secret", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer secret.jwt.token")
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Token:    "secret.jwt.token",
+			Subject:  "b@test.com",
+			Audience: []string{"user@finance"},
+		}))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusForbidden {
+			t.Fatalf("expect 403 but %d", recorder.Code)
+		}
+	}
+
+	deny()
+	var denial *log.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "authorization denied" {
+			denial = entry
+		}
+		if entryText, _ := entry.String(); strings.Contains(entryText, "secret") {
+			t.Errorf("expect the token and query string kept out of the log. got %s", entryText)
+		}
+	}
+	if denial == nil {
+		t.Fatal("expect the denial logged")
+	}
+	route := fmt.Sprintf("%s/management/user/{userRecId}", apiPrefix)
+	if denial.Level != log.WarnLevel || denial.Data["subject"] != "b@test.com" || denial.Data["route"] != route || denial.Data["method"] != http.MethodGet || denial.Data["claim"] != "role" {
+		t.Errorf("expect the subject, route, method and claim in the denial. got %v", denial.Data)
+	}
+	if fmt.Sprint(denial.Data["required"]) != "[auditor@*]" || fmt.Sprint(denial.Data["held"]) != "[user@finance]" {
+		t.Errorf("expect the required and held roles in the denial. got %v", denial.Data)
+	}
+	if len(auditRepo.audits) != 0 {
+		t.Errorf("expect no audit unless audit.authorization.denied. got %d", len(auditRepo.audits))
+	}
+
+	config.Set("audit.authorization.denied", "true")
+	defer config.Set("audit.authorization.denied", "false")
+	deny()
+	if len(auditRepo.audits) != 1 {
+		t.Fatalf("expect the denial audited. got %d", len(auditRepo.audits))
+	}
+	audit := auditRepo.audits[0]
+	recorded := &AuthorizationDenial{}
+	if err := json.Unmarshal([]byte(audit.Detail), recorded); err != nil {
+		t.Fatal(err)
+	}
+	if audit.EventType != AuditAuthorizationDenied || audit.Actor != "b@test.com" || audit.Target != "GET "+route || recorded.Claim != "role" || strings.Join(recorded.Held, ",") != "user@finance" {
+		t.Errorf("expect a structured denial audit. got %s %s %s %s", audit.EventType, audit.Actor, audit.Target, audit.Detail)
+	}
+}
+
+func TestAuthorizationDenialRecordedWhereDenied(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	denial := func() *log.Entry {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "authorization denied" {
+				return entry
+			}
+		}
+		return nil
+	}
+
+	// a valid token lacking the audience of the endpoint
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	token, err := helper.CreateJWTStringToken("testkey", "HS256", config.Get("token.issuer"), "b@test.com", []string{"user@finance"}, time.Now(), time.Now(), time.Now().Add(time.Minute), map[string]interface{}{"type": "access"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/management/users", apiPrefix), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 but %d", recorder.Code)
+	}
+	entry := denial()
+	if entry == nil || entry.Data["subject"] != "b@test.com" || entry.Data["route"] != fmt.Sprintf("%s/management/users", apiPrefix) || fmt.Sprint(entry.Data["held"]) != "[user@finance]" {
+		t.Errorf("expect the audience mismatch recorded. got %v", entry)
+	}
+
+	// a handler refusing an admin of another tenant
+	hook.Reset()
+	TenantRepo = newEmailDomainTenantRepo()
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/management/tenant/t2/email-domains", apiPrefix), nil)
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@test.com",
+		Audience: []string{"admin@acme"},
+	}))
+	recorder = httptest.NewRecorder()
+	GetTenantEmailDomains(recorder, req)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expect 403 but %d", recorder.Code)
+	}
+	entry = denial()
+	if entry == nil || entry.Data["subject"] != "admin@test.com" || fmt.Sprint(entry.Data["required"]) != fmt.Sprintf("[%s@globex]", config.Get("hansip.admin")) {
+		t.Errorf("expect the handler denial recorded. got %v", entry)
+	}
+}
@@ -0,0 +1,55 @@
+package endpoint
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// bufferedResponseWriter holds the response written by a handler in memory, so a middleware can look at it
+// or rework it before it is sent to the client.
+type bufferedResponseWriter struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), code: http.StatusOK}
+}
+
+// Header returns the response headers
+func (bw *bufferedResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+// WriteHeader keeps the status code, only the first status code written counts like on a real response.
+func (bw *bufferedResponseWriter) WriteHeader(code int) {
+	if bw.wroteHeader {
+		return
+	}
+	bw.code = code
+	bw.wroteHeader = true
+}
+
+// Write appends to the response body
+func (bw *bufferedResponseWriter) Write(data []byte) (int, error) {
+	bw.wroteHeader = true
+	return bw.body.Write(data)
+}
+
+// writeTo sends the buffered headers and status code with the body to w. The Content-Length is left out
+// as the body may have been reworked.
+func (bw *bufferedResponseWriter) writeTo(w http.ResponseWriter, body []byte) {
+	for key, values := range bw.header {
+		if strings.ToLower(key) == "content-length" {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.WriteHeader(bw.code)
+	w.Write(body)
+}
@@ -0,0 +1,35 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBufferedResponseWriter(t *testing.T) {
+	buffered := newBufferedResponseWriter()
+	buffered.Header().Set("Content-Type", "application/json")
+	buffered.Header().Set("Content-Length", "2")
+	buffered.WriteHeader(http.StatusCreated)
+	buffered.WriteHeader(http.StatusInternalServerError)
+	buffered.Write([]byte("{}"))
+
+	recorder := httptest.NewRecorder()
+	buffered.writeTo(recorder, []byte(`{"data":{}}`))
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("expect the first status code 201. got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" || len(recorder.Header().Get("Content-Length")) > 0 {
+		t.Errorf("expect the headers but the Content-Length. got %v", recorder.Header())
+	}
+	if recorder.Body.String() != `{"data":{}}` {
+		t.Errorf("expect the reworked body. got %s", recorder.Body.String())
+	}
+
+	implicit := newBufferedResponseWriter()
+	implicit.Write([]byte("ok"))
+	implicit.WriteHeader(http.StatusNotFound)
+	if implicit.code != http.StatusOK {
+		t.Errorf("expect 200 once the body is written. got %d", implicit.code)
+	}
+}
@@ -0,0 +1,231 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BulkMemberAdded is the result of a user newly assigned
+	BulkMemberAdded = "added"
+	// BulkMemberExisting is the result of a user already assigned
+	BulkMemberExisting = "already_member"
+	// BulkMemberNotFound is the result of a user rec id of no user
+	BulkMemberNotFound = "not_found"
+	// BulkMemberForbidden is the result of a user of another tenant than the admin's
+	BulkMemberForbidden = "forbidden"
+	// BulkMemberEmailDomainNotAllowed is the result of a user whose email is not in the allowed email domains of the tenant
+	BulkMemberEmailDomainNotAllowed = "email_domain_not_allowed"
+)
+
+var (
+	bulkMembershipLog = log.WithField("go", "BulkMembership")
+)
+
+// BulkMemberResult is the result of assigning one user of a bulk assignment
+type BulkMemberResult struct {
+	UserRecID string `json:"user_rec_id" xml:"user_rec_id"`
+	Result    string `json:"result" xml:"result"`
+}
+
+// BulkMembersResponse is the response of a bulk assignment, with a result for each requested user
+type BulkMembersResponse struct {
+	Added   int                 `json:"added" xml:"added"`
+	Results []*BulkMemberResult `json:"results" xml:"results"`
+}
+
+// resolveBulkMembers reads the user rec ids of the request body and looks all of them up before any is assigned.
+// Users that are not found, not manageable by a tenant admin, or whose email is not allowed in the tenant domain, get their
+// result right away and are left out of the returned users. The error response is written and false returned if the request can not proceed.
+func resolveBulkMembers(w http.ResponseWriter, r *http.Request, tenantDomain string) ([]*connector.User, *BulkMembersResponse, bool) {
+	fLog := bulkMembershipLog.WithField("func", "resolveBulkMembers").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return nil, nil, false
+	}
+	userIds := make([]string, 0)
+	err = json.Unmarshal(body, &userIds)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Malformed json body, expecting an array of user rec ids", nil, nil)
+		return nil, nil, false
+	}
+	if len(userIds) == 0 {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "no user rec id to assign", nil, nil)
+		return nil, nil, false
+	}
+	if maxUsers := config.GetInt("api.bulk.maxusers"); maxUsers > 0 && len(userIds) > maxUsers {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("%d users exceeds the maximum of %d users per bulk assignment", len(userIds), maxUsers), nil, nil)
+		return nil, nil, false
+	}
+
+	authCtx := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	users := make([]*connector.User, 0, len(userIds))
+	response := &BulkMembersResponse{Results: make([]*BulkMemberResult, 0, len(userIds))}
+	seen := make(map[string]bool)
+	for _, userID := range userIds {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		result := &BulkMemberResult{UserRecID: userID}
+		response.Results = append(response.Results, result)
+		user, err := UserRepo.GetUserByRecID(r.Context(), userID)
+		if err != nil {
+			fLog.Errorf("UserRepo.GetUserByRecID got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return nil, nil, false
+		}
+		if user == nil {
+			result.Result = BulkMemberNotFound
+			continue
+		}
+		allowed, err := canManageUser(r.Context(), authCtx, user, true)
+		if err != nil {
+			fLog.Errorf("canManageUser got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return nil, nil, false
+		}
+		if !allowed {
+			result.Result = BulkMemberForbidden
+			continue
+		}
+		if err := validateTenantEmailDomains(r.Context(), []string{tenantDomain}, user.Email); err != nil {
+			notAllowedErr := &hansiperrors.ErrEmailDomainNotAllowed{}
+			if errors.As(err, &notAllowedErr) {
+				result.Result = BulkMemberEmailDomainNotAllowed
+				continue
+			}
+			fLog.Errorf("validateTenantEmailDomains got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return nil, nil, false
+		}
+		users = append(users, user)
+	}
+	return users, response, true
+}
+
+// completeBulkMembers sets the result of the assigned users and revokes the tokens of the newly added ones
+func completeBulkMembers(r *http.Request, users []*connector.User, added []string, response *BulkMembersResponse) {
+	addedSet := make(map[string]bool)
+	for _, recID := range added {
+		addedSet[recID] = true
+	}
+	emails := make(map[string]string)
+	for _, user := range users {
+		emails[user.RecID] = user.Email
+	}
+	for _, result := range response.Results {
+		if _, assigned := emails[result.UserRecID]; !assigned {
+			continue
+		}
+		if addedSet[result.UserRecID] {
+			result.Result = BulkMemberAdded
+			response.Added++
+			RevocationRepo.Revoke(r.Context(), emails[result.UserRecID])
+		} else {
+			result.Result = BulkMemberExisting
+		}
+	}
+}
+
+// BulkCreateRoleUsers serving request to assign a role to many users at once, in a single transaction.
+// The body is an array of user rec ids, each gets its own result in the response.
+func BulkCreateRoleUsers(w http.ResponseWriter, r *http.Request) {
+	fLog := bulkMembershipLog.WithField("func", "BulkCreateRoleUsers").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	if !ok || authCtx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/role/{roleRecId}/members:bulk", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	role, err := RoleRepo.GetRoleByRecID(r.Context(), params["roleRecId"])
+	if err != nil {
+		fLog.Errorf("RoleRepo.GetRoleByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if role == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Role recid %s not found", params["roleRecId"]), nil, nil)
+		return
+	}
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access role with the specified domain", nil, nil)
+		return
+	}
+	users, response, ok := resolveBulkMembers(w, r, role.RoleDomain)
+	if !ok {
+		return
+	}
+	added := make([]string, 0)
+	if len(users) > 0 {
+		added, err = UserRoleRepo.CreateUserRoles(r.Context(), role, users)
+		if err != nil {
+			fLog.Errorf("UserRoleRepo.CreateUserRoles got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+	}
+	completeBulkMembers(r, users, added, response)
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, fmt.Sprintf("%d users added the role", response.Added), nil, response)
+}
+
+// BulkCreateGroupUsers serving request to add many users into a group at once, in a single transaction.
+// The body is an array of user rec ids, each gets its own result in the response.
+func BulkCreateGroupUsers(w http.ResponseWriter, r *http.Request) {
+	fLog := bulkMembershipLog.WithField("func", "BulkCreateGroupUsers").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	if !ok || authCtx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}/members:bulk", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	group, err := GroupRepo.GetGroupByRecID(r.Context(), params["groupRecId"])
+	if err != nil {
+		fLog.Errorf("GroupRepo.GetGroupByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if group == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group recid %s not found", params["groupRecId"]), nil, nil)
+		return
+	}
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
+		return
+	}
+	users, response, ok := resolveBulkMembers(w, r, group.GroupDomain)
+	if !ok {
+		return
+	}
+	added := make([]string, 0)
+	if len(users) > 0 {
+		added, err = UserGroupRepo.CreateUserGroups(r.Context(), group, users)
+		if err != nil {
+			fLog.Errorf("UserGroupRepo.CreateUserGroups got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+	}
+	completeBulkMembers(r, users, added, response)
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, fmt.Sprintf("%d users added the group", response.Added), nil, response)
+}
@@ -0,0 +1,186 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+)
+
+type bulkMembershipRepo struct {
+	connector.RoleRepository
+	connector.GroupRepository
+	connector.UserRoleRepository
+	connector.UserGroupRepository
+	members      map[string]bool
+	transactions int
+}
+
+func (repo *bulkMembershipRepo) GetRoleByRecID(ctx context.Context, recID string) (*connector.Role, error) {
+	if recID != "r1" {
+		return nil, nil
+	}
+	return &connector.Role{RecID: "r1", RoleName: "user", RoleDomain: "acme"}, nil
+}
+
+func (repo *bulkMembershipRepo) GetGroupByRecID(ctx context.Context, recID string) (*connector.Group, error) {
+	if recID != "g1" {
+		return nil, nil
+	}
+	return &connector.Group{RecID: "g1", GroupName: "staff", GroupDomain: "acme"}, nil
+}
+
+func (repo *bulkMembershipRepo) add(users []*connector.User) []string {
+	repo.transactions++
+	added := make([]string, 0)
+	for _, user := range users {
+		if !repo.members[user.RecID] {
+			repo.members[user.RecID] = true
+			added = append(added, user.RecID)
+		}
+	}
+	return added
+}
+
+func (repo *bulkMembershipRepo) CreateUserRoles(ctx context.Context, role *connector.Role, users []*connector.User) ([]string, error) {
+	return repo.add(users), nil
+}
+
+func (repo *bulkMembershipRepo) CreateUserGroups(ctx context.Context, group *connector.Group, users []*connector.User) ([]string, error) {
+	return repo.add(users), nil
+}
+
+func (repo *bulkMembershipRepo) CreateUserRole(ctx context.Context, user *connector.User, role *connector.Role) (*connector.UserRole, error) {
+	repo.members[user.RecID] = true
+	return &connector.UserRole{UserRecID: user.RecID, RoleRecID: role.RecID}, nil
+}
+
+func (repo *bulkMembershipRepo) CreateUserGroup(ctx context.Context, user *connector.User, group *connector.Group) (*connector.UserGroup, error) {
+	repo.members[user.RecID] = true
+	return &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID}, nil
+}
+
+func (repo *bulkMembershipRepo) GetUserGroup(ctx context.Context, user *connector.User, group *connector.Group) (*connector.UserGroup, error) {
+	if !repo.members[user.RecID] {
+		return nil, nil
+	}
+	return &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID}, nil
+}
+
+func (repo *bulkMembershipRepo) DeleteUserGroup(ctx context.Context, userGroup *connector.UserGroup) error {
+	delete(repo.members, userGroup.UserRecID)
+	return nil
+}
+
+func (repo *bulkMembershipRepo) DeleteUserRoleByRole(ctx context.Context, role *connector.Role) error {
+	repo.members = make(map[string]bool)
+	return nil
+}
+
+func (repo *bulkMembershipRepo) DeleteUserGroupByGroup(ctx context.Context, group *connector.Group) error {
+	repo.members = make(map[string]bool)
+	return nil
+}
+
+func (repo *bulkMembershipRepo) DeleteUserRoleByUser(ctx context.Context, user *connector.User) error {
+	delete(repo.members, user.RecID)
+	return nil
+}
+
+func (repo *bulkMembershipRepo) DeleteUserGroupByUser(ctx context.Context, user *connector.User) error {
+	delete(repo.members, user.RecID)
+	return nil
+}
+
+func TestBulkMembers(t *testing.T) {
+	UserRepo = &tenantUserRepo{
+		users: []*connector.User{
+			{RecID: "acme1", Email: "wile@acme.com"},
+			{RecID: "acme2", Email: "road@acme.com"},
+			{RecID: "globex1", Email: "hank@globex.com"},
+			{RecID: "acme3", Email: "coyote@gmail.com"},
+		},
+		domains: map[string][]string{
+			"acme1":   {"acme"},
+			"acme2":   {"acme"},
+			"globex1": {"globex"},
+			"acme3":   {"acme"},
+		},
+	}
+	TenantRepo = newEmailDomainTenantRepo()
+	revocationRepo := &fakeRevocationRepo{revoked: make(map[string]bool)}
+	RevocationRepo = revocationRepo
+
+	bulk := func(handler http.HandlerFunc, path, body string) (int, *BulkMembersResponse) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", apiPrefix, path), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "boss@acme.com",
+			Audience: []string{"admin@acme"},
+		}))
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		response := &struct {
+			Data *BulkMembersResponse `json:"data"`
+		}{}
+		_ = json.Unmarshal(recorder.Body.Bytes(), response)
+		return recorder.Code, response.Data
+	}
+	expectResults := func(response *BulkMembersResponse, expect map[string]string) {
+		if len(response.Results) != len(expect) {
+			t.Errorf("expect %d results but %d", len(expect), len(response.Results))
+		}
+		for _, result := range response.Results {
+			if expect[result.UserRecID] != result.Result {
+				t.Errorf("user %s expect %s but %s", result.UserRecID, expect[result.UserRecID], result.Result)
+			}
+		}
+	}
+
+	repo := &bulkMembershipRepo{members: map[string]bool{"acme2": true}}
+	RoleRepo, UserRoleRepo = repo, repo
+	code, response := bulk(BulkCreateRoleUsers, "/management/role/r1/members:bulk", `["acme1","acme2","nobody","globex1","acme3","acme1"]`)
+	if code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	expectResults(response, map[string]string{
+		"acme1":   BulkMemberAdded,
+		"acme2":   BulkMemberExisting,
+		"nobody":  BulkMemberNotFound,
+		"globex1": BulkMemberForbidden,
+		"acme3":   BulkMemberEmailDomainNotAllowed,
+	})
+	if response.Added != 1 || repo.transactions != 1 || repo.members["globex1"] || repo.members["acme3"] {
+		t.Errorf("expect only acme1 added in one transaction. got %d added in %d transactions", response.Added, repo.transactions)
+	}
+	if !revocationRepo.revoked["wile@acme.com"] || revocationRepo.revoked["road@acme.com"] {
+		t.Errorf("only the tokens of the newly added user should be revoked")
+	}
+
+	if code, _ := bulk(BulkCreateRoleUsers, "/management/role/r2/members:bulk", `["acme1"]`); code != http.StatusNotFound {
+		t.Errorf("unknown role should be not found. got %d", code)
+	}
+	if code, _ := bulk(BulkCreateRoleUsers, "/management/role/r1/members:bulk", `{"user":"acme1"}`); code != http.StatusBadRequest {
+		t.Errorf("body that is not an array should be rejected. got %d", code)
+	}
+
+	repo = &bulkMembershipRepo{members: map[string]bool{}}
+	GroupRepo, UserGroupRepo = repo, repo
+	code, response = bulk(BulkCreateGroupUsers, "/management/group/g1/members:bulk", `["nobody","globex1"]`)
+	if code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	expectResults(response, map[string]string{
+		"nobody":  BulkMemberNotFound,
+		"globex1": BulkMemberForbidden,
+	})
+	if repo.transactions != 0 {
+		t.Errorf("no transaction expected when no user can be added. got %d", repo.transactions)
+	}
+}
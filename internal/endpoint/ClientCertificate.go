@@ -0,0 +1,98 @@
+package endpoint
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	clientCertificateLog = log.WithField("go", "ClientCertificate")
+)
+
+// ClientIdentity is the service identity of the callers presenting a client certificate of the Name
+type ClientIdentity struct {
+	Name  string
+	Roles []string
+}
+
+// ParseClientIdentities parses the service identities of client certificates, as configured in "server.tls.clientidentities".
+// Identities are separated by ";", each is the certificate's common name or DNS name followed by "=" and comma separated roles,
+// eg. "billing.svc.acme.com=admin@acme,user@acme;reports.svc.acme.com=user@acme".
+func ParseClientIdentities(spec string) ([]*ClientIdentity, error) {
+	ret := make([]*ClientIdentity, 0)
+	for _, item := range strings.Split(spec, ";") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		idx := strings.Index(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("client identity %s has no role", item)
+		}
+		name := strings.TrimSpace(item[:idx])
+		if len(name) == 0 {
+			return nil, fmt.Errorf("client identity %s has no name", item)
+		}
+		roles := make([]string, 0)
+		for _, role := range strings.Split(item[idx+1:], ",") {
+			if role = strings.TrimSpace(role); len(role) > 0 {
+				roles = append(roles, role)
+			}
+		}
+		if len(roles) == 0 {
+			return nil, fmt.Errorf("client identity %s has no role", name)
+		}
+		ret = append(ret, &ClientIdentity{Name: name, Roles: roles})
+	}
+	return ret, nil
+}
+
+// certificateNames returns the common name and the DNS names of the certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.DNSNames)+1)
+	if len(cert.Subject.CommonName) > 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return append(names, cert.DNSNames...)
+}
+
+// clientCertificateToken returns the token of the service identity of the request's verified client certificate,
+// nil if "server.tls.clientauth" is none, the request has no verified client certificate or its names have no identity.
+// The identity stands in for a bearer token, it is never used when the request has an Authorization header.
+func clientCertificateToken(r *http.Request) *helper.HansipToken {
+	clientAuth := config.Get("server.tls.clientauth")
+	if clientAuth == helper.ClientAuthNone || len(clientAuth) == 0 || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.Header.Get("Authorization")) > 0 {
+		return nil
+	}
+	identities, err := ParseClientIdentities(config.Get("server.tls.clientidentities"))
+	if err != nil {
+		clientCertificateLog.WithField("func", "clientCertificateToken").Errorf("invalid server.tls.clientidentities. got %s", err.Error())
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, name := range certificateNames(cert) {
+		for _, identity := range identities {
+			if identity.Name != name {
+				continue
+			}
+			return &helper.HansipToken{
+				Issuer:    config.Get("token.issuer"),
+				Subject:   identity.Name,
+				Audiences: identity.Roles,
+				Expire:    cert.NotAfter,
+				NotBefore: cert.NotBefore,
+				IssuedAt:  time.Now(),
+				Additional: map[string]interface{}{
+					"type": "access",
+				},
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package endpoint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// testCertificate creates a certificate of the common name signed by the parent, self signed if parent is nil.
+func testCertificate(t *testing.T, commonName string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertificateIdentity(t *testing.T) {
+	ca := testCertificate(t, "Test CA", nil)
+	caFile := filepath.Join(t.TempDir(), "clientca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Set("server.tls.clientauth", helper.ClientAuthOptional)
+	config.Set("server.tls.clientidentities", "billing.svc.acme.com=admin@acme,user@acme")
+	defer config.Set("server.tls.clientauth", helper.ClientAuthNone)
+	defer config.Set("server.tls.clientidentities", "")
+
+	tlsConfig, err := helper.NewServerTLSConfig(helper.ClientAuthOptional, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hToken, err := getHToken(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(hToken.Subject + " " + strings.Join(hToken.Audiences, ",")))
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	call := func(cert *tls.Certificate) (int, string, error) {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			// the certificate is always sent, even when the server does not accept its issuer.
+			transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return cert, nil
+			}
+		}
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), nil
+	}
+
+	valid := testCertificate(t, "billing.svc.acme.com", &ca)
+	if code, body, err := call(&valid); err != nil || code != http.StatusOK || body != "billing.svc.acme.com admin@acme,user@acme" {
+		t.Errorf("trusted certificate should authenticate the service identity. got %d %q %v", code, body, err)
+	}
+	unmapped := testCertificate(t, "unknown.svc.acme.com", &ca)
+	if code, _, err := call(&unmapped); err != nil || code != http.StatusUnauthorized {
+		t.Errorf("certificate without identity should need a bearer token. got %d %v", code, err)
+	}
+	untrusted := testCertificate(t, "billing.svc.acme.com", nil)
+	if _, _, err := call(&untrusted); err == nil {
+		t.Errorf("untrusted certificate should fail the handshake")
+	}
+	if code, _, err := call(nil); err != nil || code != http.StatusUnauthorized {
+		t.Errorf("request without certificate should need a bearer token. got %d %v", code, err)
+	}
+
+	requireConfig, err := helper.NewServerTLSConfig(helper.ClientAuthRequire, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TLS.ClientAuth = requireConfig.ClientAuth
+	if _, _, err := call(nil); err == nil {
+		t.Errorf("request without certificate should fail the handshake when certificates are required")
+	}
+}
+
+func TestParseClientIdentities(t *testing.T) {
+	identities, err := ParseClientIdentities("billing.svc=admin@acme, user@acme ; reports.svc=user@acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 2 || identities[0].Name != "billing.svc" || len(identities[0].Roles) != 2 || identities[1].Roles[0] != "user@acme" {
+		t.Errorf("unexpected identities %v", identities)
+	}
+	for _, invalid := range []string{"billing.svc", "=admin@acme", "billing.svc= , "} {
+		if _, err := ParseClientIdentities(invalid); err == nil {
+			t.Errorf("%q should be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package endpoint
+
+import (
+	"context"
+	"mime"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// ContentNegotiationMiddleware lets clients use XML instead of JSON when "server.http.xml.enable" is on.
+// A request preferring "application/xml" in its Accept header is responded in XML, and a request
+// with "application/xml" Content-Type has its body read as XML. JSON remains the default.
+func ContentNegotiationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.GetBoolean("server.http.xml.enable") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if helper.NegotiateMediaType(r.Header.Get("Accept"), helper.MediaTypeJSON, helper.MediaTypeXML, "text/xml") != helper.MediaTypeJSON {
+			ctx = context.WithValue(ctx, constants.ResponseMediaType, helper.MediaTypeXML)
+		}
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && (mediaType == helper.MediaTypeXML || mediaType == "text/xml") {
+			ctx = context.WithValue(ctx, constants.RequestMediaType, helper.MediaTypeXML)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
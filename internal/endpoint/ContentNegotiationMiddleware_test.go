@@ -0,0 +1,112 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type xmlUserRepo struct {
+	connector.UserRepository
+	user *connector.User
+}
+
+func (repo *xmlUserRepo) GetUserByRecID(ctx context.Context, recID string) (*connector.User, error) {
+	if recID != repo.user.RecID {
+		return nil, nil
+	}
+	return repo.user, nil
+}
+
+func (repo *xmlUserRepo) ListUser(ctx context.Context, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	return []*connector.User{repo.user}, helper.NewPage(request, 1), nil
+}
+
+func (repo *xmlUserRepo) UpdateUser(ctx context.Context, user *connector.User) error {
+	repo.user = user
+	return nil
+}
+
+func negotiatedRequest(method, path, accept, contentType, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Accept", accept)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	authCtx := &hansipcontext.AuthenticationContext{Subject: "admin@hansip", Audience: []string{"admin@hansip"}}
+	return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, authCtx))
+}
+
+func serveNegotiated(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	ContentNegotiationMiddleware(handler).ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestContentNegotiationUserRoundTrip(t *testing.T) {
+	config.Set("server.http.xml.enable", "true")
+	defer config.Set("server.http.xml.enable", "false")
+	repo := &xmlUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true}}
+	UserRepo = repo
+
+	recorder := serveNegotiated(GetUserDetail, negotiatedRequest(http.MethodGet, "/api/v1/management/user/u1", "application/xml", "", ""))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/xml" {
+		t.Fatalf("expect 200 xml but %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	detail := &struct {
+		Status string            `xml:"status"`
+		Data   UpdateUserRequest `xml:"data"`
+	}{}
+	if err := xml.Unmarshal(recorder.Body.Bytes(), detail); err != nil {
+		t.Fatalf("response is not xml. got %s\n%s", err.Error(), recorder.Body.String())
+	}
+	if detail.Status != "SUCCESS" || detail.Data.Email != "user@acme.com" || !detail.Data.Enabled {
+		t.Fatalf("unexpected user. got %+v", detail)
+	}
+
+	detail.Data.Suspended = true
+	body, _ := xml.Marshal(detail.Data)
+	recorder = serveNegotiated(UpdateUserDetail, negotiatedRequest(http.MethodPut, "/api/v1/management/user/u1", "application/json;q=0.5, application/xml", "application/xml; charset=utf-8", string(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d. %s", recorder.Code, recorder.Body.String())
+	}
+	if !repo.user.Suspended || repo.user.Email != "user@acme.com" || !repo.user.Enabled {
+		t.Errorf("xml request body should update the user. got %+v", repo.user)
+	}
+	if !bytes.Contains(recorder.Body.Bytes(), []byte("<suspended>true</suspended>")) {
+		t.Errorf("updated user should be responded in xml. got %s", recorder.Body.String())
+	}
+
+	recorder = serveNegotiated(ListAllUsers, negotiatedRequest(http.MethodGet, "/api/v1/management/users", "text/xml", "", ""))
+	list := &struct {
+		Users []SimpleUser `xml:"data>users>user"`
+		Page  helper.Page  `xml:"data>page"`
+	}{}
+	if err := xml.Unmarshal(recorder.Body.Bytes(), list); err != nil {
+		t.Fatalf("response is not xml. got %s\n%s", err.Error(), recorder.Body.String())
+	}
+	if len(list.Users) != 1 || list.Users[0].RecID != "u1" || !list.Users[0].Suspended || list.Page.TotalItems != 1 {
+		t.Errorf("unexpected user list. got %+v", list)
+	}
+
+	recorder = serveNegotiated(GetUserDetail, negotiatedRequest(http.MethodGet, "/api/v1/management/user/u1", "*/*", "", ""))
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("json should be the default. got %s", recorder.Header().Get("Content-Type"))
+	}
+
+	config.Set("server.http.xml.enable", "false")
+	recorder = serveNegotiated(GetUserDetail, negotiatedRequest(http.MethodGet, "/api/v1/management/user/u1", "application/xml", "", ""))
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("xml should not be negotiated when disabled. got %s", recorder.Header().Get("Content-Type"))
+	}
+}
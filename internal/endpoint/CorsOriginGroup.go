@@ -0,0 +1,182 @@
+package endpoint
+
+import (
+	"fmt"
+	"github.com/rs/cors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CorsOriginGroup is a set of origin pattern that share the same preflight MaxAge
+type CorsOriginGroup struct {
+	Origins []string
+	MaxAge  int
+}
+
+// Matches check whether the origin matches any of this group's origin pattern
+func (g *CorsOriginGroup) Matches(origin string) bool {
+	for _, pattern := range g.Origins {
+		if MatchCorsOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCorsOrigin validates a configured origin pattern.
+// A pattern is either "*", a plain origin such as "https://app.example.com"
+// or a wildcard subdomain origin such as "https://*.example.com".
+func ValidateCorsOrigin(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	if len(strings.TrimSpace(pattern)) == 0 {
+		return fmt.Errorf("empty origin")
+	}
+	idx := strings.Index(pattern, "://")
+	if idx <= 0 {
+		return fmt.Errorf("origin %s has no scheme", pattern)
+	}
+	scheme := strings.ToLower(pattern[:idx])
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("origin %s has unsupported scheme %s", pattern, scheme)
+	}
+	host := pattern[idx+3:]
+	if strings.Count(pattern, "*") > 1 {
+		return fmt.Errorf("origin %s contains more than one wildcard", pattern)
+	}
+	if strings.Contains(host, "*") {
+		if !strings.HasPrefix(host, "*.") {
+			return fmt.Errorf("origin %s wildcard must be the left most subdomain label, eg. https://*.example.com", pattern)
+		}
+		host = host[2:]
+		if !strings.Contains(host, ".") {
+			return fmt.Errorf("origin %s wildcard must be followed by at least a second level domain", pattern)
+		}
+	}
+	u, err := url.Parse(scheme + "://" + host)
+	if err != nil {
+		return fmt.Errorf("origin %s is not a valid url. got %s", pattern, err.Error())
+	}
+	if len(u.Host) == 0 || (len(u.Path) > 0 && u.Path != "/") || len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil {
+		return fmt.Errorf("origin %s must only contain scheme, host and optional port", pattern)
+	}
+	return nil
+}
+
+// MatchCorsOrigin check whether an origin sent by the browser matches the origin pattern.
+// "https://*.example.com" matches "https://app.example.com" and "https://a.b.example.com"
+// but not "https://example.com" nor "https://evilexample.com".
+func MatchCorsOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+	origin = strings.ToLower(origin)
+	if pattern == "*" {
+		return true
+	}
+	idx := strings.Index(pattern, "*")
+	if idx < 0 {
+		return pattern == origin
+	}
+	prefix := pattern[:idx]
+	suffix := pattern[idx+1:]
+	if len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	label := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(label, "/:?#@")
+}
+
+// ParseCorsOriginGroups parses origin group specification.
+// Groups are separated by semicolon, each group consist of comma separated origin patterns
+// followed by equal sign and the MaxAge in seconds, eg.
+// "https://*.example.com,https://example.com=600;https://admin.example.com=60"
+func ParseCorsOriginGroups(spec string) ([]*CorsOriginGroup, error) {
+	groups := make([]*CorsOriginGroup, 0)
+	for _, groupSpec := range strings.Split(spec, ";") {
+		groupSpec = strings.TrimSpace(groupSpec)
+		if len(groupSpec) == 0 {
+			continue
+		}
+		idx := strings.LastIndex(groupSpec, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("origin group %s has no max age", groupSpec)
+		}
+		maxAge, err := strconv.Atoi(strings.TrimSpace(groupSpec[idx+1:]))
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("origin group %s has invalid max age", groupSpec)
+		}
+		group := &CorsOriginGroup{
+			Origins: make([]string, 0),
+			MaxAge:  maxAge,
+		}
+		for _, origin := range strings.Split(groupSpec[:idx], ",") {
+			origin = strings.TrimSpace(origin)
+			if err := ValidateCorsOrigin(origin); err != nil {
+				return nil, err
+			}
+			group.Origins = append(group.Origins, origin)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// NewCorsHandler creates a CORS middleware using the cors options.
+// If the request origin matches one of the groups, the preflight MaxAge of the first matching group is used
+// instead of the option's MaxAge. Allowed origins are always validated against options.AllowedOrigins.
+func NewCorsHandler(options cors.Options, groups []*CorsOriginGroup) (func(http.Handler) http.Handler, error) {
+	for _, origin := range options.AllowedOrigins {
+		if err := ValidateCorsOrigin(origin); err != nil {
+			return nil, err
+		}
+	}
+	allowAll := false
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+	}
+	if !allowAll {
+		allowedOrigins := options.AllowedOrigins
+		options.AllowedOrigins = nil
+		options.AllowOriginFunc = func(origin string) bool {
+			for _, pattern := range allowedOrigins {
+				if MatchCorsOrigin(pattern, origin) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	defaultCors := cors.New(options)
+	groupCors := make([]*cors.Cors, len(groups))
+	for i, group := range groups {
+		groupOptions := options
+		groupOptions.MaxAge = group.MaxAge
+		groupCors[i] = cors.New(groupOptions)
+	}
+	return func(next http.Handler) http.Handler {
+		defaultHandler := defaultCors.Handler(next)
+		groupHandlers := make([]http.Handler, len(groupCors))
+		for i, c := range groupCors {
+			groupHandlers[i] = c.Handler(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if len(origin) > 0 {
+				for i, group := range groups {
+					if group.Matches(origin) {
+						groupHandlers[i].ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			defaultHandler.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,114 @@
+package endpoint
+
+import (
+	"github.com/rs/cors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchCorsOrigin(t *testing.T) {
+	testData := []struct {
+		Pattern string
+		Origin  string
+		Match   bool
+	}{
+		{"*", "https://anything.com", true},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://APP.Example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+	}
+	for i, td := range testData {
+		if MatchCorsOrigin(td.Pattern, td.Origin) != td.Match {
+			t.Errorf("#%d pattern %s origin %s expect match %v", i, td.Pattern, td.Origin, td.Match)
+		}
+	}
+}
+
+func TestValidateCorsOrigin(t *testing.T) {
+	valid := []string{"*", "https://example.com", "http://localhost:3000", "https://*.example.com"}
+	for _, origin := range valid {
+		if err := ValidateCorsOrigin(origin); err != nil {
+			t.Errorf("origin %s should be valid. got %s", origin, err.Error())
+		}
+	}
+	invalid := []string{"", "example.com", "ftp://example.com", "https://*.*.example.com", "https://app.*.com", "https://*.com", "https://example.com/path"}
+	for _, origin := range invalid {
+		if err := ValidateCorsOrigin(origin); err == nil {
+			t.Errorf("origin %s should be invalid", origin)
+		}
+	}
+}
+
+func TestParseCorsOriginGroups(t *testing.T) {
+	groups, err := ParseCorsOriginGroups("https://*.example.com, https://example.com=600;https://admin.other.com=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || len(groups[0].Origins) != 2 || groups[0].MaxAge != 600 || groups[1].MaxAge != 60 {
+		t.Errorf("unexpected groups parsing result")
+	}
+	if _, err := ParseCorsOriginGroups("https://example.com"); err == nil {
+		t.Errorf("group without max age should fail")
+	}
+	if _, err := ParseCorsOriginGroups("example.com=60"); err == nil {
+		t.Errorf("group with invalid origin should fail")
+	}
+}
+
+func TestNewCorsHandler(t *testing.T) {
+	options := cors.Options{
+		AllowedOrigins: []string{"https://*.example.com", "https://other.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		MaxAge:         300,
+	}
+	groups := []*CorsOriginGroup{{Origins: []string{"https://*.admin.example.com"}, MaxAge: 60}}
+	middleware, err := NewCorsHandler(options, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/something", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://app.example.com")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("matching subdomain should be allowed")
+	}
+	if rec.Header().Get("Access-Control-Max-Age") != "300" {
+		t.Errorf("expect default max age 300. got %s", rec.Header().Get("Access-Control-Max-Age"))
+	}
+
+	rec = preflight("https://portal.admin.example.com")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://portal.admin.example.com" {
+		t.Errorf("matching admin subdomain should be allowed")
+	}
+	if rec.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("expect group max age 60. got %s", rec.Header().Get("Access-Control-Max-Age"))
+	}
+
+	rec = preflight("https://app.notexample.com")
+	if len(rec.Header().Get("Access-Control-Allow-Origin")) != 0 {
+		t.Errorf("non matching subdomain should be rejected")
+	}
+
+	if _, err := NewCorsHandler(cors.Options{AllowedOrigins: []string{"https://*.*.example.com"}}, nil); err == nil {
+		t.Errorf("invalid origin should fail")
+	}
+}
@@ -0,0 +1,126 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type deactivationUserRepo struct {
+	connector.UserRepository
+	user   *connector.User
+	active bool
+}
+
+func (repo *deactivationUserRepo) GetUserByRecID(ctx context.Context, recID string) (*connector.User, error) {
+	if recID == repo.user.RecID {
+		return repo.user, nil
+	}
+	return nil, nil
+}
+
+func (repo *deactivationUserRepo) GetUserByEmail(ctx context.Context, email string) (*connector.User, error) {
+	return repo.user, nil
+}
+
+func (repo *deactivationUserRepo) UpdateUser(ctx context.Context, user *connector.User) error {
+	return nil
+}
+
+func (repo *deactivationUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	return []*connector.Role{}, nil, nil
+}
+
+func (repo *deactivationUserRepo) SetUserActive(ctx context.Context, user *connector.User, active bool) error {
+	repo.active = active
+	return nil
+}
+
+func (repo *deactivationUserRepo) IsUserActive(ctx context.Context, user *connector.User) (bool, error) {
+	return repo.active, nil
+}
+
+// adminRequest create a PUT request authenticated as the hansip admin
+func adminRequest(url string) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, url, nil)
+	return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@hansip",
+		Audience: []string{"admin@hansip"},
+	}))
+}
+
+func TestDeactivateUser(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	revocationRepo := &fakeRevocationRepo{revoked: make(map[string]bool)}
+	RevocationRepo = revocationRepo
+	repo := &deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}
+	UserRepo = repo
+
+	login := func() int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(`{"email":"user@test.com","passphrase":"abcdefg"}`))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		return recorder.Code
+	}
+
+	recorder := httptest.NewRecorder()
+	DeactivateUser(recorder, adminRequest(fmt.Sprintf("%s/management/user/u1/deactivate", apiPrefix)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if !revocationRepo.revoked["user@test.com"] {
+		t.Errorf("tokens of deactivated user should be revoked")
+	}
+	if code := login(); code != http.StatusForbidden {
+		t.Errorf("deactivated user should not be able to login. got %d", code)
+	}
+
+	recorder = httptest.NewRecorder()
+	ReactivateUser(recorder, adminRequest(fmt.Sprintf("%s/management/user/u1/reactivate", apiPrefix)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("reactivated user should be able to login. got %d", code)
+	}
+
+	recorder = httptest.NewRecorder()
+	DeactivateUser(recorder, adminRequest(fmt.Sprintf("%s/management/user/u2/deactivate", apiPrefix)))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expect 404 for unknown user. got %d", recorder.Code)
+	}
+}
+
+type failingRevocationRepo struct {
+	fakeRevocationRepo
+}
+
+func (repo *failingRevocationRepo) Revoke(ctx context.Context, subject string) error {
+	return fmt.Errorf("revocation store is down")
+}
+
+func TestDeactivateUserRevocationFailure(t *testing.T) {
+	RevocationRepo = &failingRevocationRepo{fakeRevocationRepo{revoked: make(map[string]bool)}}
+	UserRepo = &deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", Enabled: true}, active: true}
+
+	recorder := httptest.NewRecorder()
+	DeactivateUser(recorder, adminRequest(fmt.Sprintf("%s/management/user/u1/deactivate", apiPrefix)))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("deactivation whose tokens can not be revoked should fail. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+}
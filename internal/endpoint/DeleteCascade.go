@@ -0,0 +1,154 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+const (
+	// CascadeBlock refuses to delete a role or group that is still assigned, the default
+	CascadeBlock = "block"
+	// CascadeDetach removes the assignments of the role or group before deleting it
+	CascadeDetach = "detach"
+	// CascadeReassign moves the assignments to the role or group in the "reassign_to" query parameter before deleting it
+	CascadeReassign = "reassign"
+)
+
+// deleteCascade returns the cascade mode of the delete request, responding 400 if it is invalid.
+func deleteCascade(w http.ResponseWriter, r *http.Request) (string, bool) {
+	cascade := r.URL.Query().Get("cascade")
+	if len(cascade) == 0 {
+		cascade = CascadeBlock
+	}
+	if cascade != CascadeBlock && cascade != CascadeDetach && cascade != CascadeReassign {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("Cascade %s is not one of %s, %s or %s", cascade, CascadeBlock, CascadeDetach, CascadeReassign), nil, nil)
+		return "", false
+	}
+	if cascade == CascadeReassign && len(r.URL.Query().Get("reassign_to")) == 0 {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Cascade reassign needs the reassign_to query parameter", nil, nil)
+		return "", false
+	}
+	return cascade, true
+}
+
+// roleAssignments lists the users and groups the role is assigned to.
+func roleAssignments(ctx context.Context, role *connector.Role) ([]*connector.User, []*connector.Group, error) {
+	users := make([]*connector.User, 0)
+	err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		list, page, err := UserRoleRepo.ListUserRoleByRole(ctx, role, request)
+		users = append(users, list...)
+		return page, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := make([]*connector.Group, 0)
+	err = forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		list, page, err := GroupRoleRepo.ListGroupRoleByRole(ctx, role, request)
+		groups = append(groups, list...)
+		return page, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, groups, nil
+}
+
+// cascadeRoleDelete applies the cascade mode to the assignments of the role about to be deleted.
+// It returns the http status and message to respond with when the role must not be deleted.
+func cascadeRoleDelete(ctx context.Context, authCtx *hansipcontext.AuthenticationContext, role *connector.Role, cascade, reassignTo string) (int, string, error) {
+	users, groups, err := roleAssignments(ctx, role)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error(), err
+	}
+	if len(users) == 0 && len(groups) == 0 {
+		return 0, "", nil
+	}
+	switch cascade {
+	case CascadeBlock:
+		return http.StatusConflict, fmt.Sprintf("Role is still assigned to %d users and %d groups", len(users), len(groups)), nil
+	case CascadeReassign:
+		target, err := RoleRepo.GetRoleByRecID(ctx, reassignTo)
+		if err != nil || target == nil {
+			return http.StatusBadRequest, fmt.Sprintf("Role recid %s to reassign to not found", reassignTo), nil
+		}
+		if target.RecID == role.RecID || target.RoleDomain != role.RoleDomain {
+			return http.StatusBadRequest, "Role to reassign to must be another role of the same domain", nil
+		}
+		if !authCtx.IsAdminOfDomain(target.RoleDomain) {
+			return http.StatusForbidden, "You don't have the right to access the role to reassign to", nil
+		}
+		for _, user := range users {
+			if userRole, _ := UserRoleRepo.GetUserRole(ctx, user, target); userRole != nil {
+				continue
+			}
+			if _, err := UserRoleRepo.CreateUserRole(ctx, user, target); err != nil {
+				return http.StatusInternalServerError, err.Error(), err
+			}
+		}
+		for _, group := range groups {
+			if groupRole, _ := GroupRoleRepo.GetGroupRole(ctx, group, target); groupRole != nil {
+				continue
+			}
+			if _, err := GroupRoleRepo.CreateGroupRole(ctx, group, target); err != nil {
+				return http.StatusInternalServerError, err.Error(), err
+			}
+		}
+	}
+	if err := UserRoleRepo.DeleteUserRoleByRole(ctx, role); err != nil {
+		return http.StatusInternalServerError, err.Error(), err
+	}
+	if err := GroupRoleRepo.DeleteGroupRoleByRole(ctx, role); err != nil {
+		return http.StatusInternalServerError, err.Error(), err
+	}
+	return 0, "", nil
+}
+
+// cascadeGroupDelete applies the cascade mode to the members of the group about to be deleted.
+// It returns the http status and message to respond with when the group must not be deleted.
+func cascadeGroupDelete(ctx context.Context, authCtx *hansipcontext.AuthenticationContext, group *connector.Group, cascade, reassignTo string) (int, string, error) {
+	users := make([]*connector.User, 0)
+	err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		list, page, err := UserGroupRepo.ListUserGroupByGroup(ctx, group, request)
+		users = append(users, list...)
+		return page, err
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err.Error(), err
+	}
+	if len(users) == 0 {
+		return 0, "", nil
+	}
+	switch cascade {
+	case CascadeBlock:
+		return http.StatusConflict, fmt.Sprintf("Group still has %d users", len(users)), nil
+	case CascadeReassign:
+		target, err := GroupRepo.GetGroupByRecID(ctx, reassignTo)
+		if err != nil || target == nil {
+			return http.StatusBadRequest, fmt.Sprintf("Group recid %s to reassign to not found", reassignTo), nil
+		}
+		if target.RecID == group.RecID || target.GroupDomain != group.GroupDomain {
+			return http.StatusBadRequest, "Group to reassign to must be another group of the same domain", nil
+		}
+		if !authCtx.IsAdminOfDomain(target.GroupDomain) {
+			return http.StatusForbidden, "You don't have the right to access the group to reassign to", nil
+		}
+		for _, user := range users {
+			if userGroup, _ := UserGroupRepo.GetUserGroup(ctx, user, target); userGroup != nil {
+				continue
+			}
+			if _, err := UserGroupRepo.CreateUserGroup(ctx, user, target); err != nil {
+				return http.StatusInternalServerError, err.Error(), err
+			}
+		}
+	}
+	if err := UserGroupRepo.DeleteUserGroupByGroup(ctx, group); err != nil {
+		return http.StatusInternalServerError, err.Error(), err
+	}
+	return 0, "", nil
+}
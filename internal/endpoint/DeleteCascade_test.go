@@ -0,0 +1,135 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func (dir *memoryDirectory) GetRoleByRecID(ctx context.Context, recID string) (*connector.Role, error) {
+	for _, role := range dir.roles {
+		if role.RecID == recID {
+			return role, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) ListGroupRoleByRole(ctx context.Context, role *connector.Role, request *helper.PageRequest) ([]*connector.Group, *helper.Page, error) {
+	groups := make([]*connector.Group, 0)
+	for _, group := range dir.groups {
+		for _, r := range dir.groupRoles[group.RecID] {
+			if r.RecID == role.RecID {
+				groups = append(groups, group)
+			}
+		}
+	}
+	page, start, end := pageBounds(request, len(groups))
+	return groups[start:end], page, nil
+}
+
+func (dir *memoryDirectory) DeleteUserRoleByRole(ctx context.Context, role *connector.Role) error {
+	for userRecID, roles := range dir.userRoles {
+		dir.userRoles[userRecID] = withoutRole(roles, role)
+	}
+	return nil
+}
+
+func (dir *memoryDirectory) DeleteGroupRoleByRole(ctx context.Context, role *connector.Role) error {
+	for groupRecID, roles := range dir.groupRoles {
+		dir.groupRoles[groupRecID] = withoutRole(roles, role)
+	}
+	return nil
+}
+
+func withoutRole(roles []*connector.Role, role *connector.Role) []*connector.Role {
+	ret := make([]*connector.Role, 0)
+	for _, r := range roles {
+		if r.RecID != role.RecID {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+func deleteRoleWithCascade(ctx context.Context, role *connector.Role, query string) int {
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("%s/management/role/%s%s", apiPrefix, role.RecID, query), nil)
+	req = req.WithContext(context.WithValue(ctx, constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@acme.com",
+		Audience: []string{fmt.Sprintf("%s@acme", config.Get("hansip.admin"))},
+	}))
+	recorder := httptest.NewRecorder()
+	DeleteRole(recorder, req)
+	return recorder.Code
+}
+
+func hasLine(lines []string, line string) bool {
+	return strings.Contains("\n"+strings.Join(lines, "\n")+"\n", "\n"+line+"\n")
+}
+
+func TestDeleteRoleCascade(t *testing.T) {
+	ctx := context.Background()
+	seed := func() (*memoryDirectory, *connector.Role, *connector.Role) {
+		dir := seedDirectory(ctx)
+		dir.use()
+		member, _ := dir.GetRoleByName(ctx, "member", "acme")
+		admin, _ := dir.GetRoleByName(ctx, "admin", "acme")
+		alice, _ := dir.GetUserByEmail(ctx, "alice@acme.com")
+		dir.CreateUserRole(ctx, alice, member)
+		return dir, member, admin
+	}
+
+	dir, member, _ := seed()
+	if code := deleteRoleWithCascade(ctx, member, ""); code != http.StatusConflict {
+		t.Errorf("in use role should not be deleted by default. got %d", code)
+	}
+	if code := deleteRoleWithCascade(ctx, member, "?cascade=block"); code != http.StatusConflict {
+		t.Errorf("in use role should not be deleted with block. got %d", code)
+	}
+	if described := dir.describe(); !hasLine(described, "role member@acme") || !hasLine(described, "user alice@acme.com role member") || !hasLine(described, "group company role member") {
+		t.Errorf("blocked role and its assignments should be kept. got %v", described)
+	}
+	if code := deleteRoleWithCascade(ctx, member, "?cascade=orphan"); code != http.StatusBadRequest {
+		t.Errorf("unknown cascade should be rejected. got %d", code)
+	}
+
+	dir, member, _ = seed()
+	if code := deleteRoleWithCascade(ctx, member, "?cascade=detach"); code != http.StatusOK {
+		t.Errorf("in use role should be deleted with detach. got %d", code)
+	}
+	for _, line := range dir.describe() {
+		if strings.Contains(line, "member") {
+			t.Errorf("detached role should be gone. got %s", line)
+		}
+	}
+
+	dir, member, admin := seed()
+	guest, _ := dir.GetRoleByName(ctx, "guest", "other")
+	if code := deleteRoleWithCascade(ctx, member, "?cascade=reassign&reassign_to="+guest.RecID); code != http.StatusBadRequest {
+		t.Errorf("role should not be reassigned to another domain. got %d", code)
+	}
+	if code := deleteRoleWithCascade(ctx, member, "?cascade=reassign"); code != http.StatusBadRequest {
+		t.Errorf("reassign without reassign_to should be rejected. got %d", code)
+	}
+	if code := deleteRoleWithCascade(ctx, member, "?cascade=reassign&reassign_to="+admin.RecID); code != http.StatusOK {
+		t.Errorf("in use role should be deleted with reassign. got %d", code)
+	}
+	described := dir.describe()
+	if hasLine(described, "role member@acme") || !hasLine(described, "group company role admin") || !hasLine(described, "user alice@acme.com role admin") {
+		t.Errorf("assignments should be moved to the admin role. got %v", described)
+	}
+	alice, _ := dir.GetUserByEmail(ctx, "alice@acme.com")
+	roles, _, _ := dir.ListUserRoleByUser(ctx, alice, &helper.PageRequest{No: 1, PageSize: 10})
+	if len(roles) != 1 {
+		t.Errorf("a user already having the role should not get it twice. got %d roles", len(roles))
+	}
+}
@@ -0,0 +1,146 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DeleteConfirmationHeader is the request header echoing the confirmation token of a destructive delete
+	DeleteConfirmationHeader = "X-Delete-Confirmation"
+
+	deleteConfirmationPurpose = "delete_confirmation"
+)
+
+var (
+	deleteConfirmationLog = log.WithField("go", "DeleteConfirmation")
+)
+
+// DeleteConfirmationPaths are the path patterns of the tenant delete and bulk delete endpoints that require a confirmation token.
+func DeleteConfirmationPaths() []string {
+	return []string{
+		fmt.Sprintf("%s/management/tenant/{tenantRecId}", apiPrefix),
+		fmt.Sprintf("%s/management/user/{userRecId}/roles", apiPrefix),
+		fmt.Sprintf("%s/management/user/{userRecId}/groups", apiPrefix),
+		fmt.Sprintf("%s/management/group/{groupRecId}/users", apiPrefix),
+		fmt.Sprintf("%s/management/group/{groupRecId}/roles", apiPrefix),
+		fmt.Sprintf("%s/management/role/{roleRecId}/users", apiPrefix),
+		fmt.Sprintf("%s/management/role/{roleRecId}/groups", apiPrefix),
+	}
+}
+
+// isDeleteConfirmationPath check whether the path is one of the DeleteConfirmationPaths
+func isDeleteConfirmationPath(path string) bool {
+	for _, pattern := range DeleteConfirmationPaths() {
+		if _, err := helper.ParsePathParams(pattern, path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// PrepareDeleteRequest hold the model for preparing a destructive delete
+type PrepareDeleteRequest struct {
+	Path string `json:"path"`
+}
+
+// PrepareDeleteResponse hold the confirmation token to send in the DeleteConfirmationHeader of the delete request
+type PrepareDeleteResponse struct {
+	Path              string `json:"path" xml:"path"`
+	ConfirmationToken string `json:"confirmation_token" xml:"confirmation_token"`
+	ExpiresIn         int    `json:"expires_in" xml:"expires_in"`
+}
+
+// PrepareDelete serving request for a confirmation token of a tenant delete or bulk delete.
+// The token is only valid for the delete of the requested path by the same subject, within "api.delete.confirm.window".
+// Its "purpose" claim makes getHToken refuse it as a bearer token.
+func PrepareDelete(w http.ResponseWriter, r *http.Request) {
+	fLog := deleteConfirmationLog.WithField("func", "PrepareDelete").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	if !ok || authCtx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	req := &PrepareDeleteRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Malformed json body", nil, nil)
+		return
+	}
+	if !isDeleteConfirmationPath(req.Path) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("path %q does not require a delete confirmation", req.Path), nil, nil)
+		return
+	}
+	window, err := jiffy.DurationOf(config.Get("api.delete.confirm.window"))
+	if err != nil {
+		fLog.Errorf("jiffy.DurationOf got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	token, err := TokenFactory.CreateAccessToken(authCtx.Subject, []string{}, oneTimeClaims(map[string]interface{}{
+		"purpose": deleteConfirmationPurpose,
+		"path":    req.Path,
+	}), window)
+	if err != nil {
+		fLog.Errorf("TokenFactory.CreateAccessToken got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Delete confirmation token created", nil, &PrepareDeleteResponse{
+		Path:              req.Path,
+		ConfirmationToken: token,
+		ExpiresIn:         int(window.Seconds()),
+	})
+}
+
+// DeleteConfirmationMiddleware responds 428 to a DELETE request without a valid confirmation token from PrepareDelete
+// in the DeleteConfirmationHeader. It must be placed after the JwtMiddleware so the token is checked against the subject.
+func DeleteConfirmationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+		confirmation := r.Header.Get(DeleteConfirmationHeader)
+		if len(confirmation) == 0 {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusPreconditionRequired, fmt.Sprintf("this delete requires a confirmation token in the %s header. prepare one with POST %s/management/delete/prepare", DeleteConfirmationHeader, apiPrefix), nil, nil)
+			return
+		}
+		subject := ""
+		if authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext); ok {
+			subject = authCtx.Subject
+		}
+		tok, err := TokenFactory.ReadToken(confirmation)
+		if err != nil || tok.Additional["purpose"] != deleteConfirmationPurpose || tok.Additional["path"] != r.URL.Path || tok.Subject != subject {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusPreconditionRequired, "invalid or expired delete confirmation token", nil, nil)
+			return
+		}
+		unused, err := consumeOneTimeToken(r.Context(), tok)
+		if err != nil {
+			deleteConfirmationLog.WithField("RequestID", r.Context().Value(constants.RequestID)).Errorf("consumeOneTimeToken got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if !unused {
+			writeTokenAlreadyUsed(r.Context(), w, http.StatusPreconditionRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
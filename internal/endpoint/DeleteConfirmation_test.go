@@ -0,0 +1,120 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func TestDeleteConfirmation(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	tenantPath := fmt.Sprintf("%s/management/tenant/tenant1", apiPrefix)
+	withSubject := func(req *http.Request, subject string) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  subject,
+			Audience: []string{"admin@hansip"},
+		}))
+	}
+	prepare := func(path string) (*httptest.ResponseRecorder, string) {
+		req := withSubject(httptest.NewRequest(http.MethodPost, "/api/v1/management/delete/prepare", strings.NewReader(fmt.Sprintf(`{"path":%q}`, path))), "admin@hansip")
+		recorder := httptest.NewRecorder()
+		PrepareDelete(recorder, req)
+		resp := &struct {
+			Data PrepareDeleteResponse `json:"data"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), resp)
+		return recorder, resp.Data.ConfirmationToken
+	}
+	deleted := 0
+	handler := DeleteConfirmationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted++
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant deleted", nil, nil)
+	}))
+	remove := func(path, subject, confirmation string) int {
+		req := withSubject(httptest.NewRequest(http.MethodDelete, path, nil), subject)
+		if len(confirmation) > 0 {
+			req.Header.Set(DeleteConfirmationHeader, confirmation)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := remove(tenantPath, "admin@hansip", ""); code != http.StatusPreconditionRequired || deleted != 0 {
+		t.Errorf("delete without confirmation should respond 428. got %d, %d deleted", code, deleted)
+	}
+	if code := remove(tenantPath, "admin@hansip", "not-a-token"); code != http.StatusPreconditionRequired || deleted != 0 {
+		t.Errorf("delete with invalid confirmation should respond 428. got %d, %d deleted", code, deleted)
+	}
+	if recorder, _ := prepare(fmt.Sprintf("%s/management/tenants", apiPrefix)); recorder.Code != http.StatusBadRequest {
+		t.Errorf("preparing a path without confirmation should respond 400. got %d", recorder.Code)
+	}
+
+	recorder, confirmation := prepare(tenantPath)
+	if recorder.Code != http.StatusOK || len(confirmation) == 0 {
+		t.Fatalf("prepare should return a confirmation token. got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if code := remove(fmt.Sprintf("%s/management/tenant/tenant2", apiPrefix), "admin@hansip", confirmation); code != http.StatusPreconditionRequired {
+		t.Errorf("confirmation of another path should respond 428. got %d", code)
+	}
+	if code := remove(tenantPath, "other@hansip", confirmation); code != http.StatusPreconditionRequired {
+		t.Errorf("confirmation of another subject should respond 428. got %d", code)
+	}
+	if code := remove(tenantPath, "admin@hansip", confirmation); code != http.StatusOK || deleted != 1 {
+		t.Errorf("confirmed delete should be served. got %d, %d deleted", code, deleted)
+	}
+
+	expired, err := TokenFactory.CreateAccessToken("admin@hansip", []string{}, map[string]interface{}{
+		"purpose": deleteConfirmationPurpose,
+		"path":    tenantPath,
+	}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := remove(tenantPath, "admin@hansip", expired); code != http.StatusPreconditionRequired {
+		t.Errorf("expired confirmation should respond 428. got %d", code)
+	}
+	req := withSubject(httptest.NewRequest(http.MethodGet, tenantPath, nil), "admin@hansip")
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, req)
+	if get.Code != http.StatusOK {
+		t.Errorf("GET should not require confirmation. got %d", get.Code)
+	}
+}
+
+func TestDeleteConfirmationTokenNotBearer(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/management/delete/prepare", strings.NewReader(fmt.Sprintf(`{"path":%q}`, fmt.Sprintf("%s/management/tenant/tenant1", apiPrefix))))
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@hansip",
+		Audience: []string{"admin@hansip"},
+	}))
+	recorder := httptest.NewRecorder()
+	PrepareDelete(recorder, req)
+	resp := &struct {
+		Data PrepareDeleteResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), resp); err != nil || len(resp.Data.ConfirmationToken) == 0 {
+		t.Fatalf("expect a confirmation token. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fatest", apiPrefix), nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Data.ConfirmationToken)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("delete confirmation token should not be accepted as bearer token. got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,412 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// exportPageSize is the number of entities read from the repository at a time while exporting
+	exportPageSize = 100
+
+	// DirectoryRecordTenant kind of record carrying a tenant
+	DirectoryRecordTenant = "tenant"
+	// DirectoryRecordRole kind of record carrying a role
+	DirectoryRecordRole = "role"
+	// DirectoryRecordGroup kind of record carrying a group
+	DirectoryRecordGroup = "group"
+	// DirectoryRecordGroupParent kind of record carrying the parent of a group
+	DirectoryRecordGroupParent = "group_parent"
+	// DirectoryRecordGroupRole kind of record carrying a group-role relation
+	DirectoryRecordGroupRole = "group_role"
+	// DirectoryRecordUser kind of record carrying a user
+	DirectoryRecordUser = "user"
+	// DirectoryRecordUserRole kind of record carrying a user-role relation
+	DirectoryRecordUserRole = "user_role"
+	// DirectoryRecordUserGroup kind of record carrying a user-group relation
+	DirectoryRecordUserGroup = "user_group"
+	// DirectoryRecordError kind of record written when the export fails half way
+	DirectoryRecordError = "error"
+)
+
+var (
+	directoryExportLog = log.WithField("go", "DirectoryExport")
+)
+
+// ExportedUser is the exported form of a user. Secrets such as the 2FA key and recovery codes are never exported,
+// the hashed passphrase is only exported if "export.include.passphrase" is enabled.
+type ExportedUser struct {
+	RecID            string `json:"rec_id"`
+	Email            string `json:"email"`
+	Enabled          bool   `json:"enabled"`
+	Suspended        bool   `json:"suspended"`
+	HashedPassphrase string `json:"hashed_passphrase,omitempty"`
+}
+
+// GroupParent is the exported parent relation of a group
+type GroupParent struct {
+	GroupRecID  string `json:"group_rec_id"`
+	ParentRecID string `json:"parent_rec_id"`
+}
+
+// DirectoryRecord is a single line of the NDJSON directory dump. Only the field matching the Kind is set.
+// Records refer to each other using the rec_id of the exporting server,
+// an entity is always written before the relations that refer to it.
+type DirectoryRecord struct {
+	Kind        string               `json:"kind"`
+	Tenant      *connector.Tenant    `json:"tenant,omitempty"`
+	Role        *connector.Role      `json:"role,omitempty"`
+	Group       *connector.Group     `json:"group,omitempty"`
+	GroupParent *GroupParent         `json:"group_parent,omitempty"`
+	GroupRole   *connector.GroupRole `json:"group_role,omitempty"`
+	User        *ExportedUser        `json:"user,omitempty"`
+	UserRole    *connector.UserRole  `json:"user_role,omitempty"`
+	UserGroup   *connector.UserGroup `json:"user_group,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// forEachPage calls list with increasing page number until the last page is listed.
+func forEachPage(list func(request *helper.PageRequest) (*helper.Page, error)) error {
+	for no := uint(1); ; no++ {
+		page, err := list(&helper.PageRequest{
+			No:       no,
+			PageSize: exportPageSize,
+			OrderBy:  "",
+			Sort:     "ASC",
+		})
+		if err != nil {
+			return err
+		}
+		if page == nil || page.IsLast || page.No < no {
+			return nil
+		}
+	}
+}
+
+type directoryExporter struct {
+	encoder           *json.Encoder
+	flusher           http.Flusher
+	includePassphrase bool
+	roles             map[string]bool
+	groups            map[string]bool
+	users             map[string]bool
+}
+
+func newDirectoryExporter(w io.Writer) *directoryExporter {
+	exporter := &directoryExporter{
+		encoder:           json.NewEncoder(w),
+		includePassphrase: config.GetBoolean("export.include.passphrase"),
+		roles:             make(map[string]bool),
+		groups:            make(map[string]bool),
+		users:             make(map[string]bool),
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		exporter.flusher = flusher
+	}
+	return exporter
+}
+
+func (exp *directoryExporter) write(record *DirectoryRecord) error {
+	if err := exp.encoder.Encode(record); err != nil {
+		return err
+	}
+	if exp.flusher != nil {
+		exp.flusher.Flush()
+	}
+	return nil
+}
+
+func (exp *directoryExporter) writeRole(role *connector.Role) error {
+	if exp.roles[role.RecID] {
+		return nil
+	}
+	exp.roles[role.RecID] = true
+	return exp.write(&DirectoryRecord{Kind: DirectoryRecordRole, Role: role})
+}
+
+func (exp *directoryExporter) writeGroup(group *connector.Group) error {
+	if exp.groups[group.RecID] {
+		return nil
+	}
+	exp.groups[group.RecID] = true
+	return exp.write(&DirectoryRecord{Kind: DirectoryRecordGroup, Group: group})
+}
+
+func (exp *directoryExporter) writeUser(user *connector.User) error {
+	if exp.users[user.RecID] {
+		return nil
+	}
+	exp.users[user.RecID] = true
+	exported := &ExportedUser{
+		RecID:     user.RecID,
+		Email:     user.Email,
+		Enabled:   user.Enabled,
+		Suspended: user.Suspended,
+	}
+	if exp.includePassphrase {
+		exported.HashedPassphrase = user.HashedPassphrase
+	}
+	return exp.write(&DirectoryRecord{Kind: DirectoryRecordUser, User: exported})
+}
+
+// exportTenant writes the tenant, its roles and its groups together with the groups' parents and roles.
+func (exp *directoryExporter) exportTenant(ctx context.Context, tenant *connector.Tenant) error {
+	region, err := TenantRepo.GetTenantRegion(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	tenant.Region = region
+	if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordTenant, Tenant: tenant}); err != nil {
+		return err
+	}
+	err = forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		roles, page, err := RoleRepo.ListRoles(ctx, tenant, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if err := exp.writeRole(role); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+	if err != nil {
+		return err
+	}
+	groups := make([]*connector.Group, 0)
+	err = forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		list, page, err := GroupRepo.ListGroups(ctx, tenant, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range list {
+			if err := exp.writeGroup(group); err != nil {
+				return nil, err
+			}
+		}
+		groups = append(groups, list...)
+		return page, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := exp.exportGroupRelations(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exp *directoryExporter) exportGroupRelations(ctx context.Context, group *connector.Group) error {
+	parent, err := GroupRepo.GetParentGroup(ctx, group)
+	if err != nil {
+		return err
+	}
+	if parent != nil {
+		if err := exp.writeGroup(parent); err != nil {
+			return err
+		}
+		if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordGroupParent, GroupParent: &GroupParent{GroupRecID: group.RecID, ParentRecID: parent.RecID}}); err != nil {
+			return err
+		}
+	}
+	return forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		roles, page, err := GroupRoleRepo.ListGroupRoleByGroup(ctx, group, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if err := exp.writeRole(role); err != nil {
+				return nil, err
+			}
+			if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordGroupRole, GroupRole: &connector.GroupRole{GroupRecID: group.RecID, RoleRecID: role.RecID}}); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+}
+
+// exportAll writes all tenants, then all users together with their roles and groups.
+func (exp *directoryExporter) exportAll(ctx context.Context) error {
+	err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		tenants, page, err := TenantRepo.ListTenant(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, tenant := range tenants {
+			if err := exp.exportTenant(ctx, tenant); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+	if err != nil {
+		return err
+	}
+	return forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		users, page, err := UserRepo.ListUser(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if err := exp.writeUser(user); err != nil {
+				return nil, err
+			}
+			if err := exp.exportUserRelations(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+}
+
+func (exp *directoryExporter) exportUserRelations(ctx context.Context, user *connector.User) error {
+	err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		roles, page, err := UserRoleRepo.ListUserRoleByUser(ctx, user, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if err := exp.writeRole(role); err != nil {
+				return nil, err
+			}
+			if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordUserRole, UserRole: &connector.UserRole{UserRecID: user.RecID, RoleRecID: role.RecID}}); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+	if err != nil {
+		return err
+	}
+	return forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		groups, page, err := UserGroupRepo.ListUserGroupByUser(ctx, user, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			if err := exp.writeGroup(group); err != nil {
+				return nil, err
+			}
+			if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordUserGroup, UserGroup: &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID}}); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+}
+
+// exportTenantScoped writes the tenant with its roles and groups,
+// followed by the users having any of the tenant's roles or groups, with only their relations within the tenant.
+func (exp *directoryExporter) exportTenantScoped(ctx context.Context, tenant *connector.Tenant) error {
+	if err := exp.exportTenant(ctx, tenant); err != nil {
+		return err
+	}
+	err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		roles, page, err := RoleRepo.ListRoles(ctx, tenant, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+				users, page, err := UserRoleRepo.ListUserRoleByRole(ctx, role, request)
+				if err != nil {
+					return nil, err
+				}
+				for _, user := range users {
+					if err := exp.writeUser(user); err != nil {
+						return nil, err
+					}
+					if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordUserRole, UserRole: &connector.UserRole{UserRecID: user.RecID, RoleRecID: role.RecID}}); err != nil {
+						return nil, err
+					}
+				}
+				return page, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+	if err != nil {
+		return err
+	}
+	return forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+		groups, page, err := GroupRepo.ListGroups(ctx, tenant, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			err := forEachPage(func(request *helper.PageRequest) (*helper.Page, error) {
+				users, page, err := UserGroupRepo.ListUserGroupByGroup(ctx, group, request)
+				if err != nil {
+					return nil, err
+				}
+				for _, user := range users {
+					if err := exp.writeUser(user); err != nil {
+						return nil, err
+					}
+					if err := exp.write(&DirectoryRecord{Kind: DirectoryRecordUserGroup, UserGroup: &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID}}); err != nil {
+						return nil, err
+					}
+				}
+				return page, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	})
+}
+
+// ExportDirectory streams the users, groups, roles and their relations as NDJSON, one DirectoryRecord per line.
+// Specifying the "tenant" query parameter with a tenant rec_id only exports the entities of that tenant.
+// If the export fails after the streaming has started, the last record is an error record.
+func ExportDirectory(w http.ResponseWriter, r *http.Request) {
+	fLog := directoryExportLog.WithField("func", "ExportDirectory").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+
+	var tenant *connector.Tenant
+	if tenantRecID := r.URL.Query().Get("tenant"); len(tenantRecID) > 0 {
+		var err error
+		tenant, err = TenantRepo.GetTenantByRecID(r.Context(), tenantRecID)
+		if err != nil {
+			fLog.Errorf("TenantRepo.GetTenantByRecID got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if tenant == nil {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Tenant recID %s not found", tenantRecID), nil, nil)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"hansip-export.ndjson\"")
+	w.WriteHeader(http.StatusOK)
+
+	exporter := newDirectoryExporter(w)
+	var err error
+	if tenant != nil {
+		err = exporter.exportTenantScoped(r.Context(), tenant)
+	} else {
+		err = exporter.exportAll(r.Context())
+	}
+	if err != nil {
+		fLog.Errorf("export got %s", err.Error())
+		exporter.write(&DirectoryRecord{Kind: DirectoryRecordError, Error: err.Error()})
+	}
+}
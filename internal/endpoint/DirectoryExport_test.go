@@ -0,0 +1,443 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// memoryDirectory is an in memory tenant, user, group and role repository.
+type memoryDirectory struct {
+	connector.TenantRepository
+	connector.UserRepository
+	connector.GroupRepository
+	connector.RoleRepository
+	connector.UserRoleRepository
+	connector.UserGroupRepository
+	connector.GroupRoleRepository
+
+	tenants    []*connector.Tenant
+	regions    map[string]string
+	brandings  map[string]*connector.TenantBranding
+	domains    map[string][]string
+	inactive   map[string]bool
+	users      []*connector.User
+	groups     []*connector.Group
+	roles      []*connector.Role
+	parents    map[string]*connector.Group
+	userRoles  map[string][]*connector.Role
+	userGroups map[string][]*connector.Group
+	groupRoles map[string][]*connector.Role
+	lastRecID  int
+}
+
+func newMemoryDirectory() *memoryDirectory {
+	return &memoryDirectory{
+		regions:    make(map[string]string),
+		brandings:  make(map[string]*connector.TenantBranding),
+		domains:    make(map[string][]string),
+		inactive:   make(map[string]bool),
+		parents:    make(map[string]*connector.Group),
+		userRoles:  make(map[string][]*connector.Role),
+		userGroups: make(map[string][]*connector.Group),
+		groupRoles: make(map[string][]*connector.Role),
+	}
+}
+
+func (dir *memoryDirectory) use() {
+	TenantRepo = dir
+	UserRepo = dir
+	GroupRepo = dir
+	RoleRepo = dir
+	UserRoleRepo = dir
+	UserGroupRepo = dir
+	GroupRoleRepo = dir
+}
+
+func (dir *memoryDirectory) newRecID() string {
+	dir.lastRecID++
+	return fmt.Sprintf("id%d", dir.lastRecID)
+}
+
+// pageBounds returns the requested page and the bounds of its items among total items.
+func pageBounds(request *helper.PageRequest, total int) (*helper.Page, int, int) {
+	page := helper.NewPage(request, uint(total))
+	return page, int(page.OffsetStart), int(page.OffsetEnd)
+}
+
+func (dir *memoryDirectory) ListTenant(ctx context.Context, request *helper.PageRequest) ([]*connector.Tenant, *helper.Page, error) {
+	page, start, end := pageBounds(request, len(dir.tenants))
+	return dir.tenants[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetTenantByRecID(ctx context.Context, recID string) (*connector.Tenant, error) {
+	for _, tenant := range dir.tenants {
+		if tenant.RecID == recID {
+			return tenant, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) GetTenantByDomain(ctx context.Context, tenantDomain string) (*connector.Tenant, error) {
+	for _, tenant := range dir.tenants {
+		if tenant.Domain == tenantDomain {
+			return tenant, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) CreateTenantRecord(ctx context.Context, tenantName, tenantDomain, description string) (*connector.Tenant, error) {
+	tenant := &connector.Tenant{RecID: dir.newRecID(), Name: tenantName, Domain: tenantDomain, Description: description}
+	dir.tenants = append(dir.tenants, tenant)
+	return tenant, nil
+}
+
+func (dir *memoryDirectory) GetTenantRegion(ctx context.Context, tenant *connector.Tenant) (string, error) {
+	return dir.regions[tenant.RecID], nil
+}
+
+func (dir *memoryDirectory) SetTenantRegion(ctx context.Context, tenant *connector.Tenant, region string) error {
+	dir.regions[tenant.RecID] = region
+	return nil
+}
+
+func (dir *memoryDirectory) GetTenantEmailDomains(ctx context.Context, tenant *connector.Tenant) ([]string, error) {
+	return dir.domains[tenant.RecID], nil
+}
+
+func (dir *memoryDirectory) IsTenantActive(ctx context.Context, tenant *connector.Tenant) (bool, error) {
+	return true, nil
+}
+
+func (dir *memoryDirectory) ListRoles(ctx context.Context, tenant *connector.Tenant, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	roles := make([]*connector.Role, 0)
+	for _, role := range dir.roles {
+		if role.RoleDomain == tenant.Domain {
+			roles = append(roles, role)
+		}
+	}
+	page, start, end := pageBounds(request, len(roles))
+	return roles[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetRoleByName(ctx context.Context, roleName, roleDomain string) (*connector.Role, error) {
+	for _, role := range dir.roles {
+		if role.RoleName == roleName && role.RoleDomain == roleDomain {
+			return role, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) CreateRole(ctx context.Context, roleName, roleDomain, description string) (*connector.Role, error) {
+	role := &connector.Role{RecID: dir.newRecID(), RoleName: roleName, RoleDomain: roleDomain, Description: description}
+	dir.roles = append(dir.roles, role)
+	return role, nil
+}
+
+func (dir *memoryDirectory) ListGroups(ctx context.Context, tenant *connector.Tenant, request *helper.PageRequest) ([]*connector.Group, *helper.Page, error) {
+	groups := make([]*connector.Group, 0)
+	for _, group := range dir.groups {
+		if group.GroupDomain == tenant.Domain {
+			groups = append(groups, group)
+		}
+	}
+	page, start, end := pageBounds(request, len(groups))
+	return groups[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetGroupByName(ctx context.Context, groupName, groupDomain string) (*connector.Group, error) {
+	for _, group := range dir.groups {
+		if group.GroupName == groupName && group.GroupDomain == groupDomain {
+			return group, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) CreateGroup(ctx context.Context, groupName, groupDomain, description string) (*connector.Group, error) {
+	group := &connector.Group{RecID: dir.newRecID(), GroupName: groupName, GroupDomain: groupDomain, Description: description}
+	dir.groups = append(dir.groups, group)
+	return group, nil
+}
+
+func (dir *memoryDirectory) GetGroupByRecID(ctx context.Context, recID string) (*connector.Group, error) {
+	for _, group := range dir.groups {
+		if group.RecID == recID {
+			return group, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) GetParentGroup(ctx context.Context, group *connector.Group) (*connector.Group, error) {
+	return dir.parents[group.RecID], nil
+}
+
+func (dir *memoryDirectory) SetParentGroup(ctx context.Context, group, parent *connector.Group) error {
+	dir.parents[group.RecID] = parent
+	return nil
+}
+
+func (dir *memoryDirectory) ListUser(ctx context.Context, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	page, start, end := pageBounds(request, len(dir.users))
+	return dir.users[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetUserByEmail(ctx context.Context, email string) (*connector.User, error) {
+	for _, user := range dir.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dir *memoryDirectory) CreateUserRecord(ctx context.Context, email, passphrase string) (*connector.User, error) {
+	user := &connector.User{RecID: dir.newRecID(), Email: email, HashedPassphrase: "hashed " + passphrase}
+	dir.users = append(dir.users, user)
+	return user, nil
+}
+
+func (dir *memoryDirectory) UpdateUser(ctx context.Context, user *connector.User) error {
+	return nil
+}
+
+func (dir *memoryDirectory) IsUserActive(ctx context.Context, user *connector.User) (bool, error) {
+	return !dir.inactive[user.RecID], nil
+}
+
+func (dir *memoryDirectory) ListUserRoleByUser(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	roles := dir.userRoles[user.RecID]
+	page, start, end := pageBounds(request, len(roles))
+	return roles[start:end], page, nil
+}
+
+func (dir *memoryDirectory) ListUserRoleByRole(ctx context.Context, role *connector.Role, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	users := make([]*connector.User, 0)
+	for _, user := range dir.users {
+		for _, r := range dir.userRoles[user.RecID] {
+			if r.RecID == role.RecID {
+				users = append(users, user)
+			}
+		}
+	}
+	page, start, end := pageBounds(request, len(users))
+	return users[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetUserRole(ctx context.Context, user *connector.User, role *connector.Role) (*connector.UserRole, error) {
+	for _, r := range dir.userRoles[user.RecID] {
+		if r.RecID == role.RecID {
+			return &connector.UserRole{UserRecID: user.RecID, RoleRecID: role.RecID}, nil
+		}
+	}
+	return nil, &connector.ErrDBNoResult{Message: "no user role"}
+}
+
+func (dir *memoryDirectory) CreateUserRole(ctx context.Context, user *connector.User, role *connector.Role) (*connector.UserRole, error) {
+	dir.userRoles[user.RecID] = append(dir.userRoles[user.RecID], role)
+	return &connector.UserRole{UserRecID: user.RecID, RoleRecID: role.RecID}, nil
+}
+
+func (dir *memoryDirectory) ListUserGroupByUser(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Group, *helper.Page, error) {
+	groups := dir.userGroups[user.RecID]
+	page, start, end := pageBounds(request, len(groups))
+	return groups[start:end], page, nil
+}
+
+func (dir *memoryDirectory) ListUserGroupByGroup(ctx context.Context, group *connector.Group, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	users := make([]*connector.User, 0)
+	for _, user := range dir.users {
+		for _, g := range dir.userGroups[user.RecID] {
+			if g.RecID == group.RecID {
+				users = append(users, user)
+			}
+		}
+	}
+	page, start, end := pageBounds(request, len(users))
+	return users[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetUserGroup(ctx context.Context, user *connector.User, group *connector.Group) (*connector.UserGroup, error) {
+	for _, g := range dir.userGroups[user.RecID] {
+		if g.RecID == group.RecID {
+			return &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID}, nil
+		}
+	}
+	return nil, &connector.ErrDBNoResult{Message: "no user group"}
+}
+
+func (dir *memoryDirectory) CreateUserGroup(ctx context.Context, user *connector.User, group *connector.Group) (*connector.UserGroup, error) {
+	dir.userGroups[user.RecID] = append(dir.userGroups[user.RecID], group)
+	return &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID}, nil
+}
+
+func (dir *memoryDirectory) ListGroupRoleByGroup(ctx context.Context, group *connector.Group, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	roles := dir.groupRoles[group.RecID]
+	page, start, end := pageBounds(request, len(roles))
+	return roles[start:end], page, nil
+}
+
+func (dir *memoryDirectory) GetGroupRole(ctx context.Context, group *connector.Group, role *connector.Role) (*connector.GroupRole, error) {
+	for _, r := range dir.groupRoles[group.RecID] {
+		if r.RecID == role.RecID {
+			return &connector.GroupRole{GroupRecID: group.RecID, RoleRecID: role.RecID}, nil
+		}
+	}
+	return nil, &connector.ErrDBNoResult{Message: "no group role"}
+}
+
+func (dir *memoryDirectory) CreateGroupRole(ctx context.Context, group *connector.Group, role *connector.Role) (*connector.GroupRole, error) {
+	dir.groupRoles[group.RecID] = append(dir.groupRoles[group.RecID], role)
+	return &connector.GroupRole{GroupRecID: group.RecID, RoleRecID: role.RecID}, nil
+}
+
+// describe lists the directory content by name, independent of the rec ids.
+func (dir *memoryDirectory) describe() []string {
+	ret := make([]string, 0)
+	for _, tenant := range dir.tenants {
+		ret = append(ret, fmt.Sprintf("tenant %s %s", tenant.Domain, dir.regions[tenant.RecID]))
+	}
+	for _, role := range dir.roles {
+		ret = append(ret, fmt.Sprintf("role %s@%s", role.RoleName, role.RoleDomain))
+	}
+	for _, group := range dir.groups {
+		ret = append(ret, fmt.Sprintf("group %s@%s", group.GroupName, group.GroupDomain))
+		if parent := dir.parents[group.RecID]; parent != nil {
+			ret = append(ret, fmt.Sprintf("group %s parent %s", group.GroupName, parent.GroupName))
+		}
+		for _, role := range dir.groupRoles[group.RecID] {
+			ret = append(ret, fmt.Sprintf("group %s role %s", group.GroupName, role.RoleName))
+		}
+	}
+	for _, user := range dir.users {
+		ret = append(ret, fmt.Sprintf("user %s %v", user.Email, user.Enabled))
+		for _, role := range dir.userRoles[user.RecID] {
+			ret = append(ret, fmt.Sprintf("user %s role %s", user.Email, role.RoleName))
+		}
+		for _, group := range dir.userGroups[user.RecID] {
+			ret = append(ret, fmt.Sprintf("user %s group %s", user.Email, group.GroupName))
+		}
+	}
+	return ret
+}
+
+func seedDirectory(ctx context.Context) *memoryDirectory {
+	config.Set("tenant.region.allowed", "eu-west")
+	dir := newMemoryDirectory()
+	acme, _ := dir.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+	dir.SetTenantRegion(ctx, acme, "eu-west")
+	dir.CreateTenantRecord(ctx, "Other", "other", "Other corp")
+	admin, _ := dir.CreateRole(ctx, "admin", "acme", "Acme admin")
+	member, _ := dir.CreateRole(ctx, "member", "acme", "Acme member")
+	guest, _ := dir.CreateRole(ctx, "guest", "other", "Other guest")
+	company, _ := dir.CreateGroup(ctx, "company", "acme", "Acme company")
+	team, _ := dir.CreateGroup(ctx, "team", "acme", "Acme team")
+	dir.SetParentGroup(ctx, team, company)
+	dir.CreateGroupRole(ctx, company, member)
+	alice, _ := dir.CreateUserRecord(ctx, "alice@acme.com", "secret")
+	alice.Enabled = true
+	bob, _ := dir.CreateUserRecord(ctx, "bob@acme.com", "secret")
+	carol, _ := dir.CreateUserRecord(ctx, "carol@other.com", "secret")
+	dir.CreateUserRole(ctx, alice, admin)
+	dir.CreateUserGroup(ctx, bob, team)
+	dir.CreateUserRole(ctx, carol, guest)
+	return dir
+}
+
+func exportDirectory(t *testing.T, query string) []byte {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/export%s", apiPrefix, query), nil)
+	recorder := httptest.NewRecorder()
+	ExportDirectory(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	return recorder.Body.Bytes()
+}
+
+func importDirectory(t *testing.T, query string, dump []byte) *ImportResult {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/import%s", apiPrefix, query), bytes.NewReader(dump))
+	recorder := httptest.NewRecorder()
+	ImportDirectory(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	response := &struct {
+		Data *ImportResult `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Data
+}
+
+func TestDirectoryExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	source.use()
+	dump := exportDirectory(t, "")
+	if bytes.Contains(dump, []byte("hashed")) {
+		t.Errorf("passphrase should not be exported by default")
+	}
+
+	destination := newMemoryDirectory()
+	destination.use()
+	result := importDirectory(t, "", dump)
+	if result.Created[DirectoryRecordUser] != 3 || result.Created[DirectoryRecordRole] != 3 || result.Created[DirectoryRecordGroup] != 2 {
+		t.Errorf("unexpected import result %v", result.Created)
+	}
+	expect, got := strings.Join(source.describe(), "\n"), strings.Join(destination.describe(), "\n")
+	if expect != got {
+		t.Errorf("imported directory differs.\nexpect:\n%s\ngot:\n%s", expect, got)
+	}
+
+	// importing the same dump again only finds existing entities.
+	result = importDirectory(t, "", dump)
+	if len(result.Created) != 0 {
+		t.Errorf("second import should not create anything. got %v", result.Created)
+	}
+	if got := strings.Join(destination.describe(), "\n"); expect != got {
+		t.Errorf("second import should not change the directory. got:\n%s", got)
+	}
+}
+
+func TestDirectoryExportTenantScoped(t *testing.T) {
+	ctx := context.Background()
+	source := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	source.use()
+	acme, _ := source.GetTenantByDomain(ctx, "acme")
+	dump := exportDirectory(t, "?tenant="+acme.RecID)
+	for _, unexpected := range []string{"other", "guest", "carol"} {
+		if bytes.Contains(dump, []byte(unexpected)) {
+			t.Errorf("tenant scoped export should not contain %s", unexpected)
+		}
+	}
+
+	destination := newMemoryDirectory()
+	destination.use()
+	importDirectory(t, "", dump)
+	if len(destination.users) != 2 || len(destination.roles) != 2 || len(destination.groups) != 2 {
+		t.Errorf("expect 2 users, 2 roles and 2 groups. got %d, %d and %d", len(destination.users), len(destination.roles), len(destination.groups))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/export?tenant=unknown", apiPrefix), nil)
+	recorder := httptest.NewRecorder()
+	ExportDirectory(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expect 404 for unknown tenant. got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,885 @@
+package endpoint
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// ImportModeMerge creates the missing entities and updates the existing ones
+	ImportModeMerge = "merge"
+	// ImportModeReplace replaces all roles and groups of the tenant with the imported ones, leaving a clean tenant
+	ImportModeReplace = "replace"
+
+	// ImportStatusCreated the record is created
+	ImportStatusCreated = "created"
+	// ImportStatusUpdated the record already exists and is updated
+	ImportStatusUpdated = "updated"
+	// ImportStatusExisted the record already exists and is left untouched
+	ImportStatusExisted = "existed"
+	// ImportStatusSkipped the record is outside the imported tenant or of unknown kind
+	ImportStatusSkipped = "skipped"
+	// ImportStatusFailed the record is invalid or can not be stored
+	ImportStatusFailed = "failed"
+)
+
+var (
+	directoryImportLog = log.WithField("go", "DirectoryImport")
+
+	// deferredKinds are the kinds of record imported after all tenants and users, in this order.
+	// Roles and groups need their tenant while relations need both of their entities.
+	deferredKinds = []string{DirectoryRecordRole, DirectoryRecordGroup, DirectoryRecordGroupParent, DirectoryRecordGroupRole, DirectoryRecordUserRole, DirectoryRecordUserGroup}
+
+	errIncompleteDump = errors.New("the dump is incomplete")
+)
+
+// ImportRecordResult is the outcome of importing a single record of the dump
+type ImportRecordResult struct {
+	// Record is the position of the record in the dump, starting from 1
+	Record int    `json:"record"`
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportResult summarizes a directory import, the counters are per record kind
+type ImportResult struct {
+	Mode    string                `json:"mode"`
+	Deleted map[string]int        `json:"deleted"`
+	Created map[string]int        `json:"created"`
+	Updated map[string]int        `json:"updated"`
+	Existed map[string]int        `json:"existed"`
+	Skipped map[string]int        `json:"skipped"`
+	Failed  map[string]int        `json:"failed"`
+	Records []*ImportRecordResult `json:"records"`
+}
+
+type pendingRecord struct {
+	no     int
+	record *DirectoryRecord
+}
+
+type directoryImporter struct {
+	mode       string
+	tenant     *connector.Tenant
+	roles      map[string]*connector.Role
+	groups     map[string]*connector.Group
+	users      map[string]*connector.User
+	outOfScope map[string]bool
+	pending    []*pendingRecord
+	result     *ImportResult
+}
+
+func newDirectoryImporter(mode string, tenant *connector.Tenant) *directoryImporter {
+	return &directoryImporter{
+		mode:       mode,
+		tenant:     tenant,
+		roles:      make(map[string]*connector.Role),
+		groups:     make(map[string]*connector.Group),
+		users:      make(map[string]*connector.User),
+		outOfScope: make(map[string]bool),
+		pending:    make([]*pendingRecord, 0),
+		result: &ImportResult{
+			Mode:    mode,
+			Deleted: make(map[string]int),
+			Created: make(map[string]int),
+			Updated: make(map[string]int),
+			Existed: make(map[string]int),
+			Skipped: make(map[string]int),
+			Failed:  make(map[string]int),
+			Records: make([]*ImportRecordResult, 0),
+		},
+	}
+}
+
+func (imp *directoryImporter) report(no int, kind, key, status string, err error) {
+	recordResult := &ImportRecordResult{Record: no, Kind: kind, Key: key, Status: status}
+	if err != nil {
+		recordResult.Error = err.Error()
+		if len(status) == 0 {
+			recordResult.Status = ImportStatusFailed
+		}
+	}
+	imp.result.Records = append(imp.result.Records, recordResult)
+	switch recordResult.Status {
+	case ImportStatusCreated:
+		imp.result.Created[kind]++
+	case ImportStatusUpdated:
+		imp.result.Updated[kind]++
+	case ImportStatusExisted:
+		imp.result.Existed[kind]++
+	case ImportStatusSkipped:
+		imp.result.Skipped[kind]++
+	default:
+		imp.result.Failed[kind]++
+	}
+}
+
+// inScope checks whether the domain belongs to the tenant the import is restricted to.
+func (imp *directoryImporter) inScope(domain string) bool {
+	return imp.tenant == nil || imp.tenant.Domain == domain
+}
+
+// importRecord imports a tenant or user record right away while the other records are kept until the end of the dump,
+// so a record may appear in the dump before the entities it refers to.
+func (imp *directoryImporter) importRecord(ctx context.Context, no int, record *DirectoryRecord) {
+	var key, status string
+	var err error
+	switch {
+	case record.Kind == DirectoryRecordTenant && record.Tenant != nil:
+		key, status, err = imp.importTenant(ctx, record.Tenant)
+	case record.Kind == DirectoryRecordUser && record.User != nil:
+		key, status, err = imp.importUser(ctx, record.User)
+	case record.Kind == DirectoryRecordRole && record.Role != nil,
+		record.Kind == DirectoryRecordGroup && record.Group != nil,
+		record.Kind == DirectoryRecordGroupParent && record.GroupParent != nil,
+		record.Kind == DirectoryRecordGroupRole && record.GroupRole != nil,
+		record.Kind == DirectoryRecordUserRole && record.UserRole != nil,
+		record.Kind == DirectoryRecordUserGroup && record.UserGroup != nil:
+		imp.pending = append(imp.pending, &pendingRecord{no: no, record: record})
+		return
+	default:
+		status = ImportStatusSkipped
+		err = fmt.Errorf("unknown record kind %s or missing content", record.Kind)
+	}
+	imp.report(no, record.Kind, key, status, err)
+}
+
+// importDeferred imports the records kept by importRecord.
+func (imp *directoryImporter) importDeferred(ctx context.Context) {
+	for _, kind := range deferredKinds {
+		for _, pending := range imp.pending {
+			if pending.record.Kind != kind {
+				continue
+			}
+			var key, status string
+			var err error
+			switch kind {
+			case DirectoryRecordRole:
+				key, status, err = imp.importRole(ctx, pending.record.Role)
+			case DirectoryRecordGroup:
+				key, status, err = imp.importGroup(ctx, pending.record.Group)
+			case DirectoryRecordGroupParent:
+				key, status, err = imp.importGroupParent(ctx, pending.record.GroupParent)
+			case DirectoryRecordGroupRole:
+				key, status, err = imp.importGroupRole(ctx, pending.record.GroupRole)
+			case DirectoryRecordUserRole:
+				key, status, err = imp.importUserRole(ctx, pending.record.UserRole)
+			case DirectoryRecordUserGroup:
+				key, status, err = imp.importUserGroup(ctx, pending.record.UserGroup)
+			}
+			imp.report(pending.no, kind, key, status, err)
+		}
+	}
+}
+
+// pendingGroupTree is the group tree of a replacement directory, it lets ValidateParentGroup check it before it is stored.
+type pendingGroupTree struct {
+	connector.GroupRepository
+	groups  map[string]*connector.Group
+	parents map[string]string
+}
+
+// GetParentGroup returns the parent of the group in the replacement directory
+func (tree *pendingGroupTree) GetParentGroup(ctx context.Context, group *connector.Group) (*connector.Group, error) {
+	return tree.groups[tree.parents[group.RecID]], nil
+}
+
+// directoryReplacement is the new directory of the tenant built from the records of a replace import
+type directoryReplacement struct {
+	*directoryImporter
+	directory *connector.TenantDirectory
+	roles     map[string]*connector.Role
+	groups    map[string]*connector.Group
+	tree      *pendingGroupTree
+	relations map[string]bool
+}
+
+func newDirectoryReplacement(imp *directoryImporter) *directoryReplacement {
+	directory := &connector.TenantDirectory{
+		Roles:      make([]*connector.Role, 0),
+		Groups:     make([]*connector.Group, 0),
+		Parents:    make(map[string]string),
+		GroupRoles: make([]*connector.GroupRole, 0),
+		UserRoles:  make([]*connector.UserRole, 0),
+		UserGroups: make([]*connector.UserGroup, 0),
+	}
+	return &directoryReplacement{
+		directoryImporter: imp,
+		directory:         directory,
+		roles:             make(map[string]*connector.Role),
+		groups:            make(map[string]*connector.Group),
+		tree:              &pendingGroupTree{groups: make(map[string]*connector.Group), parents: directory.Parents},
+		relations:         make(map[string]bool),
+	}
+}
+
+// replaceDeferred builds the roles, groups and relations of the records kept by importRecord as a new directory of the tenant,
+// and stores it in place of the current roles and groups of the tenant in a single transaction.
+// The records are reported once the directory is stored, or as failed if it can not be stored.
+func (imp *directoryImporter) replaceDeferred(ctx context.Context) error {
+	rep := newDirectoryReplacement(imp)
+	staged := make([]*ImportRecordResult, 0, len(imp.pending))
+	for _, kind := range deferredKinds {
+		for _, pending := range imp.pending {
+			if pending.record.Kind != kind {
+				continue
+			}
+			var key, status string
+			var err error
+			switch kind {
+			case DirectoryRecordRole:
+				key, status, err = rep.addRole(ctx, pending.record.Role)
+			case DirectoryRecordGroup:
+				key, status, err = rep.addGroup(ctx, pending.record.Group)
+			case DirectoryRecordGroupParent:
+				key, status, err = rep.addGroupParent(ctx, pending.record.GroupParent)
+			case DirectoryRecordGroupRole:
+				key, status, err = rep.addGroupRole(pending.record.GroupRole)
+			case DirectoryRecordUserRole:
+				key, status, err = rep.addUserRole(ctx, pending.record.UserRole)
+			case DirectoryRecordUserGroup:
+				key, status, err = rep.addUserGroup(ctx, pending.record.UserGroup)
+			}
+			recordResult := &ImportRecordResult{Record: pending.no, Kind: kind, Key: key, Status: status}
+			if err != nil {
+				recordResult.Status = ImportStatusFailed
+				recordResult.Error = err.Error()
+			}
+			staged = append(staged, recordResult)
+		}
+	}
+
+	deletedRoles, deletedGroups, err := TenantRepo.ReplaceTenantDirectory(ctx, imp.tenant, rep.directory)
+	for _, recordResult := range staged {
+		if err != nil && recordResult.Status != ImportStatusFailed && recordResult.Status != ImportStatusSkipped {
+			imp.report(recordResult.Record, recordResult.Kind, recordResult.Key, ImportStatusFailed, err)
+			continue
+		}
+		var recordErr error
+		if len(recordResult.Error) > 0 {
+			recordErr = errors.New(recordResult.Error)
+		}
+		imp.report(recordResult.Record, recordResult.Kind, recordResult.Key, recordResult.Status, recordErr)
+	}
+	if err != nil {
+		return err
+	}
+	imp.result.Deleted[DirectoryRecordRole] = deletedRoles
+	imp.result.Deleted[DirectoryRecordGroup] = deletedGroups
+	return nil
+}
+
+// addRelation adds the relation once, reporting its duplicates as existed.
+func (rep *directoryReplacement) addRelation(kind, key string, add func()) string {
+	if rep.relations[kind+"|"+key] {
+		return ImportStatusExisted
+	}
+	rep.relations[kind+"|"+key] = true
+	add()
+	return ImportStatusCreated
+}
+
+func (rep *directoryReplacement) addRole(ctx context.Context, exported *connector.Role) (string, string, error) {
+	key := fmt.Sprintf("%s@%s", exported.RoleName, exported.RoleDomain)
+	if !rep.inScope(exported.RoleDomain) {
+		rep.outOfScope[exported.RecID] = true
+		return key, ImportStatusSkipped, nil
+	}
+	if err := validateDomainEntity(ctx, exported.RoleName, exported.RoleDomain); err != nil {
+		return key, "", err
+	}
+	status := ImportStatusExisted
+	role, ok := rep.roles[key]
+	if !ok {
+		role = &connector.Role{RecID: connector.NewRecID(), RoleName: exported.RoleName, RoleDomain: exported.RoleDomain, Description: exported.Description}
+		rep.roles[key] = role
+		rep.directory.Roles = append(rep.directory.Roles, role)
+		status = ImportStatusCreated
+	}
+	rep.directoryImporter.roles[exported.RecID] = role
+	return key, status, nil
+}
+
+func (rep *directoryReplacement) addGroup(ctx context.Context, exported *connector.Group) (string, string, error) {
+	key := fmt.Sprintf("%s@%s", exported.GroupName, exported.GroupDomain)
+	if !rep.inScope(exported.GroupDomain) {
+		rep.outOfScope[exported.RecID] = true
+		return key, ImportStatusSkipped, nil
+	}
+	if err := validateDomainEntity(ctx, exported.GroupName, exported.GroupDomain); err != nil {
+		return key, "", err
+	}
+	status := ImportStatusExisted
+	group, ok := rep.groups[key]
+	if !ok {
+		group = &connector.Group{RecID: connector.NewRecID(), GroupName: exported.GroupName, GroupDomain: exported.GroupDomain, Description: exported.Description}
+		rep.groups[key] = group
+		rep.tree.groups[group.RecID] = group
+		rep.directory.Groups = append(rep.directory.Groups, group)
+		status = ImportStatusCreated
+	}
+	rep.directoryImporter.groups[exported.RecID] = group
+	return key, status, nil
+}
+
+func (rep *directoryReplacement) addGroupParent(ctx context.Context, exported *GroupParent) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.GroupRecID, exported.ParentRecID)
+	group, err := rep.resolveGroup(exported.GroupRecID)
+	if err != nil {
+		return key, "", err
+	}
+	parent, err := rep.resolveGroup(exported.ParentRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if group == nil || parent == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s@%s -> %s@%s", group.GroupName, group.GroupDomain, parent.GroupName, parent.GroupDomain)
+	current, hasParent := rep.directory.Parents[group.RecID]
+	if current == parent.RecID {
+		return key, ImportStatusExisted, nil
+	}
+	if err := connector.ValidateParentGroup(ctx, rep.tree, group, parent); err != nil {
+		return key, "", err
+	}
+	rep.directory.Parents[group.RecID] = parent.RecID
+	if hasParent {
+		return key, ImportStatusUpdated, nil
+	}
+	return key, ImportStatusCreated, nil
+}
+
+func (rep *directoryReplacement) addGroupRole(exported *connector.GroupRole) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.GroupRecID, exported.RoleRecID)
+	group, err := rep.resolveGroup(exported.GroupRecID)
+	if err != nil {
+		return key, "", err
+	}
+	role, err := rep.resolveRole(exported.RoleRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if group == nil || role == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s@%s -> %s@%s", group.GroupName, group.GroupDomain, role.RoleName, role.RoleDomain)
+	if role.RoleDomain != group.GroupDomain {
+		return key, "", fmt.Errorf("role can not be added into group with different domain")
+	}
+	return key, rep.addRelation(DirectoryRecordGroupRole, key, func() {
+		rep.directory.GroupRoles = append(rep.directory.GroupRoles, &connector.GroupRole{GroupRecID: group.RecID, RoleRecID: role.RecID})
+	}), nil
+}
+
+func (rep *directoryReplacement) addUserRole(ctx context.Context, exported *connector.UserRole) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.UserRecID, exported.RoleRecID)
+	user, err := rep.resolveUser(exported.UserRecID)
+	if err != nil {
+		return key, "", err
+	}
+	role, err := rep.resolveRole(exported.RoleRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if role == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s -> %s@%s", user.Email, role.RoleName, role.RoleDomain)
+	if err := validateTenantEmailDomains(ctx, []string{role.RoleDomain}, user.Email); err != nil {
+		return key, "", err
+	}
+	return key, rep.addRelation(DirectoryRecordUserRole, key, func() {
+		rep.directory.UserRoles = append(rep.directory.UserRoles, &connector.UserRole{UserRecID: user.RecID, RoleRecID: role.RecID})
+	}), nil
+}
+
+func (rep *directoryReplacement) addUserGroup(ctx context.Context, exported *connector.UserGroup) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.UserRecID, exported.GroupRecID)
+	user, err := rep.resolveUser(exported.UserRecID)
+	if err != nil {
+		return key, "", err
+	}
+	group, err := rep.resolveGroup(exported.GroupRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if group == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s -> %s@%s", user.Email, group.GroupName, group.GroupDomain)
+	if err := validateTenantEmailDomains(ctx, []string{group.GroupDomain}, user.Email); err != nil {
+		return key, "", err
+	}
+	return key, rep.addRelation(DirectoryRecordUserGroup, key, func() {
+		rep.directory.UserGroups = append(rep.directory.UserGroups, &connector.UserGroup{UserRecID: user.RecID, GroupRecID: group.RecID})
+	}), nil
+}
+
+func (imp *directoryImporter) importTenant(ctx context.Context, exported *connector.Tenant) (string, string, error) {
+	key := exported.Domain
+	if !imp.inScope(exported.Domain) {
+		return key, ImportStatusSkipped, nil
+	}
+	if len(exported.Domain) == 0 || strings.Contains(exported.Domain, "@") {
+		return key, "", fmt.Errorf("tenant domain %q is not valid", exported.Domain)
+	}
+	if err := validateTenantRegion(exported.Region); err != nil {
+		return key, "", err
+	}
+	tenant, err := TenantRepo.GetTenantByDomain(ctx, exported.Domain)
+	if err != nil {
+		return key, "", err
+	}
+	if tenant == nil {
+		tenant, err = TenantRepo.CreateTenantRecord(ctx, exported.Name, exported.Domain, exported.Description)
+		if err != nil {
+			return key, "", err
+		}
+		if len(exported.Region) > 0 {
+			if err := TenantRepo.SetTenantRegion(ctx, tenant, exported.Region); err != nil {
+				return key, "", err
+			}
+		}
+		return key, ImportStatusCreated, nil
+	}
+	status := ImportStatusExisted
+	if tenant.Name != exported.Name || tenant.Description != exported.Description {
+		tenant.Name = exported.Name
+		tenant.Description = exported.Description
+		if err := TenantRepo.UpdateTenant(ctx, tenant); err != nil {
+			return key, "", err
+		}
+		status = ImportStatusUpdated
+	}
+	region, err := TenantRepo.GetTenantRegion(ctx, tenant)
+	if err != nil {
+		return key, "", err
+	}
+	if region != exported.Region {
+		if err := TenantRepo.SetTenantRegion(ctx, tenant, exported.Region); err != nil {
+			return key, "", err
+		}
+		status = ImportStatusUpdated
+	}
+	return key, status, nil
+}
+
+// validateDomainEntity applies the same validation as creating a role or a group.
+func validateDomainEntity(ctx context.Context, name, domain string) error {
+	if strings.Contains(name, "@") || strings.Contains(domain, "@") {
+		return fmt.Errorf("name or domain of %s@%s contains @", name, domain)
+	}
+	tenant, err := TenantRepo.GetTenantByDomain(ctx, domain)
+	if err != nil {
+		return err
+	}
+	if tenant == nil {
+		return fmt.Errorf("tenant domain %s not found", domain)
+	}
+	return nil
+}
+
+func (imp *directoryImporter) importRole(ctx context.Context, exported *connector.Role) (string, string, error) {
+	key := fmt.Sprintf("%s@%s", exported.RoleName, exported.RoleDomain)
+	if !imp.inScope(exported.RoleDomain) {
+		imp.outOfScope[exported.RecID] = true
+		return key, ImportStatusSkipped, nil
+	}
+	if err := validateDomainEntity(ctx, exported.RoleName, exported.RoleDomain); err != nil {
+		return key, "", err
+	}
+	role, err := RoleRepo.GetRoleByName(ctx, exported.RoleName, exported.RoleDomain)
+	if err != nil {
+		return key, "", err
+	}
+	status := ImportStatusExisted
+	if role == nil {
+		role, err = RoleRepo.CreateRole(ctx, exported.RoleName, exported.RoleDomain, exported.Description)
+		if err != nil {
+			return key, "", err
+		}
+		status = ImportStatusCreated
+	} else if role.Description != exported.Description {
+		role.Description = exported.Description
+		if err := RoleRepo.UpdateRole(ctx, role); err != nil {
+			return key, "", err
+		}
+		status = ImportStatusUpdated
+	}
+	imp.roles[exported.RecID] = role
+	return key, status, nil
+}
+
+func (imp *directoryImporter) importGroup(ctx context.Context, exported *connector.Group) (string, string, error) {
+	key := fmt.Sprintf("%s@%s", exported.GroupName, exported.GroupDomain)
+	if !imp.inScope(exported.GroupDomain) {
+		imp.outOfScope[exported.RecID] = true
+		return key, ImportStatusSkipped, nil
+	}
+	if err := validateDomainEntity(ctx, exported.GroupName, exported.GroupDomain); err != nil {
+		return key, "", err
+	}
+	group, err := GroupRepo.GetGroupByName(ctx, exported.GroupName, exported.GroupDomain)
+	if err != nil {
+		return key, "", err
+	}
+	status := ImportStatusExisted
+	if group == nil {
+		group, err = GroupRepo.CreateGroup(ctx, exported.GroupName, exported.GroupDomain, exported.Description)
+		if err != nil {
+			return key, "", err
+		}
+		status = ImportStatusCreated
+	} else if group.Description != exported.Description {
+		group.Description = exported.Description
+		if err := GroupRepo.UpdateGroup(ctx, group); err != nil {
+			return key, "", err
+		}
+		status = ImportStatusUpdated
+	}
+	imp.groups[exported.RecID] = group
+	return key, status, nil
+}
+
+// importUser creates or updates the user having the same email. The hashed passphrase is imported as is,
+// a new user exported without its hashed passphrase gets a random passphrase and has to recover it.
+func (imp *directoryImporter) importUser(ctx context.Context, exported *ExportedUser) (string, string, error) {
+	key := exported.Email
+	if !strings.Contains(exported.Email, "@") {
+		return key, "", fmt.Errorf("email %q is not valid", exported.Email)
+	}
+	if len(exported.HashedPassphrase) > 0 {
+		if _, err := bcrypt.Cost([]byte(exported.HashedPassphrase)); err != nil {
+			return key, "", fmt.Errorf("hashed passphrase of %s is not a bcrypt hash", exported.Email)
+		}
+	}
+	user, err := getUserByEmail(ctx, exported.Email)
+	if err != nil {
+		return key, "", err
+	}
+	status := ImportStatusExisted
+	if user == nil {
+		user, err = UserRepo.CreateUserRecord(ctx, NormalizeEmail(exported.Email), helper.MakeRandomString(20, true, true, true, true))
+		if err != nil {
+			return key, "", err
+		}
+		status = ImportStatusCreated
+	}
+	changed := user.Enabled != exported.Enabled || user.Suspended != exported.Suspended ||
+		(len(exported.HashedPassphrase) > 0 && user.HashedPassphrase != exported.HashedPassphrase)
+	if changed {
+		user.Enabled = exported.Enabled
+		user.Suspended = exported.Suspended
+		if len(exported.HashedPassphrase) > 0 {
+			user.HashedPassphrase = exported.HashedPassphrase
+		}
+		if err := UserRepo.UpdateUser(ctx, user); err != nil {
+			return key, "", err
+		}
+		if status == ImportStatusExisted {
+			status = ImportStatusUpdated
+		}
+	}
+	imp.users[exported.RecID] = user
+	return key, status, nil
+}
+
+// resolveRole returns the imported role the exported rec id refers to.
+// A nil role without error means the role is outside the imported tenant.
+func (imp *directoryImporter) resolveRole(recID string) (*connector.Role, error) {
+	if role, ok := imp.roles[recID]; ok {
+		return role, nil
+	}
+	if imp.outOfScope[recID] {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("role rec_id %s is not in the dump or failed to import", recID)
+}
+
+// resolveGroup returns the imported group the exported rec id refers to.
+// A nil group without error means the group is outside the imported tenant.
+func (imp *directoryImporter) resolveGroup(recID string) (*connector.Group, error) {
+	if group, ok := imp.groups[recID]; ok {
+		return group, nil
+	}
+	if imp.outOfScope[recID] {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("group rec_id %s is not in the dump or failed to import", recID)
+}
+
+func (imp *directoryImporter) resolveUser(recID string) (*connector.User, error) {
+	if user, ok := imp.users[recID]; ok {
+		return user, nil
+	}
+	return nil, fmt.Errorf("user rec_id %s is not in the dump or failed to import", recID)
+}
+
+// relationExists interprets the result of GetUserRole, GetUserGroup and GetGroupRole, which report a missing relation as ErrDBNoResult.
+func relationExists(found bool, err error) (bool, error) {
+	if err != nil {
+		noResult := &connector.ErrDBNoResult{}
+		if errors.As(err, &noResult) {
+			return false, nil
+		}
+		return false, err
+	}
+	return found, nil
+}
+
+func createRelation(found bool, err error, create func() error) (string, error) {
+	exists, err := relationExists(found, err)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return ImportStatusExisted, nil
+	}
+	if err := create(); err != nil {
+		return "", err
+	}
+	return ImportStatusCreated, nil
+}
+
+func (imp *directoryImporter) importGroupParent(ctx context.Context, exported *GroupParent) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.GroupRecID, exported.ParentRecID)
+	group, err := imp.resolveGroup(exported.GroupRecID)
+	if err != nil {
+		return key, "", err
+	}
+	parent, err := imp.resolveGroup(exported.ParentRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if group == nil || parent == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s@%s -> %s@%s", group.GroupName, group.GroupDomain, parent.GroupName, parent.GroupDomain)
+	current, err := GroupRepo.GetParentGroup(ctx, group)
+	if err != nil {
+		return key, "", err
+	}
+	if current != nil && current.RecID == parent.RecID {
+		return key, ImportStatusExisted, nil
+	}
+	if err := connector.ValidateParentGroup(ctx, GroupRepo, group, parent); err != nil {
+		return key, "", err
+	}
+	if err := GroupRepo.SetParentGroup(ctx, group, parent); err != nil {
+		return key, "", err
+	}
+	if current != nil {
+		return key, ImportStatusUpdated, nil
+	}
+	return key, ImportStatusCreated, nil
+}
+
+func (imp *directoryImporter) importGroupRole(ctx context.Context, exported *connector.GroupRole) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.GroupRecID, exported.RoleRecID)
+	group, err := imp.resolveGroup(exported.GroupRecID)
+	if err != nil {
+		return key, "", err
+	}
+	role, err := imp.resolveRole(exported.RoleRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if group == nil || role == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s@%s -> %s@%s", group.GroupName, group.GroupDomain, role.RoleName, role.RoleDomain)
+	if role.RoleDomain != group.GroupDomain {
+		return key, "", fmt.Errorf("role can not be added into group with different domain")
+	}
+	groupRole, err := GroupRoleRepo.GetGroupRole(ctx, group, role)
+	status, err := createRelation(groupRole != nil, err, func() error {
+		_, err := GroupRoleRepo.CreateGroupRole(ctx, group, role)
+		return err
+	})
+	return key, status, err
+}
+
+func (imp *directoryImporter) importUserRole(ctx context.Context, exported *connector.UserRole) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.UserRecID, exported.RoleRecID)
+	user, err := imp.resolveUser(exported.UserRecID)
+	if err != nil {
+		return key, "", err
+	}
+	role, err := imp.resolveRole(exported.RoleRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if role == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s -> %s@%s", user.Email, role.RoleName, role.RoleDomain)
+	if err := validateTenantEmailDomains(ctx, []string{role.RoleDomain}, user.Email); err != nil {
+		return key, "", err
+	}
+	userRole, err := UserRoleRepo.GetUserRole(ctx, user, role)
+	status, err := createRelation(userRole != nil, err, func() error {
+		_, err := UserRoleRepo.CreateUserRole(ctx, user, role)
+		return err
+	})
+	return key, status, err
+}
+
+func (imp *directoryImporter) importUserGroup(ctx context.Context, exported *connector.UserGroup) (string, string, error) {
+	key := fmt.Sprintf("%s -> %s", exported.UserRecID, exported.GroupRecID)
+	user, err := imp.resolveUser(exported.UserRecID)
+	if err != nil {
+		return key, "", err
+	}
+	group, err := imp.resolveGroup(exported.GroupRecID)
+	if err != nil {
+		return key, "", err
+	}
+	if group == nil {
+		return key, ImportStatusSkipped, nil
+	}
+	key = fmt.Sprintf("%s -> %s@%s", user.Email, group.GroupName, group.GroupDomain)
+	if err := validateTenantEmailDomains(ctx, []string{group.GroupDomain}, user.Email); err != nil {
+		return key, "", err
+	}
+	userGroup, err := UserGroupRepo.GetUserGroup(ctx, user, group)
+	status, err := createRelation(userGroup != nil, err, func() error {
+		_, err := UserGroupRepo.CreateUserGroup(ctx, user, group)
+		return err
+	})
+	return key, status, err
+}
+
+// readDirectoryRecords decodes the dump one record at a time and calls fn for each of them.
+// The dump is either NDJSON, as produced by ExportDirectory, or a JSON array of records.
+func readDirectoryRecords(body io.Reader, fn func(no int, record *DirectoryRecord)) error {
+	reader := bufio.NewReader(body)
+	isArray := false
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			reader.ReadByte()
+			continue
+		}
+		isArray = b[0] == '['
+		break
+	}
+	decoder := json.NewDecoder(reader)
+	if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+	for no := 1; ; no++ {
+		if isArray && !decoder.More() {
+			_, err := decoder.Token()
+			return err
+		}
+		record := &DirectoryRecord{}
+		err := decoder.Decode(record)
+		if err == io.EOF && !isArray {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("malformed record #%d. got %s", no, err.Error())
+		}
+		if record.Kind == DirectoryRecordError {
+			return fmt.Errorf("%w, its export failed with %s", errIncompleteDump, record.Error)
+		}
+		fn(no, record)
+	}
+}
+
+// ImportDirectory restores a dump produced by ExportDirectory, either NDJSON or a JSON array of records.
+// The "mode" query parameter is either "merge", the default, which creates missing entities and updates existing ones
+// matched by name and domain or by email, or "replace" which replaces all roles and groups of the tenant
+// specified in the "tenant" query parameter. Specifying the tenant only imports the entities of that tenant.
+// The whole dump is read before anything is imported, a malformed or incomplete dump changes nothing.
+// Roles, groups and relations are imported after the tenants and users, a record failing to import is reported and does not stop the import.
+// In replace mode the roles and groups of the tenant are deleted and the imported ones stored in a single transaction,
+// if it fails the tenant keeps its current roles and groups.
+func ImportDirectory(w http.ResponseWriter, r *http.Request) {
+	fLog := directoryImportLog.WithField("func", "ImportDirectory").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+
+	mode := r.URL.Query().Get("mode")
+	if len(mode) == 0 {
+		mode = ImportModeMerge
+	}
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("Import mode %s is not one of %s or %s", mode, ImportModeMerge, ImportModeReplace), nil, nil)
+		return
+	}
+
+	var tenant *connector.Tenant
+	if tenantRecID := r.URL.Query().Get("tenant"); len(tenantRecID) > 0 {
+		var err error
+		tenant, err = TenantRepo.GetTenantByRecID(r.Context(), tenantRecID)
+		if err != nil {
+			fLog.Errorf("TenantRepo.GetTenantByRecID got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if tenant == nil {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Tenant recID %s not found", tenantRecID), nil, nil)
+			return
+		}
+	}
+	if mode == ImportModeReplace && tenant == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Replace import mode needs the tenant query parameter", nil, nil)
+		return
+	}
+
+	records := make([]*pendingRecord, 0)
+	err := readDirectoryRecords(r.Body, func(no int, record *DirectoryRecord) {
+		records = append(records, &pendingRecord{no: no, record: record})
+	})
+	if err != nil {
+		fLog.Errorf("readDirectoryRecords got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+
+	importer := newDirectoryImporter(mode, tenant)
+	for _, record := range records {
+		importer.importRecord(r.Context(), record.no, record.record)
+	}
+	if mode == ImportModeReplace {
+		if err := importer.replaceDeferred(r.Context()); err != nil {
+			fLog.Errorf("replaceDeferred got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, importer.result)
+			return
+		}
+	} else {
+		importer.importDeferred(r.Context())
+	}
+
+	failed := 0
+	for _, count := range importer.result.Failed {
+		failed += count
+	}
+	if failed > 0 {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, fmt.Sprintf("Directory imported, %d records failed", failed), nil, importer.result)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Directory imported", nil, importer.result)
+}
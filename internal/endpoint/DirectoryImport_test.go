@@ -0,0 +1,360 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (dir *memoryDirectory) UpdateTenant(ctx context.Context, tenant *connector.Tenant) error {
+	return nil
+}
+
+func (dir *memoryDirectory) UpdateRole(ctx context.Context, role *connector.Role) error {
+	return nil
+}
+
+func (dir *memoryDirectory) UpdateGroup(ctx context.Context, group *connector.Group) error {
+	return nil
+}
+
+func (dir *memoryDirectory) DeleteRole(ctx context.Context, role *connector.Role) error {
+	roles := make([]*connector.Role, 0)
+	for _, r := range dir.roles {
+		if r.RecID != role.RecID {
+			roles = append(roles, r)
+		}
+	}
+	dir.roles = roles
+	for userRecID, userRoles := range dir.userRoles {
+		remaining := make([]*connector.Role, 0)
+		for _, r := range userRoles {
+			if r.RecID != role.RecID {
+				remaining = append(remaining, r)
+			}
+		}
+		dir.userRoles[userRecID] = remaining
+	}
+	return nil
+}
+
+func (dir *memoryDirectory) DeleteGroup(ctx context.Context, group *connector.Group) error {
+	groups := make([]*connector.Group, 0)
+	for _, g := range dir.groups {
+		if g.RecID != group.RecID {
+			groups = append(groups, g)
+		}
+	}
+	dir.groups = groups
+	delete(dir.parents, group.RecID)
+	delete(dir.groupRoles, group.RecID)
+	return nil
+}
+
+func (dir *memoryDirectory) ReplaceTenantDirectory(ctx context.Context, tenant *connector.Tenant, directory *connector.TenantDirectory) (int, int, error) {
+	deletedRoles, deletedGroups := 0, 0
+	for _, role := range append([]*connector.Role{}, dir.roles...) {
+		if role.RoleDomain == tenant.Domain {
+			dir.DeleteRole(ctx, role)
+			deletedRoles++
+		}
+	}
+	for _, group := range append([]*connector.Group{}, dir.groups...) {
+		if group.GroupDomain == tenant.Domain {
+			dir.DeleteGroup(ctx, group)
+			deletedGroups++
+		}
+	}
+	dir.roles = append(dir.roles, directory.Roles...)
+	dir.groups = append(dir.groups, directory.Groups...)
+	for groupRecID, parentRecID := range directory.Parents {
+		dir.parents[groupRecID], _ = dir.GetGroupByRecID(ctx, parentRecID)
+	}
+	for _, groupRole := range directory.GroupRoles {
+		role, _ := dir.GetRoleByRecID(ctx, groupRole.RoleRecID)
+		dir.groupRoles[groupRole.GroupRecID] = append(dir.groupRoles[groupRole.GroupRecID], role)
+	}
+	for _, userRole := range directory.UserRoles {
+		role, _ := dir.GetRoleByRecID(ctx, userRole.RoleRecID)
+		dir.userRoles[userRole.UserRecID] = append(dir.userRoles[userRole.UserRecID], role)
+	}
+	for _, userGroup := range directory.UserGroups {
+		group, _ := dir.GetGroupByRecID(ctx, userGroup.GroupRecID)
+		dir.userGroups[userGroup.UserRecID] = append(dir.userGroups[userGroup.UserRecID], group)
+	}
+	return deletedRoles, deletedGroups, nil
+}
+
+// failingReplaceDirectory is a memoryDirectory whose directory replacement fails, as a rolled back transaction
+type failingReplaceDirectory struct {
+	*memoryDirectory
+}
+
+func (dir *failingReplaceDirectory) ReplaceTenantDirectory(ctx context.Context, tenant *connector.Tenant, directory *connector.TenantDirectory) (int, int, error) {
+	return 0, 0, fmt.Errorf("transaction rolled back")
+}
+
+func importDirectoryWithStatus(t *testing.T, query string, dump []byte) (int, *ImportResult) {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/import%s", apiPrefix, query), bytes.NewReader(dump))
+	recorder := httptest.NewRecorder()
+	ImportDirectory(recorder, req)
+	response := &struct {
+		Data *ImportResult `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, response.Data
+}
+
+func findRecordResult(result *ImportResult, kind, key string) *ImportRecordResult {
+	for _, record := range result.Records {
+		if record.Kind == kind && record.Key == key {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestDirectoryImportMerge(t *testing.T) {
+	ctx := context.Background()
+	source := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.users[0].HashedPassphrase = string(hashed)
+	source.use()
+	config.Set("export.include.passphrase", "true")
+	dump := exportDirectory(t, "")
+	config.Set("export.include.passphrase", "false")
+
+	destination := newMemoryDirectory()
+	acme, _ := destination.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+	destination.SetTenantRegion(ctx, acme, "eu-west")
+	destination.CreateRole(ctx, "admin", "acme", "outdated description")
+	alice, _ := destination.CreateUserRecord(ctx, "alice@acme.com", "old")
+	destination.use()
+
+	result := importDirectory(t, "?mode=merge", dump)
+	if record := findRecordResult(result, DirectoryRecordRole, "admin@acme"); record == nil || record.Status != ImportStatusUpdated {
+		t.Errorf("existing role should be updated. got %v", record)
+	}
+	if record := findRecordResult(result, DirectoryRecordTenant, "acme"); record == nil || record.Status != ImportStatusExisted {
+		t.Errorf("identical tenant should be left untouched. got %v", record)
+	}
+	if record := findRecordResult(result, DirectoryRecordUser, "alice@acme.com"); record == nil || record.Status != ImportStatusUpdated {
+		t.Errorf("existing user should be updated. got %v", record)
+	}
+	if role, _ := destination.GetRoleByName(ctx, "admin", "acme"); role.Description != "Acme admin" {
+		t.Errorf("role description should be updated. got %s", role.Description)
+	}
+	if !alice.Enabled || alice.HashedPassphrase != string(hashed) {
+		t.Errorf("user should be enabled with the pre-hashed passphrase")
+	}
+	if record := findRecordResult(result, DirectoryRecordUserRole, "alice@acme.com -> admin@acme"); record == nil || record.Status != ImportStatusCreated {
+		t.Errorf("user role should be created. got %v", record)
+	}
+}
+
+func TestDirectoryImportReplace(t *testing.T) {
+	ctx := context.Background()
+	source := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	source.use()
+	acme, _ := source.GetTenantByDomain(ctx, "acme")
+	dump := exportDirectory(t, "?tenant="+acme.RecID)
+
+	destination := newMemoryDirectory()
+	acme, _ = destination.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+	legacy, _ := destination.CreateRole(ctx, "legacy", "acme", "Legacy role")
+	dave, _ := destination.CreateUserRecord(ctx, "dave@acme.com", "secret")
+	destination.CreateUserRole(ctx, dave, legacy)
+	destination.use()
+
+	if code, _ := importDirectoryWithStatus(t, "?mode=replace", dump); code != http.StatusBadRequest {
+		t.Errorf("replace without tenant should be rejected. got %d", code)
+	}
+	if code, _ := importDirectoryWithStatus(t, "?mode=overwrite&tenant="+acme.RecID, dump); code != http.StatusBadRequest {
+		t.Errorf("unknown mode should be rejected. got %d", code)
+	}
+
+	result := importDirectory(t, "?mode=replace&tenant="+acme.RecID, dump)
+	if result.Deleted[DirectoryRecordRole] != 1 {
+		t.Errorf("expect 1 deleted role. got %d", result.Deleted[DirectoryRecordRole])
+	}
+	if role, _ := destination.GetRoleByName(ctx, "legacy", "acme"); role != nil {
+		t.Errorf("legacy role should be removed")
+	}
+	if len(destination.userRoles[dave.RecID]) != 0 {
+		t.Errorf("user should lose the removed role")
+	}
+	if len(destination.roles) != 2 || len(destination.groups) != 2 {
+		t.Errorf("expect 2 roles and 2 groups. got %d and %d", len(destination.roles), len(destination.groups))
+	}
+	team, _ := destination.GetGroupByName(ctx, "team", "acme")
+	if parent := destination.parents[team.RecID]; parent == nil || parent.GroupName != "company" {
+		t.Errorf("team should be a child of company")
+	}
+}
+
+func TestDirectoryImportTenantEmailDomains(t *testing.T) {
+	ctx := context.Background()
+	source := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	source.use()
+	acme, _ := source.GetTenantByDomain(ctx, "acme")
+	dump := exportDirectory(t, "?tenant="+acme.RecID)
+
+	for _, mode := range []string{"merge", "replace"} {
+		destination := newMemoryDirectory()
+		acme, _ = destination.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+		destination.domains[acme.RecID] = []string{"acme.co.id"}
+		destination.use()
+
+		result := importDirectory(t, fmt.Sprintf("?mode=%s&tenant=%s", mode, acme.RecID), dump)
+		if record := findRecordResult(result, DirectoryRecordUserRole, "alice@acme.com -> admin@acme"); record == nil || record.Status != ImportStatusFailed {
+			t.Errorf("%s: user role outside the tenant email domains should fail. got %v", mode, record)
+		}
+		if record := findRecordResult(result, DirectoryRecordUserGroup, "bob@acme.com -> team@acme"); record == nil || record.Status != ImportStatusFailed {
+			t.Errorf("%s: user group outside the tenant email domains should fail. got %v", mode, record)
+		}
+		alice, _ := destination.GetUserByEmail(ctx, "alice@acme.com")
+		if alice == nil || len(destination.userRoles[alice.RecID]) != 0 {
+			t.Errorf("%s: alice should be imported without the admin role", mode)
+		}
+	}
+}
+
+func TestDirectoryImportReplaceUntouchedOnFailure(t *testing.T) {
+	ctx := context.Background()
+	source := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	source.use()
+	acme, _ := source.GetTenantByDomain(ctx, "acme")
+	dump := exportDirectory(t, "?tenant="+acme.RecID)
+
+	destination := newMemoryDirectory()
+	acme, _ = destination.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+	legacy, _ := destination.CreateRole(ctx, "legacy", "acme", "Legacy role")
+	dave, _ := destination.CreateUserRecord(ctx, "dave@acme.com", "secret")
+	destination.CreateUserRole(ctx, dave, legacy)
+	destination.use()
+
+	malformed := append(append([]byte{}, dump...), []byte("{\"kind\":\"role\",\"role\":")...)
+	if code, _ := importDirectoryWithStatus(t, "?mode=replace&tenant="+acme.RecID, malformed); code != http.StatusBadRequest {
+		t.Errorf("malformed dump should be rejected. got %d", code)
+	}
+	incomplete := append(append([]byte{}, dump...), []byte("{\"kind\":\"error\",\"error\":\"database gone\"}\n")...)
+	if code, _ := importDirectoryWithStatus(t, "?mode=replace&tenant="+acme.RecID, incomplete); code != http.StatusBadRequest {
+		t.Errorf("incomplete dump should be rejected. got %d", code)
+	}
+	if len(destination.roles) != 1 || len(destination.userRoles[dave.RecID]) != 1 || len(destination.users) != 1 {
+		t.Fatalf("a rejected dump should leave the tenant untouched. got %v", destination.describe())
+	}
+
+	TenantRepo = &failingReplaceDirectory{destination}
+	code, result := importDirectoryWithStatus(t, "?mode=replace&tenant="+acme.RecID, dump)
+	if code != http.StatusInternalServerError {
+		t.Errorf("failed replacement should answer 500. got %d", code)
+	}
+	if record := findRecordResult(result, DirectoryRecordRole, "admin@acme"); record == nil || record.Status != ImportStatusFailed {
+		t.Errorf("role of a failed replacement should be reported failed. got %v", record)
+	}
+	if role, _ := destination.GetRoleByName(ctx, "legacy", "acme"); role == nil || len(destination.roles) != 1 {
+		t.Errorf("failed replacement should keep the tenant roles. got %v", destination.describe())
+	}
+}
+
+func TestDirectoryImportReplaceGroupCycle(t *testing.T) {
+	ctx := context.Background()
+	destination := newMemoryDirectory()
+	acme, _ := destination.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+	destination.use()
+
+	dump := []byte(`{"kind":"group","group":{"rec_id":"g1","group_name":"company","group_domain":"acme"}}
+{"kind":"group","group":{"rec_id":"g2","group_name":"team","group_domain":"acme"}}
+{"kind":"group_parent","group_parent":{"group_rec_id":"g2","parent_rec_id":"g1"}}
+{"kind":"group_parent","group_parent":{"group_rec_id":"g1","parent_rec_id":"g2"}}
+`)
+	result := importDirectory(t, "?mode=replace&tenant="+acme.RecID, dump)
+	if record := findRecordResult(result, DirectoryRecordGroupParent, "team@acme -> company@acme"); record == nil || record.Status != ImportStatusCreated {
+		t.Errorf("group parent should be created. got %v", record)
+	}
+	if record := findRecordResult(result, DirectoryRecordGroupParent, "company@acme -> team@acme"); record == nil || record.Status != ImportStatusFailed {
+		t.Errorf("group parent closing a cycle should fail. got %v", record)
+	}
+	team, _ := destination.GetGroupByName(ctx, "team", "acme")
+	company, _ := destination.GetGroupByName(ctx, "company", "acme")
+	if destination.parents[team.RecID] == nil || destination.parents[company.RecID] != nil {
+		t.Errorf("only team should have a parent. got %v", destination.describe())
+	}
+}
+
+func TestDirectoryImportReferentialOrder(t *testing.T) {
+	ctx := context.Background()
+	config.Set("tenant.region.allowed", "eu-west")
+	defer config.Set("tenant.region.allowed", "")
+	destination := newMemoryDirectory()
+	destination.use()
+
+	// relations come before the entities they refer to, in a JSON array instead of NDJSON.
+	dump := []byte(`[
+		{"kind":"user_role","user_role":{"user_rec_id":"u1","role_rec_id":"r1"}},
+		{"kind":"group_role","group_role":{"group_rec_id":"g1","role_rec_id":"r1"}},
+		{"kind":"user_group","user_group":{"user_rec_id":"u1","group_rec_id":"g1"}},
+		{"kind":"user_role","user_role":{"user_rec_id":"u1","role_rec_id":"missing"}},
+		{"kind":"user","user":{"rec_id":"u1","email":"alice@acme.com","enabled":true}},
+		{"kind":"user","user":{"rec_id":"u2","email":"bob@acme.com","hashed_passphrase":"plain text"}},
+		{"kind":"role","role":{"rec_id":"r1","role_name":"admin","role_domain":"acme"}},
+		{"kind":"group","group":{"rec_id":"g1","group_name":"team","group_domain":"acme"}},
+		{"kind":"role","role":{"rec_id":"r2","role_name":"orphan","role_domain":"nowhere"}},
+		{"kind":"tenant","tenant":{"rec_id":"t1","name":"Acme","domain":"acme","region":"eu-west"}}
+	]`)
+	code, result := importDirectoryWithStatus(t, "", dump)
+	if code != http.StatusOK {
+		t.Fatalf("expect 200. got %d", code)
+	}
+	if record := findRecordResult(result, DirectoryRecordRole, "admin@acme"); record == nil || record.Status != ImportStatusCreated {
+		t.Errorf("role listed before its tenant should be created. got %v", record)
+	}
+	if record := findRecordResult(result, DirectoryRecordRole, "orphan@nowhere"); record == nil || record.Status != ImportStatusFailed {
+		t.Errorf("role of an unknown tenant should fail. got %v", record)
+	}
+	for _, key := range []string{"alice@acme.com -> admin@acme", "alice@acme.com -> team@acme"} {
+		found := false
+		for _, record := range result.Records {
+			if record.Key == key && record.Status == ImportStatusCreated {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("relation %s should be created", key)
+		}
+	}
+	if record := findRecordResult(result, DirectoryRecordGroupRole, "team@acme -> admin@acme"); record == nil || record.Status != ImportStatusCreated {
+		t.Errorf("group role should be created. got %v", record)
+	}
+	if record := findRecordResult(result, DirectoryRecordUserRole, "u1 -> missing"); record == nil || record.Status != ImportStatusFailed {
+		t.Errorf("relation to a missing role should fail. got %v", record)
+	}
+	if record := findRecordResult(result, DirectoryRecordUser, "bob@acme.com"); record == nil || record.Status != ImportStatusFailed {
+		t.Errorf("user with a non bcrypt passphrase should fail. got %v", record)
+	}
+	if user, _ := destination.GetUserByEmail(ctx, "bob@acme.com"); user != nil {
+		t.Errorf("failed user should not be created")
+	}
+
+	if code, _ := importDirectoryWithStatus(t, "", []byte(`{"kind":"error","error":"db down"}`)); code != http.StatusBadRequest {
+		t.Errorf("incomplete dump should be rejected. got %d", code)
+	}
+}
@@ -0,0 +1,110 @@
+package endpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	effectivePermissionsLog = log.WithField("go", "EffectivePermissions")
+
+	// PermissionsCache caches the effective permissions per token for "auth.permissions.cache.ttl", nil disables the cache
+	PermissionsCache cache.ObjectCache
+)
+
+// EffectivePermissionsResponse is what the caller can do, its roles and the permissions they grant
+type EffectivePermissionsResponse struct {
+	Subject string `json:"subject" xml:"subject"`
+	// Roles are the caller's roles in the form of "role@domain", given directly or through its groups and their ancestors
+	Roles       []string `json:"roles" xml:"roles"`
+	Permissions []string `json:"permissions" xml:"permissions"`
+}
+
+// permissionsCacheKey keys the cached permissions by a digest of the token, so the cache holds no usable token.
+func permissionsCacheKey(authCtx *hansipcontext.AuthenticationContext) string {
+	digest := sha256.Sum256([]byte(authCtx.Subject + "|" + authCtx.Token))
+	return hex.EncodeToString(digest[:])
+}
+
+// resolveEffectivePermissions resolves the roles of the caller the same way as on login, and the permissions of
+// "token.permissions" they grant. A token scoped on login keeps only the permissions of its scope.
+func resolveEffectivePermissions(r *http.Request, authCtx *hansipcontext.AuthenticationContext) (*EffectivePermissionsResponse, int, error) {
+	user, err := UserRepo.GetUserByEmail(r.Context(), authCtx.Subject)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if user == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("user %s not found", authCtx.Subject)
+	}
+	userRoles, _, err := UserRepo.ListAllUserRoles(r.Context(), user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "ROLE_NAME",
+		Sort:     "ASC",
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	roles := make([]string, 0, len(userRoles))
+	for _, role := range userRoles {
+		roles = append(roles, fmt.Sprintf("%s@%s", role.RoleName, role.RoleDomain))
+	}
+	sort.Strings(roles)
+	permissions, err := grantedPermissions(roles)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if len(authCtx.Permissions) > 0 {
+		inScope := make(map[string]bool, len(authCtx.Permissions))
+		for _, permission := range authCtx.Permissions {
+			inScope[permission] = true
+		}
+		scoped := make([]string, 0, len(permissions))
+		for _, permission := range permissions {
+			if inScope[permission] {
+				scoped = append(scoped, permission)
+			}
+		}
+		permissions = scoped
+	}
+	return &EffectivePermissionsResponse{Subject: authCtx.Subject, Roles: roles, Permissions: permissions}, http.StatusOK, nil
+}
+
+// GetEffectivePermissions serving request to get the roles and permissions of the caller, so a frontend can show
+// only the features the user can use. The result is cached per token when PermissionsCache is set.
+func GetEffectivePermissions(w http.ResponseWriter, r *http.Request) {
+	fLog := effectivePermissionsLog.WithField("func", "GetEffectivePermissions").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+
+	key := permissionsCacheKey(authCtx)
+	if PermissionsCache != nil {
+		if ok, cached := PermissionsCache.Fetch(key); ok {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Effective permissions", nil, cached)
+			return
+		}
+	}
+	effective, status, err := resolveEffectivePermissions(r, authCtx)
+	if err != nil {
+		fLog.Errorf("resolveEffectivePermissions got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, status, err.Error(), nil, nil)
+		return
+	}
+	if PermissionsCache != nil {
+		PermissionsCache.Store(key, effective)
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Effective permissions", nil, effective)
+}
@@ -0,0 +1,90 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
+)
+
+func getEffectivePermissions(t *testing.T, authCtx *hansipcontext.AuthenticationContext) *EffectivePermissionsResponse {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/auth/permissions", apiPrefix), nil)
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, authCtx))
+	recorder := httptest.NewRecorder()
+	GetEffectivePermissions(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	response := &struct {
+		Data *EffectivePermissionsResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Data
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	ctx := context.Background()
+	dir := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	dir.use()
+	config.Set("token.permissions", "admin@acme=users:read,users:write;member=audit:read;guest=guest:read")
+	defer config.Set("token.permissions", "")
+
+	// bob gets member@acme from the company group, the parent of his team
+	bob := getEffectivePermissions(t, &hansipcontext.AuthenticationContext{Subject: "bob@acme.com", Token: "bob-token"})
+	if !reflect.DeepEqual(bob.Roles, []string{"member@acme"}) || !reflect.DeepEqual(bob.Permissions, []string{"audit:read"}) {
+		t.Errorf("expect the permissions inherited from the parent group. got %+v", bob)
+	}
+
+	// alice has admin@acme directly and member@acme through the team she joins
+	alice, _ := dir.GetUserByEmail(ctx, "alice@acme.com")
+	team, _ := dir.GetGroupByName(ctx, "team", "acme")
+	dir.CreateUserGroup(ctx, alice, team)
+	effective := getEffectivePermissions(t, &hansipcontext.AuthenticationContext{Subject: "alice@acme.com", Token: "alice-token"})
+	if !reflect.DeepEqual(effective.Roles, []string{"admin@acme", "member@acme"}) || !reflect.DeepEqual(effective.Permissions, []string{"audit:read", "users:read", "users:write"}) {
+		t.Errorf("expect the direct and group derived permissions. got %+v", effective)
+	}
+
+	// a token scoped on login keeps only its scope
+	scoped := getEffectivePermissions(t, &hansipcontext.AuthenticationContext{Subject: "alice@acme.com", Token: "alice-scoped-token", Permissions: []string{"users:read"}})
+	if !reflect.DeepEqual(scoped.Permissions, []string{"users:read"}) {
+		t.Errorf("expect only the scope of the token. got %+v", scoped.Permissions)
+	}
+}
+
+func TestEffectivePermissionsCache(t *testing.T) {
+	ctx := context.Background()
+	dir := seedDirectory(ctx)
+	defer config.Set("tenant.region.allowed", "")
+	dir.use()
+	config.Set("token.permissions", "admin@acme=users:read;member=audit:read")
+	defer config.Set("token.permissions", "")
+	PermissionsCache = cache.NewInMemoryCache(10, 60, false)
+	defer func() { PermissionsCache = nil }()
+
+	aliceCtx := &hansipcontext.AuthenticationContext{Subject: "alice@acme.com", Token: "alice-token"}
+	if first := getEffectivePermissions(t, aliceCtx); !reflect.DeepEqual(first.Permissions, []string{"users:read"}) {
+		t.Fatalf("expect users:read. got %+v", first.Permissions)
+	}
+	alice, _ := dir.GetUserByEmail(ctx, "alice@acme.com")
+	member, _ := dir.GetRoleByName(ctx, "member", "acme")
+	dir.CreateUserRole(ctx, alice, member)
+
+	if cached := getEffectivePermissions(t, aliceCtx); !reflect.DeepEqual(cached.Permissions, []string{"users:read"}) {
+		t.Errorf("expect the permissions of the token served from the cache. got %+v", cached.Permissions)
+	}
+	fresh := getEffectivePermissions(t, &hansipcontext.AuthenticationContext{Subject: "alice@acme.com", Token: "alice-new-token"})
+	if !reflect.DeepEqual(fresh.Permissions, []string{"audit:read", "users:read"}) {
+		t.Errorf("expect another token resolved again. got %+v", fresh.Permissions)
+	}
+}
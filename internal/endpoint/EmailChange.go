@@ -0,0 +1,238 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	emailChangePurpose = "email-change"
+)
+
+var (
+	emailChangeLog = log.WithField("go", "EmailChange")
+)
+
+// ChangeEmailRequest hold the model for requesting a change of the user's email
+type ChangeEmailRequest struct {
+	NewEmail   string `json:"new_email"`
+	Passphrase string `json:"passphrase"`
+}
+
+// ConfirmEmailChangeRequest hold the model for confirming the new email using the token sent to it
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+// emailChangeMailData is the data of the email change confirmation and notice emails
+type emailChangeMailData struct {
+	*brandedUser
+	NewEmail string
+	Token    string
+}
+
+// createEmailChangeToken creates the signed token confirming the change of the user's email to the new email.
+// Its "purpose" claim makes getHToken refuse it as a bearer token, it is only accepted by ConfirmEmailChange.
+func createEmailChangeToken(user *connector.User, newEmail string) (string, error) {
+	ttl, err := jiffy.DurationOf(config.Get("auth.email.change.ttl"))
+	if err != nil {
+		return "", err
+	}
+	return TokenFactory.CreateAccessToken(user.Email, []string{}, oneTimeClaims(map[string]interface{}{
+		"purpose":     emailChangePurpose,
+		"user_rec_id": user.RecID,
+		"new_email":   newEmail,
+	}), ttl)
+}
+
+// isEmailTaken check whether the email is already used by another user
+func isEmailTaken(ctx context.Context, email string) (bool, error) {
+	other, err := UserRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	return other != nil, nil
+}
+
+// ChangeEmail serving request to change the authenticated user's email. The email is not changed until
+// the new email is confirmed using the link sent to it, the old email is notified about the change.
+func ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	fLog := emailChangeLog.WithField("func", "ChangeEmail").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !applyImpersonationRestriction(w, r, "Email") {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	req := &ChangeEmailRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Malformed json body", nil, nil)
+		return
+	}
+	user := authenticatedUser(w, r)
+	if user == nil {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.HashedPassphrase), []byte(req.Passphrase)) != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "passphrase not match", nil, nil)
+		return
+	}
+	newEmail := NormalizeEmail(req.NewEmail)
+	if strings.EqualFold(newEmail, user.Email) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "new email is the same as the current email", nil, nil)
+		return
+	}
+	if err := ValidateEmailAddress(r.Context(), newEmail); err != nil {
+		fLog.Errorf("ValidateEmailAddress got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	tenantDomains, err := userTenantDomains(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("userTenantDomains got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !applyTenantEmailDomains(w, r, tenantDomains, newEmail) {
+		return
+	}
+	taken, err := isEmailTaken(r.Context(), newEmail)
+	if err != nil {
+		fLog.Errorf("isEmailTaken got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if taken {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, fmt.Sprintf("email %s is already used", newEmail), nil, nil)
+		return
+	}
+	token, err := createEmailChangeToken(user, newEmail)
+	if err != nil {
+		fLog.Errorf("createEmailChangeToken got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+
+	data := &emailChangeMailData{
+		brandedUser: mailData(r.Context(), user).(*brandedUser),
+		NewEmail:    newEmail,
+		Token:       token,
+	}
+	mailer.Send(r.Context(), &mailer.Email{
+		From:     config.Get("mailer.from"),
+		FromName: config.Get("mailer.from.name"),
+		To:       []string{newEmail},
+		Template: "EMAIL_CHANGE",
+		Locale:   requestLocale(r),
+		Data:     data,
+	})
+	mailer.Send(r.Context(), &mailer.Email{
+		From:     config.Get("mailer.from"),
+		FromName: config.Get("mailer.from.name"),
+		To:       []string{user.Email},
+		Template: "EMAIL_CHANGE_NOTICE",
+		Locale:   requestLocale(r),
+		Data:     &emailChangeMailData{brandedUser: data.brandedUser, NewEmail: newEmail},
+	})
+
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Check your new email to confirm the change", nil, nil)
+}
+
+// ConfirmEmailChange serving request to confirm the new email using the token sent to it.
+// The user's email is replaced by the new email, which is then marked as verified.
+func ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	fLog := emailChangeLog.WithField("func", "ConfirmEmailChange").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	req := &ConfirmEmailChangeRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Malformed json body", nil, nil)
+		return
+	}
+	tok, err := TokenFactory.ReadToken(req.Token)
+	if err != nil || tok.Additional["purpose"] != emailChangePurpose {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid or expired email change token", nil, nil)
+		return
+	}
+	newEmail, _ := tok.Additional["new_email"].(string)
+	userRecID, _ := tok.Additional["user_rec_id"].(string)
+
+	// the token is void once the user's email is no longer the one it was issued for
+	user, err := UserRepo.GetUserByEmail(r.Context(), tok.Subject)
+	if err != nil {
+		fLog.Errorf("UserRepo.GetUserByEmail got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if user == nil || user.RecID != userRecID {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid or expired email change token", nil, nil)
+		return
+	}
+	taken, err := isEmailTaken(r.Context(), newEmail)
+	if err != nil {
+		fLog.Errorf("isEmailTaken got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if taken {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, fmt.Sprintf("email %s is already used", newEmail), nil, nil)
+		return
+	}
+
+	// the token is consumed last, so a request refused above does not void it
+	unused, err := consumeOneTimeToken(r.Context(), tok)
+	if err != nil {
+		fLog.Errorf("consumeOneTimeToken got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !unused {
+		writeTokenAlreadyUsed(r.Context(), w, http.StatusBadRequest)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = newEmail
+	err = UserRepo.UpdateUser(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("UserRepo.UpdateUser got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	err = UserRepo.SetEmailVerified(r.Context(), user, true)
+	if err != nil {
+		fLog.Errorf("UserRepo.SetEmailVerified got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	// the issued tokens carry the old email as their subject, they must not outlive the change
+	if err := RevocationRepo.Revoke(r.Context(), oldEmail); err != nil {
+		fLog.Errorf("RevocationRepo.Revoke got %s", err.Error())
+	}
+	writeAudit(r.Context(), AuditEmailChanged, oldEmail, user.Email, fmt.Sprintf("user recid %s", user.RecID))
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Email changed", nil, nil)
+}
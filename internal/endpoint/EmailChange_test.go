@@ -0,0 +1,138 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type emailChangeUserRepo struct {
+	connector.UserRepository
+	users    []*connector.User
+	verified map[string]bool
+}
+
+func (repo *emailChangeUserRepo) GetUserByEmail(ctx context.Context, email string) (*connector.User, error) {
+	for _, user := range repo.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *emailChangeUserRepo) UpdateUser(ctx context.Context, user *connector.User) error {
+	return nil
+}
+
+func (repo *emailChangeUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	return []*connector.Role{}, nil, nil
+}
+
+func (repo *emailChangeUserRepo) SetEmailVerified(ctx context.Context, user *connector.User, verified bool) error {
+	repo.verified[user.RecID] = verified
+	return nil
+}
+
+func TestChangeEmail(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret passphrase"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	alice := &connector.User{RecID: "u1", Email: "alice@example.com", HashedPassphrase: string(hashed)}
+	repo := &emailChangeUserRepo{
+		users:    []*connector.User{alice, {RecID: "u2", Email: "bob@example.com"}},
+		verified: make(map[string]bool),
+	}
+	UserRepo = repo
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+	revocation := &fakeRevocationRepo{revoked: make(map[string]bool)}
+	RevocationRepo = revocation
+
+	sent := make(chan *mailer.Email, 10)
+	stop := consumeMails(sent)
+	defer stop()
+
+	changeEmail := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/change-email", apiPrefix), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  alice.Email,
+			Audience: []string{"user@example"},
+		}))
+		recorder := httptest.NewRecorder()
+		ChangeEmail(recorder, req)
+		return recorder.Code
+	}
+	confirm := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/change-email/confirm", apiPrefix), strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
+		recorder := httptest.NewRecorder()
+		ConfirmEmailChange(recorder, req)
+		return recorder.Code
+	}
+	// requestChange asks for the email change and returns the token of the confirmation email
+	requestChange := func(newEmail string) string {
+		if code := changeEmail(fmt.Sprintf(`{"new_email":"%s","passphrase":"secret passphrase"}`, newEmail)); code != http.StatusOK {
+			t.Fatalf("expect 200 but %d", code)
+		}
+		confirmation, notice := <-sent, <-sent
+		if confirmation.Template != "EMAIL_CHANGE" || confirmation.To[0] != newEmail {
+			t.Fatalf("expect confirmation sent to %s. got %s to %v", newEmail, confirmation.Template, confirmation.To)
+		}
+		if notice.Template != "EMAIL_CHANGE_NOTICE" || notice.To[0] != alice.Email {
+			t.Errorf("expect notice sent to %s. got %s to %v", alice.Email, notice.Template, notice.To)
+		}
+		return confirmation.Data.(*emailChangeMailData).Token
+	}
+
+	if code := changeEmail(`{"new_email":"bob@example.com","passphrase":"secret passphrase"}`); code != http.StatusConflict {
+		t.Errorf("changing to an already used email should be rejected. got %d", code)
+	}
+	if code := changeEmail(`{"new_email":"carol@example.com","passphrase":"wrong passphrase"}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong passphrase should be rejected. got %d", code)
+	}
+	if len(sent) != 0 {
+		t.Fatalf("rejected change should not send email. got %d", len(sent))
+	}
+
+	token := requestChange("carol@example.com")
+	if alice.Email != "alice@example.com" {
+		t.Fatalf("email should not change before it is confirmed. got %s", alice.Email)
+	}
+	if code := confirm("not a token"); code != http.StatusBadRequest {
+		t.Errorf("invalid token should be rejected. got %d", code)
+	}
+	if code := confirm(token); code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	if alice.Email != "carol@example.com" || !repo.verified[alice.RecID] {
+		t.Errorf("expect the confirmed email set and verified. got %s verified %v", alice.Email, repo.verified[alice.RecID])
+	}
+	if !revocation.revoked["alice@example.com"] {
+		t.Errorf("expect the tokens of the old email revoked")
+	}
+	if code := confirm(token); code != http.StatusBadRequest {
+		t.Errorf("used token should be rejected. got %d", code)
+	}
+
+	// the new email is taken by another user before it is confirmed
+	token = requestChange("dave@example.com")
+	repo.users = append(repo.users, &connector.User{RecID: "u3", Email: "dave@example.com"})
+	if code := confirm(token); code != http.StatusConflict {
+		t.Errorf("confirming an email taken meanwhile should be rejected. got %d", code)
+	}
+	if alice.Email != "carol@example.com" {
+		t.Errorf("rejected confirmation should not change the email. got %s", alice.Email)
+	}
+}
@@ -0,0 +1,34 @@
+package endpoint
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// requestLocale returns the language most preferred by the Accept-Language header of the request, eg. fr-CA,
+// empty if there is none. The emails sent to the user making the request are translated to it when a translation exists.
+func requestLocale(r *http.Request) string {
+	best, bestQuality := "", 0.0
+	for _, language := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		parts := strings.Split(language, ";")
+		tag := strings.TrimSpace(parts[0])
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range parts[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				parsed, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64)
+				if err != nil {
+					parsed = 0
+				}
+				quality = parsed
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = tag, quality
+		}
+	}
+	return best
+}
@@ -0,0 +1,24 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLocale(t *testing.T) {
+	for header, expect := range map[string]string{
+		"":                             "",
+		"fr-CA":                        "fr-CA",
+		"fr-CA, fr;q=0.9, en;q=0.8":    "fr-CA",
+		"en;q=0.5, de-AT;q=0.9, *":     "de-AT",
+		"*, ja;q=0.3":                  "ja",
+		"nl;q=0, es;q=bogus, it;q=0.1": "it",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/recover", nil)
+		req.Header.Set("Accept-Language", header)
+		if locale := requestLocale(req); locale != expect {
+			t.Errorf("expect locale %q of %q. got %q", expect, header, locale)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	emailValidationLog = log.WithField("go", "EmailValidation")
+
+	// MXResolver looks up the MX records of the email domains when "auth.email.mxcheck" is enabled
+	MXResolver MXLookup = net.DefaultResolver
+)
+
+// MXLookup is implemented by net.Resolver
+type MXLookup interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// identifierPattern compiles the regular expression of the configuration key to match a whole identifier,
+// nil if the key is empty.
+func identifierPattern(key string) (*regexp.Regexp, error) {
+	pattern := config.Get(key)
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid regular expression. got %s", key, err.Error())
+	}
+	return compiled, nil
+}
+
+// ValidateIdentifierPatterns checks the "auth.username.pattern" and "auth.email.allowpattern" are valid
+// regular expressions, so an invalid one fails the startup instead of every user creation.
+func ValidateIdentifierPatterns() error {
+	for _, key := range []string{"auth.username.pattern", "auth.email.allowpattern"} {
+		if _, err := identifierPattern(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NormalizeEmail returns the email as it is stored and looked up. With "auth.email.caseinsensitive" it is lower cased and
+// with "auth.email.stripplus" the plus addressing tag, from the "+" in the username to the "@", is removed,
+// so the variations of an address belong to the same user.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	if config.GetBoolean("auth.email.caseinsensitive") {
+		email = strings.ToLower(email)
+	}
+	if config.GetBoolean("auth.email.stripplus") {
+		at := strings.LastIndex(email, "@")
+		if plus := strings.Index(email, "+"); plus > 0 && plus < at {
+			email = email[:plus] + email[at:]
+		}
+	}
+	return email
+}
+
+// getUserByEmail looks the user up by the normalized email and, if none is found, by the email as given, so a user
+// stored before "auth.email.caseinsensitive" or "auth.email.stripplus" was enabled is still found by their stored email.
+func getUserByEmail(ctx context.Context, email string) (*connector.User, error) {
+	normalized := NormalizeEmail(email)
+	user, err := UserRepo.GetUserByEmail(ctx, normalized)
+	if err != nil || user != nil {
+		return user, err
+	}
+	if exact := strings.TrimSpace(email); exact != normalized {
+		return UserRepo.GetUserByEmail(ctx, exact)
+	}
+	return nil, nil
+}
+
+// ValidateEmailAddress checks the email is a bare RFC 5322 address in its canonical form, so without display name
+// nor quoted local part, its username, the local part, matches the "auth.username.pattern", the whole email matches
+// the "auth.email.allowpattern", its domain is not in the "auth.email.denylist"
+// and, if "auth.email.mxcheck" is enabled, the domain has an MX record to receive mail.
+// When the MX lookup fails for a temporary reason or times out, the address is accepted.
+func ValidateEmailAddress(ctx context.Context, email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(address.Name) > 0 {
+		return fmt.Errorf("email %q is not a valid address", email)
+	}
+	username := email[:strings.LastIndex(email, "@")]
+	usernamePattern, err := identifierPattern("auth.username.pattern")
+	if err != nil {
+		return err
+	}
+	if usernamePattern != nil && !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username %q is not allowed. it must match %s", username, config.Get("auth.username.pattern"))
+	}
+	emailPattern, err := identifierPattern("auth.email.allowpattern")
+	if err != nil {
+		return err
+	}
+	if emailPattern != nil && !emailPattern.MatchString(email) {
+		return fmt.Errorf("email %q is not allowed. it must match %s", email, config.Get("auth.email.allowpattern"))
+	}
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	for _, denied := range config.GetList("auth.email.denylist") {
+		denied = strings.ToLower(denied)
+		if domain == denied || strings.HasSuffix(domain, "."+denied) {
+			return fmt.Errorf("email domain %s is not allowed", domain)
+		}
+	}
+	if !config.GetBoolean("auth.email.mxcheck") {
+		return nil
+	}
+	timeout, err := jiffy.DurationOf(config.Get("auth.email.mxcheck.timeout"))
+	if err != nil {
+		return fmt.Errorf("invalid auth.email.mxcheck.timeout %s. got %s", config.Get("auth.email.mxcheck.timeout"), err.Error())
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	records, err := MXResolver.LookupMX(lookupCtx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if (errors.As(err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary)) || lookupCtx.Err() != nil {
+			emailValidationLog.WithField("func", "ValidateEmailAddress").Warnf("MX lookup of %s did not complete, email is accepted. got %s", domain, err.Error())
+			return nil
+		}
+		return fmt.Errorf("email domain %s can not receive mail", domain)
+	}
+	for _, record := range records {
+		// a single "." host is the null MX of domains that explicitly accept no mail
+		if record.Host != "." && len(record.Host) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("email domain %s can not receive mail", domain)
+}
@@ -0,0 +1,234 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeMXResolver struct {
+	records map[string][]*net.MX
+}
+
+func (resolver *fakeMXResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	switch name {
+	case "slow.example":
+		<-ctx.Done()
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	if records, ok := resolver.records[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestValidateEmailAddressSyntax(t *testing.T) {
+	ctx := context.Background()
+	for _, valid := range []string{"user@acme.com", "first.last+tag@mail.acme.co.id", "o'brien@acme.com"} {
+		if err := ValidateEmailAddress(ctx, valid); err != nil {
+			t.Errorf("%s should be valid. got %s", valid, err.Error())
+		}
+	}
+	for _, malformed := range []string{"", "user", "user@", "@acme.com", "user@@acme.com", "user@acme..com", "John <user@acme.com>", " user@acme.com", "user acme@acme.com", `"john doe"@acme.com`} {
+		if err := ValidateEmailAddress(ctx, malformed); err == nil {
+			t.Errorf("%q should be malformed", malformed)
+		}
+	}
+}
+
+func TestValidateEmailAddressDenylist(t *testing.T) {
+	config.Set("auth.email.denylist", "mailinator.com, tempmail.io")
+	defer config.Set("auth.email.denylist", "")
+	ctx := context.Background()
+	for _, denied := range []string{"user@mailinator.com", "user@MAILINATOR.com", "user@eu.tempmail.io"} {
+		if err := ValidateEmailAddress(ctx, denied); err == nil {
+			t.Errorf("%s should be denied", denied)
+		}
+	}
+	if err := ValidateEmailAddress(ctx, "user@notmailinator.com"); err != nil {
+		t.Errorf("user@notmailinator.com should be allowed. got %s", err.Error())
+	}
+}
+
+func TestValidateEmailAddressPatterns(t *testing.T) {
+	config.Set("auth.username.pattern", "[a-z][a-z0-9.]*")
+	config.Set("auth.email.allowpattern", `.+@(acme\.com|acme\.co\.id)`)
+	defer config.Set("auth.username.pattern", "")
+	defer config.Set("auth.email.allowpattern", "")
+	ctx := context.Background()
+	for _, allowed := range []string{"john@acme.com", "first.last@acme.co.id", "j0hn@acme.com"} {
+		if err := ValidateEmailAddress(ctx, allowed); err != nil {
+			t.Errorf("%s should be allowed. got %s", allowed, err.Error())
+		}
+	}
+	for _, rejected := range []string{"John@acme.com", "0john@acme.com", "john+tag@acme.com", "john@acme.com.evil.io", "john@other.com"} {
+		if err := ValidateEmailAddress(ctx, rejected); err == nil {
+			t.Errorf("%s should be rejected", rejected)
+		}
+	}
+}
+
+func TestValidateIdentifierPatterns(t *testing.T) {
+	defer config.Set("auth.username.pattern", "")
+	config.Set("auth.username.pattern", "[a-z]+")
+	if err := ValidateIdentifierPatterns(); err != nil {
+		t.Errorf("valid pattern should pass. got %s", err.Error())
+	}
+	config.Set("auth.username.pattern", "[a-z")
+	if err := ValidateIdentifierPatterns(); err == nil {
+		t.Errorf("invalid pattern should fail")
+	}
+	if err := ValidateEmailAddress(context.Background(), "john@acme.com"); err == nil {
+		t.Errorf("email should be rejected while the pattern is invalid")
+	}
+}
+
+func TestValidateEmailAddressMXCheck(t *testing.T) {
+	config.Set("auth.email.mxcheck", "true")
+	config.Set("auth.email.mxcheck.timeout", "50 milliseconds")
+	defer config.Set("auth.email.mxcheck", "false")
+	defer config.Set("auth.email.mxcheck.timeout", "2 seconds")
+	resolver := MXResolver
+	defer func() {
+		MXResolver = resolver
+	}()
+	MXResolver = &fakeMXResolver{records: map[string][]*net.MX{
+		"acme.com":    {{Host: "mx.acme.com.", Pref: 10}},
+		"nomail.com":  {{Host: ".", Pref: 0}},
+		"nomx.com":    {},
+		"example.org": {{Host: "mx2.example.org.", Pref: 20}, {Host: "mx1.example.org.", Pref: 10}},
+	}}
+	ctx := context.Background()
+	for _, valid := range []string{"user@acme.com", "user@example.org", "user@slow.example"} {
+		if err := ValidateEmailAddress(ctx, valid); err != nil {
+			t.Errorf("%s should pass the MX check. got %s", valid, err.Error())
+		}
+	}
+	for _, noMX := range []string{"user@nomx.com", "user@nomail.com", "user@unknown.invalid"} {
+		if err := ValidateEmailAddress(ctx, noMX); err == nil {
+			t.Errorf("%s should fail the MX check", noMX)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	defer config.Set("auth.email.caseinsensitive", "false")
+	defer config.Set("auth.email.stripplus", "false")
+	testData := []struct {
+		caseInsensitive string
+		stripPlus       string
+		email           string
+		normalized      string
+	}{
+		{"false", "false", " User+News@Acme.com ", "User+News@Acme.com"},
+		{"true", "false", "User+News@Acme.com", "user+news@acme.com"},
+		{"false", "true", "User+News@Acme.com", "User@Acme.com"},
+		{"true", "true", "User+News+Daily@Acme.com", "user@acme.com"},
+		{"true", "true", "+news@acme.com", "+news@acme.com"},
+	}
+	for _, td := range testData {
+		config.Set("auth.email.caseinsensitive", td.caseInsensitive)
+		config.Set("auth.email.stripplus", td.stripPlus)
+		if normalized := NormalizeEmail(td.email); normalized != td.normalized {
+			t.Errorf("caseinsensitive %s stripplus %s : %q should normalize to %q. got %q", td.caseInsensitive, td.stripPlus, td.email, td.normalized, normalized)
+		}
+	}
+}
+
+// uniqueEmailUserRepo stores the users by their exact email, like a case sensitive unique column
+type uniqueEmailUserRepo struct {
+	regionUserRepo
+	users map[string]*connector.User
+}
+
+func (repo *uniqueEmailUserRepo) GetUserByEmail(ctx context.Context, email string) (*connector.User, error) {
+	return repo.users[email], nil
+}
+
+func (repo *uniqueEmailUserRepo) CreateUserRecord(ctx context.Context, email, passphrase string) (*connector.User, error) {
+	if _, exist := repo.users[email]; exist {
+		return nil, fmt.Errorf("email %s is already used", email)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(passphrase), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+	user := &connector.User{RecID: fmt.Sprintf("u%d", len(repo.users)+1), Email: email, Enabled: true, HashedPassphrase: string(hashed)}
+	repo.users[email] = user
+	return user, nil
+}
+
+func TestCaseInsensitiveEmail(t *testing.T) {
+	config.Set("auth.email.caseinsensitive", "true")
+	config.Set("auth.email.stripplus", "true")
+	defer config.Set("auth.email.caseinsensitive", "false")
+	defer config.Set("auth.email.stripplus", "false")
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, 7*24*time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	repo := &uniqueEmailUserRepo{regionUserRepo: regionUserRepo{deactivationUserRepo{active: true}}, users: make(map[string]*connector.User)}
+	UserRepo = repo
+	RoleRepo = &regionRoleRepo{}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	stop := consumeMails(nil)
+	defer stop()
+
+	createUser := func(email string) int {
+		body := fmt.Sprintf(`{"email":"%s","passphrase":"correct horse battery staple"}`, email)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user", apiPrefix), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@acme.com",
+			Audience: []string{config.Get("hansip.admin")},
+		}))
+		recorder := httptest.NewRecorder()
+		CreateNewUser(recorder, req)
+		return recorder.Code
+	}
+	login := func(email string) int {
+		body := fmt.Sprintf(`{"email":"%s","passphrase":"correct horse battery staple"}`, email)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		return recorder.Code
+	}
+
+	if code := createUser("User+News@Acme.com"); code != http.StatusOK {
+		t.Fatalf("expect the user created. got %d", code)
+	}
+	if _, stored := repo.users["user@acme.com"]; !stored || len(repo.users) != 1 {
+		t.Errorf("expect the normalized email stored. got %v", repo.users)
+	}
+	if code := createUser("USER@acme.com"); code != http.StatusBadRequest {
+		t.Errorf("differently cased email should collide with the existing user. got %d", code)
+	}
+	if len(repo.users) != 1 {
+		t.Errorf("expect no other user created. got %v", repo.users)
+	}
+	for _, email := range []string{"user@acme.com", "USER@ACME.COM", "User+Shop@Acme.com"} {
+		if code := login(email); code != http.StatusOK {
+			t.Errorf("login as %s should succeed. got %d", email, code)
+		}
+	}
+
+	legacy, _ := repo.CreateUserRecord(context.Background(), "Legacy+Old@Acme.com", "correct horse battery staple")
+	if code := login("Legacy+Old@Acme.com"); code != http.StatusOK {
+		t.Errorf("user stored before the normalization should log in with the stored email. got %d", code)
+	}
+	if user, err := getUserByEmail(context.Background(), " Legacy+Old@Acme.com "); err != nil || user != legacy {
+		t.Errorf("expect the stored email found. got %v %v", user, err)
+	}
+}
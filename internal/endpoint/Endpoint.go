@@ -1,6 +1,7 @@
 package endpoint
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -27,6 +28,9 @@ const (
 	PatchMethod = 0b00100000
 	// DeleteMethod flag
 	DeleteMethod = 0b01000000
+
+	// anonymousSubject is the subject of the request to a public endpoint without a valid token
+	anonymousSubject = "anonymous"
 )
 
 var (
@@ -76,6 +80,10 @@ func (e *Endpoint) isPathCanAccess(path string) bool {
 }
 
 func getHToken(r *http.Request) (*helper.HansipToken, error) {
+	// A service authenticated by its client certificate needs no bearer token.
+	if hToken := clientCertificateToken(r); hToken != nil {
+		return hToken, nil
+	}
 	// If it need validation, Check the Authorization header
 	authHeader := r.Header.Get("Authorization")
 	if len(authHeader) == 0 {
@@ -88,26 +96,35 @@ func getHToken(r *http.Request) (*helper.HansipToken, error) {
 	// Get the token, validate and parse it.
 	tok := strings.TrimSpace(authHeader[7:])
 	hToken, err := TokenFactory.ReadToken(tok)
+	if err != nil && errors.Is(err, helper.ErrTokenExpired) && hToken != nil && TokenFactory.IsAcceptedIssuer(hToken.Issuer) {
+		tokenType, _ := hToken.Additional["type"].(string)
+		return nil, &hansiperrors.ErrTokenExpired{Subject: hToken.Subject, TokenType: tokenType}
+	}
 	if err != nil {
 		return nil, &hansiperrors.ErrTokenInvalid{Wrapped: err}
 	}
-	// Makesure the issuer is the same
-	if hToken.Issuer != config.Get("token.issuer") {
+	// Makesure the issuer is accepted
+	if !TokenFactory.IsAcceptedIssuer(hToken.Issuer) {
 		return nil, &hansiperrors.ErrInvalidIssuer{InvalidIssuer: hToken.Issuer}
 	}
+	// A token issued for a purpose, eg. confirming an email change, is not a bearer token.
+	// Only the 2FA enrollment token is, at the enrollment endpoints.
+	if purpose, ok := hToken.Additional["purpose"]; ok && purpose != twoFAEnrollmentPurpose {
+		return nil, &hansiperrors.ErrTokenInvalid{Wrapped: fmt.Errorf("token issued for %v can not be used as bearer token", purpose)}
+	}
 	return hToken, err
 }
 
-// AccessValid header tokens
-func (e *Endpoint) AccessValid(r *http.Request, TokenFactory helper.TokenFactory) (*helper.HansipToken, error) {
+// AccessValid check the request against the endpoint using the token read from its header by getHToken,
+// the token is read once per request and checked against every endpoint.
+func (e *Endpoint) AccessValid(r *http.Request, hTok *helper.HansipToken, hTokErr error) (*helper.HansipToken, error) {
 	path := r.URL.Path
 	method := GetMethodFlag(r.Method)
-	hTok, hTokErr := getHToken(r)
 	if e.IsPublic {
 		if hTokErr != nil {
 			return &helper.HansipToken{
 				Issuer:    config.Get("token.issuer"),
-				Subject:   "anonymous",
+				Subject:   anonymousSubject,
 				Audiences: []string{"anonymous@*"},
 				Expire:    time.Now().Add(24 * 360 * time.Hour),
 				NotBefore: time.Time{},
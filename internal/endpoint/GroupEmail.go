@@ -0,0 +1,265 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// GroupEmailJobCapacity is the number of group email jobs kept for tracking
+	GroupEmailJobCapacity = 1000
+
+	// groupEmailPageSize is the number of group members listed at a time while queuing the emails
+	groupEmailPageSize = 100
+
+	// GroupEmailQueuing the members are still being listed and their emails queued
+	GroupEmailQueuing = "queuing"
+	// GroupEmailSending all emails are queued and some are not sent yet
+	GroupEmailSending = "sending"
+	// GroupEmailDone every queued email is sent, failed or dropped
+	GroupEmailDone = "done"
+	// GroupEmailAborted listing the members failed, the emails queued before are still sent
+	GroupEmailAborted = "aborted"
+)
+
+var (
+	groupEmailLog = log.WithField("go", "GroupEmail")
+
+	// GroupEmailJobs keeps the group email jobs for "mailer.group.job.ttl" so their delivery can be tracked
+	GroupEmailJobs = cache.NewInMemoryCache(GroupEmailJobCapacity, 24*60*60, false)
+)
+
+// GroupEmailRequest hold model for emailing all members of a group
+type GroupEmailRequest struct {
+	// Template is one of the "mailer.group.templates"
+	Template string `json:"template" xml:"template"`
+	// Data is given to the template as .Data, eg. the subject and message of an ANNOUNCEMENT
+	Data map[string]string `json:"data" xml:"data"`
+}
+
+// GroupEmailJob tracks the emails sent to the members of a group
+type GroupEmailJob struct {
+	mutex      sync.Mutex
+	ID         string    `json:"job_id" xml:"job_id"`
+	GroupRecID string    `json:"group_rec_id" xml:"group_rec_id"`
+	Template   string    `json:"template" xml:"template"`
+	Status     string    `json:"status" xml:"status"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+	// Members is the number of group members listed so far
+	Members int `json:"members" xml:"members"`
+	// Skipped is the number of members not emailed because they are disabled, suspended or deactivated
+	Skipped int `json:"skipped" xml:"skipped"`
+	Queued  int `json:"queued" xml:"queued"`
+	Sent    int `json:"sent" xml:"sent"`
+	Failed  int `json:"failed" xml:"failed"`
+	// Dropped is the number of emails not sent, eg. the member exceeds "mailer.ratelimit.perhour"
+	Dropped int    `json:"dropped" xml:"dropped"`
+	Error   string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// snapshot returns a copy of the job safe to serialize while its emails are sent.
+func (job *GroupEmailJob) snapshot() *GroupEmailJob {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	ret := &GroupEmailJob{ID: job.ID, GroupRecID: job.GroupRecID, Template: job.Template, Status: job.Status, CreatedAt: job.CreatedAt,
+		Members: job.Members, Skipped: job.Skipped, Queued: job.Queued, Sent: job.Sent, Failed: job.Failed, Dropped: job.Dropped, Error: job.Error}
+	if ret.Status == GroupEmailSending && ret.Sent+ret.Failed+ret.Dropped == ret.Queued {
+		ret.Status = GroupEmailDone
+	}
+	return ret
+}
+
+// delivered counts the outcome of an email of the job.
+func (job *GroupEmailJob) delivered(outcome string) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	switch outcome {
+	case mailer.DeliverySent:
+		job.Sent++
+	case mailer.DeliveryFailed:
+		job.Failed++
+	default:
+		job.Dropped++
+	}
+}
+
+// groupMailData is the data given to the template of a group email, the member and its branding, the group and the request data.
+type groupMailData struct {
+	*brandedUser
+	Group *connector.Group
+	Data  map[string]string
+}
+
+// isGroupEmailTemplate check whether the template is listed in "mailer.group.templates".
+func isGroupEmailTemplate(template string) bool {
+	for _, allowed := range config.GetList("mailer.group.templates") {
+		if allowed == template {
+			_, ok := mailer.Templates[template]
+			return ok
+		}
+	}
+	return false
+}
+
+// distributeGroupEmail queues an email to every enabled, not suspended and not deactivated member of the group.
+// The members are listed a page at a time, and mailer.Send waits while the mailer queue is full,
+// so a large group is never held in memory at once.
+func distributeGroupEmail(ctx context.Context, job *GroupEmailJob, group *connector.Group, req *GroupEmailRequest) {
+	fLog := groupEmailLog.WithField("func", "distributeGroupEmail").WithField("RequestID", ctx.Value(constants.RequestID)).WithField("job", job.ID)
+	for pageNo := uint(1); ; pageNo++ {
+		members, page, err := UserGroupRepo.ListUserGroupByGroup(ctx, group, &helper.PageRequest{
+			No:       pageNo,
+			PageSize: groupEmailPageSize,
+			OrderBy:  "EMAIL",
+			Sort:     "ASC",
+		})
+		if err != nil {
+			fLog.Errorf("UserGroupRepo.ListUserGroupByGroup got %s", err.Error())
+			job.mutex.Lock()
+			job.Status = GroupEmailAborted
+			job.Error = err.Error()
+			job.mutex.Unlock()
+			return
+		}
+		for _, member := range members {
+			active := member.Enabled && !member.Suspended
+			if active {
+				// a member deactivated by its inactivity is skipped, the same as it can not login
+				active, err = UserRepo.IsUserActive(ctx, member)
+				if err != nil {
+					fLog.Errorf("UserRepo.IsUserActive got %s", err.Error())
+					active = false
+				}
+			}
+			job.mutex.Lock()
+			job.Members++
+			if active {
+				job.Queued++
+			} else {
+				job.Skipped++
+			}
+			job.mutex.Unlock()
+			if !active {
+				continue
+			}
+			mailer.Send(ctx, &mailer.Email{
+				From:      config.Get("mailer.from"),
+				FromName:  config.Get("mailer.from.name"),
+				To:        []string{member.Email},
+				Template:  req.Template,
+				Data:      &groupMailData{brandedUser: &brandedUser{User: member, Branding: userBranding(ctx, member)}, Group: group, Data: req.Data},
+				Delivered: job.delivered,
+			})
+		}
+		if len(members) == 0 || page == nil || page.IsLast {
+			break
+		}
+	}
+	job.mutex.Lock()
+	job.Status = GroupEmailSending
+	job.mutex.Unlock()
+	fLog.Infof("queued %d emails to the members of group %s", job.snapshot().Queued, group.RecID)
+}
+
+// SendGroupEmail serving request to email all active members of a group with one of the "mailer.group.templates".
+// The emails are queued in the background, the response carries the job to track their delivery with.
+func SendGroupEmail(w http.ResponseWriter, r *http.Request) {
+	fLog := groupEmailLog.WithField("func", "SendGroupEmail").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}/emails", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	group, err := GroupRepo.GetGroupByRecID(r.Context(), params["groupRecId"])
+	if err != nil {
+		fLog.Errorf("GroupRepo.GetGroupByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if group == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s not exist", params["groupRecId"]), nil, nil)
+		return
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
+		return
+	}
+
+	req := &GroupEmailRequest{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
+	if err != nil {
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	if !isGroupEmailTemplate(req.Template) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("Template %q can not be sent to a group, use one of %s", req.Template, config.Get("mailer.group.templates")), nil, nil)
+		return
+	}
+
+	job := &GroupEmailJob{
+		ID:         connector.NewRecID(),
+		GroupRecID: group.RecID,
+		Template:   req.Template,
+		Status:     GroupEmailQueuing,
+		CreatedAt:  time.Now(),
+	}
+	GroupEmailJobs.Store(job.ID, job)
+	// the emails are queued after the request is responded, only its request id is carried on
+	ctx := context.WithValue(context.Background(), constants.RequestID, r.Context().Value(constants.RequestID))
+	go distributeGroupEmail(ctx, job, group, req)
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusAccepted, "Group email is being queued", nil, job.snapshot())
+}
+
+// GetGroupEmailJob serving request to track the delivery of the emails sent to a group.
+func GetGroupEmailJob(w http.ResponseWriter, r *http.Request) {
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}/email/{jobId}", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	ok, cached := GroupEmailJobs.Fetch(params["jobId"])
+	if !ok || cached.(*GroupEmailJob).GroupRecID != params["groupRecId"] {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Email job %s of group %s not exist", params["jobId"], params["groupRecId"]), nil, nil)
+		return
+	}
+	group, err := GroupRepo.GetGroupByRecID(r.Context(), params["groupRecId"])
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if group == nil || !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Group email job", nil, cached.(*GroupEmailJob).snapshot())
+}
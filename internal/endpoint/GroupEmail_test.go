@@ -0,0 +1,175 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// pagedMembersRepo records the pages of group members listed.
+type pagedMembersRepo struct {
+	connector.UserGroupRepository
+	mutex     sync.Mutex
+	pageSizes []uint
+}
+
+func (repo *pagedMembersRepo) ListUserGroupByGroup(ctx context.Context, group *connector.Group, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	repo.mutex.Lock()
+	repo.pageSizes = append(repo.pageSizes, request.PageSize)
+	repo.mutex.Unlock()
+	return repo.UserGroupRepository.ListUserGroupByGroup(ctx, group, request)
+}
+
+// consumeMails takes the queued emails off the mailer queue into sent, or drops them when sent is nil, until stop is called.
+// stop returns once the consumer quit, so it can not take the emails queued by the next test.
+func consumeMails(sent chan<- *mailer.Email) (stop func()) {
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case mail := <-mailer.MailerChannel:
+				if sent == nil {
+					continue
+				}
+				select {
+				case sent <- mail:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func groupEmailRequest(method, path, admin, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@test.com",
+		Audience: []string{admin},
+	}))
+	recorder := httptest.NewRecorder()
+	if method == http.MethodPost {
+		SendGroupEmail(recorder, req)
+	} else {
+		GetGroupEmailJob(recorder, req)
+	}
+	return recorder
+}
+
+func groupEmailJobOf(t *testing.T, recorder *httptest.ResponseRecorder) *GroupEmailJob {
+	response := &struct {
+		Data *GroupEmailJob `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Data
+}
+
+func TestSendGroupEmail(t *testing.T) {
+	ctx := context.Background()
+	dir := seedDirectory(ctx)
+	dir.use()
+	members := &pagedMembersRepo{UserGroupRepository: dir}
+	UserGroupRepo = members
+	everyone, _ := dir.CreateGroup(ctx, "everyone", "acme", "Everyone at Acme")
+	// 250 members, every 10th is disabled, every 25th suspended and every 40th deactivated
+	active := make([]string, 0)
+	for i := 0; i < 250; i++ {
+		user, _ := dir.CreateUserRecord(ctx, fmt.Sprintf("member%03d@acme.com", i), "secret")
+		user.Enabled = i%10 != 0
+		user.Suspended = i%25 == 0
+		dir.inactive[user.RecID] = i%40 == 1
+		dir.CreateUserGroup(ctx, user, everyone)
+		if user.Enabled && !user.Suspended && !dir.inactive[user.RecID] {
+			active = append(active, user.Email)
+		}
+	}
+
+	sender := &capturingSender{bodies: make(map[string]string)}
+	mailer.Sender = sender
+	oldLimiter := mailer.Limiter
+	mailer.Limiter = mailer.NewRateLimiter(1, time.Hour)
+	defer func() {
+		mailer.Sender = nil
+		mailer.Limiter = oldLimiter
+	}()
+	// the first active member got an email already this hour
+	mailer.Limiter.Allow(active[0])
+	go mailer.Start()
+
+	path := fmt.Sprintf("%s/management/group/%s/emails", apiPrefix, everyone.RecID)
+	body := `{"template":"ANNOUNCEMENT","data":{"subject":"Office closed","message":"The office is closed on Friday."}}`
+	if recorder := groupEmailRequest(http.MethodPost, path, "admin@other", body); recorder.Code != http.StatusForbidden {
+		t.Errorf("admin of another tenant should not email the group. got %d", recorder.Code)
+	}
+	if recorder := groupEmailRequest(http.MethodPost, path, "admin@acme", `{"template":"EMAIL_VERIFY"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("a template not in mailer.group.templates should be rejected. got %d", recorder.Code)
+	}
+	recorder := groupEmailRequest(http.MethodPost, path, "admin@acme", body)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("tenant admin should email the group. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	job := groupEmailJobOf(t, recorder)
+	if len(job.ID) == 0 || job.GroupRecID != everyone.RecID {
+		t.Fatalf("expect a job of the group. got %+v", job)
+	}
+
+	jobPath := fmt.Sprintf("%s/management/group/%s/email/%s", apiPrefix, everyone.RecID, job.ID)
+	deadline := time.Now().Add(10 * time.Second)
+	for job.Status != GroupEmailDone && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		recorder = groupEmailRequest(http.MethodGet, jobPath, "admin@acme", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect the job tracked. got %d", recorder.Code)
+		}
+		job = groupEmailJobOf(t, recorder)
+	}
+	mailer.Stop()
+	if job.Status != GroupEmailDone || job.Members != 250 || job.Skipped != 250-len(active) || job.Queued != len(active) || job.Sent != len(active)-1 || job.Dropped != 1 || job.Failed != 0 {
+		t.Errorf("expect every active member emailed but the rate limited one. got %+v", job)
+	}
+	for _, email := range active[1:] {
+		if mail := sender.bodies[email]; !strings.HasPrefix(mail, "Office closed\n") || !strings.Contains(mail, "Dear "+email) || !strings.Contains(mail, "The office is closed on Friday.") {
+			t.Errorf("expect the announcement sent to %s. got %q", email, mail)
+		}
+	}
+	if len(sender.bodies) != len(active)-1 {
+		t.Errorf("expect %d emails sent. got %d", len(active)-1, len(sender.bodies))
+	}
+	if len(members.pageSizes) < 3 {
+		t.Errorf("expect the members listed a page at a time. got %v", members.pageSizes)
+	}
+	for _, pageSize := range members.pageSizes {
+		if pageSize > groupEmailPageSize {
+			t.Errorf("expect pages of at most %d members. got %d", groupEmailPageSize, pageSize)
+		}
+	}
+
+	if recorder := groupEmailRequest(http.MethodGet, jobPath, "admin@other", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("admin of another tenant should not track the job. got %d", recorder.Code)
+	}
+	if recorder := groupEmailRequest(http.MethodGet, fmt.Sprintf("%s/management/group/%s/email/unknown", apiPrefix, everyone.RecID), "admin@acme", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown job should not be found. got %d", recorder.Code)
+	}
+}
@@ -1,12 +1,16 @@
 package endpoint
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 
+	"github.com/hyperjumptech/hansip/internal/connector"
 	"github.com/hyperjumptech/hansip/internal/constants"
 	"github.com/hyperjumptech/hansip/internal/hansipcontext"
 	"github.com/hyperjumptech/hansip/pkg/helper"
@@ -15,8 +19,9 @@ import (
 
 // SimpleGroup hold basic data of group
 type SimpleGroup struct {
-	RecID     string `json:"rec_id"`
-	GroupName string `json:"group_name"`
+	XMLName   xml.Name `json:"-" xml:"group"`
+	RecID     string   `json:"rec_id" xml:"rec_id"`
+	GroupName string   `json:"group_name" xml:"group_name"`
 }
 
 var (
@@ -49,7 +54,7 @@ func SetGroupUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -82,6 +87,10 @@ func SetGroupUsers(w http.ResponseWriter, r *http.Request) {
 			fLog.Warnf("UserRepo.GetUserByRecID got %s, this user %s will not be added to group %s user", err.Error(), userID, group.RecID)
 		} else if user == nil {
 			fLog.Warnf("this user %s not exist and will not be added to group %s user", userID, group.RecID)
+		} else if allowed, err := canManageUser(r.Context(), authCtx, user, true); err != nil || !allowed {
+			fLog.Warnf("This user %s is of another tenant and will not be added to group %s user", userID, group.RecID)
+		} else if err := validateTenantEmailDomains(r.Context(), []string{group.GroupDomain}, user.Email); err != nil {
+			fLog.Warnf("validateTenantEmailDomains got %s, this user %s will not be added to group %s user", err.Error(), userID, group.RecID)
 		} else {
 			_, err := UserGroupRepo.CreateUserGroup(r.Context(), user, group)
 			if err != nil {
@@ -121,7 +130,7 @@ func DeleteGroupUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -161,7 +170,7 @@ func SetGroupRoles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -191,7 +200,7 @@ func SetGroupRoles(w http.ResponseWriter, r *http.Request) {
 	for _, roleID := range roleIds {
 		role, err := RoleRepo.GetRoleByRecID(r.Context(), roleID)
 		if err != nil {
-			fLog.Error("RoleRepo.GetRoleByRecID got %s, this role %s will not be added to group %s role", err.Error(), roleID, group.RecID)
+			fLog.Errorf("RoleRepo.GetRoleByRecID got %s, this role %s will not be added to group %s role", err.Error(), roleID, group.RecID)
 		} else if role == nil {
 			fLog.Warnf("This role %s is not exist and will not be added to group %s role", roleID, group.RecID)
 		} else {
@@ -232,7 +241,7 @@ func DeleteGroupRoles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -273,14 +282,14 @@ func ListAllGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(tenant.Domain) {
+	if !authorizeAdminOfDomain(r, authCtx, tenant.Domain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -298,9 +307,9 @@ func ListAllGroup(w http.ResponseWriter, r *http.Request) {
 
 // CreateGroupRequest hold model for Create new Group.
 type CreateGroupRequest struct {
-	GroupName   string `json:"group_name"`
-	GroupDomain string `json:"group_domain"`
-	Description string `json:"description"`
+	GroupName   string `json:"group_name" xml:"group_name"`
+	GroupDomain string `json:"group_domain" xml:"group_domain"`
+	Description string `json:"description" xml:"description"`
 }
 
 // CreateNewGroup serving request to create new Group
@@ -320,9 +329,9 @@ func CreateNewGroup(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -344,7 +353,7 @@ func CreateNewGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(req.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, req.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create group with the specified domain", nil, nil)
 		return
 	}
@@ -380,12 +389,12 @@ func GetGroupDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if group == nil {
-		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %d is not exist", params["groupRecId"]), nil, nil)
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s is not exist", params["groupRecId"]), nil, nil)
 		return
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -415,9 +424,9 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -429,12 +438,12 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if group == nil {
-		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %d is not exist", params["groupRecId"]), nil, nil)
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s is not exist", params["groupRecId"]), nil, nil)
 		return
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !(authCtx.IsAdminOfDomain(group.GroupDomain) && authCtx.IsAdminOfDomain(req.GroupDomain)) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain, req.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, fmt.Sprintf("forbidden. you are not admin of %s and %s domain", group.GroupDomain, req.GroupDomain), nil, nil)
 		return
 	}
@@ -465,7 +474,8 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// DeleteGroup serving request to delete a group
+// DeleteGroup serving request to delete a group. The "cascade" query parameter tells what happens to the group's users,
+// "block" (the default) refuses while the group has users, "detach" removes them, "reassign" moves them to the "reassign_to" group
 func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	fLog := groupMgmtLog.WithField("func", "DeleteGroup").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
 
@@ -475,6 +485,11 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cascade, ok := deleteCascade(w, r)
+	if !ok {
+		return
+	}
+
 	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}", apiPrefix), r.URL.Path)
 	if err != nil {
 		panic(err)
@@ -486,18 +501,30 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if group == nil {
-		fLog.Errorf(err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s not exist", params["groupRecId"]), nil, nil)
 		return
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
 
-	GroupRepo.DeleteGroup(r.Context(), group)
+	status, message, err := cascadeGroupDelete(r.Context(), authCtx, group, cascade, r.URL.Query().Get("reassign_to"))
+	if err != nil {
+		fLog.Errorf("cascadeGroupDelete got %s", err.Error())
+	}
+	if status != 0 {
+		helper.WriteHTTPResponse(r.Context(), w, status, message, nil, nil)
+		return
+	}
+	err = GroupRepo.DeleteGroup(r.Context(), group)
+	if err != nil {
+		fLog.Errorf("GroupRepo.DeleteGroup got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Group deleted", nil, nil)
 }
 
@@ -528,14 +555,14 @@ func ListGroupUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -585,7 +612,7 @@ func CreateGroupUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -596,6 +623,13 @@ func CreateGroupUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, err.Error(), nil, nil)
 		return
 	}
+	if user == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User with recid %s not exist", params["userRecId"]), nil, nil)
+		return
+	}
+	if !applyTenantEmailDomains(w, r, []string{group.GroupDomain}, user.Email) {
+		return
+	}
 	_, err = UserGroupRepo.CreateUserGroup(r.Context(), user, group)
 	if err != nil {
 		fLog.Errorf("UserGroupRepo.CreateUserGroup got %s", err.Error())
@@ -632,7 +666,7 @@ func DeleteGroupUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -647,6 +681,9 @@ func DeleteGroupUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User with recid %s not exist", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, true) {
+		return
+	}
 
 	ug, err := UserGroupRepo.GetUserGroup(r.Context(), user, group)
 	if err != nil {
@@ -695,14 +732,14 @@ func ListGroupRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -749,7 +786,7 @@ func CreateGroupRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -805,7 +842,7 @@ func DeleteGroupRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -840,3 +877,181 @@ func DeleteGroupRole(w http.ResponseWriter, r *http.Request) {
 	}
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "User-Group deleted", nil, nil)
 }
+
+// SetGroupParent serving request to set the parent group of a group
+func SetGroupParent(w http.ResponseWriter, r *http.Request) {
+	fLog := groupMgmtLog.WithField("func", "SetGroupParent").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}/parent/{parentRecId}", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	group, err := GroupRepo.GetGroupByRecID(r.Context(), params["groupRecId"])
+	if err != nil {
+		fLog.Errorf("GroupRepo.GetGroupByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if group == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s not exist", params["groupRecId"]), nil, nil)
+		return
+	}
+
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
+		return
+	}
+
+	parent, err := GroupRepo.GetGroupByRecID(r.Context(), params["parentRecId"])
+	if err != nil {
+		fLog.Errorf("GroupRepo.GetGroupByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if parent == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s not exist", params["parentRecId"]), nil, nil)
+		return
+	}
+
+	if group.GroupDomain != parent.GroupDomain {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "Group can not be nested into group with different domain", nil, nil)
+		return
+	}
+
+	err = GroupRepo.SetParentGroup(r.Context(), group, parent)
+	if err != nil {
+		fLog.Errorf("GroupRepo.SetParentGroup got %s", err.Error())
+		cycleErr := &connector.ErrGroupCycle{}
+		if errors.As(err, &cycleErr) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+			return
+		}
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	revokeGroupTreeMembers(r.Context(), group)
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Group parent set", nil, nil)
+}
+
+// DeleteGroupParent serving request to remove the parent group of a group, making it a root group
+func DeleteGroupParent(w http.ResponseWriter, r *http.Request) {
+	fLog := groupMgmtLog.WithField("func", "DeleteGroupParent").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}/parent", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	group, err := GroupRepo.GetGroupByRecID(r.Context(), params["groupRecId"])
+	if err != nil {
+		fLog.Errorf("GroupRepo.GetGroupByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if group == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s not exist", params["groupRecId"]), nil, nil)
+		return
+	}
+
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
+		return
+	}
+
+	err = GroupRepo.SetParentGroup(r.Context(), group, nil)
+	if err != nil {
+		fLog.Errorf("GroupRepo.SetParentGroup got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	revokeGroupTreeMembers(r.Context(), group)
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Group parent removed", nil, nil)
+}
+
+// ListGroupDescendants serving request to list all sub-groups of a group
+func ListGroupDescendants(w http.ResponseWriter, r *http.Request) {
+	fLog := groupMgmtLog.WithField("func", "ListGroupDescendants").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/group/{groupRecId}/descendants", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	group, err := GroupRepo.GetGroupByRecID(r.Context(), params["groupRecId"])
+	if err != nil {
+		fLog.Errorf("GroupRepo.GetGroupByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if group == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Group with recid %s not exist", params["groupRecId"]), nil, nil)
+		return
+	}
+
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
+		return
+	}
+
+	descendants, err := connector.ListDescendantGroups(r.Context(), GroupRepo, group)
+	if err != nil {
+		fLog.Errorf("connector.ListDescendantGroups got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	sgroups := make([]*SimpleGroup, len(descendants))
+	for k, v := range descendants {
+		sgroups[k] = &SimpleGroup{
+			RecID:     v.RecID,
+			GroupName: v.GroupName,
+		}
+	}
+	ret := make(map[string]interface{})
+	ret["groups"] = sgroups
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "List of all sub-groups", nil, ret)
+}
+
+// revokeGroupTreeMembers revokes the token of every member of the group and its sub-groups,
+// as their effective roles might have changed.
+func revokeGroupTreeMembers(ctx context.Context, group *connector.Group) {
+	fLog := groupMgmtLog.WithField("func", "revokeGroupTreeMembers").WithField("RequestID", ctx.Value(constants.RequestID))
+	descendants, err := connector.ListDescendantGroups(ctx, GroupRepo, group)
+	if err != nil {
+		fLog.Errorf("connector.ListDescendantGroups got %s", err.Error())
+		return
+	}
+	for _, g := range append([]*connector.Group{group}, descendants...) {
+		users, _, err := UserGroupRepo.ListUserGroupByGroup(ctx, g, &helper.PageRequest{
+			No:       1,
+			PageSize: 1000,
+			OrderBy:  "EMAIL",
+			Sort:     "ASC",
+		})
+		if err != nil {
+			fLog.Errorf("UserGroupRepo.ListUserGroupByGroup got %s", err.Error())
+			continue
+		}
+		for _, user := range users {
+			RevocationRepo.Revoke(ctx, user.Email)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package endpoint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the client's idempotency key
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on a response replayed from a previous request with the same idempotency key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the longest idempotency key a client may send
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	idempotencyLog = log.WithField("go", "IdempotencyMiddleware")
+)
+
+// IdempotentCreatePaths are the path patterns of the create endpoints that honor the Idempotency-Key header.
+func IdempotentCreatePaths() []string {
+	return []string{
+		fmt.Sprintf("%s/management/tenant", apiPrefix),
+		fmt.Sprintf("%s/management/user", apiPrefix),
+		fmt.Sprintf("%s/management/group", apiPrefix),
+		fmt.Sprintf("%s/management/role", apiPrefix),
+	}
+}
+
+// IdempotencyMiddleware replays the response of a POST request made with an Idempotency-Key header
+// when the same subject sends the same request with the same key again, within the TTL.
+// Reusing a key for a different request, or while the first request is still in progress, responds 409.
+type IdempotencyMiddleware struct {
+	Repository connector.IdempotencyRepository
+	TTL        time.Duration
+
+	mutex    sync.Mutex
+	inflight map[string]bool
+}
+
+// NewIdempotencyMiddleware create new instance of IdempotencyMiddleware that keeps the responses in the repository for the ttl.
+func NewIdempotencyMiddleware(repository connector.IdempotencyRepository, ttl time.Duration) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		Repository: repository,
+		TTL:        ttl,
+		inflight:   make(map[string]bool),
+	}
+}
+
+// Middleware is the http middleware function, it must be placed after the JwtMiddleware so the key is scoped to the subject.
+func (im *IdempotencyMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || len(idempotencyKey) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fLog := idempotencyLog.WithField("func", "Middleware").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("%s is longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength), nil, nil)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		subject := ""
+		if authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext); ok {
+			subject = authCtx.Subject
+		}
+		key := hashOf(subject, idempotencyKey)
+		requestHash := hashOf(r.Method, r.URL.Path, string(body))
+
+		if !im.acquire(key) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, fmt.Sprintf("A request with the same %s is still in progress", IdempotencyKeyHeader), nil, nil)
+			return
+		}
+		defer im.release(key)
+
+		recorded, err := im.Repository.GetIdempotentResponse(r.Context(), key)
+		if err != nil {
+			fLog.Errorf("Repository.GetIdempotentResponse got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if recorded != nil {
+			if recorded.RequestHash != requestHash {
+				helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, fmt.Sprintf("%s is already used for a different request", IdempotencyKeyHeader), nil, nil)
+				return
+			}
+			fLog.Debugf("replaying the response of %s %s", IdempotencyKeyHeader, idempotencyKey)
+			if len(recorded.ContentType) > 0 {
+				w.Header().Set("Content-Type", recorded.ContentType)
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(recorded.StatusCode)
+			w.Write([]byte(recorded.Body))
+			return
+		}
+
+		buffered := newBufferedResponseWriter()
+		next.ServeHTTP(buffered, r)
+		buffered.writeTo(w, buffered.body.Bytes())
+
+		// server errors are not recorded so the client can retry them with the same key.
+		if buffered.code >= http.StatusInternalServerError {
+			return
+		}
+		err = im.Repository.SaveIdempotentResponse(r.Context(), &connector.IdempotentResponse{
+			Key:         key,
+			RequestHash: requestHash,
+			StatusCode:  buffered.code,
+			ContentType: buffered.Header().Get("Content-Type"),
+			Body:        buffered.body.String(),
+			Expire:      time.Now().Add(im.TTL),
+		})
+		if err != nil {
+			fLog.Errorf("Repository.SaveIdempotentResponse got %s", err.Error())
+		}
+	})
+}
+
+// acquire marks the key as in progress, it returns false if the key is already in progress.
+func (im *IdempotencyMiddleware) acquire(key string) bool {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	if im.inflight[key] {
+		return false
+	}
+	im.inflight[key] = true
+	return true
+}
+
+func (im *IdempotencyMiddleware) release(key string) {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	delete(im.inflight, key)
+}
+
+func hashOf(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
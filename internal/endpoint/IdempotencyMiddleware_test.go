@@ -0,0 +1,80 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type memoryIdempotencyRepo struct {
+	responses map[string]*connector.IdempotentResponse
+}
+
+func (repo *memoryIdempotencyRepo) GetIdempotentResponse(ctx context.Context, key string) (*connector.IdempotentResponse, error) {
+	response, ok := repo.responses[key]
+	if !ok || response.Expire.Before(time.Now()) {
+		return nil, nil
+	}
+	return response, nil
+}
+
+func (repo *memoryIdempotencyRepo) SaveIdempotentResponse(ctx context.Context, response *connector.IdempotentResponse) error {
+	repo.responses[response.Key] = response
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	created := 0
+	handler := NewIdempotencyMiddleware(&memoryIdempotencyRepo{responses: make(map[string]*connector.IdempotentResponse)}, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		created++
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success creating role", nil, fmt.Sprintf("role%d", created))
+	}))
+	post := func(subject, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/management/role", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{Subject: subject}))
+		if len(key) > 0 {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := post("admin@acme.com", "key-1", `{"role_name":"auditor","role_domain":"acme"}`)
+	if first.Code != http.StatusOK || created != 1 {
+		t.Fatalf("first request should be served. got %d, %d created", first.Code, created)
+	}
+	replay := post("admin@acme.com", "key-1", `{"role_name":"auditor","role_domain":"acme"}`)
+	if replay.Code != http.StatusOK || created != 1 {
+		t.Errorf("duplicate key should be replayed without creating again. got %d, %d created", replay.Code, created)
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("replayed body should be the original response. got %s", replay.Body.String())
+	}
+	if replay.Header().Get(IdempotentReplayedHeader) != "true" || !strings.Contains(replay.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("replayed response headers are not set. got %v", replay.Header())
+	}
+
+	conflict := post("admin@acme.com", "key-1", `{"role_name":"operator","role_domain":"acme"}`)
+	if conflict.Code != http.StatusConflict || created != 1 {
+		t.Errorf("key reused with a different body should conflict. got %d, %d created", conflict.Code, created)
+	}
+
+	if code := post("admin@globex.com", "key-1", `{"role_name":"operator","role_domain":"acme"}`).Code; code != http.StatusOK || created != 2 {
+		t.Errorf("key of another subject should be served. got %d, %d created", code, created)
+	}
+	post("admin@acme.com", "", `{"role_name":"auditor","role_domain":"acme"}`)
+	post("admin@acme.com", "", `{"role_name":"auditor","role_domain":"acme"}`)
+	if created != 4 {
+		t.Errorf("requests without key should always be served. got %d created", created)
+	}
+}
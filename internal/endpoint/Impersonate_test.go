@@ -0,0 +1,156 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type impersonateUserRepo struct {
+	connector.UserRepository
+	users    map[string]*connector.User
+	inactive map[string]bool
+}
+
+func (repo *impersonateUserRepo) IsUserActive(ctx context.Context, user *connector.User) (bool, error) {
+	return !repo.inactive[user.RecID], nil
+}
+
+func (repo *impersonateUserRepo) GetUserByRecID(ctx context.Context, recID string) (*connector.User, error) {
+	return repo.users[recID], nil
+}
+
+func (repo *impersonateUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	return []*connector.Role{{RecID: "r1", RoleName: "user", RoleDomain: "app"}}, nil, nil
+}
+
+type fakeAuditRepo struct {
+	connector.AuditRepository
+	audits []*connector.Audit
+	fail   bool
+}
+
+func (repo *fakeAuditRepo) CreateAudit(ctx context.Context, eventType, actor, target, detail string) (*connector.Audit, error) {
+	if repo.fail {
+		return nil, fmt.Errorf("audit table unavailable")
+	}
+	audit := &connector.Audit{EventType: eventType, Actor: actor, Target: target, Detail: detail}
+	repo.audits = append(repo.audits, audit)
+	return audit, nil
+}
+
+func impersonateRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user/u1/impersonate", apiPrefix), strings.NewReader(`{"reason":"ticket 42"}`))
+	authCtx := &hansipcontext.AuthenticationContext{
+		Subject:  "admin@hansip",
+		Audience: []string{"admin@hansip"},
+	}
+	return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, authCtx))
+}
+
+func TestImpersonateUser(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	repo := &impersonateUserRepo{users: map[string]*connector.User{
+		"u1": {RecID: "u1", Email: "user@test.com", Enabled: true},
+	}, inactive: map[string]bool{}}
+	UserRepo = repo
+	auditRepo := &fakeAuditRepo{}
+	AuditRepo = auditRepo
+	defer func() {
+		AuditRepo = nil
+	}()
+
+	recorder := httptest.NewRecorder()
+	ImpersonateUser(recorder, impersonateRequest())
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	response := &struct {
+		Data *ImpersonateResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := TokenFactory.ReadToken(response.Data.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Subject != "user@test.com" || tok.Additional["impersonator"] != "admin@hansip" || tok.Additional["type"] != "access" {
+		t.Errorf("token is not an impersonation access token of the user")
+	}
+	if len(tok.Audiences) != 1 || tok.Audiences[0] != "user@app" {
+		t.Errorf("token should carry the user roles. got %v", tok.Audiences)
+	}
+	if len(auditRepo.audits) != 1 || auditRepo.audits[0].EventType != AuditImpersonate || auditRepo.audits[0].Actor != "admin@hansip" || !strings.Contains(auditRepo.audits[0].Detail, "ticket 42") {
+		t.Errorf("impersonation is not audited")
+	}
+
+	auditRepo.fail = true
+	recorder = httptest.NewRecorder()
+	ImpersonateUser(recorder, impersonateRequest())
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("token must not be issued when audit fails. got %d", recorder.Code)
+	}
+	auditRepo.fail = false
+
+	// a user who can not login can not be impersonated
+	user := repo.users["u1"]
+	for name, lock := range map[string]func(bool){
+		"disabled":    func(on bool) { user.Enabled = !on },
+		"suspended":   func(on bool) { user.Suspended = on },
+		"deactivated": func(on bool) { repo.inactive["u1"] = on },
+	} {
+		lock(true)
+		recorder = httptest.NewRecorder()
+		ImpersonateUser(recorder, impersonateRequest())
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("%s user should not be impersonated. got %d", name, recorder.Code)
+		}
+		lock(false)
+	}
+}
+
+// impersonatedRequest returns a request made with an impersonation token of user@test.com
+func impersonatedRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	authCtx := &hansipcontext.AuthenticationContext{
+		Subject:      "user@test.com",
+		Audience:     []string{"user@app"},
+		Impersonator: "admin@hansip",
+	}
+	return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, authCtx))
+}
+
+func TestImpersonationRestriction(t *testing.T) {
+	UserRepo = &impersonateUserRepo{users: map[string]*connector.User{}}
+	handlers := map[string]http.HandlerFunc{
+		"/management/user/u1/passwd":   ChangePassphrase,
+		"/management/user/2FAQR":       Show2FAQrCode,
+		"/management/user/activate2FA": Activate2FA,
+		"/auth/change-email":           ChangeEmail,
+	}
+	for path, handler := range handlers {
+		recorder := httptest.NewRecorder()
+		handler(recorder, impersonatedRequest(http.MethodPost, apiPrefix+path, `{}`))
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("%s should be forbidden while impersonating. got %d : %s", path, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	config.Set("token.impersonate.restricted", "false")
+	defer config.Set("token.impersonate.restricted", "true")
+	if !applyImpersonationRestriction(httptest.NewRecorder(), impersonatedRequest(http.MethodPost, apiPrefix+"/management/user/u1/passwd", `{}`), "Passphrase") {
+		t.Errorf("impersonation should not be restricted when token.impersonate.restricted is off")
+	}
+}
@@ -0,0 +1,68 @@
+package endpoint
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	inFlightLog = log.WithField("go", "InFlightLimitMiddleware")
+)
+
+// InFlightLimiter caps the number of requests processed at the same time. A request over the cap is rejected
+// right away instead of waiting, so under extreme load the database and memory are not overwhelmed by piling requests.
+type InFlightLimiter struct {
+	// Max is the number of requests processed at the same time
+	Max int
+	// RetryAfter is told to the rejected clients
+	RetryAfter time.Duration
+	// Exempt are the path prefixes never rejected, eg. the health check
+	Exempt []string
+
+	slots chan struct{}
+}
+
+// NewInFlightLimiter create new instance of InFlightLimiter
+func NewInFlightLimiter(max int, retryAfter time.Duration, exempt []string) *InFlightLimiter {
+	return &InFlightLimiter{Max: max, RetryAfter: retryAfter, Exempt: exempt, slots: make(chan struct{}, max)}
+}
+
+func (il *InFlightLimiter) isExempt(path string) bool {
+	for _, prefix := range il.Exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// InFlight returns the number of requests being processed, not counting the exempted ones.
+func (il *InFlightLimiter) InFlight() int {
+	return len(il.slots)
+}
+
+// Middleware is the http middleware function. Request arriving while Max requests are processed is
+// responded with 503 Service Unavailable and a Retry-After header.
+func (il *InFlightLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if il.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case il.slots <- struct{}{}:
+			defer func() { <-il.slots }()
+			next.ServeHTTP(w, r)
+		default:
+			inFlightLog.WithField("path", r.URL.Path).WithField("method", r.Method).Debugf("rejected, %d requests in flight", il.Max)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(il.RetryAfter.Seconds()))))
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusServiceUnavailable, "Server is at capacity, retry later", nil, nil)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimiter(t *testing.T) {
+	release := make(chan bool)
+	var started sync.WaitGroup
+	limiter := NewInFlightLimiter(4, 2*time.Second, []string{"/health"})
+	server := httptest.NewServer(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started.Done()
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer server.Close()
+
+	// occupy every slot with slow requests
+	var finished sync.WaitGroup
+	started.Add(4)
+	for i := 0; i < 4; i++ {
+		finished.Add(1)
+		go func() {
+			defer finished.Done()
+			resp, err := http.Get(server.URL + "/slow")
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Errorf("expect the slow request processed. got %v %v", resp, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	started.Wait()
+	if limiter.InFlight() != 4 {
+		t.Errorf("expect 4 requests in flight. got %d", limiter.InFlight())
+	}
+
+	// the excess requests are rejected fast instead of waiting for a slot
+	var rejected sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		rejected.Add(1)
+		go func() {
+			defer rejected.Done()
+			start := time.Now()
+			resp, err := http.Get(server.URL + "/api/v1/management/users")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "2" {
+				t.Errorf("expect 503 with Retry-After 2. got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expect the excess request rejected fast. took %s", elapsed)
+			}
+		}()
+	}
+	rejected.Wait()
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expect the health check exempted. got %v %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	close(release)
+	finished.Wait()
+	// a slot is released just after its response is written
+	for deadline := time.Now().Add(time.Second); limiter.InFlight() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("expect the slots released. got %d in flight", limiter.InFlight())
+	}
+	resp, err = http.Get(server.URL + "/api/v1/management/users")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expect requests processed again once the load is gone. got %v %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+}
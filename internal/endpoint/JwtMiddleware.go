@@ -5,14 +5,27 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/hyperjumptech/hansip/internal/config"
 	"github.com/hyperjumptech/hansip/internal/constants"
 	"github.com/hyperjumptech/hansip/internal/hansipcontext"
 	"github.com/hyperjumptech/hansip/internal/hansiperrors"
 	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// RefreshedTokenHeader is the response header carrying a fresh access token issued by sliding refresh
+	RefreshedTokenHeader = "X-Refreshed-Token"
+
+	// TokenExpiredError tells the client its access token is expired and should be refreshed
+	TokenExpiredError = "token-expired"
+	// TokenRevokedError tells the client its access token is expired and can not be refreshed, the user must authenticate again
+	TokenRevokedError = "token-revoked"
+)
+
 var (
 	middlewareLog = log.WithField("go", "JwtMiddleware")
 )
@@ -20,8 +33,15 @@ var (
 // JwtMiddleware handle authorization check for accessed endpoint by inspecting the Authorization header and look for JWT token.
 func JwtMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var expired *hansiperrors.ErrTokenExpired
+		var denied *Endpoint
+		hTok, hTokErr := getHToken(r)
 		for _, ep := range Endpoints {
-			tok, err := ep.AccessValid(r, TokenFactory)
+			tok, err := ep.AccessValid(r, hTok, hTokErr)
+			if err == nil && !ep.IsPublic && isTwoFAEnrollmentToken(tok) && !isTwoFAEnrollmentEndpoint(ep) {
+				middlewareLog.Tracef("Traced 2FA enrollment token used at %s", r.URL.Path)
+				continue
+			}
 			if err == nil {
 				middlewareLog.Tracef("Traced Path match %s to %s", r.URL.Path, ep.PathPattern)
 				hansipContext := &hansipcontext.AuthenticationContext{
@@ -30,7 +50,34 @@ func JwtMiddleware(next http.Handler) http.Handler {
 					Audience:  tok.Audiences,
 					TokenType: tok.Additional["type"].(string),
 				}
+				if impersonator, ok := tok.Additional["impersonator"].(string); ok {
+					hansipContext.Impersonator = impersonator
+				}
+				if permissions, ok := tok.Additional["permissions"].([]interface{}); ok {
+					for _, permission := range permissions {
+						if p, ok := permission.(string); ok {
+							hansipContext.Permissions = append(hansipContext.Permissions, p)
+						}
+					}
+				}
 				tokenCtx := context.WithValue(r.Context(), constants.HansipAuthentication, hansipContext)
+				if !ep.IsPublic {
+					if err := checkTenantsActive(tokenCtx, hansipContext.Audience); err != nil {
+						writeTenantAccessError(tokenCtx, w, err)
+						return
+					}
+				}
+				if hansipContext.IsImpersonated() {
+					writeAudit(tokenCtx, AuditImpersonatedRequest, hansipContext.Impersonator, hansipContext.Subject, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+				}
+				if !ep.IsPublic && config.GetBoolean("token.sliding.enable") {
+					window, err := jiffy.DurationOf(config.Get("token.sliding.window"))
+					if err != nil {
+						middlewareLog.Errorf("invalid token.sliding.window %s. got %s", config.Get("token.sliding.window"), err.Error())
+					} else if refreshed, ok := slidingRefresh(tokenCtx, tok, window); ok {
+						w.Header().Set(RefreshedTokenHeader, refreshed)
+					}
+				}
 				next.ServeHTTP(w, r.WithContext(tokenCtx))
 				return
 			}
@@ -41,10 +88,81 @@ func JwtMiddleware(next http.Handler) http.Handler {
 			}
 			if errors.As(err, &audienceNotAllowedErr) {
 				middlewareLog.Tracef("Traced Audience Not Allowed %v", err)
+				if denied == nil {
+					denied = ep
+				}
 			}
-
+			if expired == nil {
+				errors.As(err, &expired)
+			}
+		}
+		if expired != nil && expired.TokenType == "access" {
+			writeTokenExpired(r.Context(), w, expired)
+			return
+		}
+		// a valid token lacking the audience of the endpoint
+		if denied != nil && hTokErr == nil {
+			recordAuthorizationDenial(r.Context(), middlewareLog.WithField("func", "JwtMiddleware").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method), &AuthorizationDenial{
+				Subject:  hTok.Subject,
+				Method:   r.Method,
+				Route:    denied.PathPattern,
+				Claim:    "role",
+				Required: denied.WhiteListAudiences,
+				Held:     hTok.Audiences,
+			})
 		}
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, fmt.Sprintf("You are not authorized to access this end point %s", r.URL.Path), nil, nil)
 		return
 	})
 }
+
+// writeTokenExpired responds to an expired access token with 401 and the TokenExpiredError code, so the client refreshes
+// its token instead of asking the user to authenticate again. If the subject is revoked, refreshing would fail and
+// the TokenRevokedError code is given instead.
+func writeTokenExpired(ctx context.Context, w http.ResponseWriter, expired *hansiperrors.ErrTokenExpired) {
+	revoked, err := RevocationRepo.IsRevoked(ctx, expired.Subject)
+	if err != nil {
+		middlewareLog.WithField("RequestID", ctx.Value(constants.RequestID)).Errorf("RevocationRepo.IsRevoked got %s", err.Error())
+		helper.WriteHTTPResponse(ctx, w, http.StatusUnauthorized, "You are not authorized to access this end point", nil, nil)
+		return
+	}
+	code, message := TokenExpiredError, "Access token is expired, refresh it"
+	if revoked {
+		code, message = TokenRevokedError, "Your access been revoked, please authenticate again"
+	}
+	helper.WriteHTTPResponse(ctx, w, http.StatusUnauthorized, message, map[string]string{
+		"WWW-Authenticate": fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, code),
+	}, map[string]string{
+		"error": code,
+	})
+}
+
+// slidingRefresh creates a new access token if the validated access token will expire within the sliding window.
+// Token of revoked subject will never be refreshed, nor impersonation token which must end when it expires.
+func slidingRefresh(ctx context.Context, tok *helper.HansipToken, window time.Duration) (string, bool) {
+	if len(tok.Token) == 0 || tok.Additional["type"] != "access" {
+		return "", false
+	}
+	if _, ok := tok.Additional["impersonator"]; ok {
+		return "", false
+	}
+	if time.Until(tok.Expire) > window {
+		return "", false
+	}
+	revoked, err := RevocationRepo.IsRevoked(ctx, tok.Subject)
+	if err != nil || revoked {
+		return "", false
+	}
+	additional := make(map[string]interface{})
+	for k, v := range tok.Additional {
+		additional[k] = v
+	}
+	// the refreshed token replaces one already in use, so it must not wait for the not before offset.
+	// No refresh token is created, the client keeps using the one it has.
+	access, _, err := TokenFactory.CreateTokenPair(tok.Subject, tok.Audiences, additional, helper.TokenOptions{Immediate: true, AccessOnly: true})
+	if err != nil {
+		middlewareLog.Errorf("sliding refresh failed to create token. got %s", err.Error())
+		return "", false
+	}
+	return access, true
+}
@@ -0,0 +1,363 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/hansip/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeRevocationRepo struct {
+	revoked map[string]bool
+}
+
+func (repo *fakeRevocationRepo) Revoke(ctx context.Context, subject string) error {
+	repo.revoked[subject] = true
+	return nil
+}
+
+func (repo *fakeRevocationRepo) UnRevoke(ctx context.Context, subject string) error {
+	delete(repo.revoked, subject)
+	return nil
+}
+
+func (repo *fakeRevocationRepo) IsRevoked(ctx context.Context, subject string) (bool, error) {
+	return repo.revoked[subject], nil
+}
+
+func TestSlidingRefresh(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+
+	nearExpiry, err := helper.CreateJWTStringToken("testkey", "HS256", "test.issuer", "user@test.com", []string{"user@test"}, time.Now(), time.Now(), time.Now().Add(30*time.Second), map[string]interface{}{"type": "access"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := TokenFactory.ReadToken(nearExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, ok := slidingRefresh(context.Background(), tok, time.Minute)
+	if !ok {
+		t.Fatalf("token inside sliding window should be refreshed")
+	}
+	newTok, err := TokenFactory.ReadToken(refreshed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newTok.Subject != "user@test.com" || !newTok.Expire.After(tok.Expire) || newTok.Additional["type"] != "access" {
+		t.Errorf("refreshed token is not a longer living access token of the same subject")
+	}
+
+	// the refreshed token is usable right away even if the factory delays new tokens.
+	TokenFactory = helper.NewTokenFactoryWithNotBefore("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour, time.Minute, 0)
+	refreshed, ok = slidingRefresh(context.Background(), tok, time.Minute)
+	if !ok {
+		t.Fatalf("token inside sliding window should be refreshed")
+	}
+	newTok, err = TokenFactory.ReadToken(refreshed)
+	if err != nil {
+		t.Fatalf("refreshed token should be valid right away. got %s", err.Error())
+	}
+	if newTok.NotBefore.After(time.Now()) {
+		t.Errorf("refreshed token should not be delayed by the not before offset. got %s", newTok.NotBefore)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+
+	farExpiry, _ := helper.CreateJWTStringToken("testkey", "HS256", "test.issuer", "user@test.com", []string{"user@test"}, time.Now(), time.Now(), time.Now().Add(5*time.Minute), map[string]interface{}{"type": "access"})
+	tok, _ = TokenFactory.ReadToken(farExpiry)
+	if _, ok := slidingRefresh(context.Background(), tok, time.Minute); ok {
+		t.Errorf("token outside sliding window should not be refreshed")
+	}
+
+	impersonation, _ := helper.CreateJWTStringToken("testkey", "HS256", "test.issuer", "user@test.com", []string{"user@test"}, time.Now(), time.Now(), time.Now().Add(30*time.Second), map[string]interface{}{"type": "access", "impersonator": "admin@test.com"})
+	tok, _ = TokenFactory.ReadToken(impersonation)
+	if _, ok := slidingRefresh(context.Background(), tok, time.Minute); ok {
+		t.Errorf("impersonation token should not be refreshed")
+	}
+
+	RevocationRepo.Revoke(context.Background(), "user@test.com")
+	tok, _ = TokenFactory.ReadToken(nearExpiry)
+	if _, ok := slidingRefresh(context.Background(), tok, time.Minute); ok {
+		t.Errorf("revoked token should not be refreshed")
+	}
+}
+
+func TestSlidingRefreshAccessOnly(t *testing.T) {
+	store := &fakeOpaqueTokenStore{tokens: make(map[string]*helper.HansipToken)}
+	TokenFactory = helper.NewOpaqueTokenFactory(store, "test.issuer", 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	defer func() {
+		TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	}()
+
+	tok := &helper.HansipToken{Token: "near-expiry", Subject: "user@test.com", Audiences: []string{"user@test"}, Expire: time.Now().Add(30 * time.Second), Additional: map[string]interface{}{"type": "access"}}
+	for i := 0; i < 3; i++ {
+		if _, ok := slidingRefresh(context.Background(), tok, time.Minute); !ok {
+			t.Fatalf("token inside sliding window should be refreshed")
+		}
+	}
+	if len(store.tokens) != 3 {
+		t.Fatalf("each sliding refresh should store only its access token. got %d tokens", len(store.tokens))
+	}
+	for _, stored := range store.tokens {
+		if stored.Additional["type"] != "access" {
+			t.Errorf("sliding refresh should not create a refresh token")
+		}
+	}
+}
+
+func TestNotBeforeDelay(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	login := func(body string) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		response := &struct {
+			Data *Response `json:"data"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), response)
+		if response.Data == nil {
+			return recorder.Code, ""
+		}
+		return recorder.Code, response.Data.AccessToken
+	}
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fatest", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	code, delayed := login(`{"email":"user@test.com","passphrase":"abcdefg","not_before_delay":"1 hour"}`)
+	if code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	if code := call(delayed); code != http.StatusUnauthorized {
+		t.Errorf("token presented before its nbf should be rejected. got %d", code)
+	}
+
+	_, immediate := login(`{"email":"user@test.com","passphrase":"abcdefg"}`)
+	if code := call(immediate); code != http.StatusOK {
+		t.Errorf("token presented after its nbf should be accepted. got %d", code)
+	}
+
+	if code, _ := login(`{"email":"user@test.com","passphrase":"abcdefg","not_before_delay":"-1 hour"}`); code != http.StatusBadRequest {
+		t.Errorf("negative delay should be rejected. got %d", code)
+	}
+	if code, _ := login(`{"email":"user@test.com","passphrase":"abcdefg","not_before_delay":"2 days"}`); code != http.StatusBadRequest {
+		t.Errorf("delay longer than token.notbefore.maxdelay should be rejected. got %d", code)
+	}
+}
+
+type twoFAUserRepo struct {
+	deactivationUserRepo
+}
+
+func (repo *twoFAUserRepo) GetUserBy2FAToken(ctx context.Context, token string) (*connector.User, error) {
+	if token != repo.user.Token2FA {
+		return nil, nil
+	}
+	return repo.user, nil
+}
+
+// currentOtp computes the TOTP code of the secret for the current 30 seconds step.
+func currentOtp(secret totp.Secret) string {
+	hash := hmac.New(sha1.New, secret)
+	binary.Write(hash, binary.BigEndian, time.Now().UTC().Unix()/30)
+	h := hash.Sum(nil)
+	offset := h[19] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(h[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTwoFANotBeforeDelay(t *testing.T) {
+	secret := totp.MakeSecret()
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &twoFAUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", Enabled: true, Enable2FactorAuth: true, UserTotpSecretKey: secret.Base32(), Token2FA: "2fatoken"}, active: true}}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	login := func(delay string) (int, string) {
+		body := fmt.Sprintf(`{"2FA_token":"2fatoken","2FA_otp":"%s","not_before_delay":"%s"}`, currentOtp(secret), delay)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fa", apiPrefix), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		TwoFA(recorder, req)
+		response := &struct {
+			Data *Response `json:"data"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), response)
+		if response.Data == nil {
+			return recorder.Code, ""
+		}
+		return recorder.Code, response.Data.AccessToken
+	}
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fatest", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	code, delayed := login("1 hour")
+	if code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	if code := call(delayed); code != http.StatusUnauthorized {
+		t.Errorf("2FA token presented before its nbf should be rejected. got %d", code)
+	}
+	_, immediate := login("")
+	if code := call(immediate); code != http.StatusOK {
+		t.Errorf("2FA token presented after its nbf should be accepted. got %d", code)
+	}
+	if code, _ := login("2 days"); code != http.StatusBadRequest {
+		t.Errorf("2FA delay longer than token.notbefore.maxdelay should be rejected. got %d", code)
+	}
+}
+
+func TestAcceptedIssuers(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	TokenFactory.(*helper.DefaultTokenFactory).AcceptedIssuers = []string{"old.issuer"}
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(issuer string) int {
+		token, err := helper.CreateJWTStringToken("testkey", "HS256", issuer, "user@test.com", []string{"user@test"}, time.Now(), time.Now(), time.Now().Add(time.Minute), map[string]interface{}{"type": "access"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fatest", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for _, issuer := range []string{config.Get("token.issuer"), "old.issuer"} {
+		if code := call(issuer); code != http.StatusOK {
+			t.Errorf("token from accepted issuer %s should pass. got %d", issuer, code)
+		}
+	}
+	if code := call("unknown.issuer"); code != http.StatusUnauthorized {
+		t.Errorf("token from unknown issuer should be rejected. got %d", code)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	revocations := &fakeRevocationRepo{revoked: make(map[string]bool)}
+	RevocationRepo = revocations
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(token string) (int, string, string) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fatest", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		response := &struct {
+			Data map[string]string `json:"data"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), response)
+		return recorder.Code, response.Data["error"], recorder.Header().Get("WWW-Authenticate")
+	}
+	token := func(key, subject, tokenType string, expire time.Time) string {
+		tok, err := helper.CreateJWTStringToken(key, "HS256", config.Get("token.issuer"), subject, []string{"user@test"}, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour), expire, map[string]interface{}{"type": tokenType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	code, errorCode, challenge := call(token("testkey", "user@test.com", "access", time.Now().Add(-time.Minute)))
+	if code != http.StatusUnauthorized || errorCode != TokenExpiredError || challenge != `Bearer error="invalid_token", error_description="token-expired"` {
+		t.Errorf("expired access token should be told to refresh. got %d %q %q", code, errorCode, challenge)
+	}
+
+	revocations.Revoke(context.Background(), "revoked@test.com")
+	if code, errorCode, _ := call(token("testkey", "revoked@test.com", "access", time.Now().Add(-time.Minute))); code != http.StatusUnauthorized || errorCode != TokenRevokedError {
+		t.Errorf("expired access token of revoked user should be told to authenticate. got %d %q", code, errorCode)
+	}
+
+	for name, invalid := range map[string]string{
+		"malformed":             "not.a.token",
+		"signed by another key": token("anotherkey", "user@test.com", "access", time.Now().Add(-time.Minute)),
+		"expired refresh token": token("testkey", "user@test.com", "refresh", time.Now().Add(-time.Minute)),
+	} {
+		if code, errorCode, challenge := call(invalid); code != http.StatusUnauthorized || len(errorCode) > 0 || len(challenge) > 0 {
+			t.Errorf("%s should get the generic 401. got %d %q %q", name, code, errorCode, challenge)
+		}
+	}
+
+	if code, _, _ := call(token("testkey", "user@test.com", "access", time.Now().Add(time.Minute))); code != http.StatusOK {
+		t.Errorf("valid access token should pass. got %d", code)
+	}
+}
+
+func TestPurposeTokenRejected(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/2fatest", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// a token without audience is accepted by the end points of any user
+	plain, err := TokenFactory.CreateAccessToken("user@test.com", []string{}, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(plain); code != http.StatusOK {
+		t.Fatalf("access token without audience should pass. got %d", code)
+	}
+	emailChange, err := createEmailChangeToken(&connector.User{RecID: "u1", Email: "user@test.com"}, "new@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(emailChange); code != http.StatusUnauthorized {
+		t.Errorf("email change token should not be accepted as bearer token. got %d", code)
+	}
+}
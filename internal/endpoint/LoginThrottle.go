@@ -0,0 +1,140 @@
+package endpoint
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// loginThrottleForget is how long the failures of an identifier or client are remembered after its last failure
+	loginThrottleForget = time.Hour
+
+	// maxLoginThrottleEntries is the number of identifiers and clients remembered before the forgotten ones are purged
+	maxLoginThrottleEntries = 10000
+)
+
+// LoginThrottle delays the response of failed logins, nil when login throttling is disabled
+var LoginThrottle *LoginThrottler
+
+// loginFailures counts the consecutive failed logins of an identifier or a client
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// LoginThrottler slows down brute force logins with a progressive delay instead of locking the account out.
+// Failures are counted for the identifier, eg. the attempted email, and for the client IP address. Each consecutive
+// failure doubles the delay, starting from Base and up to Max. A successful login resets both counts.
+type LoginThrottler struct {
+	Base time.Duration
+	Max  time.Duration
+
+	mutex    sync.Mutex
+	failures map[string]*loginFailures
+	now      func() time.Time
+}
+
+// NewLoginThrottler create new instance of LoginThrottler.
+func NewLoginThrottler(base, max time.Duration) *LoginThrottler {
+	return &LoginThrottler{
+		Base:     base,
+		Max:      max,
+		failures: make(map[string]*loginFailures),
+		now:      time.Now,
+	}
+}
+
+func loginThrottleKeys(identifier, client string) []string {
+	return []string{"id|" + strings.ToLower(strings.TrimSpace(identifier)), "ip|" + client}
+}
+
+// Fail counts a failed login of the identifier from the client and returns the delay to apply before responding.
+func (lt *LoginThrottler) Fail(identifier, client string) time.Duration {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	now := lt.now()
+	count := 0
+	for _, key := range loginThrottleKeys(identifier, client) {
+		failures, ok := lt.failures[key]
+		if !ok || now.Sub(failures.last) > loginThrottleForget {
+			if len(lt.failures) >= maxLoginThrottleEntries {
+				lt.purge(now)
+			}
+			failures = &loginFailures{}
+			lt.failures[key] = failures
+		}
+		failures.count++
+		failures.last = now
+		if failures.count > count {
+			count = failures.count
+		}
+	}
+	return lt.delayOf(count)
+}
+
+// Succeed resets the failed logins of the identifier and of the client.
+func (lt *LoginThrottler) Succeed(identifier, client string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	for _, key := range loginThrottleKeys(identifier, client) {
+		delete(lt.failures, key)
+	}
+}
+
+// delayOf returns Base doubled for each failure after the first, capped at Max.
+func (lt *LoginThrottler) delayOf(count int) time.Duration {
+	if count <= 0 || lt.Base <= 0 {
+		return 0
+	}
+	delay := lt.Base
+	for i := 1; i < count && delay < lt.Max; i++ {
+		delay *= 2
+	}
+	if delay > lt.Max {
+		return lt.Max
+	}
+	return delay
+}
+
+// purge removes the failures that are forgotten by now.
+func (lt *LoginThrottler) purge(now time.Time) {
+	for key, failures := range lt.failures {
+		if now.Sub(failures.last) > loginThrottleForget {
+			delete(lt.failures, key)
+		}
+	}
+}
+
+// sleepContext waits for the delay, or until the context is done, eg. the client disconnected.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttleFailedLogin counts the failed login of the identifier and delays the response accordingly.
+func throttleFailedLogin(r *http.Request, identifier string) {
+	if LoginThrottle == nil {
+		return
+	}
+	_ = sleepContext(r.Context(), LoginThrottle.Fail(identifier, clientIP(r)))
+}
+
+// throttleSucceededLogin resets the failed logins of the identifier and of the client.
+func throttleSucceededLogin(r *http.Request, identifier string) {
+	if LoginThrottle == nil {
+		return
+	}
+	LoginThrottle.Succeed(identifier, clientIP(r))
+}
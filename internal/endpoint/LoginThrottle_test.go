@@ -0,0 +1,59 @@
+package endpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottler(t *testing.T) {
+	lt := NewLoginThrottler(100*time.Millisecond, time.Second)
+
+	// the delay doubles with each consecutive failure, up to the max
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, exp := range expected {
+		if delay := lt.Fail("user@hansip.io", "192.0.2.10"); delay != exp {
+			t.Fatalf("failure #%d expect delay %s. got %s", i+1, exp, delay)
+		}
+	}
+
+	// the client IP keeps its failures when another email is tried
+	if delay := lt.Fail("other@hansip.io", "192.0.2.10"); delay != time.Second {
+		t.Fatalf("expect the client IP delay. got %s", delay)
+	}
+	// and the email keeps its failures from another client IP, whatever its case
+	if delay := lt.Fail("User@Hansip.io", "192.0.2.20"); delay != time.Second {
+		t.Fatalf("expect the email delay. got %s", delay)
+	}
+
+	// a success resets the email and the client IP
+	lt.Succeed("user@hansip.io", "192.0.2.10")
+	if delay := lt.Fail("user@hansip.io", "192.0.2.10"); delay != 100*time.Millisecond {
+		t.Fatalf("expect the base delay after a success. got %s", delay)
+	}
+
+	// failures are forgotten after a while
+	now := time.Now()
+	lt.now = func() time.Time { return now.Add(2 * loginThrottleForget) }
+	if delay := lt.Fail("user@hansip.io", "192.0.2.10"); delay != 100*time.Millisecond {
+		t.Fatalf("expect the base delay after the failures are forgotten. got %s", delay)
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if err := sleepContext(ctx, 10*time.Second); err == nil {
+		t.Fatalf("expect error when the context is cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expect the sleep to be cut off. took %s", elapsed)
+	}
+	if err := sleepContext(context.Background(), 10*time.Millisecond); err != nil {
+		t.Fatalf("expect no error. got %s", err.Error())
+	}
+}
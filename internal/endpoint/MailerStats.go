@@ -0,0 +1,13 @@
+package endpoint
+
+import (
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// GetMailerStats serving the depth, throughput and outcome counts of the mailer queue, for the hansip admin.
+func GetMailerStats(w http.ResponseWriter, r *http.Request) {
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Mailer queue stats", nil, mailer.Stats())
+}
@@ -2,6 +2,7 @@ package endpoint
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/hyperjumptech/hansip/api"
@@ -26,6 +27,12 @@ var (
 	GroupRoleRepo connector.GroupRoleRepository
 	// RevocationRepo is a revocation repository instance
 	RevocationRepo connector.RevocationRepository
+	// AuditRepo is an audit repository instance
+	AuditRepo connector.AuditRepository
+	// PassphraseHistoryRepo is a passphrase history repository instance
+	PassphraseHistoryRepo connector.PassphraseHistoryRepository
+	// WebAuthnCredentialRepo is a WebAuthn credential repository instance
+	WebAuthnCredentialRepo connector.WebAuthnCredentialRepository
 	// EmailSender is email sender instance
 	EmailSender connector.EmailSender
 
@@ -47,28 +54,58 @@ func init() {
 	Endpoints = []*Endpoint{
 		{"/docs/**/*", GetMethod, true, nil, api.ServeStatic},
 		{"/health", GetMethod, true, nil, HealthCheck},
+		{"/ready", GetMethod, true, nil, Ready},
+		{"/metrics", GetMethod, true, nil, Metrics},
+		{fmt.Sprintf("%s/version", apiPrefix), OptionMethod | GetMethod, true, nil, GetVersion},
+		{fmt.Sprintf("%s/_buildinfo", apiPrefix), OptionMethod | GetMethod, false, []string{hansipAdmin}, GetBuildInfo},
+		{fmt.Sprintf("%s/_mailer/stats", apiPrefix), OptionMethod | GetMethod, false, []string{hansipAdmin}, GetMailerStats},
 		{fmt.Sprintf("%s/auth/authenticate", apiPrefix), OptionMethod | PostMethod, true, nil, Authentication},
 		{fmt.Sprintf("%s/auth/refresh", apiPrefix), OptionMethod | PostMethod, false, []string{anyUser}, Refresh},
 		{fmt.Sprintf("%s/auth/2fa", apiPrefix), OptionMethod | PostMethod, true, nil, TwoFA},
 		{fmt.Sprintf("%s/auth/2fatest", apiPrefix), OptionMethod | PostMethod, false, []string{anyUser}, TwoFATest},
+		{fmt.Sprintf("%s/auth/permissions", apiPrefix), OptionMethod | GetMethod, false, []string{anyUser}, GetEffectivePermissions},
 		{fmt.Sprintf("%s/auth/authenticate2fa", apiPrefix), OptionMethod | PostMethod, false, nil, Authentication2FA},
+		{PasswordPolicyCheckPath(), OptionMethod | PostMethod, true, nil, PasswordPolicyCheck},
+		{fmt.Sprintf("%s/auth/change-email", apiPrefix), OptionMethod | PostMethod, false, []string{anyUser}, ChangeEmail},
+		{fmt.Sprintf("%s/auth/change-email/confirm", apiPrefix), OptionMethod | PostMethod, true, nil, ConfirmEmailChange},
+		{fmt.Sprintf("%s/auth/resend-verification", apiPrefix), OptionMethod | PostMethod, true, nil, ResendVerification},
+		{fmt.Sprintf("%s/auth/webauthn/register/begin", apiPrefix), OptionMethod | PostMethod, false, []string{anyUser}, WebAuthnRegisterBegin},
+		{fmt.Sprintf("%s/auth/webauthn/register/finish", apiPrefix), OptionMethod | PostMethod, false, []string{anyUser}, WebAuthnRegisterFinish},
+		{fmt.Sprintf("%s/auth/webauthn/login/begin", apiPrefix), OptionMethod | PostMethod, true, nil, WebAuthnLoginBegin},
+		{fmt.Sprintf("%s/auth/webauthn/login/finish", apiPrefix), OptionMethod | PostMethod, true, nil, WebAuthnLoginFinish},
 
+		{fmt.Sprintf("%s/management/audit/export", apiPrefix), OptionMethod | GetMethod, false, []string{hansipAdmin}, ExportAudit},
+		{fmt.Sprintf("%s/management/delete/prepare", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, PrepareDelete},
 		{fmt.Sprintf("%s/management/tenants", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListAllTenants},
+		{fmt.Sprintf("%s/management/tenants", apiPrefix), OptionMethod | PostMethod, false, []string{hansipAdmin}, ProvisionTenant},
 		{fmt.Sprintf("%s/management/tenant", apiPrefix), OptionMethod | PostMethod, false, []string{hansipAdmin}, CreateNewTenant},
 		{fmt.Sprintf("%s/management/tenant/{tenantRecId}", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, GetTenantDetail},
 		{fmt.Sprintf("%s/management/tenant/{tenantRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{hansipAdmin}, UpdateTenantDetail},
 		{fmt.Sprintf("%s/management/tenant/{tenantRecId}", apiPrefix), OptionMethod | DeleteMethod, false, []string{hansipAdmin}, DeleteTenant},
+		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/branding", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, GetTenantBrandingDetail},
+		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/branding", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, UpdateTenantBranding},
+		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/email-domains", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, GetTenantEmailDomains},
+		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/email-domains", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, UpdateTenantEmailDomains},
+		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/suspend", apiPrefix), OptionMethod | PutMethod, false, []string{hansipAdmin}, SuspendTenant},
+		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/reactivate", apiPrefix), OptionMethod | PutMethod, false, []string{hansipAdmin}, ReactivateTenant},
 
 		{fmt.Sprintf("%s/management/users", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListAllUsers},
 		{fmt.Sprintf("%s/management/user", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, CreateNewUser},
 		{fmt.Sprintf("%s/management/user/{userRecId}/passwd", apiPrefix), OptionMethod | PostMethod, false, nil, ChangePassphrase},
 		{fmt.Sprintf("%s/management/user/activate", apiPrefix), OptionMethod | PostMethod, true, []string{adminUser}, ActivateUser},
 		{fmt.Sprintf("%s/management/user/whoami", apiPrefix), OptionMethod | GetMethod, false, []string{anyUser}, WhoAmI},
-		{fmt.Sprintf("%s/management/user/2FAQR", apiPrefix), OptionMethod | GetMethod, false, nil, Show2FAQrCode},
-		{fmt.Sprintf("%s/management/user/activate2FA", apiPrefix), OptionMethod | PostMethod, false, nil, Activate2FA},
+		{fmt.Sprintf("%s/management/user/2FAQR", apiPrefix), OptionMethod | GetMethod, false, []string{anyUser}, Show2FAQrCode},
+		{fmt.Sprintf("%s/management/user/activate2FA", apiPrefix), OptionMethod | PostMethod, false, []string{anyUser}, Activate2FA},
+		{fmt.Sprintf("%s/management/user/passkeys", apiPrefix), OptionMethod | GetMethod, false, []string{anyUser}, ListPasskeys},
+		{fmt.Sprintf("%s/management/user/passkey/{passkeyRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{anyUser}, RenamePasskey},
+		{fmt.Sprintf("%s/management/user/passkey/{passkeyRecId}", apiPrefix), OptionMethod | DeleteMethod, false, []string{anyUser}, DeletePasskey},
 		{fmt.Sprintf("%s/management/user/{userRecId}", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, GetUserDetail},
 		{fmt.Sprintf("%s/management/user/{userRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, UpdateUserDetail},
 		{fmt.Sprintf("%s/management/user/{userRecId}", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteUser},
+		{fmt.Sprintf("%s/management/user/{userRecId}/deactivate", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, DeactivateUser},
+		{fmt.Sprintf("%s/management/user/{userRecId}/reactivate", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, ReactivateUser},
+		{fmt.Sprintf("%s/management/user/{userRecId}/resend-verification", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, ResendUserVerification},
+		{fmt.Sprintf("%s/management/user/{userRecId}/impersonate", apiPrefix), OptionMethod | PostMethod, false, []string{hansipAdmin}, ImpersonateUser},
 		{fmt.Sprintf("%s/management/user/{userRecId}/roles", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListUserRole},
 		{fmt.Sprintf("%s/management/user/{userRecId}/roles", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, SetUserRoles},
 		{fmt.Sprintf("%s/management/user/{userRecId}/roles", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteUserRoles},
@@ -89,6 +126,9 @@ func init() {
 		{fmt.Sprintf("%s/management/group/{groupRecId}/users", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListGroupUser},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/users", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, SetGroupUsers},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/users", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteGroupUsers},
+		{fmt.Sprintf("%s/management/group/{groupRecId}/members:bulk", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, BulkCreateGroupUsers},
+		{fmt.Sprintf("%s/management/group/{groupRecId}/emails", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, SendGroupEmail},
+		{fmt.Sprintf("%s/management/group/{groupRecId}/email/{jobId}", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, GetGroupEmailJob},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/user/{userRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, CreateGroupUser},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/user/{userRecId}", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteGroupUser},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/roles", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListGroupRole},
@@ -96,6 +136,9 @@ func init() {
 		{fmt.Sprintf("%s/management/group/{groupRecId}/roles", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteGroupRoles},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/role/{roleRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, CreateGroupRole},
 		{fmt.Sprintf("%s/management/group/{groupRecId}/role/{roleRecId}", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteGroupRole},
+		{fmt.Sprintf("%s/management/group/{groupRecId}/parent/{parentRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, SetGroupParent},
+		{fmt.Sprintf("%s/management/group/{groupRecId}/parent", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteGroupParent},
+		{fmt.Sprintf("%s/management/group/{groupRecId}/descendants", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListGroupDescendants},
 
 		{fmt.Sprintf("%s/management/tenant/{tenantRecId}/roles", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListAllRole},
 		{fmt.Sprintf("%s/management/role", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, CreateRole},
@@ -105,6 +148,7 @@ func init() {
 		{fmt.Sprintf("%s/management/role/{roleRecId}/users", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListRoleUser},
 		{fmt.Sprintf("%s/management/role/{roleRecId}/users", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, SetRoleUsers},
 		{fmt.Sprintf("%s/management/role/{roleRecId}/users", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteRoleUsers},
+		{fmt.Sprintf("%s/management/role/{roleRecId}/members:bulk", apiPrefix), OptionMethod | PostMethod, false, []string{adminUser}, BulkCreateRoleUsers},
 		{fmt.Sprintf("%s/management/role/{roleRecId}/user/{userRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, CreateRoleUser},
 		{fmt.Sprintf("%s/management/role/{roleRecId}/user/{userRecId}", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteRoleUser},
 		{fmt.Sprintf("%s/management/role/{roleRecId}/groups", apiPrefix), OptionMethod | GetMethod, false, []string{adminUser}, ListRoleGroup},
@@ -113,6 +157,9 @@ func init() {
 		{fmt.Sprintf("%s/management/role/{roleRecId}/group/{groupRecId}", apiPrefix), OptionMethod | PutMethod, false, []string{adminUser}, CreateRoleGroup},
 		{fmt.Sprintf("%s/management/role/{roleRecId}/group/{GroupRecID}", apiPrefix), OptionMethod | DeleteMethod, false, []string{adminUser}, DeleteRoleGroup},
 
+		{fmt.Sprintf("%s/export", apiPrefix), OptionMethod | GetMethod, false, []string{hansipAdmin}, ExportDirectory},
+		{fmt.Sprintf("%s/import", apiPrefix), OptionMethod | PostMethod, false, []string{hansipAdmin}, ImportDirectory},
+
 		{fmt.Sprintf("%s/recovery/recoverPassphrase", apiPrefix), OptionMethod | PostMethod, true, nil, RecoverPassphrase},
 		{fmt.Sprintf("%s/recovery/resetPassphrase", apiPrefix), OptionMethod | PostMethod, true, nil, ResetPassphrase},
 	}
@@ -124,6 +171,11 @@ func InitializeRouter(router *mux.Router) {
 		router.HandleFunc(path, api.ServeStatic).Methods("GET")
 	}
 	for _, ep := range Endpoints {
-		router.HandleFunc(ep.PathPattern, ep.HandleFunction).Methods(FlagToListMethod(ep.AllowedMethodFlag)...)
+		var handler http.Handler = http.HandlerFunc(ep.HandleFunction)
+		middlewares := RouteMiddlewares[ep.PathPattern]
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+		router.Handle(ep.PathPattern, handler).Methods(FlagToListMethod(ep.AllowedMethodFlag)...)
 	}
 }
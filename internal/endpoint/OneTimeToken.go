@@ -0,0 +1,46 @@
+package endpoint
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+const (
+	// TokenAlreadyUsedError tells the client a one-time token was already used and can not be used again
+	TokenAlreadyUsedError = "token-already-used"
+
+	// oneTimeTokenIDClaim is the claim carrying the unique ID of a one-time token
+	oneTimeTokenIDClaim = "jti"
+)
+
+var (
+	// ConsumedTokenRepo records the IDs of the used one-time tokens when "token.onetime.replay.check" is enabled, nil disables the check
+	ConsumedTokenRepo connector.ConsumedTokenRepository
+)
+
+// oneTimeClaims adds a unique token ID to the claims of a one-time token, the email change and delete confirmation tokens.
+// Access tokens have no ID, they are used many times until they expire.
+func oneTimeClaims(claims map[string]interface{}) map[string]interface{} {
+	claims[oneTimeTokenIDClaim] = helper.MakeRandomString(32, true, true, true, false)
+	return claims
+}
+
+// consumeOneTimeToken marks the one-time token used until it expires, it returns false if it was already used.
+// Tokens issued before the check was enabled have no ID, they are accepted until they expire.
+func consumeOneTimeToken(ctx context.Context, tok *helper.HansipToken) (bool, error) {
+	tokenID, _ := tok.Additional[oneTimeTokenIDClaim].(string)
+	if ConsumedTokenRepo == nil || len(tokenID) == 0 {
+		return true, nil
+	}
+	return ConsumedTokenRepo.ConsumeTokenID(ctx, tokenID, tok.Expire)
+}
+
+// writeTokenAlreadyUsed responds to a replayed one-time token with the status and the TokenAlreadyUsedError code
+func writeTokenAlreadyUsed(ctx context.Context, w http.ResponseWriter, status int) {
+	helper.WriteHTTPResponse(ctx, w, status, "token already used", nil, map[string]string{
+		"error": TokenAlreadyUsedError,
+	})
+}
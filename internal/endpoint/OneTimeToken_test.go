@@ -0,0 +1,205 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type memoryConsumedTokenRepo struct {
+	mutex    sync.Mutex
+	consumed map[string]time.Time
+}
+
+func (repo *memoryConsumedTokenRepo) ConsumeTokenID(ctx context.Context, tokenID string, expire time.Time) (bool, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	if _, used := repo.consumed[tokenID]; used {
+		return false, nil
+	}
+	repo.consumed[tokenID] = expire
+	return true, nil
+}
+
+// errorCode returns the error code in the data of the response
+func errorCode(recorder *httptest.ResponseRecorder) string {
+	resp := &struct {
+		Data map[string]string `json:"data"`
+	}{}
+	json.Unmarshal(recorder.Body.Bytes(), resp)
+	return resp.Data["error"]
+}
+
+func TestOneTimeDeleteConfirmation(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	repo := &memoryConsumedTokenRepo{consumed: make(map[string]time.Time)}
+	ConsumedTokenRepo = repo
+	defer func() { ConsumedTokenRepo = nil }()
+
+	tenantPath := fmt.Sprintf("%s/management/tenant/tenant1", apiPrefix)
+	authenticated := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@hansip",
+			Audience: []string{"admin@hansip"},
+		}))
+	}
+	req := authenticated(httptest.NewRequest(http.MethodPost, "/api/v1/management/delete/prepare", strings.NewReader(fmt.Sprintf(`{"path":%q}`, tenantPath))))
+	prepared := httptest.NewRecorder()
+	PrepareDelete(prepared, req)
+	resp := &struct {
+		Data PrepareDeleteResponse `json:"data"`
+	}{}
+	json.Unmarshal(prepared.Body.Bytes(), resp)
+	confirmation := resp.Data.ConfirmationToken
+
+	tok, err := TokenFactory.ReadToken(confirmation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenID, _ := tok.Additional[oneTimeTokenIDClaim].(string); len(tokenID) == 0 {
+		t.Fatalf("expect the confirmation token to have an ID. got %v", tok.Additional)
+	}
+
+	deleted := 0
+	handler := DeleteConfirmationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted++
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant deleted", nil, nil)
+	}))
+	remove := func() *httptest.ResponseRecorder {
+		req := authenticated(httptest.NewRequest(http.MethodDelete, tenantPath, nil))
+		req.Header.Set(DeleteConfirmationHeader, confirmation)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := remove(); recorder.Code != http.StatusOK || deleted != 1 {
+		t.Fatalf("first use of the confirmation should be served. got %d, %d deleted", recorder.Code, deleted)
+	}
+	replayed := remove()
+	if replayed.Code != http.StatusPreconditionRequired || deleted != 1 {
+		t.Errorf("second use of the confirmation should respond 428. got %d, %d deleted", replayed.Code, deleted)
+	}
+	if code := errorCode(replayed); code != TokenAlreadyUsedError {
+		t.Errorf("expect error %s. got %q", TokenAlreadyUsedError, code)
+	}
+
+	ConsumedTokenRepo = nil
+	if recorder := remove(); recorder.Code != http.StatusOK || deleted != 2 {
+		t.Errorf("without the replay check the confirmation is valid until it expires. got %d, %d deleted", recorder.Code, deleted)
+	}
+}
+
+func TestOneTimeEmailChangeToken(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	ConsumedTokenRepo = &memoryConsumedTokenRepo{consumed: make(map[string]time.Time)}
+	defer func() { ConsumedTokenRepo = nil }()
+	alice := &connector.User{RecID: "u1", Email: "alice@example.com"}
+	repo := &emailChangeUserRepo{
+		users:    []*connector.User{alice},
+		verified: make(map[string]bool),
+	}
+	UserRepo = repo
+
+	confirm := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/change-email/confirm", apiPrefix), strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
+		recorder := httptest.NewRecorder()
+		ConfirmEmailChange(recorder, req)
+		return recorder
+	}
+
+	toCarol, err := createEmailChangeToken(alice, "carol@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := confirm(toCarol); recorder.Code != http.StatusOK || alice.Email != "carol@example.com" {
+		t.Fatalf("first use of the token should change the email. got %d %s", recorder.Code, alice.Email)
+	}
+	toAlice, err := createEmailChangeToken(alice, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := confirm(toAlice); recorder.Code != http.StatusOK || alice.Email != "alice@example.com" {
+		t.Fatalf("expect the email changed back. got %d %s", recorder.Code, alice.Email)
+	}
+
+	// the email is alice's again, so only the replay check stops the first token
+	replayed := confirm(toCarol)
+	if replayed.Code != http.StatusBadRequest || alice.Email != "alice@example.com" {
+		t.Errorf("second use of the token should be rejected. got %d %s", replayed.Code, alice.Email)
+	}
+	if code := errorCode(replayed); code != TokenAlreadyUsedError {
+		t.Errorf("expect error %s. got %q", TokenAlreadyUsedError, code)
+	}
+}
+
+// recoveryUserRepo finds the user by its current recovery or activation code
+type recoveryUserRepo struct {
+	activationUserRepo
+}
+
+func (repo *recoveryUserRepo) GetUserByRecoveryToken(ctx context.Context, token string) (*connector.User, error) {
+	if repo.user.RecoveryCode != token {
+		return nil, nil
+	}
+	return repo.user, nil
+}
+
+func TestResetPassphraseReplay(t *testing.T) {
+	repo := &recoveryUserRepo{activationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", Enabled: true, RecoveryCode: "abcdefghij"}}}
+	UserRepo = repo
+	reset := func(code, newPassphrase string) {
+		body := fmt.Sprintf(`{"passphraseResetToken":%q,"newPassphrase":%q}`, code, newPassphrase)
+		recorder := httptest.NewRecorder()
+		ResetPassphrase(recorder, httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/recovery/resetPassphrase", apiPrefix), strings.NewReader(body)))
+	}
+
+	reset("abcdefghij", "correct horse battery staple")
+	changed := repo.user.HashedPassphrase
+	if len(changed) == 0 || len(repo.user.RecoveryCode) != 0 {
+		t.Fatalf("reset link should change the passphrase and be cleared. got recovery code %q", repo.user.RecoveryCode)
+	}
+	reset("abcdefghij", "another horse battery staple")
+	if repo.user.HashedPassphrase != changed {
+		t.Errorf("replayed reset link should not change the passphrase")
+	}
+	reset("", "another horse battery staple")
+	if repo.user.HashedPassphrase != changed {
+		t.Errorf("empty reset token should not match the cleared recovery code")
+	}
+}
+
+func TestActivateUserReplay(t *testing.T) {
+	repo := &activationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", ActivationCode: "123456"}}
+	UserRepo = repo
+	activate := func(code string) int {
+		body := fmt.Sprintf(`{"email":"user@test.com","activation_token":%q,"new_passphrase":"correct horse battery staple"}`, code)
+		recorder := httptest.NewRecorder()
+		ActivateUser(recorder, httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user/activate", apiPrefix), strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	if code := activate("123456"); code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	if len(repo.user.ActivationCode) != 0 {
+		t.Fatalf("used activation code should be cleared. got %q", repo.user.ActivationCode)
+	}
+	if code := activate("123456"); code != http.StatusNotFound {
+		t.Errorf("replayed activation link should be refused. got %d", code)
+	}
+	if code := activate(""); code != http.StatusNotFound {
+		t.Errorf("empty activation token should not match the cleared code. got %d", code)
+	}
+}
@@ -0,0 +1,23 @@
+package endpoint
+
+import (
+	"context"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// OpaqueTokenRevocation is a RevocationRepository that also removes the opaque tokens of a revoked subject,
+// so the subject's tokens stop working immediately instead of when they expire.
+type OpaqueTokenRevocation struct {
+	connector.RevocationRepository
+	TokenFactory *helper.OpaqueTokenFactory
+}
+
+// Revoke a subject and all of its opaque tokens
+func (rev *OpaqueTokenRevocation) Revoke(ctx context.Context, subject string) error {
+	if err := rev.TokenFactory.RevokeSubject(subject); err != nil {
+		return err
+	}
+	return rev.RevocationRepository.Revoke(ctx, subject)
+}
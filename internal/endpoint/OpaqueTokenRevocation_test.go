@@ -0,0 +1,149 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeOpaqueTokenStore struct {
+	tokens map[string]*helper.HansipToken
+}
+
+func (store *fakeOpaqueTokenStore) SaveOpaqueToken(ctx context.Context, key string, token *helper.HansipToken) error {
+	store.tokens[key] = token
+	return nil
+}
+
+func (store *fakeOpaqueTokenStore) GetOpaqueToken(ctx context.Context, key string) (*helper.HansipToken, error) {
+	return store.tokens[key], nil
+}
+
+func (store *fakeOpaqueTokenStore) DeleteOpaqueToken(ctx context.Context, key string) error {
+	delete(store.tokens, key)
+	return nil
+}
+
+func (store *fakeOpaqueTokenStore) DeleteOpaqueTokensBySubject(ctx context.Context, subject string) error {
+	for token, hToken := range store.tokens {
+		if hToken.Subject == subject {
+			delete(store.tokens, token)
+		}
+	}
+	return nil
+}
+
+func (store *fakeOpaqueTokenStore) PurgeOpaqueTokensBefore(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestOpaqueTokenRevocation(t *testing.T) {
+	opaqueTokenFactory := helper.NewOpaqueTokenFactory(&fakeOpaqueTokenStore{tokens: make(map[string]*helper.HansipToken)}, config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	TokenFactory = opaqueTokenFactory
+	RevocationRepo = &OpaqueTokenRevocation{
+		RevocationRepository: &fakeRevocationRepo{revoked: make(map[string]bool)},
+		TokenFactory:         opaqueTokenFactory,
+	}
+	defer func() {
+		TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	}()
+
+	access, _, err := TokenFactory.CreateTokenPair("admin@hansip", []string{fmt.Sprintf("%s@hansip", config.Get("hansip.admin"))}, nil, helper.TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func() int {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/management/users", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+access)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("opaque token should be accepted. got %d", code)
+	}
+	if err := RevocationRepo.Revoke(context.Background(), "admin@hansip"); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(); code != http.StatusUnauthorized {
+		t.Errorf("revoked opaque token should be rejected immediately. got %d", code)
+	}
+}
+
+type failingOpaqueTokenStore struct {
+	fakeOpaqueTokenStore
+}
+
+func (store *failingOpaqueTokenStore) SaveOpaqueToken(ctx context.Context, key string, token *helper.HansipToken) error {
+	return fmt.Errorf("token store is down")
+}
+
+func TestAuthenticationOpaqueTokenStoreFailure(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewOpaqueTokenFactory(&failingOpaqueTokenStore{}, config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	defer func() {
+		TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	}()
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}}
+	RoleRepo = &regionRoleRepo{}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(`{"email":"user@acme.com","passphrase":"abcdefg"}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	Authentication(recorder, req)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("failing token store should fail the login. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestRefreshRevokedOpaqueToken(t *testing.T) {
+	opaqueTokenFactory := helper.NewOpaqueTokenFactory(&fakeOpaqueTokenStore{tokens: make(map[string]*helper.HansipToken)}, config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	TokenFactory = opaqueTokenFactory
+	RevocationRepo = &OpaqueTokenRevocation{
+		RevocationRepository: &fakeRevocationRepo{revoked: make(map[string]bool)},
+		TokenFactory:         opaqueTokenFactory,
+	}
+	defer func() {
+		TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	}()
+
+	_, refresh, err := TokenFactory.CreateTokenPair("user@acme.com", []string{"user@hansip"}, nil, helper.TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func() int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/refresh", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+refresh)
+		recorder := httptest.NewRecorder()
+		Refresh(recorder, req)
+		return recorder.Code
+	}
+
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("opaque refresh token should be refreshed. got %d", code)
+	}
+	if err := RevocationRepo.Revoke(context.Background(), "user@acme.com"); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(); code != http.StatusUnauthorized {
+		t.Errorf("revoked opaque refresh token should be refused with 401. got %d", code)
+	}
+}
@@ -0,0 +1,83 @@
+package endpoint
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	passphraseBreachLog = log.WithField("go", "PassphraseBreach")
+
+	// BreachCheckClient is the http client used to query the breached passphrase range API
+	BreachCheckClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// isPassphraseBreached check the passphrase against the Have I Been Pwned range API using k-anonymity.
+// Only the first 5 characters of the passphrase's SHA-1 hash are sent, the remaining suffix is matched locally.
+func isPassphraseBreached(ctx context.Context, passphrase string) (bool, error) {
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte(passphrase)))
+	prefix, suffix := hash[:5], hash[5:]
+	timeout, err := jiffy.DurationOf(config.Get("auth.password.breachcheck.timeout"))
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(config.Get("auth.password.breachcheck.url"), "/")+"/"+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// padding hides the size of the response, padded suffixes have zero count
+	req.Header.Set("Add-Padding", "true")
+	resp, err := BreachCheckClient.Do(req)
+	if err != nil {
+		return false, hansiperrors.OutboundError("breach check", timeout, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breach check responded %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(parts[1])
+		return err == nil && count > 0, nil
+	}
+	return false, scanner.Err()
+}
+
+// applyPassphraseBreachCheck rejects the passphrase found in known breaches when "auth.password.breachcheck" is enabled.
+// It writes the error response and returns false if the passphrase can not be used. The check fails open,
+// the passphrase is allowed when the breach check can not be made.
+func applyPassphraseBreachCheck(w http.ResponseWriter, r *http.Request, passphrase string) bool {
+	if !config.GetBoolean("auth.password.breachcheck") {
+		return true
+	}
+	fLog := passphraseBreachLog.WithField("func", "applyPassphraseBreachCheck").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	breached, err := isPassphraseBreached(r.Context(), passphrase)
+	if err != nil {
+		fLog.Warnf("breach check failed, passphrase is allowed. got %s", err.Error())
+		return true
+	}
+	if breached {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "passphrase has appeared in a data breach. please choose another passphrase", nil, nil)
+		return false
+	}
+	return true
+}
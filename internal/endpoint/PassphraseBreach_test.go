@@ -0,0 +1,85 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+)
+
+func TestPassphraseBreachCheck(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if r.URL.Path != "/range/5BAA6" {
+			fmt.Fprint(w, "0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n")
+			return
+		}
+		fmt.Fprint(w, "003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n")
+	}))
+	defer server.Close()
+	config.Set("auth.password.breachcheck", "true")
+	config.Set("auth.password.breachcheck.url", server.URL+"/range")
+	defer config.Set("auth.password.breachcheck", "false")
+	defer config.Set("auth.password.breachcheck.url", "https://api.pwnedpasswords.com/range")
+
+	check := func(passphrase string) int {
+		recorder := httptest.NewRecorder()
+		if applyPassphraseBreachCheck(recorder, httptest.NewRequest(http.MethodPost, "/", nil), passphrase) {
+			return http.StatusOK
+		}
+		return recorder.Code
+	}
+
+	if code := check("password"); code != http.StatusBadRequest {
+		t.Errorf("breached passphrase should be rejected. got %d", code)
+	}
+	if code := check("correct horse battery staple"); code != http.StatusOK {
+		t.Errorf("passphrase not in breach should be allowed. got %d", code)
+	}
+	for _, path := range requested {
+		if prefix := strings.TrimPrefix(path, "/range/"); len(prefix) != 5 {
+			t.Errorf("only the 5 characters hash prefix should be sent. got %s", path)
+		}
+	}
+
+	if breached, err := isPassphraseBreached(context.Background(), "password"); err != nil || !breached {
+		t.Errorf("expect password breached. got %v %v", breached, err)
+	}
+
+	server.Close()
+	if code := check("password"); code != http.StatusOK {
+		t.Errorf("breach check should fail open when the API is unreachable. got %d", code)
+	}
+}
+
+func TestPassphraseBreachCheckTimeout(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	config.Set("auth.password.breachcheck.url", server.URL+"/range")
+	config.Set("auth.password.breachcheck.timeout", "100 milliseconds")
+	defer config.Set("auth.password.breachcheck.url", "https://api.pwnedpasswords.com/range")
+	defer config.Set("auth.password.breachcheck.timeout", "5 seconds")
+
+	start := time.Now()
+	_, err := isPassphraseBreached(context.Background(), "password")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hanging breach check should be cut off at the timeout. took %s", elapsed)
+	}
+	timeoutErr := &hansiperrors.ErrOutboundTimeout{}
+	if !errors.As(err, &timeoutErr) || !timeoutErr.Temporary() {
+		t.Errorf("expect a temporary timeout error. got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	passphraseHistoryLog = log.WithField("go", "PassphraseHistory")
+)
+
+// IsPassphraseReused check whether the passphrase matches any of the bcrypt hashed passphrases.
+func IsPassphraseReused(passphrase string, hashedPassphrases []string) bool {
+	for _, hashed := range hashedPassphrases {
+		if len(hashed) > 0 && bcrypt.CompareHashAndPassword([]byte(hashed), []byte(passphrase)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePassphraseHistory returns ErrPassphraseReused if the new passphrase is the same as
+// the user's current passphrase or the previous ones, up to the last "auth.password.history" passphrases.
+func validatePassphraseHistory(ctx context.Context, user *connector.User, newPassphrase string) error {
+	history := config.GetInt("auth.password.history")
+	if history <= 0 {
+		return nil
+	}
+	hashed := []string{user.HashedPassphrase}
+	if PassphraseHistoryRepo != nil && history > 1 {
+		previous, err := PassphraseHistoryRepo.ListPassphraseHistory(ctx, user, history-1)
+		if err != nil {
+			return err
+		}
+		hashed = append(hashed, previous...)
+	}
+	if IsPassphraseReused(newPassphrase, hashed) {
+		return &hansiperrors.ErrPassphraseReused{History: history}
+	}
+	return nil
+}
+
+// recordPassphraseHistory keeps the user's replaced passphrase in the history.
+// Older history outside of the "auth.password.history" window is pruned.
+func recordPassphraseHistory(ctx context.Context, user *connector.User, previousHashedPassphrase string) error {
+	history := config.GetInt("auth.password.history")
+	if history <= 1 || PassphraseHistoryRepo == nil || len(previousHashedPassphrase) == 0 {
+		return nil
+	}
+	return PassphraseHistoryRepo.AddPassphraseHistory(ctx, user, previousHashedPassphrase, history-1)
+}
+
+// applyPassphraseHistory validates the new passphrase against the user's passphrase history. It writes the error
+// response and returns false if the new passphrase can not be used. The replaced passphrase is only recorded
+// into the history by recordPassphraseChange once the new passphrase is stored.
+func applyPassphraseHistory(w http.ResponseWriter, r *http.Request, user *connector.User, newPassphrase string) bool {
+	fLog := passphraseHistoryLog.WithField("func", "applyPassphraseHistory").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	err := validatePassphraseHistory(r.Context(), user, newPassphrase)
+	if err != nil {
+		reusedErr := &hansiperrors.ErrPassphraseReused{}
+		if errors.As(err, &reusedErr) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+			return false
+		}
+		fLog.Errorf("validatePassphraseHistory got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return false
+	}
+	return true
+}
+
+// validatePassphraseAge returns ErrPassphraseTooRecent if the user's passphrase was changed
+// more recently than "auth.password.minage". Empty minimum age disables the check.
+func validatePassphraseAge(ctx context.Context, user *connector.User) error {
+	if len(config.Get("auth.password.minage")) == 0 || PassphraseHistoryRepo == nil {
+		return nil
+	}
+	minAge, err := jiffy.DurationOf(config.Get("auth.password.minage"))
+	if err != nil {
+		return err
+	}
+	changedAt, err := PassphraseHistoryRepo.GetPassphraseChangedAt(ctx, user)
+	if err != nil {
+		return err
+	}
+	if changedAt != nil && time.Since(*changedAt) < minAge {
+		return &hansiperrors.ErrPassphraseTooRecent{MinAge: minAge, ChangedAt: *changedAt}
+	}
+	return nil
+}
+
+// applyPassphraseAge validates that the user's passphrase is old enough to be changed.
+// It writes the error response and returns false if the passphrase can not be changed yet.
+func applyPassphraseAge(w http.ResponseWriter, r *http.Request, user *connector.User) bool {
+	fLog := passphraseHistoryLog.WithField("func", "applyPassphraseAge").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	err := validatePassphraseAge(r.Context(), user)
+	if err != nil {
+		tooRecentErr := &hansiperrors.ErrPassphraseTooRecent{}
+		if errors.As(err, &tooRecentErr) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+			return false
+		}
+		fLog.Errorf("validatePassphraseAge got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return false
+	}
+	return true
+}
+
+// recordPassphraseChange records the replaced passphrase into the history and the time the user's passphrase changed,
+// once the new passphrase is stored. Failure to record is only logged as the passphrase is already changed.
+func recordPassphraseChange(ctx context.Context, user *connector.User, previousHashedPassphrase string) {
+	if PassphraseHistoryRepo == nil {
+		return
+	}
+	fLog := passphraseHistoryLog.WithField("func", "recordPassphraseChange").WithField("RequestID", ctx.Value(constants.RequestID))
+	if err := recordPassphraseHistory(ctx, user, previousHashedPassphrase); err != nil {
+		fLog.Errorf("recordPassphraseHistory got %s", err.Error())
+	}
+	err := PassphraseHistoryRepo.SetPassphraseChangedAt(ctx, user, time.Now())
+	if err != nil {
+		fLog.Errorf("PassphraseHistoryRepo.SetPassphraseChangedAt got %s", err.Error())
+	}
+}
@@ -0,0 +1,164 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakePassphraseHistoryRepo struct {
+	history   map[string][]string
+	changedAt map[string]time.Time
+}
+
+func (repo *fakePassphraseHistoryRepo) GetPassphraseChangedAt(ctx context.Context, user *connector.User) (*time.Time, error) {
+	if changedAt, ok := repo.changedAt[user.RecID]; ok {
+		return &changedAt, nil
+	}
+	return nil, nil
+}
+
+func (repo *fakePassphraseHistoryRepo) SetPassphraseChangedAt(ctx context.Context, user *connector.User, changedAt time.Time) error {
+	repo.changedAt[user.RecID] = changedAt
+	return nil
+}
+
+func (repo *fakePassphraseHistoryRepo) AddPassphraseHistory(ctx context.Context, user *connector.User, hashedPassphrase string, keep int) error {
+	history := append([]string{hashedPassphrase}, repo.history[user.RecID]...)
+	if len(history) > keep {
+		history = history[:keep]
+	}
+	repo.history[user.RecID] = history
+	return nil
+}
+
+func (repo *fakePassphraseHistoryRepo) ListPassphraseHistory(ctx context.Context, user *connector.User, limit int) ([]string, error) {
+	history := repo.history[user.RecID]
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+func changePassphrase(t *testing.T, user *connector.User, newPassphrase string) error {
+	err := validatePassphraseHistory(context.Background(), user, newPassphrase)
+	if err != nil {
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassphrase), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousHashed := user.HashedPassphrase
+	user.HashedPassphrase = string(hashed)
+	recordPassphraseChange(context.Background(), user, previousHashed)
+	return nil
+}
+
+func TestPassphraseHistory(t *testing.T) {
+	config.Set("auth.password.history", "3")
+	defer config.Set("auth.password.history", "0")
+	PassphraseHistoryRepo = &fakePassphraseHistoryRepo{history: make(map[string][]string), changedAt: make(map[string]time.Time)}
+	defer func() {
+		PassphraseHistoryRepo = nil
+	}()
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("first pass phrase"), bcrypt.MinCost)
+	user := &connector.User{RecID: "u1", HashedPassphrase: string(hashed)}
+
+	for _, pass := range []string{"second pass phrase", "third pass phrase"} {
+		if err := changePassphrase(t, user, pass); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// first, second and third are the last 3 passphrases
+	for _, pass := range []string{"first pass phrase", "second pass phrase", "third pass phrase"} {
+		err := changePassphrase(t, user, pass)
+		reusedErr := &hansiperrors.ErrPassphraseReused{}
+		if !errors.As(err, &reusedErr) {
+			t.Errorf("reusing %s should be rejected", pass)
+		}
+	}
+
+	if err := changePassphrase(t, user, "fourth pass phrase"); err != nil {
+		t.Fatal(err)
+	}
+	// first is now older than the window
+	if err := changePassphrase(t, user, "first pass phrase"); err != nil {
+		t.Errorf("passphrase older than the history window should be allowed. got %s", err.Error())
+	}
+}
+
+func TestPassphraseMinimumAge(t *testing.T) {
+	config.Set("auth.password.minage", "1 day")
+	defer config.Set("auth.password.minage", "")
+	repo := &fakePassphraseHistoryRepo{history: make(map[string][]string), changedAt: make(map[string]time.Time)}
+	PassphraseHistoryRepo = repo
+	defer func() {
+		PassphraseHistoryRepo = nil
+	}()
+	user := &connector.User{RecID: "u1"}
+
+	if err := validatePassphraseAge(context.Background(), user); err != nil {
+		t.Errorf("passphrase with no recorded change should be changeable. got %s", err.Error())
+	}
+
+	recordPassphraseChange(context.Background(), user, "")
+	err := validatePassphraseAge(context.Background(), user)
+	tooRecentErr := &hansiperrors.ErrPassphraseTooRecent{}
+	if !errors.As(err, &tooRecentErr) {
+		t.Errorf("changing passphrase too soon should be rejected")
+	}
+
+	repo.changedAt[user.RecID] = time.Now().Add(-25 * time.Hour)
+	if err := validatePassphraseAge(context.Background(), user); err != nil {
+		t.Errorf("passphrase older than the minimum age should be changeable. got %s", err.Error())
+	}
+}
+
+// failingUpdateUserRepo finds the user by the recovery token but fails to store the new passphrase
+type failingUpdateUserRepo struct {
+	connector.UserRepository
+	user *connector.User
+}
+
+func (repo *failingUpdateUserRepo) GetUserByRecoveryToken(ctx context.Context, token string) (*connector.User, error) {
+	return repo.user, nil
+}
+
+func (repo *failingUpdateUserRepo) UpdateUser(ctx context.Context, user *connector.User) error {
+	return fmt.Errorf("database is gone")
+}
+
+func TestPassphraseHistoryNotRecordedOnFailedUpdate(t *testing.T) {
+	config.Set("auth.password.history", "3")
+	defer config.Set("auth.password.history", "0")
+	repo := &fakePassphraseHistoryRepo{history: make(map[string][]string), changedAt: make(map[string]time.Time)}
+	PassphraseHistoryRepo = repo
+	defer func() {
+		PassphraseHistoryRepo = nil
+	}()
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("first pass phrase"), bcrypt.MinCost)
+	UserRepo = &failingUpdateUserRepo{user: &connector.User{RecID: "u1", HashedPassphrase: string(hashed)}}
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/recovery/resetPassphrase", apiPrefix), strings.NewReader(`{"passphraseResetToken":"abc","newPassphrase":"correct horse battery staple"}`))
+	recorder := httptest.NewRecorder()
+	ResetPassphrase(recorder, req)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expect the failed update reported. got %d", recorder.Code)
+	}
+	if len(repo.history["u1"]) != 0 || !repo.changedAt["u1"].IsZero() {
+		t.Errorf("passphrase history should not be recorded when the passphrase is not changed. got %v", repo.history["u1"])
+	}
+}
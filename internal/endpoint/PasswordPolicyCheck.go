@@ -0,0 +1,92 @@
+package endpoint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/passphrase"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RuleBreach is the rule that the passphrase has not appeared in a known data breach, checked when "auth.password.breachcheck" is enabled
+	RuleBreach = "breach"
+)
+
+var (
+	passwordPolicyLog = log.WithField("go", "PasswordPolicyCheck")
+)
+
+// PasswordPolicyCheckPath is the path of the password policy check endpoint
+func PasswordPolicyCheckPath() string {
+	return fmt.Sprintf("%s/auth/password-policy/check", apiPrefix)
+}
+
+// PasswordPolicyCheckRequest is the candidate passphrase to check
+type PasswordPolicyCheckRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// PasswordPolicyCheckResponse tells whether the candidate passphrase would be accepted, the outcome of every rule and its strength.
+type PasswordPolicyCheckResponse struct {
+	Valid       bool                     `json:"valid"`
+	Strength    int                      `json:"strength"`
+	MaxStrength int                      `json:"max_strength"`
+	Rules       []*passphrase.RuleResult `json:"rules"`
+}
+
+// checkPasswordPolicy runs the configured passphrase policy, and the breach check if it is enabled, against the passphrase.
+// The breach check fails open the same way it does when a passphrase is set.
+func checkPasswordPolicy(r *http.Request, candidate string) *PasswordPolicyCheckResponse {
+	fLog := passwordPolicyLog.WithField("func", "checkPasswordPolicy").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	rules := passphrase.Check(candidate, config.GetInt("security.passphrase.minchars"), config.GetInt("security.passphrase.minwords"), config.GetInt("security.passphrase.mincharsinword"))
+	if config.GetBoolean("auth.password.breachcheck") {
+		result := &passphrase.RuleResult{Rule: RuleBreach, Passed: true, Detail: "not found in known data breaches"}
+		breached, err := isPassphraseBreached(r.Context(), candidate)
+		if err != nil {
+			fLog.Warnf("breach check failed, passphrase is allowed. got %s", err.Error())
+			result.Detail = "breach check is not available"
+		} else if breached {
+			result.Passed = false
+			result.Detail = "has appeared in a data breach"
+		}
+		rules = append(rules, result)
+	}
+	resp := &PasswordPolicyCheckResponse{
+		Valid:       true,
+		Strength:    passphrase.Strength(candidate),
+		MaxStrength: passphrase.MaxStrength,
+		Rules:       rules,
+	}
+	for _, rule := range rules {
+		resp.Valid = resp.Valid && rule.Passed
+	}
+	return resp
+}
+
+// PasswordPolicyCheck serve checking a candidate passphrase against the passphrase policy, without creating or changing any user.
+func PasswordPolicyCheck(w http.ResponseWriter, r *http.Request) {
+	fLog := passwordPolicyLog.WithField("func", "PasswordPolicyCheck").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !config.GetBoolean("auth.password.check.enable") {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "password policy check is not enabled", nil, nil)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	req := &PasswordPolicyCheckRequest{}
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
+	if err != nil {
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passphrase checked", nil, checkPasswordPolicy(r, req.Passphrase))
+}
@@ -0,0 +1,99 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	check := func(body string) (int, *PasswordPolicyCheckResponse) {
+		req := httptest.NewRequest(http.MethodPost, PasswordPolicyCheckPath(), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		PasswordPolicyCheck(recorder, req)
+		response := &struct {
+			Data *PasswordPolicyCheckResponse `json:"data"`
+		}{}
+		_ = json.Unmarshal(recorder.Body.Bytes(), response)
+		return recorder.Code, response.Data
+	}
+	rulesOf := func(response *PasswordPolicyCheckResponse) map[string]bool {
+		ret := make(map[string]bool)
+		for _, rule := range response.Rules {
+			ret[rule.Rule] = rule.Passed
+		}
+		return ret
+	}
+
+	if code, _ := check(`{"passphrase":"correct horse battery staple"}`); code != http.StatusNotFound {
+		t.Fatalf("expect 404 when the check is disabled. got %d", code)
+	}
+	config.Set("auth.password.check.enable", "true")
+	defer config.Set("auth.password.check.enable", "false")
+
+	code, response := check(`{"passphrase":"correct horse battery staple"}`)
+	if code != http.StatusOK || response == nil {
+		t.Fatalf("expect 200. got %d", code)
+	}
+	if !response.Valid || len(response.Rules) != 3 || response.Strength < 3 || response.MaxStrength != 4 {
+		t.Errorf("expect a valid strong passphrase with 3 rules. got %+v", response)
+	}
+
+	code, response = check(`{"passphrase":"ab cd"}`)
+	if code != http.StatusOK || response == nil {
+		t.Fatalf("expect 200. got %d", code)
+	}
+	rules := rulesOf(response)
+	if response.Valid || rules["minchars"] || rules["minwords"] || rules["mincharsinword"] || response.Strength > 1 {
+		t.Errorf("expect a weak passphrase failing every rule. got %+v", response)
+	}
+
+	// the breach API never lists the checked passphrase
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n")
+	}))
+	defer server.Close()
+	config.Set("auth.password.breachcheck", "true")
+	config.Set("auth.password.breachcheck.url", server.URL+"/range")
+	defer config.Set("auth.password.breachcheck", "false")
+	defer config.Set("auth.password.breachcheck.url", "https://api.pwnedpasswords.com/range")
+
+	_, response = check(`{"passphrase":"correct horse battery staple"}`)
+	if rules := rulesOf(response); !response.Valid || !rules[RuleBreach] {
+		t.Errorf("expect the passphrase not found in breaches valid. got %+v", response)
+	}
+	server.Close()
+	_, response = check(`{"passphrase":"correct horse battery staple"}`)
+	if rules := rulesOf(response); !response.Valid || !rules[RuleBreach] {
+		t.Errorf("expect the breach check to fail open. got %+v", response)
+	}
+
+	if code, _ := check(`not json`); code != http.StatusBadRequest {
+		t.Errorf("expect 400 on invalid body. got %d", code)
+	}
+}
+
+func TestPasswordPolicyCheckRateLimit(t *testing.T) {
+	config.Set("auth.password.check.enable", "true")
+	defer config.Set("auth.password.check.enable", "false")
+	limits, err := ParseRouteRateLimits(PasswordPolicyCheckPath() + "=2/1 minute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewRateLimiter(limits, false).Middleware(http.HandlerFunc(PasswordPolicyCheck))
+	for i, expect := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, PasswordPolicyCheckPath(), strings.NewReader(`{"passphrase":"correct horse battery"}`))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != expect {
+			t.Errorf("request #%d expect %d. got %d", i+1, expect, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+var (
+	// nonFilterParameters are the list query parameters that are not counted as filter conditions
+	nonFilterParameters = map[string]bool{
+		"page_no":   true,
+		"page_size": true,
+		"order_by":  true,
+		"sort":      true,
+		"pretty":    true,
+		"fields":    true,
+		"cursor":    true,
+	}
+)
+
+// newPageRequest create the page request of a list end point and rejects queries exceeding the "api.query.*" limits,
+// that is a page larger than "api.query.maxpagesize", more filter conditions than "api.query.maxfilters",
+// more sort fields than "api.query.maxsortfields" or a page beyond "api.query.maxoffset". A zero limit is not checked.
+// The list is offset paginated, a cursor is rejected.
+func newPageRequest(r *http.Request) (*helper.PageRequest, error) {
+	if len(r.URL.Query().Get("cursor")) > 0 {
+		return nil, fmt.Errorf("cursor is not supported by this list, use page_no")
+	}
+	return parsePageRequest(r)
+}
+
+// newCursorPageRequest create the page request of a list end point supporting keyset pagination on the unique keyOrder field.
+// Without the "cursor" parameter it is the same as newPageRequest. With it, the page after the cursor position is requested,
+// the cursor must be signed by this hansip and the order_by and sort, if given, must be the ones of the cursor.
+func newCursorPageRequest(r *http.Request, keyOrder string) (*helper.PageRequest, error) {
+	pageRequest, err := parsePageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	queries := r.URL.Query()
+	token := queries.Get("cursor")
+	if len(token) == 0 {
+		return pageRequest, nil
+	}
+	if !config.GetBoolean("api.query.cursor.enabled") {
+		return nil, fmt.Errorf("cursor pagination is disabled, use page_no")
+	}
+	if len(queries.Get("page_no")) > 0 {
+		return nil, fmt.Errorf("cursor and page_no can not be used together")
+	}
+	cursor, err := helper.DecodePageCursor(pageCursorSecret(), token)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.OrderBy != keyOrder {
+		return nil, helper.ErrInvalidPageCursor
+	}
+	if len(queries.Get("order_by")) > 0 && !strings.EqualFold(queries.Get("order_by"), cursor.OrderBy) {
+		return nil, fmt.Errorf("order_by must be %s with this cursor", cursor.OrderBy)
+	}
+	if len(queries.Get("sort")) > 0 && pageRequest.Sort != cursor.Sort {
+		return nil, fmt.Errorf("sort must be %s with this cursor", cursor.Sort)
+	}
+	pageRequest.OrderBy = cursor.OrderBy
+	pageRequest.Sort = cursor.Sort
+	pageRequest.Cursor = cursor
+	return pageRequest, nil
+}
+
+// pageCursorSecret is the secret signing the page cursors, "api.query.cursor.key" or "token.crypt.key" when it is not set.
+func pageCursorSecret() string {
+	if secret := config.Get("api.query.cursor.key"); len(secret) > 0 {
+		return secret
+	}
+	return config.Get("token.crypt.key")
+}
+
+// setNextPageCursor sets the cursor of the page after the last listed item whose keyOrder field is key.
+// Only a list ordered by keyOrder has a next cursor, an offset paginated one included so a client can switch to cursors.
+func setNextPageCursor(pageRequest *helper.PageRequest, page *helper.Page, keyOrder, key string) error {
+	if !config.GetBoolean("api.query.cursor.enabled") || !page.HasNext || (len(pageRequest.OrderBy) > 0 && !strings.EqualFold(pageRequest.OrderBy, keyOrder)) {
+		return nil
+	}
+	token, err := helper.EncodePageCursor(pageCursorSecret(), &helper.PageCursor{OrderBy: keyOrder, Sort: pageRequest.Sort, Key: key})
+	if err != nil {
+		return err
+	}
+	page.NextCursor = token
+	return nil
+}
+
+// parsePageRequest create the page request and checks it against the "api.query.*" limits.
+func parsePageRequest(r *http.Request) (*helper.PageRequest, error) {
+	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	queries := r.URL.Query()
+	if pageRequest.No < 1 || pageRequest.PageSize < 1 || strings.HasPrefix(queries.Get("page_no"), "-") || strings.HasPrefix(queries.Get("page_size"), "-") {
+		return nil, fmt.Errorf("page_no and page_size must be positive")
+	}
+	if maxPageSize := config.GetInt("api.query.maxpagesize"); maxPageSize > 0 && pageRequest.PageSize > uint(maxPageSize) {
+		return nil, fmt.Errorf("page_size %d exceeds the maximum of %d", pageRequest.PageSize, maxPageSize)
+	}
+	if maxOffset := config.GetInt("api.query.maxoffset"); maxOffset > 0 && (pageRequest.No-1)*pageRequest.PageSize > uint(maxOffset) {
+		return nil, fmt.Errorf("page_no %d is beyond the maximum offset of %d items", pageRequest.No, maxOffset)
+	}
+	filters := 0
+	for key, values := range queries {
+		if !nonFilterParameters[key] {
+			filters += len(values)
+		}
+	}
+	if maxFilters := config.GetInt("api.query.maxfilters"); maxFilters > 0 && filters > maxFilters {
+		return nil, fmt.Errorf("query has %d filter conditions, exceeding the maximum of %d", filters, maxFilters)
+	}
+	if sortFields := len(strings.Split(pageRequest.OrderBy, ",")); len(pageRequest.OrderBy) > 0 {
+		if maxSortFields := config.GetInt("api.query.maxsortfields"); maxSortFields > 0 && sortFields > maxSortFields {
+			return nil, fmt.Errorf("order_by has %d fields, exceeding the maximum of %d", sortFields, maxSortFields)
+		}
+	}
+	switch strings.ToUpper(pageRequest.Sort) {
+	case "ASC", "DESC":
+		pageRequest.Sort = strings.ToUpper(pageRequest.Sort)
+	default:
+		return nil, fmt.Errorf("sort must be either ASC or DESC")
+	}
+	return pageRequest, nil
+}
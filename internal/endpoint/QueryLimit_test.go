@@ -0,0 +1,224 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func TestNewPageRequestLimits(t *testing.T) {
+	config.Set("api.query.maxpagesize", "50")
+	config.Set("api.query.maxfilters", "2")
+	config.Set("api.query.maxsortfields", "1")
+	config.Set("api.query.maxoffset", "200")
+	defer func() {
+		config.Set("api.query.maxpagesize", "1000")
+		config.Set("api.query.maxfilters", "10")
+		config.Set("api.query.maxsortfields", "1")
+		config.Set("api.query.maxoffset", "100000")
+	}()
+
+	testData := []struct {
+		query string
+		valid bool
+	}{
+		{"", true},
+		{"?page_no=2&page_size=50&order_by=email&sort=desc&pretty=true&fields=email", true},
+		{"?page_size=51", false},
+		{"?page_size=0", false},
+		{"?page_no=-1", false},
+		{"?page_no=5&page_size=50", true},
+		{"?page_no=6&page_size=50", false},
+		{"?enabled=true&domain=acme", true},
+		{"?enabled=true&domain=acme&domain=globex", false},
+		{"?order_by=email,last_login", false},
+		{"?sort=ASC%3BDROP%20TABLE%20HANSIP_USER", false},
+	}
+	for _, td := range testData {
+		pageRequest, err := newPageRequest(httptest.NewRequest(http.MethodGet, "/api/v1/management/users"+td.query, nil))
+		if td.valid && err != nil {
+			t.Errorf("query %q should be accepted. got %s", td.query, err.Error())
+		}
+		if !td.valid && err == nil {
+			t.Errorf("query %q should be rejected. got %v", td.query, pageRequest)
+		}
+	}
+
+	pageRequest, _ := newPageRequest(httptest.NewRequest(http.MethodGet, "/api/v1/management/users?sort=desc", nil))
+	if pageRequest.Sort != "DESC" {
+		t.Errorf("expect sort normalized to DESC. got %s", pageRequest.Sort)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/management/tenants?page_size=51", nil)
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@test.com",
+		Audience: []string{"admin@hansip"},
+	}))
+	recorder := httptest.NewRecorder()
+	ListAllTenants(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("list end point should reject the query with 400. got %d", recorder.Code)
+	}
+}
+
+// keysetUserRepo lists its users ordered by email the way the database connectors do, users may be created while listing.
+type keysetUserRepo struct {
+	connector.UserRepository
+	mutex sync.Mutex
+	users []*connector.User
+}
+
+func (repo *keysetUserRepo) CreateUserRecord(ctx context.Context, email, passphrase string) (*connector.User, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	user := &connector.User{RecID: fmt.Sprintf("id%d", len(repo.users)), Email: email}
+	repo.users = append(repo.users, user)
+	sort.Slice(repo.users, func(i, j int) bool { return repo.users[i].Email < repo.users[j].Email })
+	return user, nil
+}
+
+func (repo *keysetUserRepo) ListUser(ctx context.Context, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	if request.Cursor == nil {
+		page, start, end := pageBounds(request, len(repo.users))
+		return append([]*connector.User{}, repo.users[start:end]...), page, nil
+	}
+	after := make([]*connector.User, 0)
+	for _, user := range repo.users {
+		if user.Email > request.Cursor.Key {
+			after = append(after, user)
+		}
+	}
+	hasNext := uint(len(after)) > request.PageSize
+	if hasNext {
+		after = after[:request.PageSize]
+	}
+	return after, helper.NewCursorPage(request, uint(len(repo.users)), uint(len(after)), hasNext), nil
+}
+
+func listUsersPage(t *testing.T, query string) (int, []*SimpleUser, *helper.Page) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/management/users"+query, nil)
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@test.com",
+		Audience: []string{"admin@hansip"},
+	}))
+	recorder := httptest.NewRecorder()
+	ListAllUsers(recorder, req)
+	response := &struct {
+		Data struct {
+			Users []*SimpleUser `json:"users"`
+			Page  *helper.Page  `json:"page"`
+		} `json:"data"`
+	}{}
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code, response.Data.Users, response.Data.Page
+}
+
+func TestListUsersCursor(t *testing.T) {
+	repo := &keysetUserRepo{}
+	oldRepo := UserRepo
+	UserRepo = repo
+	defer func() { UserRepo = oldRepo }()
+	ctx := context.Background()
+	// the even users exist before paging, the odd ones are created while paging, before and after the cursor
+	for i := 0; i < 200; i += 2 {
+		repo.CreateUserRecord(ctx, fmt.Sprintf("user%03d@acme.com", i), "")
+	}
+
+	created := make(chan bool)
+	go func() {
+		defer close(created)
+		for i := 1; i < 200; i += 2 {
+			repo.CreateUserRecord(ctx, fmt.Sprintf("user%03d@acme.com", (i*37)%200), "")
+			created <- true
+		}
+	}()
+	seen := make(map[string]bool)
+	previous := ""
+	query := "?page_size=9"
+	for pages := 0; ; pages++ {
+		// at least one user is created between pages while the creation lasts
+		<-created
+		code, users, page := listUsersPage(t, query)
+		if code != http.StatusOK {
+			t.Fatalf("page %d with %s should be listed. got %d", pages, query, code)
+		}
+		for _, user := range users {
+			if seen[user.Email] || user.Email <= previous {
+				t.Errorf("page %d: %s listed twice or out of order after %s", pages, user.Email, previous)
+			}
+			seen[user.Email] = true
+			previous = user.Email
+		}
+		if !page.HasNext {
+			if len(page.NextCursor) > 0 {
+				t.Errorf("the last page should have no next cursor")
+			}
+			break
+		}
+		if len(page.NextCursor) == 0 {
+			t.Fatalf("page %d should have the next cursor", pages)
+		}
+		query = "?page_size=9&cursor=" + url.QueryEscape(page.NextCursor)
+	}
+	for range created {
+	}
+	for i := 0; i < 200; i += 2 {
+		if email := fmt.Sprintf("user%03d@acme.com", i); !seen[email] {
+			t.Errorf("%s existing before paging is skipped", email)
+		}
+	}
+
+	_, _, page := listUsersPage(t, "?page_size=9")
+	cursor, err := helper.DecodePageCursor(pageCursorSecret(), page.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(&helper.PageCursor{OrderBy: cursor.OrderBy, Sort: cursor.Sort, Key: "user150@acme.com"})
+	forged := base64.RawURLEncoding.EncodeToString(payload) + page.NextCursor[strings.Index(page.NextCursor, "."):]
+	for _, query := range []string{
+		"?cursor=" + url.QueryEscape(forged),
+		"?cursor=garbage",
+		"?page_no=2&cursor=" + url.QueryEscape(page.NextCursor),
+		"?sort=desc&cursor=" + url.QueryEscape(page.NextCursor),
+		"?order_by=last_login&cursor=" + url.QueryEscape(page.NextCursor),
+	} {
+		if code, _, _ := listUsersPage(t, query); code != http.StatusBadRequest {
+			t.Errorf("query %s should be rejected with 400. got %d", query, code)
+		}
+	}
+	if code, _, page := listUsersPage(t, "?order_by=last_login"); code != http.StatusOK || len(page.NextCursor) > 0 {
+		t.Errorf("a list not ordered by email should have no cursor. got %d %q", code, page.NextCursor)
+	}
+	if _, err := newPageRequest(httptest.NewRequest(http.MethodGet, "/api/v1/management/tenants?cursor="+url.QueryEscape(page.NextCursor), nil)); err == nil {
+		t.Errorf("a list without keyset pagination should reject the cursor")
+	}
+
+	config.Set("api.query.cursor.enabled", "false")
+	defer config.Set("api.query.cursor.enabled", "true")
+	if code, _, _ := listUsersPage(t, "?cursor="+url.QueryEscape(page.NextCursor)); code != http.StatusBadRequest {
+		t.Errorf("cursor should be rejected when disabled. got %d", code)
+	}
+	if _, _, page := listUsersPage(t, "?page_size=9"); len(page.NextCursor) > 0 {
+		t.Errorf("no cursor should be given when disabled")
+	}
+}
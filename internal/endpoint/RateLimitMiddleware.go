@@ -0,0 +1,227 @@
+package endpoint
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+)
+
+const (
+	// RateLimitLimitHeader is the IETF header of the request quota of the route
+	RateLimitLimitHeader = "RateLimit-Limit"
+
+	// RateLimitRemainingHeader is the IETF header of the requests the client may still make
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+
+	// RateLimitResetHeader is the IETF header of the seconds until the client's quota is fully restored
+	RateLimitResetHeader = "RateLimit-Reset"
+)
+
+// RouteRateLimit is a request rate limit for all path starting with PathPrefix, Limit requests per Window for each client.
+type RouteRateLimit struct {
+	PathPrefix string
+	Limit      int
+	Window     time.Duration
+}
+
+// ParseRouteRateLimits parses the per route rate limit configuration.
+// Routes are separated by ";", each route is a path prefix followed by "=", the number of requests, "/" and a duration,
+// eg. "/api/v1/auth=10/1 minute;/api/v1/recovery=5/1 hour".
+func ParseRouteRateLimits(spec string) ([]*RouteRateLimit, error) {
+	ret := make([]*RouteRateLimit, 0)
+	for _, item := range strings.Split(spec, ";") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		idx := strings.LastIndex(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("route rate limit %s has no limit", item)
+		}
+		prefix := strings.TrimSpace(item[:idx])
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route rate limit path prefix %s must start with /", prefix)
+		}
+		rate := strings.SplitN(item[idx+1:], "/", 2)
+		if len(rate) != 2 {
+			return nil, fmt.Errorf("route rate limit %s must be in the form of requests/duration", item)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(rate[0]))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("route rate limit %s must have a positive number of requests", item)
+		}
+		window, err := jiffy.DurationOf(strings.TrimSpace(rate[1]))
+		if err != nil {
+			return nil, fmt.Errorf("route rate limit %s has invalid duration. got %s", item, err.Error())
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("route rate limit %s must have a positive duration", item)
+		}
+		ret = append(ret, &RouteRateLimit{PathPrefix: prefix, Limit: limit, Window: window})
+	}
+	return ret, nil
+}
+
+// tokenBucket holds up to limit tokens and is refilled continuously with limit tokens per window.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter limits the requests of each client to the routes, using a token bucket per client and route.
+type RateLimiter struct {
+	Routes []*RouteRateLimit
+	// Headers emits the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers on the rate limited routes.
+	Headers bool
+	// Client tells the clients apart, each client has its own buckets
+	Client func(r *http.Request) string
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// NewRateLimiter create new instance of RateLimiter, limiting each client IP address.
+func NewRateLimiter(routes []*RouteRateLimit, headers bool) *RateLimiter {
+	return &RateLimiter{
+		Routes:  routes,
+		Headers: headers,
+		Client:  clientIP,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// NewUserRateLimiter create new instance of RateLimiter, limiting each authenticated user by their token subject
+// and each unauthenticated client by its IP address. Its middleware must be placed after the JwtMiddleware.
+func NewUserRateLimiter(routes []*RouteRateLimit, headers bool) *RateLimiter {
+	limiter := NewRateLimiter(routes, headers)
+	limiter.Client = userOrClientIP
+	return limiter
+}
+
+// userOrClientIP returns the token subject of the authenticated user, otherwise the IP address of the client.
+// The anonymous callers of the public endpoints are told apart by their IP address, not sharing a single budget.
+func userOrClientIP(r *http.Request) string {
+	if authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext); ok && authCtx != nil && len(authCtx.Subject) > 0 && authCtx.Subject != anonymousSubject {
+		return "user:" + authCtx.Subject
+	}
+	return "ip:" + clientIP(r)
+}
+
+// take refills the client's bucket of the route and takes a token from it if there is one.
+// It returns whether the request is allowed and the tokens left in the bucket.
+func (rl *RateLimiter) take(route *RouteRateLimit, client string) (bool, float64) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	now := rl.now()
+	rate := float64(route.Limit) / route.Window.Seconds()
+	key := route.PathPrefix + "|" + client
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(route.Limit), updated: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(route.Limit), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, bucket.tokens
+	}
+	bucket.tokens--
+	return true, bucket.tokens
+}
+
+// StartPurge removes the full buckets every PurgeInterval until stop is closed,
+// so the buckets of the clients gone are not kept forever.
+func (rl *RateLimiter) StartPurge(stop <-chan bool) {
+	ticker := time.NewTicker(rl.PurgeInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rl.mutex.Lock()
+			rl.purge(rl.now())
+			rl.mutex.Unlock()
+		}
+	}
+}
+
+// PurgeInterval returns the shortest route window, but at least a second. A bucket not used for its route window is full.
+func (rl *RateLimiter) PurgeInterval() time.Duration {
+	interval := time.Duration(0)
+	for _, route := range rl.Routes {
+		if interval == 0 || route.Window < interval {
+			interval = route.Window
+		}
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// purge removes the buckets that are full by now, they are the same as a new bucket.
+func (rl *RateLimiter) purge(now time.Time) {
+	for key, bucket := range rl.buckets {
+		route := rl.routeOf(key[:strings.Index(key, "|")])
+		if route == nil || bucket.tokens+now.Sub(bucket.updated).Seconds()*float64(route.Limit)/route.Window.Seconds() >= float64(route.Limit) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+func (rl *RateLimiter) routeOf(prefix string) *RouteRateLimit {
+	for _, route := range rl.Routes {
+		if route.PathPrefix == prefix {
+			return route
+		}
+	}
+	return nil
+}
+
+// matchRoute returns the route of the longest matching path prefix, nil if the path is not rate limited.
+func (rl *RateLimiter) matchRoute(path string) *RouteRateLimit {
+	var matched *RouteRateLimit
+	for _, route := range rl.Routes {
+		if strings.HasPrefix(path, route.PathPrefix) && (matched == nil || len(route.PathPrefix) > len(matched.PathPrefix)) {
+			matched = route
+		}
+	}
+	return matched
+}
+
+// Middleware is the http middleware function. It must be placed after the ClientIPResolverMiddleware so
+// the clients are told apart by their real IP address.
+// Request exceeding its route limit is responded with 429 Too Many Requests and a Retry-After header.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := rl.matchRoute(r.URL.Path)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, tokens := rl.take(route, rl.Client(r))
+		secondsPerToken := route.Window.Seconds() / float64(route.Limit)
+		if rl.Headers {
+			w.Header().Set(RateLimitLimitHeader, strconv.Itoa(route.Limit))
+			w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(int(math.Floor(tokens))))
+			w.Header().Set(RateLimitResetHeader, strconv.Itoa(int(math.Ceil((float64(route.Limit)-tokens)*secondsPerToken))))
+		}
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)*secondsPerToken))))
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusTooManyRequests, "Too many requests, retry later", nil, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
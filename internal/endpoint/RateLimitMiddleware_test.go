@@ -0,0 +1,179 @@
+package endpoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+)
+
+func TestParseRouteRateLimits(t *testing.T) {
+	routes, err := ParseRouteRateLimits("/api/v1/auth=10/1 minute; /api/v1/recovery = 5/1 hour")
+	if err != nil {
+		t.Fatalf("valid rate limits got %s", err.Error())
+	}
+	if len(routes) != 2 || routes[0].Limit != 10 || routes[0].Window != time.Minute || routes[1].PathPrefix != "/api/v1/recovery" || routes[1].Window != time.Hour {
+		t.Errorf("rate limits are not parsed correctly. got %+v %+v", routes[0], routes[1])
+	}
+	for _, invalid := range []string{"/api/v1/auth", "api/v1/auth=10/1 minute", "/api/v1/auth=10", "/api/v1/auth=0/1 minute", "/api/v1/auth=10/soon"} {
+		if _, err := ParseRouteRateLimits(invalid); err == nil {
+			t.Errorf("rate limit %q should be invalid", invalid)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter([]*RouteRateLimit{{PathPrefix: "/api/v1/auth", Limit: 3, Window: 30 * time.Second}}, true)
+	limiter.now = func() time.Time {
+		return now
+	}
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	expect := func(recorder *httptest.ResponseRecorder, code int, remaining, reset string) {
+		t.Helper()
+		if recorder.Code != code {
+			t.Errorf("expect %d. got %d", code, recorder.Code)
+		}
+		if recorder.Header().Get(RateLimitLimitHeader) != "3" {
+			t.Errorf("expect %s 3. got %q", RateLimitLimitHeader, recorder.Header().Get(RateLimitLimitHeader))
+		}
+		if recorder.Header().Get(RateLimitRemainingHeader) != remaining {
+			t.Errorf("expect %s %s. got %q", RateLimitRemainingHeader, remaining, recorder.Header().Get(RateLimitRemainingHeader))
+		}
+		if recorder.Header().Get(RateLimitResetHeader) != reset {
+			t.Errorf("expect %s %s. got %q", RateLimitResetHeader, reset, recorder.Header().Get(RateLimitResetHeader))
+		}
+	}
+
+	// a token is restored every 10 seconds.
+	expect(call("/api/v1/auth/authenticate", "10.0.0.1:5000"), http.StatusOK, "2", "10")
+	expect(call("/api/v1/auth/authenticate", "10.0.0.1:5001"), http.StatusOK, "1", "20")
+	expect(call("/api/v1/auth/refresh", "10.0.0.1:5002"), http.StatusOK, "0", "30")
+	limited := call("/api/v1/auth/authenticate", "10.0.0.1:5003")
+	expect(limited, http.StatusTooManyRequests, "0", "30")
+	if limited.Header().Get("Retry-After") != "10" {
+		t.Errorf("expect Retry-After 10. got %q", limited.Header().Get("Retry-After"))
+	}
+
+	expect(call("/api/v1/auth/authenticate", "10.0.0.2:5000"), http.StatusOK, "2", "10")
+
+	now = now.Add(15 * time.Second)
+	expect(call("/api/v1/auth/authenticate", "10.0.0.1:5004"), http.StatusOK, "0", "25")
+	limited = call("/api/v1/auth/authenticate", "10.0.0.1:5005")
+	expect(limited, http.StatusTooManyRequests, "0", "25")
+	if limited.Header().Get("Retry-After") != "5" {
+		t.Errorf("expect Retry-After 5. got %q", limited.Header().Get("Retry-After"))
+	}
+
+	free := call("/api/v1/management/users", "10.0.0.1:5006")
+	if free.Code != http.StatusOK || len(free.Header().Get(RateLimitLimitHeader)) > 0 {
+		t.Errorf("route without rate limit should not be limited. got %d %v", free.Code, free.Header())
+	}
+}
+
+func TestUserRateLimit(t *testing.T) {
+	limiter := NewUserRateLimiter([]*RouteRateLimit{{PathPrefix: "/api/v1/management", Limit: 2, Window: time.Minute}}, false)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	// every caller is behind the same NAT
+	call := func(subject string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/management/users", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		if len(subject) > 0 {
+			req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{Subject: subject}))
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := call("alice@acme.com"); code != http.StatusOK {
+			t.Errorf("expect alice's request #%d allowed. got %d", i+1, code)
+		}
+	}
+	if code := call("alice@acme.com"); code != http.StatusTooManyRequests {
+		t.Errorf("expect alice's budget exhausted. got %d", code)
+	}
+	// bob shares the IP of alice but has a budget of their own
+	for i := 0; i < 2; i++ {
+		if code := call("bob@acme.com"); code != http.StatusOK {
+			t.Errorf("expect bob's request #%d allowed. got %d", i+1, code)
+		}
+	}
+	if code := call("bob@acme.com"); code != http.StatusTooManyRequests {
+		t.Errorf("expect bob's budget exhausted. got %d", code)
+	}
+	// unauthenticated requests fall back to the IP, with a budget of their own
+	for i := 0; i < 2; i++ {
+		if code := call(""); code != http.StatusOK {
+			t.Errorf("expect anonymous request #%d allowed. got %d", i+1, code)
+		}
+	}
+	if code := call(""); code != http.StatusTooManyRequests {
+		t.Errorf("expect the IP budget exhausted. got %d", code)
+	}
+}
+
+func TestUserRateLimitPublicRoute(t *testing.T) {
+	limiter := NewUserRateLimiter([]*RouteRateLimit{{PathPrefix: apiPrefix + "/auth", Limit: 2, Window: time.Minute}}, false)
+	handler := JwtMiddleware(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	call := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, apiPrefix+"/auth/authenticate", nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := call("203.0.113.7:5000"); code != http.StatusOK {
+			t.Errorf("expect the first client's request #%d allowed. got %d", i+1, code)
+		}
+	}
+	if code := call("203.0.113.7:5000"); code != http.StatusTooManyRequests {
+		t.Errorf("expect the first client's budget exhausted. got %d", code)
+	}
+	// the anonymous callers do not share a budget
+	if code := call("198.51.100.9:5000"); code != http.StatusOK {
+		t.Errorf("expect another client allowed. got %d", code)
+	}
+}
+
+func TestRateLimitPurge(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter([]*RouteRateLimit{
+		{PathPrefix: "/api/v1/auth", Limit: 2, Window: time.Minute},
+		{PathPrefix: "/api/v1/recovery", Limit: 5, Window: time.Hour},
+	}, false)
+	limiter.now = func() time.Time {
+		return now
+	}
+	if interval := limiter.PurgeInterval(); interval != time.Minute {
+		t.Errorf("expect the shortest route window as purge interval. got %s", interval)
+	}
+	limiter.take(limiter.Routes[0], "10.0.0.1")
+	now = now.Add(45 * time.Second)
+	limiter.take(limiter.Routes[0], "10.0.0.2")
+	now = now.Add(15 * time.Second)
+	limiter.purge(now)
+	if _, ok := limiter.buckets["/api/v1/auth|10.0.0.1"]; ok || len(limiter.buckets) != 1 {
+		t.Errorf("expect only the bucket that is not full yet kept. got %v", limiter.buckets)
+	}
+}
@@ -0,0 +1,96 @@
+package endpoint
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// readinessTimeout is the maximum time each component check may take.
+	readinessTimeout = 5 * time.Second
+)
+
+var (
+	readinessLog = log.WithField("go", "Readiness")
+
+	// draining is set when the server is about to shut down.
+	draining atomic.Bool
+)
+
+// SetDraining marks the server as draining before it shuts down, so Ready reports it unavailable
+// and load balancers stop sending it new requests.
+func SetDraining(drain bool) {
+	draining.Store(drain)
+}
+
+// IsDraining returns true if the server is draining before it shuts down.
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// Ready serve readiness check request. It checks the database and, if "server.health.checkmailer" is enabled, the mailer.
+// It responds with 503 Service Unavailable if any of the checked component is failing, or if the server is draining.
+func Ready(w http.ResponseWriter, r *http.Request) {
+	hc := &helper.HealthCheck{}
+	if IsDraining() {
+		hc.AddDetail(&helper.HealthDetail{
+			DetailKey:     "server",
+			ComponentID:   "server",
+			ComponentType: "system",
+			Time:          time.Now(),
+			Status:        helper.StatusFail,
+		})
+	}
+	hc.AddDetail(checkComponent(r.Context(), "database", "datastore", UserRepo))
+	if config.GetBoolean("server.health.checkmailer") {
+		hc.AddDetail(checkComponent(r.Context(), "mailer", "system", EmailSender))
+	}
+	body := hc.String()
+	w.Header().Add("cache-control", "no-cache")
+	w.Header().Add("Content-Type", "application/json")
+	if hc.Status == helper.StatusFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.Write([]byte(body))
+}
+
+// checkComponent checks the component if it implements connector.HealthChecker,
+// component that can not be checked is reported as warn.
+func checkComponent(ctx context.Context, key, componentType string, component interface{}) *helper.HealthDetail {
+	fLog := readinessLog.WithField("func", "checkComponent").WithField("RequestID", ctx.Value(constants.RequestID)).WithField("component", key)
+	detail := &helper.HealthDetail{
+		DetailKey:     key,
+		ComponentID:   key,
+		ComponentType: componentType,
+		MetricUnit:    "ms",
+		Time:          time.Now(),
+		Status:        helper.StatusWarn,
+	}
+	checker, ok := component.(connector.HealthChecker)
+	if !ok {
+		fLog.Warnf("component can not be checked")
+		return detail
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	start := time.Now()
+	err := checker.CheckHealth(checkCtx)
+	detail.MetricValue = int(time.Since(start).Milliseconds())
+	if err != nil {
+		fLog.Errorf("CheckHealth got %s", err.Error())
+		detail.Status = helper.StatusFail
+		return detail
+	}
+	detail.Status = helper.StatusPass
+	return detail
+}
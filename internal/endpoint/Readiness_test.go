@@ -0,0 +1,78 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type stubHealthyUserRepo struct {
+	connector.UserRepository
+}
+
+func (repo *stubHealthyUserRepo) CheckHealth(ctx context.Context) error {
+	return nil
+}
+
+type stubMailer struct {
+	err error
+}
+
+func (sender *stubMailer) SendEmail(ctx context.Context, to, cc, bcc []string, from, fromName, subject, body string) error {
+	return sender.err
+}
+
+func (sender *stubMailer) CheckHealth(ctx context.Context) error {
+	return sender.err
+}
+
+func TestReadyMailer(t *testing.T) {
+	UserRepo = &stubHealthyUserRepo{}
+	EmailSender = &stubMailer{}
+	defer func() {
+		EmailSender = nil
+	}()
+
+	detail := checkComponent(context.Background(), "mailer", "system", EmailSender)
+	if detail.Status != helper.StatusPass {
+		t.Errorf("healthy mailer should pass. got %s", detail.Status)
+	}
+
+	EmailSender = &stubMailer{err: fmt.Errorf("connection refused")}
+	detail = checkComponent(context.Background(), "mailer", "system", EmailSender)
+	if detail.Status != helper.StatusFail {
+		t.Errorf("unhealthy mailer should fail. got %s", detail.Status)
+	}
+
+	// mailer check is disabled by default, unhealthy mailer does not affect readiness.
+	recorder := httptest.NewRecorder()
+	Ready(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expect 200 but %d", recorder.Code)
+	}
+	hc := &helper.HealthCheck{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), hc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hc.Details["mailer"]; ok {
+		t.Errorf("mailer should not be reported when the check is disabled")
+	}
+	if hc.Details["database"] == nil || hc.Details["database"].Status != helper.StatusPass {
+		t.Errorf("database should be reported as pass")
+	}
+
+	config.Set("server.health.checkmailer", "true")
+	defer config.Set("server.health.checkmailer", "false")
+	recorder = httptest.NewRecorder()
+	Ready(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect 503 when mailer check is enabled and failing. got %d", recorder.Code)
+	}
+}
@@ -39,7 +39,7 @@ func RecoverPassphrase(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
-	user, err := UserRepo.GetUserByEmail(r.Context(), req.Email)
+	user, err := getUserByEmail(r.Context(), req.Email)
 	if err != nil {
 		fLog.Errorf("UserRepo.GetUserByEmail got %s", err.Error())
 		// send fake success
@@ -62,7 +62,8 @@ func RecoverPassphrase(w http.ResponseWriter, r *http.Request) {
 		Cc:       nil,
 		Bcc:      nil,
 		Template: "PASSPHRASE_RECOVERY",
-		Data:     user,
+		Locale:   requestLocale(r),
+		Data:     mailData(r.Context(), user),
 	})
 
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Check your email", nil, nil)
@@ -97,7 +98,16 @@ func ResetPassphrase(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid passphrase", nil, invalidMsg)
 		return
 	}
+	if !applyPassphraseBreachCheck(w, r, req.NewPassphrase) {
+		return
+	}
 
+	// a used recovery code is cleared, it must never match the empty code.
+	if len(req.ResetToken) == 0 {
+		// send fake response
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Check your email", nil, nil)
+		return
+	}
 	user, err := UserRepo.GetUserByRecoveryToken(r.Context(), req.ResetToken)
 	if err != nil {
 		fLog.Errorf("UserRepo.GetUserByRecoveryToken got %s", err.Error())
@@ -110,13 +120,26 @@ func ResetPassphrase(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Check your email", nil, nil)
 		return
 	}
+	if !applyPassphraseHistory(w, r, user, req.NewPassphrase) {
+		return
+	}
 	pass, err := bcrypt.GenerateFromPassword([]byte(req.NewPassphrase), 14)
 	if err != nil {
 		fLog.Errorf("bcrypt.GenerateFromPassword got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
+	previousHashed := user.HashedPassphrase
 	user.HashedPassphrase = string(pass)
-	UserRepo.UpdateUser(r.Context(), user)
+	// the reset link is used once
+	user.RecoveryCode = ""
+	err = UserRepo.UpdateUser(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("UserRepo.UpdateUser got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	recordPassphraseChange(r.Context(), user, previousHashed)
+	emitSecurityEvent(r, SecurityEventPassphraseReset, user.Email, user, "passphrase reset with recovery code")
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passphrase changed", nil, nil)
 }
@@ -0,0 +1,37 @@
+package endpoint
+
+import (
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	rememberMeLog = log.WithField("go", "RememberMe")
+)
+
+// rememberMeRefreshAge returns the refresh token lifetime of a login with the remember me flag.
+// A remembered login gets "token.refresh.remember.duration", a login on a shared device gets the shorter
+// "token.refresh.session.duration". Roles of the audience having their own refresh duration cap the lifetime,
+// so remembering never extends the session of a privileged role.
+// Zero is returned when the flag is omitted, the token factory then uses the default refresh token lifetime.
+func rememberMeRefreshAge(audience []string, rememberMe *bool) time.Duration {
+	if rememberMe == nil {
+		return 0
+	}
+	key := "token.refresh.session.duration"
+	if *rememberMe {
+		key = "token.refresh.remember.duration"
+	}
+	age, err := jiffy.DurationOf(config.Get(key))
+	if err != nil || age <= 0 {
+		rememberMeLog.WithField("func", "rememberMeRefreshAge").Warnf("%s %q is not a valid duration. the default refresh token duration is used", key, config.Get(key))
+		return 0
+	}
+	if _, roleRefreshAge := RoleTokenDurations(audience); roleRefreshAge > 0 && roleRefreshAge < age {
+		return roleRefreshAge
+	}
+	return age
+}
@@ -0,0 +1,74 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRememberMeRefreshDuration(t *testing.T) {
+	config.Set("token.refresh.remember.duration", "30 days")
+	config.Set("token.refresh.session.duration", "8 hours")
+	defer config.Set("token.refresh.remember.duration", "1 year")
+	defer config.Set("token.refresh.session.duration", "1 day")
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, 7*24*time.Hour)
+	TokenFactory.(*helper.DefaultTokenFactory).DurationResolver = RoleTokenDurations
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}}
+	RoleRepo = &regionRoleRepo{}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	refreshDuration := func(rememberMe string) time.Duration {
+		body := `{"email":"user@acme.com","passphrase":"abcdefg"` + rememberMe + `}`
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+		}
+		response := &struct {
+			Data *Response `json:"data"`
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		tok, err := TokenFactory.ReadToken(response.Data.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Until(tok.Expire).Round(time.Minute)
+	}
+
+	if duration := refreshDuration(`,"remember_me":true`); duration != 30*24*time.Hour {
+		t.Errorf("remembered login should get a 30 days refresh token. got %s", duration)
+	}
+	if duration := refreshDuration(`,"remember_me":false`); duration != 8*time.Hour {
+		t.Errorf("not remembered login should get a 8 hours refresh token. got %s", duration)
+	}
+	if duration := refreshDuration(``); duration != 7*24*time.Hour {
+		t.Errorf("login without remember_me should get the default refresh token. got %s", duration)
+	}
+
+	config.Set("token.role.user.refresh.duration", "2 days")
+	defer config.Set("token.role.user.refresh.duration", "")
+	if duration := refreshDuration(`,"remember_me":true`); duration != 48*time.Hour {
+		t.Errorf("role refresh duration should cap the remembered login. got %s", duration)
+	}
+	if duration := refreshDuration(`,"remember_me":false`); duration != 8*time.Hour {
+		t.Errorf("shorter not remembered login should not be extended by the role. got %s", duration)
+	}
+}
@@ -0,0 +1,144 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	resendVerificationLog = log.WithField("go", "ResendVerification")
+)
+
+// ResendVerificationRequest hold the email of the user asking for another verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// awaitsVerification check whether the user never verified the email and is waiting to be activated.
+// A verified user disabled by an admin, or a suspended user, is not sent a new activation code that would enable it again.
+func awaitsVerification(ctx context.Context, user *connector.User) (bool, error) {
+	if user.Enabled || user.Suspended {
+		return false, nil
+	}
+	verified, err := UserRepo.IsEmailVerified(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	return !verified, nil
+}
+
+// resendVerification replaces the activation code of the unverified user and sends it in a new verification email,
+// the code of the previous emails no longer activates the user.
+func resendVerification(ctx context.Context, user *connector.User, locale string) error {
+	user.ActivationCode = helper.MakeRandomString(6, true, false, false, false)
+	err := UserRepo.UpdateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	mailer.Send(ctx, &mailer.Email{
+		From:     config.Get("mailer.from"),
+		FromName: config.Get("mailer.from.name"),
+		To:       []string{user.Email},
+		Cc:       nil,
+		Bcc:      nil,
+		Template: "EMAIL_VERIFY",
+		Locale:   locale,
+		Data:     mailData(ctx, user),
+	})
+	return nil
+}
+
+// ResendUserVerification serving request of the admin to send another verification email to an unverified user.
+// Nothing is sent to a verified user, even if disabled, nor to a suspended user.
+func ResendUserVerification(w http.ResponseWriter, r *http.Request) {
+	fLog := resendVerificationLog.WithField("func", "ResendUserVerification").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/user/{userRecId}/resend-verification", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	user, err := UserRepo.GetUserByRecID(r.Context(), params["userRecId"])
+	if err != nil {
+		fLog.Errorf("UserRepo.GetUserByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if user == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
+		return
+	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
+	awaits, err := awaitsVerification(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("awaitsVerification got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !awaits {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "User already verified or not awaiting activation, no email sent", nil, nil)
+		return
+	}
+	// the current code is kept if the new one can not be delivered
+	if mailer.Limiter.Exceeded(user.Email) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusTooManyRequests, fmt.Sprintf("%s received %d emails in the last hour, retry later", user.Email, mailer.Limiter.Limit), nil, nil)
+		return
+	}
+	err = resendVerification(r.Context(), user, "")
+	if err != nil {
+		fLog.Errorf("resendVerification got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Verification email sent", nil, nil)
+}
+
+// ResendVerification serving request of a user who lost the verification email to send another one.
+// The response is the same whether the email belongs to an unverified user or not, so it tells nothing about the accounts.
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	fLog := resendVerificationLog.WithField("func", "ResendVerification").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !config.GetBoolean("mailer.verification.resend.enable") {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "verification resend is not enabled", nil, nil)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	req := &ResendVerificationRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	user, err := getUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		fLog.Errorf("UserRepo.GetUserByEmail got %s", err.Error())
+	}
+	awaits := false
+	if err == nil && user != nil {
+		if awaits, err = awaitsVerification(r.Context(), user); err != nil {
+			fLog.Errorf("awaitsVerification got %s", err.Error())
+		}
+	}
+	// a throttled recipient keeps the current code, as the new one would not be delivered
+	if awaits && !mailer.Limiter.Exceeded(user.Email) {
+		if err := resendVerification(r.Context(), user, requestLocale(r)); err != nil {
+			fLog.Errorf("resendVerification got %s", err.Error())
+		}
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Check your email", nil, nil)
+}
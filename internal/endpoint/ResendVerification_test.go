@@ -0,0 +1,152 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+)
+
+type resendUserRepo struct {
+	activationUserRepo
+}
+
+func (repo *resendUserRepo) GetUserByRecID(ctx context.Context, recID string) (*connector.User, error) {
+	if repo.user.RecID == recID {
+		return repo.user, nil
+	}
+	return nil, nil
+}
+
+func TestResendVerification(t *testing.T) {
+	repo := &resendUserRepo{activationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", ActivationCode: "123456"}}}
+	UserRepo = repo
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	sent := make(chan *mailer.Email, 10)
+	stop := consumeMails(sent)
+	defer stop()
+
+	adminResend := func() int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user/u1/resend-verification", apiPrefix), nil)
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@hansip",
+			Audience: []string{"admin@hansip"},
+		}))
+		recorder := httptest.NewRecorder()
+		ResendUserVerification(recorder, req)
+		return recorder.Code
+	}
+	selfResend := func(email string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/resend-verification", apiPrefix), strings.NewReader(fmt.Sprintf(`{"email":"%s"}`, email)))
+		recorder := httptest.NewRecorder()
+		ResendVerification(recorder, req)
+		return recorder.Code
+	}
+	activate := func(code string) int {
+		body := fmt.Sprintf(`{"email":"user@test.com","activation_token":"%s","new_passphrase":"correct horse battery staple"}`, code)
+		recorder := httptest.NewRecorder()
+		ActivateUser(recorder, httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user/activate", apiPrefix), strings.NewReader(body)))
+		return recorder.Code
+	}
+	// resent returns the activation code of the verification email sent
+	resent := func() string {
+		select {
+		case mail := <-sent:
+			if mail.Template != "EMAIL_VERIFY" || mail.To[0] != "user@test.com" {
+				t.Fatalf("expect a verification email to user@test.com. got %s to %v", mail.Template, mail.To)
+			}
+			return mail.Data.(*brandedUser).ActivationCode
+		case <-time.After(time.Second):
+			t.Fatal("expect a verification email sent")
+		}
+		return ""
+	}
+
+	if code := adminResend(); code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	first := resent()
+	if first == "123456" || first != repo.user.ActivationCode {
+		t.Fatalf("expect a fresh activation code saved. got %s, saved %s", first, repo.user.ActivationCode)
+	}
+	if code := selfResend("USER@test.com"); code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	second := resent()
+	if second == first || second != repo.user.ActivationCode {
+		t.Fatalf("expect another fresh activation code saved. got %s, saved %s", second, repo.user.ActivationCode)
+	}
+
+	for _, old := range []string{"123456", first} {
+		if code := activate(old); code != http.StatusNotFound {
+			t.Errorf("expect the replaced code %s refused. got %d", old, code)
+		}
+	}
+	if code := activate(second); code != http.StatusOK {
+		t.Fatalf("expect the resent code to activate the user. got %d", code)
+	}
+
+	if code := adminResend(); code != http.StatusOK {
+		t.Errorf("expect 200 for a verified user but %d", code)
+	}
+	if code := selfResend("user@test.com"); code != http.StatusOK {
+		t.Errorf("expect 200 for a verified user but %d", code)
+	}
+	if len(sent) != 0 || len(repo.user.ActivationCode) != 0 {
+		t.Errorf("expect nothing sent nor changed for a verified user. got %d emails, code %s", len(sent), repo.user.ActivationCode)
+	}
+
+	// a verified user disabled by an admin must not get a code enabling it again
+	if !repo.verified {
+		t.Fatal("expect the activation to verify the email")
+	}
+	repo.user.Enabled = false
+	if code := adminResend(); code != http.StatusOK {
+		t.Errorf("expect 200 for a disabled user but %d", code)
+	}
+	if code := selfResend("user@test.com"); code != http.StatusOK {
+		t.Errorf("expect 200 for a disabled user but %d", code)
+	}
+	if len(sent) != 0 || len(repo.user.ActivationCode) != 0 {
+		t.Errorf("expect nothing sent nor changed for a disabled user. got %d emails, code %s", len(sent), repo.user.ActivationCode)
+	}
+}
+
+func TestResendVerificationThrottled(t *testing.T) {
+	repo := &resendUserRepo{activationUserRepo{user: &connector.User{RecID: "u1", Email: "throttled@test.com", ActivationCode: "123456"}}}
+	UserRepo = repo
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+	limiter := mailer.Limiter
+	defer func() { mailer.Limiter = limiter }()
+	mailer.Limiter = mailer.NewRateLimiter(1, time.Hour)
+	mailer.Limiter.Allow("throttled@test.com")
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user/u1/resend-verification", apiPrefix), nil)
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@hansip",
+		Audience: []string{"admin@hansip"},
+	}))
+	recorder := httptest.NewRecorder()
+	ResendUserVerification(recorder, req)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("expect 429 but %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	ResendVerification(recorder, httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/resend-verification", apiPrefix), strings.NewReader(`{"email":"throttled@test.com"}`)))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expect the same 200 to a throttled user but %d", recorder.Code)
+	}
+	if repo.user.ActivationCode != "123456" {
+		t.Errorf("expect a throttled user to keep the code. got %s", repo.user.ActivationCode)
+	}
+}
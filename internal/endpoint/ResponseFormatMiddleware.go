@@ -0,0 +1,147 @@
+package endpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	responseFormatLog = log.WithField("go", "ResponseFormatMiddleware")
+
+	// SelectableFields are the entity field names allowed in the "fields" query parameter.
+	SelectableFields = map[string]bool{
+		"rec_id":        true,
+		"email":         true,
+		"enabled":       true,
+		"suspended":     true,
+		"last_seen":     true,
+		"last_login":    true,
+		"enabled_2fa":   true,
+		"name":          true,
+		"domain":        true,
+		"description":   true,
+		"group_name":    true,
+		"group_domain":  true,
+		"role_name":     true,
+		"role_domain":   true,
+		"tenant_rec_id": true,
+	}
+)
+
+// ResponseFormatMiddleware handles the "pretty" and "fields" query parameter.
+// "pretty=true" indents the JSON response body, while "fields=a,b" on GET request
+// only returns the requested fields of the returned entity or entities.
+// When neither parameter is specified, the response is passed through untouched.
+func ResponseFormatMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+		var fields []string
+		if r.Method == http.MethodGet && len(r.URL.Query().Get("fields")) > 0 {
+			var err error
+			fields, err = parseSelectedFields(r.URL.Query().Get("fields"))
+			if err != nil {
+				helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+				return
+			}
+		}
+		if !pretty && fields == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buffered := newBufferedResponseWriter()
+		next.ServeHTTP(buffered, r)
+		body := buffered.body.Bytes()
+
+		if strings.Contains(buffered.Header().Get("Content-Type"), "application/json") {
+			formatted, err := formatJSONBody(body, pretty, fields)
+			if err != nil {
+				responseFormatLog.WithField("func", "ResponseFormatMiddleware").Warnf("formatJSONBody got %s. response is left unformatted", err.Error())
+			} else {
+				body = formatted
+			}
+		}
+
+		buffered.writeTo(w, body)
+	})
+}
+
+func parseSelectedFields(fieldsParam string) ([]string, error) {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(fieldsParam, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+		if !SelectableFields[field] {
+			return nil, fmt.Errorf("field %s can not be selected", field)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no field selected")
+	}
+	return fields, nil
+}
+
+func formatJSONBody(body []byte, pretty bool, fields []string) ([]byte, error) {
+	if fields == nil {
+		buff := &bytes.Buffer{}
+		if err := json.Indent(buff, body, "", "  "); err != nil {
+			return nil, err
+		}
+		return buff.Bytes(), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	response := make(map[string]interface{})
+	if err := decoder.Decode(&response); err != nil {
+		return nil, err
+	}
+	if data, ok := response["data"].(map[string]interface{}); ok {
+		response["data"] = selectFields(data, fields)
+	}
+	if pretty {
+		return json.MarshalIndent(response, "", "  ")
+	}
+	return json.Marshal(response)
+}
+
+// selectFields filters the entity fields in the data.
+// If data contains lists of entities, as returned by list endpoints, each entity in the lists is filtered.
+// Otherwise, data is the entity itself.
+func selectFields(data map[string]interface{}, fields []string) map[string]interface{} {
+	isList := false
+	for key, value := range data {
+		if list, ok := value.([]interface{}); ok {
+			isList = true
+			for i, item := range list {
+				if entity, ok := item.(map[string]interface{}); ok {
+					list[i] = filterEntity(entity, fields)
+				}
+			}
+			data[key] = list
+		}
+	}
+	if isList {
+		return data
+	}
+	return filterEntity(data, fields)
+}
+
+func filterEntity(entity map[string]interface{}, fields []string) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, field := range fields {
+		if value, ok := entity[field]; ok {
+			ret[field] = value
+		}
+	}
+	return ret
+}
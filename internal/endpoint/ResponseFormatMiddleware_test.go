@@ -0,0 +1,57 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func TestResponseFormatMiddleware(t *testing.T) {
+	handler := ResponseFormatMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ret := make(map[string]interface{})
+		ret["users"] = []*SimpleUser{{RecID: "abc", Email: "a@b.com", Enabled: true}}
+		ret["page"] = helper.NewPage(&helper.PageRequest{No: 1, PageSize: 10, OrderBy: "EMAIL", Sort: "ASC"}, 1)
+		helper.WriteHTTPResponse(context.Background(), w, http.StatusOK, "ok", nil, ret)
+	}))
+
+	// untouched
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/management/users", nil))
+	if strings.Contains(rec.Body.String(), "\n") {
+		t.Errorf("response should not be indented by default")
+	}
+
+	// pretty
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/management/users?pretty=true", nil))
+	if !strings.Contains(rec.Body.String(), "\n  \"httpcode\": 200") {
+		t.Errorf("response should be indented. got %s", rec.Body.String())
+	}
+
+	// fields
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/management/users?fields=rec_id,email", nil))
+	resp := make(map[string]interface{})
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	user := resp["data"].(map[string]interface{})["users"].([]interface{})[0].(map[string]interface{})
+	if len(user) != 2 || user["rec_id"] != "abc" || user["email"] != "a@b.com" {
+		t.Errorf("expect only rec_id and email. got %v", user)
+	}
+	if _, ok := resp["data"].(map[string]interface{})["page"]; !ok {
+		t.Errorf("page information should be retained")
+	}
+
+	// invalid field
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/management/users?fields=rec_id,hashed_passphrase", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("field outside allowlist should be rejected. got %d", rec.Code)
+	}
+}
@@ -43,7 +43,7 @@ func SetRoleUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -76,6 +76,10 @@ func SetRoleUsers(w http.ResponseWriter, r *http.Request) {
 			fLog.Warnf("UserRepo.GetUserByRecID got %s, this user %s will not be added to role %s user", err.Error(), userID, role.RecID)
 		} else if user == nil {
 			fLog.Warnf("This user %s not exist and will not be added to role %s user", userID, role.RecID)
+		} else if allowed, err := canManageUser(r.Context(), authCtx, user, true); err != nil || !allowed {
+			fLog.Warnf("This user %s is of another tenant and will not be added to role %s user", userID, role.RecID)
+		} else if err := validateTenantEmailDomains(r.Context(), []string{role.RoleDomain}, user.Email); err != nil {
+			fLog.Warnf("validateTenantEmailDomains got %s, this user %s will not be added to role %s user", err.Error(), userID, role.RecID)
 		} else {
 			_, err := UserRoleRepo.CreateUserRole(r.Context(), user, role)
 			if err != nil {
@@ -115,7 +119,7 @@ func DeleteRoleUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -154,7 +158,7 @@ func SetRoleGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -225,7 +229,7 @@ func DeleteRoleGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -265,9 +269,9 @@ func ListAllRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -285,9 +289,9 @@ func ListAllRole(w http.ResponseWriter, r *http.Request) {
 
 // CreateRoleRequest hold dta model for requesting to create new role
 type CreateRoleRequest struct {
-	RoleName    string `json:"role_name"`
-	RoleDomain  string `json:"role_domain"`
-	Description string `json:"description"`
+	RoleName    string `json:"role_name" xml:"role_name"`
+	RoleDomain  string `json:"role_domain" xml:"role_domain"`
+	Description string `json:"description" xml:"description"`
 }
 
 // CreateRole serve the creation new role endpoint
@@ -307,9 +311,9 @@ func CreateRole(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -331,7 +335,7 @@ func CreateRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(req.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, req.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
@@ -367,9 +371,9 @@ func UpdateRole(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -386,7 +390,7 @@ func UpdateRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !(authCtx.IsAdminOfDomain(role.RoleDomain) && authCtx.IsAdminOfDomain(req.RoleDomain)) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain, req.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, fmt.Sprintf("forbidden. you are not admin of %s and %s domain", role.RoleDomain, req.RoleDomain), nil, nil)
 		return
 	}
@@ -437,7 +441,7 @@ func GetRoleDetail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
@@ -445,7 +449,8 @@ func GetRoleDetail(w http.ResponseWriter, r *http.Request) {
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Role fetched", nil, role)
 }
 
-// DeleteRole serving request to delete a role
+// DeleteRole serving request to delete a role. The "cascade" query parameter tells what happens to the role's users and groups,
+// "block" (the default) refuses while the role is assigned, "detach" removes the assignments, "reassign" moves them to the "reassign_to" role
 func DeleteRole(w http.ResponseWriter, r *http.Request) {
 	fLog := roleMgmtLogger.WithField("func", "DeleteRole").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
 
@@ -455,6 +460,11 @@ func DeleteRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cascade, ok := deleteCascade(w, r)
+	if !ok {
+		return
+	}
+
 	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/role/{roleRecId}", apiPrefix), r.URL.Path)
 	if err != nil {
 		panic(err)
@@ -471,12 +481,25 @@ func DeleteRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
 
-	RoleRepo.DeleteRole(r.Context(), role)
+	status, message, err := cascadeRoleDelete(r.Context(), authCtx, role, cascade, r.URL.Query().Get("reassign_to"))
+	if err != nil {
+		fLog.Errorf("cascadeRoleDelete got %s", err.Error())
+	}
+	if status != 0 {
+		helper.WriteHTTPResponse(r.Context(), w, status, message, nil, nil)
+		return
+	}
+	err = RoleRepo.DeleteRole(r.Context(), role)
+	if err != nil {
+		fLog.Errorf("RoleRepo.DeleteRole got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Role deleted", nil, nil)
 }
 
@@ -506,14 +529,14 @@ func ListRoleUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -562,7 +585,7 @@ func CreateRoleUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
@@ -577,6 +600,12 @@ func CreateRoleUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, true) {
+		return
+	}
+	if !applyTenantEmailDomains(w, r, []string{role.RoleDomain}, user.Email) {
+		return
+	}
 
 	_, err = UserRoleRepo.CreateUserRole(r.Context(), user, role)
 	if err != nil {
@@ -614,7 +643,7 @@ func DeleteRoleUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
@@ -676,12 +705,12 @@ func ListRoleGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access role with the specified domain", nil, nil)
 		return
 	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
 		fLog.Errorf("RoleRepo.GetRoleByRecID got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
@@ -727,7 +756,7 @@ func CreateRoleGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to manage role with the specified domain", nil, nil)
 		return
 	}
@@ -783,7 +812,7 @@ func DeleteRoleGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to create role with the specified domain", nil, nil)
 		return
 	}
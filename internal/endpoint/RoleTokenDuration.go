@@ -0,0 +1,52 @@
+package endpoint
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/jiffy"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	roleTokenDurationLog = log.WithField("go", "RoleTokenDuration")
+)
+
+// RoleTokenDurations resolves the access and refresh token lifetime of a token issued to the audience
+// from the per role configuration "token.role.<role name>.access.duration" and "token.role.<role name>.refresh.duration".
+// When more than one role in the audience has a specific lifetime, the shortest one is used,
+// thus the most privileged role, having the shortest session, always wins.
+// A zero duration is returned when none of the roles has a specific lifetime,
+// the token factory will then use "token.access.duration" or "token.refresh.duration".
+func RoleTokenDurations(audience []string) (time.Duration, time.Duration) {
+	var access, refresh time.Duration
+	for _, role := range audience {
+		roleName := strings.SplitN(role, "@", 2)[0]
+		access = shorterDuration(access, roleTokenDuration(roleName, "access"))
+		refresh = shorterDuration(refresh, roleTokenDuration(roleName, "refresh"))
+	}
+	return access, refresh
+}
+
+func roleTokenDuration(roleName, tokenType string) time.Duration {
+	key := fmt.Sprintf("token.role.%s.%s.duration", roleName, tokenType)
+	value := config.Get(key)
+	if len(value) == 0 {
+		return 0
+	}
+	duration, err := jiffy.DurationOf(value)
+	if err != nil || duration <= 0 {
+		roleTokenDurationLog.WithField("func", "roleTokenDuration").Warnf("%s %q is not a valid duration. the default token duration is used", key, value)
+		return 0
+	}
+	return duration
+}
+
+func shorterDuration(current, candidate time.Duration) time.Duration {
+	if candidate > 0 && (current == 0 || candidate < current) {
+		return candidate
+	}
+	return current
+}
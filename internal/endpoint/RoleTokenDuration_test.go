@@ -0,0 +1,104 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type durationRoleRepo struct {
+	connector.RoleRepository
+	roleName string
+}
+
+func (repo *durationRoleRepo) GetRoleByRecID(ctx context.Context, recID string) (*connector.Role, error) {
+	return &connector.Role{RecID: recID, RoleName: repo.roleName, RoleDomain: "acme"}, nil
+}
+
+func TestRoleTokenDurations(t *testing.T) {
+	config.Set("token.role.admin.access.duration", "15 minutes")
+	config.Set("token.role.auditor.access.duration", "30 minutes")
+	config.Set("token.role.auditor.refresh.duration", "1 day")
+	defer config.Set("token.role.admin.access.duration", "")
+	defer config.Set("token.role.auditor.access.duration", "")
+	defer config.Set("token.role.auditor.refresh.duration", "")
+
+	access, refresh := RoleTokenDurations([]string{"user"})
+	if access != 0 || refresh != 0 {
+		t.Errorf("role without override should get zero durations. got %s and %s", access, refresh)
+	}
+	access, refresh = RoleTokenDurations([]string{"user", "auditor@acme", "admin"})
+	if access != 15*time.Minute {
+		t.Errorf("shortest access duration should win. got %s", access)
+	}
+	if refresh != 24*time.Hour {
+		t.Errorf("expect auditor refresh duration. got %s", refresh)
+	}
+}
+
+func TestRoleTokenDurationLogin(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Set("token.role.admin.access.duration", "15 minutes")
+	defer config.Set("token.role.admin.access.duration", "")
+	tokenFactory := helper.NewTokenFactory("testkey", "HS256", "test.issuer", time.Hour, 24*time.Hour)
+	tokenFactory.(*helper.DefaultTokenFactory).DurationResolver = RoleTokenDurations
+	TokenFactory = tokenFactory
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+	roleRepo := &durationRoleRepo{}
+	RoleRepo = roleRepo
+
+	login := func() (*helper.HansipToken, *helper.HansipToken) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(`{"email":"user@acme.com","passphrase":"abcdefg"}`))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+		}
+		response := &struct {
+			Data *Response `json:"data"`
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		access, err := TokenFactory.ReadToken(response.Data.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refresh, err := TokenFactory.ReadToken(response.Data.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return access, refresh
+	}
+
+	roleRepo.roleName = "admin"
+	access, refresh := login()
+	if age := access.Expire.Sub(access.NotBefore); age != 15*time.Minute {
+		t.Errorf("admin should get 15 minutes access token. got %s", age)
+	}
+	if age := refresh.Expire.Sub(refresh.NotBefore); age != 24*time.Hour {
+		t.Errorf("admin without refresh override should get the default refresh token. got %s", age)
+	}
+
+	roleRepo.roleName = "user"
+	access, _ = login()
+	if age := access.Expire.Sub(access.NotBefore); age != time.Hour {
+		t.Errorf("normal user should get the default access token. got %s", age)
+	}
+}
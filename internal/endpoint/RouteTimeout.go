@@ -0,0 +1,107 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/jiffy"
+)
+
+// RouteTimeout is a request timeout override for all path starting with PathPrefix
+type RouteTimeout struct {
+	PathPrefix string
+	Timeout    time.Duration
+}
+
+// ParseRouteTimeouts parses the per route timeout configuration.
+// Routes are separated by ";", each route is a path prefix followed by "=" and a duration,
+// eg. "/api/v1/management/users/bulk=5 minutes;/api/v1/management/user=30 seconds".
+func ParseRouteTimeouts(spec string) ([]*RouteTimeout, error) {
+	ret := make([]*RouteTimeout, 0)
+	for _, item := range strings.Split(spec, ";") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		idx := strings.LastIndex(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("route timeout %s has no timeout", item)
+		}
+		prefix := strings.TrimSpace(item[:idx])
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route timeout path prefix %s must start with /", prefix)
+		}
+		timeout, err := jiffy.DurationOf(strings.TrimSpace(item[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("route timeout %s has invalid duration. got %s", item, err.Error())
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("route timeout %s must be positive", item)
+		}
+		ret = append(ret, &RouteTimeout{PathPrefix: prefix, Timeout: timeout})
+	}
+	return ret, nil
+}
+
+// MaxRouteTimeout returns the longest timeout among the default and all the route timeouts.
+func MaxRouteTimeout(defaultTimeout time.Duration, routes []*RouteTimeout) time.Duration {
+	ret := defaultTimeout
+	for _, route := range routes {
+		if route.Timeout > ret {
+			ret = route.Timeout
+		}
+	}
+	return ret
+}
+
+// StreamingPaths returns the paths of the NDJSON exports, which send their records as they are read.
+func StreamingPaths() []string {
+	return []string{fmt.Sprintf("%s/export", apiPrefix), fmt.Sprintf("%s/management/audit/export", apiPrefix)}
+}
+
+// isStreamingRoute check whether the path is one of the NDJSON exports.
+func isStreamingRoute(path string) bool {
+	for _, streaming := range StreamingPaths() {
+		if path == streaming {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRouteTimeoutMiddleware creates a middleware that limits the handling time of each request.
+// The timeout of the longest matching path prefix is used, other path use the default timeout.
+// Request that exceed its timeout is responded with 503 Service Unavailable.
+// A streaming route only gets its timeout as the request context deadline, as http.TimeoutHandler would hold
+// the whole stream in memory until the handler is done, the export then ends with its error record.
+func NewRouteTimeoutMiddleware(defaultTimeout time.Duration, routes []*RouteTimeout) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		message := `{"httpcode":503,"message":"Request timeout","status":"FAIL"}`
+		defaultHandler := http.TimeoutHandler(next, defaultTimeout, message)
+		routeHandlers := make([]http.Handler, len(routes))
+		for i, route := range routes {
+			routeHandlers[i] = http.TimeoutHandler(next, route.Timeout, message)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler := defaultHandler
+			timeout := defaultTimeout
+			matched := ""
+			for i, route := range routes {
+				if strings.HasPrefix(r.URL.Path, route.PathPrefix) && len(route.PathPrefix) > len(matched) {
+					matched = route.PathPrefix
+					handler = routeHandlers[i]
+					timeout = route.Timeout
+				}
+			}
+			if isStreamingRoute(r.URL.Path) {
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
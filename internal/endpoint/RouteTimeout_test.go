@@ -0,0 +1,69 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	routes, err := ParseRouteTimeouts("/api/v1/management/users/bulk=5 minutes; /api/v1/management=30 seconds;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].Timeout != 5*time.Minute || routes[1].PathPrefix != "/api/v1/management" {
+		t.Errorf("unexpected parse result")
+	}
+	if MaxRouteTimeout(15*time.Second, routes) != 5*time.Minute {
+		t.Errorf("max timeout should be 5 minutes")
+	}
+	for _, spec := range []string{"/api/v1/bulk", "api/v1/bulk=1 minute", "/api/v1/bulk=forever"} {
+		if _, err := ParseRouteTimeouts(spec); err == nil {
+			t.Errorf("expect error for %s", spec)
+		}
+	}
+}
+
+func TestRouteTimeoutMiddleware(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := NewRouteTimeoutMiddleware(20*time.Millisecond, []*RouteTimeout{
+		{PathPrefix: "/api/v1/management/users/bulk", Timeout: time.Second},
+	})(slow)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/management/users/bulk", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("bulk route should survive the default timeout. got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/management/user", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("normal route should be cut by the default timeout. got %d", recorder.Code)
+	}
+}
+
+func TestRouteTimeoutStreamingRoute(t *testing.T) {
+	streaming := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}\n"))
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("streaming route should be able to flush")
+		}
+		<-r.Context().Done()
+		w.Write([]byte(`{"error":"timeout"}`))
+	})
+	handler := NewRouteTimeoutMiddleware(20*time.Millisecond, nil)(streaming)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/export", apiPrefix), nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "{}\n{\"error\":\"timeout\"}" {
+		t.Errorf("streaming route should end with its error record on the deadline. got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SecurityEventLoginFailed is the security event type when a login is rejected
+	SecurityEventLoginFailed = "LOGIN_FAILED"
+	// SecurityEventAccountLocked is the security event type when a user is suspended after too many failed attempts
+	SecurityEventAccountLocked = "ACCOUNT_LOCKED"
+	// SecurityEventPassphraseReset is the security event type when a user reset the passphrase with a recovery code
+	SecurityEventPassphraseReset = "PASSPHRASE_RESET"
+	// SecurityEvent2FAFailed is the security event type when an OTP or a TOTP recovery code is rejected
+	SecurityEvent2FAFailed = "2FA_FAILED"
+
+	// maxFailedAttempts is how many failed attempts a user may make before being suspended
+	maxFailedAttempts = 3
+	// maxSecurityEventsInFlight is how many security events are posted concurrently, more are dropped
+	maxSecurityEventsInFlight = 32
+)
+
+var (
+	securityEventLog = log.WithField("go", "SecurityEvent")
+
+	// SecurityWebhook receives the security events, none are emitted if nil
+	SecurityWebhook connector.Webhook
+
+	securityEventTypes    = []string{SecurityEventLoginFailed, SecurityEventAccountLocked, SecurityEventPassphraseReset, SecurityEvent2FAFailed}
+	securityEventInFlight = make(chan bool, maxSecurityEventsInFlight)
+)
+
+// SecurityEvent is the payload posted to the security webhook. It never holds the attempted passphrase, OTP nor code.
+type SecurityEvent struct {
+	EventType  string    `json:"event_type"`
+	Time       time.Time `json:"time"`
+	ClientIP   string    `json:"client_ip"`
+	Identifier string    `json:"identifier"`
+	UserRecID  string    `json:"user_rec_id,omitempty"`
+	Reason     string    `json:"reason"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// ValidateSecurityEvents checks every event type in "webhook.security.events" is known
+func ValidateSecurityEvents() error {
+	for _, eventType := range config.GetList("webhook.security.events") {
+		if !isSecurityEventType(eventType) {
+			return fmt.Errorf("webhook.security.events %q is not one of %s", eventType, strings.Join(securityEventTypes, ","))
+		}
+	}
+	return nil
+}
+
+func isSecurityEventType(eventType string) bool {
+	for _, known := range securityEventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecurityEventSubscribed check whether the event type is listed in "webhook.security.events"
+func isSecurityEventSubscribed(eventType string) bool {
+	for _, subscribed := range config.GetList("webhook.security.events") {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the caller's address without its port, as resolved by the ClientIPResolverMiddleware
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// emitSecurityEvent posts the security event to the SecurityWebhook in the background, if the event type is subscribed.
+// The identifier is what the caller identified as, eg. the attempted email, the user is nil if it is not known.
+func emitSecurityEvent(r *http.Request, eventType, identifier string, user *connector.User, reason string) {
+	if SecurityWebhook == nil || !isSecurityEventSubscribed(eventType) {
+		return
+	}
+	fLog := securityEventLog.WithField("func", "emitSecurityEvent").WithField("RequestID", r.Context().Value(constants.RequestID))
+	event := &SecurityEvent{
+		EventType:  eventType,
+		Time:       time.Now(),
+		ClientIP:   clientIP(r),
+		Identifier: identifier,
+		Reason:     reason,
+	}
+	if user != nil {
+		event.UserRecID = user.RecID
+	}
+	if requestID, ok := r.Context().Value(constants.RequestID).(string); ok {
+		event.RequestID = requestID
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		fLog.Errorf("json.Marshal got %s", err.Error())
+		return
+	}
+	select {
+	case securityEventInFlight <- true:
+	default:
+		fLog.Warnf("too many security events in flight, %s of %s is dropped", eventType, identifier)
+		return
+	}
+	webhook := SecurityWebhook
+	go func() {
+		defer func() { <-securityEventInFlight }()
+		// the request is done by then, so the event is posted outside of its context
+		if err := webhook.Post(context.Background(), eventType, payload); err != nil {
+			fLog.Errorf("SecurityWebhook.Post %s got %s", eventType, err.Error())
+		}
+	}()
+}
+
+// countFailedAttempt counts a failed login attempt of the user, emitting the security event of the failure, and suspends the user
+// after too many, emitting the SecurityEventAccountLocked. The response is then delayed by the LoginThrottle.
+func countFailedAttempt(r *http.Request, user *connector.User, eventType, identifier, reason string) {
+	user.FailCount++
+	emitSecurityEvent(r, eventType, identifier, user, reason)
+	if user.FailCount > maxFailedAttempts && !user.Suspended {
+		user.Suspended = true
+		emitSecurityEvent(r, SecurityEventAccountLocked, identifier, user, fmt.Sprintf("%d failed attempts", user.FailCount))
+	}
+	throttleFailedLogin(r, identifier)
+}
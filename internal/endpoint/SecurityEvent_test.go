@@ -0,0 +1,111 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeWebhook struct {
+	posted chan []byte
+}
+
+func (webhook *fakeWebhook) Post(ctx context.Context, eventType string, payload []byte) error {
+	webhook.posted <- payload
+	return nil
+}
+
+func TestSecurityEvents(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correct passphrase"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, 7*24*time.Hour)
+	user := &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: user, active: true}}
+	RoleRepo = &regionRoleRepo{}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+	webhook := &fakeWebhook{posted: make(chan []byte, 10)}
+	SecurityWebhook = webhook
+	defer func() { SecurityWebhook = nil }()
+
+	login := func(passphrase string) int {
+		body := fmt.Sprintf(`{"email":"user@acme.com","passphrase":"%s"}`, passphrase)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.10:51234"
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		return recorder.Code
+	}
+	next := func() *SecurityEvent {
+		select {
+		case payload := <-webhook.posted:
+			if strings.Contains(string(payload), "wrong passphrase") {
+				t.Errorf("the attempted passphrase must never be posted. got %s", payload)
+			}
+			event := &SecurityEvent{}
+			if err := json.Unmarshal(payload, event); err != nil {
+				t.Fatal(err)
+			}
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("expect a security event posted")
+			return nil
+		}
+	}
+
+	for attempt := 1; attempt <= 4; attempt++ {
+		if code := login("wrong passphrase"); code != http.StatusUnauthorized {
+			t.Fatalf("expect wrong passphrase rejected. got %d", code)
+		}
+		// the 4th failed attempt locks the user, its events are posted concurrently
+		events := map[string]*SecurityEvent{}
+		for len(events) < 1 || (attempt == 4 && len(events) < 2) {
+			event := next()
+			events[event.EventType] = event
+		}
+		event := events[SecurityEventLoginFailed]
+		if event == nil || event.Identifier != "user@acme.com" || event.UserRecID != "u1" ||
+			event.ClientIP != "192.0.2.10" || event.Reason != "passphrase not match" || time.Since(event.Time) > time.Minute {
+			t.Errorf("attempt %d got unexpected event %+v", attempt, event)
+		}
+		if locked := events[SecurityEventAccountLocked]; (locked != nil) != (attempt == 4) || user.Suspended != (attempt == 4) {
+			t.Errorf("expect the user locked only after the 4th failed attempt. attempt %d got %+v", attempt, locked)
+		}
+	}
+
+	// only the subscribed events are posted
+	config.Set("webhook.security.events", SecurityEventAccountLocked)
+	defer config.Set("webhook.security.events", "LOGIN_FAILED,ACCOUNT_LOCKED,PASSPHRASE_RESET,2FA_FAILED")
+	if code := login("correct passphrase"); code != http.StatusForbidden {
+		t.Fatalf("expect the suspended user rejected. got %d", code)
+	}
+	select {
+	case payload := <-webhook.posted:
+		t.Errorf("expect no event posted when it is not subscribed. got %s", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestValidateSecurityEvents(t *testing.T) {
+	defer config.Set("webhook.security.events", "LOGIN_FAILED,ACCOUNT_LOCKED,PASSPHRASE_RESET,2FA_FAILED")
+	config.Set("webhook.security.events", "LOGIN_FAILED, 2FA_FAILED")
+	if err := ValidateSecurityEvents(); err != nil {
+		t.Errorf("known events should be valid. got %s", err.Error())
+	}
+	config.Set("webhook.security.events", "LOGIN_FAILED,LOGIN_SUCCEEDED")
+	if err := ValidateSecurityEvents(); err == nil {
+		t.Errorf("unknown event should be invalid")
+	}
+}
@@ -0,0 +1,121 @@
+package endpoint
+
+import (
+	"fmt"
+	"github.com/hyperjumptech/hansip/internal/config"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const (
+	// HSTSPreloadMinMaxAge is the shortest max-age, one year, accepted by the HSTS preload list
+	HSTSPreloadMinMaxAge = 31536000
+)
+
+var (
+	securityHeadersLog = log.WithField("go", "SecurityHeadersMiddleware")
+)
+
+// SecurityHeaders contains the security response header values to be added into every response.
+// Empty value means the header will not be added.
+type SecurityHeaders struct {
+	ContentTypeOptions      string
+	FrameOptions            string
+	ReferrerPolicy          string
+	StrictTransportSecurity string
+	ContentSecurityPolicy   string
+}
+
+// NewSecurityHeadersFromConfig creates SecurityHeaders using values from configuration.
+// Strict-Transport-Security is built from "server.http.hsts.*" if "server.http.hsts.maxage" is set,
+// otherwise "server.http.securityheaders.hsts" is used as is.
+func NewSecurityHeadersFromConfig() *SecurityHeaders {
+	hsts := config.Get("server.http.securityheaders.hsts")
+	if len(config.Get("server.http.hsts.maxage")) > 0 {
+		hsts = StrictTransportSecurityValue(config.GetInt("server.http.hsts.maxage"), config.GetBoolean("server.http.hsts.includesubdomains"), config.GetBoolean("server.http.hsts.preload"))
+	}
+	return &SecurityHeaders{
+		ContentTypeOptions:      config.Get("server.http.securityheaders.contenttypeoptions"),
+		FrameOptions:            config.Get("server.http.securityheaders.frameoptions"),
+		ReferrerPolicy:          config.Get("server.http.securityheaders.referrerpolicy"),
+		StrictTransportSecurity: hsts,
+		ContentSecurityPolicy:   config.Get("server.http.securityheaders.csp"),
+	}
+}
+
+// StrictTransportSecurityValue builds the Strict-Transport-Security header value.
+// preload is refused with a warning unless includeSubDomains is set and maxAge is at least HSTSPreloadMinMaxAge,
+// as the preload list would reject the domain anyway. A negative maxAge omits the header.
+func StrictTransportSecurityValue(maxAge int, includeSubDomains, preload bool) string {
+	fLog := securityHeadersLog.WithField("func", "StrictTransportSecurityValue")
+	if maxAge < 0 {
+		fLog.Warnf("HSTS max-age %d is negative. Strict-Transport-Security header is omitted", maxAge)
+		return ""
+	}
+	value := fmt.Sprintf("max-age=%d", maxAge)
+	if includeSubDomains {
+		value = value + "; includeSubDomains"
+	}
+	if preload {
+		if !includeSubDomains || maxAge < HSTSPreloadMinMaxAge {
+			fLog.Warnf("HSTS preload requires includeSubDomains and max-age of at least %d seconds. preload is not enabled", HSTSPreloadMinMaxAge)
+		} else {
+			value = value + "; preload"
+		}
+	}
+	return value
+}
+
+// Middleware adds the security headers into response. Header already set by the handler will not be replaced.
+// Strict-Transport-Security is only added if the request came through TLS. X-Forwarded-Proto is not honoured
+// as any client can set it when there is no trusted proxy in front.
+func (sh *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := map[string]string{
+			"X-Content-Type-Options":  sh.ContentTypeOptions,
+			"X-Frame-Options":         sh.FrameOptions,
+			"Referrer-Policy":         sh.ReferrerPolicy,
+			"Content-Security-Policy": sh.ContentSecurityPolicy,
+		}
+		if r.TLS != nil {
+			headers["Strict-Transport-Security"] = sh.StrictTransportSecurity
+		}
+		next.ServeHTTP(&securityHeaderWriter{ResponseWriter: w, headers: headers}, r)
+	})
+}
+
+// securityHeaderWriter adds the missing security headers just before the response header is written.
+type securityHeaderWriter struct {
+	http.ResponseWriter
+	headers     map[string]string
+	wroteHeader bool
+}
+
+func (sw *securityHeaderWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+		for key, value := range sw.headers {
+			if len(value) > 0 && len(sw.Header().Get(key)) == 0 {
+				sw.Header().Set(key, value)
+			}
+		}
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *securityHeaderWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying writer supports it.
+func (sw *securityHeaderWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		if !sw.wroteHeader {
+			sw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
@@ -0,0 +1,112 @@
+package endpoint
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+func TestSecurityHeaders_Middleware(t *testing.T) {
+	sh := &SecurityHeaders{
+		ContentTypeOptions:      "nosniff",
+		FrameOptions:            "DENY",
+		ReferrerPolicy:          "no-referrer",
+		StrictTransportSecurity: "max-age=600",
+		ContentSecurityPolicy:   "default-src 'self'",
+	}
+	handler := sh.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/something", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("missing X-Content-Type-Options")
+	}
+	if rec.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("missing Referrer-Policy")
+	}
+	if rec.Header().Get("Content-Security-Policy") != "default-src 'self'" {
+		t.Errorf("missing Content-Security-Policy")
+	}
+	if rec.Header().Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options set by handler should not be replaced. got %s", rec.Header().Get("X-Frame-Options"))
+	}
+	if len(rec.Header().Get("Strict-Transport-Security")) != 0 {
+		t.Errorf("Strict-Transport-Security should not be sent over plain http")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/something", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if len(rec.Header().Get("Strict-Transport-Security")) != 0 {
+		t.Errorf("Strict-Transport-Security should not be sent because of a spoofable X-Forwarded-Proto")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/something", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Strict-Transport-Security") != "max-age=600" {
+		t.Errorf("Strict-Transport-Security should be sent over TLS")
+	}
+}
+
+func TestStrictTransportSecurityValue(t *testing.T) {
+	testData := []struct {
+		maxAge            int
+		includeSubDomains bool
+		preload           bool
+		expect            string
+	}{
+		{600, false, false, "max-age=600"},
+		{600, true, false, "max-age=600; includeSubDomains"},
+		{63072000, true, true, "max-age=63072000; includeSubDomains; preload"},
+		{HSTSPreloadMinMaxAge, true, true, "max-age=31536000; includeSubDomains; preload"},
+		{600, true, true, "max-age=600; includeSubDomains"},
+		{63072000, false, true, "max-age=63072000"},
+		{0, false, false, "max-age=0"},
+		{-1, true, false, ""},
+	}
+	for _, td := range testData {
+		if value := StrictTransportSecurityValue(td.maxAge, td.includeSubDomains, td.preload); value != td.expect {
+			t.Errorf("max-age %d includeSubDomains %v preload %v expect %q but %q", td.maxAge, td.includeSubDomains, td.preload, td.expect, value)
+		}
+	}
+}
+
+func TestStrictTransportSecurityFromConfig(t *testing.T) {
+	if sh := NewSecurityHeadersFromConfig(); sh.StrictTransportSecurity != config.Get("server.http.securityheaders.hsts") {
+		t.Errorf("without server.http.hsts.maxage the configured header value should be used. got %s", sh.StrictTransportSecurity)
+	}
+	config.Set("server.http.hsts.maxage", "63072000")
+	config.Set("server.http.hsts.preload", "true")
+	defer func() {
+		config.Set("server.http.hsts.maxage", "")
+		config.Set("server.http.hsts.preload", "false")
+	}()
+	sh := NewSecurityHeadersFromConfig()
+	handler := sh.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/something", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Strict-Transport-Security") != "max-age=63072000; includeSubDomains; preload" {
+		t.Errorf("preload header should be sent over TLS. got %s", rec.Header().Get("Strict-Transport-Security"))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/something", nil))
+	if _, ok := rec.Header()["Strict-Transport-Security"]; ok {
+		t.Errorf("Strict-Transport-Security should not be sent over plain http")
+	}
+}
@@ -3,6 +3,8 @@ package endpoint
 import (
 	"net/http"
 
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/metrics"
 	"github.com/hyperjumptech/hansip/pkg/helper"
 )
 
@@ -14,3 +16,15 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	hc := &helper.HealthCheck{}
 	_, _ = w.Write([]byte(hc.String()))
 }
+
+// Metrics serve the metrics in the Prometheus text exposition format, if "server.metrics.enable" is on.
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	if !config.GetBoolean("server.metrics.enable") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Add("cache-control", "no-cache")
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	metrics.DefaultRegistry.WritePrometheus(w)
+}
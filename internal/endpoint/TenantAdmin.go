@@ -0,0 +1,105 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tenantAdminLog = log.WithField("go", "TenantAdmin")
+)
+
+// isSuperAdmin check whether the authenticated user is the hansip admin, who manages the users of all tenants
+func isSuperAdmin(authCtx *hansipcontext.AuthenticationContext) bool {
+	return authCtx.IsAdminOfDomain(config.Get("hansip.domain"))
+}
+
+// userTenantDomains list the domains of the tenants the user belongs to, that is the domains of the user's roles,
+// including the roles of the user's groups.
+func userTenantDomains(ctx context.Context, user *connector.User) ([]string, error) {
+	roles, _, err := UserRepo.ListAllUserRoles(ctx, user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "ROLE_NAME",
+		Sort:     "ASC",
+	})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	domains := make([]string, 0)
+	for _, role := range roles {
+		if !seen[role.RoleDomain] {
+			seen[role.RoleDomain] = true
+			domains = append(domains, role.RoleDomain)
+		}
+	}
+	return domains, nil
+}
+
+// canManageUser check whether the authenticated admin may manage the user. With "auth.tenantadmin.scoped", an admin
+// of a tenant only manages the users belonging to tenants they administer, a user shared with another tenant is left
+// to the hansip admin. A user of no tenant yet is only managed when onboarding it into the admin's tenant.
+func canManageUser(ctx context.Context, authCtx *hansipcontext.AuthenticationContext, user *connector.User, onboarding bool) (bool, error) {
+	if !config.GetBoolean("auth.tenantadmin.scoped") || isSuperAdmin(authCtx) {
+		return true, nil
+	}
+	domains, err := userTenantDomains(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	if len(domains) == 0 {
+		return onboarding, nil
+	}
+	for _, domain := range domains {
+		if !authCtx.IsAdminOfDomain(domain) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// userAdminRoles list the admin roles of the tenants of the user, the roles required to manage it.
+// A user of no tenant yet is only managed by the hansip admin.
+func userAdminRoles(ctx context.Context, user *connector.User) []string {
+	domains, err := userTenantDomains(ctx, user)
+	if err != nil || len(domains) == 0 {
+		domains = []string{config.Get("hansip.domain")}
+	}
+	roles := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		roles = append(roles, fmt.Sprintf("%s@%s", config.Get("hansip.admin"), domain))
+	}
+	return roles
+}
+
+// authorizeUserManagement check whether the authenticated admin may manage the user, responding 403 if not.
+// Returns false if the request should not proceed.
+func authorizeUserManagement(w http.ResponseWriter, r *http.Request, user *connector.User, onboarding bool) bool {
+	fLog := tenantAdminLog.WithField("func", "authorizeUserManagement").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	if !ok || authCtx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return false
+	}
+	allowed, err := canManageUser(r.Context(), authCtx, user, onboarding)
+	if err != nil {
+		fLog.Errorf("canManageUser got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return false
+	}
+	if !allowed {
+		recordAdminDenial(r, authCtx, userAdminRoles(r.Context(), user))
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to manage a user of another tenant", nil, nil)
+		return false
+	}
+	return true
+}
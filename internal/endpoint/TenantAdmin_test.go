@@ -0,0 +1,125 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type tenantUserRepo struct {
+	connector.UserRepository
+	users   []*connector.User
+	domains map[string][]string
+	deleted map[string]bool
+}
+
+func (repo *tenantUserRepo) GetUserByRecID(ctx context.Context, recID string) (*connector.User, error) {
+	for _, user := range repo.users {
+		if user.RecID == recID {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *tenantUserRepo) ListUser(ctx context.Context, request *helper.PageRequest) ([]*connector.User, *helper.Page, error) {
+	return repo.users, &helper.Page{}, nil
+}
+
+func (repo *tenantUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	roles := make([]*connector.Role, 0)
+	for _, domain := range repo.domains[user.RecID] {
+		roles = append(roles, &connector.Role{RoleName: "user", RoleDomain: domain})
+	}
+	return roles, nil, nil
+}
+
+func (repo *tenantUserRepo) DeleteUser(ctx context.Context, user *connector.User) error {
+	repo.deleted[user.RecID] = true
+	return nil
+}
+
+func TestTenantAdminScope(t *testing.T) {
+	repo := &tenantUserRepo{
+		users: []*connector.User{
+			{RecID: "acme1", Email: "wile@acme.com"},
+			{RecID: "globex1", Email: "hank@globex.com"},
+			{RecID: "shared1", Email: "sam@acme.com"},
+			{RecID: "new1", Email: "new@acme.com"},
+		},
+		domains: map[string][]string{
+			"acme1":   {"acme"},
+			"globex1": {"globex"},
+			"shared1": {"acme", "globex"},
+		},
+		deleted: make(map[string]bool),
+	}
+	UserRepo = repo
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+
+	request := func(method, path, admin string) *http.Request {
+		req := httptest.NewRequest(method, fmt.Sprintf("%s%s", apiPrefix, path), nil)
+		return req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  fmt.Sprintf("boss@%s.com", admin),
+			Audience: []string{fmt.Sprintf("admin@%s", admin)},
+		}))
+	}
+
+	testData := []struct {
+		admin  string
+		userID string
+		expect int
+	}{
+		{"acme", "acme1", http.StatusOK},
+		{"acme", "globex1", http.StatusForbidden},
+		{"acme", "shared1", http.StatusForbidden},
+		{"acme", "new1", http.StatusForbidden},
+		{"globex", "acme1", http.StatusForbidden},
+		{"hansip", "globex1", http.StatusOK},
+		{"hansip", "shared1", http.StatusOK},
+	}
+	for _, td := range testData {
+		recorder := httptest.NewRecorder()
+		GetUserDetail(recorder, request(http.MethodGet, "/management/user/"+td.userID, td.admin))
+		if recorder.Code != td.expect {
+			t.Errorf("admin of %s getting user %s expect %d but %d", td.admin, td.userID, td.expect, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	DeleteUser(recorder, request(http.MethodDelete, "/management/user/globex1", "acme"))
+	if recorder.Code != http.StatusForbidden || repo.deleted["globex1"] {
+		t.Errorf("admin of acme should not delete a user of globex. got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	DeleteUser(recorder, request(http.MethodDelete, "/management/user/acme1", "acme"))
+	if recorder.Code != http.StatusOK || !repo.deleted["acme1"] {
+		t.Errorf("admin of acme should delete a user of acme. got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	ListAllUsers(recorder, request(http.MethodGet, "/management/users", "globex"))
+	response := &struct {
+		Data struct {
+			Users []*SimpleUser `json:"users"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data.Users) != 1 || response.Data.Users[0].RecID != "globex1" {
+		t.Errorf("admin of globex should only list the users of globex. got %d users", len(response.Data.Users))
+	}
+
+	if allowed, _ := canManageUser(context.Background(), &hansipcontext.AuthenticationContext{Audience: []string{"admin@acme"}}, repo.users[3], true); !allowed {
+		t.Errorf("admin of acme should onboard a user of no tenant")
+	}
+}
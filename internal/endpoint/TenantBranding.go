@@ -0,0 +1,218 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tenantBrandingLog = log.WithField("go", "TenantBranding")
+
+	colorRegex = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// brandedUser is the data given to the email templates, the user's fields
+// such as {{.Email}} are available as before, and the branding as {{.Branding.ProductName}}.
+type brandedUser struct {
+	*connector.User
+	Branding *connector.TenantBranding
+}
+
+// defaultBranding returns the global branding from the "branding.*" configuration.
+func defaultBranding() *connector.TenantBranding {
+	return &connector.TenantBranding{
+		ProductName:  config.Get("branding.product.name"),
+		LogoURL:      config.Get("branding.logo.url"),
+		SupportEmail: config.Get("branding.support.email"),
+		PrimaryColor: config.Get("branding.primary.color"),
+	}
+}
+
+// withDefaultBranding fills the fields the tenant did not brand with the global branding.
+func withDefaultBranding(branding *connector.TenantBranding) *connector.TenantBranding {
+	ret := defaultBranding()
+	if branding == nil {
+		return ret
+	}
+	if len(branding.ProductName) > 0 {
+		ret.ProductName = branding.ProductName
+	}
+	if len(branding.LogoURL) > 0 {
+		ret.LogoURL = branding.LogoURL
+	}
+	if len(branding.SupportEmail) > 0 {
+		ret.SupportEmail = branding.SupportEmail
+	}
+	if len(branding.PrimaryColor) > 0 {
+		ret.PrimaryColor = branding.PrimaryColor
+	}
+	return ret
+}
+
+// userBranding returns the branding of the tenant the user belongs to, that is the first tenant having a branding
+// among the domains of the user's roles. A user without any role, e.g. just created, gets the branding of the tenant
+// of the admin making the request. The global branding is used when none of the tenants has a branding.
+func userBranding(ctx context.Context, user *connector.User) *connector.TenantBranding {
+	fLog := tenantBrandingLog.WithField("func", "userBranding").WithField("RequestID", ctx.Value(constants.RequestID))
+	domains := make([]string, 0)
+	roles, _, err := UserRepo.ListAllUserRoles(ctx, user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "ROLE_NAME",
+		Sort:     "ASC",
+	})
+	if err != nil {
+		fLog.Errorf("UserRepo.ListAllUserRoles got %s", err.Error())
+	}
+	for _, role := range roles {
+		domains = append(domains, role.RoleDomain)
+	}
+	if authCtx, ok := ctx.Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext); ok && len(domains) == 0 {
+		for _, aud := range authCtx.Audience {
+			if idx := strings.Index(aud, "@"); idx >= 0 {
+				domains = append(domains, aud[idx+1:])
+			}
+		}
+	}
+	sort.Strings(domains)
+	seen := make(map[string]bool)
+	for _, domain := range domains {
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		tenant, err := TenantRepo.GetTenantByDomain(ctx, domain)
+		if err != nil || tenant == nil {
+			continue
+		}
+		branding, err := TenantRepo.GetTenantBranding(ctx, tenant)
+		if err != nil {
+			fLog.Errorf("TenantRepo.GetTenantBranding got %s", err.Error())
+			continue
+		}
+		if branding != nil {
+			return withDefaultBranding(branding)
+		}
+	}
+	return defaultBranding()
+}
+
+// mailData returns the data given to the email templates sent to the user.
+func mailData(ctx context.Context, user *connector.User) interface{} {
+	return &brandedUser{
+		User:     user,
+		Branding: userBranding(ctx, user),
+	}
+}
+
+// validateBranding makes sure the branding fields that are set are well formed.
+func validateBranding(branding *connector.TenantBranding) error {
+	if len(branding.LogoURL) > 0 {
+		logoURL, err := url.Parse(branding.LogoURL)
+		if err != nil || (logoURL.Scheme != "http" && logoURL.Scheme != "https") || len(logoURL.Host) == 0 {
+			return fmt.Errorf("logo url %s is not a http or https url", branding.LogoURL)
+		}
+	}
+	if len(branding.SupportEmail) > 0 && !strings.Contains(branding.SupportEmail, "@") {
+		return fmt.Errorf("support email %s is not an email address", branding.SupportEmail)
+	}
+	if len(branding.PrimaryColor) > 0 && !colorRegex.MatchString(branding.PrimaryColor) {
+		return fmt.Errorf("primary color %s is not a hex color such as #0055AA", branding.PrimaryColor)
+	}
+	return nil
+}
+
+func brandingTenant(w http.ResponseWriter, r *http.Request, fLog *log.Entry) (*connector.Tenant, bool) {
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return nil, false
+	}
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/tenant/{tenantRecId}/branding", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	tenant, err := TenantRepo.GetTenantByRecID(r.Context(), params["tenantRecId"])
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return nil, false
+	}
+	if tenant == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Tenant recid %s not exist", params["tenantRecId"]), nil, nil)
+		return nil, false
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, tenant.Domain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
+		return nil, false
+	}
+	return tenant, true
+}
+
+// GetTenantBrandingDetail serving request to get the branding of a tenant, the global branding fills the fields the tenant did not brand
+func GetTenantBrandingDetail(w http.ResponseWriter, r *http.Request) {
+	fLog := tenantBrandingLog.WithField("func", "GetTenantBrandingDetail").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	tenant, ok := brandingTenant(w, r, fLog)
+	if !ok {
+		return
+	}
+	branding, err := TenantRepo.GetTenantBranding(r.Context(), tenant)
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantBranding got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant branding retrieved", nil, withDefaultBranding(branding))
+}
+
+// UpdateTenantBranding serving request to set the branding of a tenant. Empty fields use the global branding,
+// and a branding with all fields empty removes the tenant's branding
+func UpdateTenantBranding(w http.ResponseWriter, r *http.Request) {
+	fLog := tenantBrandingLog.WithField("func", "UpdateTenantBranding").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	tenant, ok := brandingTenant(w, r, fLog)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	branding := &connector.TenantBranding{}
+	err = json.Unmarshal(body, branding)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	if err := validateBranding(branding); err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	if *branding == (connector.TenantBranding{}) {
+		branding = nil
+	}
+	err = TenantRepo.SetTenantBranding(r.Context(), tenant, branding)
+	if err != nil {
+		fLog.Errorf("TenantRepo.SetTenantBranding got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant branding updated", nil, withDefaultBranding(branding))
+}
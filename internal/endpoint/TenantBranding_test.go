@@ -0,0 +1,140 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func (dir *memoryDirectory) GetTenantBranding(ctx context.Context, tenant *connector.Tenant) (*connector.TenantBranding, error) {
+	return dir.brandings[tenant.RecID], nil
+}
+
+func (dir *memoryDirectory) SetTenantBranding(ctx context.Context, tenant *connector.Tenant, branding *connector.TenantBranding) error {
+	if branding == nil {
+		delete(dir.brandings, tenant.RecID)
+		return nil
+	}
+	dir.brandings[tenant.RecID] = branding
+	return nil
+}
+
+// ListAllUserRoles returns the roles of the user and of its groups and their ancestors, as the database connectors do.
+func (dir *memoryDirectory) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	groupRoles, err := connector.ResolveEffectiveGroupRoles(ctx, dir, dir, dir.userGroups[user.RecID])
+	if err != nil {
+		return nil, nil, err
+	}
+	roles := append([]*connector.Role{}, dir.userRoles[user.RecID]...)
+	for _, groupRole := range groupRoles {
+		found := false
+		for _, role := range roles {
+			found = found || role.RecID == groupRole.RecID
+		}
+		if !found {
+			roles = append(roles, groupRole)
+		}
+	}
+	page, start, end := pageBounds(request, len(roles))
+	return roles[start:end], page, nil
+}
+
+type capturingSender struct {
+	mutex  sync.Mutex
+	bodies map[string]string
+}
+
+func (sender *capturingSender) SendEmail(ctx context.Context, to, cc, bcc []string, from, fromName, subject, body string) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	for _, recipient := range to {
+		sender.bodies[recipient] = subject + "\n" + body
+	}
+	return nil
+}
+
+func TestTenantBrandingEmails(t *testing.T) {
+	ctx := context.Background()
+	dir := newMemoryDirectory()
+	acme, _ := dir.CreateTenantRecord(ctx, "Acme", "acme", "Acme corp")
+	globex, _ := dir.CreateTenantRecord(ctx, "Globex", "globex", "Globex corp")
+	acmeUser, _ := dir.CreateRole(ctx, "user", "acme", "Acme user")
+	globexUser, _ := dir.CreateRole(ctx, "user", "globex", "Globex user")
+	alice, _ := dir.CreateUserRecord(ctx, "alice@acme.com", "secret")
+	bob, _ := dir.CreateUserRecord(ctx, "bob@globex.com", "secret")
+	carol, _ := dir.CreateUserRecord(ctx, "carol@example.com", "secret")
+	dir.CreateUserRole(ctx, alice, acmeUser)
+	dir.CreateUserRole(ctx, bob, globexUser)
+	dir.use()
+
+	updateBranding := func(tenant *connector.Tenant, admin, body string) int {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/management/tenant/%s/branding", apiPrefix, tenant.RecID), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@test.com",
+			Audience: []string{admin},
+		}))
+		recorder := httptest.NewRecorder()
+		UpdateTenantBranding(recorder, req)
+		return recorder.Code
+	}
+	if code := updateBranding(acme, "admin@acme", `{"product_name":"Acme Portal","support_email":"help@acme.com","primary_color":"#AA0000"}`); code != http.StatusOK {
+		t.Fatalf("tenant admin should brand the tenant. got %d", code)
+	}
+	if code := updateBranding(globex, "admin@acme", `{"product_name":"Not Globex"}`); code != http.StatusForbidden {
+		t.Errorf("admin of another tenant should not brand the tenant. got %d", code)
+	}
+	if code := updateBranding(globex, "admin@globex", `{"product_name":"Globex Hub","primary_color":"blue"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid color should be rejected. got %d", code)
+	}
+	if code := updateBranding(globex, "admin@globex", `{"product_name":"Globex Hub"}`); code != http.StatusOK {
+		t.Fatalf("tenant admin should brand the tenant. got %d", code)
+	}
+
+	sender := &capturingSender{bodies: make(map[string]string)}
+	mailer.Sender = sender
+	defer func() {
+		mailer.Sender = nil
+	}()
+	go mailer.Start()
+	for _, user := range []*connector.User{alice, bob, carol} {
+		mailer.Send(ctx, &mailer.Email{
+			To:       []string{user.Email},
+			Template: "EMAIL_VERIFY",
+			Data:     mailData(ctx, user),
+		})
+	}
+	mailer.Stop()
+
+	expect := map[string]string{
+		"alice@acme.com":    "Acme Portal",
+		"bob@globex.com":    "Globex Hub",
+		"carol@example.com": config.Get("branding.product.name"),
+	}
+	for recipient, productName := range expect {
+		mail := sender.bodies[recipient]
+		if !strings.Contains(mail, "Please verify your new "+productName+" account") || !strings.Contains(mail, productName+" team") {
+			t.Errorf("verification email to %s should be branded %s. got\n%s", recipient, productName, mail)
+		}
+		if !strings.Contains(mail, recipient) {
+			t.Errorf("verification email to %s should still carry the user's fields. got\n%s", recipient, mail)
+		}
+	}
+	if strings.Contains(sender.bodies["bob@globex.com"], "Acme") {
+		t.Errorf("globex user should not receive acme branding")
+	}
+
+	if code := updateBranding(acme, "admin@acme", `{}`); code != http.StatusOK || dir.brandings[acme.RecID] != nil {
+		t.Errorf("empty branding should remove the tenant's branding. got %d", code)
+	}
+}
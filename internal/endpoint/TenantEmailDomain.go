@@ -0,0 +1,196 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tenantEmailDomainLog = log.WithField("go", "TenantEmailDomain")
+)
+
+// TenantEmailDomains hold the email domains allowed for the users of a tenant, empty means any domain is allowed
+type TenantEmailDomains struct {
+	Domains []string `json:"domains" xml:"domains"`
+}
+
+// normalizeEmailDomains lower cases, sorts and removes the duplicates of the domains, refusing anything that is not a domain name.
+func normalizeEmailDomains(domains []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if len(domain) == 0 || strings.ContainsAny(domain, "@ ,") || !strings.Contains(domain, ".") {
+			return nil, fmt.Errorf("email domain %q is not a domain name such as example.com", domain)
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			normalized = append(normalized, domain)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// onboardingTenantDomains returns the domains of the tenants of the user making the request, the tenants a new user
+// created by that user joins. The same way the new user gets the branding of the admin's tenant.
+func onboardingTenantDomains(ctx context.Context) []string {
+	domains := make([]string, 0)
+	if authCtx, ok := ctx.Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext); ok {
+		for _, aud := range authCtx.Audience {
+			if idx := strings.Index(aud, "@"); idx >= 0 {
+				domains = append(domains, aud[idx+1:])
+			}
+		}
+	}
+	return domains
+}
+
+// validateTenantEmailDomains returns ErrEmailDomainNotAllowed if the email is not in the allowed email domains
+// of one of the tenants. A tenant without allowed email domains accepts any email.
+func validateTenantEmailDomains(ctx context.Context, tenantDomains []string, email string) error {
+	emailDomain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	seen := make(map[string]bool)
+	for _, tenantDomain := range tenantDomains {
+		if seen[tenantDomain] {
+			continue
+		}
+		seen[tenantDomain] = true
+		tenant, err := TenantRepo.GetTenantByDomain(ctx, tenantDomain)
+		if err != nil {
+			return err
+		}
+		if tenant == nil {
+			continue
+		}
+		allowed, err := TenantRepo.GetTenantEmailDomains(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		if len(allowed) == 0 {
+			continue
+		}
+		isAllowed := false
+		for _, domain := range allowed {
+			if domain == emailDomain {
+				isAllowed = true
+				break
+			}
+		}
+		if !isAllowed {
+			return &hansiperrors.ErrEmailDomainNotAllowed{Email: email, Tenant: tenant.Domain, Allowed: allowed}
+		}
+	}
+	return nil
+}
+
+// applyTenantEmailDomains validates the email against the allowed email domains of the tenants.
+// It writes the error response and returns false if the email is not allowed.
+func applyTenantEmailDomains(w http.ResponseWriter, r *http.Request, tenantDomains []string, email string) bool {
+	fLog := tenantEmailDomainLog.WithField("func", "applyTenantEmailDomains").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	err := validateTenantEmailDomains(r.Context(), tenantDomains, email)
+	if err != nil {
+		notAllowedErr := &hansiperrors.ErrEmailDomainNotAllowed{}
+		if errors.As(err, &notAllowedErr) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+			return false
+		}
+		fLog.Errorf("validateTenantEmailDomains got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return false
+	}
+	return true
+}
+
+func emailDomainsTenant(w http.ResponseWriter, r *http.Request, fLog *log.Entry) (*connector.Tenant, bool) {
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return nil, false
+	}
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/tenant/{tenantRecId}/email-domains", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	tenant, err := TenantRepo.GetTenantByRecID(r.Context(), params["tenantRecId"])
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return nil, false
+	}
+	if tenant == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Tenant recid %s not exist", params["tenantRecId"]), nil, nil)
+		return nil, false
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, tenant.Domain) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
+		return nil, false
+	}
+	return tenant, true
+}
+
+// GetTenantEmailDomains serving request to get the email domains allowed for the users of a tenant
+func GetTenantEmailDomains(w http.ResponseWriter, r *http.Request) {
+	fLog := tenantEmailDomainLog.WithField("func", "GetTenantEmailDomains").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	tenant, ok := emailDomainsTenant(w, r, fLog)
+	if !ok {
+		return
+	}
+	domains, err := TenantRepo.GetTenantEmailDomains(r.Context(), tenant)
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantEmailDomains got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant email domains retrieved", nil, &TenantEmailDomains{Domains: domains})
+}
+
+// UpdateTenantEmailDomains serving request to set the email domains allowed for the users of a tenant.
+// The users created in the tenant and the users of the tenant changing their email must have an email in one of them.
+// No domain removes the restriction.
+func UpdateTenantEmailDomains(w http.ResponseWriter, r *http.Request) {
+	fLog := tenantEmailDomainLog.WithField("func", "UpdateTenantEmailDomains").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	tenant, ok := emailDomainsTenant(w, r, fLog)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	req := &TenantEmailDomains{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	domains, err := normalizeEmailDomains(req.Domains)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	err = TenantRepo.SetTenantEmailDomains(r.Context(), tenant, domains)
+	if err != nil {
+		fLog.Errorf("TenantRepo.SetTenantEmailDomains got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant email domains updated", nil, &TenantEmailDomains{Domains: domains})
+}
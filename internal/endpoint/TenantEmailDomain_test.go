@@ -0,0 +1,297 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type emailDomainTenantRepo struct {
+	connector.TenantRepository
+	tenants      []*connector.Tenant
+	emailDomains map[string][]string
+}
+
+func (repo *emailDomainTenantRepo) GetTenantByDomain(ctx context.Context, tenantDomain string) (*connector.Tenant, error) {
+	for _, tenant := range repo.tenants {
+		if tenant.Domain == tenantDomain {
+			return tenant, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *emailDomainTenantRepo) GetTenantByRecID(ctx context.Context, recID string) (*connector.Tenant, error) {
+	for _, tenant := range repo.tenants {
+		if tenant.RecID == recID {
+			return tenant, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *emailDomainTenantRepo) GetTenantEmailDomains(ctx context.Context, tenant *connector.Tenant) ([]string, error) {
+	return repo.emailDomains[tenant.RecID], nil
+}
+
+func (repo *emailDomainTenantRepo) SetTenantEmailDomains(ctx context.Context, tenant *connector.Tenant, domains []string) error {
+	repo.emailDomains[tenant.RecID] = domains
+	return nil
+}
+
+type emailDomainUserRepo struct {
+	emailChangeUserRepo
+}
+
+func (repo *emailDomainUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	return []*connector.Role{{RecID: "r1", RoleName: "user", RoleDomain: "acme"}}, nil, nil
+}
+
+func newEmailDomainTenantRepo() *emailDomainTenantRepo {
+	return &emailDomainTenantRepo{
+		tenants: []*connector.Tenant{
+			{RecID: "t1", Name: "Acme", Domain: "acme"},
+			{RecID: "t2", Name: "Globex", Domain: "globex"},
+			{RecID: "t3", Name: "Open", Domain: "open"},
+		},
+		emailDomains: map[string][]string{
+			"t1": {"acme.com", "acme.co.id"},
+			"t2": {"globex.com"},
+		},
+	}
+}
+
+func TestValidateTenantEmailDomains(t *testing.T) {
+	TenantRepo = newEmailDomainTenantRepo()
+	for _, test := range []struct {
+		tenants []string
+		email   string
+		allowed bool
+	}{
+		{[]string{"acme"}, "alice@acme.com", true},
+		{[]string{"acme"}, "alice@ACME.co.id", true},
+		{[]string{"acme"}, "alice@globex.com", false},
+		{[]string{"acme"}, "alice@mail.acme.com", false},
+		{[]string{"globex"}, "bob@globex.com", true},
+		{[]string{"globex"}, "bob@acme.com", false},
+		{[]string{"open"}, "carol@anywhere.org", true},
+		{[]string{"unknown"}, "carol@anywhere.org", true},
+		{[]string{"acme", "globex"}, "dave@acme.com", false},
+		{[]string{}, "eve@anywhere.org", true},
+	} {
+		err := validateTenantEmailDomains(context.Background(), test.tenants, test.email)
+		notAllowedErr := &hansiperrors.ErrEmailDomainNotAllowed{}
+		if test.allowed && err != nil {
+			t.Errorf("expect %s allowed in %v. got %s", test.email, test.tenants, err.Error())
+		}
+		if !test.allowed && !errors.As(err, &notAllowedErr) {
+			t.Errorf("expect %s not allowed in %v. got %v", test.email, test.tenants, err)
+		}
+	}
+}
+
+func TestNormalizeEmailDomains(t *testing.T) {
+	domains, err := normalizeEmailDomains([]string{" Acme.com", "acme.co.id", "ACME.COM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(domains, ",") != "acme.co.id,acme.com" {
+		t.Errorf("expect sorted unique lower case domains. got %v", domains)
+	}
+	for _, invalid := range []string{"", "@acme.com", "acme", "acme .com"} {
+		if _, err := normalizeEmailDomains([]string{invalid}); err == nil {
+			t.Errorf("expect %q refused", invalid)
+		}
+	}
+}
+
+func TestCreateNewUserTenantEmailDomains(t *testing.T) {
+	TenantRepo = newEmailDomainTenantRepo()
+	createUser := func(admin, email string) (int, string) {
+		body := fmt.Sprintf(`{"email":"%s","passphrase":"correct horse battery staple"}`, email)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/user", apiPrefix), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@test.com",
+			Audience: []string{admin},
+		}))
+		recorder := httptest.NewRecorder()
+		CreateNewUser(recorder, req)
+		return recorder.Code, recorder.Body.String()
+	}
+	if code, body := createUser("admin@acme", "alice@globex.com"); code != http.StatusBadRequest || !strings.Contains(body, "acme.com, acme.co.id") {
+		t.Errorf("expect an acme admin refused a globex.com user. got %d %s", code, body)
+	}
+	if code, body := createUser("admin@globex", "bob@acme.com"); code != http.StatusBadRequest || !strings.Contains(body, "globex.com") {
+		t.Errorf("expect a globex admin refused an acme.com user. got %d %s", code, body)
+	}
+}
+
+func TestChangeEmailTenantEmailDomains(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret passphrase"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	alice := &connector.User{RecID: "u1", Email: "alice@acme.com", HashedPassphrase: string(hashed)}
+	UserRepo = &emailDomainUserRepo{emailChangeUserRepo{users: []*connector.User{alice}, verified: make(map[string]bool)}}
+	TenantRepo = newEmailDomainTenantRepo()
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/change-email", apiPrefix), strings.NewReader(`{"new_email":"alice@gmail.com","passphrase":"secret passphrase"}`))
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  alice.Email,
+		Audience: []string{"user@acme"},
+	}))
+	recorder := httptest.NewRecorder()
+	ChangeEmail(recorder, req)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "alice@gmail.com is not allowed by tenant acme") {
+		t.Errorf("expect an acme user refused to change to a gmail.com email. got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestTenantEmailDomainsEndpoint(t *testing.T) {
+	repo := newEmailDomainTenantRepo()
+	TenantRepo = repo
+	call := func(method, audience, body string) (int, string) {
+		req := httptest.NewRequest(method, fmt.Sprintf("%s/management/tenant/t2/email-domains", apiPrefix), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@test.com",
+			Audience: []string{audience},
+		}))
+		recorder := httptest.NewRecorder()
+		if method == http.MethodGet {
+			GetTenantEmailDomains(recorder, req)
+		} else {
+			UpdateTenantEmailDomains(recorder, req)
+		}
+		return recorder.Code, recorder.Body.String()
+	}
+	if code, _ := call(http.MethodPut, "admin@acme", `{"domains":["acme.com"]}`); code != http.StatusForbidden {
+		t.Errorf("expect an acme admin forbidden to set the globex domains. got %d", code)
+	}
+	if code, _ := call(http.MethodPut, "admin@globex", `{"domains":["not a domain"]}`); code != http.StatusBadRequest {
+		t.Errorf("expect an invalid domain refused. got %d", code)
+	}
+	if code, body := call(http.MethodPut, "admin@globex", `{"domains":["Globex.com","initech.com"]}`); code != http.StatusOK {
+		t.Fatalf("expect the domains updated. got %d %s", code, body)
+	}
+	if strings.Join(repo.emailDomains["t2"], ",") != "globex.com,initech.com" {
+		t.Errorf("expect the normalized domains stored. got %v", repo.emailDomains["t2"])
+	}
+	if code, body := call(http.MethodGet, "admin@globex", ""); code != http.StatusOK || !strings.Contains(body, "initech.com") {
+		t.Errorf("expect the domains returned. got %d %s", code, body)
+	}
+	if code, _ := call(http.MethodPut, "admin@globex", `{"domains":[]}`); code != http.StatusOK || len(repo.emailDomains["t2"]) != 0 {
+		t.Errorf("expect the restriction removed. got %d %v", code, repo.emailDomains["t2"])
+	}
+}
+
+func TestCreateRoleUserTenantEmailDomains(t *testing.T) {
+	TenantRepo = newEmailDomainTenantRepo()
+	UserRepo = &tenantUserRepo{
+		users:   []*connector.User{{RecID: "acme1", Email: "wile@gmail.com"}},
+		domains: map[string][]string{"acme1": {"acme"}},
+	}
+	repo := &bulkMembershipRepo{members: map[string]bool{}}
+	RoleRepo, UserRoleRepo = repo, repo
+
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/management/role/r1/user/acme1", apiPrefix), nil)
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "boss@acme.com",
+		Audience: []string{"admin@acme"},
+	}))
+	recorder := httptest.NewRecorder()
+	CreateRoleUser(recorder, req)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "wile@gmail.com is not allowed by tenant acme") {
+		t.Errorf("expect a gmail.com user refused an acme role. got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if repo.members["acme1"] {
+		t.Errorf("user should not be added to the role")
+	}
+}
+
+func TestMembershipTenantEmailDomains(t *testing.T) {
+	TenantRepo = newEmailDomainTenantRepo()
+	UserRepo = &tenantUserRepo{
+		users: []*connector.User{
+			{RecID: "acme1", Email: "wile@acme.com"},
+			{RecID: "gmail1", Email: "wile@gmail.com"},
+		},
+		domains: map[string][]string{"acme1": {"acme"}, "gmail1": {"acme"}},
+	}
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+
+	call := func(handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, fmt.Sprintf("%s%s", apiPrefix, path), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "boss@acme.com",
+			Audience: []string{"admin@acme"},
+		}))
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	repo := &bulkMembershipRepo{members: map[string]bool{}}
+	GroupRepo, UserGroupRepo = repo, repo
+	if recorder := call(CreateGroupUser, http.MethodPut, "/management/group/g1/user/gmail1", ""); recorder.Code != http.StatusBadRequest || repo.members["gmail1"] {
+		t.Errorf("expect a gmail.com user refused an acme group. got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := call(CreateGroupUser, http.MethodPut, "/management/group/g1/user/acme1", ""); recorder.Code != http.StatusOK || !repo.members["acme1"] {
+		t.Errorf("expect an acme.com user added to an acme group. got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := call(CreateGroupUser, http.MethodPut, "/management/group/g1/user/nobody", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("expect an unknown user not found. got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	repo = &bulkMembershipRepo{members: map[string]bool{"gmail1": true}}
+	GroupRepo, UserGroupRepo = repo, repo
+	if recorder := call(DeleteGroupUser, http.MethodDelete, "/management/group/g1/user/gmail1", ""); recorder.Code != http.StatusOK || repo.members["gmail1"] {
+		t.Errorf("a user of a disallowed email domain should still be removable from the group. got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	repo = &bulkMembershipRepo{members: map[string]bool{"gmail1": true}}
+	RoleRepo, UserRoleRepo = repo, repo
+	if recorder := call(SetUserRoles, http.MethodPut, "/management/user/gmail1/roles", `["r1"]`); recorder.Code != http.StatusBadRequest || !repo.members["gmail1"] {
+		t.Errorf("expect the roles of a gmail.com user kept when an acme role is refused. got %d %s", recorder.Code, recorder.Body.String())
+	}
+	repo = &bulkMembershipRepo{members: map[string]bool{}}
+	RoleRepo, UserRoleRepo = repo, repo
+	if recorder := call(SetUserRoles, http.MethodPut, "/management/user/acme1/roles", `["r1"]`); recorder.Code != http.StatusOK || !repo.members["acme1"] {
+		t.Errorf("expect an acme.com user given an acme role. got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	repo = &bulkMembershipRepo{members: map[string]bool{"gmail1": true}}
+	GroupRepo, UserGroupRepo = repo, repo
+	if recorder := call(SetUserGroups, http.MethodPut, "/management/user/gmail1/groups", `["g1"]`); recorder.Code != http.StatusBadRequest || !repo.members["gmail1"] {
+		t.Errorf("expect the groups of a gmail.com user kept when an acme group is refused. got %d %s", recorder.Code, recorder.Body.String())
+	}
+	repo = &bulkMembershipRepo{members: map[string]bool{}}
+	GroupRepo, UserGroupRepo = repo, repo
+	if recorder := call(SetUserGroups, http.MethodPut, "/management/user/acme1/groups", `["g1"]`); recorder.Code != http.StatusOK || !repo.members["acme1"] {
+		t.Errorf("expect an acme.com user joined an acme group. got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	repo = &bulkMembershipRepo{members: map[string]bool{}}
+	RoleRepo, UserRoleRepo = repo, repo
+	if recorder := call(SetRoleUsers, http.MethodPut, "/management/role/r1/users", `["gmail1","acme1"]`); recorder.Code != http.StatusOK || repo.members["gmail1"] || !repo.members["acme1"] {
+		t.Errorf("expect only the acme.com user set into the acme role. got %d %s %v", recorder.Code, recorder.Body.String(), repo.members)
+	}
+
+	repo = &bulkMembershipRepo{members: map[string]bool{}}
+	GroupRepo, UserGroupRepo = repo, repo
+	if recorder := call(SetGroupUsers, http.MethodPut, "/management/group/g1/users", `["gmail1","acme1"]`); recorder.Code != http.StatusOK || repo.members["gmail1"] || !repo.members["acme1"] {
+		t.Errorf("expect only the acme.com user set into the acme group. got %d %s %v", recorder.Code, recorder.Body.String(), repo.members)
+	}
+}
@@ -1,7 +1,6 @@
 package endpoint
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -33,9 +32,9 @@ func ListAllTenants(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -45,6 +44,14 @@ func ListAllTenants(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
+	for _, tenant := range tenants {
+		tenant.Region, err = TenantRepo.GetTenantRegion(r.Context(), tenant)
+		if err != nil {
+			fLog.Errorf("TenantRepo.GetTenantRegion got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+	}
 	ret := make(map[string]interface{})
 	ret["tenants"] = tenants
 	ret["page"] = page
@@ -53,9 +60,10 @@ func ListAllTenants(w http.ResponseWriter, r *http.Request) {
 
 // CreateTenantRequest hold model for Create new tenants
 type CreateTenantRequest struct {
-	TenantName   string `json:"name"`
-	TenantDomain string `json:"domain"`
-	Description  string `json:"description"`
+	TenantName   string `json:"name" xml:"name"`
+	TenantDomain string `json:"domain" xml:"domain"`
+	Description  string `json:"description" xml:"description"`
+	Region       string `json:"region" xml:"region"`
 }
 
 // CreateNewTenant serving request to create new tenant
@@ -67,7 +75,7 @@ func CreateNewTenant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(config.Get("hansip.domain")) {
+	if !authorizeAdminOfDomain(r, authCtx, config.Get("hansip.domain")) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -79,9 +87,9 @@ func CreateNewTenant(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -90,12 +98,23 @@ func CreateNewTenant(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Tenant domain contains @", nil, nil)
 		return
 	}
+	if err := validateTenantRegion(req.Region); err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
 	tenant, err := TenantRepo.CreateTenantRecord(r.Context(), req.TenantName, req.TenantDomain, req.Description)
 	if err != nil {
 		fLog.Errorf("TenantRepo.CreateTenantRecord got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
+	err = TenantRepo.SetTenantRegion(r.Context(), tenant, req.Region)
+	if err != nil {
+		fLog.Errorf("TenantRepo.SetTenantRegion got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	tenant.Region = req.Region
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success creating tenant", nil, tenant)
 	return
 }
@@ -128,6 +147,19 @@ func GetTenantDetail(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Tenant recid %s not exist", params["tenantRecId"]), nil, nil)
 		return
 	}
+	tenant.Region, err = TenantRepo.GetTenantRegion(r.Context(), tenant)
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantRegion got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	active, err := TenantRepo.IsTenantActive(r.Context(), tenant)
+	if err != nil {
+		fLog.Errorf("TenantRepo.IsTenantActive got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	tenant.Suspended = !active
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant retrieved", nil, tenant)
 }
 
@@ -140,7 +172,7 @@ func UpdateTenantDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(config.Get("hansip.domain")) {
+	if !authorizeAdminOfDomain(r, authCtx, config.Get("hansip.domain")) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -157,9 +189,13 @@ func UpdateTenantDetail(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	if err := validateTenantRegion(req.Region); err != nil {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -191,6 +227,13 @@ func UpdateTenantDetail(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
+	err = TenantRepo.SetTenantRegion(r.Context(), tenant, req.Region)
+	if err != nil {
+		fLog.Errorf("TenantRepo.SetTenantRegion got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	tenant.Region = req.Region
 
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant updated", nil, tenant)
 
@@ -205,7 +248,7 @@ func DeleteTenant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(config.Get("hansip.domain")) {
+	if !authorizeAdminOfDomain(r, authCtx, config.Get("hansip.domain")) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
 		return
 	}
@@ -0,0 +1,204 @@
+package endpoint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/internal/passphrase"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// provisionAdminGroup is the group of the tenant admins created with a provisioned tenant
+	provisionAdminGroup = "admins"
+)
+
+var (
+	tenantProvisionLog = log.WithField("go", "TenantProvisioning")
+)
+
+// ProvisionTenantRequest hold model for provisioning a tenant with its first admin
+type ProvisionTenantRequest struct {
+	TenantName      string `json:"name" xml:"name"`
+	TenantDomain    string `json:"domain" xml:"domain"`
+	Description     string `json:"description" xml:"description"`
+	Region          string `json:"region" xml:"region"`
+	AdminEmail      string `json:"admin_email" xml:"admin_email"`
+	AdminPassphrase string `json:"admin_passphrase" xml:"admin_passphrase"`
+}
+
+// ProvisionTenantResponse is the provisioned tenant, its roles and groups and its first admin
+type ProvisionTenantResponse struct {
+	Tenant *connector.Tenant      `json:"tenant" xml:"tenant"`
+	Roles  []*connector.Role      `json:"roles" xml:"roles"`
+	Groups []*connector.Group     `json:"groups" xml:"groups"`
+	Admin  *CreateNewUserResponse `json:"admin" xml:"admin"`
+}
+
+// provisionRoles returns the names of the roles created with every provisioned tenant, the tenant admin role
+// followed by the roles of "tenant.provision.roles".
+func provisionRoles() []string {
+	roles := []string{config.Get("hansip.admin")}
+	for _, role := range config.GetList("tenant.provision.roles") {
+		if role != roles[0] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// newTenantProvision returns the tenant, its roles, the admin group holding the admin role and the admin user in that group,
+// to be stored together by TenantRepo.ProvisionTenant.
+func newTenantProvision(req *ProvisionTenantRequest) (*connector.TenantProvision, error) {
+	tenant := &connector.Tenant{
+		RecID:       connector.NewRecID(),
+		Name:        req.TenantName,
+		Domain:      req.TenantDomain,
+		Description: req.Description,
+		Region:      req.Region,
+	}
+	provision := &connector.TenantProvision{Tenant: tenant}
+	for _, roleName := range provisionRoles() {
+		provision.Roles = append(provision.Roles, &connector.Role{
+			RecID:       connector.NewRecID(),
+			RoleName:    roleName,
+			RoleDomain:  tenant.Domain,
+			Description: fmt.Sprintf("%s role of %s", roleName, tenant.Name),
+		})
+	}
+	group := &connector.Group{
+		RecID:       connector.NewRecID(),
+		GroupName:   provisionAdminGroup,
+		GroupDomain: tenant.Domain,
+		Description: fmt.Sprintf("Admins of %s", tenant.Name),
+	}
+	provision.Groups = []*connector.Group{group}
+	admin, err := connector.NewUserRecord(req.AdminEmail, req.AdminPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	provision.Admin = admin
+	adminRole := provision.Roles[0]
+	provision.GroupRoles = []*connector.GroupRole{{GroupRecID: group.RecID, RoleRecID: adminRole.RecID}}
+	provision.UserRoles = []*connector.UserRole{{UserRecID: admin.RecID, RoleRecID: adminRole.RecID}}
+	provision.UserGroups = []*connector.UserGroup{{UserRecID: admin.RecID, GroupRecID: group.RecID}}
+	return provision, nil
+}
+
+// ProvisionTenant serving request to create a tenant ready to use in one call, with its roles of "tenant.provision.roles",
+// the admin role and an admin group, and its first admin user who is emailed an activation link.
+// Everything is stored in a single transaction, nothing is created if any of it fails.
+func ProvisionTenant(w http.ResponseWriter, r *http.Request) {
+	fLog := tenantProvisionLog.WithField("func", "ProvisionTenant").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if !authorizeAdminOfDomain(r, authCtx, config.Get("hansip.domain")) {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access this resource", nil, nil)
+		return
+	}
+
+	req := &ProvisionTenantRequest{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
+	if err != nil {
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	if len(req.TenantDomain) == 0 || strings.Contains(req.TenantDomain, "@") {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Tenant domain is empty or contains @", nil, nil)
+		return
+	}
+	if err := validateTenantRegion(req.Region); err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	req.AdminEmail = NormalizeEmail(req.AdminEmail)
+	if err := ValidateEmailAddress(r.Context(), req.AdminEmail); err != nil {
+		fLog.Errorf("ValidateEmailAddress got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	isValidPassphrase := passphrase.Validate(req.AdminPassphrase, config.GetInt("security.passphrase.minchars"), config.GetInt("security.passphrase.minwords"), config.GetInt("security.passphrase.mincharsinword"))
+	if !isValidPassphrase {
+		invalidMsg := fmt.Sprintf("Invalid passphrase. Passphrase must at least has %d characters and %d words and for each word have minimum %d characters", config.GetInt("security.passphrase.minchars"), config.GetInt("security.passphrase.minwords"), config.GetInt("security.passphrase.mincharsinword"))
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid admin passphrase", nil, invalidMsg)
+		return
+	}
+	if !applyPassphraseBreachCheck(w, r, req.AdminPassphrase) {
+		return
+	}
+
+	// the existing tenant or user would fail the provisioning, they are refused with a conflict instead
+	existingTenant, err := TenantRepo.GetTenantByDomain(r.Context(), req.TenantDomain)
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantByDomain got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if existingTenant != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, fmt.Sprintf("Tenant domain %s already exist", req.TenantDomain), nil, nil)
+		return
+	}
+	existingUser, err := UserRepo.GetUserByEmail(r.Context(), req.AdminEmail)
+	if err != nil {
+		fLog.Errorf("UserRepo.GetUserByEmail got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if existingUser != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, fmt.Sprintf("User %s already exist", req.AdminEmail), nil, nil)
+		return
+	}
+
+	provision, err := newTenantProvision(req)
+	if err != nil {
+		fLog.Errorf("newTenantProvision got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if err := TenantRepo.ProvisionTenant(r.Context(), provision); err != nil {
+		fLog.Errorf("TenantRepo.ProvisionTenant got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, fmt.Sprintf("Provisioning tenant %s failed, nothing is created. got %s", req.TenantDomain, err.Error()), nil, nil)
+		return
+	}
+
+	mailer.Send(r.Context(), &mailer.Email{
+		From:     config.Get("mailer.from"),
+		FromName: config.Get("mailer.from.name"),
+		To:       []string{provision.Admin.Email},
+		Template: "EMAIL_VERIFY",
+		Data:     mailData(r.Context(), provision.Admin),
+	})
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success provisioning tenant", nil, &ProvisionTenantResponse{
+		Tenant: provision.Tenant,
+		Roles:  provision.Roles,
+		Groups: provision.Groups,
+		Admin: &CreateNewUserResponse{
+			RecordID:    provision.Admin.RecID,
+			Email:       provision.Admin.Email,
+			Enabled:     provision.Admin.Enabled,
+			Suspended:   provision.Admin.Suspended,
+			LastSeen:    provision.Admin.LastSeen,
+			LastLogin:   provision.Admin.LastLogin,
+			TotpEnabled: provision.Admin.Enable2FactorAuth,
+		},
+	})
+}
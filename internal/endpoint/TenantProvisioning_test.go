@@ -0,0 +1,137 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+)
+
+func (dir *memoryDirectory) ProvisionTenant(ctx context.Context, provision *connector.TenantProvision) error {
+	dir.tenants = append(dir.tenants, provision.Tenant)
+	dir.regions[provision.Tenant.RecID] = provision.Tenant.Region
+	dir.roles = append(dir.roles, provision.Roles...)
+	dir.groups = append(dir.groups, provision.Groups...)
+	dir.users = append(dir.users, provision.Admin)
+	for _, groupRole := range provision.GroupRoles {
+		role, _ := dir.GetRoleByRecID(ctx, groupRole.RoleRecID)
+		dir.groupRoles[groupRole.GroupRecID] = append(dir.groupRoles[groupRole.GroupRecID], role)
+	}
+	for _, userRole := range provision.UserRoles {
+		role, _ := dir.GetRoleByRecID(ctx, userRole.RoleRecID)
+		dir.userRoles[userRole.UserRecID] = append(dir.userRoles[userRole.UserRecID], role)
+	}
+	for _, userGroup := range provision.UserGroups {
+		group, _ := dir.GetGroupByRecID(ctx, userGroup.GroupRecID)
+		dir.userGroups[userGroup.UserRecID] = append(dir.userGroups[userGroup.UserRecID], group)
+	}
+	return nil
+}
+
+// failingProvisionDirectory fails storing the provisioned tenant, as the transaction rolled back
+type failingProvisionDirectory struct {
+	*memoryDirectory
+}
+
+func (dir *failingProvisionDirectory) ProvisionTenant(ctx context.Context, provision *connector.TenantProvision) error {
+	return errors.New("connection lost")
+}
+
+func provisionTenant(t *testing.T, body string) (int, *ProvisionTenantResponse) {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/management/tenants", apiPrefix), bytes.NewReader([]byte(body)))
+	req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  "admin@hansip",
+		Audience: []string{fmt.Sprintf("%s@%s", config.Get("hansip.admin"), config.Get("hansip.domain"))},
+	}))
+	recorder := httptest.NewRecorder()
+	ProvisionTenant(recorder, req)
+	response := &struct {
+		Data *ProvisionTenantResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, response.Data
+}
+
+func TestProvisionTenant(t *testing.T) {
+	ctx := context.Background()
+	dir := seedDirectory(ctx)
+	dir.use()
+	config.Set("tenant.provision.roles", "viewer, editor")
+	defer config.Set("tenant.provision.roles", "")
+	body := `{"name":"Initech","domain":"initech","region":"eu-west","admin_email":"peter@initech.com","admin_passphrase":"tps reports every friday"}`
+
+	status, provisioned := provisionTenant(t, body)
+	if status != http.StatusOK {
+		t.Fatalf("expect the tenant provisioned. got %d", status)
+	}
+	select {
+	case mail := <-mailer.MailerChannel:
+		if mail.To[0] != "peter@initech.com" || mail.Template != "EMAIL_VERIFY" {
+			t.Errorf("expect the activation email sent to the admin. got %s to %v", mail.Template, mail.To)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expect the activation email sent to the admin")
+	}
+	if provisioned.Tenant.Domain != "initech" || len(provisioned.Roles) != 3 || len(provisioned.Groups) != 1 || provisioned.Admin.Email != "peter@initech.com" {
+		t.Errorf("expect the provisioned tenant, roles, group and admin in the response. got %+v", provisioned)
+	}
+	lines := dir.describe()
+	for _, line := range []string{
+		"tenant initech eu-west",
+		fmt.Sprintf("role %s@initech", config.Get("hansip.admin")),
+		"role viewer@initech",
+		"role editor@initech",
+		"group admins@initech",
+		fmt.Sprintf("group admins role %s", config.Get("hansip.admin")),
+		"user peter@initech.com false",
+		fmt.Sprintf("user peter@initech.com role %s", config.Get("hansip.admin")),
+		"user peter@initech.com group admins",
+	} {
+		if !hasLine(lines, line) {
+			t.Errorf("expect %q provisioned. got\n%s", line, strings.Join(lines, "\n"))
+		}
+	}
+
+	// provisioning the same tenant again is refused without touching it
+	if status, _ := provisionTenant(t, body); status != http.StatusConflict {
+		t.Errorf("expect an existing tenant refused. got %d", status)
+	}
+	if after := dir.describe(); strings.Join(after, "\n") != strings.Join(lines, "\n") {
+		t.Errorf("expect the existing tenant untouched. got\n%s", strings.Join(after, "\n"))
+	}
+}
+
+func TestProvisionTenantFailed(t *testing.T) {
+	ctx := context.Background()
+	dir := seedDirectory(ctx)
+	dir.use()
+	before := dir.describe()
+	TenantRepo = &failingProvisionDirectory{memoryDirectory: dir}
+
+	status, _ := provisionTenant(t, `{"name":"Initech","domain":"initech","admin_email":"peter@initech.com","admin_passphrase":"tps reports every friday"}`)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("expect the provisioning failed. got %d", status)
+	}
+	if after := dir.describe(); strings.Join(after, "\n") != strings.Join(before, "\n") {
+		t.Errorf("expect nothing of the tenant left. got\n%s", strings.Join(after, "\n"))
+	}
+	select {
+	case mail := <-mailer.MailerChannel:
+		t.Errorf("expect no activation email of a failed provisioning. got %s to %v", mail.Template, mail.To)
+	default:
+	}
+}
@@ -0,0 +1,62 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tenantRegionLog = log.WithField("go", "TenantRegion")
+)
+
+// validateTenantRegion makes sure the region is one of the regions allowed in "tenant.region.allowed".
+// An empty region is always valid, it means the tenant has no region.
+func validateTenantRegion(region string) error {
+	if len(region) == 0 {
+		return nil
+	}
+	for _, allowed := range config.GetList("tenant.region.allowed") {
+		if allowed == region {
+			return nil
+		}
+	}
+	return fmt.Errorf("region %s is not allowed", region)
+}
+
+// regionClaims returns the additional token claims carrying the region of the tenants owning the specified role domains.
+// No region claim is returned when none of the tenants has a region, or when the tenants disagree on the region.
+func regionClaims(ctx context.Context, roleDomains []string) map[string]interface{} {
+	fLog := tenantRegionLog.WithField("func", "regionClaims")
+	region := ""
+	seen := make(map[string]bool)
+	for _, domain := range roleDomains {
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		tenant, err := TenantRepo.GetTenantByDomain(ctx, domain)
+		if err != nil || tenant == nil {
+			continue
+		}
+		tenantRegion, err := TenantRepo.GetTenantRegion(ctx, tenant)
+		if err != nil {
+			fLog.Errorf("TenantRepo.GetTenantRegion got %s", err.Error())
+			continue
+		}
+		if len(tenantRegion) == 0 {
+			continue
+		}
+		if len(region) > 0 && region != tenantRegion {
+			fLog.Warnf("tenants of role domains %v reside in different regions %s and %s. region claim is omitted", roleDomains, region, tenantRegion)
+			return nil
+		}
+		region = tenantRegion
+	}
+	if len(region) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"region": region}
+}
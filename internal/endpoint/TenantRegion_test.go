@@ -0,0 +1,110 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type regionUserRepo struct {
+	deactivationUserRepo
+}
+
+func (repo *regionUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	return []*connector.Role{{RecID: "r1"}}, nil, nil
+}
+
+type regionRoleRepo struct {
+	connector.RoleRepository
+}
+
+func (repo *regionRoleRepo) GetRoleByRecID(ctx context.Context, recID string) (*connector.Role, error) {
+	return &connector.Role{RecID: recID, RoleName: "user", RoleDomain: "acme"}, nil
+}
+
+type regionTenantRepo struct {
+	connector.TenantRepository
+	regions map[string]string
+}
+
+func (repo *regionTenantRepo) GetTenantByDomain(ctx context.Context, tenantDomain string) (*connector.Tenant, error) {
+	if tenantDomain == "acme" {
+		return &connector.Tenant{RecID: "t1", Name: "Acme", Domain: "acme"}, nil
+	}
+	return nil, nil
+}
+
+func (repo *regionTenantRepo) GetTenantRegion(ctx context.Context, tenant *connector.Tenant) (string, error) {
+	return repo.regions[tenant.RecID], nil
+}
+
+func (repo *regionTenantRepo) IsTenantActive(ctx context.Context, tenant *connector.Tenant) (bool, error) {
+	return true, nil
+}
+
+func TestValidateTenantRegion(t *testing.T) {
+	config.Set("tenant.region.allowed", "eu-west, ap-southeast")
+	defer config.Set("tenant.region.allowed", "")
+	if err := validateTenantRegion("ap-southeast"); err != nil {
+		t.Errorf("ap-southeast should be allowed. got %s", err.Error())
+	}
+	if err := validateTenantRegion(""); err != nil {
+		t.Errorf("empty region should be allowed. got %s", err.Error())
+	}
+	if err := validateTenantRegion("us-east"); err == nil {
+		t.Errorf("us-east should not be allowed")
+	}
+}
+
+func TestRegionClaim(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", "test.issuer", 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}}
+	RoleRepo = &regionRoleRepo{}
+	tenantRepo := &regionTenantRepo{regions: map[string]string{"t1": "eu-west"}}
+	TenantRepo = tenantRepo
+
+	login := func() *helper.HansipToken {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(`{"email":"user@acme.com","passphrase":"abcdefg"}`))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+		}
+		response := &struct {
+			Data *Response `json:"data"`
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		tok, err := TokenFactory.ReadToken(response.Data.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	if tok := login(); tok.Additional["region"] != "eu-west" {
+		t.Errorf("expect region claim eu-west. got %v", tok.Additional["region"])
+	}
+
+	delete(tenantRepo.regions, "t1")
+	if tok := login(); tok.Additional["region"] != nil {
+		t.Errorf("expect no region claim for tenant without region. got %v", tok.Additional["region"])
+	}
+}
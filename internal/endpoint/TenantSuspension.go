@@ -0,0 +1,115 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/hansiperrors"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tenantSuspensionLog = log.WithField("go", "TenantSuspension")
+)
+
+// checkTenantsActive returns hansiperrors.ErrTenantSuspended if a tenant owning a role domain of the audience is suspended.
+// Hansip admins are never locked out, so they can still operate across tenants, eg. to reactivate a tenant.
+func checkTenantsActive(ctx context.Context, audience []string) error {
+	if (&hansipcontext.AuthenticationContext{Audience: audience}).IsAdminOfDomain(config.Get("hansip.domain")) {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, aud := range audience {
+		at := strings.LastIndex(aud, "@")
+		if at < 0 {
+			continue
+		}
+		domain := aud[at+1:]
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		tenant, err := TenantRepo.GetTenantByDomain(ctx, domain)
+		if err != nil {
+			return err
+		}
+		if tenant == nil {
+			continue
+		}
+		active, err := TenantRepo.IsTenantActive(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return &hansiperrors.ErrTenantSuspended{Domain: domain}
+		}
+	}
+	return nil
+}
+
+// writeTenantAccessError responds the error of checkTenantsActive. A suspended tenant is responded
+// with 403 and the "tenant.suspended.message", with "tenant_suspended" as the error in the data.
+func writeTenantAccessError(ctx context.Context, w http.ResponseWriter, err error) {
+	fLog := tenantSuspensionLog.WithField("func", "writeTenantAccessError").WithField("RequestID", ctx.Value(constants.RequestID))
+	suspended := &hansiperrors.ErrTenantSuspended{}
+	if errors.As(err, &suspended) {
+		fLog.Debugf("access denied. %s", err.Error())
+		helper.WriteHTTPResponse(ctx, w, http.StatusForbidden, config.Get("tenant.suspended.message"), nil, map[string]string{
+			"error":         "tenant_suspended",
+			"tenant_domain": suspended.Domain,
+		})
+		return
+	}
+	fLog.Errorf("checkTenantsActive got %s", err.Error())
+	helper.WriteHTTPResponse(ctx, w, http.StatusInternalServerError, err.Error(), nil, nil)
+}
+
+// SuspendTenant serve request to suspend a tenant, locking out all its users.
+func SuspendTenant(w http.ResponseWriter, r *http.Request) {
+	setTenantActive(w, r, "SuspendTenant", "suspend", false)
+}
+
+// ReactivateTenant serve request to restore the access of a suspended tenant's users.
+func ReactivateTenant(w http.ResponseWriter, r *http.Request) {
+	setTenantActive(w, r, "ReactivateTenant", "reactivate", true)
+}
+
+func setTenantActive(w http.ResponseWriter, r *http.Request, funcName, pathSuffix string, active bool) {
+	fLog := tenantSuspensionLog.WithField("func", funcName).WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/tenant/{tenantRecId}/%s", apiPrefix, pathSuffix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	tenant, err := TenantRepo.GetTenantByRecID(r.Context(), params["tenantRecId"])
+	if err != nil {
+		fLog.Errorf("TenantRepo.GetTenantByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if tenant == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Tenant recid %s not exist", params["tenantRecId"]), nil, nil)
+		return
+	}
+	if tenant.Domain == config.Get("hansip.domain") {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Hansip tenant can not be suspended", nil, nil)
+		return
+	}
+	err = TenantRepo.SetTenantActive(r.Context(), tenant, active)
+	if err != nil {
+		fLog.Errorf("TenantRepo.SetTenantActive got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant suspended", nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Tenant reactivated", nil, nil)
+}
@@ -0,0 +1,96 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type suspensionTenantRepo struct {
+	regionTenantRepo
+	suspended map[string]bool
+}
+
+func (repo *suspensionTenantRepo) GetTenantByRecID(ctx context.Context, recID string) (*connector.Tenant, error) {
+	if recID == "t1" {
+		return repo.GetTenantByDomain(ctx, "acme")
+	}
+	return nil, nil
+}
+
+func (repo *suspensionTenantRepo) SetTenantActive(ctx context.Context, tenant *connector.Tenant, active bool) error {
+	repo.suspended[tenant.RecID] = !active
+	return nil
+}
+
+func (repo *suspensionTenantRepo) IsTenantActive(ctx context.Context, tenant *connector.Tenant) (bool, error) {
+	return !repo.suspended[tenant.RecID], nil
+}
+
+func TestTenantSuspension(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	TenantRepo = &suspensionTenantRepo{regionTenantRepo: regionTenantRepo{regions: map[string]string{}}, suspended: make(map[string]bool)}
+
+	token := func(audience string) string {
+		tok, err := helper.CreateJWTStringToken("testkey", "HS256", config.Get("token.issuer"), "someone@test.com", []string{audience}, time.Now(), time.Now(), time.Now().Add(time.Minute), map[string]interface{}{"type": "access"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	call := func(handler http.Handler, method, path, audience string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token(audience))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	userHandler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	userPath := fmt.Sprintf("%s/auth/2fatest", apiPrefix)
+	admin := fmt.Sprintf("%s@%s", config.Get("hansip.admin"), config.Get("hansip.domain"))
+
+	if rec := call(userHandler, http.MethodPost, userPath, "user@acme"); rec.Code != http.StatusOK {
+		t.Fatalf("user of an active tenant should pass. got %d", rec.Code)
+	}
+
+	rec := call(JwtMiddleware(http.HandlerFunc(SuspendTenant)), http.MethodPut, fmt.Sprintf("%s/management/tenant/t1/suspend", apiPrefix), admin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin should be able to suspend the tenant. got %d", rec.Code)
+	}
+
+	rec = call(userHandler, http.MethodPost, userPath, "user@acme")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("user of a suspended tenant should be rejected with 403. got %d", rec.Code)
+	}
+	response := &struct {
+		Data map[string]string `json:"data"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Data["error"] != "tenant_suspended" {
+		t.Errorf("expect tenant_suspended error. got %v", response.Data)
+	}
+	if rec := call(userHandler, http.MethodPost, userPath, "user@other"); rec.Code != http.StatusOK {
+		t.Errorf("user of another tenant should pass. got %d", rec.Code)
+	}
+
+	rec = call(JwtMiddleware(http.HandlerFunc(ReactivateTenant)), http.MethodPut, fmt.Sprintf("%s/management/tenant/t1/reactivate", apiPrefix), admin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin should still be able to reactivate the tenant. got %d", rec.Code)
+	}
+	if rec := call(userHandler, http.MethodPost, userPath, "user@acme"); rec.Code != http.StatusOK {
+		t.Errorf("user of a reactivated tenant should pass. got %d", rec.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package endpoint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+// RolePermissions are the permissions granted to the users having the role.
+// Role is either "role@domain" or only the role name, which matches the role in any domain.
+type RolePermissions struct {
+	Role        string
+	Permissions []string
+}
+
+// ParseRolePermissions parses the permissions granted by roles, as configured in "token.permissions".
+// Roles are separated by ";", each role is followed by "=" and comma separated permissions,
+// eg. "admin@acme=users:read,users:write;auditor=audit:read".
+func ParseRolePermissions(spec string) ([]*RolePermissions, error) {
+	ret := make([]*RolePermissions, 0)
+	for _, item := range strings.Split(spec, ";") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		idx := strings.Index(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("role permissions %s has no permission", item)
+		}
+		role := strings.TrimSpace(item[:idx])
+		if len(role) == 0 {
+			return nil, fmt.Errorf("role permissions %s has no role", item)
+		}
+		permissions := make([]string, 0)
+		for _, permission := range strings.Split(item[idx+1:], ",") {
+			permission = strings.TrimSpace(permission)
+			if len(permission) == 0 {
+				continue
+			}
+			if strings.ContainsAny(permission, " \t") {
+				return nil, fmt.Errorf("permission %q of role %s must not contain a space", permission, role)
+			}
+			permissions = append(permissions, permission)
+		}
+		ret = append(ret, &RolePermissions{Role: role, Permissions: permissions})
+	}
+	return ret, nil
+}
+
+// grantedPermissions returns the permissions granted by any of the roles, each role in the form of "role@domain".
+func grantedPermissions(roles []string) ([]string, error) {
+	rolePermissions, err := ParseRolePermissions(config.Get("token.permissions"))
+	if err != nil {
+		return nil, err
+	}
+	granted := make(map[string]bool)
+	for _, rp := range rolePermissions {
+		for _, role := range roles {
+			if role == rp.Role || (!strings.Contains(rp.Role, "@") && strings.SplitN(role, "@", 2)[0] == rp.Role) {
+				for _, permission := range rp.Permissions {
+					granted[permission] = true
+				}
+			}
+		}
+	}
+	ret := make([]string, 0, len(granted))
+	for permission := range granted {
+		ret = append(ret, permission)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// scopeClaims adds the "permissions" claim to the token claims, the permissions granted by the roles narrowed to
+// the space separated scope requested on login. Omitting the scope keeps all the granted permissions.
+// The claims are left untouched when no permission is granted and no scope is requested.
+func scopeClaims(claims map[string]interface{}, roles []string, scope string) (map[string]interface{}, error) {
+	granted, err := grantedPermissions(roles)
+	if err != nil {
+		return nil, err
+	}
+	requested := strings.Fields(scope)
+	if len(granted) == 0 && len(requested) == 0 {
+		return claims, nil
+	}
+	permissions := granted
+	if len(requested) > 0 {
+		permissions = make([]string, 0, len(requested))
+		for _, permission := range granted {
+			for _, r := range requested {
+				if r == permission {
+					permissions = append(permissions, permission)
+					break
+				}
+			}
+		}
+	}
+	if claims == nil {
+		claims = make(map[string]interface{})
+	}
+	claims["permissions"] = permissions
+	return claims, nil
+}
+
+// scopeAudience narrows the audience to the roles granting any of the permissions in the space separated scope
+// requested on login, so a scoped token can not reach the endpoints of its other roles. Each role of the audience is
+// the role at the same position in roles, in the form of "role@domain". Omitting the scope keeps the whole audience.
+func scopeAudience(audience, roles []string, scope string) ([]string, error) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return audience, nil
+	}
+	narrowed := make([]string, 0, len(audience))
+	for i, role := range roles {
+		granted, err := grantedPermissions([]string{role})
+		if err != nil {
+			return nil, err
+		}
+		inScope := false
+		for _, permission := range granted {
+			for _, r := range requested {
+				inScope = inScope || r == permission
+			}
+		}
+		if inScope {
+			narrowed = append(narrowed, audience[i])
+		}
+	}
+	return narrowed, nil
+}
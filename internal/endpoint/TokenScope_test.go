@@ -0,0 +1,121 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseRolePermissions(t *testing.T) {
+	rolePermissions, err := ParseRolePermissions("admin@acme=users:read, users:write; auditor=audit:read")
+	if err != nil {
+		t.Fatalf("valid role permissions got %s", err.Error())
+	}
+	if len(rolePermissions) != 2 || rolePermissions[0].Role != "admin@acme" || len(rolePermissions[0].Permissions) != 2 || rolePermissions[1].Permissions[0] != "audit:read" {
+		t.Errorf("role permissions are not parsed correctly. got %+v %+v", rolePermissions[0], rolePermissions[1])
+	}
+	for _, invalid := range []string{"admin@acme", "=users:read", "admin=users read"} {
+		if _, err := ParseRolePermissions(invalid); err == nil {
+			t.Errorf("role permissions %q should be invalid", invalid)
+		}
+	}
+}
+
+func TestScopedToken(t *testing.T) {
+	config.Set("token.permissions", "user@acme=report:read,report:write;admin=users:write")
+	defer config.Set("token.permissions", "")
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}, active: true}}
+	RoleRepo = &regionRoleRepo{}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	login := func(scope string) string {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(fmt.Sprintf(`{"email":"user@acme.com","passphrase":"abcdefg","scope":%q}`, scope)))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+		}
+		response := &struct {
+			Data *Response `json:"data"`
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Data.AccessToken
+	}
+	call := func(token, permission string) int {
+		handler := JwtMiddleware(RequirePermission(permission)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/management/user/whoami", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	narrowed := login("report:read users:write")
+	if code := call(narrowed, "report:read"); code != http.StatusOK {
+		t.Errorf("narrowed token should have report:read. got %d", code)
+	}
+	if code := call(narrowed, "report:write"); code != http.StatusForbidden {
+		t.Errorf("narrowed token should be denied report:write outside its scope. got %d", code)
+	}
+	if code := call(narrowed, "users:write"); code != http.StatusForbidden {
+		t.Errorf("scope should not grant users:write the user does not have. got %d", code)
+	}
+
+	if tok, _ := TokenFactory.ReadToken(narrowed); len(tok.Audiences) != 1 || tok.Audiences[0] != "user@acme" {
+		t.Errorf("narrowed token should keep the role granting its scope. got %v", tok.Audiences)
+	}
+	if tok, _ := TokenFactory.ReadToken(login("users:write")); len(tok.Audiences) != 0 {
+		t.Errorf("token scoped outside its roles should have no audience. got %v", tok.Audiences)
+	}
+
+	full := login("")
+	if code := call(full, "report:read"); code != http.StatusOK {
+		t.Errorf("token without scope should have report:read. got %d", code)
+	}
+	if code := call(full, "report:write"); code != http.StatusOK {
+		t.Errorf("token without scope should have report:write. got %d", code)
+	}
+}
+
+func TestScopeAudience(t *testing.T) {
+	config.Set("token.permissions", "user@acme=report:read;admin=users:write;auditor@acme=audit:read,report:read")
+	defer config.Set("token.permissions", "")
+	roles := []string{"user@acme", "admin@acme", "auditor@acme", "guest@acme"}
+	for _, test := range []struct {
+		scope    string
+		audience string
+	}{
+		{"", "user@acme,admin@acme,auditor@acme,guest@acme"},
+		{"report:read", "user@acme,auditor@acme"},
+		{"users:write audit:read", "admin@acme,auditor@acme"},
+		{"unknown", ""},
+	} {
+		audience, err := scopeAudience(roles, roles, test.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(audience, ",") != test.audience {
+			t.Errorf("scope %q expect audience %s. got %v", test.scope, test.audience, audience)
+		}
+	}
+}
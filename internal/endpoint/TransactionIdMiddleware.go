@@ -2,30 +2,75 @@ package endpoint
 
 import (
 	"context"
+	"github.com/hyperjumptech/hansip/internal/config"
 	"github.com/hyperjumptech/hansip/internal/constants"
 	"github.com/hyperjumptech/hansip/pkg/helper"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"regexp"
 	"time"
 )
 
 var (
 	trxMiddlewareLog = log.WithField("go", "TrackingMiddleware")
+
+	// requestIDPattern is the format an upstream request ID must have to be inherited,
+	// so it is safe to log and to echo back.
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
 )
 
-// TransactionIDMiddleware handles X-Request-Id handler, if no X-Request-Id found, it will create one.
+// requestIDHeaders returns the configured request ID header names, the first one is echoed back in the response.
+func requestIDHeaders() []string {
+	headers := config.GetList("server.http.requestid.header")
+	if len(headers) == 0 {
+		headers = append(headers, constants.RequestIDHeader)
+	}
+	return headers
+}
+
+// upstreamRequestID returns the first valid request ID set by an upstream gateway in the headers, empty if none.
+func upstreamRequestID(r *http.Request, headers []string) string {
+	for _, header := range headers {
+		requestID := r.Header.Get(header)
+		if len(requestID) == 0 {
+			continue
+		}
+		if requestIDPattern.MatchString(requestID) {
+			return requestID
+		}
+		trxMiddlewareLog.WithField("func", "upstreamRequestID").Debugf("ignoring invalid %s header", header)
+	}
+	return ""
+}
+
+// TransactionIDMiddleware handles X-Request-Id handler. The request ID set by an upstream gateway in the
+// "server.http.requestid.header" headers is used when "server.http.requestid.inherit" is on and it is valid,
+// otherwise it will create one. The request ID is echoed back in the response.
+// The end of each request is written to the access log at "server.http.accesslog.level", or at
+// "server.http.accesslog.quiet.level" for the reads of the "server.http.accesslog.quiet" routes.
 func TransactionIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := r.Header.Get(constants.RequestIDHeader)
+		headers := requestIDHeaders()
+		requestID := ""
+		if config.GetBoolean("server.http.requestid.inherit") {
+			requestID = upstreamRequestID(r, headers)
+		}
 		if len(requestID) == 0 {
 			requestID = helper.MakeRandomString(20, true, true, true, false)
 		}
+		w.Header().Set(headers[0], requestID)
+		ctx := context.WithValue(r.Context(), constants.RequestID, requestID)
+		level, logged := accessLogFor(r)
+		if !logged {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		log := trxMiddlewareLog.WithField("path", r.URL.Path).WithField("RequestID", requestID).WithField("func", "TransactionIDMiddleware").WithField("method", r.Method)
 		log.Tracef("request start")
 		start := time.Now()
-		ctx := context.WithValue(r.Context(), constants.RequestID, requestID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
 		dur := time.Now().Sub(start)
-		log.WithField("ms", dur.Milliseconds()).Tracef("request end")
+		log.WithField("ms", dur.Milliseconds()).WithField("status", recorder.status).Log(level, "request end")
 	})
 }
@@ -0,0 +1,56 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func TestTransactionIDMiddleware(t *testing.T) {
+	config.Set("server.http.requestid.header", "X-Request-ID, X-Transaction-ID")
+	defer config.Set("server.http.requestid.header", "X-Request-ID")
+
+	var contextID string
+	handler := TransactionIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextID = r.Context().Value(constants.RequestID).(string)
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "", nil, nil)
+	}))
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/something", nil)
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("X-Transaction-ID", "gw-4f1c2a:01")
+	if contextID != "gw-4f1c2a:01" || rec.Header().Get("X-Request-ID") != "gw-4f1c2a:01" {
+		t.Errorf("upstream request id should be inherited and echoed. got %s and %s", contextID, rec.Header().Get("X-Request-ID"))
+	}
+	if len(rec.Header().Values("X-Request-ID")) != 1 {
+		t.Errorf("request id should be echoed once. got %v", rec.Header().Values("X-Request-ID"))
+	}
+
+	rec = serve("", "")
+	if len(contextID) != 20 || rec.Header().Get("X-Request-ID") != contextID {
+		t.Errorf("request id should be generated and echoed. got %s and %s", contextID, rec.Header().Get("X-Request-ID"))
+	}
+
+	rec = serve("X-Request-ID", "bad id\" <script>")
+	if contextID == "bad id\" <script>" || rec.Header().Get("X-Request-ID") != contextID {
+		t.Errorf("invalid request id should be replaced. got %s", contextID)
+	}
+
+	config.Set("server.http.requestid.inherit", "false")
+	defer config.Set("server.http.requestid.inherit", "true")
+	serve("X-Request-ID", "gw-4f1c2a:02")
+	if contextID == "gw-4f1c2a:02" {
+		t.Errorf("upstream request id should not be inherited when disabled")
+	}
+}
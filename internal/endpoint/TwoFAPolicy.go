@@ -0,0 +1,56 @@
+package endpoint
+
+import (
+	"fmt"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+)
+
+const (
+	// twoFAEnrollmentPurpose is the "purpose" claim of the token issued to a user who must enroll 2FA before authenticating
+	twoFAEnrollmentPurpose = "2fa-enrollment"
+)
+
+// TwoFAEnrollmentResponse is the data of the "2FA enrollment required" response
+type TwoFAEnrollmentResponse struct {
+	// EnrollmentToken is an access token that is only accepted by the 2FA QR code and 2FA activation endpoints
+	EnrollmentToken string `json:"enrollment_token"`
+}
+
+// isTwoFARequired checks whether any of the roles is one of the comma separated "auth.2fa.requiredroles",
+// eg. "admin@*,finance@acme". Users holding such role can not authenticate without 2FA.
+func isTwoFARequired(roles []string) bool {
+	required := config.GetList("auth.2fa.requiredroles")
+	return len(required) > 0 && isRoleMatch(required, roles)
+}
+
+// createTwoFAEnrollmentToken creates the access token a user uses to enroll 2FA, valid for "auth.2fa.enrollment.duration".
+// The token carries no role and comes with no refresh token, so it grants nothing but the enrollment.
+func createTwoFAEnrollmentToken(subject string) (string, error) {
+	duration, err := jiffy.DurationOf(config.Get("auth.2fa.enrollment.duration"))
+	if err != nil {
+		return "", err
+	}
+	return TokenFactory.CreateAccessToken(subject, []string{}, map[string]interface{}{
+		"purpose": twoFAEnrollmentPurpose,
+	}, duration)
+}
+
+// isTwoFAEnrollmentPending checks whether the user holds a role requiring 2FA but has not enrolled it yet
+func isTwoFAEnrollmentPending(user *connector.User, roles []string) bool {
+	return !user.Enable2FactorAuth && isTwoFARequired(roles)
+}
+
+// isTwoFAEnrollmentToken checks whether the token was issued only to enroll 2FA
+func isTwoFAEnrollmentToken(tok *helper.HansipToken) bool {
+	return tok.Additional["purpose"] == twoFAEnrollmentPurpose
+}
+
+// isTwoFAEnrollmentEndpoint checks whether the endpoint is one a 2FA enrollment token is accepted at
+func isTwoFAEnrollmentEndpoint(ep *Endpoint) bool {
+	return ep.PathPattern == fmt.Sprintf("%s/management/user/2FAQR", apiPrefix) ||
+		ep.PathPattern == fmt.Sprintf("%s/management/user/activate2FA", apiPrefix)
+}
@@ -0,0 +1,156 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type twoFAPolicyRoleRepo struct {
+	connector.RoleRepository
+	roleName string
+}
+
+func (repo *twoFAPolicyRoleRepo) GetRoleByRecID(ctx context.Context, recID string) (*connector.Role, error) {
+	return &connector.Role{RecID: recID, RoleName: repo.roleName, RoleDomain: "acme"}, nil
+}
+
+func TestTwoFARequiredRoles(t *testing.T) {
+	config.Set("auth.2fa.requiredroles", "admin@*, finance@acme")
+	defer config.Set("auth.2fa.requiredroles", "")
+	hashed, err := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	user := &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true, HashedPassphrase: string(hashed)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: user, active: true}}
+	roleRepo := &twoFAPolicyRoleRepo{}
+	RoleRepo = roleRepo
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/authenticate", apiPrefix), strings.NewReader(`{"email":"user@acme.com","passphrase":"abcdefg"}`))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		Authentication(recorder, req)
+		return recorder
+	}
+
+	roleRepo.roleName = "user"
+	if recorder := login(); recorder.Code != http.StatusOK {
+		t.Errorf("regular user without 2FA should authenticate. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+
+	roleRepo.roleName = "admin"
+	recorder := login()
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "2FA enrollment required") {
+		t.Fatalf("admin without 2FA should be forced to enroll. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	response := &struct {
+		Data *TwoFAEnrollmentResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := TokenFactory.ReadToken(response.Data.EnrollmentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enrollment.Additional["type"] != "access" || enrollment.Expire.After(time.Now().Add(15*time.Minute)) || enrollment.Expire.Before(time.Now().Add(14*time.Minute)) {
+		t.Errorf("enrollment token should be an access token living auth.2fa.enrollment.duration. got %v expiring %s", enrollment.Additional["type"], enrollment.Expire)
+	}
+
+	served := false
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+	request := func(method, path string) int {
+		served = false
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+response.Data.EnrollmentToken)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	if request(http.MethodGet, fmt.Sprintf("%s/management/user/2FAQR", apiPrefix)); !served {
+		t.Errorf("enrollment token should be accepted to show the 2FA QR code")
+	}
+	if request(http.MethodPost, fmt.Sprintf("%s/management/user/activate2FA", apiPrefix)); !served {
+		t.Errorf("enrollment token should be accepted to activate 2FA")
+	}
+	if code := request(http.MethodGet, fmt.Sprintf("%s/management/user/whoami", apiPrefix)); served || code != http.StatusUnauthorized {
+		t.Errorf("enrollment token should not be accepted elsewhere. got %d", code)
+	}
+
+	user.Enable2FactorAuth = true
+	if recorder := login(); recorder.Code != http.StatusAccepted {
+		t.Errorf("enrolled admin should continue with 2FA. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestTwoFARequiredPasskey(t *testing.T) {
+	setupWebAuthn(t)
+	defer func() { WebAuthn = nil }()
+	config.Set("auth.2fa.requiredroles", "user@acme")
+	defer config.Set("auth.2fa.requiredroles", "")
+
+	laptop := newSimulatedAuthenticator(t)
+	if recorder := registerPasskey(t, laptop, "Laptop"); recorder.Code != http.StatusOK {
+		t.Fatalf("registration should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	recorder := loginPasskey(t, laptop)
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "2FA enrollment required") {
+		t.Fatalf("passkey login of a user without 2FA should be forced to enroll. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "refresh_token") {
+		t.Errorf("passkey login pending 2FA enrollment should not issue a refresh token")
+	}
+
+	UserRepo.(*regionUserRepo).user.Enable2FactorAuth = true
+	if recorder := loginPasskey(t, laptop); recorder.Code != http.StatusOK {
+		t.Errorf("passkey login of an enrolled user should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestTwoFARequiredRefresh(t *testing.T) {
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	user := &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: user, active: true}}
+	_, refreshToken, err := TokenFactory.CreateTokenPair("user@acme.com", []string{"admin@acme"}, nil, helper.TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func() int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/refresh", apiPrefix), nil)
+		req.Header.Set("Authorization", "Bearer "+refreshToken)
+		recorder := httptest.NewRecorder()
+		Refresh(recorder, req)
+		return recorder.Code
+	}
+
+	if code := refresh(); code != http.StatusOK {
+		t.Fatalf("refresh should succeed while 2FA is not required. got %d", code)
+	}
+	config.Set("auth.2fa.requiredroles", "admin@*")
+	defer config.Set("auth.2fa.requiredroles", "")
+	if code := refresh(); code != http.StatusForbidden {
+		t.Errorf("refresh token of a role now requiring 2FA should be refused until enrolled. got %d", code)
+	}
+	user.Enable2FactorAuth = true
+	if code := refresh(); code != http.StatusOK {
+		t.Errorf("refresh of an enrolled user should succeed. got %d", code)
+	}
+}
@@ -2,6 +2,7 @@ package endpoint
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"github.com/hyperjumptech/hansip/internal/passphrase"
 	"github.com/hyperjumptech/hansip/pkg/helper"
 	"github.com/hyperjumptech/hansip/pkg/totp"
+	"github.com/hyperjumptech/jiffy"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -47,6 +49,9 @@ func SetUserRoles(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recID %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, true) {
+		return
+	}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
@@ -66,15 +71,20 @@ func SetUserRoles(w http.ResponseWriter, r *http.Request) {
 		role, err := RoleRepo.GetRoleByRecID(r.Context(), roleID)
 		if err != nil {
 			fLog.Warnf("RoleRepo.GetRoleByRecID got %s, this role %s will not be added to user %s role", err.Error(), roleID, user.RecID)
+			continue
 		}
 		if role == nil {
-			fLog.Warnf("This role %s is not exist and will not be added to user %s role", roleId, user.RecID)
+			fLog.Warnf("This role %s is not exist and will not be added to user %s role", roleID, user.RecID)
+			continue
 		}
 		authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-		if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+		if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 			helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access role with the specified domain", nil, nil)
 			return
 		}
+		if !applyTenantEmailDomains(w, r, []string{role.RoleDomain}, user.Email) {
+			return
+		}
 		rolesToAdd = append(rolesToAdd, role)
 	}
 
@@ -115,6 +125,9 @@ func DeleteUserRoles(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recID %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 	err = UserRoleRepo.DeleteUserRoleByUser(r.Context(), user)
 	if err != nil {
 		fLog.Errorf("UserRoleRepo.DeleteUserRoleByUser got %s", err.Error())
@@ -142,6 +155,9 @@ func SetUserGroups(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recID %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, true) {
+		return
+	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -157,6 +173,23 @@ func SetUserGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	groupsToJoin := make([]*connector.Group, 0)
+	for _, groupID := range groupIds {
+		group, err := GroupRepo.GetGroupByRecID(r.Context(), groupID)
+		if err != nil {
+			fLog.Warnf("GroupRepo.GetGroupByRecID got %s, this group %s will not be joined by user %s", err.Error(), groupID, user.RecID)
+			continue
+		}
+		if group == nil {
+			fLog.Warnf("This group %s is not exist and will not be joined by user %s", groupID, user.RecID)
+			continue
+		}
+		if !applyTenantEmailDomains(w, r, []string{group.GroupDomain}, user.Email) {
+			return
+		}
+		groupsToJoin = append(groupsToJoin, group)
+	}
+
 	err = UserGroupRepo.DeleteUserGroupByUser(r.Context(), user)
 	if err != nil {
 		fLog.Errorf("UserGroupRepo.DeleteUserGroupByUser got %s", err.Error())
@@ -165,17 +198,12 @@ func SetUserGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	counter := 0
-	for _, groupID := range groupIds {
-		group, err := GroupRepo.GetGroupByRecID(r.Context(), groupID)
+	for _, group := range groupsToJoin {
+		_, err := UserGroupRepo.CreateUserGroup(r.Context(), user, group)
 		if err != nil {
-			fLog.Warnf("GroupRepo.GetGroupByRecID got %s, this group %s will not be joined by user %s", err.Error(), groupID, user.RecID)
+			fLog.Warnf("UserGroupRepo.CreateUserGroup got %s, this group %s will not be joined by user %s", err.Error(), group.RecID, user.RecID)
 		} else {
-			_, err := UserGroupRepo.CreateUserGroup(r.Context(), user, group)
-			if err != nil {
-				fLog.Warnf("UserGroupRepo.CreateUserGroup got %s, this group %s will not be joined by user %s", err.Error(), groupID, user.RecID)
-			} else {
-				counter++
-			}
+			counter++
 		}
 	}
 
@@ -201,6 +229,9 @@ func DeleteUserGroups(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recID %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 
 	err = UserGroupRepo.DeleteUserGroupByUser(r.Context(), user)
 	if err != nil {
@@ -215,6 +246,9 @@ func DeleteUserGroups(w http.ResponseWriter, r *http.Request) {
 // Show2FAQrCode shows 2FA QR code. It returns a PNG image bytes.
 func Show2FAQrCode(w http.ResponseWriter, r *http.Request) {
 	fLog := userMgmtLogger.WithField("func", "Show2FAQrCode").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !applyImpersonationRestriction(w, r, "2FA") {
+		return
+	}
 	authCtx := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
 	user, err := UserRepo.GetUserByEmail(r.Context(), authCtx.Subject)
 	if err != nil {
@@ -257,10 +291,11 @@ func Show2FAQrCode(w http.ResponseWriter, r *http.Request) {
 
 // SimpleUser hold data model of user. showing important attributes only.
 type SimpleUser struct {
-	RecID     string `json:"rec_id"`
-	Email     string `json:"email"`
-	Enabled   bool   `json:"enabled"`
-	Suspended bool   `json:"suspended"`
+	XMLName   xml.Name `json:"-" xml:"user"`
+	RecID     string   `json:"rec_id" xml:"rec_id"`
+	Email     string   `json:"email" xml:"email"`
+	Enabled   bool     `json:"enabled" xml:"enabled"`
+	Suspended bool     `json:"suspended" xml:"suspended"`
 }
 
 // ListAllUsers serving listing all user request
@@ -280,9 +315,9 @@ func ListAllUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fLog.Trace("Listing Users")
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newCursorPageRequest(r, "EMAIL")
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newCursorPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -292,14 +327,32 @@ func ListAllUsers(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	susers := make([]*SimpleUser, len(users))
-	for i, v := range users {
-		susers[i] = &SimpleUser{
+	if len(users) > 0 {
+		if err := setNextPageCursor(pageRequest, page, "EMAIL", users[len(users)-1].Email); err != nil {
+			fLog.Errorf("setNextPageCursor got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+	}
+	// a tenant admin only sees the users of the tenants they administer, the page may hold less users than the page size
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	susers := make([]*SimpleUser, 0, len(users))
+	for _, v := range users {
+		allowed, err := canManageUser(r.Context(), authCtx, v, false)
+		if err != nil {
+			fLog.Errorf("canManageUser got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if !allowed {
+			continue
+		}
+		susers = append(susers, &SimpleUser{
 			RecID:     v.RecID,
 			Email:     v.Email,
 			Enabled:   v.Enabled,
 			Suspended: v.Suspended,
-		}
+		})
 	}
 	ret := make(map[string]interface{})
 	ret["users"] = susers
@@ -309,19 +362,19 @@ func ListAllUsers(w http.ResponseWriter, r *http.Request) {
 
 // CreateNewUserRequest hold the data model for requesting to create new user.
 type CreateNewUserRequest struct {
-	Email      string `json:"email"`
-	Passphrase string `json:"passphrase"`
+	Email      string `json:"email" xml:"email"`
+	Passphrase string `json:"passphrase" xml:"passphrase"`
 }
 
 // CreateNewUserResponse hold the data model for responding CreateNewUser request
 type CreateNewUserResponse struct {
-	RecordID    string    `json:"rec_id"`
-	Email       string    `json:"email"`
-	Enabled     bool      `json:"enabled"`
-	Suspended   bool      `json:"suspended"`
-	LastSeen    time.Time `json:"last_seen"`
-	LastLogin   time.Time `json:"last_login"`
-	TotpEnabled bool      `json:"enabled_2fa"`
+	RecordID    string    `json:"rec_id" xml:"rec_id"`
+	Email       string    `json:"email" xml:"email"`
+	Enabled     bool      `json:"enabled" xml:"enabled"`
+	Suspended   bool      `json:"suspended" xml:"suspended"`
+	LastSeen    time.Time `json:"last_seen" xml:"last_seen"`
+	LastLogin   time.Time `json:"last_login" xml:"last_login"`
+	TotpEnabled bool      `json:"enabled_2fa" xml:"enabled_2fa"`
 }
 
 // CreateNewUser handles request to create new user
@@ -342,9 +395,9 @@ func CreateNewUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -355,6 +408,18 @@ func CreateNewUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid passphrase", nil, invalidMsg)
 		return
 	}
+	if !applyPassphraseBreachCheck(w, r, req.Passphrase) {
+		return
+	}
+	req.Email = NormalizeEmail(req.Email)
+	if err := ValidateEmailAddress(r.Context(), req.Email); err != nil {
+		fLog.Errorf("ValidateEmailAddress got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	if !applyTenantEmailDomains(w, r, onboardingTenantDomains(r.Context()), req.Email) {
+		return
+	}
 	user, err := UserRepo.CreateUserRecord(r.Context(), req.Email, req.Passphrase)
 	if err != nil {
 		fLog.Errorf("UserRepo.CreateUserRecord got %s", err.Error())
@@ -378,13 +443,24 @@ func CreateNewUser(w http.ResponseWriter, r *http.Request) {
 		Cc:       nil,
 		Bcc:      nil,
 		Template: "EMAIL_VERIFY",
-		Data:     user,
+		Data:     mailData(r.Context(), user),
 	})
 
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success creating user", nil, resp)
 	return
 }
 
+// applyImpersonationRestriction responds 403 when "token.impersonate.restricted" is on and the request uses an impersonation token,
+// so an impersonator can not change the credentials of the user. It returns whether the request may proceed.
+func applyImpersonationRestriction(w http.ResponseWriter, r *http.Request, credential string) bool {
+	authCtx, ok := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	if !ok || !authCtx.IsImpersonated() || !config.GetBoolean("token.impersonate.restricted") {
+		return true
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, fmt.Sprintf("%s can not be changed while impersonating", credential), nil, nil)
+	return false
+}
+
 // ChangePassphraseRequest stores change password request
 type ChangePassphraseRequest struct {
 	OldPassphrase string `json:"old_passphrase"`
@@ -398,6 +474,9 @@ func ChangePassphrase(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		panic(err)
 	}
+	if !applyImpersonationRestriction(w, r, "Passphrase") {
+		return
+	}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
@@ -419,6 +498,9 @@ func ChangePassphrase(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid new passphrase", nil, invalidMsg)
 		return
 	}
+	if !applyPassphraseBreachCheck(w, r, c.NewPassphrase) {
+		return
+	}
 
 	user, err := UserRepo.GetUserByRecID(r.Context(), params["userRecId"])
 	if err != nil {
@@ -436,12 +518,23 @@ func ChangePassphrase(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotAcceptable, err.Error(), nil, nil)
 		return
 	}
+	// admin changing other user's passphrase is not restricted by the minimum passphrase age
+	authCtx, _ := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	if authCtx == nil || authCtx.Subject == user.Email || !authCtx.IsAdminOfDomain(config.Get("hansip.domain")) {
+		if !applyPassphraseAge(w, r, user) {
+			return
+		}
+	}
+	if !applyPassphraseHistory(w, r, user, c.NewPassphrase) {
+		return
+	}
 	newHashed, err := bcrypt.GenerateFromPassword([]byte(c.NewPassphrase), 14)
 	if err != nil {
 		fLog.Errorf("bcrypt.GenerateFromPassword got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
+	previousHashed := user.HashedPassphrase
 	user.HashedPassphrase = string(newHashed)
 	err = UserRepo.UpdateUser(r.Context(), user)
 	if err != nil {
@@ -449,6 +542,7 @@ func ChangePassphrase(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
+	recordPassphraseChange(r.Context(), user, previousHashed)
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Password changed", nil, nil)
 }
 
@@ -498,6 +592,9 @@ type Activate2FAResponse struct {
 // Activate2FA handle 2FA activation request
 func Activate2FA(w http.ResponseWriter, r *http.Request) {
 	fLog := userMgmtLogger.WithField("func", "Activate2FA").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !applyImpersonationRestriction(w, r, "2FA") {
+		return
+	}
 	authCtx := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
 	user, err := UserRepo.GetUserByEmail(r.Context(), authCtx.Subject)
 	if err != nil {
@@ -662,8 +759,11 @@ func ActivateUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "invalid passphrase", nil, invalidMsg)
 		return
 	}
+	if !applyPassphraseBreachCheck(w, r, c.NewPassphrase) {
+		return
+	}
 
-	user, err := UserRepo.GetUserByEmail(r.Context(), c.Email)
+	user, err := getUserByEmail(r.Context(), c.Email)
 	if err != nil {
 		fLog.Errorf("UserRepo.GetUserByEmail got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
@@ -673,21 +773,51 @@ func ActivateUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User email %s not found", c.Email), nil, nil)
 		return
 	}
-	if user.ActivationCode == c.ActivationToken {
+	// a used activation code is cleared, it must never match the empty code.
+	if len(user.ActivationCode) > 0 && user.ActivationCode == c.ActivationToken {
+		activated := !user.Enabled
 		user.Enabled = true
+		if !applyPassphraseHistory(w, r, user, c.NewPassphrase) {
+			return
+		}
 		newHashed, err := bcrypt.GenerateFromPassword([]byte(c.NewPassphrase), 14)
 		if err != nil {
 			fLog.Errorf("bcrypt.GenerateFromPassword got %s", err.Error())
 			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 			return
 		}
+		previousHashed := user.HashedPassphrase
 		user.HashedPassphrase = string(newHashed)
+		// the activation link is used once
+		user.ActivationCode = ""
 		err = UserRepo.UpdateUser(r.Context(), user)
 		if err != nil {
 			fLog.Errorf("UserRepo.SaveOrUpdate got %s", err.Error())
 			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 			return
 		}
+		// the activation code was received at the email, proving the user owns it
+		err = UserRepo.SetEmailVerified(r.Context(), user, true)
+		if err != nil {
+			fLog.Errorf("UserRepo.SetEmailVerified got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		recordPassphraseChange(r.Context(), user, previousHashed)
+		// welcome email is only sent when the user is activated, not when the activation is repeated
+		if activated && config.GetBoolean("mailer.welcome.enable") {
+			fLog.Warnf("Sending welcome email")
+			mailer.Send(r.Context(), &mailer.Email{
+				From:     config.Get("mailer.from"),
+				FromName: config.Get("mailer.from.name"),
+				To:       []string{user.Email},
+				Cc:       nil,
+				Bcc:      nil,
+				Template: "WELCOME",
+				Locale:   requestLocale(r),
+				Data:     mailData(r.Context(), user),
+			})
+		}
 		ret := make(map[string]interface{})
 		ret["rec_id"] = user.RecID
 		ret["email"] = user.Email
@@ -719,6 +849,9 @@ func GetUserDetail(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 	ret := make(map[string]interface{})
 	ret["rec_id"] = user.RecID
 	ret["email"] = user.Email
@@ -732,10 +865,10 @@ func GetUserDetail(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUserRequest hold request data for requesting to update user information.
 type UpdateUserRequest struct {
-	Email     string `json:"email"`
-	Enabled   bool   `json:"enabled"`
-	Suspended bool   `json:"suspended"`
-	Enable2FA bool   `json:"enabled_2fa"`
+	Email     string `json:"email" xml:"email"`
+	Enabled   bool   `json:"enabled" xml:"enabled"`
+	Suspended bool   `json:"suspended" xml:"suspended"`
+	Enable2FA bool   `json:"enabled_2fa" xml:"enabled_2fa"`
 }
 
 // UpdateUserDetail rest endpoint to update user detail
@@ -755,6 +888,9 @@ func UpdateUserDetail(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 	req := &UpdateUserRequest{}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -762,13 +898,30 @@ func UpdateUserDetail(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
 		return
 	}
-	err = json.Unmarshal(body, req)
+	err = helper.UnmarshalRequestBody(r.Context(), body, req)
 	if err != nil {
-		fLog.Errorf("json.Unmarshal got %s", err.Error())
+		fLog.Errorf("helper.UnmarshalRequestBody got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
 
+	req.Email = NormalizeEmail(req.Email)
+	if user.Email != req.Email {
+		if err := ValidateEmailAddress(r.Context(), req.Email); err != nil {
+			fLog.Errorf("ValidateEmailAddress got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+			return
+		}
+		tenantDomains, err := userTenantDomains(r.Context(), user)
+		if err != nil {
+			fLog.Errorf("userTenantDomains got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if !applyTenantEmailDomains(w, r, tenantDomains, req.Email) {
+			return
+		}
+	}
 	// if email is changed and enabled = false, send email
 	sendemail := false
 	if user.Email != req.Email && req.Enabled == false {
@@ -801,7 +954,7 @@ func UpdateUserDetail(w http.ResponseWriter, r *http.Request) {
 			Cc:       nil,
 			Bcc:      nil,
 			Template: "EMAIL_VERIFY",
-			Data:     user,
+			Data:     mailData(r.Context(), user),
 		})
 	}
 
@@ -834,6 +987,9 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 	UserRepo.DeleteUser(r.Context(), user)
 	RevocationRepo.Revoke(r.Context(), user.Email)
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "User deleted", nil, nil)
@@ -841,9 +997,10 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 // SimpleRole define structure or request body used to list role
 type SimpleRole struct {
-	RecID      string `json:"rec_id"`
-	RoleName   string `json:"role_name"`
-	RoleDomain string `json:"role_domain"`
+	XMLName    xml.Name `json:"-" xml:"role"`
+	RecID      string   `json:"rec_id" xml:"rec_id"`
+	RoleName   string   `json:"role_name" xml:"role_name"`
+	RoleDomain string   `json:"role_domain" xml:"role_domain"`
 }
 
 // ListUserRole serve listing all role that directly owned by user
@@ -863,9 +1020,12 @@ func ListUserRole(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -904,9 +1064,12 @@ func ListAllUserRole(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -954,7 +1117,7 @@ func CreateUserRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access role with the specified domain", nil, nil)
 		return
 	}
@@ -969,6 +1132,12 @@ func CreateUserRole(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, true) {
+		return
+	}
+	if !applyTenantEmailDomains(w, r, []string{role.RoleDomain}, user.Email) {
+		return
+	}
 
 	_, err = UserRoleRepo.CreateUserRole(r.Context(), user, role)
 	if err != nil {
@@ -1007,7 +1176,7 @@ func DeleteUserRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(role.RoleDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, role.RoleDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access role with the specified domain", nil, nil)
 		return
 	}
@@ -1022,6 +1191,9 @@ func DeleteUserRole(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 
 	userRole, err := UserRoleRepo.GetUserRole(r.Context(), user, role)
 	if err != nil {
@@ -1068,10 +1240,13 @@ func ListUserGroup(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 
-	pageRequest, err := helper.NewPageRequestFromRequest(r)
+	pageRequest, err := newPageRequest(r)
 	if err != nil {
-		fLog.Errorf("helper.NewPageRequestFromRequest got %s", err.Error())
+		fLog.Errorf("newPageRequest got %s", err.Error())
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
 		return
 	}
@@ -1119,7 +1294,7 @@ func CreateUserGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -1134,6 +1309,12 @@ func CreateUserGroup(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, true) {
+		return
+	}
+	if !applyTenantEmailDomains(w, r, []string{group.GroupDomain}, user.Email) {
+		return
+	}
 
 	_, err = UserGroupRepo.CreateUserGroup(r.Context(), user, group)
 	if err != nil {
@@ -1172,7 +1353,7 @@ func DeleteUserGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
-	if !authCtx.IsAdminOfDomain(group.GroupDomain) {
+	if !authorizeAdminOfDomain(r, authCtx, group.GroupDomain) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "You don't have the right to access group with the specified domain", nil, nil)
 		return
 	}
@@ -1187,6 +1368,9 @@ func DeleteUserGroup(w http.ResponseWriter, r *http.Request) {
 		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
 		return
 	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
 
 	ug, err := UserGroupRepo.GetUserGroup(r.Context(), user, group)
 	if err != nil {
@@ -1209,3 +1393,172 @@ func DeleteUserGroup(w http.ResponseWriter, r *http.Request) {
 	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "User-Group deleted", nil, nil)
 
 }
+
+// ImpersonateRequest hold the data model for impersonating a user
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ImpersonateResponse hold the data model for responding ImpersonateUser request
+type ImpersonateResponse struct {
+	AccessToken  string `json:"access_token"`
+	Subject      string `json:"subject"`
+	Impersonator string `json:"impersonator"`
+}
+
+// ImpersonateUser serve request to issue a short lived access token of a user for an admin
+func ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	fLog := userMgmtLogger.WithField("func", "ImpersonateUser").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+
+	iauthctx := r.Context().Value(constants.HansipAuthentication)
+	if iauthctx == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "You are not authorized to access this resource", nil, nil)
+		return
+	}
+	authCtx := iauthctx.(*hansipcontext.AuthenticationContext)
+	if authCtx.IsImpersonated() {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "Impersonation token can not be used to impersonate", nil, nil)
+		return
+	}
+
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/user/{userRecId}/impersonate", apiPrefix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+
+	req := &ImpersonateRequest{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fLog.Errorf("ioutil.ReadAll got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, req)
+		if err != nil {
+			fLog.Errorf("json.Unmarshal got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Malformed json body", nil, nil)
+			return
+		}
+	}
+
+	user, err := UserRepo.GetUserByRecID(r.Context(), params["userRecId"])
+	if err != nil {
+		fLog.Errorf("UserRepo.GetUserByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if user == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
+		return
+	}
+	// an account that can not login can not be impersonated either
+	if !user.Enabled || user.Suspended {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "Disabled or suspended user can not be impersonated", nil, nil)
+		return
+	}
+	active, err := UserRepo.IsUserActive(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("UserRepo.IsUserActive got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "Deactivated user can not be impersonated", nil, nil)
+		return
+	}
+
+	roles, _, err := UserRepo.ListAllUserRoles(r.Context(), user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "ROLE_NAME",
+		Sort:     "ASC",
+	})
+	if err != nil {
+		fLog.Errorf("UserRepo.ListAllUserRoles got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	audience := make([]string, len(roles))
+	for i, role := range roles {
+		audience[i] = fmt.Sprintf("%s@%s", role.RoleName, role.RoleDomain)
+	}
+
+	duration, err := jiffy.DurationOf(config.Get("token.impersonate.duration"))
+	if err != nil {
+		fLog.Errorf("jiffy.DurationOf got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+
+	// The audit must be recorded before the token is handed out.
+	err = writeAudit(r.Context(), AuditImpersonate, authCtx.Subject, user.Email, fmt.Sprintf("impersonation for %s. reason : %s", duration.String(), req.Reason))
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Can not record impersonation audit", nil, nil)
+		return
+	}
+
+	access, err := TokenFactory.CreateAccessToken(user.Email, audience, map[string]interface{}{
+		"impersonator": authCtx.Subject,
+	}, duration)
+	if err != nil {
+		fLog.Errorf("TokenFactory.CreateAccessToken got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	fLog.Warnf("%s is impersonating %s", authCtx.Subject, user.Email)
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Impersonation token issued", nil, &ImpersonateResponse{
+		AccessToken:  access,
+		Subject:      user.Email,
+		Impersonator: authCtx.Subject,
+	})
+}
+
+// DeactivateUser serve request to temporarily disable a user account without deleting it.
+// The user's existing tokens are revoked.
+func DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	setUserActive(w, r, "DeactivateUser", "deactivate", false)
+}
+
+// ReactivateUser serve request to enable back a deactivated user account.
+func ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	setUserActive(w, r, "ReactivateUser", "reactivate", true)
+}
+
+func setUserActive(w http.ResponseWriter, r *http.Request, funcName, pathSuffix string, active bool) {
+	fLog := userMgmtLogger.WithField("func", funcName).WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	params, err := helper.ParsePathParams(fmt.Sprintf("%s/management/user/{userRecId}/%s", apiPrefix, pathSuffix), r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	user, err := UserRepo.GetUserByRecID(r.Context(), params["userRecId"])
+	if err != nil {
+		fLog.Errorf("UserRepo.GetUserByRecID got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if user == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User recid %s not found", params["userRecId"]), nil, nil)
+		return
+	}
+	if !authorizeUserManagement(w, r, user, false) {
+		return
+	}
+	err = UserRepo.SetUserActive(r.Context(), user, active)
+	if err != nil {
+		fLog.Errorf("UserRepo.SetUserActive got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		err = RevocationRepo.Revoke(r.Context(), user.Email)
+		if err != nil {
+			fLog.Errorf("RevocationRepo.Revoke got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "User deactivated", nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "User reactivated", nil, nil)
+}
@@ -1,9 +1,22 @@
 package endpoint
 
 import (
+	"context"
 	"encoding/json"
-	"golang.org/x/crypto/bcrypt"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestArrayJsonParsing(t *testing.T) {
@@ -29,3 +42,102 @@ func TestGenPass(t *testing.T) {
 	}
 	t.Log(string(hash))
 }
+
+type activationUserRepo struct {
+	connector.UserRepository
+	user     *connector.User
+	verified bool
+}
+
+func (repo *activationUserRepo) GetUserByEmail(ctx context.Context, email string) (*connector.User, error) {
+	return repo.user, nil
+}
+
+func (repo *activationUserRepo) UpdateUser(ctx context.Context, user *connector.User) error {
+	repo.user = user
+	return nil
+}
+
+func (repo *activationUserRepo) SetEmailVerified(ctx context.Context, user *connector.User, verified bool) error {
+	repo.verified = verified
+	return nil
+}
+
+func (repo *activationUserRepo) IsEmailVerified(ctx context.Context, user *connector.User) (bool, error) {
+	return repo.verified, nil
+}
+
+func (repo *activationUserRepo) ListAllUserRoles(ctx context.Context, user *connector.User, request *helper.PageRequest) ([]*connector.Role, *helper.Page, error) {
+	return nil, nil, nil
+}
+
+func TestActivateUserWelcomeEmail(t *testing.T) {
+	config.Set("mailer.welcome.enable", "true")
+	defer config.Set("mailer.welcome.enable", "false")
+	UserRepo = &activationUserRepo{user: &connector.User{RecID: "u1", Email: "user@test.com", ActivationCode: "123456"}}
+
+	sent := make(chan *mailer.Email, 10)
+	stop := consumeMails(sent)
+	defer stop()
+
+	activate := func() int {
+		body := `{"email":"user@test.com","activation_token":"123456","new_passphrase":"correct horse battery staple"}`
+		recorder := httptest.NewRecorder()
+		ActivateUser(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/management/user/activate", strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	if code := activate(); code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", code)
+	}
+	// the used activation link is refused and does not send another welcome email.
+	if code := activate(); code != http.StatusNotFound {
+		t.Fatalf("expect 404 but %d", code)
+	}
+	select {
+	case mail := <-sent:
+		if mail.Template != "WELCOME" {
+			t.Errorf("expect WELCOME email but %s", mail.Template)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect a welcome email sent")
+	}
+	if len(sent) != 0 {
+		t.Errorf("expect exactly 1 email but %d more", len(sent))
+	}
+}
+
+type updateUserRepo struct {
+	tenantUserRepo
+	updated bool
+}
+
+func (repo *updateUserRepo) UpdateUser(ctx context.Context, user *connector.User) error {
+	repo.updated = true
+	return nil
+}
+
+func TestUpdateUserDetailValidatesEmail(t *testing.T) {
+	repo := &updateUserRepo{tenantUserRepo: tenantUserRepo{users: []*connector.User{{RecID: "u1", Email: "user@test.com", Enabled: true}}}}
+	UserRepo = repo
+
+	update := func(email string) int {
+		body := fmt.Sprintf(`{"email":%q,"enabled":true}`, email)
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/management/user/u1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+			Subject:  "admin@hansip.com",
+			Audience: []string{"admin@hansip"},
+		}))
+		recorder := httptest.NewRecorder()
+		UpdateUserDetail(recorder, req)
+		return recorder.Code
+	}
+
+	if code := update("not an email"); code != http.StatusBadRequest || repo.updated {
+		t.Errorf("invalid email should be refused with 400 before the update. got %d", code)
+	}
+	if code := update("new@test.com"); code != http.StatusOK || !repo.updated {
+		t.Errorf("valid email should be updated. got %d", code)
+	}
+}
@@ -0,0 +1,28 @@
+package endpoint
+
+import (
+	"net/http"
+
+	"github.com/hyperjumptech/hansip/internal/version"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+// VersionResponse is the minimal build information served publicly
+type VersionResponse struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// GetVersion serving the version and commit of the running build, to verify an upgrade.
+func GetVersion(w http.ResponseWriter, r *http.Request) {
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Hansip version", nil, &VersionResponse{
+		Version: version.Version,
+		Commit:  version.Commit,
+	})
+}
+
+// GetBuildInfo serving the detailed build information of the running build, including the go version
+// and the module dependencies, for the hansip admin.
+func GetBuildInfo(w http.ResponseWriter, r *http.Request) {
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Hansip build info", nil, version.GetBuildInfo())
+}
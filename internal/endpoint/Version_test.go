@@ -0,0 +1,45 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/version"
+)
+
+func TestGetVersion(t *testing.T) {
+	version.Version, version.Commit, version.BuildDate = "v1.2.3", "abc1234", "2026-10-17T00:00:00Z"
+	defer func() {
+		version.Version, version.Commit, version.BuildDate = "dev", "unknown", "unknown"
+	}()
+
+	recorder := httptest.NewRecorder()
+	GetVersion(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/version", apiPrefix), nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d", recorder.Code)
+	}
+	response := &struct {
+		Data *VersionResponse `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Data.Version != "v1.2.3" || response.Data.Commit != "abc1234" {
+		t.Errorf("expect the injected version and commit. got %s %s", response.Data.Version, response.Data.Commit)
+	}
+
+	recorder = httptest.NewRecorder()
+	GetBuildInfo(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/_buildinfo", apiPrefix), nil))
+	buildInfo := &struct {
+		Data *version.BuildInfo `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), buildInfo); err != nil {
+		t.Fatal(err)
+	}
+	if buildInfo.Data.BuildDate != "2026-10-17T00:00:00Z" || len(buildInfo.Data.GoVersion) == 0 {
+		t.Errorf("expect the build date and go version in the build info. got %v", buildInfo.Data)
+	}
+}
@@ -0,0 +1,632 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	webAuthnLog = log.WithField("go", "WebAuthn")
+	// WebAuthn runs the passkey ceremonies, nil if "auth.webauthn.enable" is false
+	WebAuthn *WebAuthnCeremonies
+)
+
+// WebAuthnCeremonies runs the WebAuthn registration and login ceremonies.
+// The ceremony sessions, holding the challenges, are kept in process until they are finished or timed out.
+type WebAuthnCeremonies struct {
+	webAuthn *webauthn.WebAuthn
+	sessions cache.ObjectCache
+	// decoyKey derives the passkey ids offered for the emails without passkey, so they can not be told apart
+	decoyKey []byte
+}
+
+// NewWebAuthnCeremonies create new instance of WebAuthnCeremonies for the relying party id, eg. "example.com",
+// accepting the ceremonies made from the origins, eg. "https://login.example.com", within the timeout.
+func NewWebAuthnCeremonies(rpID, rpName string, origins []string, timeout time.Duration) (*WebAuthnCeremonies, error) {
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("at least one origin is required")
+	}
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	seconds := int(timeout / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	decoyKey := make([]byte, 32)
+	if _, err := rand.Read(decoyKey); err != nil {
+		return nil, err
+	}
+	return &WebAuthnCeremonies{
+		webAuthn: webAuthn,
+		sessions: cache.NewInMemoryCache(10000, seconds, false),
+		decoyKey: decoyKey,
+	}, nil
+}
+
+// decoyUser returns a user holding a single made up passkey for the email of no user or of a user without passkey.
+// Its passkey id is the same every time for the email, like a registered passkey, so starting a login tells nothing about the email.
+func (ceremonies *WebAuthnCeremonies) decoyUser(email string) *webAuthnUser {
+	mac := hmac.New(sha256.New, ceremonies.decoyKey)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return &webAuthnUser{
+		user:        &connector.User{Email: email},
+		credentials: []webauthn.Credential{{ID: mac.Sum(nil)}},
+	}
+}
+
+// webAuthnSession is a started ceremony waiting to be finished
+type webAuthnSession struct {
+	// userRecID is empty for a login started with a decoyUser
+	userRecID    string
+	email        string
+	registration bool
+	data         webauthn.SessionData
+}
+
+func (ceremonies *WebAuthnCeremonies) startSession(session *webAuthnSession) string {
+	sessionID := helper.MakeRandomString(32, true, true, true, false)
+	ceremonies.sessions.Store(sessionID, session)
+	return sessionID
+}
+
+// finishSession returns the started ceremony of the session id. A session can only be finished once.
+func (ceremonies *WebAuthnCeremonies) finishSession(sessionID string, registration bool) (*webAuthnSession, bool) {
+	ok, stored := ceremonies.sessions.Fetch(sessionID)
+	if !ok {
+		return nil, false
+	}
+	ceremonies.sessions.Delete(sessionID)
+	session := stored.(*webAuthnSession)
+	return session, session.registration == registration
+}
+
+// webAuthnUser is a user with the passkeys it registered, as the WebAuthn library sees it.
+type webAuthnUser struct {
+	user        *connector.User
+	stored      []*connector.WebAuthnCredential
+	credentials []webauthn.Credential
+}
+
+func loadWebAuthnUser(ctx context.Context, user *connector.User) (*webAuthnUser, error) {
+	stored, err := WebAuthnCredentialRepo.ListWebAuthnCredentials(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, s := range stored {
+		credential := webauthn.Credential{}
+		if err := json.Unmarshal([]byte(s.Credential), &credential); err != nil {
+			return nil, fmt.Errorf("invalid passkey %s. got %s", s.RecID, err.Error())
+		}
+		credentials = append(credentials, credential)
+	}
+	return &webAuthnUser{user: user, stored: stored, credentials: credentials}, nil
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.RecID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *webAuthnUser) descriptors() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, len(u.credentials))
+	for i, credential := range u.credentials {
+		descriptors[i] = credential.Descriptor()
+	}
+	return descriptors
+}
+
+// storedCredential returns the stored passkey of the credential id
+func (u *webAuthnUser) storedCredential(credentialID []byte) *connector.WebAuthnCredential {
+	encoded := base64.RawURLEncoding.EncodeToString(credentialID)
+	for _, stored := range u.stored {
+		if stored.CredentialID == encoded {
+			return stored
+		}
+	}
+	return nil
+}
+
+// WebAuthnBeginResponse is the data of a started ceremony
+type WebAuthnBeginResponse struct {
+	// Session identifies the ceremony, it is sent back when finishing it
+	Session string `json:"session"`
+	// Options are to be passed to navigator.credentials.create() or navigator.credentials.get()
+	Options interface{} `json:"options"`
+}
+
+// WebAuthnRegistrationRequest finishes a passkey registration
+type WebAuthnRegistrationRequest struct {
+	Session string `json:"session"`
+	// Name optionally tells the passkey apart from the user's other passkeys
+	Name string `json:"name"`
+	// Credential is the PublicKeyCredential returned by navigator.credentials.create()
+	Credential json.RawMessage `json:"credential"`
+}
+
+// WebAuthnLoginBeginRequest starts a passkey login
+type WebAuthnLoginBeginRequest struct {
+	Email string `json:"email"`
+}
+
+// WebAuthnLoginRequest finishes a passkey login
+type WebAuthnLoginRequest struct {
+	Session string `json:"session"`
+	// Credential is the PublicKeyCredential returned by navigator.credentials.get()
+	Credential json.RawMessage `json:"credential"`
+	// NotBeforeDelay optionally delays the validity of the issued tokens, eg. "30 minutes"
+	NotBeforeDelay string `json:"not_before_delay"`
+	// Scope optionally narrows the permissions of the issued tokens, space separated, eg. "users:read audit:read"
+	Scope string `json:"scope"`
+	// RememberMe optionally issues a long lived refresh token if true, or a short lived one for shared devices if false
+	RememberMe *bool `json:"remember_me"`
+}
+
+// PasskeyRenameRequest renames a passkey
+type PasskeyRenameRequest struct {
+	Name string `json:"name"`
+}
+
+// readWebAuthnRequest checks the WebAuthn is enabled and parses the JSON body into req, otherwise writes the error response.
+func readWebAuthnRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if WebAuthn == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "passkey is not enabled", nil, nil)
+		return false
+	}
+	if req == nil {
+		return true
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "Unserviceable content type", nil, nil)
+		return false
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return false
+	}
+	if err := json.Unmarshal(body, req); err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return false
+	}
+	return true
+}
+
+// authenticatedUser returns the user of the request's token, otherwise writes the error response.
+func authenticatedUser(w http.ResponseWriter, r *http.Request) *connector.User {
+	authCtx := r.Context().Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	user, err := UserRepo.GetUserByEmail(r.Context(), authCtx.Subject)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return nil
+	}
+	if user == nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("User email %s not found", authCtx.Subject), nil, nil)
+		return nil
+	}
+	return user
+}
+
+// WebAuthnRegisterBegin starts registering a passkey for the authenticated user
+func WebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "WebAuthnRegisterBegin").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !readWebAuthnRequest(w, r, nil) {
+		return
+	}
+	if !applyImpersonationRestriction(w, r, "Passkey") {
+		return
+	}
+	user := authenticatedUser(w, r)
+	if user == nil {
+		return
+	}
+	wUser, err := loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("loadWebAuthnUser got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	creation, data, err := WebAuthn.webAuthn.BeginRegistration(wUser, webauthn.WithExclusions(wUser.descriptors()))
+	if err != nil {
+		fLog.Errorf("BeginRegistration got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	sessionID := WebAuthn.startSession(&webAuthnSession{userRecID: user.RecID, registration: true, data: *data})
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passkey registration started", nil, &WebAuthnBeginResponse{Session: sessionID, Options: creation})
+}
+
+// WebAuthnRegisterFinish verifies the authenticator's attestation and stores the new passkey
+func WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "WebAuthnRegisterFinish").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	req := &WebAuthnRegistrationRequest{}
+	if !readWebAuthnRequest(w, r, req) {
+		return
+	}
+	if !applyImpersonationRestriction(w, r, "Passkey") {
+		return
+	}
+	if len(req.Name) > 128 {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "passkey name is longer than 128 characters", nil, nil)
+		return
+	}
+	user := authenticatedUser(w, r)
+	if user == nil {
+		return
+	}
+	session, ok := WebAuthn.finishSession(req.Session, true)
+	if !ok || session.userRecID != user.RecID {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "unknown or expired passkey registration session", nil, nil)
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("invalid credential. got %s", err.Error()), nil, nil)
+		return
+	}
+	wUser, err := loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("loadWebAuthnUser got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	credential, err := WebAuthn.webAuthn.CreateCredential(wUser, session.data, parsed)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("passkey registration failed. got %s", err.Error()), nil, nil)
+		return
+	}
+	if wUser.storedCredential(credential.ID) != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusConflict, "passkey is already registered", nil, nil)
+		return
+	}
+	marshaled, err := json.Marshal(credential)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	name := req.Name
+	if len(name) == 0 {
+		name = fmt.Sprintf("Passkey %d", len(wUser.stored)+1)
+	}
+	passkey, err := WebAuthnCredentialRepo.CreateWebAuthnCredential(r.Context(), user, name, base64.RawURLEncoding.EncodeToString(credential.ID), string(marshaled))
+	if err != nil {
+		fLog.Errorf("WebAuthnCredentialRepo.CreateWebAuthnCredential got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passkey registered", nil, passkey)
+}
+
+// WebAuthnLoginBegin starts a passkey login of the user with the email
+func WebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "WebAuthnLoginBegin").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	req := &WebAuthnLoginBeginRequest{}
+	if !readWebAuthnRequest(w, r, req) {
+		return
+	}
+	user, err := getUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		fLog.Errorf("getUserByEmail got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	// an unknown email and a user without passkey get a login of a decoy passkey, the same response as a user with passkeys,
+	// which fails when it is finished.
+	wUser := WebAuthn.decoyUser(req.Email)
+	if user != nil {
+		loaded, err := loadWebAuthnUser(r.Context(), user)
+		if err != nil {
+			fLog.Errorf("loadWebAuthnUser got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		if len(loaded.credentials) > 0 {
+			wUser = loaded
+		}
+	}
+	assertion, data, err := WebAuthn.webAuthn.BeginLogin(wUser, webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		fLog.Errorf("BeginLogin got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	sessionID := WebAuthn.startSession(&webAuthnSession{userRecID: wUser.user.RecID, email: req.Email, data: *data})
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passkey login started", nil, &WebAuthnBeginResponse{Session: sessionID, Options: assertion})
+}
+
+// WebAuthnLoginFinish verifies the authenticator's assertion and issues the tokens, the same as a passphrase authentication.
+// Passkeys require user verification, so the login already has two factors and is not asked for 2FA.
+// A user of a role requiring 2FA must still enroll it first, the same as on a passphrase authentication.
+func WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "WebAuthnLoginFinish").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	req := &WebAuthnLoginRequest{}
+	if !readWebAuthnRequest(w, r, req) {
+		return
+	}
+	delay, err := parseNotBeforeDelay(req.NotBeforeDelay)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, err.Error(), nil, nil)
+		return
+	}
+	session, ok := WebAuthn.finishSession(req.Session, false)
+	if !ok {
+		emitSecurityEvent(r, SecurityEventLoginFailed, "", nil, "unknown or expired passkey login session")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "unknown or expired passkey login session", nil, nil)
+		return
+	}
+	var user *connector.User
+	if len(session.userRecID) > 0 {
+		user, err = UserRepo.GetUserByRecID(r.Context(), session.userRecID)
+	}
+	if err != nil || user == nil {
+		emitSecurityEvent(r, SecurityEventLoginFailed, session.email, nil, "no passkey registered")
+		throttleFailedLogin(r, session.email)
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, "passkey verification failed", nil, nil)
+		return
+	}
+	if !user.Enabled {
+		emitSecurityEvent(r, SecurityEventLoginFailed, session.email, user, "account disabled")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account disabled", nil, nil)
+		return
+	}
+	if user.Suspended {
+		emitSecurityEvent(r, SecurityEventLoginFailed, session.email, user, "account suspended")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account suspended", nil, nil)
+		return
+	}
+	active, err := UserRepo.IsUserActive(r.Context(), user)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	if !active {
+		emitSecurityEvent(r, SecurityEventLoginFailed, user.Email, user, "account deactivated")
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "account deactivated", nil, nil)
+		return
+	}
+	// failedPasskey counts the failed attempt of the user, like a passphrase that does not match
+	failedPasskey := func(reason string) {
+		countFailedAttempt(r, user, SecurityEventLoginFailed, session.email, reason)
+		if err := UserRepo.UpdateUser(r.Context(), user); err != nil {
+			fLog.Errorf("UserRepo.UpdateUser got %s", err.Error())
+		}
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusUnauthorized, reason, nil, nil)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("invalid credential. got %s", err.Error()), nil, nil)
+		return
+	}
+	wUser, err := loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("loadWebAuthnUser got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	credential, err := WebAuthn.webAuthn.ValidateLogin(wUser, session.data, parsed)
+	if err != nil {
+		failedPasskey(fmt.Sprintf("passkey verification failed. got %s", err.Error()))
+		return
+	}
+	// a sign count that does not increase tells the passkey may have been cloned
+	if credential.Authenticator.CloneWarning {
+		fLog.Warnf("passkey of %s has a sign count of %d that did not increase", user.Email, credential.Authenticator.SignCount)
+		failedPasskey("passkey verification failed. got sign count did not increase")
+		return
+	}
+	passkey := wUser.storedCredential(credential.ID)
+	if passkey == nil {
+		failedPasskey("passkey is not registered")
+		return
+	}
+	marshaled, err := json.Marshal(credential)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	passkey.Credential = string(marshaled)
+	passkey.LastUsedAt = time.Now()
+	if err := WebAuthnCredentialRepo.UpdateWebAuthnCredential(r.Context(), passkey); err != nil {
+		fLog.Errorf("WebAuthnCredentialRepo.UpdateWebAuthnCredential got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+
+	user.LastLogin = time.Unix(time.Now().Unix(), 0)
+	user.FailCount = 0
+	if err := UserRepo.UpdateUser(r.Context(), user); err != nil {
+		fLog.Errorf("UserRepo.UpdateUser got %s", err.Error())
+	}
+	throttleSucceededLogin(r, session.email)
+
+	userRoles, _, err := UserRepo.ListAllUserRoles(r.Context(), user, &helper.PageRequest{
+		No:       1,
+		PageSize: 1000,
+		OrderBy:  "ROLE_NAME",
+		Sort:     "ASC",
+	})
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	roles := make([]string, len(userRoles))
+	roleDomains := make([]string, 0, len(userRoles))
+	for k, v := range userRoles {
+		role, err := RoleRepo.GetRoleByRecID(r.Context(), v.RecID)
+		if err == nil {
+			roles[k] = fmt.Sprintf("%s@%s", role.RoleName, role.RoleDomain)
+			roleDomains = append(roleDomains, role.RoleDomain)
+		}
+	}
+
+	subject := user.Email
+
+	// Users of the roles requiring 2FA only get a token to enroll 2FA
+	if isTwoFAEnrollmentPending(user, roles) {
+		enrollment, err := createTwoFAEnrollmentToken(subject)
+		if err != nil {
+			fLog.Errorf("createTwoFAEnrollmentToken got %s", err.Error())
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+			return
+		}
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusForbidden, "2FA enrollment required", nil, &TwoFAEnrollmentResponse{EnrollmentToken: enrollment})
+		return
+	}
+
+	RevocationRepo.UnRevoke(r.Context(), subject)
+
+	claims, err := scopeClaims(regionClaims(r.Context(), roleDomains), roles, req.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	audience, err := scopeAudience(roles, roles, req.Scope)
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	access, refresh, err := TokenFactory.CreateTokenPair(subject, audience, claims, helper.TokenOptions{Delay: delay, RefreshTokenAge: rememberMeRefreshAge(audience, req.RememberMe)})
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Successful", nil, &Response{
+		AccessToken:  access,
+		RefreshToken: refresh,
+	})
+}
+
+// ListPasskeys lists the passkeys of the authenticated user
+func ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "ListPasskeys").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !readWebAuthnRequest(w, r, nil) {
+		return
+	}
+	user := authenticatedUser(w, r)
+	if user == nil {
+		return
+	}
+	passkeys, err := WebAuthnCredentialRepo.ListWebAuthnCredentials(r.Context(), user)
+	if err != nil {
+		fLog.Errorf("WebAuthnCredentialRepo.ListWebAuthnCredentials got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "List of passkeys", nil, passkeys)
+}
+
+// ownPasskey returns the authenticated user's passkey of the path's passkeyRecId, otherwise writes the error response.
+func ownPasskey(w http.ResponseWriter, r *http.Request) *connector.WebAuthnCredential {
+	user := authenticatedUser(w, r)
+	if user == nil {
+		return nil
+	}
+	passkey, err := WebAuthnCredentialRepo.GetWebAuthnCredentialByRecID(r.Context(), mux.Vars(r)["passkeyRecId"])
+	if err != nil {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return nil
+	}
+	if passkey == nil || passkey.UserRecID != user.RecID {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("passkey recid %s not found", mux.Vars(r)["passkeyRecId"]), nil, nil)
+		return nil
+	}
+	return passkey
+}
+
+// RenamePasskey renames a passkey of the authenticated user
+func RenamePasskey(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "RenamePasskey").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	req := &PasskeyRenameRequest{}
+	if !readWebAuthnRequest(w, r, req) {
+		return
+	}
+	if !applyImpersonationRestriction(w, r, "Passkey") {
+		return
+	}
+	if len(req.Name) == 0 || len(req.Name) > 128 {
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusBadRequest, "passkey name must be 1 to 128 characters", nil, nil)
+		return
+	}
+	passkey := ownPasskey(w, r)
+	if passkey == nil {
+		return
+	}
+	passkey.Name = req.Name
+	if err := WebAuthnCredentialRepo.UpdateWebAuthnCredential(r.Context(), passkey); err != nil {
+		fLog.Errorf("WebAuthnCredentialRepo.UpdateWebAuthnCredential got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passkey renamed", nil, passkey)
+}
+
+// DeletePasskey removes a passkey of the authenticated user, it can no longer be used to login
+func DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	fLog := webAuthnLog.WithField("func", "DeletePasskey").WithField("RequestID", r.Context().Value(constants.RequestID)).WithField("path", r.URL.Path).WithField("method", r.Method)
+	if !readWebAuthnRequest(w, r, nil) {
+		return
+	}
+	if !applyImpersonationRestriction(w, r, "Passkey") {
+		return
+	}
+	passkey := ownPasskey(w, r)
+	if passkey == nil {
+		return
+	}
+	if err := WebAuthnCredentialRepo.DeleteWebAuthnCredential(r.Context(), passkey); err != nil {
+		fLog.Errorf("WebAuthnCredentialRepo.DeleteWebAuthnCredential got %s", err.Error())
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, err.Error(), nil, nil)
+		return
+	}
+	helper.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Passkey deleted", nil, nil)
+}
@@ -0,0 +1,478 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+type fakeWebAuthnRepo struct {
+	connector.WebAuthnCredentialRepository
+	credentials []*connector.WebAuthnCredential
+}
+
+func (repo *fakeWebAuthnRepo) CreateWebAuthnCredential(ctx context.Context, user *connector.User, name, credentialID, credential string) (*connector.WebAuthnCredential, error) {
+	created := &connector.WebAuthnCredential{RecID: fmt.Sprintf("pk%d", len(repo.credentials)+1), UserRecID: user.RecID, Name: name, CredentialID: credentialID, Credential: credential, CreatedAt: time.Now(), LastUsedAt: time.Now()}
+	repo.credentials = append(repo.credentials, created)
+	return created, nil
+}
+
+func (repo *fakeWebAuthnRepo) GetWebAuthnCredentialByRecID(ctx context.Context, recID string) (*connector.WebAuthnCredential, error) {
+	for _, credential := range repo.credentials {
+		if credential.RecID == recID {
+			stored := *credential
+			return &stored, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *fakeWebAuthnRepo) ListWebAuthnCredentials(ctx context.Context, user *connector.User) ([]*connector.WebAuthnCredential, error) {
+	ret := make([]*connector.WebAuthnCredential, 0)
+	for _, credential := range repo.credentials {
+		if credential.UserRecID == user.RecID {
+			stored := *credential
+			ret = append(ret, &stored)
+		}
+	}
+	return ret, nil
+}
+
+func (repo *fakeWebAuthnRepo) UpdateWebAuthnCredential(ctx context.Context, credential *connector.WebAuthnCredential) error {
+	for i, stored := range repo.credentials {
+		if stored.RecID == credential.RecID {
+			updated := *credential
+			repo.credentials[i] = &updated
+		}
+	}
+	return nil
+}
+
+func (repo *fakeWebAuthnRepo) DeleteWebAuthnCredential(ctx context.Context, credential *connector.WebAuthnCredential) error {
+	for i, stored := range repo.credentials {
+		if stored.RecID == credential.RecID {
+			repo.credentials = append(repo.credentials[:i], repo.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// simulatedAuthenticator is an ES256 authenticator doing the WebAuthn ceremonies the way a browser and a security key would.
+type simulatedAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	origin       string
+}
+
+func newSimulatedAuthenticator(t *testing.T) *simulatedAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &simulatedAuthenticator{key: key, credentialID: credentialID, origin: "http://localhost:3000"}
+}
+
+func cborHeader(major byte, length int) []byte {
+	switch {
+	case length < 24:
+		return []byte{major<<5 | byte(length)}
+	case length < 256:
+		return []byte{major<<5 | 24, byte(length)}
+	default:
+		return []byte{major<<5 | 25, byte(length >> 8), byte(length)}
+	}
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHeader(2, len(b)), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHeader(3, len(s)), s...)
+}
+
+// coseKey is the authenticator's public key as a COSE EC2 key, {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+func (a *simulatedAuthenticator) coseKey() []byte {
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21}
+	key = append(key, cborBytes(a.key.PublicKey.X.FillBytes(make([]byte, 32)))...)
+	key = append(key, 0x22)
+	return append(key, cborBytes(a.key.PublicKey.Y.FillBytes(make([]byte, 32)))...)
+}
+
+func (a *simulatedAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *simulatedAuthenticator) clientData(ceremony, challenge string) []byte {
+	clientData, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	return clientData
+}
+
+// create answers navigator.credentials.create() with a "none" attestation
+func (a *simulatedAuthenticator) create(challenge string) json.RawMessage {
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey()...)
+	// user present, user verified and attested credential data included
+	authData := a.authenticatorData(0x01|0x04|0x40, attested)
+
+	attestation := []byte{0xa3}
+	attestation = append(attestation, cborText("fmt")...)
+	attestation = append(attestation, cborText("none")...)
+	attestation = append(attestation, cborText("attStmt")...)
+	attestation = append(attestation, 0xa0)
+	attestation = append(attestation, cborText("authData")...)
+	attestation = append(attestation, cborBytes(authData)...)
+
+	encode := base64.RawURLEncoding.EncodeToString
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(a.clientData("webauthn.create", challenge)),
+			"attestationObject": encode(attestation),
+		},
+	})
+	return credential
+}
+
+// get answers navigator.credentials.get(), signing the authenticator data and the client data hash
+func (a *simulatedAuthenticator) get(t *testing.T, challenge, userHandle string) json.RawMessage {
+	a.signCount++
+	authData := a.authenticatorData(0x01|0x04, nil)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode([]byte(userHandle)),
+		},
+	})
+	return credential
+}
+
+func webAuthnRequest(t *testing.T, handler http.HandlerFunc, subject string, vars map[string]string, body interface{}) *httptest.ResponseRecorder {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/auth/webauthn", apiPrefix), strings.NewReader(string(encoded)))
+	req.Header.Set("Content-Type", "application/json")
+	if len(subject) > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), constants.HansipAuthentication, &hansipcontext.AuthenticationContext{Subject: subject}))
+	}
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+// beginCeremony returns the session and the challenge of a started ceremony
+func beginCeremony(t *testing.T, recorder *httptest.ResponseRecorder) (string, string) {
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 but %d : %s", recorder.Code, recorder.Body.String())
+	}
+	response := &struct {
+		Data struct {
+			Session string `json:"session"`
+			Options struct {
+				PublicKey struct {
+					Challenge string `json:"challenge"`
+				} `json:"publicKey"`
+			} `json:"options"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Data.Session, response.Data.Options.PublicKey.Challenge
+}
+
+func setupWebAuthn(t *testing.T) *fakeWebAuthnRepo {
+	webAuthn, err := NewWebAuthnCeremonies("localhost", "Hansip", []string{"http://localhost:3000"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WebAuthn = webAuthn
+	repo := &fakeWebAuthnRepo{}
+	WebAuthnCredentialRepo = repo
+	TokenFactory = helper.NewTokenFactory("testkey", "HS256", config.Get("token.issuer"), 5*time.Minute, 7*24*time.Hour)
+	RevocationRepo = &fakeRevocationRepo{revoked: make(map[string]bool)}
+	UserRepo = &regionUserRepo{deactivationUserRepo{user: &connector.User{RecID: "u1", Email: "user@acme.com", Enabled: true}, active: true}}
+	RoleRepo = &regionRoleRepo{}
+	TenantRepo = &regionTenantRepo{regions: map[string]string{}}
+	return repo
+}
+
+func registerPasskey(t *testing.T, authenticator *simulatedAuthenticator, name string) *httptest.ResponseRecorder {
+	session, challenge := beginCeremony(t, webAuthnRequest(t, WebAuthnRegisterBegin, "user@acme.com", nil, nil))
+	return webAuthnRequest(t, WebAuthnRegisterFinish, "user@acme.com", nil, &WebAuthnRegistrationRequest{
+		Session:    session,
+		Name:       name,
+		Credential: authenticator.create(challenge),
+	})
+}
+
+func loginPasskey(t *testing.T, authenticator *simulatedAuthenticator) *httptest.ResponseRecorder {
+	session, challenge := beginCeremony(t, webAuthnRequest(t, WebAuthnLoginBegin, "", nil, &WebAuthnLoginBeginRequest{Email: "user@acme.com"}))
+	return webAuthnRequest(t, WebAuthnLoginFinish, "", nil, &WebAuthnLoginRequest{
+		Session:    session,
+		Credential: authenticator.get(t, challenge, "u1"),
+	})
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	repo := setupWebAuthn(t)
+	defer func() { WebAuthn = nil }()
+
+	laptop := newSimulatedAuthenticator(t)
+	if recorder := registerPasskey(t, laptop, "Laptop"); recorder.Code != http.StatusOK {
+		t.Fatalf("registration should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	phone := newSimulatedAuthenticator(t)
+	if recorder := registerPasskey(t, phone, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("second registration should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if len(repo.credentials) != 2 || repo.credentials[0].Name != "Laptop" || repo.credentials[1].Name != "Passkey 2" {
+		t.Fatalf("expect passkeys Laptop and Passkey 2. got %v", repo.credentials)
+	}
+	if recorder := registerPasskey(t, laptop, "Again"); recorder.Code == http.StatusOK {
+		t.Errorf("registering the same authenticator twice should fail")
+	}
+
+	recorder := loginPasskey(t, laptop)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	response := &struct {
+		Data *Response `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := TokenFactory.ReadToken(response.Data.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Subject != "user@acme.com" || len(tok.Audiences) != 1 || tok.Audiences[0] != "user@acme" {
+		t.Errorf("expect token of user@acme.com for user@acme. got %s %v", tok.Subject, tok.Audiences)
+	}
+	if recorder := loginPasskey(t, phone); recorder.Code != http.StatusOK {
+		t.Errorf("login with the second passkey should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+
+	// a finished session can not be replayed
+	session, challenge := beginCeremony(t, webAuthnRequest(t, WebAuthnLoginBegin, "", nil, &WebAuthnLoginBeginRequest{Email: "user@acme.com"}))
+	login := &WebAuthnLoginRequest{Session: session, Credential: laptop.get(t, challenge, "u1")}
+	if recorder := webAuthnRequest(t, WebAuthnLoginFinish, "", nil, login); recorder.Code != http.StatusOK {
+		t.Fatalf("login should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := webAuthnRequest(t, WebAuthnLoginFinish, "", nil, login); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("replayed login should be unauthorized. got %d", recorder.Code)
+	}
+
+	// a cloned authenticator does not increase the sign count
+	laptop.signCount = 1
+	if recorder := loginPasskey(t, laptop); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("login with a sign count that did not increase should be unauthorized. got %d", recorder.Code)
+	}
+
+	// the assertion must be made for the relying party origin and signed by the registered key
+	phishing := newSimulatedAuthenticator(t)
+	phishing.credentialID = phone.credentialID
+	if recorder := loginPasskey(t, phishing); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("login signed by another key should be unauthorized. got %d", recorder.Code)
+	}
+	phone.origin = "https://phishing.example.com"
+	if recorder := loginPasskey(t, phone); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("login from another origin should be unauthorized. got %d", recorder.Code)
+	}
+}
+
+func TestPasskeyManagement(t *testing.T) {
+	repo := setupWebAuthn(t)
+	defer func() { WebAuthn = nil }()
+	for _, name := range []string{"Laptop", "Phone"} {
+		if recorder := registerPasskey(t, newSimulatedAuthenticator(t), name); recorder.Code != http.StatusOK {
+			t.Fatalf("registration should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	repo.credentials = append(repo.credentials, &connector.WebAuthnCredential{RecID: "other", UserRecID: "u2", Name: "Other"})
+
+	recorder := webAuthnRequest(t, RenamePasskey, "user@acme.com", map[string]string{"passkeyRecId": "pk2"}, &PasskeyRenameRequest{Name: "Work phone"})
+	if recorder.Code != http.StatusOK || repo.credentials[1].Name != "Work phone" {
+		t.Errorf("passkey should be renamed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := webAuthnRequest(t, DeletePasskey, "user@acme.com", map[string]string{"passkeyRecId": "other"}, nil); recorder.Code != http.StatusNotFound {
+		t.Errorf("deleting other user's passkey should be not found. got %d", recorder.Code)
+	}
+	if recorder := webAuthnRequest(t, DeletePasskey, "user@acme.com", map[string]string{"passkeyRecId": "pk1"}, nil); recorder.Code != http.StatusOK {
+		t.Errorf("passkey should be deleted. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = webAuthnRequest(t, ListPasskeys, "user@acme.com", nil, nil)
+	response := &struct {
+		Data []*connector.WebAuthnCredential `json:"data"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data) != 1 || response.Data[0].Name != "Work phone" {
+		t.Errorf("expect only the Work phone passkey. got %s", recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "publicKey") {
+		t.Errorf("passkey listing should not expose the credential. got %s", recorder.Body.String())
+	}
+
+	WebAuthn = nil
+	if recorder := webAuthnRequest(t, ListPasskeys, "user@acme.com", nil, nil); recorder.Code != http.StatusNotFound {
+		t.Errorf("disabled passkey should be not found. got %d", recorder.Code)
+	}
+}
+
+func TestPasskeyLoginFailures(t *testing.T) {
+	setupWebAuthn(t)
+	defer func() { WebAuthn = nil }()
+
+	allowed := func(email string) (string, []string) {
+		recorder := webAuthnRequest(t, WebAuthnLoginBegin, "", nil, &WebAuthnLoginBeginRequest{Email: email})
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("login begin of %s should respond 200. got %d : %s", email, recorder.Code, recorder.Body.String())
+		}
+		response := &struct {
+			Data struct {
+				Session string `json:"session"`
+				Options struct {
+					PublicKey struct {
+						AllowCredentials []struct {
+							ID string `json:"id"`
+						} `json:"allowCredentials"`
+					} `json:"publicKey"`
+				} `json:"options"`
+			} `json:"data"`
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0)
+		for _, credential := range response.Data.Options.PublicKey.AllowCredentials {
+			ids = append(ids, credential.ID)
+		}
+		return response.Data.Session, ids
+	}
+
+	// an unknown email and a user without passkey look like a user with a passkey
+	_, unknown := allowed("nobody@acme.com")
+	_, again := allowed("nobody@acme.com")
+	if len(unknown) != 1 || len(again) != 1 || unknown[0] != again[0] {
+		t.Errorf("expect the same single passkey for an unknown email. got %v and %v", unknown, again)
+	}
+	session, noPasskey := allowed("user@acme.com")
+	if len(noPasskey) != 1 || noPasskey[0] == unknown[0] {
+		t.Errorf("expect a single passkey of its own for a user without passkey. got %v", noPasskey)
+	}
+	stranger := newSimulatedAuthenticator(t)
+	if recorder := webAuthnRequest(t, WebAuthnLoginFinish, "", nil, &WebAuthnLoginRequest{Session: session, Credential: stranger.get(t, "", "u1")}); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("login of a user without passkey should be unauthorized. got %d", recorder.Code)
+	}
+
+	// a failed passkey verification counts as a failed login of the user
+	laptop := newSimulatedAuthenticator(t)
+	if recorder := registerPasskey(t, laptop, "Laptop"); recorder.Code != http.StatusOK {
+		t.Fatalf("registration should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	phishing := newSimulatedAuthenticator(t)
+	phishing.credentialID = laptop.credentialID
+	if recorder := loginPasskey(t, phishing); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("login signed by another key should be unauthorized. got %d", recorder.Code)
+	}
+	user, _ := UserRepo.GetUserByRecID(context.Background(), "u1")
+	if user.FailCount != 1 {
+		t.Errorf("expect a fail count of 1 after a failed passkey login. got %d", user.FailCount)
+	}
+	if recorder := loginPasskey(t, laptop); recorder.Code != http.StatusOK {
+		t.Fatalf("login should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+	if user.FailCount != 0 {
+		t.Errorf("expect the fail count reset by a passkey login. got %d", user.FailCount)
+	}
+}
+
+func TestPasskeyImpersonation(t *testing.T) {
+	repo := setupWebAuthn(t)
+	defer func() { WebAuthn = nil }()
+	if recorder := registerPasskey(t, newSimulatedAuthenticator(t), "Laptop"); recorder.Code != http.StatusOK {
+		t.Fatalf("registration should succeed. got %d : %s", recorder.Code, recorder.Body.String())
+	}
+
+	impersonated := func(method, path, body string) *http.Request {
+		req := impersonatedRequest(method, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	passkeyPath := fmt.Sprintf("%s/management/user/passkey/%s", apiPrefix, repo.credentials[0].RecID)
+	for name, call := range map[string]func(w http.ResponseWriter){
+		"register begin": func(w http.ResponseWriter) {
+			WebAuthnRegisterBegin(w, impersonated(http.MethodPost, apiPrefix+"/auth/webauthn/register/begin", `{}`))
+		},
+		"register finish": func(w http.ResponseWriter) {
+			WebAuthnRegisterFinish(w, impersonated(http.MethodPost, apiPrefix+"/auth/webauthn/register/finish", `{}`))
+		},
+		"rename": func(w http.ResponseWriter) {
+			RenamePasskey(w, mux.SetURLVars(impersonated(http.MethodPut, passkeyPath, `{"name":"Mine"}`), map[string]string{"passkeyRecId": repo.credentials[0].RecID}))
+		},
+		"delete": func(w http.ResponseWriter) {
+			DeletePasskey(w, mux.SetURLVars(impersonated(http.MethodDelete, passkeyPath, `{}`), map[string]string{"passkeyRecId": repo.credentials[0].RecID}))
+		},
+	} {
+		recorder := httptest.NewRecorder()
+		call(recorder)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("passkey %s should be forbidden while impersonating. got %d : %s", name, recorder.Code, recorder.Body.String())
+		}
+	}
+	if len(repo.credentials) != 1 || repo.credentials[0].Name != "Laptop" {
+		t.Errorf("expect the passkey unchanged. got %v", repo.credentials)
+	}
+}
@@ -0,0 +1,152 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	flagsLog = log.WithField("go", "Flags")
+
+	// DefaultSource is the source of the flags used by Enabled
+	DefaultSource Source = &ConfigSource{}
+)
+
+// Flag is the rollout rule of a feature flag.
+type Flag struct {
+	Name string
+	// Enabled switches the flag on, only for the subjects within the other rules.
+	Enabled bool
+	// Tenants are the tenant domains the flag is on for, all tenants if empty.
+	Tenants []string
+	// Roles are the roles the flag is on for, either "role@domain" or only the role name, all roles if empty.
+	Roles []string
+	// Percentage of the subjects the flag is on for, from 0 to 100.
+	// A subject always falls into the same side of the percentage.
+	Percentage int
+}
+
+// Source provides the rollout rule of the feature flags, such as the configuration or a flags repository.
+type Source interface {
+	// GetFlag returns the flag of the name, nil if the flag is not defined.
+	GetFlag(ctx context.Context, name string) (*Flag, error)
+}
+
+// ConfigSource reads the flags from the configuration "flags.<name>.enable", "flags.<name>.tenants",
+// "flags.<name>.roles" and "flags.<name>.percentage".
+type ConfigSource struct {
+}
+
+// GetFlag returns the flag of the name, nil if "flags.<name>.enable" is not configured.
+func (source *ConfigSource) GetFlag(ctx context.Context, name string) (*Flag, error) {
+	prefix := fmt.Sprintf("flags.%s.", name)
+	enable := config.Get(prefix + "enable")
+	if len(enable) == 0 {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(enable)
+	if err != nil {
+		return nil, fmt.Errorf("%senable %q is not a boolean", prefix, enable)
+	}
+	percentage := 100
+	if value := config.Get(prefix + "percentage"); len(value) > 0 {
+		percentage, err = strconv.Atoi(value)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return nil, fmt.Errorf("%spercentage %q is not a number between 0 and 100", prefix, value)
+		}
+	}
+	return &Flag{
+		Name:       name,
+		Enabled:    enabled,
+		Tenants:    config.GetList(prefix + "tenants"),
+		Roles:      config.GetList(prefix + "roles"),
+		Percentage: percentage,
+	}, nil
+}
+
+// Enabled check whether the flag is on for the authenticated subject of the context, using the DefaultSource.
+// An undefined flag, or a flag that can not be read, is off.
+func Enabled(ctx context.Context, name string) bool {
+	flag, err := DefaultSource.GetFlag(ctx, name)
+	if err != nil {
+		flagsLog.WithField("func", "Enabled").Errorf("flag %s is off because it can not be read. got %s", name, err.Error())
+		return false
+	}
+	if flag == nil {
+		return false
+	}
+	var authCtx *hansipcontext.AuthenticationContext
+	if ctx != nil {
+		authCtx, _ = ctx.Value(constants.HansipAuthentication).(*hansipcontext.AuthenticationContext)
+	}
+	return flag.IsOnFor(authCtx)
+}
+
+// IsOnFor check whether the flag is on for the authenticated subject.
+// Without an authenticated subject, the flag is only on if it is not scoped to any tenant, role or percentage.
+func (flag *Flag) IsOnFor(authCtx *hansipcontext.AuthenticationContext) bool {
+	if !flag.Enabled {
+		return false
+	}
+	if authCtx == nil {
+		return len(flag.Tenants) == 0 && len(flag.Roles) == 0 && flag.Percentage >= 100
+	}
+	if len(flag.Tenants) > 0 && !matchAudience(authCtx.Audience, flag.Tenants, func(aud, tenant string) bool {
+		return audienceDomain(aud) == tenant
+	}) {
+		return false
+	}
+	if len(flag.Roles) > 0 && !matchAudience(authCtx.Audience, flag.Roles, func(aud, role string) bool {
+		return aud == role || (!strings.Contains(role, "@") && strings.SplitN(aud, "@", 2)[0] == role)
+	}) {
+		return false
+	}
+	return bucket(flag.Name, authCtx.Subject) < flag.Percentage
+}
+
+func matchAudience(audience, values []string, match func(aud, value string) bool) bool {
+	for _, aud := range audience {
+		for _, value := range values {
+			if match(aud, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func audienceDomain(aud string) string {
+	if idx := strings.Index(aud, "@"); idx >= 0 {
+		return aud[idx+1:]
+	}
+	return ""
+}
+
+// bucket places the subject into one of 100 buckets, differently for each flag,
+// so the same subjects are not always the first to get every new feature.
+func bucket(name, subject string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(name + ":" + subject))
+	return int(hash.Sum32() % 100)
+}
+
+// Gate only serves the handler when the flag is on, otherwise it responds 404 as if the path does not exist.
+func Gate(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled(r.Context(), name) {
+			helper.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, fmt.Sprintf("Path %s not found", r.URL.Path), nil, nil)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,94 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/constants"
+	"github.com/hyperjumptech/hansip/internal/hansipcontext"
+)
+
+func withSubject(ctx context.Context, subject string, audience ...string) context.Context {
+	return context.WithValue(ctx, constants.HansipAuthentication, &hansipcontext.AuthenticationContext{
+		Subject:  subject,
+		Audience: audience,
+	})
+}
+
+func TestGateByTenant(t *testing.T) {
+	config.Set("flags.newFlow.enable", "true")
+	config.Set("flags.newFlow.tenants", "acme, initech")
+	defer func() {
+		config.Set("flags.newFlow.enable", "")
+		config.Set("flags.newFlow.tenants", "")
+	}()
+
+	handler := Gate("newFlow", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(ctx context.Context) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/newflow", nil).WithContext(ctx)
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder.Code
+	}
+
+	if code := call(withSubject(context.Background(), "alice@acme.com", "user@acme")); code != http.StatusOK {
+		t.Errorf("flag should be on for tenant acme. got %d", code)
+	}
+	if code := call(withSubject(context.Background(), "bob@globex.com", "user@globex")); code != http.StatusNotFound {
+		t.Errorf("flag should be off for tenant globex. got %d", code)
+	}
+	if code := call(context.Background()); code != http.StatusNotFound {
+		t.Errorf("tenant scoped flag should be off without authentication. got %d", code)
+	}
+
+	config.Set("flags.newFlow.enable", "false")
+	if code := call(withSubject(context.Background(), "alice@acme.com", "user@acme")); code != http.StatusNotFound {
+		t.Errorf("disabled flag should be off for tenant acme. got %d", code)
+	}
+
+	if Enabled(context.Background(), "undefinedFlow") {
+		t.Errorf("undefined flag should be off")
+	}
+}
+
+func TestFlagRolesAndPercentage(t *testing.T) {
+	flag := &Flag{Name: "newFlow", Enabled: true, Roles: []string{"admin", "auditor@acme"}, Percentage: 100}
+	if !flag.IsOnFor(&hansipcontext.AuthenticationContext{Subject: "a", Audience: []string{"admin@globex"}}) {
+		t.Errorf("role name should match any domain")
+	}
+	if flag.IsOnFor(&hansipcontext.AuthenticationContext{Subject: "a", Audience: []string{"auditor@globex"}}) {
+		t.Errorf("role with domain should only match that domain")
+	}
+
+	flag = &Flag{Name: "newFlow", Enabled: true, Percentage: 30}
+	on := 0
+	for i := 0; i < 1000; i++ {
+		authCtx := &hansipcontext.AuthenticationContext{Subject: fmt.Sprintf("user%d@test.com", i)}
+		first := flag.IsOnFor(authCtx)
+		if first != flag.IsOnFor(authCtx) {
+			t.Fatalf("the same subject should always get the same result")
+		}
+		if first {
+			on++
+		}
+	}
+	if on < 250 || on > 350 {
+		t.Errorf("expect about 300 of 1000 subjects to get the flag. got %d", on)
+	}
+
+	config.Set("flags.brokenFlow.enable", "true")
+	config.Set("flags.brokenFlow.percentage", "150")
+	defer func() {
+		config.Set("flags.brokenFlow.enable", "")
+		config.Set("flags.brokenFlow.percentage", "")
+	}()
+	if Enabled(context.Background(), "brokenFlow") {
+		t.Errorf("flag with invalid percentage should be off")
+	}
+}
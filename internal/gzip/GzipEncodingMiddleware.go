@@ -3,6 +3,7 @@ package gzip
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"net/http/httptest"
@@ -31,13 +32,65 @@ func NewGzipEncoderFilter(enable bool, minSizeToCompress int) *EncoderFilter {
 type EncoderFilter struct {
 	EnableGzip  bool
 	GzipMinSize int
+	Exclusions  []*Exclusion
+}
+
+// Exclusion is a request path prefix and/or method that should never be compressed.
+// Empty Method matches any method, empty PathPrefix matches any path.
+type Exclusion struct {
+	Method     string
+	PathPrefix string
+}
+
+// Matches check whether the request is excluded from compression
+func (e *Exclusion) Matches(r *http.Request) bool {
+	if len(e.Method) > 0 && !strings.EqualFold(e.Method, r.Method) {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, e.PathPrefix)
+}
+
+// ParseExclusions parses comma separated exclusion list. Each exclusion is either a path prefix,
+// a method, or a method followed by a space and a path prefix, eg. "/api/v1/stream,HEAD,GET /docs".
+func ParseExclusions(spec string) ([]*Exclusion, error) {
+	ret := make([]*Exclusion, 0)
+	for _, item := range strings.Split(spec, ",") {
+		fields := strings.Fields(item)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			if strings.HasPrefix(fields[0], "/") {
+				ret = append(ret, &Exclusion{PathPrefix: fields[0]})
+			} else {
+				ret = append(ret, &Exclusion{Method: strings.ToUpper(fields[0])})
+			}
+		case 2:
+			if !strings.HasPrefix(fields[1], "/") {
+				return nil, fmt.Errorf("gzip exclusion %s path prefix must start with /", item)
+			}
+			ret = append(ret, &Exclusion{Method: strings.ToUpper(fields[0]), PathPrefix: fields[1]})
+		default:
+			return nil, fmt.Errorf("gzip exclusion %s is invalid", item)
+		}
+	}
+	return ret, nil
+}
+
+func (filter *EncoderFilter) isExcluded(r *http.Request) bool {
+	for _, exclusion := range filter.Exclusions {
+		if exclusion.Matches(r) {
+			return true
+		}
+	}
+	return false
 }
 
 // DoFilter will return the middleware function for compressing body IF the client ask for Accept-Encoding: gzip
 func (filter *EncoderFilter) DoFilter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if the client can accept the gzip encoding.
-		if filter.EnableGzip == false || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		if filter.EnableGzip == false || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || filter.isExcluded(r) {
 			// The client cannot accept it, so return the output
 			// uncompressed.
 			gzipFilterLog.Tracef("Enable gzip is %v. Accept-Encoding is %s ", filter.EnableGzip, r.Header.Get("Accept-Encoding"))
@@ -0,0 +1,46 @@
+package gzip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncoderFilterExclusion(t *testing.T) {
+	exclusions, err := ParseExclusions("/api/v1/stream, HEAD, post /api/v1/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewGzipEncoderFilter(true, 10)
+	filter.Exclusions = exclusions
+	handler := filter.DoFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("compress me ", 100)))
+	}))
+
+	testData := []struct {
+		method     string
+		path       string
+		compressed bool
+	}{
+		{http.MethodGet, "/api/v1/stream/events", false},
+		{http.MethodHead, "/api/v1/management/users", false},
+		{http.MethodPost, "/api/v1/export", false},
+		{http.MethodGet, "/api/v1/export", true},
+		{http.MethodGet, "/api/v1/management/users", true},
+	}
+	for _, td := range testData {
+		req := httptest.NewRequest(td.method, td.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if compressed := recorder.Header().Get("Content-Encoding") == "gzip"; compressed != td.compressed {
+			t.Errorf("%s %s expect compressed %v but %v", td.method, td.path, td.compressed, compressed)
+		}
+	}
+
+	if _, err := ParseExclusions("GET api/v1"); err == nil {
+		t.Errorf("expect error for path without leading /")
+	}
+}
@@ -12,6 +12,15 @@ type AuthenticationContext struct {
 	Subject   string
 	Audience  []string
 	TokenType string
+	// Impersonator is the subject of the admin impersonating the Subject, empty if not impersonated
+	Impersonator string
+	// Permissions are the values of the token's "permissions" claim, if any
+	Permissions []string
+}
+
+// IsImpersonated check whether the token is an impersonation token issued to an admin
+func (c *AuthenticationContext) IsImpersonated() bool {
+	return len(c.Impersonator) > 0
 }
 
 // IsAdminOfDomain validate if the user have an admin account of a domain
@@ -1,8 +1,12 @@
 package hansiperrors
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 )
 
 type ErrPathNotAllowed struct {
@@ -55,6 +59,16 @@ func (e *ErrTokenInvalid) Unwrap() error {
 	return e.Wrapped
 }
 
+// ErrTokenExpired is a genuine token presented past its expiry
+type ErrTokenExpired struct {
+	Subject   string
+	TokenType string
+}
+
+func (e *ErrTokenExpired) Error() string {
+	return fmt.Sprintf("%s token of %s is expired", e.TokenType, e.Subject)
+}
+
 type ErrInvalidIssuer struct {
 	InvalidIssuer string
 }
@@ -62,3 +76,89 @@ type ErrInvalidIssuer struct {
 func (e *ErrInvalidIssuer) Error() string {
 	return fmt.Sprintf("invalid issuer \"%s\" error", e.InvalidIssuer)
 }
+
+type ErrPassphraseReused struct {
+	History int
+}
+
+func (e *ErrPassphraseReused) Error() string {
+	return fmt.Sprintf("passphrase must not be the same as any of the last %d passphrases", e.History)
+}
+
+type ErrPassphraseTooRecent struct {
+	MinAge    time.Duration
+	ChangedAt time.Time
+}
+
+func (e *ErrPassphraseTooRecent) Error() string {
+	return fmt.Sprintf("passphrase was changed less than %s ago. it can be changed again after %s", e.MinAge.String(), e.ChangedAt.Add(e.MinAge).Format(time.RFC3339))
+}
+
+type ErrTenantSuspended struct {
+	Domain string
+}
+
+func (e *ErrTenantSuspended) Error() string {
+	return fmt.Sprintf("tenant %s is suspended", e.Domain)
+}
+
+type ErrEmailDomainNotAllowed struct {
+	Email   string
+	Tenant  string
+	Allowed []string
+}
+
+func (e *ErrEmailDomainNotAllowed) Error() string {
+	return fmt.Sprintf("email %s is not allowed by tenant %s. the email domain must be one of %s", e.Email, e.Tenant, strings.Join(e.Allowed, ", "))
+}
+
+type ErrOutboundTimeout struct {
+	Connector string
+	Timeout   time.Duration
+	Wrapped   error
+}
+
+func (e *ErrOutboundTimeout) Error() string {
+	return fmt.Sprintf("%s call timed out after %s. got %s", e.Connector, e.Timeout.String(), e.Wrapped.Error())
+}
+
+func (e *ErrOutboundTimeout) Unwrap() error {
+	return e.Wrapped
+}
+
+// Temporary reports the call may succeed when retried
+func (e *ErrOutboundTimeout) Temporary() bool {
+	return true
+}
+
+type ErrOutboundCall struct {
+	Connector string
+	Wrapped   error
+}
+
+func (e *ErrOutboundCall) Error() string {
+	return fmt.Sprintf("%s call failed. got %s", e.Connector, e.Wrapped.Error())
+}
+
+func (e *ErrOutboundCall) Unwrap() error {
+	return e.Wrapped
+}
+
+// Temporary reports the call may succeed when retried, that is when it failed on the network
+func (e *ErrOutboundCall) Temporary() bool {
+	var netErr net.Error
+	return errors.As(e.Wrapped, &netErr)
+}
+
+// OutboundError types the error of an outbound call to a third party, ErrOutboundTimeout if the call was cut off
+// by the timeout or a context deadline, ErrOutboundCall otherwise.
+func OutboundError(connector string, timeout time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &ErrOutboundTimeout{Connector: connector, Timeout: timeout, Wrapped: err}
+	}
+	return &ErrOutboundCall{Connector: connector, Wrapped: err}
+}
@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+var (
+	// TemplateConfigKeys maps the email templates to their configuration key, eg. "mailer.templates.emailveri" for EMAIL_VERIFY
+	TemplateConfigKeys = map[string]string{
+		"EMAIL_VERIFY":        "mailer.templates.emailveri",
+		"PASSPHRASE_RECOVERY": "mailer.templates.passrecover",
+		"WELCOME":             "mailer.templates.welcome",
+		"EMAIL_CHANGE":        "mailer.templates.emailchange",
+		"EMAIL_CHANGE_NOTICE": "mailer.templates.emailchangenotice",
+		"ANNOUNCEMENT":        "mailer.templates.announcement",
+	}
+
+	// LocalizedTemplates maps the locales of "mailer.locales" to their translated email templates.
+	// A locale may translate only some of the templates.
+	LocalizedTemplates map[string]map[string]*EmailTemplates
+)
+
+// NormalizeLocale lower cases the locale and separates its parts with a dash, so fr_CA and fr-CA are the same locale
+func NormalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// localeChain returns the locales to try for the locale, the exact locale first and then its base languages,
+// eg. fr-ca then fr. The default templates come after the chain.
+func localeChain(locale string) []string {
+	chain := make([]string, 0)
+	for locale = NormalizeLocale(locale); len(locale) > 0; {
+		chain = append(chain, locale)
+		dash := strings.LastIndex(locale, "-")
+		if dash < 0 {
+			break
+		}
+		locale = locale[:dash]
+	}
+	return chain
+}
+
+// LoadLocalizedTemplates loads the translated templates of the comma separated locales. The templates of a locale
+// are configured with the locale after the template key, eg. "mailer.templates.emailveri.fr_ca.subject" and
+// "mailer.templates.emailveri.fr_ca.body". A template without translation is left out, its subject and body must be translated together.
+func LoadLocalizedTemplates(locales string) (map[string]map[string]*EmailTemplates, error) {
+	localized := make(map[string]map[string]*EmailTemplates)
+	for _, locale := range strings.Split(locales, ",") {
+		if locale = NormalizeLocale(locale); len(locale) == 0 {
+			continue
+		}
+		// the key has an underscore instead of the dash, which can not be in an environment variable name
+		configLocale := strings.ReplaceAll(locale, "-", "_")
+		translated := make(map[string]*EmailTemplates)
+		for name, key := range TemplateConfigKeys {
+			subjectKey, bodyKey := fmt.Sprintf("%s.%s.subject", key, configLocale), fmt.Sprintf("%s.%s.body", key, configLocale)
+			subjectURI, bodyURI := config.Get(subjectKey), config.Get(bodyKey)
+			if len(subjectURI) == 0 && len(bodyURI) == 0 {
+				continue
+			}
+			if len(subjectURI) == 0 || len(bodyURI) == 0 {
+				return nil, fmt.Errorf("%s and %s must both be set", subjectKey, bodyKey)
+			}
+			subject, err := TemplateLoader(subjectURI)
+			if err != nil {
+				return nil, fmt.Errorf("%s can not be loaded. got %s", subjectKey, err.Error())
+			}
+			body, err := TemplateLoader(bodyURI)
+			if err != nil {
+				return nil, fmt.Errorf("%s can not be loaded. got %s", bodyKey, err.Error())
+			}
+			templates := &EmailTemplates{}
+			if templates.SubjectTemplate, err = template.New(subjectKey).Parse(subject); err != nil {
+				return nil, fmt.Errorf("%s is not a valid template. got %s", subjectKey, err.Error())
+			}
+			if templates.BodyTemplate, err = template.New(bodyKey).Parse(body); err != nil {
+				return nil, fmt.Errorf("%s is not a valid template. got %s", bodyKey, err.Error())
+			}
+			translated[name] = templates
+		}
+		localized[locale] = translated
+	}
+	return localized, nil
+}
+
+// ResolveTemplates returns the templates of the email in the locale, falling back to its base language and then to
+// the default templates, eg. fr-CA, fr and the default. A locale missing the template falls through to the next one.
+func ResolveTemplates(name, locale string) (*EmailTemplates, error) {
+	for _, candidate := range localeChain(locale) {
+		if templates, ok := LocalizedTemplates[candidate][name]; ok {
+			return templates, nil
+		}
+	}
+	if templates, ok := Templates[name]; ok {
+		return templates, nil
+	}
+	return nil, fmt.Errorf("email template %s not found for locale %q, its base language nor the default", name, locale)
+}
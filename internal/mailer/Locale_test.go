@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+)
+
+func setLocalizedTemplates(t *testing.T, values map[string]string) {
+	t.Cleanup(func() {
+		for key := range values {
+			config.Set(key, "")
+		}
+		LocalizedTemplates = map[string]map[string]*EmailTemplates{}
+	})
+	for key, value := range values {
+		config.Set(key, value)
+	}
+	localized, err := LoadLocalizedTemplates("fr, fr_CA, de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	LocalizedTemplates = localized
+}
+
+func TestLocaleChain(t *testing.T) {
+	for locale, expect := range map[string]string{
+		"fr-CA":      "fr-ca,fr",
+		"fr_CA":      "fr-ca,fr",
+		"zh-Hant-TW": "zh-hant-tw,zh-hant,zh",
+		"de":         "de",
+		"":           "",
+	} {
+		if chain := strings.Join(localeChain(locale), ","); chain != expect {
+			t.Errorf("expect the chain of %q to be %q. got %q", locale, expect, chain)
+		}
+	}
+}
+
+func TestResolveTemplates(t *testing.T) {
+	setLocalizedTemplates(t, map[string]string{
+		"mailer.templates.passrecover.fr_ca.subject": "Récupération (Canada)",
+		"mailer.templates.passrecover.fr_ca.body":    "Bonjour du Canada",
+		"mailer.templates.passrecover.fr.subject":    "Récupération",
+		"mailer.templates.passrecover.fr.body":       "Bonjour",
+		"mailer.templates.welcome.fr.subject":        "Bienvenue",
+		"mailer.templates.welcome.fr.body":           "Bienvenue {{.}}",
+	})
+
+	subjectOf := func(name, locale string) string {
+		templates, err := ResolveTemplates(name, locale)
+		if err != nil {
+			t.Fatalf("expect %s resolved for %q. got %s", name, locale, err.Error())
+		}
+		subject := &strings.Builder{}
+		templates.SubjectTemplate.Execute(subject, nil)
+		return subject.String()
+	}
+	defaultRecovery := subjectOf("PASSPHRASE_RECOVERY", "")
+	defaultVerify := subjectOf("EMAIL_VERIFY", "")
+	for _, test := range []struct {
+		name, locale, subject string
+	}{
+		{"PASSPHRASE_RECOVERY", "fr-CA", "Récupération (Canada)"},
+		{"PASSPHRASE_RECOVERY", "fr_ca", "Récupération (Canada)"},
+		{"PASSPHRASE_RECOVERY", "fr-BE", "Récupération"},
+		{"PASSPHRASE_RECOVERY", "fr", "Récupération"},
+		{"WELCOME", "fr-CA", "Bienvenue"},
+		{"EMAIL_VERIFY", "fr-CA", defaultVerify},
+		{"PASSPHRASE_RECOVERY", "de-AT", defaultRecovery},
+		{"PASSPHRASE_RECOVERY", "ja", defaultRecovery},
+	} {
+		if subject := subjectOf(test.name, test.locale); subject != test.subject {
+			t.Errorf("expect %s in %s to be %q. got %q", test.name, test.locale, test.subject, subject)
+		}
+	}
+
+	if _, err := ResolveTemplates("NEWSLETTER", "fr-CA"); err == nil || !strings.Contains(err.Error(), "NEWSLETTER") || !strings.Contains(err.Error(), "fr-CA") {
+		t.Errorf("expect a missing template to name the template and the locale. got %v", err)
+	}
+}
+
+func TestDeliverLocalized(t *testing.T) {
+	setLocalizedTemplates(t, map[string]string{
+		"mailer.templates.passrecover.fr.subject": "Récupération",
+		"mailer.templates.passrecover.fr.body":    "Bonjour",
+	})
+	sender := &connector.DummyMailSender{}
+	Sender = sender
+	defer func() { Sender = nil }()
+
+	if outcome := deliver(&Email{context: context.Background(), To: []string{"localized@test.com"}, Template: "PASSPHRASE_RECOVERY", Locale: "fr-CA"}); outcome != DeliverySent {
+		t.Fatalf("expect the email sent. got %s", outcome)
+	}
+	if sender.LastSentMail.Subject != "Récupération" || sender.LastSentMail.Body != "Bonjour" {
+		t.Errorf("expect the fr template for fr-CA. got %q %q", sender.LastSentMail.Subject, sender.LastSentMail.Body)
+	}
+	if outcome := deliver(&Email{context: context.Background(), To: []string{"localized@test.com"}, Template: "NEWSLETTER", Locale: "fr-CA"}); outcome != DeliveryDropped {
+		t.Errorf("expect an email without template in any locale dropped. got %s", outcome)
+	}
+}
+
+func TestLoadLocalizedTemplatesHalfTranslated(t *testing.T) {
+	config.Set("mailer.templates.welcome.de.subject", "Willkommen")
+	defer config.Set("mailer.templates.welcome.de.subject", "")
+	if _, err := LoadLocalizedTemplates("de"); err == nil || !strings.Contains(err.Error(), "mailer.templates.welcome.de.body") {
+		t.Errorf("expect a subject without body refused. got %v", err)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"io/ioutil"
 	"net/url"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
 var (
@@ -21,11 +23,17 @@ var (
 	// KillChannel a bolean channel to detect mailer server shutdown
 	KillChannel chan bool
 
+	// stoppedChannel signals Stop that all workers have drained the queue
+	stoppedChannel chan bool
+
 	// Sender the connector used in this mailer
 	Sender connector.EmailSender
 
 	// Templates maps list of email template to use
 	Templates map[string]*EmailTemplates
+
+	// Limiter throttles emails sent to the same recipient
+	Limiter *RateLimiter
 )
 
 // Email contains data structure of a new email
@@ -37,7 +45,11 @@ type Email struct {
 	Cc       []string
 	Bcc      []string
 	Template string
-	Data     interface{}
+	// Locale of the recipient, eg. fr-CA, the template is translated to it or to its base language if available. Optional
+	Locale string
+	Data   interface{}
+	// Delivered is called with the outcome, DeliverySent, DeliveryFailed or DeliveryDropped, once the email leaves the queue. Optional
+	Delivered func(outcome string)
 }
 
 // TemplateLoader will load from specified resourceURI.
@@ -71,9 +83,15 @@ func parseTemplate(name, text string) *template.Template {
 }
 
 func init() {
-	MailerChannel = make(chan *Email)
+	queueSize := config.GetInt("mailer.queue.size")
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	MailerChannel = make(chan *Email, queueSize)
 	KillChannel = make(chan bool)
+	stoppedChannel = make(chan bool)
 	Templates = make(map[string]*EmailTemplates)
+	Limiter = NewRateLimiter(config.GetInt("mailer.ratelimit.perhour"), time.Hour)
 
 	emailVeriSubTempl, err := TemplateLoader(config.Get("mailer.templates.emailveri.subject"))
 	if err != nil {
@@ -94,6 +112,46 @@ func init() {
 		panic(err.Error())
 	}
 
+	welcomeSubTempl, err := TemplateLoader(config.Get("mailer.templates.welcome.subject"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	welcomeBodTempl, err := TemplateLoader(config.Get("mailer.templates.welcome.body"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	emailChangeSubTempl, err := TemplateLoader(config.Get("mailer.templates.emailchange.subject"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	emailChangeBodTempl, err := TemplateLoader(config.Get("mailer.templates.emailchange.body"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	emailChangeNoticeSubTempl, err := TemplateLoader(config.Get("mailer.templates.emailchangenotice.subject"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	emailChangeNoticeBodTempl, err := TemplateLoader(config.Get("mailer.templates.emailchangenotice.body"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	announcementSubTempl, err := TemplateLoader(config.Get("mailer.templates.announcement.subject"))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	announcementBodTempl, err := TemplateLoader(config.Get("mailer.templates.announcement.body"))
+	if err != nil {
+		panic(err.Error())
+	}
+
 	Templates["EMAIL_VERIFY"] = &EmailTemplates{
 		SubjectTemplate: parseTemplate("verifySubject", emailVeriSubTempl),
 		BodyTemplate:    parseTemplate("verifyBody", emailVeriBodTempl),
@@ -102,57 +160,127 @@ func init() {
 		SubjectTemplate: parseTemplate("passRecoverSubject", emailPassRecSubTempl),
 		BodyTemplate:    parseTemplate("passRecoverBody", emailPassRecBodTempl),
 	}
+	Templates["WELCOME"] = &EmailTemplates{
+		SubjectTemplate: parseTemplate("welcomeSubject", welcomeSubTempl),
+		BodyTemplate:    parseTemplate("welcomeBody", welcomeBodTempl),
+	}
+	Templates["EMAIL_CHANGE"] = &EmailTemplates{
+		SubjectTemplate: parseTemplate("emailChangeSubject", emailChangeSubTempl),
+		BodyTemplate:    parseTemplate("emailChangeBody", emailChangeBodTempl),
+	}
+	Templates["EMAIL_CHANGE_NOTICE"] = &EmailTemplates{
+		SubjectTemplate: parseTemplate("emailChangeNoticeSubject", emailChangeNoticeSubTempl),
+		BodyTemplate:    parseTemplate("emailChangeNoticeBody", emailChangeNoticeBodTempl),
+	}
+	Templates["ANNOUNCEMENT"] = &EmailTemplates{
+		SubjectTemplate: parseTemplate("announcementSubject", announcementSubTempl),
+		BodyTemplate:    parseTemplate("announcementBody", announcementBodTempl),
+	}
 
+	LocalizedTemplates, err = LoadLocalizedTemplates(config.Get("mailer.locales"))
+	if err != nil {
+		panic(err.Error())
+	}
 }
 
-// Start will start this mailer server
+// Start will start this mailer server.
+// It runs "mailer.workers" workers that send the emails from the queue concurrently.
 func Start() {
-	mailerLogger.Info("Mailer starting")
-	running := true
-	for running {
+	workers := config.GetInt("mailer.workers")
+	if workers < 1 {
+		workers = 1
+	}
+	mailerLogger.Infof("Mailer starting with %d workers", workers)
+	quit := make(chan bool)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go work(quit, wg)
+	}
+	for stop := false; !stop; {
+		stop = <-KillChannel
+	}
+	close(quit)
+	wg.Wait()
+	mailerLogger.Info("Mailer stopped")
+	stoppedChannel <- true
+}
+
+// work sends emails from the queue until quit is closed, then sends the remaining queued emails and returns.
+func work(quit chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
 		select {
 		case mail := <-MailerChannel:
-			fLog := mailerLogger.WithField("RequestID", mail.context.Value(constants.RequestID))
-			if Sender == nil {
-				fLog.Errorf("not sent because mail Sender is nil")
-			} else {
-				if templates, ok := Templates[mail.Template]; ok {
-					subjectWriter := &strings.Builder{}
-					err := templates.SubjectTemplate.Execute(subjectWriter, mail.Data)
-					if err != nil {
-						fLog.Errorf("templates.SubjectTemplate.Execute got %s", err.Error())
-					}
-					bodyWriter := &strings.Builder{}
-					err = templates.BodyTemplate.Execute(bodyWriter, mail.Data)
-					if err != nil {
-						fLog.Errorf("templates.BodyTemplate.Execute got %s", err.Error())
-					}
-					err = Sender.SendEmail(mail.context, mail.To, mail.Cc, mail.Bcc, mail.From, mail.FromName, subjectWriter.String(), bodyWriter.String())
-					if err != nil {
-						fLog.Errorf("Sender.SendEmail got %s", err.Error())
-					}
-					fLog.Tracef("email sent to %s", mail.To)
-				} else {
-					fLog.Errorf("not sent because mail template not recognized %s", mail.Template)
+			process(mail)
+		case <-quit:
+			for {
+				select {
+				case mail := <-MailerChannel:
+					process(mail)
+				default:
+					return
 				}
 			}
-		case stop := <-KillChannel:
-			if stop {
-				running = false
-				break
-			}
 		}
 	}
-	mailerLogger.Info("Mailer stopped")
 }
 
-// Send will add an email to the channel for sending.
-func Send(context context.Context, mail *Email) {
-	mail.context = context
+// deliver sends the email and returns its outcome, DeliverySent, DeliveryFailed or DeliveryDropped.
+func deliver(mail *Email) string {
+	fLog := mailerLogger.WithField("RequestID", mail.context.Value(constants.RequestID))
+	if Sender == nil {
+		fLog.Errorf("not sent because mail Sender is nil")
+		return DeliveryDropped
+	}
+	to := Limiter.Filter(mail.To)
+	if len(mail.To) > 0 && len(to) == 0 {
+		fLog.Warnf("not sent because recipient %s exceeds %d emails per hour", mail.To, Limiter.Limit)
+		return DeliveryDropped
+	}
+	if len(to) < len(mail.To) {
+		fLog.Warnf("some recipient of %s exceeds %d emails per hour and are skipped", mail.To, Limiter.Limit)
+	}
+	mail.To = to
+	templates, err := ResolveTemplates(mail.Template, mail.Locale)
+	if err != nil {
+		fLog.Errorf("not sent because %s", err.Error())
+		return DeliveryDropped
+	}
+	subjectWriter := &strings.Builder{}
+	err = templates.SubjectTemplate.Execute(subjectWriter, mail.Data)
+	if err != nil {
+		fLog.Errorf("templates.SubjectTemplate.Execute got %s", err.Error())
+	}
+	bodyWriter := &strings.Builder{}
+	err = templates.BodyTemplate.Execute(bodyWriter, mail.Data)
+	if err != nil {
+		fLog.Errorf("templates.BodyTemplate.Execute got %s", err.Error())
+	}
+	subject := subjectWriter.String()
+	if prefix := config.Get("mailer.subject.prefix"); len(prefix) > 0 {
+		subject = prefix + " " + subject
+	}
+	err = Sender.SendEmail(mail.context, mail.To, mail.Cc, mail.Bcc, mail.From, mail.FromName, subject, bodyWriter.String())
+	if err != nil {
+		fLog.Errorf("Sender.SendEmail got %s", err.Error())
+		return DeliveryFailed
+	}
+	fLog.Tracef("email sent to %s", mail.To)
+	return DeliverySent
+}
+
+// Send will add an email to the queue for sending.
+// It blocks when the queue is full until one of the workers is free.
+// The email keeps the values of the context, eg. the request id, but not its cancellation,
+// the request that queued it is usually done by the time a worker sends it.
+func Send(ctx context.Context, mail *Email) {
+	mail.context = context.WithoutCancel(ctx)
 	MailerChannel <- mail
 }
 
-// Stop stop the channel
+// Stop stop the mailer and wait until all the queued emails are sent.
 func Stop() {
 	KillChannel <- true
+	<-stoppedChannel
 }
@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/metrics"
+)
+
+const (
+	// DeliverySent the email is handed to the mail provider
+	DeliverySent = "sent"
+	// DeliveryFailed the mail provider refused the email or could not be reached. Failed emails are not retried
+	DeliveryFailed = "failed"
+	// DeliveryDropped the email is not sent, eg. its recipient exceeds the rate limit or it has no template
+	DeliveryDropped = "dropped"
+)
+
+var (
+	mailerEmails = metrics.DefaultRegistry.NewCounterVec("hansip_mailer_emails_total", "Number of emails taken from the mailer queue, by outcome.", "result")
+
+	// inFlight counts the emails taken from the queue and still being sent by the workers
+	inFlight int64
+
+	// recentDeliveries counts the emails leaving the queue in the last minute
+	recentDeliveries = &deliveryRate{}
+)
+
+func init() {
+	metrics.DefaultRegistry.NewGaugeFunc("hansip_mailer_queue_depth", "Number of emails queued and not yet taken by a worker.", func() float64 {
+		return float64(len(MailerChannel))
+	})
+	metrics.DefaultRegistry.NewGaugeFunc("hansip_mailer_queue_capacity", "Number of emails the mailer queue holds before Send blocks.", func() float64 {
+		return float64(cap(MailerChannel))
+	})
+	metrics.DefaultRegistry.NewGaugeFunc("hansip_mailer_in_flight", "Number of emails being sent by the workers.", func() float64 {
+		return float64(atomic.LoadInt64(&inFlight))
+	})
+}
+
+// QueueStats is a snapshot of the mailer queue
+type QueueStats struct {
+	// QueueDepth is the number of emails queued and not yet taken by a worker
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	InFlight      int64 `json:"in_flight"`
+	// ProcessedLastMinute is the number of emails that left the queue in the last minute, whatever their outcome
+	ProcessedLastMinute int     `json:"processed_last_minute"`
+	Sent                float64 `json:"sent"`
+	Failed              float64 `json:"failed"`
+	Dropped             float64 `json:"dropped"`
+}
+
+// Stats returns the current depth, throughput and outcome counts of the mailer queue.
+func Stats() *QueueStats {
+	return &QueueStats{
+		QueueDepth:          len(MailerChannel),
+		QueueCapacity:       cap(MailerChannel),
+		InFlight:            atomic.LoadInt64(&inFlight),
+		ProcessedLastMinute: recentDeliveries.count(time.Now()),
+		Sent:                mailerEmails.Value(DeliverySent),
+		Failed:              mailerEmails.Value(DeliveryFailed),
+		Dropped:             mailerEmails.Value(DeliveryDropped),
+	}
+}
+
+// process delivers an email taken from the queue and records its outcome.
+func process(mail *Email) {
+	atomic.AddInt64(&inFlight, 1)
+	defer atomic.AddInt64(&inFlight, -1)
+	outcome := deliver(mail)
+	mailerEmails.Inc(outcome)
+	recentDeliveries.add(time.Now())
+	if mail.Delivered != nil {
+		mail.Delivered(outcome)
+	}
+}
+
+// deliveryRate counts events within a sliding minute, in one second buckets.
+type deliveryRate struct {
+	mutex   sync.Mutex
+	seconds [60]int64
+	counts  [60]int
+}
+
+func (rate *deliveryRate) add(now time.Time) {
+	second := now.Unix()
+	bucket := second % 60
+	rate.mutex.Lock()
+	defer rate.mutex.Unlock()
+	if rate.seconds[bucket] != second {
+		rate.seconds[bucket] = second
+		rate.counts[bucket] = 0
+	}
+	rate.counts[bucket]++
+}
+
+func (rate *deliveryRate) count(now time.Time) int {
+	second := now.Unix()
+	rate.mutex.Lock()
+	defer rate.mutex.Unlock()
+	total := 0
+	for i, bucketSecond := range rate.seconds {
+		if second-bucketSecond < 60 {
+			total += rate.counts[i]
+		}
+	}
+	return total
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/metrics"
+)
+
+func TestQueueDepth(t *testing.T) {
+	Sender = &connector.DummyMailSender{}
+	defer func() { Sender = nil }()
+	sentBefore := Stats().Sent
+
+	// no worker is running, the emails stay queued
+	for i := 0; i < 3; i++ {
+		Send(context.Background(), &Email{To: []string{"user@test.com"}, Template: "PASSPHRASE_RECOVERY"})
+	}
+	if stats := Stats(); stats.QueueDepth != 3 || stats.QueueCapacity != cap(MailerChannel) || stats.InFlight != 0 {
+		t.Errorf("expect 3 queued emails. got %+v", stats)
+	}
+	buff := &bytes.Buffer{}
+	metrics.DefaultRegistry.WritePrometheus(buff)
+	if !strings.Contains(buff.String(), "\nhansip_mailer_queue_depth 3\n") {
+		t.Errorf("expect the queue depth metric at 3. got\n%s", buff.String())
+	}
+
+	go Start()
+	Stop()
+	stats := Stats()
+	if stats.QueueDepth != 0 || stats.Sent != sentBefore+3 || stats.ProcessedLastMinute < 3 {
+		t.Errorf("expect the 3 emails sent and the queue empty. got %+v", stats)
+	}
+}
+
+func TestDeliveryRate(t *testing.T) {
+	rate := &deliveryRate{}
+	now := time.Unix(1000, 0)
+	rate.add(now.Add(-90 * time.Second))
+	rate.add(now.Add(-30 * time.Second))
+	rate.add(now)
+	rate.add(now)
+	if count := rate.count(now); count != 3 {
+		t.Errorf("expect 3 deliveries in the last minute. got %d", count)
+	}
+	if count := rate.count(now.Add(time.Minute)); count != 0 {
+		t.Errorf("expect no delivery a minute later. got %d", count)
+	}
+}
@@ -0,0 +1,119 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/constants"
+)
+
+type slowSender struct {
+	mutex sync.Mutex
+	delay time.Duration
+	sent  map[string]bool
+}
+
+func (sender *slowSender) SendEmail(ctx context.Context, to, cc, bcc []string, from, fromName, subject, body string) error {
+	time.Sleep(sender.delay)
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	for _, recipient := range to {
+		sender.sent[recipient] = true
+	}
+	return nil
+}
+
+// sendBurst sends count emails through a mailer running the given number of workers
+// and returns how long it took until the mailer stopped.
+func sendBurst(t *testing.T, workers, count int) time.Duration {
+	sender := &slowSender{delay: 20 * time.Millisecond, sent: make(map[string]bool)}
+	Sender = sender
+	config.Set("mailer.workers", fmt.Sprintf("%d", workers))
+	defer func() {
+		Sender = nil
+		config.Set("mailer.workers", "1")
+	}()
+
+	startTime := time.Now()
+	go Start()
+	for i := 0; i < count; i++ {
+		Send(context.Background(), &Email{To: []string{fmt.Sprintf("user%d@test.com", i)}, Template: "PASSPHRASE_RECOVERY"})
+	}
+	Stop()
+	elapsed := time.Since(startTime)
+
+	// workers may send in any order, only check every email got sent.
+	if len(sender.sent) != count {
+		t.Errorf("expect %d emails sent by %d workers. got %d", count, workers, len(sender.sent))
+	}
+	for i := 0; i < count; i++ {
+		if !sender.sent[fmt.Sprintf("user%d@test.com", i)] {
+			t.Errorf("email to user%d@test.com is not sent by %d workers", i, workers)
+		}
+	}
+	return elapsed
+}
+
+func TestMailerWorkers(t *testing.T) {
+	single := sendBurst(t, 1, 12)
+	parallel := sendBurst(t, 4, 12)
+	if parallel*2 > single {
+		t.Errorf("4 workers should send the burst at least twice as fast as 1 worker. got %s and %s", parallel, single)
+	}
+}
+
+func TestSubjectPrefix(t *testing.T) {
+	sender := &connector.DummyMailSender{}
+	Sender = sender
+	defer func() {
+		Sender = nil
+		config.Set("mailer.subject.prefix", "")
+	}()
+
+	deliver(&Email{context: context.Background(), To: []string{"user@test.com"}, Template: "PASSPHRASE_RECOVERY"})
+	unprefixed := sender.LastSentMail.Subject
+
+	config.Set("mailer.subject.prefix", "[Acme]")
+	deliver(&Email{context: context.Background(), To: []string{"user@test.com"}, Template: "PASSPHRASE_RECOVERY"})
+	if sender.LastSentMail.Subject != "[Acme] "+unprefixed {
+		t.Errorf("expect subject \"[Acme] %s\". got %q", unprefixed, sender.LastSentMail.Subject)
+	}
+}
+
+// contextSender records the context error and request id seen when sending.
+type contextSender struct {
+	err       error
+	requestID interface{}
+}
+
+func (sender *contextSender) SendEmail(ctx context.Context, to, cc, bcc []string, from, fromName, subject, body string) error {
+	sender.err = ctx.Err()
+	sender.requestID = ctx.Value(constants.RequestID)
+	return ctx.Err()
+}
+
+func TestSendAfterRequestCanceled(t *testing.T) {
+	sender := &contextSender{}
+	Sender = sender
+	defer func() {
+		Sender = nil
+	}()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), constants.RequestID, "req-1"))
+	Send(ctx, &Email{To: []string{"user@test.com"}, Template: "PASSPHRASE_RECOVERY"})
+	// the request is done before a worker takes the email
+	cancel()
+	go Start()
+	Stop()
+	if sender.err != nil {
+		t.Errorf("expect the email sent after the request is canceled. got %s", sender.err.Error())
+	}
+	if sender.requestID != "req-1" {
+		t.Errorf("expect the request id kept. got %v", sender.requestID)
+	}
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewRateLimiter create new instance of RateLimiter.
+// A limit of 0 or less means unlimited.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:  limit,
+		Window: window,
+		sent:   make(map[string][]time.Time),
+	}
+}
+
+// RateLimiter limits the number of email sent to the same recipient within a time window.
+type RateLimiter struct {
+	mutex     sync.Mutex
+	Limit     int
+	Window    time.Duration
+	sent      map[string][]time.Time
+	lastPurge time.Time
+}
+
+// recent returns the times of the emails sent to the recipient key within the window, the older ones are forgotten.
+// The recipient without any email within the window is removed. The caller must hold the mutex.
+func (rl *RateLimiter) recent(key string, now time.Time) []time.Time {
+	rl.purge(now)
+	recent := make([]time.Time, 0, len(rl.sent[key]))
+	for _, sentAt := range rl.sent[key] {
+		if now.Sub(sentAt) < rl.Window {
+			recent = append(recent, sentAt)
+		}
+	}
+	if len(recent) == 0 {
+		delete(rl.sent, key)
+	} else {
+		rl.sent[key] = recent
+	}
+	return recent
+}
+
+// purge removes, at most once per window, the recipients whose last email is older than the window,
+// so the recipients that never come back do not pile up. The caller must hold the mutex.
+func (rl *RateLimiter) purge(now time.Time) {
+	if now.Sub(rl.lastPurge) < rl.Window {
+		return
+	}
+	rl.lastPurge = now
+	for key, sentAt := range rl.sent {
+		if len(sentAt) == 0 || now.Sub(sentAt[len(sentAt)-1]) >= rl.Window {
+			delete(rl.sent, key)
+		}
+	}
+}
+
+// Allow check whether another email may be sent to the recipient now, and if so, count it against the limit.
+func (rl *RateLimiter) Allow(recipient string) bool {
+	if rl.Limit <= 0 {
+		return true
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	key := strings.ToLower(strings.TrimSpace(recipient))
+	now := time.Now()
+	recent := rl.recent(key, now)
+	if len(recent) >= rl.Limit {
+		return false
+	}
+	rl.sent[key] = append(recent, now)
+	return true
+}
+
+// Exceeded check whether the recipient already received as many emails as the limit within the window,
+// so another email would be throttled. Unlike Allow, it does not count against the limit.
+func (rl *RateLimiter) Exceeded(recipient string) bool {
+	if rl.Limit <= 0 {
+		return false
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return len(rl.recent(strings.ToLower(strings.TrimSpace(recipient)), time.Now())) >= rl.Limit
+}
+
+// Filter returns the recipients that are still allowed to receive an email.
+func (rl *RateLimiter) Filter(recipients []string) []string {
+	ret := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		if rl.Allow(recipient) {
+			ret = append(ret, recipient)
+		}
+	}
+	return ret
+}
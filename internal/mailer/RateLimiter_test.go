@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type countingSender struct {
+	sent map[string]int
+}
+
+func (sender *countingSender) SendEmail(ctx context.Context, to, cc, bcc []string, from, fromName, subject, body string) error {
+	for _, recipient := range to {
+		sender.sent[recipient]++
+	}
+	return nil
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Hour)
+	if !limiter.Allow("user@test.com") || !limiter.Allow("USER@test.com ") {
+		t.Errorf("first 2 emails should be allowed")
+	}
+	if limiter.Allow("user@test.com") {
+		t.Errorf("third email within the window should be throttled")
+	}
+	if !limiter.Allow("other@test.com") {
+		t.Errorf("other recipient should not be throttled")
+	}
+	if !limiter.Exceeded("user@test.com") || limiter.Exceeded("other@test.com") {
+		t.Errorf("only the throttled recipient should be exceeded")
+	}
+	if !limiter.Allow("other@test.com") || !limiter.Exceeded("other@test.com") {
+		t.Errorf("checking the limit should not count against it")
+	}
+
+	limiter = NewRateLimiter(1, 10*time.Millisecond)
+	limiter.Allow("user@test.com")
+	time.Sleep(20 * time.Millisecond)
+	if !limiter.Allow("user@test.com") {
+		t.Errorf("email after the window should be allowed")
+	}
+
+	unlimited := NewRateLimiter(0, time.Hour)
+	for i := 0; i < 10; i++ {
+		if !unlimited.Allow("user@test.com") {
+			t.Fatalf("limit 0 should never throttle")
+		}
+	}
+}
+
+func TestRateLimiterForgetsRecipients(t *testing.T) {
+	limiter := NewRateLimiter(1, 10*time.Millisecond)
+	limiter.Allow("user@test.com")
+	limiter.Allow("gone@test.com")
+	time.Sleep(20 * time.Millisecond)
+	if limiter.Exceeded("user@test.com") {
+		t.Errorf("recipient should not be exceeded after the window")
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if len(limiter.sent) != 0 {
+		t.Errorf("recipients without email within the window should be removed. got %v", limiter.sent)
+	}
+}
+
+func TestMailerThrottlesRecipient(t *testing.T) {
+	sender := &countingSender{sent: make(map[string]int)}
+	Sender = sender
+	Limiter = NewRateLimiter(3, time.Hour)
+	defer func() {
+		Sender = nil
+		Limiter = NewRateLimiter(0, time.Hour)
+	}()
+
+	go Start()
+	for i := 0; i < 5; i++ {
+		Send(context.Background(), &Email{To: []string{"user@test.com"}, Template: "PASSPHRASE_RECOVERY"})
+	}
+	Send(context.Background(), &Email{To: []string{"other@test.com"}, Template: "PASSPHRASE_RECOVERY"})
+	Stop()
+
+	if sender.sent["user@test.com"] != 3 {
+		t.Errorf("expect 3 emails to the throttled recipient. got %d", sender.sent["user@test.com"])
+	}
+	if sender.sent["other@test.com"] != 1 {
+		t.Errorf("expect 1 email to other recipient. got %d", sender.sent["other@test.com"])
+	}
+}
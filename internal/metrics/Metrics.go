@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultRegistry is the registry served by the metrics end point
+	DefaultRegistry = NewRegistry()
+
+	// DefaultBuckets are the latency histogram buckets, in seconds
+	DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// collector is a metric family that can write itself in the Prometheus text exposition format.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds metric families to be exposed together.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry create new instance of Registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make([]collector, 0)}
+}
+
+func (reg *Registry) register(c collector) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.collectors = append(reg.collectors, c)
+}
+
+// WritePrometheus writes all metric families, ordered by name, in the Prometheus text exposition format.
+func (reg *Registry) WritePrometheus(w io.Writer) {
+	reg.mutex.Lock()
+	collectors := make([]collector, len(reg.collectors))
+	copy(collectors, reg.collectors)
+	reg.mutex.Unlock()
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	Name   string
+	Help   string
+	Labels []string
+
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec create new instance of CounterVec registered in the registry.
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{Name: name, Help: help, Labels: labels, values: make(map[string]float64)}
+	reg.register(counter)
+	return counter
+}
+
+// Inc increments the counter of the label values by one.
+func (counter *CounterVec) Inc(labelValues ...string) {
+	key := labelKey(counter.Labels, labelValues)
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.values[key]++
+}
+
+// Value returns the current value of the counter of the label values.
+func (counter *CounterVec) Value(labelValues ...string) float64 {
+	key := labelKey(counter.Labels, labelValues)
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return counter.values[key]
+}
+
+func (counter *CounterVec) name() string {
+	return counter.Name
+}
+
+func (counter *CounterVec) write(w io.Writer) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", counter.Name, counter.Help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", counter.Name)
+	for _, key := range sortedKeys(counter.values) {
+		fmt.Fprintf(w, "%s%s %s\n", counter.Name, braced(key), formatFloat(counter.values[key]))
+	}
+}
+
+// GaugeFunc is a gauge without labels whose value is read from a function when the metrics are written,
+// eg. the length of a queue.
+type GaugeFunc struct {
+	Name     string
+	Help     string
+	Function func() float64
+}
+
+// NewGaugeFunc create new instance of GaugeFunc registered in the registry.
+func (reg *Registry) NewGaugeFunc(name, help string, function func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{Name: name, Help: help, Function: function}
+	reg.register(gauge)
+	return gauge
+}
+
+func (gauge *GaugeFunc) name() string {
+	return gauge.Name
+}
+
+func (gauge *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", gauge.Name, gauge.Help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", gauge.Name)
+	fmt.Fprintf(w, "%s %s\n", gauge.Name, formatFloat(gauge.Function()))
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Buckets []float64
+
+	mutex      sync.Mutex
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec create new instance of HistogramVec registered in the registry. Buckets are the ascending upper bounds.
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	hist := &HistogramVec{Name: name, Help: help, Labels: labels, Buckets: buckets, histograms: make(map[string]*histogram)}
+	reg.register(hist)
+	return hist
+}
+
+// Observe adds the value to the histogram of the label values.
+func (hist *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(hist.Labels, labelValues)
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	h, ok := hist.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(hist.Buckets))}
+		hist.histograms[key] = h
+	}
+	for i, bound := range hist.Buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of values observed by the histogram of the label values.
+func (hist *HistogramVec) Count(labelValues ...string) uint64 {
+	key := labelKey(hist.Labels, labelValues)
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	if h, ok := hist.histograms[key]; ok {
+		return h.count
+	}
+	return 0
+}
+
+func (hist *HistogramVec) name() string {
+	return hist.Name
+}
+
+func (hist *HistogramVec) write(w io.Writer) {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", hist.Name, hist.Help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", hist.Name)
+	keys := make([]string, 0, len(hist.histograms))
+	for key := range hist.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h := hist.histograms[key]
+		for i, bound := range hist.Buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hist.Name, braced(joinLabels(key, `le="`+formatFloat(bound)+`"`)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hist.Name, braced(joinLabels(key, `le="+Inf"`)), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hist.Name, braced(key), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hist.Name, braced(key), h.count)
+	}
+}
+
+// labelKey renders the label pairs, eg. `db="mysql",operation="read"`. Missing label values are empty.
+func labelKey(labels, values []string) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%q", label, value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(key, pair string) string {
+	if len(key) == 0 {
+		return pair
+	}
+	return key + "," + pair
+}
+
+func braced(key string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	return "{" + key + "}"
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
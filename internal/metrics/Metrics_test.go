@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	reg := NewRegistry()
+	queries := reg.NewCounterVec("test_queries_total", "Queries executed", "operation")
+	latency := reg.NewHistogramVec("test_query_duration_seconds", "Query latency", []float64{0.1, 1}, "operation")
+	queries.Inc("read")
+	queries.Inc("read")
+	queries.Inc("write")
+	latency.Observe(0.05, "read")
+	latency.Observe(0.5, "read")
+
+	if queries.Value("read") != 2 || latency.Count("read") != 2 || latency.Count("write") != 0 {
+		t.Errorf("unexpected values. got %v %v", queries.Value("read"), latency.Count("read"))
+	}
+
+	buff := &bytes.Buffer{}
+	reg.WritePrometheus(buff)
+	expected := `# HELP test_queries_total Queries executed
+# TYPE test_queries_total counter
+test_queries_total{operation="read"} 2
+test_queries_total{operation="write"} 1
+# HELP test_query_duration_seconds Query latency
+# TYPE test_query_duration_seconds histogram
+test_query_duration_seconds_bucket{operation="read",le="0.1"} 1
+test_query_duration_seconds_bucket{operation="read",le="1"} 2
+test_query_duration_seconds_bucket{operation="read",le="+Inf"} 2
+test_query_duration_seconds_sum{operation="read"} 0.55
+test_query_duration_seconds_count{operation="read"} 2
+`
+	if buff.String() != expected {
+		t.Errorf("unexpected exposition. got\n%s", buff.String())
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	reg := NewRegistry()
+	depth := 3
+	reg.NewGaugeFunc("test_queue_depth", "Queued items", func() float64 { return float64(depth) })
+	depth = 5
+
+	buff := &bytes.Buffer{}
+	reg.WritePrometheus(buff)
+	expected := `# HELP test_queue_depth Queued items
+# TYPE test_queue_depth gauge
+test_queue_depth 5
+`
+	if buff.String() != expected {
+		t.Errorf("unexpected exposition. got\n%s", buff.String())
+	}
+}
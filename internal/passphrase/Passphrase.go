@@ -1,25 +1,107 @@
 package passphrase
 
 import (
+	"fmt"
+	"math"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
+const (
+	// RuleMinChars is the rule of the minimum number of characters
+	RuleMinChars = "minchars"
+	// RuleMinWords is the rule of the minimum number of words
+	RuleMinWords = "minwords"
+	// RuleMinCharsInWord is the rule of the minimum number of characters of every word
+	RuleMinCharsInWord = "mincharsinword"
+
+	// MaxStrength is the strength score of the strongest passphrases
+	MaxStrength = 4
+)
+
+// RuleResult is the outcome of a passphrase policy rule
+type RuleResult struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+var whitespaces = regexp.MustCompile(`[ \t\n]+`)
+
+func words(passphrase string) []string {
+	return strings.Split(whitespaces.ReplaceAllString(passphrase, " "), " ")
+}
+
 // Validate parses your passphrase and validates for minimum characters and words
 func Validate(passphrase string, minchars, minwords, mincharsinword int) bool {
-	if len(passphrase) < minchars {
-		return false
-	}
-	regx := regexp.MustCompile(`[ \t\n]+`)
-	reps := regx.ReplaceAllString(passphrase, " ")
-	words := strings.Split(reps, " ")
-	if len(words) < minwords {
-		return false
-	}
-	for _, w := range words {
-		if len(w) < mincharsinword {
+	for _, result := range Check(passphrase, minchars, minwords, mincharsinword) {
+		if !result.Passed {
 			return false
 		}
 	}
 	return true
 }
+
+// Check runs every rule of the passphrase policy against the passphrase and returns the outcome of each
+func Check(passphrase string, minchars, minwords, mincharsinword int) []*RuleResult {
+	wordList := words(passphrase)
+	shortWords := 0
+	for _, w := range wordList {
+		if len(w) < mincharsinword {
+			shortWords++
+		}
+	}
+	return []*RuleResult{
+		{Rule: RuleMinChars, Passed: len(passphrase) >= minchars, Detail: fmt.Sprintf("at least %d characters", minchars)},
+		{Rule: RuleMinWords, Passed: len(wordList) >= minwords, Detail: fmt.Sprintf("at least %d words", minwords)},
+		{Rule: RuleMinCharsInWord, Passed: shortWords == 0, Detail: fmt.Sprintf("at least %d characters in every word", mincharsinword)},
+	}
+}
+
+// Strength scores the passphrase from 0, the weakest, to MaxStrength. The score estimates the passphrase entropy
+// from its length and the kinds of characters it uses, it does not know dictionary words.
+func Strength(passphrase string) int {
+	var lower, upper, digit, other bool
+	for _, c := range passphrase {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if other {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+	entropy := float64(len([]rune(passphrase))) * math.Log2(float64(pool))
+	switch {
+	case entropy < 28:
+		return 0
+	case entropy < 36:
+		return 1
+	case entropy < 60:
+		return 2
+	case entropy < 128:
+		return 3
+	default:
+		return MaxStrength
+	}
+}
@@ -30,3 +30,32 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestCheck(t *testing.T) {
+	results := Check("short pass is good", 3, 2, 4)
+	passed := make(map[string]bool)
+	for _, result := range results {
+		passed[result.Rule] = result.Passed
+	}
+	if len(results) != 3 || !passed[RuleMinChars] || !passed[RuleMinWords] || passed[RuleMinCharsInWord] {
+		t.Errorf("expect only %s to fail. got %v", RuleMinCharsInWord, passed)
+	}
+}
+
+func TestStrength(t *testing.T) {
+	testData := []struct {
+		Pass     string
+		Strength int
+	}{
+		{"", 0},
+		{"abc", 0},
+		{"abcdefgh", 2},
+		{"correct horse battery", 3},
+		{"Correct-Horse-Battery-Staple-1234567890-Tr0ub4dor", MaxStrength},
+	}
+	for _, td := range testData {
+		if strength := Strength(td.Pass); strength != td.Strength {
+			t.Errorf("expect %q strength %d. got %d", td.Pass, td.Strength, strength)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/endpoint"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	auditRetentionLog = log.WithField("go", "AuditRetention")
+)
+
+// archiveAudit writes the audit events that occurred before the cutoff into a new NDJSON file in the directory.
+// A partially written file is removed.
+func archiveAudit(ctx context.Context, dir string, cutoff time.Time) (string, int, error) {
+	path := filepath.Join(dir, fmt.Sprintf("hansip-audit-%s.ndjson", cutoff.UTC().Format("20060102T150405Z")))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return path, 0, err
+	}
+	count, err := endpoint.WriteAuditNDJSON(ctx, file, cutoff)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return path, 0, err
+	}
+	return path, count, nil
+}
+
+// PurgeAudit deletes the audit events older than "audit.retention", nothing if it is not set. When "audit.retention.archive"
+// is set, the events are first archived into an NDJSON file in that directory and nothing is deleted if archiving fails.
+func PurgeAudit(ctx context.Context, now time.Time) error {
+	fLog := auditRetentionLog.WithField("func", "PurgeAudit")
+	if len(config.Get("audit.retention")) == 0 {
+		return nil
+	}
+	retention, err := configDuration("audit.retention")
+	if err != nil {
+		return err
+	}
+	if endpoint.AuditRepo == nil {
+		return fmt.Errorf("no audit repository")
+	}
+	cutoff := now.Add(-retention)
+	if dir := config.Get("audit.retention.archive"); len(dir) > 0 {
+		path, count, err := archiveAudit(ctx, dir, cutoff)
+		if err != nil {
+			return fmt.Errorf("archiving audit to %s got %s. audit is not purged", path, err.Error())
+		}
+		fLog.Infof("archived %d audit events before %s to %s", count, cutoff.Format(time.RFC3339), path)
+	}
+	purged, err := endpoint.AuditRepo.PurgeAuditsBefore(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	fLog.Infof("purged %d audit events before %s", purged, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+// startAuditRetention purges the audit events older than the retention every interval.
+func startAuditRetention(interval time.Duration, stop <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := PurgeAudit(context.Background(), now); err != nil {
+				auditRetentionLog.WithField("func", "startAuditRetention").Errorf("purging audit got %s", err.Error())
+			}
+		}
+	}
+}
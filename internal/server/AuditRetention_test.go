@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/endpoint"
+)
+
+type fakeAuditRepo struct {
+	connector.AuditRepository
+	audits []*connector.Audit
+}
+
+func (repo *fakeAuditRepo) EachAuditBefore(ctx context.Context, before time.Time, each func(audit *connector.Audit) error) error {
+	for _, audit := range repo.audits {
+		if audit.EventTime.Before(before) {
+			if err := each(audit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (repo *fakeAuditRepo) PurgeAuditsBefore(ctx context.Context, before time.Time) (int64, error) {
+	kept := make([]*connector.Audit, 0)
+	for _, audit := range repo.audits {
+		if !audit.EventTime.Before(before) {
+			kept = append(kept, audit)
+		}
+	}
+	purged := int64(len(repo.audits) - len(kept))
+	repo.audits = kept
+	return purged, nil
+}
+
+func TestPurgeAudit(t *testing.T) {
+	now := time.Now()
+	repo := &fakeAuditRepo{audits: []*connector.Audit{
+		{RecID: "old", EventTime: now.Add(-48 * time.Hour), EventType: "IMPERSONATE"},
+		{RecID: "recent", EventTime: now.Add(-time.Hour), EventType: "EMAIL_CHANGED"},
+	}}
+	previous := endpoint.AuditRepo
+	endpoint.AuditRepo = repo
+	defer func() { endpoint.AuditRepo = previous }()
+
+	// without retention nothing is purged
+	if err := PurgeAudit(context.Background(), now); err != nil || len(repo.audits) != 2 {
+		t.Fatalf("expect no purge without audit.retention. got %d audits, %v", len(repo.audits), err)
+	}
+
+	dir := t.TempDir()
+	config.Set("audit.retention", "1 day")
+	config.Set("audit.retention.archive", dir)
+	defer config.Set("audit.retention", "")
+	defer config.Set("audit.retention.archive", "")
+	if err := PurgeAudit(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.audits) != 1 || repo.audits[0].RecID != "recent" {
+		t.Errorf("expect only the recent audit retained. got %v", repo.audits)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "hansip-audit-*.ndjson"))
+	if len(files) != 1 {
+		t.Fatalf("expect one archive file. got %v", files)
+	}
+	archived, _ := ioutil.ReadFile(files[0])
+	if lines := strings.Split(strings.TrimSpace(string(archived)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"rec_id":"old"`) {
+		t.Errorf("expect the old audit archived. got %s", archived)
+	}
+
+	// the archive is never overwritten, the audit is kept when archiving fails
+	repo.audits = append(repo.audits, &connector.Audit{RecID: "older", EventTime: now.Add(-72 * time.Hour)})
+	if err := PurgeAudit(context.Background(), now); err == nil || len(repo.audits) != 2 {
+		t.Errorf("expect the purge to stop when the archive exists. got %d audits, %v", len(repo.audits), err)
+	}
+}
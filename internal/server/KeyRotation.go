@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// tokenLifetimes are the configuration keys of the token lifetimes a retired signing key must outlive
+	tokenLifetimes = []string{
+		"token.access.duration",
+		"token.refresh.duration",
+		"token.refresh.remember.duration",
+		"token.refresh.session.duration",
+		"token.impersonate.duration",
+		"auth.2fa.enrollment.duration",
+		"token.crypt.rotation.overlap",
+	}
+)
+
+// loadKeySet returns the keyset of the "token.crypt.keyset" file, nil if it is not configured or not yet created by rotate-key.
+func loadKeySet() (*helper.KeySet, error) {
+	path := config.Get("token.crypt.keyset")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	keySet, err := helper.LoadKeySet(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return keySet, err
+}
+
+// maxTokenLifetime returns the longest lifetime a token may have, including the not before offset and the clock skew leeway.
+func maxTokenLifetime() (time.Duration, error) {
+	var longest time.Duration
+	for _, key := range tokenLifetimes {
+		if len(config.Get(key)) == 0 {
+			continue
+		}
+		duration, err := configDuration(key)
+		if err != nil {
+			return 0, err
+		}
+		if duration > longest {
+			longest = duration
+		}
+	}
+	for _, key := range []string{"token.notbefore.offset", "token.clockskew.leeway"} {
+		duration, err := configDuration(key)
+		if err != nil {
+			return 0, err
+		}
+		longest += duration
+	}
+	return longest, nil
+}
+
+// RotateKey generates a new token signing key and makes it the current key of the "token.crypt.keyset" file.
+// The previous key keeps verifying the outstanding tokens for the longest token lifetime, then it is removed
+// by a later rotation. The first rotation creates the keyset, keeping "token.crypt.key" as the previous key.
+func RotateKey(out io.Writer, now time.Time) error {
+	path := config.Get("token.crypt.keyset")
+	if len(path) == 0 {
+		return fmt.Errorf("token.crypt.keyset is not configured")
+	}
+	overlap, err := maxTokenLifetime()
+	if err != nil {
+		return err
+	}
+	keySet, err := loadKeySet()
+	if err != nil {
+		return err
+	}
+	if keySet == nil {
+		fmt.Fprintf(out, "Creating keyset %s from token.crypt.key\n", path)
+		keySet = helper.NewKeySet(config.Get("token.crypt.key"))
+	}
+	previous := keySet.Current
+	key, err := helper.GenerateSigningKey(now)
+	if err != nil {
+		return err
+	}
+	keySet.Rotate(key, overlap, now)
+	if err := keySet.Save(path); err != nil {
+		return fmt.Errorf("can not save keyset %s. got %s", path, err.Error())
+	}
+	fmt.Fprintf(out, "Signing key %s is current. Key %s verifies tokens until %s\n", key.ID, previous, now.Add(overlap).Format(time.RFC3339))
+	return nil
+}
+
+// startKeySetReload reloads the keyset file every interval, so keys rotated in are picked up without a restart.
+func startKeySetReload(tokenFactory *helper.DefaultTokenFactory, interval time.Duration, stop <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := tokenFactory.ReloadKeySet(); err != nil && !os.IsNotExist(err) {
+				log.Errorf("reloading token keyset got %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+)
+
+func TestRotateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyset.json")
+	config.Set("token.crypt.keyset", path)
+	defer config.Set("token.crypt.keyset", "")
+
+	// two instances share the keyset file, only the one rotating the key reloads it right away
+	rotating := GetJwtTokenFactory().(*helper.DefaultTokenFactory)
+	other := GetJwtTokenFactory().(*helper.DefaultTokenFactory)
+	oldAccess, oldRefresh, err := rotating.CreateTokenPair("user@acme.com", []string{"user@acme"}, nil, helper.TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := RotateKey(out, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Key default verifies tokens until") {
+		t.Errorf("expect the previous key to verify tokens. got %s", out.String())
+	}
+	if err := rotating.ReloadKeySet(); err != nil {
+		t.Fatal(err)
+	}
+	newAccess, _, err := rotating.CreateTokenPair("user@acme.com", []string{"user@acme"}, nil, helper.TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := helper.JWTKeyID(newAccess); kid != rotating.KeySet.Current || kid == helper.DefaultKeyID {
+		t.Errorf("new token should be signed by the new key. got kid %q", kid)
+	}
+
+	for _, tf := range []*helper.DefaultTokenFactory{rotating, other} {
+		for _, tok := range []string{oldAccess, newAccess} {
+			if _, err := tf.ReadToken(tok); err != nil {
+				t.Errorf("old and new tokens should validate during the overlap. got %s", err.Error())
+			}
+		}
+	}
+	refreshed, err := rotating.RefreshToken(oldRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := helper.JWTKeyID(refreshed); kid != rotating.KeySet.Current {
+		t.Errorf("refreshed token should be signed by the new key. got kid %q", kid)
+	}
+
+	// a rotation long ago, its overlap is over
+	if err := RotateKey(out, time.Now().Add(-2*365*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotating.ReloadKeySet(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotating.ReadToken(newAccess); err == nil {
+		t.Errorf("token of a key past its overlap should not validate")
+	}
+}
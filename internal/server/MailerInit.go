@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// MailerFailFatal aborts the startup when the mailer can not be initialized
+	MailerFailFatal = "fatal"
+	// MailerFailDegrade starts with a dummy mailer that sends nothing when the mailer can not be initialized
+	MailerFailDegrade = "degrade"
+
+	// mailerDialTimeout is how long the SMTP host is given to accept a connection at startup
+	mailerDialTimeout = 5 * time.Second
+)
+
+// checkMailerFailMode validates "mailer.failmode"
+func checkMailerFailMode() error {
+	switch config.Get("mailer.failmode") {
+	case MailerFailFatal, MailerFailDegrade:
+		return nil
+	default:
+		return fmt.Errorf("mailer.failmode %q is not one of %s or %s", config.Get("mailer.failmode"), MailerFailFatal, MailerFailDegrade)
+	}
+}
+
+// newEmailSender creates the email sender of "mailer.type", checking its credentials are configured
+// and, for SENDMAIL, that the SMTP host accepts connections.
+func newEmailSender() (connector.EmailSender, error) {
+	replyTo := config.Get("mailer.replyto")
+	if len(replyTo) > 0 {
+		if _, err := mail.ParseAddress(replyTo); err != nil {
+			return nil, fmt.Errorf("mailer.replyto %q is not an email address", replyTo)
+		}
+	}
+	switch config.Get("mailer.type") {
+	case "DUMMY":
+		return &connector.DummyMailSender{ReplyTo: replyTo}, nil
+	case "SENDMAIL":
+		host := config.Get("mailer.sendmail.host")
+		if len(host) == 0 {
+			return nil, fmt.Errorf("mailer.sendmail.host is empty")
+		}
+		port, err := strconv.Atoi(config.Get("mailer.sendmail.port"))
+		if err != nil {
+			return nil, fmt.Errorf("mailer.sendmail.port %q is not a number", config.Get("mailer.sendmail.port"))
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+		conn, err := net.DialTimeout("tcp", address, mailerDialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("mailer.sendmail.host %s is not reachable. got %s", address, err.Error())
+		}
+		conn.Close()
+		return &connector.SendMailSender{
+			Host:      host,
+			Port:      port,
+			User:      config.Get("mailer.sendmail.user"),
+			Password:  config.Get("mailer.sendmail.password"),
+			ReplyTo:   replyTo,
+			KeepAlive: config.GetBoolean("mailer.sendmail.keepalive"),
+		}, nil
+	case "SENDGRID":
+		if len(config.Get("mailer.sendgrid.token")) == 0 {
+			return nil, fmt.Errorf("mailer.sendgrid.token is empty")
+		}
+		timeout := mustConfigDuration("mailer.http.timeout")
+		client, err := helper.NewOutboundClient(config.Get("http.proxy.url"), config.Get("http.proxy.cabundle"), timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbound http configuration 'http.proxy'. got %s", err.Error())
+		}
+		return &connector.SendGridSender{
+			Token:   config.Get("mailer.sendgrid.token"),
+			Client:  client,
+			Timeout: timeout,
+			ReplyTo: replyTo,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer type %s. Correct your configuration 'mailer.type' or env-var 'AAA_MAILER_TYPE'. allowed values are DUMMY, SENDMAIL or SENDGRID", config.Get("mailer.type"))
+	}
+}
+
+// initEmailSender creates the email sender of "mailer.type". When it can not be created, it returns the error
+// if "mailer.failmode" is fatal, or a dummy sender that sends nothing if it is degrade, so hansip keeps serving without email.
+func initEmailSender() (connector.EmailSender, error) {
+	if err := checkMailerFailMode(); err != nil {
+		return nil, err
+	}
+	sender, err := newEmailSender()
+	if err == nil {
+		return sender, nil
+	}
+	if config.Get("mailer.failmode") == MailerFailFatal {
+		return nil, err
+	}
+	log.WithField("go", "MailerInit").WithField("func", "initEmailSender").Errorf("MAILER IS DEGRADED, NO EMAIL WILL BE SENT. got %s", err.Error())
+	return &connector.DummyMailSender{}, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+)
+
+func TestInitEmailSender(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	reachablePort := listener.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachablePort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	keys := []string{"mailer.type", "mailer.failmode", "mailer.sendmail.host", "mailer.sendmail.port", "mailer.sendgrid.token"}
+	for _, key := range keys {
+		defer config.Set(key, config.Get(key))
+	}
+
+	testData := []struct {
+		mailerType string
+		failMode   string
+		port       int
+		token      string
+		fails      bool
+		dummy      bool
+	}{
+		{"SENDMAIL", MailerFailFatal, reachablePort, "", false, false},
+		{"SENDMAIL", MailerFailFatal, unreachablePort, "", true, false},
+		{"SENDMAIL", MailerFailDegrade, unreachablePort, "", false, true},
+		{"SENDGRID", MailerFailFatal, 0, "SG.token", false, false},
+		{"SENDGRID", MailerFailFatal, 0, "", true, false},
+		{"SENDGRID", MailerFailDegrade, 0, "", false, true},
+		{"PIGEON", MailerFailFatal, 0, "", true, false},
+		{"PIGEON", MailerFailDegrade, 0, "", false, true},
+		{"DUMMY", "ignore", 0, "", true, false},
+	}
+	for _, td := range testData {
+		config.Set("mailer.type", td.mailerType)
+		config.Set("mailer.failmode", td.failMode)
+		config.Set("mailer.sendmail.host", "127.0.0.1")
+		config.Set("mailer.sendmail.port", fmt.Sprintf("%d", td.port))
+		config.Set("mailer.sendgrid.token", td.token)
+		sender, err := initEmailSender()
+		if td.fails {
+			if err == nil {
+				t.Errorf("%s %s port %d should fail. got %T", td.mailerType, td.failMode, td.port, sender)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s port %d should not fail. got %s", td.mailerType, td.failMode, td.port, err.Error())
+			continue
+		}
+		if _, isDummy := sender.(*connector.DummyMailSender); isDummy != td.dummy {
+			t.Errorf("%s %s port %d expect dummy sender %v. got %T", td.mailerType, td.failMode, td.port, td.dummy, sender)
+		}
+	}
+}
+
+func TestInitEmailSenderReplyTo(t *testing.T) {
+	for _, key := range []string{"mailer.type", "mailer.failmode", "mailer.replyto"} {
+		defer config.Set(key, config.Get(key))
+	}
+	config.Set("mailer.type", "DUMMY")
+	config.Set("mailer.failmode", MailerFailFatal)
+
+	config.Set("mailer.replyto", "Acme Support <support@acme.com>")
+	sender, err := initEmailSender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dummy := sender.(*connector.DummyMailSender); dummy.ReplyTo != "Acme Support <support@acme.com>" {
+		t.Errorf("expect the reply-to passed to the sender. got %q", dummy.ReplyTo)
+	}
+
+	config.Set("mailer.replyto", "not an email")
+	if _, err := initEmailSender(); err == nil {
+		t.Errorf("expect an invalid mailer.replyto to fail")
+	}
+}
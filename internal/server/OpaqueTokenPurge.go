@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	opaqueTokenPurgeLog = log.WithField("go", "OpaqueTokenPurge")
+)
+
+// PurgeOpaqueTokens deletes the opaque tokens expired before now from the store.
+func PurgeOpaqueTokens(ctx context.Context, store helper.OpaqueTokenStore, now time.Time) error {
+	purged, err := store.PurgeOpaqueTokensBefore(ctx, now)
+	if err != nil {
+		return err
+	}
+	opaqueTokenPurgeLog.WithField("func", "PurgeOpaqueTokens").Debugf("purged %d expired opaque tokens", purged)
+	return nil
+}
+
+// startOpaqueTokenPurge purges the expired opaque tokens every interval.
+func startOpaqueTokenPurge(store helper.OpaqueTokenStore, interval time.Duration, stop <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := PurgeOpaqueTokens(context.Background(), store, now); err != nil {
+				opaqueTokenPurgeLog.WithField("func", "startOpaqueTokenPurge").Errorf("purging opaque tokens got %s", err.Error())
+			}
+		}
+	}
+}
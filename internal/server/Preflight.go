@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/endpoint"
+	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/jiffy"
+)
+
+const (
+	// preflightDBTimeout is the time given to the database connectivity check
+	preflightDBTimeout = 5 * time.Second
+)
+
+var (
+	// requiredDurations are configuration keys that must hold a valid jiffy duration
+	requiredDurations = []string{
+		"server.timeout.write",
+		"server.timeout.read",
+		"server.timeout.idle",
+		"server.timeout.graceshut",
+		"server.timeout.shutdownhook",
+		"server.shutdown.draindelay",
+		"server.http.idempotency.ttl",
+		"server.http.maxinflight.retryafter",
+		"api.delete.confirm.window",
+		"audit.retention.interval",
+		"token.access.duration",
+		"token.refresh.duration",
+		"token.refresh.remember.duration",
+		"token.refresh.session.duration",
+		"token.sliding.window",
+		"token.notbefore.offset",
+		"token.notbefore.maxdelay",
+		"auth.permissions.cache.ttl",
+		"token.clockskew.leeway",
+		"token.impersonate.duration",
+		"auth.2fa.enrollment.duration",
+		"token.opaque.purge.interval",
+		"db.connect.retry.interval",
+		"db.slowquery.threshold",
+		"auth.email.mxcheck.timeout",
+		"cache.role.ttl",
+		"cache.tenant.ttl",
+		"revocation.cache.ttl",
+		"auth.webauthn.timeout",
+		"auth.email.change.ttl",
+		"token.crypt.keyset.reload",
+		"mailer.http.timeout",
+		"mailer.group.job.ttl",
+		"secret.vault.timeout",
+		"auth.password.breachcheck.timeout",
+		"webhook.security.timeout",
+		"auth.throttle.base",
+		"auth.throttle.max",
+	}
+
+	// optionalDurations are configuration keys that must hold a valid jiffy duration when they are set
+	optionalDurations = []string{
+		"auth.password.minage",
+		"secret.refresh.interval",
+		"audit.retention",
+		"token.crypt.rotation.overlap",
+	}
+
+	// requiredIntegers are configuration keys that must hold an integer
+	requiredIntegers = []string{
+		"server.port",
+		"server.http.maxheaderbytes",
+		"server.http.maxinflight",
+		"db.pool.maxidle",
+		"db.pool.maxopen",
+		"db.connect.retries",
+		"db.retry.deadlock.max",
+		"auth.permissions.cache.capacity",
+		"auth.password.history",
+		"mailer.ratelimit.perhour",
+		"cache.capacity",
+		"revocation.cache.capacity",
+		"api.query.maxpagesize",
+		"api.query.maxfilters",
+		"api.query.maxsortfields",
+		"api.query.maxoffset",
+		"api.bulk.maxusers",
+	}
+
+	// mailTemplates are configuration keys of the email templates
+	mailTemplates = []string{
+		"mailer.templates.emailveri.subject",
+		"mailer.templates.emailveri.body",
+		"mailer.templates.passrecover.subject",
+		"mailer.templates.passrecover.body",
+		"mailer.templates.welcome.subject",
+		"mailer.templates.welcome.body",
+		"mailer.templates.emailchange.subject",
+		"mailer.templates.emailchange.body",
+		"mailer.templates.emailchangenotice.subject",
+		"mailer.templates.emailchangenotice.body",
+		"mailer.templates.announcement.subject",
+		"mailer.templates.announcement.body",
+	}
+)
+
+// PreflightCheck is a single named validation run before the server starts serving traffic
+type PreflightCheck struct {
+	Name  string
+	Check func() error
+}
+
+// PreflightChecks returns all checks of the preflight validation
+func PreflightChecks() []*PreflightCheck {
+	return []*PreflightCheck{
+		{Name: "durations", Check: checkDurations},
+		{Name: "numbers", Check: checkIntegers},
+		{Name: "route timeouts", Check: checkRouteTimeouts},
+		{Name: "rate limits", Check: checkRateLimits},
+		{Name: "identifier patterns", Check: endpoint.ValidateIdentifierPatterns},
+		{Name: "access log", Check: endpoint.ValidateAccessLog},
+		{Name: "token", Check: checkToken},
+		{Name: "database", Check: checkDatabase},
+		{Name: "mailer", Check: checkMailer},
+		{Name: "outbound http", Check: checkOutboundHTTP},
+		{Name: "security webhook", Check: checkSecurityWebhook},
+		{Name: "tls", Check: checkTLS},
+	}
+}
+
+// RunPreflight runs all preflight checks, writes a pass/fail line of each check to out
+// and returns true only if all checks passed.
+func RunPreflight(out io.Writer, checks []*PreflightCheck) bool {
+	passed := true
+	for _, check := range checks {
+		if err := check.Check(); err != nil {
+			passed = false
+			fmt.Fprintf(out, "[FAIL] %s : %s\n", check.Name, err.Error())
+		} else {
+			fmt.Fprintf(out, "[PASS] %s\n", check.Name)
+		}
+	}
+	if passed {
+		fmt.Fprintln(out, "Preflight passed")
+	} else {
+		fmt.Fprintln(out, "Preflight failed")
+	}
+	return passed
+}
+
+// configDuration parses the jiffy duration of a configuration key,
+// the error names the key and its offending value.
+func configDuration(key string) (time.Duration, error) {
+	duration, err := jiffy.DurationOf(config.Get(key))
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration for %s: '%s'", key, config.Get(key))
+	}
+	return duration, nil
+}
+
+// mustConfigDuration is configDuration that panics on an invalid duration.
+func mustConfigDuration(key string) time.Duration {
+	duration, err := configDuration(key)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+// checkDurations validates all duration configuration at once, so every invalid key is reported together.
+func checkDurations() error {
+	failed := make([]string, 0)
+	for _, key := range requiredDurations {
+		if _, err := configDuration(key); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	for _, key := range optionalDurations {
+		if len(config.Get(key)) == 0 {
+			continue
+		}
+		if _, err := configDuration(key); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	return joinFailures(failed)
+}
+
+func checkIntegers() error {
+	failed := make([]string, 0)
+	for _, key := range requiredIntegers {
+		if _, err := strconv.Atoi(config.Get(key)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s %q is not a number", key, config.Get(key)))
+		}
+	}
+	return joinFailures(failed)
+}
+
+func checkRouteTimeouts() error {
+	if _, err := endpoint.ParseRouteTimeouts(config.Get("server.timeout.routes")); err != nil {
+		return fmt.Errorf("server.timeout.routes is not valid. got %s", err.Error())
+	}
+	return nil
+}
+
+func checkRateLimits() error {
+	if _, err := endpoint.ParseRouteRateLimits(config.Get("server.http.ratelimit.routes")); err != nil {
+		return fmt.Errorf("server.http.ratelimit.routes is not valid. got %s", err.Error())
+	}
+	if _, err := endpoint.ParseRouteRateLimits(config.Get("server.http.ratelimit.user.routes")); err != nil {
+		return fmt.Errorf("server.http.ratelimit.user.routes is not valid. got %s", err.Error())
+	}
+	if _, err := passwordCheckRateLimits(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// passwordCheckRateLimits parses "auth.password.check.ratelimit", the requests/duration each client may check passphrases
+func passwordCheckRateLimits() ([]*endpoint.RouteRateLimit, error) {
+	limits, err := endpoint.ParseRouteRateLimits(endpoint.PasswordPolicyCheckPath() + "=" + config.Get("auth.password.check.ratelimit"))
+	if err != nil {
+		return nil, fmt.Errorf("auth.password.check.ratelimit is not valid. got %s", err.Error())
+	}
+	return limits, nil
+}
+
+func checkToken() error {
+	failed := make([]string, 0)
+	switch config.Get("token.crypt.method") {
+	case "HS256", "HS384", "HS512":
+	default:
+		failed = append(failed, fmt.Sprintf("token.crypt.method %q is not one of HS256, HS384 or HS512", config.Get("token.crypt.method")))
+	}
+	if len(config.Get("token.crypt.key")) == 0 {
+		failed = append(failed, "token.crypt.key is empty")
+	}
+	if len(config.Get("token.issuer")) == 0 {
+		failed = append(failed, "token.issuer is empty")
+	}
+	if _, err := loadKeySet(); err != nil {
+		failed = append(failed, fmt.Sprintf("token.crypt.keyset is not valid. got %s", err.Error()))
+	}
+	if config.Get("token.format") != "JWT" && config.Get("token.format") != "OPAQUE" {
+		failed = append(failed, fmt.Sprintf("token.format %q is not one of JWT or OPAQUE", config.Get("token.format")))
+	}
+	if !helper.IsTokenMinimizeMode(config.Get("token.minimize")) {
+		failed = append(failed, fmt.Sprintf("token.minimize %q is not one of none, claims or compress", config.Get("token.minimize")))
+	}
+	if _, err := endpoint.ParseRolePermissions(config.Get("token.permissions")); err != nil {
+		failed = append(failed, fmt.Sprintf("token.permissions is not valid. got %s", err.Error()))
+	}
+	return joinFailures(failed)
+}
+
+func checkDatabase() error {
+	if err := connector.ValidateEncryptedFields(); err != nil {
+		return err
+	}
+	if err := connector.ValidateRecIDStrategy(); err != nil {
+		return err
+	}
+	if err := connector.ValidateDbTLS(); err != nil {
+		return err
+	}
+	switch config.Get("db.type") {
+	case "MYSQL":
+		if _, err := strconv.Atoi(config.Get("db.mysql.port")); err != nil {
+			return fmt.Errorf("db.mysql.port %q is not a number", config.Get("db.mysql.port"))
+		}
+		if !isDriverRegistered(config.Get("db.mysql.driver")) {
+			return fmt.Errorf("db.mysql.driver %q is not compiled into hansip. available drivers are %s", config.Get("db.mysql.driver"), strings.Join(sql.Drivers(), ","))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), preflightDBTimeout)
+		defer cancel()
+		if err := connector.CheckMySQLConnectivity(ctx); err != nil {
+			return fmt.Errorf("can not connect to MySQL at %s:%s. got %s", config.Get("db.mysql.host"), config.Get("db.mysql.port"), err.Error())
+		}
+		return nil
+	case "SQLITE":
+		return nil
+	default:
+		return fmt.Errorf("db.type %q is not one of MYSQL or SQLITE", config.Get("db.type"))
+	}
+}
+
+func isDriverRegistered(driver string) bool {
+	for _, registered := range sql.Drivers() {
+		if registered == driver {
+			return true
+		}
+	}
+	return false
+}
+
+func checkMailer() error {
+	failed := make([]string, 0)
+	if _, err := newEmailSender(); err != nil {
+		failed = append(failed, err.Error())
+	}
+	if err := checkMailerFailMode(); err != nil {
+		failed = append(failed, err.Error())
+	}
+	if len(config.Get("mailer.from")) == 0 {
+		failed = append(failed, "mailer.from is empty")
+	}
+	for _, key := range mailTemplates {
+		text, err := mailer.TemplateLoader(config.Get(key))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s can not be loaded. got %s", key, err.Error()))
+			continue
+		}
+		if _, err := template.New(key).Parse(text); err != nil {
+			failed = append(failed, fmt.Sprintf("%s is not a valid template. got %s", key, err.Error()))
+		}
+	}
+	if _, err := mailer.LoadLocalizedTemplates(config.Get("mailer.locales")); err != nil {
+		failed = append(failed, err.Error())
+	}
+	return joinFailures(failed)
+}
+
+// checkOutboundHTTP validates the proxy and CA bundle of the API based connectors.
+func checkOutboundHTTP() error {
+	_, err := helper.NewOutboundClient(config.Get("http.proxy.url"), config.Get("http.proxy.cabundle"), 0)
+	return err
+}
+
+// checkSecurityWebhook validates the URL and the subscribed events of the security webhook.
+func checkSecurityWebhook() error {
+	if address := config.Get("webhook.security.url"); len(address) > 0 {
+		parsed, err := url.Parse(address)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+			return fmt.Errorf("webhook.security.url %q is not an http or https URL", address)
+		}
+	}
+	return endpoint.ValidateSecurityEvents()
+}
+
+func checkTLS() error {
+	_, err := serverTLSConfig()
+	return err
+}
+
+func joinFailures(failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(failed, ", "))
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+)
+
+func TestPreflightValid(t *testing.T) {
+	config.Set("db.type", "SQLITE")
+	config.Set("mailer.type", "DUMMY")
+	defer config.Set("db.type", "MYSQL")
+	defer config.Set("mailer.type", "SENDGRID")
+
+	out := &bytes.Buffer{}
+	if !RunPreflight(out, PreflightChecks()) {
+		t.Errorf("default configuration should pass preflight. got\n%s", out.String())
+	}
+}
+
+func TestPreflightInvalid(t *testing.T) {
+	config.Set("db.type", "SQLITE")
+	config.Set("mailer.type", "DUMMY")
+	defer config.Set("db.type", "MYSQL")
+	defer config.Set("mailer.type", "SENDGRID")
+
+	testData := []struct {
+		key     string
+		value   string
+		failing string
+	}{
+		{"token.access.duration", "five minutes", "durations"},
+		{"auth.password.minage", "a while", "durations"},
+		{"server.port", "http", "numbers"},
+		{"server.timeout.routes", "/api=soon", "route timeouts"},
+		{"server.http.ratelimit.routes", "/api=ten/1 minute", "rate limits"},
+		{"auth.password.check.ratelimit", "10/soon", "rate limits"},
+		{"server.http.ratelimit.user.routes", "/api=10", "rate limits"},
+		{"server.http.accesslog.level", "loud", "access log"},
+		{"server.http.accesslog.quiet.level", "silent", "access log"},
+		{"token.crypt.method", "RS256", "token"},
+		{"token.format", "PASETO", "token"},
+		{"db.type", "POSTGRES", "database"},
+		{"db.encrypt.fields", "totp,phone", "database"},
+		{"db.id.strategy", "AUTOINCREMENT", "database"},
+		{"db.tls.mode", "prefer", "database"},
+		{"mailer.type", "PIGEON", "mailer"},
+		{"mailer.failmode", "ignore", "mailer"},
+		{"mailer.templates.welcome.body", "Hello {{.Email", "mailer"},
+		{"webhook.security.url", "siem.acme.com/hansip", "security webhook"},
+		{"webhook.security.events", "LOGIN_FAILED,LOGIN_SUCCEEDED", "security webhook"},
+		{"server.tls.clientauth", "require", "tls"},
+		{"server.tls.cert", "/etc/hansip/server.pem", "tls"},
+		{"server.tls.minversion", "1.4", "tls"},
+		{"server.tls.ciphers", "TLS_RSA_WITH_RC4_128_SHA", "tls"},
+	}
+	for _, td := range testData {
+		original := config.Get(td.key)
+		config.Set(td.key, td.value)
+		out := &bytes.Buffer{}
+		passed := RunPreflight(out, PreflightChecks())
+		config.Set(td.key, original)
+		if passed {
+			t.Errorf("%s = %q should fail preflight", td.key, td.value)
+			continue
+		}
+		if !strings.Contains(out.String(), "[FAIL] "+td.failing+" : ") || !strings.Contains(out.String(), td.key) {
+			t.Errorf("%s = %q should fail the %s check. got\n%s", td.key, td.value, td.failing, out.String())
+		}
+	}
+}
+
+func TestPreflightUnknownDriver(t *testing.T) {
+	config.Set("db.mysql.driver", "nodriver")
+	config.Set("mailer.type", "DUMMY")
+	defer config.Set("db.mysql.driver", "mysql")
+	defer config.Set("mailer.type", "SENDGRID")
+
+	out := &bytes.Buffer{}
+	if RunPreflight(out, PreflightChecks()) {
+		t.Fatalf("unknown driver should fail preflight")
+	}
+	if !strings.Contains(out.String(), "[FAIL] database : ") || !strings.Contains(out.String(), "db.mysql.driver") {
+		t.Errorf("unknown driver should fail the database check. got\n%s", out.String())
+	}
+}
+
+func TestConfigDuration(t *testing.T) {
+	config.Set("server.timeout.write", "10x")
+	defer config.Set("server.timeout.write", "15 seconds")
+
+	if _, err := configDuration("server.timeout.write"); err == nil || err.Error() != "invalid duration for server.timeout.write: '10x'" {
+		t.Errorf("error should name the key and the value. got %v", err)
+	}
+	if duration, err := configDuration("server.timeout.read"); err != nil || duration != 15*time.Second {
+		t.Errorf("expect 15 seconds. got %s %v", duration, err)
+	}
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "server.timeout.write") {
+			t.Errorf("panic should name the key. got %v", r)
+		}
+	}()
+	mustConfigDuration("server.timeout.write")
+}
+
+func TestCheckDurationsReportsAll(t *testing.T) {
+	config.Set("server.timeout.write", "10x")
+	config.Set("auth.password.minage", "a while")
+	defer config.Set("server.timeout.write", "15 seconds")
+	defer config.Set("auth.password.minage", "")
+
+	err := checkDurations()
+	if err == nil {
+		t.Fatalf("invalid durations should fail")
+	}
+	for _, expect := range []string{"invalid duration for server.timeout.write: '10x'", "invalid duration for auth.password.minage: 'a while'"} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("expect %q. got %s", expect, err.Error())
+		}
+	}
+}
+
+func TestPreflightResolvesSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/hansip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"method":"HS512"}}}`))
+	}))
+	defer vault.Close()
+	// runs last, once the references below are restored, to forget the resolved secret.
+	defer config.ResolveSecrets()
+	config.Set("db.type", "SQLITE")
+	config.Set("mailer.type", "DUMMY")
+	config.Set("secret.vault.address", vault.URL)
+	config.Set("token.crypt.method", "vault://secret/hansip#method")
+	defer config.Set("db.type", "MYSQL")
+	defer config.Set("mailer.type", "SENDGRID")
+	defer config.Set("secret.vault.address", "")
+	defer config.Set("token.crypt.method", "HS512")
+
+	out := &bytes.Buffer{}
+	if RunPreflight(out, PreflightChecks()) {
+		t.Fatalf("unresolved secret reference should fail preflight")
+	}
+	if err := ResolveConfigSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if !RunPreflight(out, PreflightChecks()) {
+		t.Errorf("resolved secret reference should pass preflight. got\n%s", out.String())
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/endpoint"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	roleSeedLog = log.WithField("go", "RoleSeed")
+)
+
+// seedRoles reads the default roles of "seed.roles", comma separated role@domain or role of the hansip domain,
+// followed by the roles of the "seed.roles.file" JSON array.
+func seedRoles() ([]*connector.Role, error) {
+	roles := make([]*connector.Role, 0)
+	for _, entry := range config.GetList("seed.roles") {
+		role := &connector.Role{RoleName: entry, RoleDomain: config.Get("hansip.domain")}
+		if at := strings.Index(entry, "@"); at >= 0 {
+			role.RoleName, role.RoleDomain = entry[:at], entry[at+1:]
+		}
+		roles = append(roles, role)
+	}
+	if path := config.Get("seed.roles.file"); len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("seed.roles.file %s can not be read. got %s", path, err.Error())
+		}
+		fileRoles := make([]*connector.Role, 0)
+		if err := json.Unmarshal(data, &fileRoles); err != nil {
+			return nil, fmt.Errorf("seed.roles.file %s is not a JSON array of roles. got %s", path, err.Error())
+		}
+		for _, role := range fileRoles {
+			if len(role.RoleDomain) == 0 {
+				role.RoleDomain = config.Get("hansip.domain")
+			}
+		}
+		roles = append(roles, fileRoles...)
+	}
+	for _, role := range roles {
+		if len(role.RoleName) == 0 || strings.Contains(role.RoleName, "@") || strings.Contains(role.RoleDomain, "@") {
+			return nil, fmt.Errorf("seeded role %s@%s is not a valid role name", role.RoleName, role.RoleDomain)
+		}
+	}
+	return roles, nil
+}
+
+// SeedRoles creates the default roles of "seed.roles" and "seed.roles.file" that do not exist yet and returns how many are created.
+// Existing roles are left as they are, so roles modified by the operator are never overwritten and seeding on every start is harmless.
+func SeedRoles(ctx context.Context) (int, error) {
+	fLog := roleSeedLog.WithField("func", "SeedRoles")
+	roles, err := seedRoles()
+	if err != nil {
+		return 0, err
+	}
+	created := 0
+	for _, seed := range roles {
+		tenant, err := endpoint.TenantRepo.GetTenantByDomain(ctx, seed.RoleDomain)
+		if err != nil {
+			return created, err
+		}
+		if tenant == nil {
+			return created, fmt.Errorf("seeded role %s@%s has no tenant domain %s", seed.RoleName, seed.RoleDomain, seed.RoleDomain)
+		}
+		role, err := endpoint.RoleRepo.GetRoleByName(ctx, seed.RoleName, seed.RoleDomain)
+		if err != nil {
+			return created, err
+		}
+		if role != nil {
+			continue
+		}
+		if _, err := endpoint.RoleRepo.CreateRole(ctx, seed.RoleName, seed.RoleDomain, seed.Description); err != nil {
+			return created, err
+		}
+		fLog.Infof("seeded role %s@%s", seed.RoleName, seed.RoleDomain)
+		created++
+	}
+	return created, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperjumptech/hansip/internal/config"
+	"github.com/hyperjumptech/hansip/internal/connector"
+	"github.com/hyperjumptech/hansip/internal/endpoint"
+)
+
+type fakeSeedTenantRepo struct {
+	connector.TenantRepository
+	domains map[string]bool
+}
+
+func (repo *fakeSeedTenantRepo) GetTenantByDomain(ctx context.Context, tenantDomain string) (*connector.Tenant, error) {
+	if !repo.domains[tenantDomain] {
+		return nil, nil
+	}
+	return &connector.Tenant{Domain: tenantDomain}, nil
+}
+
+type fakeSeedRoleRepo struct {
+	connector.RoleRepository
+	roles map[string]*connector.Role
+}
+
+func (repo *fakeSeedRoleRepo) GetRoleByName(ctx context.Context, roleName, roleDomain string) (*connector.Role, error) {
+	return repo.roles[roleName+"@"+roleDomain], nil
+}
+
+func (repo *fakeSeedRoleRepo) CreateRole(ctx context.Context, roleName, roleDomain, description string) (*connector.Role, error) {
+	role := &connector.Role{RoleName: roleName, RoleDomain: roleDomain, Description: description}
+	repo.roles[roleName+"@"+roleDomain] = role
+	return role, nil
+}
+
+func TestSeedRoles(t *testing.T) {
+	previousRoles, previousTenants := endpoint.RoleRepo, endpoint.TenantRepo
+	roles := &fakeSeedRoleRepo{roles: make(map[string]*connector.Role)}
+	endpoint.RoleRepo = roles
+	endpoint.TenantRepo = &fakeSeedTenantRepo{domains: map[string]bool{"hansip": true, "acme.com": true}}
+	defer func() {
+		endpoint.RoleRepo, endpoint.TenantRepo = previousRoles, previousTenants
+		config.Set("seed.roles", "")
+		config.Set("seed.roles.file", "")
+	}()
+
+	file := filepath.Join(t.TempDir(), "roles.json")
+	if err := ioutil.WriteFile(file, []byte(`[{"role_name":"auditor","role_domain":"acme.com","description":"Reads the audit"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Set("seed.roles", "viewer, editor@acme.com")
+	config.Set("seed.roles.file", file)
+
+	// empty database
+	created, err := SeedRoles(context.Background())
+	if err != nil || created != 3 {
+		t.Fatalf("expect 3 roles seeded. got %d %v", created, err)
+	}
+	for _, key := range []string{"viewer@hansip", "editor@acme.com", "auditor@acme.com"} {
+		if roles.roles[key] == nil {
+			t.Errorf("expect role %s seeded", key)
+		}
+	}
+	if roles.roles["auditor@acme.com"].Description != "Reads the audit" {
+		t.Errorf("expect the description of the file. got %q", roles.roles["auditor@acme.com"].Description)
+	}
+
+	// populated database, the operator changed a seeded role
+	roles.roles["auditor@acme.com"].Description = "Changed by the operator"
+	created, err = SeedRoles(context.Background())
+	if err != nil || created != 0 || len(roles.roles) != 3 {
+		t.Fatalf("expect nothing seeded again. got %d of %d roles, %v", created, len(roles.roles), err)
+	}
+	if roles.roles["auditor@acme.com"].Description != "Changed by the operator" {
+		t.Errorf("expect the operator's role untouched. got %q", roles.roles["auditor@acme.com"].Description)
+	}
+
+	config.Set("seed.roles.file", "")
+	for _, invalid := range []string{"viewer@unknown.com", "@acme.com", "a@b@acme.com"} {
+		config.Set("seed.roles", invalid)
+		if _, err := SeedRoles(context.Background()); err == nil {
+			t.Errorf("expect seeding %s to fail", invalid)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/hyperjumptech/hansip/internal/config"
@@ -9,7 +10,10 @@ import (
 	"github.com/hyperjumptech/hansip/internal/endpoint"
 	"github.com/hyperjumptech/hansip/internal/gzip"
 	"github.com/hyperjumptech/hansip/internal/mailer"
+	"github.com/hyperjumptech/hansip/internal/shutdown"
+	"github.com/hyperjumptech/hansip/internal/version"
 	"github.com/hyperjumptech/hansip/pkg/helper"
+	"github.com/hyperjumptech/hansip/pkg/store/cache"
 	"github.com/hyperjumptech/jiffy"
 	"github.com/rs/cors"
 	log "github.com/sirupsen/logrus"
@@ -26,42 +30,92 @@ var (
 
 	// TokenFactory will handle token creation and validation
 	TokenFactory helper.TokenFactory
+
+	// rateLimiters are the rate limiters of the router, their full buckets are purged while the server runs
+	rateLimiters []*endpoint.RateLimiter
 )
 
 // GetJwtTokenFactory return an instance of JWT TokenFactory.
 func GetJwtTokenFactory() helper.TokenFactory {
-	accessDuration, err := jiffy.DurationOf(config.Get("token.access.duration"))
-	if err != nil {
-		panic(err)
-	}
-	refreshDuration, err := jiffy.DurationOf(config.Get("token.refresh.duration"))
-	if err != nil {
-		panic(err)
-	}
+	accessDuration := mustConfigDuration("token.access.duration")
+	refreshDuration := mustConfigDuration("token.refresh.duration")
 
-	tokenFactory := helper.NewTokenFactory(
+	notBeforeOffset, leeway := getNotBeforeOffsetAndLeeway()
+
+	tokenFactory := helper.NewTokenFactoryWithNotBefore(
 		config.Get("token.crypt.key"),
 		config.Get("token.crypt.method"),
 		config.Get("token.issuer"),
 		accessDuration,
-		refreshDuration)
+		refreshDuration,
+		notBeforeOffset,
+		leeway)
+	tokenFactory.(*helper.DefaultTokenFactory).DurationResolver = endpoint.RoleTokenDurations
+	tokenFactory.(*helper.DefaultTokenFactory).AcceptedIssuers = config.GetList("token.issuer.accept")
+	if !helper.IsTokenMinimizeMode(config.Get("token.minimize")) {
+		panic(fmt.Sprintf("unknown token minimize mode %s. Correct your configuration 'token.minimize' or env-var 'AAA_TOKEN_MINIMIZE'. allowed values are none, claims or compress", config.Get("token.minimize")))
+	}
+	tokenFactory.(*helper.DefaultTokenFactory).Minimize = config.Get("token.minimize")
+	tokenFactory.(*helper.DefaultTokenFactory).Encrypt = config.GetBoolean("token.encrypt")
+	tokenFactory.(*helper.DefaultTokenFactory).EncryptKey = tokenEncryptionKey()
+
+	if path := config.Get("token.crypt.keyset"); len(path) > 0 {
+		keySet, err := loadKeySet()
+		if err != nil {
+			panic(fmt.Sprintf("invalid token keyset 'token.crypt.keyset'. got %s", err.Error()))
+		}
+		if keySet != nil {
+			log.Infof("Using token keyset %s, current signing key %s", path, keySet.Current)
+			tokenFactory.(*helper.DefaultTokenFactory).SetKeySet(keySet)
+		}
+		tokenFactory.(*helper.DefaultTokenFactory).KeySetLoader = func() (*helper.KeySet, error) {
+			return helper.LoadKeySet(path)
+		}
+	}
+
+	return tokenFactory
+}
 
+// tokenEncryptionKey derives the JWE key from "token.encrypt.key", or "token.crypt.key" when it is not set.
+func tokenEncryptionKey() []byte {
+	secret := config.Get("token.encrypt.key")
+	if len(secret) == 0 {
+		secret = config.Get("token.crypt.key")
+	}
+	return helper.DeriveTokenEncryptionKey(secret)
+}
+
+// GetOpaqueTokenFactory return an instance of TokenFactory that issues opaque reference tokens kept in the store.
+func GetOpaqueTokenFactory(store helper.OpaqueTokenStore) *helper.OpaqueTokenFactory {
+	accessDuration := mustConfigDuration("token.access.duration")
+	refreshDuration := mustConfigDuration("token.refresh.duration")
+	tokenFactory := helper.NewOpaqueTokenFactory(store, config.Get("token.issuer"), accessDuration, refreshDuration)
+	tokenFactory.NotBeforeOffset, tokenFactory.Leeway = getNotBeforeOffsetAndLeeway()
+	tokenFactory.DurationResolver = endpoint.RoleTokenDurations
+	tokenFactory.AcceptedIssuers = config.GetList("token.issuer.accept")
 	return tokenFactory
 }
 
+func getNotBeforeOffsetAndLeeway() (time.Duration, time.Duration) {
+	notBeforeOffset := mustConfigDuration("token.notbefore.offset")
+	leeway := mustConfigDuration("token.clockskew.leeway")
+	return notBeforeOffset, leeway
+}
+
 // InitializeRouter initializes Gorilla Mux and all handler, including Database and Mailer connector
 func InitializeRouter() {
+	rateLimiters = nil
 	log.Info("Initializing server")
 	Router = mux.NewRouter()
 
 	if config.GetBoolean("server.http.cors.enable") {
 		log.Info("CORS handling is enabled")
 		options := cors.Options{
-			AllowedOrigins:     strings.Split(config.Get("server.http.cors.allow.origins"), ","),
-			AllowedHeaders:     strings.Split(config.Get("server.http.cors.allow.headers"), ","),
+			AllowedOrigins:     config.GetList("server.http.cors.allow.origins"),
+			AllowedHeaders:     config.GetList("server.http.cors.allow.headers"),
 			AllowCredentials:   config.GetBoolean("server.http.cors.allow.credential"),
-			AllowedMethods:     strings.Split(config.Get("server.http.cors.allow.method"), ","),
-			ExposedHeaders:     strings.Split(config.Get("server.http.cors.exposed.headers"), ","),
+			AllowedMethods:     config.GetList("server.http.cors.allow.method"),
+			ExposedHeaders:     config.GetList("server.http.cors.exposed.headers"),
 			OptionsPassthrough: config.GetBoolean("server.http.cors.optionpassthrough"),
 			MaxAge:             config.GetInt("server.http.cors.maxage"),
 		}
@@ -72,15 +126,85 @@ func InitializeRouter() {
 		log.Infof("    AllowCredentials   : %v", options.AllowCredentials)
 		log.Infof("    OptionsPassthrough : %v", options.OptionsPassthrough)
 		log.Infof("    MaxAge : %d", options.MaxAge)
-		c := cors.New(options)
-		Router.Use(c.Handler)
+		originGroups, err := endpoint.ParseCorsOriginGroups(config.Get("server.http.cors.maxage.groups"))
+		if err != nil {
+			panic(fmt.Sprintf("invalid CORS origin group configuration 'server.http.cors.maxage.groups'. got %s", err.Error()))
+		}
+		for _, group := range originGroups {
+			log.Infof("    MaxAge %d for : %s", group.MaxAge, strings.Join(group.Origins, ","))
+		}
+		corsHandler, err := endpoint.NewCorsHandler(options, originGroups)
+		if err != nil {
+			panic(fmt.Sprintf("invalid CORS origin configuration 'server.http.cors.allow.origins'. got %s", err.Error()))
+		}
+		Router.Use(corsHandler)
 		Router.Use(endpoint.CorsMiddleware)
 		gzipFilter := gzip.NewGzipEncoderFilter(true, 300)
+		gzipExclusions, err := gzipExclusions()
+		if err != nil {
+			panic(fmt.Sprintf("invalid gzip exclusion configuration 'server.http.gzip.exclude'. got %s", err.Error()))
+		}
+		gzipFilter.Exclusions = gzipExclusions
 		Router.Use(gzipFilter.DoFilter)
 	}
 
-	Router.Use(endpoint.ClientIPResolverMiddleware, endpoint.TransactionIDMiddleware, endpoint.JwtMiddleware)
+	if config.GetBoolean("server.http.securityheaders") {
+		log.Info("Security headers is enabled")
+		Router.Use(endpoint.NewSecurityHeadersFromConfig().Middleware)
+	} else if len(config.Get("server.http.hsts.maxage")) > 0 || config.GetBoolean("server.http.hsts.preload") {
+		log.Warnf("server.http.hsts is configured but server.http.securityheaders is false. Strict-Transport-Security header is not sent")
+	}
+
+	// the transaction id is set before any limiter, so the requests they reject are traceable too
+	Router.Use(endpoint.ClientIPResolverMiddleware, endpoint.TransactionIDMiddleware)
+
+	if maxInFlight := config.GetInt("server.http.maxinflight"); maxInFlight > 0 {
+		exempt := config.GetList("server.http.maxinflight.exempt")
+		log.Infof("In flight request limit is enabled, at most %d requests are processed at once, except : %s", maxInFlight, strings.Join(exempt, ","))
+		Router.Use(endpoint.NewInFlightLimiter(maxInFlight, mustConfigDuration("server.http.maxinflight.retryafter"), exempt).Middleware)
+	}
+
+	routeTimeouts := getRouteTimeouts()
+	if len(routeTimeouts) > 0 {
+		writeTimeout := mustConfigDuration("server.timeout.write")
+		log.Info("Per route timeout is enabled")
+		for _, route := range routeTimeouts {
+			log.Infof("    Timeout %s for : %s", route.Timeout.String(), route.PathPrefix)
+		}
+		Router.Use(endpoint.NewRouteTimeoutMiddleware(writeTimeout, routeTimeouts))
+	}
+
+	rateLimits, err := endpoint.ParseRouteRateLimits(config.Get("server.http.ratelimit.routes"))
+	if err != nil {
+		panic(fmt.Sprintf("invalid route rate limit configuration 'server.http.ratelimit.routes'. got %s", err.Error()))
+	}
+	if len(rateLimits) > 0 {
+		log.Info("Per route rate limit is enabled")
+		for _, route := range rateLimits {
+			log.Infof("    %d requests per %s for : %s", route.Limit, route.Window.String(), route.PathPrefix)
+		}
+		limiter := endpoint.NewRateLimiter(rateLimits, config.GetBoolean("server.http.ratelimit.headers"))
+		rateLimiters = append(rateLimiters, limiter)
+		Router.Use(limiter.Middleware)
+	}
+	Router.Use(endpoint.ContentNegotiationMiddleware, endpoint.ResponseFormatMiddleware, endpoint.JwtMiddleware)
+	userRateLimits, err := endpoint.ParseRouteRateLimits(config.Get("server.http.ratelimit.user.routes"))
+	if err != nil {
+		panic(fmt.Sprintf("invalid user rate limit configuration 'server.http.ratelimit.user.routes'. got %s", err.Error()))
+	}
+	if len(userRateLimits) > 0 {
+		log.Info("Per user rate limit is enabled")
+		for _, route := range userRateLimits {
+			log.Infof("    %d requests per %s for : %s", route.Limit, route.Window.String(), route.PathPrefix)
+		}
+		limiter := endpoint.NewUserRateLimiter(userRateLimits, config.GetBoolean("server.http.ratelimit.headers"))
+		rateLimiters = append(rateLimiters, limiter)
+		Router.Use(limiter.Middleware)
+	}
 
+	var tokenStore helper.OpaqueTokenStore
+	var idempotencyRepo connector.IdempotencyRepository
+	var consumedTokenRepo connector.ConsumedTokenRepository
 	if config.Get("db.type") == "MYSQL" {
 		log.Warnf("Using MYSQL")
 		endpoint.UserRepo = connector.GetMySQLDBInstance()
@@ -91,6 +215,12 @@ func InitializeRouter() {
 		endpoint.GroupRoleRepo = connector.GetMySQLDBInstance()
 		endpoint.TenantRepo = connector.GetMySQLDBInstance()
 		endpoint.RevocationRepo = connector.GetMySQLDBInstance()
+		endpoint.AuditRepo = connector.GetMySQLDBInstance()
+		endpoint.PassphraseHistoryRepo = connector.GetMySQLDBInstance()
+		endpoint.WebAuthnCredentialRepo = connector.GetMySQLDBInstance()
+		tokenStore = connector.GetMySQLDBInstance()
+		idempotencyRepo = connector.GetMySQLDBInstance()
+		consumedTokenRepo = connector.GetMySQLDBInstance()
 	} else if config.Get("db.type") == "SQLITE" {
 		log.Warnf("Using SQLITE")
 		endpoint.UserRepo = connector.GetSqliteDBInstance()
@@ -101,35 +231,228 @@ func InitializeRouter() {
 		endpoint.GroupRoleRepo = connector.GetSqliteDBInstance()
 		endpoint.TenantRepo = connector.GetSqliteDBInstance()
 		endpoint.RevocationRepo = connector.GetSqliteDBInstance()
+		endpoint.AuditRepo = connector.GetSqliteDBInstance()
+		endpoint.PassphraseHistoryRepo = connector.GetSqliteDBInstance()
+		endpoint.WebAuthnCredentialRepo = connector.GetSqliteDBInstance()
+		tokenStore = connector.GetSqliteDBInstance()
+		idempotencyRepo = connector.GetSqliteDBInstance()
+		consumedTokenRepo = connector.GetSqliteDBInstance()
 	} else {
 		panic(fmt.Sprintf("unknown database type %s. Correct your configuration 'db.type' or env-var 'AAA_DB_TYPE'. allowed values are INMEMORY or MYSQL", config.Get("db.type")))
 	}
 
-	if config.Get("mailer.type") == "DUMMY" {
-		endpoint.EmailSender = &connector.DummyMailSender{}
-	} else if config.Get("mailer.type") == "SENDMAIL" {
-		endpoint.EmailSender = &connector.SendMailSender{
-			Host:     config.Get("mailer.sendmail.host"),
-			Port:     config.GetInt("mailer.sendmail.port"),
-			User:     config.Get("mailer.sendmail.user"),
-			Password: config.Get("mailer.sendmail.password"),
-		}
-	} else if config.Get("mailer.type") == "SENDGRID" {
-		endpoint.EmailSender = &connector.SendGridSender{
-			Token: config.Get("mailer.sendgrid.token"),
+	if config.GetBoolean("cache.enable") {
+		roleTTL := mustConfigDuration("cache.role.ttl")
+		tenantTTL := mustConfigDuration("cache.tenant.ttl")
+		log.Infof("Role and tenant cache is enabled, roles are cached for %s and tenants for %s", roleTTL.String(), tenantTTL.String())
+		entityCache := connector.NewEntityCache(config.GetInt("cache.capacity"), roleTTL, tenantTTL)
+		endpoint.RoleRepo = &connector.CachedRoleRepository{RoleRepository: endpoint.RoleRepo, Cache: entityCache}
+		endpoint.TenantRepo = &connector.CachedTenantRepository{TenantRepository: endpoint.TenantRepo, Cache: entityCache}
+	}
+
+	if permissionsTTL := mustConfigDuration("auth.permissions.cache.ttl"); permissionsTTL > 0 {
+		log.Infof("Effective permissions are cached per token for %s", permissionsTTL.String())
+		endpoint.PermissionsCache = cache.NewInMemoryCache(config.GetInt("auth.permissions.cache.capacity"), int(permissionsTTL/time.Second), false)
+	}
+	endpoint.GroupEmailJobs = cache.NewInMemoryCache(endpoint.GroupEmailJobCapacity, int(mustConfigDuration("mailer.group.job.ttl")/time.Second), false)
+	if config.GetBoolean("revocation.cache.enable") {
+		revocationTTL := mustConfigDuration("revocation.cache.ttl")
+		log.Infof("Revocation cache is enabled, revocations are cached for %s", revocationTTL.String())
+		endpoint.RevocationRepo = connector.NewCachedRevocationRepository(endpoint.RevocationRepo, config.GetInt("revocation.cache.capacity"), revocationTTL)
+	}
+
+	if config.GetBoolean("auth.webauthn.enable") {
+		origins := config.GetList("auth.webauthn.origins")
+		log.Infof("Passkey is enabled for relying party %s, origins : %s", config.Get("auth.webauthn.rpid"), strings.Join(origins, ", "))
+		webAuthn, err := endpoint.NewWebAuthnCeremonies(config.Get("auth.webauthn.rpid"), config.Get("auth.webauthn.rpname"), origins, mustConfigDuration("auth.webauthn.timeout"))
+		if err != nil {
+			panic(fmt.Sprintf("invalid passkey configuration 'auth.webauthn'. got %s", err.Error()))
 		}
-	} else {
-		panic(fmt.Sprintf("unknown mailer type %s. Correct your configuration 'mailer.type' or env-var 'AAA_MAILER_TYPE'. allowed values are DUMMY, SENDMAIL or SENDGRID", config.Get("mailer.type")))
+		endpoint.WebAuthn = webAuthn
+	}
+
+	emailSender, err := initEmailSender()
+	if err != nil {
+		panic(fmt.Sprintf("invalid mailer configuration. got %s", err.Error()))
 	}
+	endpoint.EmailSender = emailSender
 	mailer.Sender = endpoint.EmailSender
 
-	TokenFactory = GetJwtTokenFactory()
+	if address := config.Get("webhook.security.url"); len(address) > 0 {
+		if err := endpoint.ValidateSecurityEvents(); err != nil {
+			panic(err.Error())
+		}
+		timeout := mustConfigDuration("webhook.security.timeout")
+		log.Infof("Security events %s are posted to %s", config.Get("webhook.security.events"), address)
+		endpoint.SecurityWebhook = &connector.WebhookPoster{
+			URL:     address,
+			Secret:  config.Get("webhook.security.secret"),
+			Client:  mustOutboundClient(timeout),
+			Timeout: timeout,
+		}
+	}
+	endpoint.BreachCheckClient = mustOutboundClient(mustConfigDuration("auth.password.breachcheck.timeout"))
+
+	if throttleBase := mustConfigDuration("auth.throttle.base"); throttleBase > 0 {
+		throttleMax := mustConfigDuration("auth.throttle.max")
+		log.Infof("Login throttling is enabled, failed logins are delayed from %s up to %s", throttleBase.String(), throttleMax.String())
+		endpoint.LoginThrottle = endpoint.NewLoginThrottler(throttleBase, throttleMax)
+	}
+
+	if config.GetBoolean("token.onetime.replay.check") {
+		log.Info("One-time token replay check is enabled, email change and delete confirmation tokens can be used once")
+		endpoint.ConsumedTokenRepo = consumedTokenRepo
+	}
+
+	if config.GetBoolean("server.http.idempotency.enable") {
+		idempotencyTTL := mustConfigDuration("server.http.idempotency.ttl")
+		log.Infof("Idempotency key is enabled, responses are replayed for %s", idempotencyTTL.String())
+		idempotency := endpoint.NewIdempotencyMiddleware(idempotencyRepo, idempotencyTTL)
+		for _, path := range endpoint.IdempotentCreatePaths() {
+			endpoint.AttachRouteMiddleware(path, idempotency.Middleware)
+		}
+	}
+
+	if config.GetBoolean("auth.password.check.enable") {
+		checkLimits, err := passwordCheckRateLimits()
+		if err != nil {
+			panic(err.Error())
+		}
+		log.Infof("Password policy check is enabled, limited to %s per client", config.Get("auth.password.check.ratelimit"))
+		limiter := endpoint.NewRateLimiter(checkLimits, config.GetBoolean("server.http.ratelimit.headers"))
+		rateLimiters = append(rateLimiters, limiter)
+		endpoint.AttachRouteMiddleware(endpoint.PasswordPolicyCheckPath(), limiter.Middleware)
+	}
+
+	if config.GetBoolean("api.delete.confirm.enable") {
+		log.Infof("Delete confirmation is enabled, tokens are valid for %s", mustConfigDuration("api.delete.confirm.window").String())
+		for _, path := range endpoint.DeleteConfirmationPaths() {
+			endpoint.AttachRouteMiddleware(path, endpoint.DeleteConfirmationMiddleware)
+		}
+	}
+
+	if config.Get("token.format") == "JWT" {
+		TokenFactory = GetJwtTokenFactory()
+	} else if config.Get("token.format") == "OPAQUE" {
+		log.Info("Using OPAQUE reference token")
+		opaqueTokenFactory := GetOpaqueTokenFactory(tokenStore)
+		endpoint.RevocationRepo = &endpoint.OpaqueTokenRevocation{
+			RevocationRepository: endpoint.RevocationRepo,
+			TokenFactory:         opaqueTokenFactory,
+		}
+		TokenFactory = opaqueTokenFactory
+	} else {
+		panic(fmt.Sprintf("unknown token format %s. Correct your configuration 'token.format' or env-var 'AAA_TOKEN_FORMAT'. allowed values are JWT or OPAQUE", config.Get("token.format")))
+	}
 	endpoint.TokenFactory = TokenFactory
 	endpoint.TokenFactory = TokenFactory
 	endpoint.InitializeRouter(Router)
 	Walk()
 }
 
+// gzipExclusions returns the requests of "server.http.gzip.exclude" and the streamed exports,
+// which are never compressed because the gzip filter buffers the whole response.
+func gzipExclusions() ([]*gzip.Exclusion, error) {
+	exclusions, err := gzip.ParseExclusions(config.Get("server.http.gzip.exclude"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range endpoint.StreamingPaths() {
+		exclusions = append(exclusions, &gzip.Exclusion{PathPrefix: path})
+	}
+	return exclusions, nil
+}
+
+// ResolveConfigSecrets register the configured secret providers and resolve configuration values that refer to a secret,
+// such as "vault://secret/hansip#token-key". It must run before the configuration is validated or used.
+func ResolveConfigSecrets() error {
+	if len(config.Get("secret.vault.address")) > 0 {
+		timeout, err := configDuration("secret.vault.timeout")
+		if err != nil {
+			return err
+		}
+		client, err := helper.NewOutboundClient(config.Get("http.proxy.url"), config.Get("http.proxy.cabundle"), timeout)
+		if err != nil {
+			return fmt.Errorf("invalid outbound http configuration 'http.proxy'. got %s", err.Error())
+		}
+		log.Infof("Vault secret provider is enabled. Address : %s", config.Get("secret.vault.address"))
+		config.RegisterSecretProvider("vault", &config.VaultSecretProvider{
+			Address:   config.Get("secret.vault.address"),
+			Token:     config.Get("secret.vault.token"),
+			KVVersion: config.GetInt("secret.vault.kv.version"),
+			Client:    client,
+			Timeout:   timeout,
+		})
+	}
+	return config.ResolveSecrets()
+}
+
+// startSecretRefresh fetch the secrets again every "secret.refresh.interval", if set, until stop is closed.
+func startSecretRefresh(stop <-chan bool) {
+	if len(config.Get("secret.refresh.interval")) > 0 {
+		interval := mustConfigDuration("secret.refresh.interval")
+		log.Infof("Secrets are refreshed every %s", interval.String())
+		go config.StartSecretRefresh(interval, stop)
+	}
+}
+
+// mustOutboundClient create the http client of the API based connectors from "http.proxy.url" and "http.proxy.cabundle",
+// panics on an invalid configuration.
+func mustOutboundClient(timeout time.Duration) *http.Client {
+	client, err := helper.NewOutboundClient(config.Get("http.proxy.url"), config.Get("http.proxy.cabundle"), timeout)
+	if err != nil {
+		panic(fmt.Sprintf("invalid outbound http configuration 'http.proxy'. got %s", err.Error()))
+	}
+	return client
+}
+
+// serverTLSConfig create the TLS configuration of the server from "server.tls.cert" and "server.tls.key",
+// verifying client certificates according to "server.tls.clientauth". It returns nil when TLS is not configured.
+func serverTLSConfig() (*tls.Config, error) {
+	minVersion, err := helper.ParseTLSVersion(config.Get("server.tls.minversion"))
+	if err != nil {
+		return nil, fmt.Errorf("server.tls.minversion is not valid. got %s", err.Error())
+	}
+	cipherSuites, err := helper.ParseCipherSuites(config.Get("server.tls.ciphers"))
+	if err != nil {
+		return nil, fmt.Errorf("server.tls.ciphers is not valid. got %s", err.Error())
+	}
+	certFile, keyFile := config.Get("server.tls.cert"), config.Get("server.tls.key")
+	clientAuth := config.Get("server.tls.clientauth")
+	if len(certFile) == 0 && len(keyFile) == 0 {
+		if len(clientAuth) > 0 && clientAuth != helper.ClientAuthNone {
+			return nil, fmt.Errorf("server.tls.clientauth %s requires server.tls.cert and server.tls.key", clientAuth)
+		}
+		return nil, nil
+	}
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, fmt.Errorf("server.tls.cert and server.tls.key must both be set")
+	}
+	tlsConfig, err := helper.NewServerTLSConfig(clientAuth, config.Get("server.tls.clientca"))
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not load server.tls.cert and server.tls.key. got %s", err.Error())
+	}
+	tlsConfig.Certificates = []tls.Certificate{certificate}
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.CipherSuites = cipherSuites
+	if _, err := endpoint.ParseClientIdentities(config.Get("server.tls.clientidentities")); err != nil {
+		return nil, fmt.Errorf("server.tls.clientidentities is not valid. got %s", err.Error())
+	}
+	return tlsConfig, nil
+}
+
+func getRouteTimeouts() []*endpoint.RouteTimeout {
+	routeTimeouts, err := endpoint.ParseRouteTimeouts(config.Get("server.timeout.routes"))
+	if err != nil {
+		panic(fmt.Sprintf("invalid route timeout configuration 'server.timeout.routes'. got %s", err.Error()))
+	}
+	return routeTimeouts
+}
+
 func configureLogging() {
 	lLevel := config.Get("server.log.level")
 	fmt.Println("Setting log level to ", lLevel)
@@ -152,52 +475,157 @@ func configureLogging() {
 	}
 }
 
+// logBanner logs the build of the starting Hansip as structured fields, to tell which build is running.
+func logBanner() {
+	buildInfo := version.GetBuildInfo()
+	log.WithFields(log.Fields{
+		"version":    buildInfo.Version,
+		"commit":     buildInfo.Commit,
+		"build_date": buildInfo.BuildDate,
+		"go_version": buildInfo.GoVersion,
+		"platform":   buildInfo.Platform,
+	}).Info("Hansip build")
+}
+
 // Start this server
 func Start() {
 	configureLogging()
+	if config.GetBoolean("server.banner.enable") {
+		logBanner()
+	}
 	log.Infof("Starting Hansip")
+	if err := ResolveConfigSecrets(); err != nil {
+		log.Errorf("Can not resolve the configuration secrets. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
+	if config.GetBoolean("server.preflight.enable") {
+		if !RunPreflight(os.Stdout, PreflightChecks()) {
+			log.Error("Preflight failed. Hansip is not started")
+			os.Exit(1)
+		}
+	} else if err := checkDurations(); err != nil {
+		// the durations are always validated up front, so all invalid keys are reported instead of the first panic.
+		log.Errorf("Invalid configuration. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
+	if err := connector.ValidateEncryptedFields(); err != nil {
+		log.Errorf("Invalid configuration. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
+	if err := connector.ValidateRecIDStrategy(); err != nil {
+		log.Errorf("Invalid configuration. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
+	if err := connector.ValidateDbTLS(); err != nil {
+		log.Errorf("Invalid configuration. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
+	if config.GetBoolean("db.tls.skipverify") {
+		log.Warnf("db.tls.skipverify is on, the database certificate is not verified")
+	}
+	if err := endpoint.ValidateIdentifierPatterns(); err != nil {
+		log.Errorf("Invalid configuration. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
+	if err := endpoint.ValidateAccessLog(); err != nil {
+		log.Errorf("Invalid configuration. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	}
 	startTime := time.Now()
 
+	secretRefreshStop := make(chan bool)
+	startSecretRefresh(secretRefreshStop)
+	shutdown.Register("secret refresh", func(ctx context.Context) error {
+		close(secretRefreshStop)
+		return nil
+	})
 	InitializeRouter()
+	if len(rateLimiters) > 0 {
+		rateLimitPurgeStop := make(chan bool)
+		for _, limiter := range rateLimiters {
+			go limiter.StartPurge(rateLimitPurgeStop)
+		}
+		shutdown.Register("rate limit purge", func(ctx context.Context) error {
+			close(rateLimitPurgeStop)
+			return nil
+		})
+	}
+	if created, err := SeedRoles(context.Background()); err != nil {
+		log.Errorf("Seeding default roles failed. Hansip is not started. got %s", err.Error())
+		os.Exit(1)
+	} else if created > 0 {
+		log.Infof("Seeded %d default roles", created)
+	}
+	if tokenFactory, ok := TokenFactory.(*helper.DefaultTokenFactory); ok && tokenFactory.KeySetLoader != nil {
+		keySetReloadStop := make(chan bool)
+		go startKeySetReload(tokenFactory, mustConfigDuration("token.crypt.keyset.reload"), keySetReloadStop)
+		shutdown.Register("keyset reload", func(ctx context.Context) error {
+			close(keySetReloadStop)
+			return nil
+		})
+	}
+	if tokenFactory, ok := TokenFactory.(*helper.OpaqueTokenFactory); ok {
+		interval := mustConfigDuration("token.opaque.purge.interval")
+		log.Infof("Expired opaque tokens are purged every %s", interval.String())
+		opaqueTokenPurgeStop := make(chan bool)
+		go startOpaqueTokenPurge(tokenFactory.Store, interval, opaqueTokenPurgeStop)
+		shutdown.Register("opaque token purge", func(ctx context.Context) error {
+			close(opaqueTokenPurgeStop)
+			return nil
+		})
+	}
+	if len(config.Get("audit.retention")) > 0 {
+		interval := mustConfigDuration("audit.retention.interval")
+		log.Infof("Audit older than %s is purged every %s", config.Get("audit.retention"), interval.String())
+		auditRetentionStop := make(chan bool)
+		go startAuditRetention(interval, auditRetentionStop)
+		shutdown.Register("audit retention", func(ctx context.Context) error {
+			close(auditRetentionStop)
+			return nil
+		})
+	}
 	go mailer.Start()
+	shutdown.Register("mailer", func(ctx context.Context) error {
+		mailer.Stop()
+		return nil
+	})
 
 	var wait time.Duration
 
-	graceShut, err := jiffy.DurationOf(config.Get("server.timeout.graceshut"))
-	if err != nil {
-		panic(err)
-	}
+	graceShut := mustConfigDuration("server.timeout.graceshut")
 	wait = graceShut
-	WriteTimeout, err := jiffy.DurationOf(config.Get("server.timeout.write"))
-	if err != nil {
-		panic(err)
-	}
-	ReadTimeout, err := jiffy.DurationOf(config.Get("server.timeout.read"))
-	if err != nil {
-		panic(err)
-	}
-	IdleTimeout, err := jiffy.DurationOf(config.Get("server.timeout.idle"))
-	if err != nil {
-		panic(err)
-	}
+	hookTimeout := mustConfigDuration("server.timeout.shutdownhook")
+	WriteTimeout := mustConfigDuration("server.timeout.write")
+	ReadTimeout := mustConfigDuration("server.timeout.read")
+	IdleTimeout := mustConfigDuration("server.timeout.idle")
+	// The server must not cut the connection before the longest per route timeout expires,
+	// the per route timeout middleware enforces the write timeout for the other routes.
+	WriteTimeout = endpoint.MaxRouteTimeout(WriteTimeout, getRouteTimeouts())
 
 	address := fmt.Sprintf("%s:%s", config.Get("server.host"), config.Get("server.port"))
 	log.Info("Server binding to ", address)
 
-	srv := &http.Server{
-		Addr: address,
-		// Good practice to set timeouts to avoid Slowloris attacks.
-		WriteTimeout: WriteTimeout,
-		ReadTimeout:  ReadTimeout,
-		IdleTimeout:  IdleTimeout,
-		Handler:      Router, // Pass our instance of gorilla/mux in.
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		panic(fmt.Sprintf("invalid server TLS configuration 'server.tls'. got %s", err.Error()))
 	}
+	srv := newHTTPServer(address, Router, WriteTimeout, ReadTimeout, IdleTimeout)
+	log.Infof("Max header bytes : %d", srv.MaxHeaderBytes)
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
+		if tlsConfig != nil {
+			log.Infof("Serving TLS, client certificate : %s", config.Get("server.tls.clientauth"))
+			srv.TLSConfig = tlsConfig
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
+				log.Println(err)
+			}
+			return
+		}
 		if err := srv.ListenAndServe(); err != nil {
 			log.Println(err)
 		}
 	}()
+	shutdown.Register("http server", srv.Shutdown)
 
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
@@ -207,23 +635,50 @@ func Start() {
 	// Block until we receive our signal.
 	<-c
 
-	mailer.Stop()
+	drain(srv, mustConfigDuration("server.shutdown.draindelay"))
 
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
-	// Doesn't block if no connections, but will otherwise wait
-	// until the timeout deadline.
-	srv.Shutdown(ctx)
-	// Optionally, you could run srv.Shutdown in a goroutine and block on
-	// <-ctx.Done() if your application should wait for other services
-	// to finalize based on context cancellation.
+	// Components are shut down in the reverse order they are started,
+	// the http server stops accepting request first and waits for the ongoing ones until the deadline.
+	shutdown.Run(ctx, hookTimeout)
 	dur := time.Now().Sub(startTime)
 	durDesc := jiffy.DescribeDuration(dur, jiffy.NewWant())
 	log.Infof("Shutting down. This Hansip been protecting the world for %s", durDesc)
 	os.Exit(0)
 }
 
+// newHTTPServer creates the http server of the handler. Request whose headers are larger than "server.http.maxheaderbytes"
+// is rejected with 431 Request Header Fields Too Large.
+func newHTTPServer(address string, handler http.Handler, writeTimeout, readTimeout, idleTimeout time.Duration) *http.Server {
+	maxHeaderBytes := config.GetInt("server.http.maxheaderbytes")
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+	return &http.Server{
+		Addr: address,
+		// Good practice to set timeouts to avoid Slowloris attacks.
+		WriteTimeout:   writeTimeout,
+		ReadTimeout:    readTimeout,
+		IdleTimeout:    idleTimeout,
+		MaxHeaderBytes: maxHeaderBytes,
+		Handler:        handler, // Pass our instance of gorilla/mux in.
+	}
+}
+
+// drain makes the instance not ready and turns off keep-alive before the server shuts down. During the delay,
+// load balancers polling /ready deregister the instance, while the responses still being served carry
+// "Connection: close", so pooled clients reconnect to another instance.
+func drain(srv *http.Server, delay time.Duration) {
+	endpoint.SetDraining(true)
+	srv.SetKeepAlivesEnabled(false)
+	if delay > 0 {
+		log.Infof("Draining connections for %s before shutting down", delay.String())
+		time.Sleep(delay)
+	}
+}
+
 // Walk and show all endpoint that available on this server
 func Walk() {
 	err := Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
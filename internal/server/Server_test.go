@@ -8,10 +8,13 @@ import (
 	"github.com/hyperjumptech/hansip/internal/config"
 	"github.com/hyperjumptech/hansip/internal/connector"
 	"github.com/hyperjumptech/hansip/internal/endpoint"
+	"github.com/hyperjumptech/hansip/internal/gzip"
 	"github.com/hyperjumptech/hansip/internal/mailer"
 	"github.com/sirupsen/logrus"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -34,6 +37,116 @@ func pretifyJSON(sjson string) string {
 	return string(byt)
 }
 
+func TestMaxHeaderBytes(t *testing.T) {
+	config.Set("server.http.maxheaderbytes", "1024")
+	defer config.Set("server.http.maxheaderbytes", "1048576")
+	srv := newHTTPServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), time.Second, time.Second, time.Second)
+	if srv.MaxHeaderBytes != 1024 {
+		t.Fatalf("expect max header bytes 1024. got %d", srv.MaxHeaderBytes)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	call := func(headerSize int) int {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/health", listener.Addr().String()), nil)
+		req.Header.Set("X-Bomb", strings.Repeat("a", headerSize))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := call(100); code != http.StatusOK {
+		t.Errorf("small headers should be accepted. got %d", code)
+	}
+	// the standard library allows 4096 bytes of slack on top of the limit.
+	if code := call(1024 + 8192); code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("oversized headers should be rejected. got %d", code)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	defer endpoint.SetDraining(false)
+	srv := newHTTPServer("", http.HandlerFunc(endpoint.Ready), time.Second, time.Second, time.Second)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	ready := func() *http.Response {
+		resp, err := http.Get(fmt.Sprintf("http://%s/ready", listener.Addr().String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := ready(); resp.StatusCode != http.StatusOK || resp.Close {
+		t.Fatalf("expect ready with keep-alive before shutdown. got %d, close %v", resp.StatusCode, resp.Close)
+	}
+
+	drained := make(chan bool)
+	go func() {
+		drain(srv, 500*time.Millisecond)
+		close(drained)
+	}()
+	for !endpoint.IsDraining() {
+		time.Sleep(time.Millisecond)
+	}
+	resp := ready()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("ready should be 503 at the start of shutdown. got %d", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Errorf("response should carry Connection: close while draining")
+	}
+	select {
+	case <-drained:
+		t.Errorf("drain should wait for the drain delay")
+	default:
+	}
+	<-drained
+}
+
+func TestGzipExcludesStreamingExports(t *testing.T) {
+	exclusions, err := gzipExclusions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := gzip.NewGzipEncoderFilter(true, 300)
+	filter.Exclusions = exclusions
+	handler := filter.DoFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(strings.Repeat("{\"kind\":\"user\"}\n", 100)))
+		w.(http.Flusher).Flush()
+	}))
+	for _, path := range endpoint.StreamingPaths() {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Header().Get("Content-Encoding") == "gzip" || !recorder.Flushed {
+			t.Errorf("%s should be streamed uncompressed", path)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/management/users", apiPrefix), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("other responses should still be compressed")
+	}
+}
+
 func TestAll(t *testing.T) {
 	logrus.SetLevel(logrus.TraceLevel)
 	/*
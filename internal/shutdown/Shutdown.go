@@ -0,0 +1,102 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	shutdownLog = log.WithField("go", "Shutdown")
+
+	// DefaultRegistry is the registry used by Register and Run
+	DefaultRegistry = NewRegistry()
+)
+
+// Hook is a named teardown callback of a component
+type Hook struct {
+	Name     string
+	Shutdown func(ctx context.Context) error
+}
+
+// NewRegistry create new instance of Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		hooks: make([]*Hook, 0),
+	}
+}
+
+// Registry keeps the shutdown hooks in their registration order
+type Registry struct {
+	mutex sync.Mutex
+	hooks []*Hook
+}
+
+// Register adds a shutdown hook. Hooks run in the reverse order of their registration,
+// so a component registered after its dependencies is shut down before them.
+func (reg *Registry) Register(name string, shutdown func(ctx context.Context) error) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.hooks = append(reg.hooks, &Hook{Name: name, Shutdown: shutdown})
+}
+
+// Run calls all hooks in LIFO order. Each hook is given at most hookTimeout, and never beyond the deadline of ctx.
+// A hook that fails or times out is logged and does not stop the remaining hooks.
+// It returns the errors of the failed hooks.
+func (reg *Registry) Run(ctx context.Context, hookTimeout time.Duration) []error {
+	fLog := shutdownLog.WithField("func", "Run")
+	reg.mutex.Lock()
+	hooks := make([]*Hook, len(reg.hooks))
+	copy(hooks, reg.hooks)
+	reg.mutex.Unlock()
+
+	errs := make([]error, 0)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if ctx.Err() != nil {
+			fLog.Warnf("Shutdown deadline reached, %s is skipped", hook.Name)
+			errs = append(errs, fmt.Errorf("shutdown of %s skipped. got %w", hook.Name, ctx.Err()))
+			continue
+		}
+		fLog.Infof("Shutting down %s", hook.Name)
+		start := time.Now()
+		if err := runHook(ctx, hook, hookTimeout); err != nil {
+			fLog.Errorf("Shutting down %s got %s", hook.Name, err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		fLog.Infof("%s is shut down in %s", hook.Name, time.Since(start).String())
+	}
+	return errs
+}
+
+func runHook(ctx context.Context, hook *Hook, hookTimeout time.Duration) error {
+	hookCtx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- hook.Shutdown(hookCtx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("shutdown of %s failed. got %w", hook.Name, err)
+		}
+		return nil
+	case <-hookCtx.Done():
+		return fmt.Errorf("shutdown of %s timed out. got %w", hook.Name, hookCtx.Err())
+	}
+}
+
+// Register adds a shutdown hook to the DefaultRegistry
+func Register(name string, shutdown func(ctx context.Context) error) {
+	DefaultRegistry.Register(name, shutdown)
+}
+
+// Run calls all hooks in the DefaultRegistry
+func Run(ctx context.Context, hookTimeout time.Duration) []error {
+	return DefaultRegistry.Run(ctx, hookTimeout)
+}
@@ -0,0 +1,84 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunOrder(t *testing.T) {
+	reg := NewRegistry()
+	order := make([]string, 0)
+	for _, name := range []string{"database", "mailer", "http server"} {
+		name := name
+		reg.Register(name, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+	reg.Register("metrics", func(ctx context.Context) error {
+		order = append(order, "metrics")
+		return fmt.Errorf("flush failed")
+	})
+
+	errs := reg.Run(context.Background(), time.Second)
+	if len(errs) != 1 {
+		t.Errorf("expect 1 failed hook. got %d", len(errs))
+	}
+	expect := []string{"metrics", "http server", "mailer", "database"}
+	if fmt.Sprint(order) != fmt.Sprint(expect) {
+		t.Errorf("expect hooks to run in LIFO order %v. got %v", expect, order)
+	}
+}
+
+type recorder struct {
+	mutex sync.Mutex
+	ran   []string
+}
+
+func (rec *recorder) record(name string) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.ran = append(rec.ran, name)
+}
+
+func (rec *recorder) get() []string {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return append([]string{}, rec.ran...)
+}
+
+func TestRunDeadline(t *testing.T) {
+	reg := NewRegistry()
+	rec := &recorder{}
+	reg.Register("first", func(ctx context.Context) error {
+		rec.record("first")
+		return nil
+	})
+	reg.Register("stuck", func(ctx context.Context) error {
+		rec.record("stuck")
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	// the stuck hook is cut by the hook timeout, the remaining hook still runs.
+	start := time.Now()
+	errs := reg.Run(context.Background(), 20*time.Millisecond)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("stuck hook should be cut by the hook timeout")
+	}
+	if ran := rec.get(); len(errs) != 1 || len(ran) != 2 || ran[1] != "first" {
+		t.Errorf("expect only the stuck hook to fail. got %v, ran %v", errs, ran)
+	}
+
+	// once the overall deadline passed, the remaining hooks are skipped.
+	rec = &recorder{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	errs = reg.Run(ctx, time.Second)
+	if ran := rec.get(); len(errs) != 2 || len(ran) != 1 {
+		t.Errorf("expect the hook after the deadline to be skipped. got %v, ran %v", errs, ran)
+	}
+}
@@ -0,0 +1,47 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the released version of the build, injected using
+	// -ldflags "-X github.com/hyperjumptech/hansip/internal/version.Version=..."
+	Version = "dev"
+	// Commit is the git commit the build is made from, injected the same way as Version
+	Commit = "unknown"
+	// BuildDate is the time of the build, injected the same way as Version
+	BuildDate = "unknown"
+)
+
+// BuildInfo describe the running build
+type BuildInfo struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit"`
+	BuildDate string            `json:"build_date"`
+	GoVersion string            `json:"go_version"`
+	Platform  string            `json:"platform"`
+	Module    string            `json:"module,omitempty"`
+	Deps      map[string]string `json:"dependencies,omitempty"`
+}
+
+// GetBuildInfo returns the build information, including the module dependencies when the binary embeds them.
+func GetBuildInfo() *BuildInfo {
+	info := &BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		info.Module = buildInfo.Main.Path
+		info.Deps = make(map[string]string, len(buildInfo.Deps))
+		for _, dep := range buildInfo.Deps {
+			info.Deps[dep.Path] = dep.Version
+		}
+	}
+	return info
+}
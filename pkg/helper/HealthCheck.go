@@ -52,7 +52,9 @@ func (hc *HealthCheck) String() string {
 		hc.Status = StatusPass
 	} else {
 		for _, v := range hc.Details {
-			if v.Status != StatusPass {
+			if v.Status == StatusFail {
+				hc.Status = StatusFail
+			} else if v.Status != StatusPass && hc.Status != StatusFail {
 				hc.Status = StatusWarn
 			}
 		}
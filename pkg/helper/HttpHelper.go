@@ -49,14 +49,18 @@ func ParsePathParams(template, path string) (map[string]string, error) {
 // WriteHTTPResponse into the response writer, according to the response code and headers.
 // headerMap and data argument are both optional
 func WriteHTTPResponse(ctx context.Context, w http.ResponseWriter, httpRespCode int, message string, headerMap map[string]string, data interface{}) {
-	w.Header().Add("Content-Type", "application/json")
+	mediaType := MediaTypeJSON
+	if ctx.Value(constants.ResponseMediaType) == MediaTypeXML {
+		mediaType = MediaTypeXML
+	}
+	w.Header().Add("Content-Type", mediaType)
 	if headerMap != nil {
 		for k, v := range headerMap {
 			w.Header().Add(k, v)
 		}
 	}
 	if ctx.Value(constants.RequestID) != nil {
-		w.Header().Add("X-Request-ID", ctx.Value(constants.RequestID).(string))
+		w.Header().Set("X-Request-ID", ctx.Value(constants.RequestID).(string))
 	}
 	w.WriteHeader(httpRespCode)
 	rJSON := &ResponseJSON{
@@ -76,7 +80,13 @@ func WriteHTTPResponse(ctx context.Context, w http.ResponseWriter, httpRespCode
 			rJSON.Message = "Operation Failed"
 		}
 	}
-	bytes, err := json.Marshal(rJSON)
+	var bytes []byte
+	var err error
+	if mediaType == MediaTypeXML {
+		bytes, err = MarshalXMLResponse(rJSON)
+	} else {
+		bytes, err = json.Marshal(rJSON)
+	}
 	if err != nil {
 		log.Errorf("Can not marshal. Got %s", err)
 	} else {
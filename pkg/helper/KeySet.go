@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultKeyID identifies the key tokens without a "kid" header are verified with,
+	// they were signed before the keyset was created.
+	DefaultKeyID = "default"
+)
+
+// SigningKey is a token signing key, identified by the "kid" header of the tokens it signs.
+type SigningKey struct {
+	ID  string `json:"kid"`
+	Key string `json:"key"`
+	// VerifyUntil is the time a retired key stops verifying tokens. It is zero for the current key.
+	VerifyUntil time.Time `json:"verify_until,omitempty"`
+}
+
+// KeySet holds the current signing key and the retired keys that still verify outstanding tokens.
+type KeySet struct {
+	Current string        `json:"current"`
+	Keys    []*SigningKey `json:"keys"`
+}
+
+// NewKeySet create new instance of KeySet whose current key is the signing key in use before the keyset.
+func NewKeySet(signKey string) *KeySet {
+	return &KeySet{
+		Current: DefaultKeyID,
+		Keys:    []*SigningKey{{ID: DefaultKeyID, Key: signKey}},
+	}
+}
+
+// LoadKeySet reads a keyset file written by Save.
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keySet := &KeySet{}
+	if err := json.Unmarshal(data, keySet); err != nil {
+		return nil, fmt.Errorf("invalid keyset %s. got %s", path, err.Error())
+	}
+	if keySet.CurrentKey() == nil {
+		return nil, fmt.Errorf("invalid keyset %s. current key %q not found", path, keySet.Current)
+	}
+	return keySet, nil
+}
+
+// Save writes the keyset file, readable only by its owner. The file is replaced at once,
+// so a server reading it never sees a partly written keyset.
+func (ks *KeySet) Save(path string) error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CurrentKey returns the key new tokens are signed with.
+func (ks *KeySet) CurrentKey() *SigningKey {
+	for _, key := range ks.Keys {
+		if key.ID == ks.Current {
+			return key
+		}
+	}
+	return nil
+}
+
+// VerificationKey returns the key of the kid, if it still verifies tokens at the time.
+// An empty kid is the DefaultKeyID.
+func (ks *KeySet) VerificationKey(kid string, at time.Time) *SigningKey {
+	if len(kid) == 0 {
+		kid = DefaultKeyID
+	}
+	for _, key := range ks.Keys {
+		if key.ID == kid && (key.VerifyUntil.IsZero() || at.Before(key.VerifyUntil)) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Rotate makes the new key current. The previous current key only verifies tokens for the overlap,
+// which must be as long as the longest token lifetime. Retired keys past their overlap are removed.
+func (ks *KeySet) Rotate(newKey *SigningKey, overlap time.Duration, now time.Time) {
+	keys := []*SigningKey{newKey}
+	for _, key := range ks.Keys {
+		if key.ID == newKey.ID {
+			continue
+		}
+		if key.ID == ks.Current {
+			key.VerifyUntil = now.Add(overlap)
+		}
+		if now.Before(key.VerifyUntil) {
+			keys = append(keys, key)
+		}
+	}
+	ks.Keys = keys
+	ks.Current = newKey.ID
+}
+
+// GenerateSigningKey generates a random HMAC signing key, identified by the time it is generated.
+func GenerateSigningKey(now time.Time) (*SigningKey, error) {
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:  now.UTC().Format("20060102T150405Z"),
+		Key: base64.RawURLEncoding.EncodeToString(secret),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeySetRotate(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	keySet := NewKeySet("previouskey")
+
+	keySet.Rotate(&SigningKey{ID: "k1", Key: "key1"}, time.Hour, now)
+	if keySet.CurrentKey().ID != "k1" {
+		t.Fatalf("expect k1 current. got %s", keySet.Current)
+	}
+	if key := keySet.VerificationKey("", now.Add(59*time.Minute)); key == nil || key.Key != "previouskey" {
+		t.Errorf("tokens without kid should verify with the previous key during the overlap. got %v", key)
+	}
+	if key := keySet.VerificationKey("", now.Add(time.Hour)); key != nil {
+		t.Errorf("previous key should not verify after the overlap. got %v", key)
+	}
+	if key := keySet.VerificationKey("k1", now.Add(365*24*time.Hour)); key == nil || key.Key != "key1" {
+		t.Errorf("current key should always verify. got %v", key)
+	}
+
+	keySet.Rotate(&SigningKey{ID: "k2", Key: "key2"}, time.Hour, now.Add(2*time.Hour))
+	if len(keySet.Keys) != 2 || keySet.Keys[0].ID != "k2" || keySet.Keys[1].ID != "k1" {
+		t.Errorf("expired previous key should be removed, expect k2 and k1. got %v", keySet.Keys)
+	}
+
+	path := filepath.Join(t.TempDir(), "keyset.json")
+	if err := keySet.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Current != "k2" || len(loaded.Keys) != 2 || !loaded.Keys[1].VerifyUntil.Equal(now.Add(3*time.Hour)) {
+		t.Errorf("loaded keyset should be the saved one. got %v", loaded.Keys)
+	}
+}
@@ -0,0 +1,177 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	// opaqueTokenBytes is the number of random bytes of an opaque token. The token string is its hex encoding.
+	opaqueTokenBytes = 32
+)
+
+// OpaqueTokenStore defines the server side storage of opaque reference token claims.
+// Tokens are stored and looked up by key, the SHA-256 of the token, so the stored tokens can not be used if the store leaks.
+type OpaqueTokenStore interface {
+	// SaveOpaqueToken stores the claims of a token under its key.
+	SaveOpaqueToken(ctx context.Context, key string, token *HansipToken) error
+
+	// GetOpaqueToken returns the claims of the token with the key, nil if the token is not in the store.
+	GetOpaqueToken(ctx context.Context, key string) (*HansipToken, error)
+
+	// DeleteOpaqueToken removes the token with the key from the store.
+	DeleteOpaqueToken(ctx context.Context, key string) error
+
+	// DeleteOpaqueTokensBySubject removes all tokens of a subject from the store.
+	DeleteOpaqueTokensBySubject(ctx context.Context, subject string) error
+
+	// PurgeOpaqueTokensBefore removes the tokens expired before the time from the store. Returns the number of tokens removed.
+	PurgeOpaqueTokensBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// opaqueTokenKey returns the key an opaque token is stored under, the hex encoded SHA-256 of the token.
+func opaqueTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewOpaqueTokenFactory create new instance of TokenFactory that issues opaque reference tokens.
+// The token claims are kept in the store and a token is valid only as long as it is in the store.
+func NewOpaqueTokenFactory(store OpaqueTokenStore, issuer string, accessTokenAge, refreshTokenAge time.Duration) *OpaqueTokenFactory {
+	if issuer == "" {
+		panic("empty issuer")
+	}
+	return &OpaqueTokenFactory{
+		Issuer:               issuer,
+		AccessTokenDuration:  accessTokenAge,
+		RefreshTokenDuration: refreshTokenAge,
+		Store:                store,
+	}
+}
+
+// OpaqueTokenFactory implementation of TokenFactory that issues random reference tokens instead of JWT.
+type OpaqueTokenFactory struct {
+	Issuer               string
+	AccessTokenDuration  time.Duration
+	RefreshTokenDuration time.Duration
+	NotBeforeOffset      time.Duration
+	Leeway               time.Duration
+	DurationResolver     TokenDurationResolver
+	AcceptedIssuers      []string
+	Store                OpaqueTokenStore
+}
+
+// IsAcceptedIssuer check whether tokens from the issuer are accepted by this factory.
+func (tf *OpaqueTokenFactory) IsAcceptedIssuer(issuer string) bool {
+	return isAcceptedIssuer(tf.Issuer, tf.AcceptedIssuers, issuer)
+}
+
+// CreateTokenPair create new Access and Refresh token pair, tuned by the options.
+func (tf *OpaqueTokenFactory) CreateTokenPair(subject string, audience []string, additional map[string]interface{}, opts TokenOptions) (string, string, error) {
+	accessTokenAge, refreshTokenAge := resolveTokenDurations(tf.DurationResolver, audience, tf.AccessTokenDuration, tf.RefreshTokenDuration)
+	if opts.RefreshTokenAge > 0 {
+		refreshTokenAge = opts.RefreshTokenAge
+	}
+	notBefore := opts.notBefore(tf.NotBeforeOffset)
+	access, err := tf.createToken(subject, audience, additional, "access", notBefore, notBefore.Add(accessTokenAge))
+	if err != nil {
+		return "", "", err
+	}
+	if opts.AccessOnly {
+		return access, "", nil
+	}
+	refresh, err := tf.createToken(subject, audience, additional, "refresh", notBefore, notBefore.Add(refreshTokenAge))
+	if err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// CreateAccessToken create a single Access token with a specific age, without any Refresh token
+func (tf *OpaqueTokenFactory) CreateAccessToken(subject string, audience []string, additional map[string]interface{}, age time.Duration) (string, error) {
+	notBefore := time.Now().Add(tf.NotBeforeOffset)
+	return tf.createToken(subject, audience, additional, "access", notBefore, notBefore.Add(age))
+}
+
+// ReadToken look up the token in the store and returns its claims.
+func (tf *OpaqueTokenFactory) ReadToken(token string) (*HansipToken, error) {
+	hToken, err := tf.Store.GetOpaqueToken(context.Background(), opaqueTokenKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if hToken == nil {
+		return nil, fmt.Errorf("unknown or revoked token")
+	}
+	hToken.Token = token
+	if time.Now().After(hToken.Expire.Add(tf.Leeway)) {
+		if err := tf.Store.DeleteOpaqueToken(context.Background(), opaqueTokenKey(token)); err != nil {
+			return hToken, err
+		}
+		return hToken, ErrTokenExpired
+	}
+	if time.Now().Before(hToken.NotBefore.Add(-tf.Leeway)) {
+		return hToken, fmt.Errorf("token not yet valid")
+	}
+	if !tf.IsAcceptedIssuer(hToken.Issuer) {
+		return hToken, fmt.Errorf("invalid issuer %s", hToken.Issuer)
+	}
+	return hToken, nil
+}
+
+// RefreshToken generate new Access token by specifying its refresh token
+func (tf *OpaqueTokenFactory) RefreshToken(refreshToken string) (string, error) {
+	hToken, err := tf.ReadToken(refreshToken)
+	if err != nil {
+		return "", err
+	}
+	if typ, ok := hToken.Additional["type"]; ok {
+		if typ != "refresh" {
+			return "", fmt.Errorf("not refresh token")
+		}
+	} else {
+		return "", fmt.Errorf("unknown token type")
+	}
+	accessTokenAge, _ := resolveTokenDurations(tf.DurationResolver, hToken.Audiences, tf.AccessTokenDuration, tf.RefreshTokenDuration)
+	return tf.createToken(hToken.Subject, hToken.Audiences, hToken.Additional, "access", hToken.NotBefore, time.Now().Add(accessTokenAge))
+}
+
+// RevokeToken immediately invalidates a single token.
+func (tf *OpaqueTokenFactory) RevokeToken(token string) error {
+	return tf.Store.DeleteOpaqueToken(context.Background(), opaqueTokenKey(token))
+}
+
+// RevokeSubject immediately invalidates all tokens issued to the subject.
+func (tf *OpaqueTokenFactory) RevokeSubject(subject string) error {
+	return tf.Store.DeleteOpaqueTokensBySubject(context.Background(), subject)
+}
+
+func (tf *OpaqueTokenFactory) createToken(subject string, audience []string, additional map[string]interface{}, tokenType string, notBefore, expire time.Time) (string, error) {
+	buff := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buff); err != nil {
+		return "", err
+	}
+	tokenAdditional := make(map[string]interface{})
+	for k, v := range additional {
+		tokenAdditional[k] = v
+	}
+	tokenAdditional["type"] = tokenType
+	hToken := &HansipToken{
+		Issuer:     tf.Issuer,
+		Subject:    subject,
+		Audiences:  audience,
+		Expire:     expire,
+		NotBefore:  notBefore,
+		IssuedAt:   time.Now(),
+		Additional: tokenAdditional,
+	}
+	// the token is not part of the stored claims, only its key
+	token := hex.EncodeToString(buff)
+	if err := tf.Store.SaveOpaqueToken(context.Background(), opaqueTokenKey(token), hToken); err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryOpaqueTokenStore keeps the claims as JSON, the same way the database store does.
+type memoryOpaqueTokenStore struct {
+	tokens map[string][]byte
+}
+
+func (store *memoryOpaqueTokenStore) SaveOpaqueToken(ctx context.Context, key string, token *HansipToken) error {
+	claims, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	store.tokens[key] = claims
+	return nil
+}
+
+func (store *memoryOpaqueTokenStore) GetOpaqueToken(ctx context.Context, key string) (*HansipToken, error) {
+	claims, ok := store.tokens[key]
+	if !ok {
+		return nil, nil
+	}
+	ret := &HansipToken{}
+	return ret, json.Unmarshal(claims, ret)
+}
+
+func (store *memoryOpaqueTokenStore) DeleteOpaqueToken(ctx context.Context, key string) error {
+	delete(store.tokens, key)
+	return nil
+}
+
+func (store *memoryOpaqueTokenStore) DeleteOpaqueTokensBySubject(ctx context.Context, subject string) error {
+	for token := range store.tokens {
+		if hToken, _ := store.GetOpaqueToken(ctx, token); hToken.Subject == subject {
+			delete(store.tokens, token)
+		}
+	}
+	return nil
+}
+
+func (store *memoryOpaqueTokenStore) PurgeOpaqueTokensBefore(ctx context.Context, before time.Time) (int64, error) {
+	purged := int64(0)
+	for key := range store.tokens {
+		if hToken, _ := store.GetOpaqueToken(ctx, key); hToken.Expire.Before(before) {
+			delete(store.tokens, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func TestOpaqueTokenFactory(t *testing.T) {
+	store := &memoryOpaqueTokenStore{tokens: make(map[string][]byte)}
+	tf := NewOpaqueTokenFactory(store, issuer, 5*time.Minute, time.Hour)
+
+	access, refresh, err := tf.CreateTokenPair(subject, audience, map[string]interface{}{"impersonator": "admin"}, TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(access) != 64 || access == refresh {
+		t.Errorf("expect 2 distinct 64 chars opaque tokens. got %s and %s", access, refresh)
+	}
+	if len(store.tokens) != 2 {
+		t.Errorf("expect 2 stored tokens. got %d", len(store.tokens))
+	}
+	for key, claims := range store.tokens {
+		if key == access || key == refresh || strings.Contains(string(claims), access) || strings.Contains(string(claims), refresh) {
+			t.Errorf("expect the store to hold neither token, only their hash. got %s %s", key, string(claims))
+		}
+	}
+	if _, ok := store.tokens[opaqueTokenKey(access)]; !ok {
+		t.Errorf("expect the access token stored under its SHA-256")
+	}
+
+	hToken, err := tf.ReadToken(access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hToken.Subject != subject || hToken.Issuer != issuer || len(hToken.Audiences) != 2 || hToken.Additional["type"] != "access" || hToken.Additional["impersonator"] != "admin" || hToken.Token != access {
+		t.Errorf("claims are not kept. got %v", hToken)
+	}
+
+	refreshed, err := tf.RefreshToken(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hToken, err := tf.ReadToken(refreshed); err != nil || hToken.Additional["type"] != "access" {
+		t.Errorf("refreshed token should be a valid access token")
+	}
+	if _, err := tf.RefreshToken(access); err == nil {
+		t.Errorf("access token should not be usable to refresh")
+	}
+
+	if _, err := tf.ReadToken("not-a-token"); err == nil {
+		t.Errorf("unknown token should be invalid")
+	}
+
+	expired, err := tf.CreateAccessToken(subject, audience, nil, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.ReadToken(expired); err == nil {
+		t.Errorf("expired token should be invalid")
+	}
+	if _, ok := store.tokens[opaqueTokenKey(expired)]; ok {
+		t.Errorf("expired token should be removed from the store")
+	}
+
+	if err := tf.RevokeToken(access); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.ReadToken(access); err == nil {
+		t.Errorf("revoked token should be invalid immediately")
+	}
+	if _, err := tf.ReadToken(refreshed); err != nil {
+		t.Errorf("other token should stay valid. got %s", err.Error())
+	}
+
+	if err := tf.RevokeSubject(subject); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.tokens) != 0 {
+		t.Errorf("all tokens of the subject should be revoked. %d left", len(store.tokens))
+	}
+}
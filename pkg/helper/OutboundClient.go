@@ -0,0 +1,45 @@
+package helper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NewOutboundClient create the HTTP client for calling external APIs. Requests go through the proxyURL if set,
+// otherwise through the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+// The certificates in the PEM caBundle file, if set, are trusted in addition to the system's, eg. the CA of
+// a TLS intercepting proxy. A zero timeout means no timeout.
+func NewOutboundClient(proxyURL, caBundle string, timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if len(proxyURL) > 0 {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %s. got %s", proxyURL, err.Error())
+		}
+		if len(proxy.Scheme) == 0 || len(proxy.Host) == 0 {
+			return nil, fmt.Errorf("invalid proxy url %s. scheme and host are required", proxyURL)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if len(caBundle) > 0 {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("can not read CA bundle %s. got %s", caBundle, err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s has no PEM certificate", caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewOutboundClientProxy(t *testing.T) {
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a forward proxy receives the absolute url of the target
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer proxy.Close()
+
+	client, err := NewOutboundClient(proxy.URL, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://api.sendgrid.invalid/v3/mail/send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || proxied != "http://api.sendgrid.invalid/v3/mail/send" {
+		t.Errorf("expect the request sent through the proxy. got %d %s", resp.StatusCode, proxied)
+	}
+
+	if _, err := NewOutboundClient("proxy.local:3128", "", 0); err == nil {
+		t.Errorf("proxy url without scheme should be rejected")
+	}
+}
+
+func TestNewOutboundClientCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewOutboundClient("", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("certificate of an unknown CA should not be trusted")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	err = ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewOutboundClient("", bundle, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("certificate of the CA bundle should be trusted. got %s", err.Error())
+	}
+	resp.Body.Close()
+
+	if _, err := NewOutboundClient("", filepath.Join(os.TempDir(), "no-such-bundle.pem"), 0); err == nil {
+		t.Errorf("missing CA bundle should be rejected")
+	}
+}
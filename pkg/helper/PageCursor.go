@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidPageCursor is returned when a cursor is malformed or its signature does not match, eg. it was altered by the client
+	ErrInvalidPageCursor = errors.New("invalid page cursor")
+)
+
+// PageCursor is the position of a keyset paginated list, the sort key of the last item listed.
+// The next page lists the items after that key, so items inserted or deleted meanwhile do not shift the page.
+// The sort key must be unique, eg. the user email.
+type PageCursor struct {
+	OrderBy string `json:"o"`
+	Sort    string `json:"s"`
+	Key     string `json:"k"`
+}
+
+// pageCursorSignature signs the cursor payload with a key derived from the secret,
+// so the secret shared with the token signing is never used as is.
+func pageCursorSignature(secret string, payload []byte) []byte {
+	keyMac := hmac.New(sha256.New, []byte(secret))
+	keyMac.Write([]byte("hansip page cursor"))
+	mac := hmac.New(sha256.New, keyMac.Sum(nil))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// EncodePageCursor encodes the cursor into an opaque token, the base64 payload and its HMAC-SHA256 signature separated by a dot.
+func EncodePageCursor(secret string, cursor *PageCursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(pageCursorSignature(secret, payload)), nil
+}
+
+// DecodePageCursor decodes the token made by EncodePageCursor with the same secret.
+// It returns ErrInvalidPageCursor if the token is malformed or was not signed with the secret.
+func DecodePageCursor(secret, token string) (*PageCursor, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPageCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidPageCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, pageCursorSignature(secret, payload)) {
+		return nil, ErrInvalidPageCursor
+	}
+	cursor := &PageCursor{}
+	if err := json.Unmarshal(payload, cursor); err != nil {
+		return nil, ErrInvalidPageCursor
+	}
+	return cursor, nil
+}
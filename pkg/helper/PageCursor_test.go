@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestPageCursor(t *testing.T) {
+	cursor := &PageCursor{OrderBy: "EMAIL", Sort: "ASC", Key: "bob@acme.com"}
+	token, err := EncodePageCursor("secret", cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePageCursor("secret", token)
+	if err != nil || *decoded != *cursor {
+		t.Fatalf("expect the cursor decoded. got %+v %v", decoded, err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"o":"EMAIL","s":"ASC","k":"zed@acme.com"}`)) + "." + parts[1]
+	for _, tampered := range []string{
+		forged,
+		parts[0] + "." + parts[1][1:],
+		parts[0],
+		token + ".x",
+		"not a cursor",
+		"",
+	} {
+		if _, err := DecodePageCursor("secret", tampered); err != ErrInvalidPageCursor {
+			t.Errorf("expect %q rejected. got %v", tampered, err)
+		}
+	}
+	if _, err := DecodePageCursor("other secret", token); err != ErrInvalidPageCursor {
+		t.Errorf("expect a cursor signed with another secret rejected. got %v", err)
+	}
+}
@@ -114,25 +114,43 @@ func NewPage(pageRequest *PageRequest, totalItems uint) *Page {
 	return page
 }
 
+// NewCursorPage create a page structure of a keyset paginated list. The page has no number,
+// the items listed after the cursor and whether there are more after them is all that is known.
+func NewCursorPage(pageRequest *PageRequest, totalItems, items uint, hasNext bool) *Page {
+	return &Page{
+		Sort:       pageRequest.Sort,
+		PageSize:   pageRequest.PageSize,
+		OrderBy:    pageRequest.OrderBy,
+		TotalItems: totalItems,
+		Items:      items,
+		HasNext:    hasNext,
+		HasPrev:    true,
+		IsLast:     !hasNext,
+		OffsetEnd:  items,
+	}
+}
+
 // Page a meta data for listing that contains pagination structure
 type Page struct {
-	No          uint   `json:"no"`
-	TotalPages  uint   `json:"total_pages"`
-	PageSize    uint   `json:"page_size"`
-	Items       uint   `json:"items"`
-	TotalItems  uint   `json:"total_items"`
-	HasNext     bool   `json:"has_next"`
-	HasPrev     bool   `json:"has_prev"`
-	IsFirst     bool   `json:"is_first"`
-	IsLast      bool   `json:"is_last"`
-	FistPage    uint   `json:"fist_page"`
-	NextPage    uint   `json:"next_page"`
-	PrevPage    uint   `json:"prev_page"`
-	LastPage    uint   `json:"last_page"`
-	OrderBy     string `json:"order_by"`
-	OffsetStart uint   `json:"-"`
-	OffsetEnd   uint   `json:"-"`
-	Sort        string `json:"sort"`
+	No          uint   `json:"no" xml:"no"`
+	TotalPages  uint   `json:"total_pages" xml:"total_pages"`
+	PageSize    uint   `json:"page_size" xml:"page_size"`
+	Items       uint   `json:"items" xml:"items"`
+	TotalItems  uint   `json:"total_items" xml:"total_items"`
+	HasNext     bool   `json:"has_next" xml:"has_next"`
+	HasPrev     bool   `json:"has_prev" xml:"has_prev"`
+	IsFirst     bool   `json:"is_first" xml:"is_first"`
+	IsLast      bool   `json:"is_last" xml:"is_last"`
+	FistPage    uint   `json:"fist_page" xml:"fist_page"`
+	NextPage    uint   `json:"next_page" xml:"next_page"`
+	PrevPage    uint   `json:"prev_page" xml:"prev_page"`
+	LastPage    uint   `json:"last_page" xml:"last_page"`
+	OrderBy     string `json:"order_by" xml:"order_by"`
+	OffsetStart uint   `json:"-" xml:"-"`
+	OffsetEnd   uint   `json:"-" xml:"-"`
+	Sort        string `json:"sort" xml:"sort"`
+	// NextCursor is the cursor of the next page in keyset pagination, empty if there is no next page or the list is not keyset paginated
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
 }
 
 // PageRequest define a list query specification in paginated fashion.
@@ -141,4 +159,6 @@ type PageRequest struct {
 	PageSize uint   `json:"page_size"`
 	OrderBy  string `json:"order_by"`
 	Sort     string `json:"sort"`
+	// Cursor lists the page after the cursor position instead of page No, nil for offset pagination
+	Cursor *PageCursor `json:"-"`
 }
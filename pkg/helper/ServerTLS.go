@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// ClientAuthNone does not ask the client for a certificate
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies the client certificate if the client sends one
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire rejects the connection of a client without a valid certificate
+	ClientAuthRequire = "require"
+)
+
+// NewServerTLSConfig create the TLS configuration of the server for the clientAuth mode, ClientAuthNone, ClientAuthOptional
+// or ClientAuthRequire. Client certificates are verified against the CA certificates in the PEM clientCA file,
+// which is required unless the mode is ClientAuthNone.
+func NewServerTLSConfig(clientAuth, clientCA string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch clientAuth {
+	case "", ClientAuthNone:
+		return tlsConfig, nil
+	case ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth %q. allowed values are none, optional or require", clientAuth)
+	}
+	if len(clientCA) == 0 {
+		return nil, fmt.Errorf("client auth %s requires a client CA", clientAuth)
+	}
+	pem, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("can not read client CA %s. got %s", clientCA, err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA %s has no PEM certificate", clientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	return tlsConfig, nil
+}
+
+// tlsVersions are the TLS protocol versions by their configuration name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses the minimum TLS version name, "1.0", "1.1", "1.2" or "1.3". An empty name is TLS 1.2.
+func ParseTLSVersion(name string) (uint16, error) {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "TLS")
+	if len(name) == 0 {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[strings.TrimSpace(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q. allowed values are 1.0, 1.1, 1.2 or 1.3", name)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses the comma separated cipher suite names, eg. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+// Only the cipher suites Go considers secure are allowed. An empty list returns nil, leaving the choice to Go's
+// defaults. The suites only apply to TLS 1.2 and below, TLS 1.3 suites are not configurable.
+func ParseCipherSuites(names string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	var ret []uint16
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) == 0 {
+			continue
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
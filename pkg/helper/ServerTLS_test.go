@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	testData := []struct {
+		Name    string
+		Version uint16
+		Valid   bool
+	}{
+		{"", tls.VersionTLS12, true},
+		{"1.1", tls.VersionTLS11, true},
+		{"1.2", tls.VersionTLS12, true},
+		{"TLS1.3", tls.VersionTLS13, true},
+		{"1.4", 0, false},
+		{"SSLv3", 0, false},
+	}
+	for _, td := range testData {
+		version, err := ParseTLSVersion(td.Name)
+		if (err == nil) != td.Valid || version != td.Version {
+			t.Errorf("%q expect version %x valid %v. got %x %v", td.Name, td.Version, td.Valid, version, err)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	if err != nil || len(suites) != 2 || suites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("expect the 2 cipher suites. got %v %v", suites, err)
+	}
+	if suites, err := ParseCipherSuites(""); err != nil || suites != nil {
+		t.Errorf("expect no cipher suites. got %v %v", suites, err)
+	}
+	for _, invalid := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_NOT_A_SUITE"} {
+		if _, err := ParseCipherSuites(invalid); err == nil {
+			t.Errorf("expect %s rejected", invalid)
+		}
+	}
+}
+
+func TestServerTLSMinVersion(t *testing.T) {
+	tlsConfig, err := NewServerTLSConfig(ClientAuthNone, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.MinVersion, _ = ParseTLSVersion("1.2")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	call := func(maxVersion uint16) error {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS10
+		transport.TLSClientConfig.MaxVersion = maxVersion
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err := call(tls.VersionTLS11); err == nil {
+		t.Errorf("expect a TLS 1.1 client rejected")
+	}
+	if err := call(tls.VersionTLS12); err != nil {
+		t.Errorf("expect a TLS 1.2 client accepted. got %s", err.Error())
+	}
+}
@@ -0,0 +1,114 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jweHeader is the protected header of the JWE tokens. The signed JWT is encrypted directly with the shared key
+// ("dir") using AES-256-GCM, the "cty" tells the payload is a nested JWT.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Cty string `json:"cty"`
+}
+
+// DeriveTokenEncryptionKey derives the 256 bit JWE content encryption key from the secret,
+// so a secret shared with the signing key is never used as is for both.
+func DeriveTokenEncryptionKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("hansip token encryption"))
+	return mac.Sum(nil)
+}
+
+// IsJWE check whether the token is in the JWE compact serialization, five dot separated parts, instead of a signed JWT's three.
+func IsJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// EncryptJWT encrypts the signed JWT into a JWE compact serialization with the 32 bytes key.
+func EncryptJWT(key []byte, jwt string) (string, error) {
+	aead, err := tokenCipher(key)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(&jweHeader{Alg: "dir", Enc: "A256GCM", Cty: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(header)
+	iv := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, iv, []byte(jwt), []byte(protected))
+	cipherText, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+	// the encrypted key part is empty with direct encryption
+	return strings.Join([]string{
+		protected,
+		"",
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(cipherText),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptJWT decrypts the JWE compact serialization with the 32 bytes key and returns the signed JWT inside.
+// The JWT signature still has to be validated.
+func DecryptJWT(key []byte, jwe string) (string, error) {
+	parts := strings.Split(jwe, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("malformed jwe token")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwe token header")
+	}
+	header := &jweHeader{}
+	if err := json.Unmarshal(headerBytes, header); err != nil {
+		return "", fmt.Errorf("malformed jwe token header")
+	}
+	if header.Alg != "dir" || header.Enc != "A256GCM" || len(parts[1]) > 0 {
+		return "", fmt.Errorf("unsupported jwe token algorithm %s %s", header.Alg, header.Enc)
+	}
+	aead, err := tokenCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(iv) != aead.NonceSize() {
+		return "", fmt.Errorf("malformed jwe token iv")
+	}
+	cipherText, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwe token ciphertext")
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil || len(tag) != aead.Overhead() {
+		return "", fmt.Errorf("malformed jwe token tag")
+	}
+	plain, err := aead.Open(nil, iv, append(cipherText, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("invalid jwe token - can not decrypt")
+	}
+	return string(plain), nil
+}
+
+func tokenCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("token encryption key must be 32 bytes. got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
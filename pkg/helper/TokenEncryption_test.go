@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryptedTokenFactory(t *testing.T) {
+	tf := NewTokenFactory(signKey, signMethod, issuer, time.Hour, 24*time.Hour).(*DefaultTokenFactory)
+	tf.EncryptKey = DeriveTokenEncryptionKey("thisisatestencryptkey")
+
+	// signed only token issued before encryption is enabled
+	signed, _, err := tf.CreateTokenPair(subject, audience, map[string]interface{}{"secret": "claim"}, TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsJWE(signed) {
+		t.Fatalf("expect a signed only token when Encrypt is off")
+	}
+
+	tf.Encrypt = true
+	access, refresh, err := tf.CreateTokenPair(subject, audience, map[string]interface{}{"secret": "claim"}, TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsJWE(access) || !IsJWE(refresh) {
+		t.Fatalf("expect JWE tokens. got %s", access)
+	}
+	for _, part := range strings.Split(access, ".") {
+		decoded, _ := base64.RawURLEncoding.DecodeString(part)
+		if strings.Contains(string(decoded), "claim") || strings.Contains(string(decoded), subject) {
+			t.Fatalf("expect the claims not readable from the JWE")
+		}
+	}
+
+	for _, tok := range []string{access, signed} {
+		read, err := tf.ReadToken(tok)
+		if err != nil {
+			t.Fatalf("expect the token valid. got %s", err.Error())
+		}
+		if read.Subject != subject || read.Additional["secret"] != "claim" || read.Token != tok {
+			t.Errorf("expect the claims of the token. got %+v", read)
+		}
+	}
+
+	refreshed, err := tf.RefreshToken(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := tf.ReadToken(refreshed); err != nil || !IsJWE(refreshed) || read.Additional["type"] != "access" {
+		t.Errorf("expect the refreshed token encrypted. got %v", err)
+	}
+
+	// tampered ciphertext
+	parts := strings.Split(access, ".")
+	cipherText, _ := base64.RawURLEncoding.DecodeString(parts[3])
+	cipherText[0] ^= 1
+	parts[3] = base64.RawURLEncoding.EncodeToString(cipherText)
+	if _, err := tf.ReadToken(strings.Join(parts, ".")); err == nil {
+		t.Errorf("expect tampered ciphertext rejected")
+	}
+	// tampered protected header
+	parts = strings.Split(access, ".")
+	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A256GCM","cty":"jwt"}`))
+	if _, err := tf.ReadToken(strings.Join(parts, ".")); err == nil {
+		t.Errorf("expect tampered header rejected")
+	}
+
+	other := NewTokenFactory(signKey, signMethod, issuer, time.Hour, 24*time.Hour).(*DefaultTokenFactory)
+	if _, err := other.ReadToken(access); err == nil {
+		t.Errorf("expect JWE rejected without encryption key")
+	}
+	other.EncryptKey = DeriveTokenEncryptionKey("anotherencryptkey")
+	if _, err := other.ReadToken(access); err == nil {
+		t.Errorf("expect JWE rejected with another encryption key")
+	}
+}
+
+func TestDecryptJWT(t *testing.T) {
+	key := DeriveTokenEncryptionKey("thisisatestencryptkey")
+	jwe, err := EncryptJWT(key, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := DecryptJWT(key, jwe)
+	if err != nil || jwt != token {
+		t.Errorf("expect the signed token back. got %s %v", jwt, err)
+	}
+	if _, err := EncryptJWT([]byte("short"), token); err == nil {
+		t.Errorf("expect error on a key that is not 32 bytes")
+	}
+	if _, err := DecryptJWT(key, "a.b.c.d.e"); err == nil {
+		t.Errorf("expect error on malformed jwe")
+	}
+}
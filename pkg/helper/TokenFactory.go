@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"strings"
@@ -9,8 +10,13 @@ import (
 
 	"github.com/SermoDigital/jose/crypto"
 	"github.com/SermoDigital/jose/jws"
+	josejwt "github.com/SermoDigital/jose/jwt"
 )
 
+// ErrTokenExpired is wrapped in the error of reading a token past its expiry. The token returned along with it
+// holds the claims of the expired token, they are genuine as the signature is verified before the expiry.
+var ErrTokenExpired = errors.New("token is expired")
+
 type HansipToken struct {
 	Issuer     string
 	Subject    string
@@ -24,13 +30,46 @@ type HansipToken struct {
 
 // TokenFactory defines a token factory function to implement
 type TokenFactory interface {
-	CreateTokenPair(subject string, audience []string, additional map[string]interface{}) (string, string, error)
+	CreateTokenPair(subject string, audience []string, additional map[string]interface{}, opts TokenOptions) (string, string, error)
 	ReadToken(token string) (*HansipToken, error)
 	RefreshToken(refreshToken string) (string, error)
+	CreateAccessToken(subject string, audience []string, additional map[string]interface{}, age time.Duration) (string, error)
+	IsAcceptedIssuer(issuer string) bool
+}
+
+// TokenOptions tune the token pair created by CreateTokenPair, the zero value creates a pair valid right away
+// with the token lifetimes of the audience.
+type TokenOptions struct {
+	// Delay postpones the validity of the pair, on top of the NotBeforeOffset. The token lifetimes start when they become valid.
+	Delay time.Duration
+	// RefreshTokenAge is the Refresh token lifetime, zero uses the Refresh token lifetime of the audience.
+	RefreshTokenAge time.Duration
+	// Immediate makes the pair valid right away regardless of the NotBeforeOffset, eg. the token replacing one already in use.
+	Immediate bool
+	// AccessOnly creates only the Access token, the returned Refresh token is empty.
+	AccessOnly bool
+}
+
+// notBefore returns when the pair created with the options becomes valid.
+func (opts TokenOptions) notBefore(notBeforeOffset time.Duration) time.Time {
+	if opts.Immediate {
+		notBeforeOffset = 0
+	}
+	return time.Now().Add(notBeforeOffset + opts.Delay)
 }
 
+// TokenDurationResolver returns the access and refresh token lifetime for a token issued to the audience.
+// A zero duration means the audience has no specific lifetime and the factory's default is used.
+type TokenDurationResolver func(audience []string) (accessTokenAge, refreshTokenAge time.Duration)
+
 // NewTokenFactory create new instance of TokenFactory
 func NewTokenFactory(signKey, signMethod, issuer string, accessTokenAge, refreshTokenAge time.Duration) TokenFactory {
+	return NewTokenFactoryWithNotBefore(signKey, signMethod, issuer, accessTokenAge, refreshTokenAge, 0, 0)
+}
+
+// NewTokenFactoryWithNotBefore create new instance of TokenFactory whose tokens only become valid after the notBeforeOffset.
+// The leeway tolerates clock skew between the issuer and the validator when checking the token's expiry and not before time.
+func NewTokenFactoryWithNotBefore(signKey, signMethod, issuer string, accessTokenAge, refreshTokenAge, notBeforeOffset, leeway time.Duration) TokenFactory {
 	if issuer == "" {
 		panic("empty issuer")
 	}
@@ -38,6 +77,8 @@ func NewTokenFactory(signKey, signMethod, issuer string, accessTokenAge, refresh
 		Issuer:               issuer,
 		AccessTokenDuration:  accessTokenAge,
 		RefreshTokenDuration: refreshTokenAge,
+		NotBeforeOffset:      notBeforeOffset,
+		Leeway:               leeway,
 		SignKey:              signKey,
 		SignMethod:           signMethod,
 	}
@@ -49,12 +90,139 @@ type DefaultTokenFactory struct {
 	Issuer               string
 	AccessTokenDuration  time.Duration
 	RefreshTokenDuration time.Duration
+	NotBeforeOffset      time.Duration
+	Leeway               time.Duration
+	DurationResolver     TokenDurationResolver
+	AcceptedIssuers      []string
 	SignKey              string
 	SignMethod           string
+	// Minimize is the TokenMinimizeNone, TokenMinimizeClaims or TokenMinimizeCompress mode the claims of new tokens are minimized with.
+	// Minimized tokens are always expanded when read, whatever the mode.
+	Minimize string
+	// KeySet, if set, replaces the SignKey. Tokens are signed with its current key and verified with the key of their "kid" header.
+	KeySet *KeySet
+	// KeySetLoader reloads the KeySet when a token is signed with a key not in it, the key may have been rotated in by another instance.
+	KeySetLoader func() (*KeySet, error)
+	// Encrypt issues the signed tokens encrypted into JWE with the EncryptKey, so their claims can not be read by the clients.
+	Encrypt bool
+	// EncryptKey is the 32 bytes key JWE tokens are encrypted and decrypted with. JWE tokens are always decrypted when read,
+	// whether Encrypt is set or not, and signed only tokens are always accepted, so Encrypt can be toggled without logging users out.
+	EncryptKey     []byte
+	keyMutex       sync.RWMutex
+	keySetLoadedAt time.Time
+}
+
+// SetKeySet replaces the KeySet, eg. after the keyset file is reloaded.
+func (tf *DefaultTokenFactory) SetKeySet(keySet *KeySet) {
+	tf.keyMutex.Lock()
+	defer tf.keyMutex.Unlock()
+	tf.KeySet = keySet
+	tf.keySetLoadedAt = time.Now()
+}
+
+// ReloadKeySet loads the KeySet again with the KeySetLoader.
+func (tf *DefaultTokenFactory) ReloadKeySet() error {
+	if tf.KeySetLoader == nil {
+		return nil
+	}
+	keySet, err := tf.KeySetLoader()
+	if err != nil {
+		return err
+	}
+	tf.SetKeySet(keySet)
+	return nil
+}
+
+// signingKey returns the key and the key id new tokens are signed with.
+func (tf *DefaultTokenFactory) signingKey() (string, string) {
+	tf.keyMutex.RLock()
+	defer tf.keyMutex.RUnlock()
+	if tf.KeySet == nil {
+		return tf.SignKey, ""
+	}
+	key := tf.KeySet.CurrentKey()
+	return key.Key, key.ID
 }
 
-// CreateTokenPair create new Access and Refresh token pair
-func (tf *DefaultTokenFactory) CreateTokenPair(subject string, audience []string, additional map[string]interface{}) (string, string, error) {
+// verificationKey returns the key a token with the key id is verified with.
+func (tf *DefaultTokenFactory) verificationKey(kid string) (string, error) {
+	tf.keyMutex.RLock()
+	keySet, loadedAt := tf.KeySet, tf.keySetLoadedAt
+	tf.keyMutex.RUnlock()
+	if keySet == nil && (len(kid) == 0 || tf.KeySetLoader == nil) {
+		return tf.SignKey, nil
+	}
+	var key *SigningKey
+	if keySet != nil {
+		key = keySet.VerificationKey(kid, time.Now())
+	}
+	// reloading is throttled, so tokens with made up key ids do not cause a reload each.
+	if key == nil && tf.KeySetLoader != nil && time.Since(loadedAt) > time.Second {
+		if reloaded, err := tf.KeySetLoader(); err != nil {
+			logrus.Errorf("reloading token keyset got %s", err.Error())
+		} else {
+			tf.SetKeySet(reloaded)
+			key = reloaded.VerificationKey(kid, time.Now())
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("invalid jwt token - unknown signing key %q", kid)
+	}
+	return key.Key, nil
+}
+
+// createToken creates a JWT token signed with the key, its claims minimized according to the Minimize mode.
+func (tf *DefaultTokenFactory) createToken(signKey, keyID, subject string, audience []string, issuedAt, notBefore, expiration time.Time, additional map[string]interface{}) (string, error) {
+	audience, additional, err := MinimizeClaims(tf.Minimize, audience, additional)
+	if err != nil {
+		return "", err
+	}
+	token, err := CreateJWTStringTokenWithKeyID(signKey, keyID, tf.SignMethod, tf.Issuer, subject, audience, issuedAt, notBefore, expiration, additional)
+	if err != nil || !tf.Encrypt {
+		return token, err
+	}
+	return EncryptJWT(tf.EncryptKey, token)
+}
+
+// tokenDurations returns the access and refresh token lifetime for the audience,
+// falling back to AccessTokenDuration and RefreshTokenDuration.
+func (tf *DefaultTokenFactory) tokenDurations(audience []string) (time.Duration, time.Duration) {
+	return resolveTokenDurations(tf.DurationResolver, audience, tf.AccessTokenDuration, tf.RefreshTokenDuration)
+}
+
+// IsAcceptedIssuer check whether tokens from the issuer are accepted by this factory.
+func (tf *DefaultTokenFactory) IsAcceptedIssuer(issuer string) bool {
+	return isAcceptedIssuer(tf.Issuer, tf.AcceptedIssuers, issuer)
+}
+
+func isAcceptedIssuer(primary string, accepted []string, issuer string) bool {
+	if issuer == primary {
+		return true
+	}
+	for _, acceptedIssuer := range accepted {
+		if issuer == acceptedIssuer {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveTokenDurations(resolver TokenDurationResolver, audience []string, accessTokenAge, refreshTokenAge time.Duration) (time.Duration, time.Duration) {
+	if resolver == nil {
+		return accessTokenAge, refreshTokenAge
+	}
+	access, refresh := resolver(audience)
+	if access <= 0 {
+		access = accessTokenAge
+	}
+	if refresh <= 0 {
+		refresh = refreshTokenAge
+	}
+	return access, refresh
+}
+
+// CreateTokenPair create new Access and Refresh token pair, tuned by the options.
+func (tf *DefaultTokenFactory) CreateTokenPair(subject string, audience []string, additional map[string]interface{}, opts TokenOptions) (string, string, error) {
 	tf.mutex.Lock()
 	defer tf.mutex.Unlock()
 	accessAdditional := make(map[string]interface{})
@@ -68,20 +236,66 @@ func (tf *DefaultTokenFactory) CreateTokenPair(subject string, audience []string
 	accessAdditional["type"] = "access"
 	refreshAdditional["type"] = "refresh"
 
-	access, err := CreateJWTStringToken(tf.SignKey, tf.SignMethod, tf.Issuer, subject, audience, time.Now(), time.Now(), time.Now().Add(tf.AccessTokenDuration), accessAdditional)
+	accessTokenAge, refreshTokenAge := tf.tokenDurations(audience)
+	if opts.RefreshTokenAge > 0 {
+		refreshTokenAge = opts.RefreshTokenAge
+	}
+	notBefore := opts.notBefore(tf.NotBeforeOffset)
+	signKey, keyID := tf.signingKey()
+	access, err := tf.createToken(signKey, keyID, subject, audience, time.Now(), notBefore, notBefore.Add(accessTokenAge), accessAdditional)
 	if err != nil {
 		return "", "", err
 	}
-	refresh, err := CreateJWTStringToken(tf.SignKey, tf.SignMethod, tf.Issuer, subject, audience, time.Now(), time.Now(), time.Now().Add(tf.RefreshTokenDuration), refreshAdditional)
+	if opts.AccessOnly {
+		return access, "", nil
+	}
+	refresh, err := tf.createToken(signKey, keyID, subject, audience, time.Now(), notBefore, notBefore.Add(refreshTokenAge), refreshAdditional)
 	if err != nil {
 		return "", "", err
 	}
 	return access, refresh, nil
 }
 
+// CreateAccessToken create a single Access token with a specific age, without any Refresh token
+func (tf *DefaultTokenFactory) CreateAccessToken(subject string, audience []string, additional map[string]interface{}, age time.Duration) (string, error) {
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+	accessAdditional := make(map[string]interface{})
+	for k, v := range additional {
+		accessAdditional[k] = v
+	}
+	accessAdditional["type"] = "access"
+	notBefore := time.Now().Add(tf.NotBeforeOffset)
+	signKey, keyID := tf.signingKey()
+	return tf.createToken(signKey, keyID, subject, audience, time.Now(), notBefore, notBefore.Add(age), accessAdditional)
+}
+
 // ReadToken read a token string, validate and extract its content.
 func (tf *DefaultTokenFactory) ReadToken(token string) (*HansipToken, error) {
-	issuer, subject, audience, issuedAt, notBefore, expire, additional, err := ReadJWTStringToken(true, tf.SignKey, tf.SignMethod, token)
+	jwt := token
+	if IsJWE(token) {
+		if tf.EncryptKey == nil {
+			return &HansipToken{Token: token}, fmt.Errorf("invalid jwt token - encrypted token is not accepted")
+		}
+		decrypted, err := DecryptJWT(tf.EncryptKey, token)
+		if err != nil {
+			return &HansipToken{Token: token}, err
+		}
+		jwt = decrypted
+	}
+	signKey, err := tf.verificationKey(JWTKeyID(jwt))
+	if err != nil {
+		return &HansipToken{Token: token}, err
+	}
+	issuer, subject, audience, issuedAt, notBefore, expire, additional, err := ReadJWTStringTokenWithLeeway(true, signKey, tf.SignMethod, jwt, tf.Leeway)
+	// the claims of an expired token are verified too, they are expanded so its type and subject can be told
+	if err == nil || errors.Is(err, ErrTokenExpired) {
+		var expandErr error
+		audience, additional, expandErr = ExpandClaims(audience, additional)
+		if expandErr != nil {
+			err = expandErr
+		}
+	}
 	htoken := &HansipToken{
 		Issuer:     issuer,
 		Subject:    subject,
@@ -92,7 +306,7 @@ func (tf *DefaultTokenFactory) ReadToken(token string) (*HansipToken, error) {
 		Additional: additional,
 		Token:      token,
 	}
-	if issuer != tf.Issuer {
+	if !tf.IsAcceptedIssuer(issuer) {
 		return htoken, fmt.Errorf("invalid issuer %s", issuer)
 	}
 	return htoken, err
@@ -106,7 +320,7 @@ func (tf *DefaultTokenFactory) RefreshToken(refreshToken string) (string, error)
 	if err != nil {
 		return "", err
 	}
-	if hToken.Issuer != tf.Issuer {
+	if !tf.IsAcceptedIssuer(hToken.Issuer) {
 		return "", fmt.Errorf("invalid issuer")
 	}
 	if typ, ok := hToken.Additional["type"]; ok {
@@ -117,7 +331,9 @@ func (tf *DefaultTokenFactory) RefreshToken(refreshToken string) (string, error)
 		return "", fmt.Errorf("unknown token type")
 	}
 	hToken.Additional["type"] = "access"
-	access, err := CreateJWTStringToken(tf.SignKey, tf.SignMethod, tf.Issuer, hToken.Subject, hToken.Audiences, hToken.IssuedAt, hToken.NotBefore, time.Now().Add(tf.AccessTokenDuration), hToken.Additional)
+	accessTokenAge, _ := tf.tokenDurations(hToken.Audiences)
+	signKey, keyID := tf.signingKey()
+	access, err := tf.createToken(signKey, keyID, hToken.Subject, hToken.Audiences, hToken.IssuedAt, hToken.NotBefore, time.Now().Add(accessTokenAge), hToken.Additional)
 	if err != nil {
 		return "", err
 	}
@@ -126,6 +342,11 @@ func (tf *DefaultTokenFactory) RefreshToken(refreshToken string) (string, error)
 
 // ReadJWTStringToken takes a token string , keys, signMethod and returns its content.
 func ReadJWTStringToken(validate bool, signKey, signMethod, tokenString string) (string, string, []string, time.Time, time.Time, time.Time, map[string]interface{}, error) {
+	return ReadJWTStringTokenWithLeeway(validate, signKey, signMethod, tokenString, 0)
+}
+
+// ReadJWTStringTokenWithLeeway is ReadJWTStringToken that tolerates the leeway of clock skew when validating the token's expiry and not before time.
+func ReadJWTStringTokenWithLeeway(validate bool, signKey, signMethod, tokenString string, leeway time.Duration) (string, string, []string, time.Time, time.Time, time.Time, map[string]interface{}, error) {
 	if signKey == "th15mustb3CH@ngedINprodUCT10N" {
 		logrus.Warnf("Using default CryptKey for JWT Token, This key is visible from the source tree and to be used in development only. YOU MUST CHANGE THIS IN PRODUCTION or TO REMOVE THIS LOG FROM APPEARING")
 	}
@@ -135,6 +356,7 @@ func ReadJWTStringToken(validate bool, signKey, signMethod, tokenString string)
 		return "", "", nil, time.Now(), time.Now(), time.Now(), nil, fmt.Errorf("malformed jwt token")
 	}
 
+	var validateErr error
 	if validate {
 		var sMethod crypto.SigningMethod
 
@@ -149,7 +371,9 @@ func ReadJWTStringToken(validate bool, signKey, signMethod, tokenString string)
 			sMethod = crypto.SigningMethodHS256
 		}
 
-		if err := jwt.Validate([]byte(signKey), sMethod); err != nil {
+		if err := jwt.Validate([]byte(signKey), sMethod, &josejwt.Validator{EXP: leeway, NBF: leeway}); err == josejwt.ErrTokenIsExpired {
+			validateErr = fmt.Errorf("invalid jwt token - %w", ErrTokenExpired)
+		} else if err != nil {
 			return "", "", nil, time.Now(), time.Now(), time.Now(), nil, fmt.Errorf("invalid jwt token - %s", err.Error())
 		}
 	}
@@ -169,11 +393,26 @@ func ReadJWTStringToken(validate bool, signKey, signMethod, tokenString string)
 	notBefore, _ := claims.NotBefore()
 	issuedAt, _ := claims.IssuedAt()
 
-	return issuer, subject, audience, issuedAt, notBefore, expire, additional, nil
+	return issuer, subject, audience, issuedAt, notBefore, expire, additional, validateErr
+}
+
+// JWTKeyID returns the "kid" header of a JWT token string, empty if it has none or the token is malformed.
+func JWTKeyID(tokenString string) string {
+	jwt, err := jws.ParseJWT([]byte(tokenString))
+	if err != nil {
+		return ""
+	}
+	kid, _ := jwt.(jws.JWS).Protected().Get("kid").(string)
+	return kid
 }
 
 // CreateJWTStringToken create JWT String token based on arguments
 func CreateJWTStringToken(signKey, signMethod, issuer, subject string, audience []string, issuedAt, notBefore, expiration time.Time, additional map[string]interface{}) (string, error) {
+	return CreateJWTStringTokenWithKeyID(signKey, "", signMethod, issuer, subject, audience, issuedAt, notBefore, expiration, additional)
+}
+
+// CreateJWTStringTokenWithKeyID is CreateJWTStringToken that sets the "kid" header to the key id, if not empty.
+func CreateJWTStringTokenWithKeyID(signKey, keyID, signMethod, issuer, subject string, audience []string, issuedAt, notBefore, expiration time.Time, additional map[string]interface{}) (string, error) {
 	if signKey == "th15mustb3CH@ngedINprodUCT10N" {
 		logrus.Warnf("Using default CryptKey for JWT Token, This key is visible from the source tree and to be used in development only. YOU MUST CHANGE THIS IN PRODUCTION or TO REMOVE THIS LOG FROM APPEARING")
 	}
@@ -204,6 +443,9 @@ func CreateJWTStringToken(signKey, signMethod, issuer, subject string, audience
 	}
 
 	jwtBytes := jws.NewJWT(claims, signM)
+	if len(keyID) > 0 {
+		jwtBytes.(jws.JWS).Protected().Set("kid", keyID)
+	}
 
 	tokenByte, err := jwtBytes.Serialize([]byte(signKey))
 	if err != nil {
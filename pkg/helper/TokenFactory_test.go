@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -63,3 +64,109 @@ func TestReadJWTStringToken(t *testing.T) {
 		t.Errorf("expect type %s but %s", additional["type"], add["type"])
 	}
 }
+
+func TestReadExpiredToken(t *testing.T) {
+	tf := NewTokenFactory(signKey, signMethod, issuer, 5*time.Minute, time.Hour)
+	expired, _ := CreateJWTStringToken(signKey, signMethod, issuer, subject, audience, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour), time.Now().Add(-time.Minute), additional)
+	hToken, err := tf.ReadToken(expired)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expect the token expired. got %v", err)
+	}
+	if hToken.Subject != subject || hToken.Additional["type"] != "access" {
+		t.Errorf("expect the claims of the expired token. got %+v", hToken)
+	}
+
+	forged, _ := CreateJWTStringToken("anotherkey", signMethod, issuer, subject, audience, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour), time.Now().Add(-time.Minute), additional)
+	if _, err := tf.ReadToken(forged); err == nil || errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expired token of another key should be invalid, not expired. got %v", err)
+	}
+}
+
+func TestNotBefore(t *testing.T) {
+	tf := NewTokenFactoryWithNotBefore(signKey, signMethod, issuer, 5*time.Minute, time.Hour, 0, 0)
+
+	access, _, err := tf.CreateTokenPair(subject, audience, nil, TokenOptions{Delay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.ReadToken(access); err == nil {
+		t.Errorf("token presented before its nbf should be invalid")
+	}
+
+	// presented after its nbf
+	past, _ := CreateJWTStringToken(signKey, signMethod, issuer, subject, audience, time.Now(), time.Now().Add(-2*time.Second), time.Now().Add(time.Minute), additional)
+	if _, err := tf.ReadToken(past); err != nil {
+		t.Errorf("token presented after its nbf should be valid. got %s", err.Error())
+	}
+
+	// nbf slightly ahead is tolerated within the clock skew leeway
+	ahead, _ := CreateJWTStringToken(signKey, signMethod, issuer, subject, audience, time.Now(), time.Now().Add(30*time.Second), time.Now().Add(time.Minute), additional)
+	if _, err := tf.ReadToken(ahead); err == nil {
+		t.Errorf("token with nbf ahead should be invalid without leeway")
+	}
+	lenient := NewTokenFactoryWithNotBefore(signKey, signMethod, issuer, 5*time.Minute, time.Hour, 0, time.Minute)
+	if _, err := lenient.ReadToken(ahead); err != nil {
+		t.Errorf("token with nbf within the leeway should be valid. got %s", err.Error())
+	}
+
+	// global offset
+	delayed := NewTokenFactoryWithNotBefore(signKey, signMethod, issuer, 5*time.Minute, time.Hour, time.Hour, 0)
+	access, _, err = delayed.CreateTokenPair(subject, audience, nil, TokenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := delayed.ReadToken(access); err == nil {
+		t.Errorf("token issued with not before offset should not be valid yet")
+	}
+	_, _, _, _, nbf, exp, _, _ := ReadJWTStringToken(false, signKey, signMethod, access)
+	if exp.Sub(nbf) != 5*time.Minute || time.Until(nbf) < 59*time.Minute {
+		t.Errorf("token lifetime should start at its nbf an hour from now. got nbf %s exp %s", nbf, exp)
+	}
+}
+
+func TestAcceptedIssuers(t *testing.T) {
+	tf := NewTokenFactory(signKey, signMethod, "new.issuer", 5*time.Minute, time.Hour)
+	tf.(*DefaultTokenFactory).AcceptedIssuers = []string{"old.issuer", "older.issuer"}
+
+	for _, iss := range []string{"new.issuer", "old.issuer", "older.issuer"} {
+		tok, _ := CreateJWTStringToken(signKey, signMethod, iss, subject, audience, time.Now(), time.Now(), time.Now().Add(time.Minute), additional)
+		if _, err := tf.ReadToken(tok); err != nil {
+			t.Errorf("token from accepted issuer %s should be valid. got %s", iss, err.Error())
+		}
+	}
+	unknown, _ := CreateJWTStringToken(signKey, signMethod, "unknown.issuer", subject, audience, time.Now(), time.Now(), time.Now().Add(time.Minute), additional)
+	if _, err := tf.ReadToken(unknown); err == nil {
+		t.Errorf("token from unknown issuer should be invalid")
+	}
+
+	// a refresh token from an old issuer is exchanged for an access token of the primary issuer
+	refresh, _ := CreateJWTStringToken(signKey, signMethod, "old.issuer", subject, audience, time.Now(), time.Now(), time.Now().Add(time.Hour), map[string]interface{}{"type": "refresh"})
+	access, err := tf.RefreshToken(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iss, _, _, _, _, _, _, _ := ReadJWTStringToken(false, signKey, signMethod, access); iss != "new.issuer" {
+		t.Errorf("refreshed token should be issued by new.issuer. got %s", iss)
+	}
+}
+
+func TestCreateTokenPairRefreshTokenAge(t *testing.T) {
+	tf := NewTokenFactory(signKey, signMethod, issuer, 5*time.Minute, 24*time.Hour)
+	refreshExpiry := func(refreshTokenAge time.Duration) time.Duration {
+		_, refresh, err := tf.CreateTokenPair(subject, audience, nil, TokenOptions{RefreshTokenAge: refreshTokenAge})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := tf.ReadToken(refresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Until(tok.Expire).Round(time.Minute)
+	}
+	if age := refreshExpiry(0); age != 24*time.Hour {
+		t.Errorf("zero refresh token age should use the default. got %s", age)
+	}
+	if age := refreshExpiry(30 * 24 * time.Hour); age != 30*24*time.Hour {
+		t.Errorf("expect refresh token age of 30 days. got %s", age)
+	}
+}
@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// TokenMinimizeNone keeps the token claims as they are.
+	TokenMinimizeNone = "none"
+	// TokenMinimizeClaims renames the well known claims to short names.
+	TokenMinimizeClaims = "claims"
+	// TokenMinimizeCompress moves the audience and all the additional claims into a single deflated claim.
+	TokenMinimizeCompress = "compress"
+
+	// minimizedClaim marks a token whose claims are minimized, its value is the minimize mode.
+	minimizedClaim = "min"
+	// compressedClaim holds the deflated and base64 encoded audience and additional claims.
+	compressedClaim = "zip"
+)
+
+// shortClaimNames maps the claims hansip puts into its tokens to their short names.
+var shortClaimNames = map[string]string{
+	"type":         "t",
+	"permissions":  "prm",
+	"impersonator": "imp",
+	"region":       "rgn",
+	"purpose":      "pur",
+	"new_email":    "nem",
+	"user_rec_id":  "uid",
+}
+
+// IsTokenMinimizeMode check whether the mode is one of TokenMinimizeNone, TokenMinimizeClaims or TokenMinimizeCompress.
+func IsTokenMinimizeMode(mode string) bool {
+	return mode == TokenMinimizeNone || mode == TokenMinimizeClaims || mode == TokenMinimizeCompress
+}
+
+// MinimizeClaims returns the audience and additional claims to put into a token minimized according to the mode.
+// An empty or TokenMinimizeNone mode returns them as they are.
+func MinimizeClaims(mode string, audience []string, additional map[string]interface{}) ([]string, map[string]interface{}, error) {
+	switch mode {
+	case "", TokenMinimizeNone:
+		return audience, additional, nil
+	case TokenMinimizeClaims:
+		minimized := make(map[string]interface{}, len(additional)+1)
+		for k, v := range additional {
+			if short, ok := shortClaimNames[k]; ok {
+				k = short
+			}
+			minimized[k] = v
+		}
+		minimized[minimizedClaim] = TokenMinimizeClaims
+		return audience, minimized, nil
+	case TokenMinimizeCompress:
+		payload := make(map[string]interface{}, len(additional)+1)
+		for k, v := range additional {
+			payload[k] = v
+		}
+		payload["aud"] = audience
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		var buff bytes.Buffer
+		writer, err := flate.NewWriter(&buff, flate.BestCompression)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := writer.Write(raw); err != nil {
+			return nil, nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, nil, err
+		}
+		return []string{}, map[string]interface{}{
+			minimizedClaim:  TokenMinimizeCompress,
+			compressedClaim: base64.RawURLEncoding.EncodeToString(buff.Bytes()),
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown token minimize mode %q", mode)
+	}
+}
+
+// ExpandClaims restores the audience and additional claims of a token minimized by MinimizeClaims.
+// Claims of a token that is not minimized are returned as they are, so tokens issued before the mode changed stay valid.
+func ExpandClaims(audience []string, additional map[string]interface{}) ([]string, map[string]interface{}, error) {
+	switch additional[minimizedClaim] {
+	case TokenMinimizeClaims:
+		longClaimNames := make(map[string]string, len(shortClaimNames))
+		for long, short := range shortClaimNames {
+			longClaimNames[short] = long
+		}
+		expanded := make(map[string]interface{}, len(additional))
+		for k, v := range additional {
+			if k == minimizedClaim {
+				continue
+			}
+			if long, ok := longClaimNames[k]; ok {
+				k = long
+			}
+			expanded[k] = v
+		}
+		return audience, expanded, nil
+	case TokenMinimizeCompress:
+		encoded, _ := additional[compressedClaim].(string)
+		compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed compressed claims")
+		}
+		raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed compressed claims")
+		}
+		expanded := make(map[string]interface{})
+		if err := json.Unmarshal(raw, &expanded); err != nil {
+			return nil, nil, fmt.Errorf("malformed compressed claims")
+		}
+		expandedAudience := make([]string, 0)
+		if auds, ok := expanded["aud"].([]interface{}); ok {
+			for _, aud := range auds {
+				if s, ok := aud.(string); ok {
+					expandedAudience = append(expandedAudience, s)
+				}
+			}
+		}
+		delete(expanded, "aud")
+		return expandedAudience, expanded, nil
+	default:
+		return audience, additional, nil
+	}
+}
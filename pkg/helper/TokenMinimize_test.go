@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenMinimize(t *testing.T) {
+	roles := make([]string, 0, 50)
+	permissions := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		roles = append(roles, fmt.Sprintf("role-%d@tenant.acme.com", i))
+		permissions = append(permissions, fmt.Sprintf("document:read:%d", i))
+	}
+	claims := map[string]interface{}{
+		"permissions":  permissions,
+		"impersonator": "admin@acme.com",
+		"region":       "eu-west",
+	}
+	read := func(mode string) (int, *HansipToken) {
+		tf := NewTokenFactory(signKey, signMethod, issuer, time.Minute, time.Hour).(*DefaultTokenFactory)
+		tf.Minimize = mode
+		access, _, err := tf.CreateTokenPair(subject, roles, claims, TokenOptions{})
+		if err != nil {
+			t.Fatalf("mode %s create got %s", mode, err.Error())
+		}
+		// reading does not depend on the mode the token was created with.
+		tf.Minimize = TokenMinimizeNone
+		tok, err := tf.ReadToken(access)
+		if err != nil {
+			t.Fatalf("mode %s read got %s", mode, err.Error())
+		}
+		return len(access), tok
+	}
+	plainSize, plain := read(TokenMinimizeNone)
+	if !reflect.DeepEqual(plain.Audiences, roles) {
+		t.Errorf("expect audience %v. got %v", roles, plain.Audiences)
+	}
+	for _, mode := range []string{TokenMinimizeClaims, TokenMinimizeCompress} {
+		size, tok := read(mode)
+		if size >= plainSize {
+			t.Errorf("mode %s token is %d bytes, expect smaller than %d bytes", mode, size, plainSize)
+		}
+		if !reflect.DeepEqual(tok.Audiences, plain.Audiences) {
+			t.Errorf("mode %s expect audience %v. got %v", mode, plain.Audiences, tok.Audiences)
+		}
+		if !reflect.DeepEqual(tok.Additional, plain.Additional) {
+			t.Errorf("mode %s expect claims %v. got %v", mode, plain.Additional, tok.Additional)
+		}
+	}
+	if _, _, err := MinimizeClaims("zip", roles, claims); err == nil {
+		t.Errorf("unknown mode should fail")
+	}
+}
+
+func TestTokenMinimizeRefresh(t *testing.T) {
+	tf := NewTokenFactory(signKey, signMethod, issuer, time.Minute, time.Hour).(*DefaultTokenFactory)
+	tf.Minimize = TokenMinimizeCompress
+	_, refresh, err := tf.CreateTokenPair(subject, audience, map[string]interface{}{"region": "eu-west"}, TokenOptions{})
+	if err != nil {
+		t.Fatalf("got %s", err.Error())
+	}
+	access, err := tf.RefreshToken(refresh)
+	if err != nil {
+		t.Fatalf("got %s", err.Error())
+	}
+	tok, err := tf.ReadToken(access)
+	if err != nil {
+		t.Fatalf("got %s", err.Error())
+	}
+	if tok.Additional["type"] != "access" || tok.Additional["region"] != "eu-west" || !reflect.DeepEqual(tok.Audiences, audience) {
+		t.Errorf("refreshed token lost its claims. got %v %v", tok.Audiences, tok.Additional)
+	}
+}
+
+func TestReadExpiredMinimizedToken(t *testing.T) {
+	tf := NewTokenFactory(signKey, signMethod, issuer, time.Minute, time.Hour).(*DefaultTokenFactory)
+	for _, mode := range []string{TokenMinimizeClaims, TokenMinimizeCompress} {
+		tf.Minimize = mode
+		key, keyID := tf.signingKey()
+		expired, err := tf.createToken(key, keyID, subject, audience, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour), time.Now().Add(-time.Minute), map[string]interface{}{"type": "access"})
+		if err != nil {
+			t.Fatalf("mode %s create got %s", mode, err.Error())
+		}
+		tok, err := tf.ReadToken(expired)
+		if !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("mode %s expect the token expired. got %v", mode, err)
+		}
+		if tok.Additional["type"] != "access" || !reflect.DeepEqual(tok.Audiences, audience) {
+			t.Errorf("mode %s expect the expanded claims of the expired token. got %v %v", mode, tok.Audiences, tok.Additional)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package helper
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperjumptech/hansip/internal/constants"
+)
+
+const (
+	// MediaTypeJSON is the default media type of request and response bodies
+	MediaTypeJSON = "application/json"
+	// MediaTypeXML is the media type of XML request and response bodies, for clients that negotiate it
+	MediaTypeXML = "application/xml"
+)
+
+// xmlResponse is ResponseJSON in XML. Data is written by the xmlValue marshaller, as encoding/xml can not marshal maps.
+type xmlResponse struct {
+	XMLName  xml.Name `xml:"response"`
+	HTTPCode int      `xml:"httpcode"`
+	Message  string   `xml:"message"`
+	Status   string   `xml:"status"`
+	Data     *xmlValue
+}
+
+// xmlValue writes any value into XML. Map keys and struct fields, named by their xml or else json tag,
+// become elements. Each element of a list becomes an element named by the XMLName of the list element
+// struct, or "item".
+type xmlValue struct {
+	value interface{}
+}
+
+// MarshalXML implements xml.Marshaler
+func (v *xmlValue) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "data"}
+	return encodeXMLValue(e, start, reflect.ValueOf(v.value))
+}
+
+var (
+	xmlMarshalerType  = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	xmlNameType       = reflect.TypeOf(xml.Name{})
+)
+
+func encodeXMLValue(e *xml.Encoder, start xml.StartElement, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Implements(xmlMarshalerType) || v.Type().Implements(textMarshalerType) {
+			return e.EncodeElement(v.Interface(), start)
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(xmlMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return e.EncodeElement(v.Interface(), start)
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("can not marshal map with %s key into xml", v.Type().Key())
+		}
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			if err := encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: key.String()}}, v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.EncodeElement(v.Interface(), start)
+		}
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		itemName := xmlItemName(v.Type().Elem())
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: itemName}}, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Struct:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" || field.Type == xmlNameType {
+				continue
+			}
+			name, omitEmpty := xmlFieldName(field)
+			if len(name) == 0 || (omitEmpty && v.Field(i).IsZero()) {
+				continue
+			}
+			if err := encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: name}}, v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Func, reflect.Chan:
+		return nil
+	default:
+		return e.EncodeElement(v.Interface(), start)
+	}
+}
+
+// xmlFieldName returns the element name of the struct field, from its xml tag or else its json tag.
+// An empty name means the field is not written.
+func xmlFieldName(field reflect.StructField) (string, bool) {
+	tag, ok := field.Tag.Lookup("xml")
+	if !ok {
+		tag, ok = field.Tag.Lookup("json")
+	}
+	if !ok {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	if parts[0] == "-" {
+		return "", false
+	}
+	name := parts[0]
+	if len(name) == 0 {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
+
+// xmlItemName returns the XMLName of the list element struct, or "item".
+func xmlItemName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		if field, ok := t.FieldByName("XMLName"); ok && field.Type == xmlNameType {
+			if name := strings.Split(field.Tag.Get("xml"), ",")[0]; len(name) > 0 {
+				return name
+			}
+		}
+	}
+	return "item"
+}
+
+// MarshalXMLResponse marshals the response into XML, the same structure as its JSON.
+func MarshalXMLResponse(rJSON *ResponseJSON) ([]byte, error) {
+	resp := &xmlResponse{
+		HTTPCode: rJSON.HTTPCode,
+		Message:  rJSON.Message,
+		Status:   rJSON.Status,
+	}
+	if rJSON.Data != nil {
+		resp.Data = &xmlValue{value: rJSON.Data}
+	}
+	body, err := xml.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// NegotiateMediaType returns the offered media type most preferred by the Accept header.
+// The first offered media type is the default, when the header is empty or accepts none of them.
+func NegotiateMediaType(accept string, offered ...string) string {
+	best, bestQuality := offered[0], -1.0
+	for _, offer := range offered {
+		quality := acceptQuality(accept, offer)
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	if bestQuality <= 0 {
+		return offered[0]
+	}
+	return best
+}
+
+// acceptQuality returns the quality the Accept header gives to the media type, by its most specific media range.
+// A media type not accepted has quality 0, except when there is no Accept header at all.
+func acceptQuality(accept, mediaType string) float64 {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return 1
+	}
+	quality, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		rangeSpecificity := 0
+		switch {
+		case rangeType == mediaType:
+			rangeSpecificity = 2
+		case strings.HasSuffix(rangeType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rangeType, "*")):
+			rangeSpecificity = 1
+		case rangeType == "*/*":
+		default:
+			continue
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+		q := 1.0
+		if qValue, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qValue, 64); err != nil {
+				continue
+			}
+		}
+		quality, specificity = q, rangeSpecificity
+	}
+	return quality
+}
+
+// UnmarshalRequestBody decodes the request body into v, from XML if the request negotiated an XML body, otherwise from JSON.
+func UnmarshalRequestBody(ctx context.Context, body []byte, v interface{}) error {
+	if ctx.Value(constants.RequestMediaType) == MediaTypeXML {
+		return xml.Unmarshal(body, v)
+	}
+	return json.Unmarshal(body, v)
+}
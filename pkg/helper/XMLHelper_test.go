@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNegotiateMediaType(t *testing.T) {
+	testData := []struct {
+		accept string
+		expect string
+	}{
+		{"", MediaTypeJSON},
+		{"*/*", MediaTypeJSON},
+		{"application/xml", MediaTypeXML},
+		{"application/*", MediaTypeJSON},
+		{"application/json;q=0.5, application/xml", MediaTypeXML},
+		{"application/xml;q=0.5, application/json", MediaTypeJSON},
+		{"application/xml;q=0.9, */*;q=0.1", MediaTypeXML},
+		{"text/html", MediaTypeJSON},
+	}
+	for _, td := range testData {
+		if mediaType := NegotiateMediaType(td.accept, MediaTypeJSON, MediaTypeXML); mediaType != td.expect {
+			t.Errorf("Accept %q expect %s but %s", td.accept, td.expect, mediaType)
+		}
+	}
+}
+
+func TestMarshalXMLResponse(t *testing.T) {
+	data := map[string]interface{}{
+		"page":  &Page{No: 1, TotalItems: 2, OffsetEnd: 9},
+		"names": []string{"a & b", "c"},
+		"since": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	body, err := MarshalXMLResponse(&ResponseJSON{HTTPCode: 200, Message: "ok", Status: "SUCCESS", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><httpcode>200</httpcode><message>ok</message><status>SUCCESS</status><data>` +
+		`<names><item>a &amp; b</item><item>c</item></names>` +
+		`<page><no>1</no><total_pages>0</total_pages><page_size>0</page_size><items>0</items><total_items>2</total_items>` +
+		`<has_next>false</has_next><has_prev>false</has_prev><is_first>false</is_first><is_last>false</is_last>` +
+		`<fist_page>0</fist_page><next_page>0</next_page><prev_page>0</prev_page><last_page>0</last_page>` +
+		`<order_by></order_by><sort></sort></page>` +
+		`<since>2020-01-02T03:04:05Z</since></data></response>`
+	if string(body) != expect {
+		t.Errorf("expect\n%s\nbut\n%s", expect, string(body))
+	}
+
+	body, err = MarshalXMLResponse(&ResponseJSON{HTTPCode: 404, Message: "not found", Status: "FAIL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<response><httpcode>404</httpcode><message>not found</message><status>FAIL</status></response>` {
+		t.Errorf("response without data got %s", string(body))
+	}
+}